@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/pricing"
+	"github.com/mule-ai/proxy/pkg/usage"
+)
+
+// exportBilling writes a month of saved usage to stdout for the finance system
+func exportBilling(args []string) error {
+	fs := flag.NewFlagSet("export-billing", flag.ContinueOnError)
+	configPath := fs.String("config", "config.json", "Path to the proxy config")
+	month := fs.String("month", time.Now().UTC().Format("2006-01"), "Billing month (YYYY-MM)")
+	format := fs.String("format", "csv", "Output format: csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if cfg.Billing.UsageFile == "" {
+		return fmt.Errorf("billing.usage_file is not configured")
+	}
+
+	ledger := usage.NewLedger(pricing.NewTable(cfg.Pricing), cfg.Billing.BillPreemptedAttempts)
+	if err := ledger.Load(cfg.Billing.UsageFile); err != nil {
+		return err
+	}
+
+	entries := ledger.Report(*month)
+	switch *format {
+	case "csv":
+		return usage.WriteCSV(os.Stdout, entries)
+	case "json":
+		return usage.WriteJSON(os.Stdout, entries)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+// saveUsage persists the ledger every minute until ctx is cancelled
+func saveUsage(ctx context.Context, ledger *usage.Ledger, path string) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := ledger.Save(path); err != nil {
+				log.Printf("Error saving usage: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/usage"
+)
+
+// TestExportBilling tests exporting saved usage as CSV
+func TestExportBilling(t *testing.T) {
+	dir := t.TempDir()
+	usageFile := filepath.Join(dir, "usage.json")
+
+	ledger := usage.NewLedger(nil, false)
+	ledger.Record(usage.Record{Time: time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC), KeyID: "key-a", Model: "gpt-4", InputTokens: 10})
+	if err := ledger.Save(usageFile); err != nil {
+		t.Fatalf("Failed to save usage: %v", err)
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	configData := `{"billing": {"usage_file": "` + usageFile + `"}}`
+	if err := os.WriteFile(configPath, []byte(configData), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	// Capture stdout
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	err := exportBilling([]string{"-config", configPath, "-month", "2026-10"})
+	w.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	out, _ := io.ReadAll(r)
+	if !strings.Contains(string(out), "2026-10,key-a,gpt-4,1,1,0,10,10,0,") {
+		t.Errorf("Unexpected export output: %s", out)
+	}
+}
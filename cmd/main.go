@@ -1,29 +1,199 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"google.golang.org/grpc"
+
+	"github.com/mule-ai/proxy/pkg/accesslog"
+	"github.com/mule-ai/proxy/pkg/archive"
 	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/grpcapi"
 	"github.com/mule-ai/proxy/pkg/metrics"
 	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/pricing"
 	"github.com/mule-ai/proxy/pkg/proxy"
+	"github.com/mule-ai/proxy/pkg/quota"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+	"github.com/mule-ai/proxy/pkg/secrets"
+	"github.com/mule-ai/proxy/pkg/tracing"
+	"github.com/mule-ai/proxy/pkg/usage"
 )
 
 func main() {
-	// Load configuration
-	cfg, err := config.LoadConfig("config.json")
+	if len(os.Args) > 1 && os.Args[1] == "export-billing" {
+		if err := exportBilling(os.Args[2:]); err != nil {
+			log.Fatalf("Billing export failed: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		if err := simulate(os.Args[2:]); err != nil {
+			log.Fatalf("Simulation failed: %v", err)
+		}
+		return
+	}
+	failFast := flag.Bool("fail-fast", false, "Exit if a pre-flight check fails instead of waiting for it to pass")
+	flag.Parse()
+
+	// Say which build is running first, for matching logs to its behavior
+	build := proxy.ReadBuildInfo()
+	log.Printf("openai-proxy %s (commit %s, built %s, %s)", build.Version,
+		cmp.Or(build.Revision, "unknown"), cmp.Or(build.BuiltAt, "unknown"), build.GoVersion)
+
+	// Load configuration, from where PROXY_CONFIG says when it is mounted elsewhere
+	configPath := "config.json"
+	if path := os.Getenv("PROXY_CONFIG"); path != "" {
+		configPath = path
+	}
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	// Keep the config as read, before secrets are filled in, to compare reloads with
+	loaded := *cfg
+
+	// Export request traces to the configured collector
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Invalid tracing: %v", err)
+	}
+
+	// Fetch credentials from the secrets manager instead of the config file
+	var secretStore *secrets.Store
+	if cfg.Secrets.Enabled() {
+		secretStore, err = newSecretStore(cfg.Secrets)
+		if err != nil {
+			log.Fatalf("Invalid secrets manager: %v", err)
+		}
+		if cfg.Secrets.OpenAIAPIKey != "" {
+			if cfg.OpenAIAPIKey, err = secretStore.Load(context.Background(), cfg.Secrets.OpenAIAPIKey); err != nil {
+				log.Fatalf("Failed to load OpenAI API key: %v", err)
+			}
+		}
+		if cfg.Secrets.InfluxToken != "" {
+			if cfg.InfluxToken, err = secretStore.Load(context.Background(), cfg.Secrets.InfluxToken); err != nil {
+				log.Fatalf("Failed to load Influx token: %v", err)
+			}
+		}
+	}
+
+	if cfg.OpenAIAPIKeyFile != "" {
+		if cfg.OpenAIAPIKey, err = openai.ReadKeyFile(cfg.OpenAIAPIKeyFile); err != nil {
+			log.Fatalf("Failed to load OpenAI API key: %v", err)
+		}
+	}
+
+	// All upstreams share one key ring, so rotating the key through the admin
+	// API, the key file or the secrets manager switches every client at once
+	upstreamKeys := openai.NewKeyRing(cfg.OpenAIAPIKey, time.Duration(cfg.KeyRotationGrace)*time.Second)
+	if secretStore != nil && cfg.Secrets.OpenAIAPIKey != "" {
+		secretStore.OnChange(cfg.Secrets.OpenAIAPIKey, func(key string) { upstreamKeys.Rotate(key, 0) })
+	}
+
+	// newUpstream creates a client for one upstream, whose usage is tracked against its provider's quotas
+	backendUsage := proxy.NewBackendUsage(cfg.BackendQuotas)
+	newUpstream := func(url string) proxy.OpenAIClient {
+		identity := cfg.UpstreamIdentity.ForBackend(url)
+		opts := []openai.Option{
+			openai.WithRetryPolicy(retryPolicy(cfg.UpstreamRetry.ForBackend(url))),
+			openai.WithKeyRing(upstreamKeys),
+			openai.WithUserAgent(identity.UserAgent),
+		}
+		for key, value := range identity.Headers {
+			opts = append(opts, openai.WithDefaultHeader(key, value))
+		}
+		if conns := cfg.UpstreamConnections; conns.MaxAge > 0 || conns.ResolveInterval > 0 {
+			opts = append(opts, openai.WithConnectionRecycling(
+				time.Duration(max(conns.MaxAge, 0))*time.Second,
+				time.Duration(max(conns.ResolveInterval, 0))*time.Second))
+		}
+		return backendUsage.Track(url, openai.NewClient(url, cfg.OpenAIAPIKey, opts...))
+	}
+
+	// Initialize OpenAI client, pinning conversations or clients to one replica when there are several
+	openaiClient := newUpstream(cfg.OpenAIAPIURL)
+	if len(cfg.UpstreamReplicas) > 0 {
+		replicas := make([]proxy.OpenAIClient, 0, len(cfg.UpstreamReplicas))
+		for _, url := range cfg.UpstreamReplicas {
+			replicas = append(replicas, newUpstream(url))
+		}
+		affinity := proxy.NewAffinityClient(replicas...)
+		switch cfg.ReplicaBalancing {
+		case "", proxy.BalanceSession, proxy.BalanceKey:
+			affinity.Policy = cfg.ReplicaBalancing
+		default:
+			log.Fatalf("Unknown replica_balancing %q", cfg.ReplicaBalancing)
+		}
+		if cfg.ReplicaLoadFactor != 0 && cfg.ReplicaLoadFactor < 1 {
+			log.Fatalf("replica_load_factor must be at least 1")
+		}
+		affinity.LoadFactor = cfg.ReplicaLoadFactor
+		openaiClient = affinity
+	}
 
-	// Initialize OpenAI client
-	openaiClient := openai.NewClient(cfg.OpenAIAPIURL, cfg.OpenAIAPIKey)
+	// Or send requests to the best of the upstream's regional deployments
+	var regional *proxy.RegionalClient
+	if len(cfg.Regions) > 0 {
+		if len(cfg.UpstreamReplicas) > 0 {
+			log.Fatalf("upstream_replicas and regions can't be used together")
+		}
+		regions := make([]proxy.Region, 0, len(cfg.Regions))
+		for _, region := range cfg.Regions {
+			regions = append(regions, proxy.Region{Name: region.Name, URL: region.URL, Client: newUpstream(region.URL)})
+		}
+		regional = proxy.NewRegionalClient(regions...)
+		regional.ProbePath = cfg.RegionRouting.ProbePath
+		regional.FailureThreshold = cfg.RegionRouting.FailureThreshold
+		regional.FailbackAfter = time.Duration(cfg.RegionRouting.FailbackAfter) * time.Second
+		regional.SwitchMargin = cfg.RegionRouting.SwitchMargin
+		regional.TrialAfter = time.Duration(cfg.RegionRouting.TrialAfter) * time.Second
+		if cfg.RegionRouting.SlowStart > 0 {
+			regional.SlowStart = time.Duration(cfg.RegionRouting.SlowStart) * time.Second
+		}
+		openaiClient = regional
+	}
+
+	// Add the upstreams routes send requests to, racing two of them where a route asks for it
+	var speculator *proxy.Speculator
+	var discovered []proxy.DiscoveredBackend
+	if len(cfg.Routes) > 0 || len(cfg.ModelDiscovery.Backends) > 0 {
+		backends := make(map[string]proxy.OpenAIClient)
+		for _, route := range cfg.Routes {
+			for _, url := range []string{route.Backend, route.Speculative} {
+				if url != "" && backends[url] == nil {
+					backends[url] = newUpstream(url)
+				}
+			}
+			if route.Speculative != "" && speculator == nil {
+				speculator = proxy.NewSpeculator(cfg.SpeculativeBudget)
+			}
+		}
+		// Models are discovered on the default upstream through its own client
+		for _, url := range cfg.ModelDiscovery.Backends {
+			client := openaiClient
+			if url != cfg.OpenAIAPIURL {
+				if backends[url] == nil {
+					backends[url] = newUpstream(url)
+				}
+				client = backends[url]
+			}
+			discovered = append(discovered, proxy.DiscoveredBackend{URL: url, Client: client})
+		}
+		openaiClient = &proxy.BackendClient{Default: openaiClient, Backends: backends, Speculator: speculator}
+	}
 
 	// Initialize metrics collector
 	metricsCollector := metrics.NewMetricsCollector(
@@ -33,41 +203,581 @@ func main() {
 		cfg.InfluxBucket,
 	)
 	defer metricsCollector.Close()
+	metricsCollector.Schema = &metrics.Schema{
+		MaxTagValues: cfg.Metrics.MaxTagValues,
+		Users:        cfg.Metrics.Users,
+		Salt:         cfg.Metrics.UserSalt,
+		Version:      build.Version,
+	}
+	if secretStore != nil && cfg.Secrets.InfluxToken != "" {
+		secretStore.OnChange(cfg.Secrets.InfluxToken, metricsCollector.SetToken)
+	}
 
 	// Create queue manager with OpenAI client
 	queueManager := proxy.NewQueueManager(cfg.Endpoints, openaiClient)
+	queueManager.StreamIdleTimeout = time.Duration(cfg.StreamIdleTimeout) * time.Second
+	queueManager.StreamIdleRetries = cfg.StreamIdleRetries
+	queueManager.RetryClassifier = proxy.NewRetryClassifier(cfg.RetryRules)
+	queueManager.ExposeUpstreamErrors = cfg.ExposeUpstreamErrors
+	queueManager.BackendUsage = backendUsage
+	queueManager.WaitWindow = time.Duration(cfg.Downgrade.Window) * time.Second
+	queueManager.SchedulerTick = time.Duration(cfg.SchedulerTickMs) * time.Millisecond
+	queueManager.PreemptCheckInterval = time.Duration(cfg.PreemptCheckMs) * time.Millisecond
+	queueManager.Trace = cfg.SchedulerTrace
+	queueManager.RequeueBoost = cfg.RequeueBoost
+	queueManager.RetryBudget = cfg.RetryBudget
+	if cfg.Tracing.Baggage || cfg.Tracing.TenantTag != "" || len(cfg.Tracing.IdentityHeaders) > 0 {
+		queueManager.Identity = &proxy.IdentityPropagation{
+			Baggage:   cfg.Tracing.Baggage,
+			TenantTag: cfg.Tracing.TenantTag,
+			Headers:   cfg.Tracing.IdentityHeaders,
+		}
+	}
+	queueManager.PreemptStreams = cfg.PreemptStreams
+	if cfg.LogSampling.SuccessEvery > 1 || cfg.LogSampling.MaxPerSecond > 0 {
+		queueManager.LogSampler = proxy.NewLogSampler(cfg.LogSampling.SuccessEvery, cfg.LogSampling.MaxPerSecond)
+	}
 
 	// Create context for shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Pick up rotated secrets and keys
+	if secretStore != nil {
+		go secretStore.Run(ctx)
+	}
+	if cfg.OpenAIAPIKeyFile != "" {
+		go upstreamKeys.WatchFile(ctx, cfg.OpenAIAPIKeyFile, 5*time.Second)
+	}
+
+	// Measure the regions' latency independently of request sizes. Every
+	// replica probes for itself, since each routes by what it has measured.
+	if regional != nil && cfg.RegionRouting.ProbeInterval > 0 {
+		go regional.Probe(ctx, time.Duration(cfg.RegionRouting.ProbeInterval)*time.Second)
+	}
+
+	// Account usage per key for billing, restoring what earlier runs saved
+	priceTable := pricing.NewTable(cfg.Pricing)
+	queueManager.Ledger = usage.NewLedger(priceTable, cfg.Billing.BillPreemptedAttempts)
+	queueManager.Pricing = priceTable
+	if cfg.Billing.UsageFile != "" {
+		if err := queueManager.Ledger.Load(cfg.Billing.UsageFile); err != nil {
+			log.Fatalf("Failed to load usage: %v", err)
+		}
+		go saveUsage(ctx, queueManager.Ledger, cfg.Billing.UsageFile)
+	}
+
+	// Archive requests and responses, streamed ones chunk by chunk
+	if cfg.Archive.Enabled() {
+		sink, err := archive.NewFileSink(cfg.Archive.File)
+		if err != nil {
+			log.Fatalf("Failed to open archive: %v", err)
+		}
+		queueManager.Archiver = archive.NewArchiver(sink, cfg.Archive.MaxBodyBytes, cfg.Archive.Buffer)
+	}
+
+	// Let admins capture chosen keys' or requests' exchanges in full for a while
+	if cfg.DebugCapture.Dir != "" {
+		if err := os.MkdirAll(cfg.DebugCapture.Dir, 0o700); err != nil {
+			log.Fatalf("Failed to create debug capture directory: %v", err)
+		}
+		queueManager.Debug = proxy.NewDebugCaptures(cfg.DebugCapture.Dir, cfg.DebugCapture.MaxBodyBytes, cfg.DebugCapture.MaxMinutes)
+	}
+
 	// Start the priority queue scheduler
 	go queueManager.StartScheduler(ctx)
 
+	// Share the queue with other replicas when a backend is configured
+	var lease proxy.Lease
+	switch cfg.Distributed.Backend {
+	case "":
+	case "redis":
+		backend, err := proxy.NewRedisBackend(cfg.Distributed.RedisURL, cfg.Distributed.KeyPrefix,
+			time.Duration(cfg.Distributed.ResultTTL)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to create Redis queue backend: %v", err)
+		}
+		defer backend.Close()
+
+		queueManager.Backend = backend
+		lease = backend
+		go queueManager.StartDistributedWorker(ctx, cfg.Distributed.MaxInflight)
+	case "nats":
+		backend, err := proxy.NewNATSBackend(ctx, cfg.Distributed.NATSURL, cfg.Distributed.KeyPrefix,
+			time.Duration(cfg.Distributed.AckWait)*time.Second,
+			time.Duration(cfg.Distributed.ResultTTL)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to create NATS queue backend: %v", err)
+		}
+		defer backend.Close()
+
+		queueManager.Backend = backend
+		lease = backend
+		go queueManager.StartDistributedWorker(ctx, cfg.Distributed.MaxInflight)
+	default:
+		log.Fatalf("Unknown queue backend: %s", cfg.Distributed.Backend)
+	}
+
 	// Create request handler
 	handler := proxy.NewRequestHandler(queueManager)
+	if cfg.Cache.Enabled {
+		handler.Cache = proxy.NewResponseCache(cfg.Cache)
+	}
+	if cfg.StatusCache.Enabled {
+		handler.StatusCache = proxy.NewResponseCache(cfg.StatusCache)
+	}
+
+	// Set up rate limiting, sharing counters between replicas when using Redis
+	if cfg.RateLimits.Enabled() || cfg.ScheduledRateLimits() {
+		var store ratelimit.Store
+		switch cfg.RateLimits.Store {
+		case "memory":
+			store = ratelimit.NewMemoryStore()
+		case "redis":
+			redisStore, err := ratelimit.NewRedisStore(cfg.RateLimits.RedisURL, cfg.RateLimits.KeyPrefix)
+			if err != nil {
+				log.Fatalf("Failed to create Redis rate limit store: %v", err)
+			}
+			defer redisStore.Close()
+			store = redisStore
+		default:
+			log.Fatalf("Unknown rate limit store: %s", cfg.RateLimits.Store)
+		}
+
+		keyLimits := make(map[string]int64, len(cfg.RateLimits.KeyLimits))
+		for key, limit := range cfg.RateLimits.KeyLimits {
+			keyLimits[proxy.KeyID(key)] = limit
+		}
+
+		handler.Limiter = ratelimit.NewLimiter(store, time.Duration(cfg.RateLimits.Window)*time.Second,
+			cfg.RateLimits.RequestsPerKey, cfg.RateLimits.OrgRequests, keyLimits)
+		handler.Limiter.Burst = cfg.RateLimits.Burst
+	}
+
+	// Keep keys with too many requests in flight waiting in their queues
+	if cfg.KeyConcurrency.Enabled() {
+		keyCaps := make(map[string]int, len(cfg.KeyConcurrency.Keys))
+		for key, limit := range cfg.KeyConcurrency.Keys {
+			keyCaps[proxy.KeyID(key)] = limit
+		}
+		queueManager.KeyConcurrency = proxy.NewKeyConcurrency(cfg.KeyConcurrency.Default, keyCaps)
+	}
+
+	// Duties only one replica needs run on the elected leader when leader
+	// election is on
+	var duties []func(context.Context)
+
+	// Enforce per-key token budgets, resetting them on schedule
+	if cfg.Quotas.Enabled() {
+		period, err := quota.ParsePeriod(cfg.Quotas.Period)
+		if err != nil {
+			log.Fatalf("Invalid quotas: %v", err)
+		}
+		loc, err := time.LoadLocation(cfg.Quotas.Timezone)
+		if err != nil {
+			log.Fatalf("Invalid quota timezone: %v", err)
+		}
+
+		keyTokens := make(map[string]int64, len(cfg.Quotas.KeyTokens))
+		for key, tokens := range cfg.Quotas.KeyTokens {
+			keyTokens[proxy.KeyID(key)] = tokens
+		}
+
+		queueManager.Quotas = quota.NewManager(period, loc, cfg.Quotas.Tokens, keyTokens,
+			cfg.Quotas.Rollover, cfg.Quotas.MaxRollover)
+		duties = append(duties, queueManager.Quotas.Run)
+	}
+
+	// Run the duties on one replica at a time when replicas elect a leader
+	var elector *proxy.Elector
+	if cfg.Distributed.LeaderElection {
+		id := cfg.Distributed.ReplicaID
+		if id == "" {
+			host, _ := os.Hostname()
+			id = fmt.Sprintf("%s-%d", host, os.Getpid())
+		}
+		elector = proxy.NewElector(lease, id, time.Duration(cfg.Distributed.LeaseTTL)*time.Second)
+		go elector.Run(ctx, duties...)
+	} else {
+		for _, duty := range duties {
+			go duty(ctx)
+		}
+	}
+
+	// Change queue settings and rate limits during scheduled windows
+	var schedule *proxy.Schedule
+	if len(cfg.Schedules) > 0 {
+		s, err := proxy.NewSchedule(cfg.Schedules, queueManager, handler.Limiter)
+		if err != nil {
+			log.Fatalf("Invalid schedules: %v", err)
+		}
+		schedule = s
+		go schedule.Run(ctx)
+	}
+
+	// Allow listed keys to promote urgent requests with X-Priority-Boost
+	if len(cfg.PriorityBoost.Keys) > 0 {
+		handler.BoostKeys = make(map[string]bool, len(cfg.PriorityBoost.Keys))
+		for _, key := range cfg.PriorityBoost.Keys {
+			handler.BoostKeys[proxy.KeyID(key)] = true
+		}
+		handler.BoostPriority = cfg.PriorityBoost.Priority
+	}
+
+	// Record allowlisted X-Proxy-Tags as metrics dimensions
+	if len(cfg.TagKeys) > 0 {
+		handler.TagKeys = make(map[string]bool, len(cfg.TagKeys))
+		for _, key := range cfg.TagKeys {
+			handler.TagKeys[key] = true
+		}
+	}
+
+	// Keep giant prompts from holding up interactive traffic
+	if cfg.LargePrompts.MinTokens > 0 {
+		handler.LargePrompts = &proxy.LargePromptPolicy{
+			MinTokens: int64(cfg.LargePrompts.MinTokens),
+			Priority:  cfg.LargePrompts.Priority,
+		}
+	}
+
+	// Switch to cheaper models while queues are backed up
+	if cfg.Downgrade.Enabled() {
+		handler.Downgrade = &proxy.DowngradePolicy{
+			MaxWait:    time.Duration(cfg.Downgrade.MaxWait) * time.Millisecond,
+			Models:     cfg.Downgrade.Models,
+			Priorities: make(map[int]bool, len(cfg.Downgrade.Priorities)),
+		}
+		for _, priority := range cfg.Downgrade.Priorities {
+			handler.Downgrade.Priorities[priority] = true
+		}
+	}
+
+	var routes *proxy.RuleRouter
+	if len(cfg.Routes) > 0 {
+		routes = proxy.NewRuleRouter(cfg.Routes)
+		handler.Router = routes
+	}
+
+	// Route models added to backends without a config change
+	if len(discovered) > 0 {
+		switch cfg.ModelDiscovery.Conflict {
+		case "", proxy.DiscoveryFirst, proxy.DiscoverySticky:
+		default:
+			log.Fatalf("Unknown model_discovery conflict %q", cfg.ModelDiscovery.Conflict)
+		}
+		handler.Discovery = proxy.NewModelDiscovery(routes, discovered...)
+		handler.Discovery.Conflict = cfg.ModelDiscovery.Conflict
+		handler.Discovery.Default = cfg.OpenAIAPIURL
+		go handler.Discovery.Run(ctx, time.Duration(cfg.ModelDiscovery.Interval)*time.Second)
+	}
+
+	handler.Methods = proxy.NewMethodPolicy(cfg.AllowedMethods)
 
-	// Start HTTP servers for each endpoint
+	// Decide per path whether requests are queued, bypass the queues or are denied
+	if len(cfg.PathRules) > 0 {
+		for _, rule := range cfg.PathRules {
+			if rule.Priority > 0 && queueManager.FindQueue(rule.Priority) == nil {
+				log.Fatalf("Invalid path rules: no queue with priority %d for path %s", rule.Priority, rule.Path)
+			}
+		}
+		handler.Paths, err = proxy.NewPathPolicy(cfg.PathRules)
+		if err != nil {
+			log.Fatalf("Invalid path rules: %v", err)
+		}
+	}
+
+	// Relay paths the proxy doesn't know to the upstream as they are
+	if cfg.Fallback.Enabled {
+		switch cfg.Fallback.Action {
+		case proxy.PathBypass, proxy.PathQueue:
+		default:
+			log.Fatalf("Unknown fallback action %q", cfg.Fallback.Action)
+		}
+		if cfg.Fallback.Priority > 0 && queueManager.FindQueue(cfg.Fallback.Priority) == nil {
+			log.Fatalf("Invalid fallback: no queue with priority %d", cfg.Fallback.Priority)
+		}
+		handler.Fallback, err = proxy.NewFallback(cfg.Fallback.Target, upstreamKeys.Current, cfg.Fallback.KnownPaths)
+		if err != nil {
+			log.Fatalf("Invalid fallback: %v", err)
+		}
+		handler.Fallback.Queue = cfg.Fallback.Action == proxy.PathQueue
+		handler.Fallback.Priority = cfg.Fallback.Priority
+	}
+
+	// Limit the models keys see and invoke; the admin API can grant more at any time
+	catalogKeys := make(map[string][]string, len(cfg.ModelCatalogs.Keys))
+	for key, models := range cfg.ModelCatalogs.Keys {
+		if !strings.HasPrefix(key, "sub:") {
+			key = proxy.KeyID(key)
+		}
+		catalogKeys[key] = models
+	}
+	handler.Catalogs = proxy.NewModelCatalogs(cfg.ModelCatalogs.Default, catalogKeys)
+	if cfg.ContextTrim.Strategy != "" {
+		handler.Trim = &proxy.ContextTrimmer{
+			Strategy:      cfg.ContextTrim.Strategy,
+			SummaryModel:  cfg.ContextTrim.SummaryModel,
+			SummaryTokens: cfg.ContextTrim.SummaryTokens,
+			Timeout:       time.Duration(cfg.ContextTrim.Timeout) * time.Second,
+		}
+	}
+	if len(cfg.ModelDefaults) > 0 {
+		handler.Defaults = proxy.NewParamDefaults(cfg.ModelDefaults)
+	}
+	handler.InjectUser = cfg.InjectUser
+	handler.Pricing = priceTable
+	handler.QuotaPrecheck = cfg.Quotas.Precheck
+	handler.Maintenance = proxy.NewMaintenance(cfg.Maintenance.Message,
+		time.Duration(cfg.Maintenance.RetryAfter)*time.Second)
+
+	// Log requests to their own file, apart from the application log
+	var accessLogger *accesslog.Logger
+	var accessLogFile *accesslog.RotatingFile
+	if cfg.AccessLog.Enabled() {
+		accessLogFile, err = accesslog.OpenRotatingFile(cfg.AccessLog.File,
+			int64(cfg.AccessLog.MaxSizeMB)<<20, cfg.AccessLog.MaxBackups)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		accessLogger, err = accesslog.NewLogger(accessLogFile, cfg.AccessLog.Format)
+		if err != nil {
+			log.Fatalf("Invalid access log: %v", err)
+		}
+	}
+
+	// Record how each request ended, one JSON line apiece
+	var outcomeLogFile *accesslog.RotatingFile
+	if cfg.OutcomeLog.Enabled() {
+		outcomeLogFile, err = accesslog.OpenRotatingFile(cfg.OutcomeLog.File,
+			int64(cfg.OutcomeLog.MaxSizeMB)<<20, cfg.OutcomeLog.MaxBackups)
+		if err != nil {
+			log.Fatalf("Failed to open outcome log: %v", err)
+		}
+		queueManager.Outcomes = append(queueManager.Outcomes, proxy.NewOutcomeLog(outcomeLogFile))
+	}
+
+	// Report requests slower than their threshold
+	if cfg.SlowRequests.Enabled() {
+		models := make(map[string]time.Duration, len(cfg.SlowRequests.Models))
+		for model, ms := range cfg.SlowRequests.Models {
+			models[model] = time.Duration(ms) * time.Millisecond
+		}
+		queueManager.Slow = proxy.NewSlowRequests(time.Duration(cfg.SlowRequests.Threshold)*time.Millisecond, models)
+		queueManager.Slow.Keep = cfg.SlowRequests.Keep
+		queueManager.Slow.Window = time.Duration(cfg.SlowRequests.Window) * time.Second
+	}
+
+	// Check generated text before it reaches clients
+	if cfg.OutputScan.Enabled() {
+		scanner, err := proxy.NewOutputScanner(cfg.OutputScan.Rules)
+		if err != nil {
+			log.Fatalf("Invalid output scan: %v", err)
+		}
+		if cfg.OutputScan.Classifier.URL != "" {
+			scanner.Classifier = &proxy.OutputClassifier{URL: cfg.OutputScan.Classifier.URL, APIKey: cfg.OutputScan.Classifier.APIKey}
+		}
+		scanner.Window = cfg.OutputScan.Window
+		scanner.Timeout = time.Duration(cfg.OutputScan.Timeout) * time.Millisecond
+		scanner.FailClosed = cfg.OutputScan.FailClosed
+		scanner.MaxResponse = cfg.OutputScan.MaxResponse
+		queueManager.Scanner = scanner
+	}
+
+	// Check responses against the json_schema their request asked for
+	if cfg.StructuredOutputs.Validate {
+		queueManager.Structured = &proxy.StructuredOutputs{Retry: cfg.StructuredOutputs.Retry}
+	}
+
+	// Keep writes from being applied twice when they, or their clients, retry
+	if cfg.Journal.Enabled {
+		queueManager.Journal = proxy.NewJournal(queueManager.RetryClassifier)
+		queueManager.Journal.TTL = time.Duration(cfg.Journal.TTL) * time.Second
+		queueManager.Journal.AmbiguousTTL = time.Duration(cfg.Journal.AmbiguousTTL) * time.Second
+	}
+
+	// Check what the proxy depends on before reporting ready
+	var preflight *proxy.Preflight
+	if cfg.Preflight.Enabled || *failFast {
+		preflight = proxy.NewPreflight(handler.Maintenance)
+		preflight.Timeout = time.Duration(cfg.Preflight.Timeout) * time.Second
+		preflight.Interval = time.Duration(cfg.Preflight.Interval) * time.Second
+		preflight.Add("upstream", proxy.CheckUpstream(openaiClient))
+		if cfg.InfluxDBURL != "" {
+			preflight.Add("influxdb", metricsCollector.Ping)
+		}
+	}
+
+	// Start HTTP servers for each endpoint, once every port is bound
 	var servers []*http.Server
+	var serve []func()
+	authSwitches := make(map[int]*proxy.AuthSwitch)
 	for _, ep := range cfg.Endpoints {
-		portStr := fmt.Sprintf(":%d", ep.Port)
-		
+		lis, err := listen(ep.BindAddress, ep.Port, ep.Stack, cfg.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen on port %d: %v", ep.Port, err)
+		}
+
+		var epHandler http.Handler = handler
+		auth, err := proxy.NewAuthorizer(ep.Auth)
+		if err != nil {
+			log.Fatalf("Invalid auth for port %d: %v", ep.Port, err)
+		}
+		if auth != nil {
+			authSwitches[ep.Port] = proxy.NewAuthSwitch(auth)
+			epHandler = proxy.NewAuthHandler(epHandler, authSwitches[ep.Port])
+		}
+		if accessLogger != nil && ep.AccessLogged() {
+			epHandler = proxy.NewAccessLogHandler(epHandler, accessLogger)
+		}
+		if cfg.Tracing.Endpoint != "" {
+			epHandler = proxy.NewTracingHandler(epHandler)
+		}
+
 		mux := http.NewServeMux()
-		mux.Handle("/", handler)
+		mux.Handle("/", epHandler)
 		
 		server := &http.Server{
-			Addr:    portStr,
+			Addr:    lis.Addr().String(),
 			Handler: mux,
 		}
 		
 		servers = append(servers, server)
 		
-		go func(port string) {
-			log.Printf("Starting proxy on %s", port)
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		serve = append(serve, func() {
+			log.Printf("Starting proxy on %s", server.Addr)
+			if err := server.Serve(lis); err != nil && err != http.ErrServerClosed {
 				log.Printf("Server error: %v", err)
 			}
-		}(portStr)
+		})
+	}
+
+	// Apply config changes without a restart on SIGHUP, through the admin API
+	// or, when watching, as soon as a mounted ConfigMap or Secret changes
+	reloader := proxy.NewReloader(&loaded, func() (*config.Config, error) {
+		return config.LoadConfig(configPath)
+	})
+	reloader.Keys = upstreamKeys
+	reloader.Queues = queueManager
+	reloader.Limiter = handler.Limiter
+	reloader.Schedule = schedule
+	reloader.Auth = authSwitches
+	reloader.Maintenance = handler.Maintenance
+	if len(cfg.Reload.Watch) > 0 {
+		go reloader.Watch(ctx, cfg.Reload.Watch, time.Duration(cfg.Reload.Interval)*time.Second)
+	}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Error reloading config, keeping the running one: %v", err)
+			}
+		}
+	}()
+
+	// Start the admin API on its own port so it is never exposed to proxy clients
+	if cfg.AdminPort != 0 {
+		adminHandler := proxy.NewAdminHandler(queueManager, handler.Cache)
+		adminHandler.Maintenance = handler.Maintenance
+		adminHandler.Speculator = speculator
+		adminHandler.Keys = upstreamKeys
+		adminHandler.Backends = backendInfo(cfg)
+		adminHandler.Regions = regional
+		adminHandler.Discovery = handler.Discovery
+		adminHandler.Schedule = schedule
+		adminHandler.Reloader = reloader
+		adminHandler.Elector = elector
+		adminHandler.Debug = queueManager.Debug
+		if cfg.QueueSnapshot.Key != "" {
+			snapshots, err := proxy.NewQueueSnapshots(queueManager, cfg.QueueSnapshot.Key)
+			if err != nil {
+				log.Fatalf("Invalid queue snapshots: %v", err)
+			}
+			adminHandler.Snapshots = snapshots
+		}
+		adminHandler.Slow = queueManager.Slow
+		adminHandler.Journal = queueManager.Journal
+		adminHandler.Catalogs = handler.Catalogs
+		adminHandler.BackendUsage = backendUsage
+		adminHandler.Limiter = handler.Limiter
+		adminHandler.Limits = proxy.StatusLimits{
+			RequestsPerKey: cfg.RateLimits.RequestsPerKey,
+			OrgRequests:    cfg.RateLimits.OrgRequests,
+			QuotaTokens:    cfg.Quotas.Tokens,
+			KeyConcurrency: cfg.KeyConcurrency.Default,
+		}
+		if handler.Limiter != nil {
+			adminHandler.Limits.RateWindowSeconds = cfg.RateLimits.Window
+		}
+		if cfg.Quotas.Enabled() {
+			adminHandler.Limits.QuotaPeriod = cfg.Quotas.Period
+		}
+		if err := adminHandler.SetTokens(cfg.AdminTokens); err != nil {
+			log.Fatalf("Invalid admin tokens: %v", err)
+		}
+
+		adminLis, err := listen(cfg.AdminBindAddress, cfg.AdminPort, "", cfg.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen for the admin API: %v", err)
+		}
+
+		adminServer := &http.Server{
+			Addr:    adminLis.Addr().String(),
+			Handler: adminHandler,
+		}
+
+		servers = append(servers, adminServer)
+
+		serve = append(serve, func() {
+			log.Printf("Starting admin API on %s", adminServer.Addr)
+			if err := adminServer.Serve(adminLis); err != nil && err != http.ErrServerClosed {
+				log.Printf("Admin server error: %v", err)
+			}
+		})
+	}
+
+	// Start the gRPC submission API
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort != 0 {
+		lis, err := listen(cfg.GRPCBindAddress, cfg.GRPCPort, "", cfg.ReusePort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC: %v", err)
+		}
+
+		auth, err := proxy.NewAuthorizer(cfg.GRPCAuth)
+		if err != nil {
+			log.Fatalf("Invalid gRPC auth: %v", err)
+		}
+		var opts []grpc.ServerOption
+		if auth != nil {
+			opts = grpcapi.AuthOptions(auth)
+		}
+		grpcServer = grpc.NewServer(opts...)
+		grpcapi.RegisterProxyServer(grpcServer, grpcapi.NewServer(queueManager, handler))
+
+		serve = append(serve, func() {
+			log.Printf("Starting gRPC API on %s", lis.Addr())
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Printf("gRPC server error: %v", err)
+			}
+		})
+	}
+
+	// With --fail-fast, a failed pre-flight check stops startup before any
+	// traffic is accepted too
+	if preflight != nil && *failFast {
+		if err := preflight.Run(ctx); err != nil {
+			log.Fatalf("Pre-flight checks failed: %v", err)
+		}
+	}
+
+	// Serve only now, so a port that's taken stops startup before any
+	// traffic is accepted
+	for _, start := range serve {
+		go start()
+	}
+	if preflight != nil && !*failFast {
+		go preflight.RunUntilPassed(ctx)
 	}
 
 	log.Println("OpenAI Proxy is running with preemption prioritization")
@@ -79,6 +789,16 @@ func main() {
 	<-stop
 	log.Println("Shutting down servers...")
 	
+	// Report not-ready and refuse new work while in-flight requests drain
+	handler.Maintenance.Enable("Proxy is shutting down", 0)
+	
+	// Let queued and running requests finish while the scheduler still dispatches them
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.DrainTimeout)*time.Second)
+	if err := queueManager.Drain(drainCtx); err != nil {
+		log.Printf("Requests still in flight after %ds drain timeout: %v", cfg.DrainTimeout, err)
+	}
+	cancelDrain()
+	
 	// Cancel the scheduler context
 	cancel()
 	
@@ -88,6 +808,128 @@ func main() {
 			log.Printf("Error shutting down server: %v", err)
 		}
 	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+
+	// Write out what requests that finished while draining left in the archive queue
+	if queueManager.Archiver != nil {
+		if err := queueManager.Archiver.Close(); err != nil {
+			log.Printf("Error closing archive: %v", err)
+		}
+	}
+	if queueManager.Debug != nil {
+		queueManager.Debug.Close()
+	}
+
+	if accessLogFile != nil {
+		if err := accessLogFile.Close(); err != nil {
+			log.Printf("Error closing access log: %v", err)
+		}
+	}
+	if outcomeLogFile != nil {
+		if err := outcomeLogFile.Close(); err != nil {
+			log.Printf("Error closing outcome log: %v", err)
+		}
+	}
+
+	// Save usage from requests that finished while draining
+	if cfg.Billing.UsageFile != "" {
+		if err := queueManager.Ledger.Save(cfg.Billing.UsageFile); err != nil {
+			log.Printf("Error saving usage: %v", err)
+		}
+	}
+
+	// Send the spans of requests that finished while draining
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+	cancelFlush()
 	
 	log.Println("Servers gracefully stopped")
-}
\ No newline at end of file
+}
+
+// listen opens a listener on a bind address and port. The stack is "dual"
+// (or empty) to accept IPv4 and IPv6 where the address allows it, or "ipv4"
+// or "ipv6" for one IP version only. With reuse, other processes may listen
+// on the same address, which lets a new version start before the old one exits.
+func listen(bindAddress string, port int, stack string, reuse bool) (net.Listener, error) {
+	var network string
+	switch stack {
+	case "", "dual":
+		network = "tcp"
+	case "ipv4":
+		network = "tcp4"
+	case "ipv6":
+		network = "tcp6"
+	default:
+		return nil, fmt.Errorf("unknown stack %q", stack)
+	}
+	var lc net.ListenConfig
+	if reuse {
+		lc.Control = reusePort
+	}
+	return lc.Listen(context.Background(), network, net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+}
+
+// retryPolicy converts an upstream's retry settings to the client's policy
+func retryPolicy(cfg config.UpstreamRetryConfig) openai.RetryPolicy {
+	return openai.RetryPolicy{
+		MaxRetries:      cfg.MaxRetries,
+		Backoff:         time.Duration(cfg.Backoff) * time.Millisecond,
+		RetryableStatus: cfg.Status,
+		ErrorTypes:      cfg.ErrorTypes,
+		NetworkErrors:   cfg.NetworkErrors,
+	}
+}
+
+// backendInfo lists the upstreams the configuration sends requests to
+func backendInfo(cfg *config.Config) []proxy.BackendInfo {
+	var backends []proxy.BackendInfo
+	seen := make(map[string]bool)
+	add := func(url, role string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			backends = append(backends, proxy.BackendInfo{URL: url, Role: role})
+		}
+	}
+
+	if len(cfg.UpstreamReplicas) > 0 {
+		for _, url := range cfg.UpstreamReplicas {
+			add(url, "replica")
+		}
+	} else if len(cfg.Regions) > 0 {
+		for _, region := range cfg.Regions {
+			add(region.URL, "region")
+		}
+	} else {
+		add(cfg.OpenAIAPIURL, "default")
+	}
+	for _, route := range cfg.Routes {
+		add(route.Backend, "route")
+		add(route.Speculative, "route")
+	}
+	for _, url := range cfg.ModelDiscovery.Backends {
+		add(url, "discovery")
+	}
+	return backends
+}
+
+// newSecretStore creates the store for the configured secrets manager
+func newSecretStore(cfg config.SecretsConfig) (*secrets.Store, error) {
+	var provider secrets.Provider
+	var err error
+	switch cfg.Provider {
+	case "vault":
+		provider, err = secrets.NewVaultProvider(cfg.Vault.Address, cfg.Vault.Token, cfg.Vault.TokenFile, cfg.Vault.Namespace)
+	case "aws":
+		provider, err = secrets.NewAWSProvider(cfg.AWS.Region, cfg.AWS.Endpoint)
+	default:
+		err = fmt.Errorf("unknown secrets provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secrets.NewStore(provider, time.Duration(cfg.Refresh)*time.Second), nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"net"
 	"os"
+	"strconv"
 	"testing"
 	
 	"github.com/mule-ai/proxy/pkg/config"
@@ -66,4 +68,55 @@ func TestConfigLoading(t *testing.T) {
 	if cfg.Endpoints[1].Priority != 2 || cfg.Endpoints[1].Preemptive {
 		t.Errorf("Endpoint 1 has incorrect values")
 	}
-}
\ No newline at end of file
+}
+
+// TestListen tests opening listeners on bind addresses and IP stacks
+func TestListen(t *testing.T) {
+	lis, err := listen("127.0.0.1", 0, "ipv4", false)
+	if err != nil {
+		t.Fatalf("Failed to listen on 127.0.0.1: %v", err)
+	}
+	lis.Close()
+	if host, _, _ := net.SplitHostPort(lis.Addr().String()); host != "127.0.0.1" {
+		t.Errorf("Expected listener on 127.0.0.1, got %s", lis.Addr())
+	}
+
+	if _, err := listen("127.0.0.1", 0, "ipv6", false); err == nil {
+		t.Error("Expected an IPv4 address to be refused on the ipv6 stack")
+	}
+
+	if _, err := listen("", 0, "ipx", false); err == nil {
+		t.Error("Expected error for unknown stack")
+	}
+
+	lis, err = listen("::1", 0, "", false)
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	lis.Close()
+	if host, _, _ := net.SplitHostPort(lis.Addr().String()); host != "::1" {
+		t.Errorf("Expected listener on ::1, got %s", lis.Addr())
+	}
+}
+
+// TestListenReusePort tests that a second listener can share a port with reuse
+func TestListenReusePort(t *testing.T) {
+	first, err := listen("127.0.0.1", 0, "", true)
+	if err != nil {
+		t.Skipf("SO_REUSEPORT not available: %v", err)
+	}
+	defer first.Close()
+	_, port, _ := net.SplitHostPort(first.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	second, err := listen("127.0.0.1", portNum, "", true)
+	if err != nil {
+		t.Fatalf("Expected a second listener on port %d, got %v", portNum, err)
+	}
+	second.Close()
+
+	if lis, err := listen("127.0.0.1", portNum, "", false); err == nil {
+		lis.Close()
+		t.Error("Expected a listener without reuse to be refused")
+	}
+}
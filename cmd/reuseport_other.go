@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort is unavailable on this platform
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("reuse_port is not supported on this platform")
+}
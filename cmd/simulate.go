@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/accesslog"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/proxy"
+)
+
+// traceRequest is one request replayed by the simulator
+type traceRequest struct {
+	Offset   time.Duration // Arrival time relative to the first request
+	Priority int
+	Method   string
+	Path     string
+	Model    string
+	Service  time.Duration // How long the upstream took to answer it
+}
+
+// simulatedBody is what the simulator sends through the scheduler; the
+// simulated upstream reads how long to take from it
+type simulatedBody struct {
+	Model     string `json:"model,omitempty"`
+	ServiceMs int64  `json:"service_ms"`
+}
+
+// percentileSummary summarizes a distribution of durations, in milliseconds
+type percentileSummary struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+// simulationResult describes how one priority fared in a simulation
+type simulationResult struct {
+	Priority  int               `json:"priority"`
+	Requests  int               `json:"requests"`
+	Rejected  int               `json:"rejected"`  // Refused because the queue was full or doesn't exist
+	Preempted int               `json:"preempted"` // Completed after being preempted at least once
+	QueueWait percentileSummary `json:"queue_wait_ms"`
+	Latency   percentileSummary `json:"latency_ms"` // From arrival until the response was sent
+}
+
+// simulate replays a JSON access log through the scheduler in memory, with
+// a simulated upstream in place of the network, and reports how long each
+// priority waited. It lets queue and scheduler settings be tried before
+// they are rolled out.
+func simulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	configPath := fs.String("config", "config.json", "Path to the proxy config whose queues and scheduler settings are simulated")
+	tracePath := fs.String("trace", "", "JSON access log to replay")
+	speed := fs.Float64("speed", 1, "How many times faster than real time to replay")
+	concurrency := fs.Int("upstream-concurrency", 0, "Requests the simulated upstream serves at once (0 = unlimited)")
+	remap := fs.String("remap", "", "Move recorded priorities to others, e.g. 3=2,4=2")
+	format := fs.String("format", "text", "Output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *tracePath == "" {
+		return fmt.Errorf("-trace is required")
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("-speed must be positive")
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	priorities, err := parseRemap(*remap)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	f, err := os.Open(*tracePath)
+	if err != nil {
+		return fmt.Errorf("failed to open trace: %w", err)
+	}
+	trace, err := readTrace(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for i := range trace {
+		if p, ok := priorities[trace[i].Priority]; ok {
+			trace[i].Priority = p
+		}
+	}
+
+	// The scheduler logs every request to stdout; keep the report readable
+	stdout := os.Stdout
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+		defer devNull.Close()
+	}
+	results := runSimulation(cfg, trace, *speed, *concurrency)
+	os.Stdout = stdout
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	return writeSimulationResults(stdout, results)
+}
+
+// parseRemap parses priority moves written as old=new pairs
+func parseRemap(s string) (map[int]int, error) {
+	remap := make(map[int]int)
+	if s == "" {
+		return remap, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		fromPriority, err1 := strconv.Atoi(from)
+		toPriority, err2 := strconv.Atoi(to)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid priority remap %q", pair)
+		}
+		remap[fromPriority] = toPriority
+	}
+	return remap, nil
+}
+
+// readTrace reads the queued requests from a JSON access log, in order of arrival
+func readTrace(r io.Reader) ([]traceRequest, error) {
+	var entries []accesslog.Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry accesslog.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("trace line %d is not a JSON access log entry: %w", line, err)
+		}
+		// Requests without a priority never reached a queue
+		if entry.Priority > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("trace has no queued requests")
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	trace := make([]traceRequest, 0, len(entries))
+	for _, entry := range entries {
+		// Prefer the upstream's own account of its time over what the proxy saw
+		service := time.Duration(entry.UpstreamProcessingMs) * time.Millisecond
+		if service == 0 {
+			service = time.Duration(max(entry.DurationMs-entry.QueueWaitMs, 0)) * time.Millisecond
+		}
+
+		path := entry.URI
+		if u, err := url.ParseRequestURI(entry.URI); err == nil {
+			path = u.Path
+		}
+
+		trace = append(trace, traceRequest{
+			Offset:   entry.Time.Sub(entries[0].Time),
+			Priority: entry.Priority,
+			Method:   entry.Method,
+			Path:     path,
+			Model:    entry.Model,
+			Service:  service,
+		})
+	}
+	return trace, nil
+}
+
+// simulatedUpstream answers every request after the service time its body
+// names, serving at most a fixed number at once when limited
+type simulatedUpstream struct {
+	speed float64
+	slots chan struct{} // nil for unlimited
+}
+
+// ForwardRequest implements proxy.OpenAIClient
+func (u *simulatedUpstream) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	var req simulatedBody
+	if body != nil {
+		json.NewDecoder(body).Decode(&req)
+	}
+
+	if u.slots != nil {
+		select {
+		case u.slots <- struct{}{}:
+			defer func() { <-u.slots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(float64(req.ServiceMs) * float64(time.Millisecond) / u.speed))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+// runSimulation replays a trace through a queue manager configured like the
+// proxy, speed times faster than it was recorded
+func runSimulation(cfg *config.Config, trace []traceRequest, speed float64, concurrency int) []simulationResult {
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) / speed)
+	}
+
+	upstream := &simulatedUpstream{speed: speed}
+	if concurrency > 0 {
+		upstream.slots = make(chan struct{}, concurrency)
+	}
+
+	qm := proxy.NewQueueManager(cfg.Endpoints, upstream)
+	qm.RetryClassifier = proxy.NewRetryClassifier(cfg.RetryRules)
+	qm.SchedulerTick = scale(time.Duration(cfg.SchedulerTickMs) * time.Millisecond)
+	qm.PreemptCheckInterval = scale(time.Duration(cfg.PreemptCheckMs) * time.Millisecond)
+
+	// Completed requests are counted from the metrics they report
+	var mu sync.Mutex
+	completed := make(map[int][]metrics.RequestMetrics)
+	rejected := make(map[int]int)
+	collector := metrics.NewMetricsCollector("", "", "", "")
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		mu.Lock()
+		defer mu.Unlock()
+		completed[m.Priority] = append(completed[m.Priority], m)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, tr := range trace {
+		time.Sleep(time.Until(start.Add(scale(tr.Offset))))
+
+		body, _ := json.Marshal(simulatedBody{Model: tr.Model, ServiceMs: tr.Service.Milliseconds()})
+		req := httptest.NewRequest(tr.Method, tr.Path, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+			if err := qm.Submit(priority, req, httptest.NewRecorder()); errors.Is(err, proxy.ErrQueueFull) || errors.Is(err, proxy.ErrQueueBytes) || errors.Is(err, proxy.ErrNoQueue) {
+				mu.Lock()
+				rejected[priority]++
+				mu.Unlock()
+			}
+		}(tr.Priority)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	seen := make(map[int]bool)
+	for _, tr := range trace {
+		seen[tr.Priority] = true
+	}
+	results := make([]simulationResult, 0, len(seen))
+	for priority := range seen {
+		result := simulationResult{Priority: priority, Requests: len(completed[priority]) + rejected[priority], Rejected: rejected[priority]}
+
+		var waits, latencies []time.Duration
+		for _, m := range completed[priority] {
+			if m.Preempted {
+				result.Preempted++
+			}
+			// Report times as they would have been in production
+			waits = append(waits, time.Duration(float64(m.QueueWaitTime)*speed))
+			latencies = append(latencies, time.Duration(float64(m.TotalTime)*speed))
+		}
+		result.QueueWait = percentiles(waits)
+		result.Latency = percentiles(latencies)
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Priority < results[j].Priority
+	})
+	return results
+}
+
+// percentiles summarizes durations, all zero when there are none
+func percentiles(durations []time.Duration) percentileSummary {
+	if len(durations) == 0 {
+		return percentileSummary{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	at := func(p float64) int64 {
+		i := int(p * float64(len(durations)-1))
+		return durations[i].Milliseconds()
+	}
+	return percentileSummary{P50: at(0.50), P90: at(0.90), P99: at(0.99), Max: durations[len(durations)-1].Milliseconds()}
+}
+
+// writeSimulationResults writes results as a table
+func writeSimulationResults(w io.Writer, results []simulationResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRIORITY\tREQUESTS\tREJECTED\tPREEMPTED\tWAIT P50\tWAIT P90\tWAIT P99\tWAIT MAX\tLATENCY P50\tLATENCY P99")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%dms\t%dms\t%dms\t%dms\t%dms\t%dms\n",
+			r.Priority, r.Requests, r.Rejected, r.Preempted,
+			r.QueueWait.P50, r.QueueWait.P90, r.QueueWait.P99, r.QueueWait.Max,
+			r.Latency.P50, r.Latency.P99)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+const testTrace = `{"time":"2026-10-01T12:00:00.5Z","method":"POST","uri":"/v1/chat/completions","priority":1,"model":"gpt-4o","queue_wait_ms":5,"duration_ms":300,"upstream_processing_ms":250}
+{"time":"2026-10-01T12:00:00Z","method":"POST","uri":"/v1/chat/completions?stream=true","priority":2,"model":"gpt-4o-mini","queue_wait_ms":100,"duration_ms":1100}
+{"time":"2026-10-01T12:00:01Z","method":"GET","uri":"/proxy/ready","status":200,"duration_ms":0}
+`
+
+func TestReadTrace(t *testing.T) {
+	trace, err := readTrace(strings.NewReader(testTrace))
+	if err != nil {
+		t.Fatalf("Failed to read trace: %v", err)
+	}
+
+	if len(trace) != 2 {
+		t.Fatalf("Expected the 2 queued requests, got %+v", trace)
+	}
+	first, second := trace[0], trace[1]
+	if first.Priority != 2 || first.Offset != 0 || first.Path != "/v1/chat/completions" || first.Service != time.Second {
+		t.Errorf("Unexpected first request: %+v", first)
+	}
+	if second.Priority != 1 || second.Offset != 500*time.Millisecond || second.Service != 250*time.Millisecond || second.Model != "gpt-4o" {
+		t.Errorf("Unexpected second request: %+v", second)
+	}
+
+	if _, err := readTrace(strings.NewReader("not json\n")); err == nil {
+		t.Error("Expected error for a trace that isn't an access log")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	tracePath := filepath.Join(dir, "access.log")
+	if err := os.WriteFile(tracePath, []byte(testTrace), 0o600); err != nil {
+		t.Fatalf("Failed to write trace: %v", err)
+	}
+	configPath := filepath.Join(dir, "config.json")
+	configData := `{"endpoints": [{"port": 8080, "priority": 1, "preemptive": true}, {"port": 8081, "priority": 2}]}`
+	if err := os.WriteFile(configPath, []byte(configData), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if err := simulate([]string{"-config", configPath, "-trace", tracePath, "-remap", "x"}); err == nil {
+		t.Error("Expected error for an invalid remap")
+	}
+
+	// Both requests complete, with times reported at production speed
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	trace, _ := readTrace(strings.NewReader(testTrace))
+	results := runSimulation(cfg, trace, 10, 0)
+
+	if len(results) != 2 || results[0].Priority != 1 || results[1].Priority != 2 {
+		t.Fatalf("Expected results for priorities 1 and 2, got %+v", results)
+	}
+	for _, r := range results {
+		if r.Requests != 1 || r.Rejected != 0 {
+			t.Errorf("Expected one completed request at priority %d, got %+v", r.Priority, r)
+		}
+	}
+	if low := results[1]; low.Latency.Max < 1000 || low.Latency.Max > 2000 {
+		t.Errorf("Expected the priority 2 request to take about a second, got %+v", low.Latency)
+	}
+
+	// Capture stdout
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	err = simulate([]string{"-config", configPath, "-trace", tracePath, "-speed", "10", "-remap", "1=2", "-format", "json"})
+	w.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("Simulation failed: %v", err)
+	}
+
+	out, _ := io.ReadAll(r)
+	if err := json.Unmarshal(out, &results); err != nil {
+		t.Fatalf("Expected only the JSON report on stdout, got %s", out)
+	}
+	if len(results) != 1 || results[0].Priority != 2 || results[0].Requests != 2 {
+		t.Errorf("Expected both requests moved to priority 2, got %+v", results)
+	}
+}
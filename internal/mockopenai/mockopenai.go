@@ -0,0 +1,230 @@
+// Package mockopenai fakes the OpenAI API for tests: a client answering
+// with scripted responses, which can stream server-sent events at a set
+// pace and fail after a number of bytes, so preemption and streaming paths
+// can be tested deterministically.
+package mockopenai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is one scripted upstream response
+type Response struct {
+	Status int         // Status code (default 200)
+	Header http.Header // Extra response headers
+	Body   string      // Response body, ignored when Chunks is set
+	// Chunks are sent as server-sent events, each as one data line, followed
+	// by "data: [DONE]" unless BodyErr is set
+	Chunks []string
+	// ChunkDelay is waited before each chunk
+	ChunkDelay time.Duration
+	// BodyErr breaks the body with this error instead of ending it, as a
+	// dropped upstream connection would: after FailAfter bytes when set,
+	// otherwise after the body or the last chunk
+	BodyErr error
+	// FailAfter is how many bytes of the body are sent before it breaks with
+	// BodyErr (io.ErrUnexpectedEOF when BodyErr is unset); 0 sends it all
+	FailAfter int
+	// Delay is waited before responding, or until the request is cancelled
+	Delay time.Duration
+	// Err fails the request without a response, as a connection error would
+	Err error
+}
+
+// JSON returns a 200 response with a JSON body
+func JSON(body string) Response {
+	return Response{Body: body}
+}
+
+// Error returns an OpenAI-format error response
+func Error(status int, message string) Response {
+	body, _ := json.Marshal(map[string]map[string]string{"error": {"message": message, "type": "server_error"}})
+	return Response{Status: status, Body: string(body)}
+}
+
+// Stream returns a streaming response sending chunks as server-sent events
+func Stream(chunks ...string) Response {
+	return Response{Chunks: chunks}
+}
+
+// ChatCompletion returns a chat completion answering with content and
+// reporting token usage
+func ChatCompletion(content string, promptTokens, completionTokens int) Response {
+	return JSON(fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		content, promptTokens, completionTokens, promptTokens+completionTokens))
+}
+
+// ChatStream returns a streaming chat completion sending each delta as a
+// chunk, with a final chunk reporting token usage
+func ChatStream(promptTokens, completionTokens int, deltas ...string) Response {
+	chunks := make([]string, 0, len(deltas)+1)
+	for _, delta := range deltas {
+		chunks = append(chunks, fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":%q}}]}`, delta))
+	}
+	chunks = append(chunks, fmt.Sprintf(`{"id":"chatcmpl-test","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`,
+		promptTokens, completionTokens, promptTokens+completionTokens))
+	return Stream(chunks...)
+}
+
+// Respond builds the HTTP response a script entry describes, waiting out
+// its Delay unless ctx is cancelled first. Streamed chunks are written as
+// they are read, so ChunkDelay paces what the reader sees.
+func Respond(ctx context.Context, resp Response) (*http.Response, error) {
+	if resp.Delay > 0 {
+		if err := sleep(ctx, resp.Delay); err != nil {
+			return nil, err
+		}
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := make(http.Header)
+	for key, values := range resp.Header {
+		header[key] = append([]string(nil), values...)
+	}
+
+	if resp.Chunks == nil && resp.BodyErr == nil && resp.FailAfter == 0 {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(bytes.NewReader([]byte(resp.Body)))}, nil
+	}
+
+	pr, pw := io.Pipe()
+	if resp.Chunks == nil {
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+		go write(ctx, pw, resp, []string{resp.Body}, 0)
+	} else {
+		header.Set("Content-Type", "text/event-stream")
+		events := make([]string, 0, len(resp.Chunks)+1)
+		for _, chunk := range resp.Chunks {
+			events = append(events, "data: "+chunk+"\n\n")
+		}
+		if resp.BodyErr == nil && resp.FailAfter == 0 {
+			events = append(events, "data: [DONE]\n\n")
+		}
+		go write(ctx, pw, resp, events, resp.ChunkDelay)
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: pr}, nil
+}
+
+// write sends a body's parts, waiting delay before each, and breaks it as
+// the response asks
+func write(ctx context.Context, w *io.PipeWriter, resp Response, parts []string, delay time.Duration) {
+	bodyErr := resp.BodyErr
+	if bodyErr == nil {
+		bodyErr = io.ErrUnexpectedEOF
+	}
+
+	sent := 0
+	for i, part := range parts {
+		// A stream with nothing left to send but [DONE] is already done
+		if delay > 0 && !(i == len(parts)-1 && strings.HasPrefix(part, "data: [DONE]")) {
+			if err := sleep(ctx, delay); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		if resp.FailAfter > 0 && sent+len(part) >= resp.FailAfter {
+			w.Write([]byte(part[:resp.FailAfter-sent]))
+			w.CloseWithError(bodyErr)
+			return
+		}
+		if _, err := io.WriteString(w, part); err != nil {
+			return
+		}
+		sent += len(part)
+	}
+
+	if resp.BodyErr != nil || resp.FailAfter > 0 {
+		w.CloseWithError(bodyErr)
+		return
+	}
+	w.Close()
+}
+
+// sleep waits d, returning early with ctx's error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Client implements the proxy's OpenAI client interface. Calls are answered
+// from Script in order while it lasts, then from the Response fields.
+type Client struct {
+	ResponseBody    string
+	ResponseHeaders map[string]string
+	ResponseStatus  int
+	RequestDelay    time.Duration
+	CallCount       int
+	CustomForwarder func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error)
+	// Script answers calls in order before the fields above are used
+	Script []Response
+	mu     sync.Mutex
+}
+
+// ForwardRequest answers with the next scripted response, or the configured one
+func (m *Client) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	// Use custom implementation if provided
+	if m.CustomForwarder != nil {
+		return m.CustomForwarder(ctx, method, path, body)
+	}
+
+	m.mu.Lock()
+	m.CallCount++
+	if len(m.Script) > 0 {
+		resp := m.Script[0]
+		m.Script = m.Script[1:]
+		m.mu.Unlock()
+		return Respond(ctx, resp)
+	}
+	m.mu.Unlock()
+
+	// Simulate processing delay
+	if m.RequestDelay > 0 {
+		time.Sleep(m.RequestDelay)
+	}
+
+	// Check for context cancellation
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+		// Continue with the response
+	}
+
+	// Create a response
+	resp := &http.Response{
+		StatusCode: m.ResponseStatus,
+		Body:       io.NopCloser(strings.NewReader(m.ResponseBody)),
+		Header:     make(http.Header),
+	}
+
+	// Add headers
+	for k, v := range m.ResponseHeaders {
+		resp.Header.Set(k, v)
+	}
+
+	return resp, nil
+}
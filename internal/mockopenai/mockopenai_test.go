@@ -0,0 +1,93 @@
+package mockopenai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientScript(t *testing.T) {
+	client := &Client{
+		ResponseBody:   `{"id":"fallback"}`,
+		ResponseStatus: http.StatusOK,
+		Script: []Response{
+			Error(http.StatusTooManyRequests, "slow down"),
+			{Err: errors.New("connection refused")},
+		},
+	}
+
+	resp, err := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected the scripted 429, got %v", err)
+	}
+	if _, err := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil); err == nil {
+		t.Error("Expected the scripted connection error")
+	}
+
+	// The configured response answers once the script runs out
+	resp, err = client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil {
+		t.Fatalf("Expected the configured response, got %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"id":"fallback"}` || client.CallCount != 3 {
+		t.Errorf("Expected the configured body on the third call, got %q after %d calls", body, client.CallCount)
+	}
+}
+
+func TestRespondStream(t *testing.T) {
+	resp, err := Respond(context.Background(), Response{Chunks: []string{"one", "two"}, ChunkDelay: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+
+	// Chunks arrive one at a time at the configured pace
+	start := time.Now()
+	buf := make([]byte, 64)
+	n, _ := resp.Body.Read(buf)
+	if string(buf[:n]) != "data: one\n\n" || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Expected the first chunk alone after the delay, got %q after %v", buf[:n], time.Since(start))
+	}
+	rest, err := io.ReadAll(resp.Body)
+	if err != nil || string(rest) != "data: two\n\ndata: [DONE]\n\n" {
+		t.Errorf("Expected the rest of the stream, got %q (%v)", rest, err)
+	}
+}
+
+func TestRespondFailAfter(t *testing.T) {
+	dropped := errors.New("connection reset")
+	tests := []struct {
+		name string
+		resp Response
+		body string
+		err  error
+	}{
+		{"body", Response{Body: `{"id":"abc"}`, FailAfter: 5}, `{"id"`, io.ErrUnexpectedEOF},
+		{"stream", Response{Chunks: []string{"one", "two"}, FailAfter: 13, BodyErr: dropped}, "data: one\n\nda", dropped},
+		{"after the last chunk", Response{Chunks: []string{"one"}, BodyErr: dropped}, "data: one\n\n", dropped},
+	}
+	for _, tt := range tests {
+		resp, err := Respond(context.Background(), tt.resp)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if string(body) != tt.body || !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %q then %v, got %q then %v", tt.name, tt.body, tt.err, body, err)
+		}
+	}
+}
+
+func TestRespondDelayCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Respond(ctx, Response{Delay: time.Minute}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the delay to end with the request, got %v", err)
+	}
+}
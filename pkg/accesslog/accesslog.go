@@ -0,0 +1,197 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats an access log can be written in
+const (
+	FormatCommon   = "common"   // NCSA common log format
+	FormatCombined = "combined" // Common format plus referer and user agent
+	FormatJSON     = "json"     // One JSON object per line
+)
+
+// Entry is one request in the access log
+type Entry struct {
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	Method      string    `json:"method"`
+	URI         string    `json:"uri"`
+	Proto       string    `json:"proto"`
+	Status      int       `json:"status"`
+	Bytes       int64     `json:"bytes"`
+	Referer     string    `json:"referer,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	KeyID       string    `json:"key_id,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	Priority    int       `json:"priority,omitempty"`
+	QueueWaitMs int64     `json:"queue_wait_ms"`
+	DurationMs  int64     `json:"duration_ms"`
+	// Upstream's ID for the request and the time it reports spending, for correlating with its logs
+	UpstreamRequestID    string `json:"upstream_request_id,omitempty"`
+	UpstreamProcessingMs int64  `json:"upstream_processing_ms,omitempty"`
+}
+
+// Logger writes access log entries in one format
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+}
+
+// NewLogger creates a logger writing entries to out in format
+func NewLogger(out io.Writer, format string) (*Logger, error) {
+	switch format {
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+	return &Logger{out: out, format: format}, nil
+}
+
+// Log writes an entry
+func (l *Logger) Log(e *Entry) {
+	var line []byte
+	if l.format == FormatJSON {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		line = []byte(l.formatText(e))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		fmt.Printf("Error writing access log: %v\n", err)
+	}
+}
+
+// formatText renders an entry in common or combined format, followed by the
+// proxy's own fields so standard parsers still read the leading ones
+func (l *Logger) formatText(e *Entry) string {
+	host := e.RemoteAddr
+	if i := strings.LastIndexByte(host, ':'); i > 0 {
+		host = strings.Trim(host[:i], "[]")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] \"%s %s %s\" %d %s",
+		dash(host), dash(e.KeyID), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.URI, e.Proto, e.Status, bytesField(e.Bytes))
+	if l.format == FormatCombined {
+		fmt.Fprintf(&b, " %q %q", dash(e.Referer), dash(e.UserAgent))
+	}
+	fmt.Fprintf(&b, " request_id=%s model=%s priority=%s queue_wait_ms=%d duration_ms=%d upstream_request_id=%s upstream_processing_ms=%d\n",
+		dash(e.RequestID), dash(e.Model), priorityField(e.Priority), e.QueueWaitMs, e.DurationMs,
+		dash(e.UpstreamRequestID), e.UpstreamProcessingMs)
+	return b.String()
+}
+
+// dash stands in for empty fields, as the common format does
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// bytesField renders a body size, "-" for none as the common format does
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// priorityField renders a queue priority, "-" for requests never queued
+func priorityField(priority int) string {
+	if priority == 0 {
+		return "-"
+	}
+	return strconv.Itoa(priority)
+}
+
+// RotatingFile is an append-only file that is rotated once it reaches a
+// size limit, keeping a number of old files as path.1, path.2, ...
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// OpenRotatingFile opens path for appending, rotating it at maxBytes (0
+// never rotates) and keeping maxBackups old files
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the current file, picking up its size
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening access log: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write implements io.Writer
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the old files along, dropping the oldest, and starts a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("error rotating access log: %w", err)
+	}
+
+	if f.maxBackups <= 0 {
+		os.Remove(f.path)
+	} else {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return fmt.Errorf("error rotating access log: %w", err)
+		}
+	}
+	return f.open()
+}
+
+// Close closes the current file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,101 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testEntry() *Entry {
+	return &Entry{
+		Time:        time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC),
+		RequestID:   "abc123",
+		RemoteAddr:  "[::1]:52144",
+		Method:      "POST",
+		URI:         "/v1/chat/completions",
+		Proto:       "HTTP/1.1",
+		Status:      200,
+		Bytes:       512,
+		UserAgent:   "openai-python/1.0",
+		KeyID:       "key-0123456789abcdef",
+		Model:       "gpt-4o",
+		Priority:    2,
+		QueueWaitMs: 40,
+		DurationMs:  1200,
+
+		UpstreamRequestID:    "req_789",
+		UpstreamProcessingMs: 950,
+	}
+}
+
+func TestLoggerFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatCommon, `::1 - key-0123456789abcdef [14/Mar/2025:09:26:53 +0000] "POST /v1/chat/completions HTTP/1.1" 200 512` +
+			" request_id=abc123 model=gpt-4o priority=2 queue_wait_ms=40 duration_ms=1200 upstream_request_id=req_789 upstream_processing_ms=950\n"},
+		{FormatCombined, `::1 - key-0123456789abcdef [14/Mar/2025:09:26:53 +0000] "POST /v1/chat/completions HTTP/1.1" 200 512 "-" "openai-python/1.0"` +
+			" request_id=abc123 model=gpt-4o priority=2 queue_wait_ms=40 duration_ms=1200 upstream_request_id=req_789 upstream_processing_ms=950\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		logger, err := NewLogger(&buf, tt.format)
+		if err != nil {
+			t.Fatalf("Failed to create %s logger: %v", tt.format, err)
+		}
+		logger.Log(testEntry())
+		if buf.String() != tt.want {
+			t.Errorf("%s format:\n got %q\nwant %q", tt.format, buf.String(), tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, FormatJSON)
+	logger.Log(testEntry())
+	var decoded Entry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", buf.String(), err)
+	}
+	if decoded != *testEntry() {
+		t.Errorf("Expected JSON line to round trip, got %+v", decoded)
+	}
+
+	if _, err := NewLogger(&buf, "apache"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+
+	// Each write overflows the 10 byte limit, so only the newest two rotated files are kept
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", name, want, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest file to be dropped, got %v", err)
+	}
+}
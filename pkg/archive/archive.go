@@ -0,0 +1,248 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Record is one archived request and the response it got
+type Record struct {
+	Time       time.Time `json:"time"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Streamed   bool      `json:"streamed"`
+	Request    string    `json:"request,omitempty"`
+	Response   string    `json:"response"`
+	Truncated  bool      `json:"truncated,omitempty"` // Cut at the size limit, or chunks dropped to keep up with the client
+	DurationMs int64     `json:"duration_ms"`
+	// UpstreamRequestID is the ID the upstream gave the request, for correlating with its logs
+	UpstreamRequestID string `json:"upstream_request_id,omitempty"`
+	// RequestID is the ID the proxy logged the request under, if it had one
+	RequestID string `json:"request_id,omitempty"`
+	// RequestHeaders and ResponseHeaders are kept by debug captures, with credentials redacted
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+}
+
+// Sink stores archived records
+type Sink interface {
+	Write(rec *Record) error
+	Close() error
+}
+
+// FileSink appends records to a file as JSON lines
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileSink opens a file for appending records
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening archive file: %w", err)
+	}
+	return &FileSink{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Write implements Sink
+func (s *FileSink) Write(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(rec)
+}
+
+// Close implements Sink
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// eventKind says what an event carries
+type eventKind int
+
+const (
+	eventStart eventKind = iota
+	eventChunk
+	eventFinish
+)
+
+// event is a step in capturing one exchange, handed to the archiver's goroutine
+type event struct {
+	id        uint64
+	kind      eventKind
+	record    *Record // eventStart
+	data      []byte  // eventChunk
+	truncated bool    // eventFinish
+}
+
+// Archiver captures exchanges off the request path. Capture calls never
+// block: chunks are queued for a background goroutine that assembles and
+// writes each record once its response is complete, and are dropped (the
+// record marked truncated) when the queue is full.
+type Archiver struct {
+	sink     Sink
+	maxBytes int
+	events   chan event
+	nextID   atomic.Uint64
+	dropped  atomic.Int64
+	mu       sync.RWMutex
+	closed   bool
+	finishes sync.WaitGroup // Finish events waiting for room in the queue
+	done     chan struct{}
+}
+
+// NewArchiver starts an archiver writing to sink. Responses are kept up to
+// maxBytes, and buffer chunks may be queued before new ones are dropped.
+func NewArchiver(sink Sink, maxBytes, buffer int) *Archiver {
+	a := &Archiver{
+		sink:     sink,
+		maxBytes: maxBytes,
+		events:   make(chan event, buffer),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Dropped returns how many events were dropped because the queue was full
+func (a *Archiver) Dropped() int64 {
+	return a.dropped.Load()
+}
+
+// send queues an event without blocking
+func (a *Archiver) send(e event) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		return false
+	}
+	select {
+	case a.events <- e:
+		return true
+	default:
+		a.dropped.Add(1)
+		return false
+	}
+}
+
+// Start begins capturing an exchange described by rec
+func (a *Archiver) Start(rec Record) *Capture {
+	if len(rec.Request) > a.maxBytes {
+		rec.Request = rec.Request[:a.maxBytes]
+		rec.Truncated = true
+	}
+
+	c := &Capture{archiver: a, id: a.nextID.Add(1)}
+	c.started = a.send(event{id: c.id, kind: eventStart, record: &rec})
+	return c
+}
+
+// Close writes the records already finished and closes the sink
+func (a *Archiver) Close() error {
+	a.finishes.Wait()
+
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+	return a.sink.Close()
+}
+
+// pending is a record whose response is still arriving
+type pending struct {
+	record   *Record
+	response []byte
+	start    time.Time
+}
+
+// run assembles records from queued events and writes completed ones
+func (a *Archiver) run() {
+	defer close(a.done)
+
+	inFlight := make(map[uint64]*pending)
+	for e := range a.events {
+		switch e.kind {
+		case eventStart:
+			inFlight[e.id] = &pending{record: e.record, start: time.Now()}
+		case eventChunk:
+			p, ok := inFlight[e.id]
+			if !ok {
+				continue
+			}
+			if room := a.maxBytes - len(p.response); room < len(e.data) {
+				e.data = e.data[:max(room, 0)]
+				p.record.Truncated = true
+			}
+			p.response = append(p.response, e.data...)
+		case eventFinish:
+			p, ok := inFlight[e.id]
+			if !ok {
+				continue
+			}
+			delete(inFlight, e.id)
+
+			p.record.Response = string(p.response)
+			p.record.Truncated = p.record.Truncated || e.truncated
+			p.record.DurationMs = time.Since(p.start).Milliseconds()
+			if err := a.sink.Write(p.record); err != nil {
+				fmt.Printf("Error archiving response: %v\n", err)
+			}
+		}
+	}
+}
+
+// Capture collects one exchange's response as it streams to the client
+type Capture struct {
+	archiver  *Archiver
+	id        uint64
+	started   bool
+	truncated bool
+}
+
+// Chunk queues a piece of the response, copying it so the caller can reuse p
+func (c *Capture) Chunk(p []byte) {
+	if !c.started || len(p) == 0 {
+		return
+	}
+	data := make([]byte, len(p))
+	copy(data, p)
+	if !c.archiver.send(event{id: c.id, kind: eventChunk, data: data}) {
+		c.truncated = true
+	}
+}
+
+// Finish marks the response complete so its record can be written
+func (c *Capture) Finish() {
+	if !c.started {
+		return
+	}
+	c.started = false
+
+	// Wait for room in the background rather than lose the whole record
+	// because the queue is momentarily full
+	finish := event{id: c.id, kind: eventFinish, truncated: c.truncated}
+	c.archiver.finishes.Add(1)
+	go func() {
+		defer c.archiver.finishes.Done()
+		c.archiver.mu.RLock()
+		defer c.archiver.mu.RUnlock()
+		if !c.archiver.closed {
+			c.archiver.events <- finish
+		}
+	}()
+}
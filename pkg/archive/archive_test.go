@@ -0,0 +1,133 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// memorySink keeps records in memory
+type memorySink struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func (s *memorySink) Write(rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestArchiverAssemblesChunks(t *testing.T) {
+	sink := &memorySink{}
+	archiver := NewArchiver(sink, 16, 64)
+
+	streamed := archiver.Start(Record{Path: "/v1/chat/completions", Status: 200, Streamed: true, Request: `{"stream":true}`})
+	plain := archiver.Start(Record{Path: "/v1/embeddings", Status: 200})
+
+	streamed.Chunk([]byte("data: 1\n\n"))
+	plain.Chunk([]byte(`{"data":[]}`))
+	streamed.Chunk([]byte("data: 2\n\n"))
+	plain.Finish()
+	streamed.Finish()
+
+	if err := archiver.Close(); err != nil {
+		t.Fatalf("Failed to close archiver: %v", err)
+	}
+
+	if len(sink.records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(sink.records))
+	}
+
+	byPath := make(map[string]*Record)
+	for _, rec := range sink.records {
+		byPath[rec.Path] = rec
+	}
+
+	if rec := byPath["/v1/embeddings"]; rec.Response != `{"data":[]}` || rec.Truncated {
+		t.Errorf("Unexpected plain record: %+v", rec)
+	}
+
+	// The second chunk goes over the 16 byte limit
+	if rec := byPath["/v1/chat/completions"]; rec.Response != "data: 1\n\ndata: 2" || !rec.Truncated || rec.Request != `{"stream":true}` {
+		t.Errorf("Unexpected streamed record: %+v", rec)
+	}
+}
+
+// blockingSink holds up the archiver until released
+type blockingSink struct {
+	memorySink
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSink) Write(rec *Record) error {
+	s.writing <- struct{}{}
+	<-s.release
+	return s.memorySink.Write(rec)
+}
+
+func TestArchiverDropsWhenBehind(t *testing.T) {
+	sink := &blockingSink{writing: make(chan struct{}, 2), release: make(chan struct{})}
+	archiver := NewArchiver(sink, 1024, 1)
+
+	// Keep the archiver busy writing the first record
+	archiver.Start(Record{Path: "/first"}).Finish()
+	<-sink.writing
+
+	// The queue only has room for the start of the next capture, so its
+	// chunk is dropped rather than holding up the caller
+	capture := archiver.Start(Record{Path: "/second"})
+	capture.Chunk([]byte("data"))
+	capture.Finish()
+
+	close(sink.release)
+	archiver.Close()
+
+	if archiver.Dropped() == 0 {
+		t.Error("Expected a dropped chunk")
+	}
+	if len(sink.records) != 2 || sink.records[1].Response != "" || !sink.records[1].Truncated {
+		t.Errorf("Expected the second record to be marked truncated, got %+v", sink.records)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Failed to create file sink: %v", err)
+	}
+
+	sink.Write(&Record{Path: "/v1/chat/completions", Response: "first"})
+	sink.Write(&Record{Path: "/v1/chat/completions", Response: "second"})
+	sink.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	var responses []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid archive line %q: %v", scanner.Text(), err)
+		}
+		responses = append(responses, rec.Response)
+	}
+
+	if len(responses) != 2 || responses[0] != "first" || responses[1] != "second" {
+		t.Errorf("Expected both records in order, got %v", responses)
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import "fmt"
+
+// slaClass bundles the queue settings suited to a kind of traffic, so an
+// endpoint can name what its clients need rather than tune each setting
+type slaClass struct {
+	Priority        int
+	Preemptive      bool
+	MaxConcurrent   int
+	QueueTimeout    int
+	UpstreamTimeout int
+}
+
+// slaClasses are the classes endpoints can name in class
+var slaClasses = map[string]slaClass{
+	// People waiting on an answer: first in line, taking capacity from the
+	// others when they need it, and failing fast rather than waiting long
+	"interactive": {Priority: 1, Preemptive: true, QueueTimeout: 30, UpstreamTimeout: 120},
+	// Services calling the API without someone watching
+	"standard": {Priority: 2, QueueTimeout: 300, UpstreamTimeout: 300},
+	// Offline jobs: whatever capacity is left, a few at a time, however long it takes
+	"batch": {Priority: 3, MaxConcurrent: 4, UpstreamTimeout: 600},
+}
+
+// applyClasses fills in the settings of endpoints with a class that they
+// leave out. Settings an endpoint sets itself, even to zero or false, win.
+func (c *Config) applyClasses(s *schema) {
+	for i := range c.Endpoints {
+		ep := &c.Endpoints[i]
+		if ep.Class == "" {
+			continue
+		}
+		path := fmt.Sprintf("endpoints[%d]", i)
+		class, ok := slaClasses[ep.Class]
+		if !ok {
+			s.problem(path+".class", "unknown class %q, expected interactive, standard or batch", ep.Class)
+			continue
+		}
+
+		unset := func(name string) bool {
+			_, ok := s.lines[path+"."+name]
+			return !ok
+		}
+		if unset("priority") {
+			ep.Priority = class.Priority
+		}
+		if unset("preemptive") {
+			ep.Preemptive = class.Preemptive
+		}
+		if unset("max_concurrent") {
+			ep.MaxConcurrent = class.MaxConcurrent
+		}
+		if unset("queue_timeout") {
+			ep.QueueTimeout = class.QueueTimeout
+		}
+		if unset("upstream_timeout") {
+			ep.UpstreamTimeout = class.UpstreamTimeout
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package config
 
 import (
-	"encoding/json"
 	"os"
+	"path/filepath"
 )
 
 // Config represents the application configuration
 type Config struct {
+	// Include merges more config files into this one, e.g. "conf.d/*.json"
+	// for an endpoint file per team; patterns are relative to this file
+	Include []string `json:"include"`
+	// Reload re-reads the config while the proxy runs when watched files
+	// change, e.g. a mounted Kubernetes ConfigMap or Secret
+	Reload ReloadConfig `json:"reload"`
+
 	InfluxDBURL string     `json:"influxdb_url"`
 	InfluxToken string     `json:"influx_token"`
 	InfluxOrg   string     `json:"influx_org"`
@@ -14,40 +21,1013 @@ type Config struct {
 	OpenAIAPIURL string    `json:"openai_api_url"`
 	OpenAIAPIKey string    `json:"openai_api_key"`
 	Endpoints   []Endpoint `json:"endpoints"`
+	// OpenAIAPIKeyFile holds the upstream API key instead of openai_api_key; it
+	// is watched, so writing a new key to it rotates the key without a restart
+	OpenAIAPIKeyFile string `json:"openai_api_key_file"`
+	// KeyRotationGrace is how many seconds the old upstream key stays usable
+	// after a rotation (default 300)
+	KeyRotationGrace int `json:"key_rotation_grace"`
+	// UpstreamReplicas are base URLs of interchangeable upstream replicas used instead of OpenAIAPIURL
+	UpstreamReplicas []string `json:"upstream_replicas"`
+	// ReplicaBalancing is what requests are pinned to one of UpstreamReplicas
+	// by: "session" (default) or "key"
+	ReplicaBalancing string `json:"replica_balancing"`
+	// ReplicaLoadFactor caps each replica's in-flight requests at this multiple
+	// of the average, moving the excess to other replicas (0 leaves them unbounded)
+	ReplicaLoadFactor float64 `json:"replica_load_factor"`
+	// Regions are deployments of the upstream in several regions, used instead
+	// of OpenAIAPIURL; requests go to the healthy region with the lowest latency
+	Regions []RegionConfig `json:"regions"`
+	// RegionRouting tunes how requests move between Regions
+	RegionRouting RegionRoutingConfig `json:"region_routing"`
+	// StreamIdleTimeout is the number of seconds an upstream response may go
+	// without sending data before it is aborted (0 disables the check)
+	StreamIdleTimeout int `json:"stream_idle_timeout"`
+	// StreamIdleRetries is how many times a request whose upstream stalls
+	// before sending anything is retried
+	StreamIdleRetries int `json:"stream_idle_retries"`
+	// SchedulerTickMs is how long the scheduler sleeps between dispatches, in
+	// milliseconds (default 10)
+	SchedulerTickMs int `json:"scheduler_tick_ms"`
+	// PreemptCheckMs is how often running requests check whether a higher
+	// priority request should preempt them, in milliseconds (default 50)
+	PreemptCheckMs int `json:"preempt_check_ms"`
+	// SchedulerTrace logs every scheduling decision, for diagnosing starvation
+	SchedulerTrace bool `json:"scheduler_trace"`
+	// RequeueBoost is how many queues a preempted or stalled request moves up
+	// when it is requeued (0 keeps it in its queue)
+	RequeueBoost int `json:"requeue_boost"`
+	// RetryBudget caps how many times one request is retried, over preemption
+	// and stall requeues and upstream retries together (0 = unlimited)
+	RetryBudget int `json:"retry_budget"`
+	// PreemptStreams cuts off streaming responses already under way when a
+	// preemptive queue has requests waiting, instead of letting them finish
+	PreemptStreams bool `json:"preempt_streams"`
+	// RetryRules override which requests may be replayed after preemption
+	RetryRules []RetryRule `json:"retry_rules"`
+	// AllowedMethods override which HTTP methods are forwarded for each path
+	AllowedMethods []MethodRule `json:"allowed_methods"`
+	// PathRules decide per path whether requests are queued, bypass the queues or are denied
+	PathRules []PathRule `json:"path_rules"`
+	// Fallback relays paths the proxy doesn't handle itself to the upstream as they are
+	Fallback FallbackConfig `json:"fallback"`
+	// UpstreamRetry controls which upstream failures are retried before the client sees them
+	UpstreamRetry UpstreamRetryConfig `json:"upstream_retry"`
+	// UpstreamIdentity sets how upstream requests identify this deployment
+	UpstreamIdentity UpstreamIdentityConfig `json:"upstream_identity"`
+	// UpstreamConnections replaces upstream connections so DNS changes are picked up
+	UpstreamConnections UpstreamConnectionsConfig `json:"upstream_connections"`
+	// BackendQuotas are the providers' per-minute limits on each upstream,
+	// keyed by upstream base URL, e.g. an Azure deployment's TPM
+	BackendQuotas map[string]BackendQuota `json:"backend_quotas"`
+	// Cache configures local caching of read-only GET endpoints
+	Cache CacheConfig `json:"cache"`
+	// StatusCache briefly caches status endpoints clients poll, like fine-tuning jobs
+	StatusCache CacheConfig `json:"status_cache"`
+	// ExposeUpstreamErrors returns raw upstream errors in the X-Proxy-Upstream-Error header
+	ExposeUpstreamErrors bool `json:"expose_upstream_errors"`
+	// Maintenance sets what clients are told while maintenance mode is on
+	Maintenance MaintenanceConfig `json:"maintenance"`
+	// DrainTimeout is how many seconds shutdown waits for accepted requests
+	// to finish before stopping the scheduler (default 60)
+	DrainTimeout int `json:"drain_timeout"`
+	// ReusePort listens with SO_REUSEPORT, so an upgraded proxy can start on the
+	// same ports while the old process drains
+	ReusePort bool `json:"reuse_port"`
+	// AdminPort is the port for the admin API (0 disables it)
+	AdminPort int `json:"admin_port"`
+	// AdminBindAddress is the address the admin API listens on (default all interfaces)
+	AdminBindAddress string `json:"admin_bind_address"`
+	// AdminTokens are the bearer tokens the admin API accepts; when empty it is open
+	AdminTokens []AdminToken `json:"admin_tokens"`
+	// GRPCPort is the port for the gRPC submission API (0 disables it)
+	GRPCPort int `json:"grpc_port"`
+	// GRPCBindAddress is the address the gRPC API listens on (default all interfaces)
+	GRPCBindAddress string `json:"grpc_bind_address"`
+	// GRPCAuth sets how clients of the gRPC API authenticate (default none),
+	// with the same modes as endpoints' auth
+	GRPCAuth AuthConfig `json:"grpc_auth"`
+	// Distributed configures a shared queue backend for running several replicas
+	Distributed DistributedConfig `json:"distributed"`
+	// RateLimits caps requests per client key and for the whole organization
+	RateLimits RateLimitConfig `json:"rate_limits"`
+	// KeyConcurrency caps the requests each client key has in flight at once
+	KeyConcurrency KeyConcurrencyConfig `json:"key_concurrency"`
+	// PriorityBoost lets authorized keys promote urgent requests to a higher queue
+	PriorityBoost PriorityBoostConfig `json:"priority_boost"`
+	// LargePrompts moves requests with very long prompts to a lower priority queue
+	LargePrompts LargePromptsConfig `json:"large_prompts"`
+	// TagKeys allowlists the X-Proxy-Tags keys recorded as metrics dimensions
+	TagKeys []string `json:"tag_keys"`
+	// Metrics guards the tags requests are written to InfluxDB with
+	Metrics MetricsConfig `json:"metrics"`
+	// InjectUser fills in the OpenAI `user` field from the client key when it is missing
+	InjectUser bool `json:"inject_user"`
+	// Pricing maps model names (or "prefix*" patterns) to their token prices
+	Pricing map[string]ModelPrice `json:"pricing"`
+	// Billing controls per-key usage accounting
+	Billing BillingConfig `json:"billing"`
+	// Quotas sets per-key token budgets that reset on a schedule
+	Quotas QuotaConfig `json:"quotas"`
+	// Archive records requests and responses for later analysis
+	Archive ArchiveConfig `json:"archive"`
+	// DebugCapture lets the admin API record full exchanges of chosen keys or request IDs for a while
+	DebugCapture DebugCaptureConfig `json:"debug_capture"`
+	// AccessLog writes an HTTP access log separate from the application log
+	AccessLog AccessLogConfig `json:"access_log"`
+	// OutcomeLog writes one JSON record of how each request ended
+	OutcomeLog OutcomeLogConfig `json:"outcome_log"`
+	// SlowRequests reports requests taking longer than a threshold
+	SlowRequests SlowRequestsConfig `json:"slow_requests"`
+	// Journal keeps writes such as file uploads from being applied twice
+	Journal JournalConfig `json:"journal"`
+	// ModelCatalogs limit the models each key sees in /v1/models and may invoke
+	ModelCatalogs ModelCatalogsConfig `json:"model_catalogs"`
+	// Preflight checks the upstream and InfluxDB at startup before reporting ready
+	Preflight PreflightConfig `json:"preflight"`
+	// QueueSnapshot lets the admin API move queued requests to another instance
+	QueueSnapshot QueueSnapshotConfig `json:"queue_snapshot"`
+	// LogSampling thins out the per-request lines of the application log
+	LogSampling LogSamplingConfig `json:"log_sampling"`
+	// Tracing exports request traces to an OTLP collector such as Jaeger or Tempo
+	Tracing TracingConfig `json:"tracing"`
+	// Secrets fetches the upstream API key and Influx token from a secrets manager
+	Secrets SecretsConfig `json:"secrets"`
+	// Routes pick the model and backend for requests from their characteristics
+	Routes []RouteRule `json:"routes"`
+	// ModelDiscovery routes models no route names to the backend whose /v1/models lists them
+	ModelDiscovery ModelDiscoveryConfig `json:"model_discovery"`
+	// SpeculativeBudget caps the extra upstream requests speculative routes make per minute
+	SpeculativeBudget int `json:"speculative_budget"`
+	// Downgrade switches requests to cheaper models while queues are backed up
+	Downgrade DowngradeConfig `json:"downgrade"`
+	// ModelDefaults are the parameters applied to a model's requests when clients omit them,
+	// keyed by model name or "prefix*" pattern
+	ModelDefaults map[string]ParamDefaults `json:"model_defaults"`
+	// OutputScan checks model output against denylists and a classifier before it reaches clients
+	OutputScan OutputScanConfig `json:"output_scan"`
+	// ContextTrim shortens conversations that don't fit their model's context window
+	ContextTrim ContextTrimConfig `json:"context_trim"`
+	// StructuredOutputs checks responses against the json_schema their request asked for
+	StructuredOutputs StructuredOutputsConfig `json:"structured_outputs"`
+	// Schedules change queue priorities, concurrency limits and rate limits
+	// during recurring windows, e.g. more capacity for batch work overnight
+	Schedules []ScheduleWindow `json:"schedules"`
 }
 
+// QueueCapacity is how many requests each endpoint's queue holds
+const QueueCapacity = 100
+
 // Endpoint represents a priority endpoint configuration
 type Endpoint struct {
-	Port       int    `json:"port"`
-	Priority   int    `json:"priority"`
-	Preemptive bool   `json:"preemptive"`
+	Port          int        `json:"port"`
+	BindAddress   string     `json:"bind_address"` // Address to listen on, e.g. "127.0.0.1" or "::" (default all interfaces)
+	Stack         string     `json:"stack"`        // "dual" (default), or "ipv4" or "ipv6" to listen on one IP version only
+	Class         string     `json:"class"`        // SLA class whose priority, preemption, concurrency and timeouts fill in those left unset: "interactive", "standard" or "batch"
+	Priority      int        `json:"priority"`
+	Preemptive    bool       `json:"preemptive"`
+	MaxConcurrent int        `json:"max_concurrent"`       // Requests from this port's queue run upstream at once (0 = unlimited)
+	MaxQueuedMB   int        `json:"max_queued_mb"`        // Request bodies this port's queue holds at once, in MiB (0 = unlimited)
+	SoftWatermark int        `json:"soft_watermark"`       // Queued requests past which responses advise clients to slow down (0 = never)
+	HardWatermark int        `json:"hard_watermark"`       // Queued requests at which new ones are rejected (0 = the queue's capacity)
+	AccessLog     *bool      `json:"access_log,omitempty"` // Write this port's requests to the access log (default true)
+	Auth          AuthConfig `json:"auth"`                 // How clients of this port authenticate (default none)
+	// QueueTimeout and UpstreamTimeout split the time this port's requests
+	// may take, in seconds: requests still queued after QueueTimeout are
+	// answered with a 504, and each upstream attempt is cut off after
+	// UpstreamTimeout (0 = no limit)
+	QueueTimeout    int `json:"queue_timeout"`
+	UpstreamTimeout int `json:"upstream_timeout"`
+	// Escalate moves requests that have waited too long in this port's queue
+	// to a higher priority one
+	Escalate []EscalationRule `json:"escalate"`
+}
+
+// EscalationRule moves a request up once it has been queued for a while
+type EscalationRule struct {
+	After int `json:"after"` // Seconds the request has waited in queues
+	Steps int `json:"steps"` // Queues it moves up, stopping short of preemptive ones (default 1)
+}
+
+// AccessLogged reports whether requests to the endpoint are written to the access log
+func (e Endpoint) AccessLogged() bool {
+	return e.AccessLog == nil || *e.AccessLog
+}
+
+// AdminToken grants access to the admin API
+type AdminToken struct {
+	Token string `json:"token"`
+	Scope string `json:"scope"` // "read" for inspection only, "admin" to also change state
+}
+
+// AuthConfig sets how clients of an endpoint authenticate
+type AuthConfig struct {
+	Mode string    `json:"mode"` // "none" (default), "keys", "jwt" or a mode registered by a program embedding the proxy
+	Keys []string  `json:"keys"` // Bearer keys accepted in keys mode
+	JWT  JWTConfig `json:"jwt"`  // Token validation in jwt mode
+	// Options are the settings of a registered mode, which it interprets itself
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// JWTConfig sets how bearer JWTs are validated
+type JWTConfig struct {
+	Secret        string `json:"secret"`          // Shared secret for HS256 tokens
+	PublicKeyFile string `json:"public_key_file"` // PEM RSA public key for RS256 tokens
+	Issuer        string `json:"issuer"`          // Required "iss" claim, empty accepts any
+	Audience      string `json:"audience"`        // Required "aud" claim, empty accepts any
+}
+
+// RetryRule classifies whether requests to matching paths can be safely replayed
+type RetryRule struct {
+	Path      string `json:"path"`   // path.Match pattern, a trailing "/**" also matches all sub-paths
+	Method    string `json:"method"` // HTTP method to match, empty matches any method
+	Retryable bool   `json:"retryable"`
+}
+
+// MethodRule sets the HTTP methods forwarded for requests to matching paths
+type MethodRule struct {
+	Path    string   `json:"path"`    // path.Match pattern, a trailing "/**" also matches all sub-paths
+	Methods []string `json:"methods"` // Methods allowed, anything else is refused with 405
+}
+
+// PathRule decides how requests to matching paths are handled
+type PathRule struct {
+	Path     string `json:"path"`     // path.Match pattern, a trailing "/**" also matches all sub-paths
+	Action   string `json:"action"`   // "queue" (default), "bypass" to skip the queues, "deny" or "respond"
+	Priority int    `json:"priority"` // Queue to use instead of the port's (0 keeps the port's)
+	Body     string `json:"body"`     // What "respond" answers with (default {"status":"ok"})
+}
+
+// ReloadConfig sets which files are watched for config changes
+type ReloadConfig struct {
+	Watch    []string `json:"watch"`    // Files or directories to watch, e.g. ConfigMap and Secret mount points
+	Interval int      `json:"interval"` // Seconds between checks (default 10)
+}
+
+// FallbackConfig configures relaying requests for paths the proxy doesn't handle
+type FallbackConfig struct {
+	Enabled    bool     `json:"enabled"`
+	Target     string   `json:"target"`      // Upstream base URL (default openai_api_url)
+	Action     string   `json:"action"`      // "bypass" (default) to skip the queues, or "queue"
+	Priority   int      `json:"priority"`    // Queue to wait in when queued (0 keeps the port's)
+	KnownPaths []string `json:"known_paths"` // Paths the proxy handles itself (default the OpenAI endpoints it supports)
+}
+
+// UpstreamConnectionsConfig sets when upstream connections are replaced, so
+// new addresses behind the upstream's host name are used without a restart
+type UpstreamConnectionsConfig struct {
+	MaxAge          int `json:"max_age"`          // Seconds connections are kept before they are replaced (default 0, kept for as long as the upstream allows)
+	ResolveInterval int `json:"resolve_interval"` // Seconds between lookups of upstream hosts, connections being replaced when their addresses change (default 30, -1 disables)
+}
+
+// UpstreamRetryConfig classifies which upstream errors are retried
+type UpstreamRetryConfig struct {
+	MaxRetries    int                              `json:"max_retries"`    // Additional attempts after the first (0 disables retries)
+	Backoff       int                              `json:"backoff_ms"`     // Milliseconds before the first retry, doubled for each one after
+	Status        []int                            `json:"status"`         // Status codes retried (default 429, 502, 503, 504)
+	ErrorTypes    map[string]bool                  `json:"error_types"`    // Error types or codes that are always (true) or never (false) retried
+	NetworkErrors []string                         `json:"network_errors"` // "timeout", "connection_refused", "connection_reset", "dns", "tls" or "other" (default all)
+	Backends      map[string]UpstreamRetryOverride `json:"backends"`       // Overrides keyed by upstream base URL
+}
+
+// UpstreamRetryOverride changes the retry settings for one upstream. Fields
+// left unset keep the defaults; MaxRetries is a pointer so a backend can
+// turn retries off with 0.
+type UpstreamRetryOverride struct {
+	MaxRetries    *int            `json:"max_retries"`
+	Backoff       int             `json:"backoff_ms"`
+	Status        []int           `json:"status"`
+	ErrorTypes    map[string]bool `json:"error_types"`
+	NetworkErrors []string        `json:"network_errors"`
+}
+
+// ForBackend returns the retry settings for one upstream with its overrides
+// applied. Error types are merged, every other field set on the override
+// replaces the default.
+func (c UpstreamRetryConfig) ForBackend(url string) UpstreamRetryConfig {
+	merged := c
+	merged.Backends = nil
+
+	override, ok := c.Backends[url]
+	if !ok {
+		return merged
+	}
+
+	if override.MaxRetries != nil {
+		merged.MaxRetries = *override.MaxRetries
+	}
+	if override.Backoff != 0 {
+		merged.Backoff = override.Backoff
+	}
+	if override.Status != nil {
+		merged.Status = override.Status
+	}
+	if override.NetworkErrors != nil {
+		merged.NetworkErrors = override.NetworkErrors
+	}
+	if len(override.ErrorTypes) > 0 {
+		merged.ErrorTypes = make(map[string]bool, len(c.ErrorTypes)+len(override.ErrorTypes))
+		for kind, retry := range c.ErrorTypes {
+			merged.ErrorTypes[kind] = retry
+		}
+		for kind, retry := range override.ErrorTypes {
+			merged.ErrorTypes[kind] = retry
+		}
+	}
+	return merged
+}
+
+// UpstreamIdentityConfig sets the User-Agent and extra headers sent with
+// upstream requests, so providers attribute traffic to this deployment
+type UpstreamIdentityConfig struct {
+	UserAgent string                            `json:"user_agent"` // Sent as the User-Agent header (default "mule-proxy")
+	Headers   map[string]string                 `json:"headers"`    // Extra headers, e.g. OpenAI-Organization or X-Deployment
+	Backends  map[string]UpstreamIdentityConfig `json:"backends"`   // Overrides keyed by upstream base URL
+}
+
+// ForBackend returns the identity for one upstream with its overrides
+// applied. Headers are merged, with the override's winning; a User-Agent
+// set on the override replaces the default.
+func (c UpstreamIdentityConfig) ForBackend(url string) UpstreamIdentityConfig {
+	merged := c
+	merged.Backends = nil
+
+	override, ok := c.Backends[url]
+	if !ok {
+		return merged
+	}
+
+	if override.UserAgent != "" {
+		merged.UserAgent = override.UserAgent
+	}
+	if len(override.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(c.Headers)+len(override.Headers))
+		for key, value := range c.Headers {
+			merged.Headers[key] = value
+		}
+		for key, value := range override.Headers {
+			merged.Headers[key] = value
+		}
+	}
+	return merged
+}
+
+// CacheConfig controls the local response cache for read-only endpoints
+type CacheConfig struct {
+	Enabled    bool     `json:"enabled"`
+	TTL        int      `json:"ttl"`         // Seconds a cached response is served without revalidation
+	Paths      []string `json:"paths"`       // GET path patterns to cache
+	MaxEntries int      `json:"max_entries"` // Upper bound on cached responses
+}
+
+// DistributedConfig selects a shared queue backend so replicas form one logical queue
+type DistributedConfig struct {
+	Backend     string `json:"backend"`      // "redis", "nats", or empty to keep queues in-process
+	RedisURL    string `json:"redis_url"`    // e.g. redis://localhost:6379/0
+	NATSURL     string `json:"nats_url"`     // e.g. nats://localhost:4222
+	AckWait     int    `json:"ack_wait"`     // Seconds before an unacknowledged NATS job is redelivered
+	KeyPrefix   string `json:"key_prefix"`   // Prefix for backend keys
+	MaxInflight int    `json:"max_inflight"` // Jobs this replica pulls and runs concurrently
+	ResultTTL   int    `json:"result_ttl"`   // Seconds an unclaimed result is kept
+	// LeaderElection runs scheduled quota resets on one elected replica only
+	LeaderElection bool   `json:"leader_election"`
+	LeaseTTL       int    `json:"lease_ttl"`  // Seconds the leader's lease lasts without renewal
+	ReplicaID      string `json:"replica_id"` // Names this replica in the election, defaults to hostname-pid
+}
+
+// RateLimitConfig sets fixed-window request limits. Counters live in
+// memory by default, or in Redis so all replicas share one budget.
+type RateLimitConfig struct {
+	Window         int              `json:"window"`           // Seconds per counting window
+	RequestsPerKey int64            `json:"requests_per_key"` // Default limit for each client key (0 = unlimited)
+	OrgRequests    int64            `json:"org_requests"`     // Limit across all keys (0 = unlimited)
+	KeyLimits      map[string]int64 `json:"key_limits"`       // Per-key overrides, keyed by client API key
+	Burst          int64            `json:"burst"`            // Extra requests a key may make in a window, out of what it left unused in the previous one (0 = none)
+	Store          string           `json:"store"`            // "memory" or "redis"
+	RedisURL       string           `json:"redis_url"`        // Redis URL for the shared store
+	KeyPrefix      string           `json:"key_prefix"`       // Prefix for Redis keys
+}
+
+// KeyConcurrencyConfig caps the requests each client key has in flight,
+// the rest waiting in their queue
+type KeyConcurrencyConfig struct {
+	Default int            `json:"default"` // In-flight requests per key (0 = unlimited)
+	Keys    map[string]int `json:"keys"`    // Per-key overrides, keyed by client API key
+}
+
+// Enabled reports whether any key's concurrency is capped
+func (c KeyConcurrencyConfig) Enabled() bool {
+	return c.Default > 0 || len(c.Keys) > 0
+}
+
+// PriorityBoostConfig lists the client keys allowed to send X-Priority-Boost
+type PriorityBoostConfig struct {
+	Keys     []string `json:"keys"`     // Client API keys allowed to boost
+	Priority int      `json:"priority"` // Queue priority boosted requests run at (0 = highest configured)
+}
+
+// LargePromptsConfig sends requests with very long prompts to a lower priority queue
+type LargePromptsConfig struct {
+	MinTokens int `json:"min_tokens"` // Estimated input tokens from which a request is moved (0 disables)
+	Priority  int `json:"priority"`   // Queue priority large requests run at (0 = lowest configured)
+}
+
+// ModelPrice is the price of a model's tokens in USD per million tokens
+type ModelPrice struct {
+	Input           float64 `json:"input"`
+	Output          float64 `json:"output"`
+	MaxOutputTokens int64   `json:"max_output_tokens"` // Output budget assumed when a request sets no limit
+	ContextWindow   int64   `json:"context_window"`    // Most input plus output tokens the model accepts (0 = unchecked)
+}
+
+// BillingConfig controls the usage ledger behind billing exports
+type BillingConfig struct {
+	UsageFile             string `json:"usage_file"`              // JSON file usage is persisted to (empty keeps it in memory)
+	BillPreemptedAttempts bool   `json:"bill_preempted_attempts"` // Bill the prompt of every preempted attempt
+}
+
+// QuotaConfig sets token budgets per client key that reset every period
+type QuotaConfig struct {
+	Period      string           `json:"period"`       // "daily", "weekly" or "monthly"
+	Timezone    string           `json:"timezone"`     // IANA timezone periods start in, e.g. "America/New_York"
+	Tokens      int64            `json:"tokens"`       // Default budget per key per period (0 = unlimited)
+	KeyTokens   map[string]int64 `json:"key_tokens"`   // Per-key overrides, keyed by client API key
+	Rollover    bool             `json:"rollover"`     // Carry unused budget into the next period
+	MaxRollover int64            `json:"max_rollover"` // Cap on carried budget (0 = one period's budget)
+	Precheck    bool             `json:"precheck"`     // Reject requests whose estimated tokens exceed the remaining budget
+}
+
+// Enabled reports whether any quota is configured
+func (c QuotaConfig) Enabled() bool {
+	return c.Tokens > 0 || len(c.KeyTokens) > 0
+}
+
+// RegionConfig is one region an upstream is deployed in
+type RegionConfig struct {
+	Name string `json:"name"` // e.g. "eastus"
+	URL  string `json:"url"`  // Base URL of the upstream in this region
+}
+
+// RegionRoutingConfig tunes how requests move between regions
+type RegionRoutingConfig struct {
+	ProbeInterval    int     `json:"probe_interval"`    // Seconds between latency probes of every region (default 10, -1 measures requests instead)
+	ProbePath        string  `json:"probe_path"`        // Path probed (default /v1/models)
+	FailureThreshold int     `json:"failure_threshold"` // Failures in a row that take a region out (default 3)
+	FailbackAfter    int     `json:"failback_after"`    // Seconds a region must have been healthy before traffic moves to it (default 60)
+	SwitchMargin     float64 `json:"switch_margin"`     // How much lower another region's latency must be to move to it, as a fraction (default 0.2)
+	SlowStart        int     `json:"slow_start"`        // Seconds a recovered region takes to ramp up to full traffic (default 30, -1 disables)
+	TrialAfter       int     `json:"trial_after"`       // Seconds before a request tries an unhealthy region again when probing is off (default 30)
+}
+
+// RouteRule sends requests matching its conditions to a model and/or backend
+type RouteRule struct {
+	Name    string     `json:"name"`    // Shown in logs
+	Match   RouteMatch `json:"match"`   // Conditions a request must meet, all of them
+	Model   string     `json:"model"`   // Model used instead of the requested one (empty keeps it)
+	Backend string     `json:"backend"` // Upstream base URL requests are sent to (empty uses the default)
+	// Speculative is a second upstream base URL non-streaming requests are
+	// sent to at the same time, the first success being returned
+	Speculative string `json:"speculative"`
+	// Priority is the queue requests wait in instead of their port's (0 keeps it)
+	Priority int `json:"priority"`
+}
+
+// RouteMatch lists the request characteristics a route requires; unset fields match anything
+type RouteMatch struct {
+	Models           []string `json:"models"`             // Requested models, a trailing "*" matches any suffix
+	MinContextTokens int64    `json:"min_context_tokens"` // Prompt tokens at least this
+	MaxContextTokens int64    `json:"max_context_tokens"` // Prompt tokens at most this
+	MinMaxTokens     int64    `json:"min_max_tokens"`     // Requested max_tokens at least this
+	Tools            *bool    `json:"tools"`              // Whether the request offers tools
+	Images           *bool    `json:"images"`             // Whether the prompt contains images
+}
+
+// ModelDiscoveryConfig lists the backends whose models are discovered
+type ModelDiscoveryConfig struct {
+	Backends []string `json:"backends"` // Upstream base URLs to list models from, most preferred first
+	Interval int      `json:"interval"` // Seconds between refreshes (default 60)
+	Conflict string   `json:"conflict"` // Backend a model several list goes to: "first" (default) or "sticky"
+}
+
+// DowngradeConfig moves requests to cheaper models while their queue's recent wait is high
+type DowngradeConfig struct {
+	MaxWait    int               `json:"max_wait_ms"` // Queue wait in milliseconds, recent or expected, that triggers downgrades (0 disables)
+	Window     int               `json:"window"`      // Seconds of recent waits averaged (default 30)
+	Models     map[string]string `json:"models"`      // Model name to the cheaper model used in its place
+	Priorities []int             `json:"priorities"`  // Queues whose requests may be downgraded (default all)
+}
+
+// Enabled reports whether downgrades are configured
+func (c DowngradeConfig) Enabled() bool {
+	return c.MaxWait > 0 && len(c.Models) > 0
+}
+
+// ParamDefaults are sampling parameters applied to completions that don't
+// set them; unset ones are left to the upstream
+type ParamDefaults struct {
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	MaxTokens   int      `json:"max_tokens"` // 0 leaves it unset
+	Stop        []string `json:"stop"`
+}
+
+// OutputScanConfig redacts or blocks generated text that matches a rule or
+// that a classifier flags
+type OutputScanConfig struct {
+	Rules      []OutputScanRule       `json:"rules"`
+	Classifier OutputClassifierConfig `json:"classifier"`
+	Window      int                    `json:"window"`       // Bytes of streamed text each classifier check covers and redaction holds back (default 1000)
+	Timeout     int                    `json:"timeout_ms"`   // Milliseconds each classifier check may take (default 2000)
+	FailClosed  bool                   `json:"fail_closed"`  // Block output that couldn't be checked instead of passing it
+	MaxResponse int                    `json:"max_response"` // Bytes of a complete response held for scanning (default 8 MiB)
+}
+
+// Enabled reports whether any check is configured
+func (c OutputScanConfig) Enabled() bool {
+	return len(c.Rules) > 0 || c.Classifier.URL != ""
+}
+
+// OutputScanRule is a regular expression generated text mustn't match
+type OutputScanRule struct {
+	Name        string `json:"name"`        // Reported when the rule fires (default the pattern)
+	Pattern     string `json:"pattern"`     // Go regular expression
+	Action      string `json:"action"`      // "block" (default) or "redact"
+	Replacement string `json:"replacement"` // What redacted matches are replaced with (default "[REDACTED]")
+}
+
+// OutputClassifierConfig names a classifier speaking the OpenAI moderations API
+type OutputClassifierConfig struct {
+	URL    string `json:"url"`     // Endpoint text is POSTed to, e.g. https://api.openai.com/v1/moderations
+	APIKey string `json:"api_key"` // Sent as a bearer token when set
+}
+
+// ContextTrimConfig trims the oldest messages of chat requests longer than
+// their model's context_window in pricing
+type ContextTrimConfig struct {
+	Strategy      string `json:"strategy"`       // "drop_oldest" or "summarize" (empty disables trimming)
+	SummaryModel  string `json:"summary_model"`  // Model writing summaries (default the request's model)
+	SummaryTokens int64  `json:"summary_tokens"` // Most tokens a summary takes (default 500)
+	Timeout       int    `json:"timeout"`        // Seconds a summary may take before messages are dropped instead (default 30)
+}
+
+// StructuredOutputsConfig checks the responses of chat requests with a
+// json_schema response_format against their schema
+type StructuredOutputsConfig struct {
+	Validate bool `json:"validate"` // Check complete responses, answering violations with an error
+	Retry    bool `json:"retry"`    // Retry a violating response once before answering with the error
+}
+
+// ArchiveConfig controls archival of requests and their responses
+type ArchiveConfig struct {
+	File         string `json:"file"`           // JSON lines file records are appended to (empty disables archival)
+	MaxBodyBytes int    `json:"max_body_bytes"` // Bytes of each request and response kept (default 1 MiB)
+	Buffer       int    `json:"buffer"`         // Response chunks queued for the archiver before new ones are dropped (default 4096)
+}
+
+// BackendQuota is a provider's limit on an upstream, which the proxy
+// tracks usage against; zero is no limit
+type BackendQuota struct {
+	TokensPerMinute   int64 `json:"tokens_per_minute"`
+	RequestsPerMinute int64 `json:"requests_per_minute"`
+}
+
+// DebugCaptureConfig controls captures started through the admin API
+type DebugCaptureConfig struct {
+	Dir          string `json:"dir"`            // Directory capture files are written to (empty disables captures)
+	MaxBodyBytes int    `json:"max_body_bytes"` // Bytes of each request and response kept (default 1 MiB)
+	MaxMinutes   int    `json:"max_minutes"`    // Longest a capture may run (default 60)
+}
+
+// AccessLogConfig configures the HTTP access log
+type AccessLogConfig struct {
+	File       string `json:"file"`        // File lines are appended to (empty disables the access log)
+	Format     string `json:"format"`      // "common", "combined" or "json" (default "combined")
+	MaxSizeMB  int    `json:"max_size_mb"` // Size the file is rotated at (default 100)
+	MaxBackups int    `json:"max_backups"` // Rotated files kept (default 5)
+}
+
+// MetricsConfig bounds the InfluxDB tags requests are written with. Every
+// distinct combination of tag values is a series of its own, so unbounded
+// values such as user IDs would make the database grow without end.
+type MetricsConfig struct {
+	MaxTagValues int    `json:"max_tag_values"` // Distinct values kept per tag key, later ones written as "_other" (default 1000, -1 = unlimited)
+	Users        string `json:"users"`          // How end users are tagged: "hash" (default), "raw" or "none"
+	UserSalt     string `json:"user_salt"`      // Mixed into hashed user IDs
+}
+
+// OutcomeLogConfig configures the request outcome log
+type OutcomeLogConfig struct {
+	File       string `json:"file"`        // File outcomes are appended to as JSON lines (empty disables the outcome log)
+	MaxSizeMB  int    `json:"max_size_mb"` // Size the file is rotated at (default 100)
+	MaxBackups int    `json:"max_backups"` // Rotated files kept (default 5)
+}
+
+// SlowRequestsConfig flags requests taking longer than a threshold, from
+// arrival to their last byte
+type SlowRequestsConfig struct {
+	Threshold int            `json:"threshold_ms"` // Milliseconds past which a request is slow (0 = only the models listed)
+	Models    map[string]int `json:"models"`       // Thresholds in milliseconds by model name or "prefix*" pattern
+	Keep      int            `json:"keep"`         // Slowest requests listed by /admin/slow (default 50)
+	Window    int            `json:"window"`       // Seconds the slowest requests are kept for (default 3600)
+}
+
+// Enabled reports whether any threshold is set
+func (c SlowRequestsConfig) Enabled() bool {
+	return c.Threshold > 0 || len(c.Models) > 0
+}
+
+// JournalConfig records writes that mustn't be applied twice before they
+// are dispatched, answering clients' retries of them from the journal
+type JournalConfig struct {
+	Enabled      bool `json:"enabled"`
+	TTL          int  `json:"ttl"`           // Seconds settled writes are remembered for (default 86400)
+	AmbiguousTTL int  `json:"ambiguous_ttl"` // Seconds ambiguous writes are kept unless cleared (default 604800)
+}
+
+// ModelCatalogsConfig limits the models keys see and may invoke. Catalogs
+// list model names and "prefix*" patterns.
+type ModelCatalogsConfig struct {
+	Default []string            `json:"default"` // For keys without their own; empty leaves them unrestricted
+	Keys    map[string][]string `json:"keys"`    // Keyed by API key, or "sub:<subject>" for clients with a subject, e.g. JWT clients
+}
+
+// PreflightConfig checks at startup that the upstream answers and accepts
+// the API key and that InfluxDB is reachable, holding readiness until they
+// do. With --fail-fast a failed check exits instead.
+type PreflightConfig struct {
+	Enabled  bool `json:"enabled"`
+	Timeout  int  `json:"timeout"`  // Seconds each check may take (default 10)
+	Interval int  `json:"interval"` // Seconds between retries of failed checks (default 15)
+}
+
+// QueueSnapshotConfig enables /admin/queue/export and /admin/queue/import
+type QueueSnapshotConfig struct {
+	// Key is the passphrase snapshots are encrypted with, shared by the
+	// instances exchanging them (empty disables snapshots)
+	Key string `json:"key"`
+}
+
+// LogSamplingConfig sets which per-request lines the application log keeps.
+// Failed and preempted requests are always logged, even over MaxPerSecond.
+type LogSamplingConfig struct {
+	SuccessEvery int `json:"success_every"`  // Log one in this many successful requests (0 or 1 logs all)
+	MaxPerSecond int `json:"max_per_second"` // Most request lines logged per second, the successes over it counted (0 = unlimited)
+}
+
+// TracingConfig sets where request traces are exported. Jaeger, Tempo and
+// other collectors accept OTLP directly.
+type TracingConfig struct {
+	Endpoint           string            `json:"endpoint"`            // Collector address, e.g. "localhost:4317" or "https://tempo:4318" (empty disables tracing)
+	Protocol           string            `json:"protocol"`            // "grpc" (default) or "http"
+	Insecure           bool              `json:"insecure"`            // Export without TLS
+	Headers            map[string]string `json:"headers"`             // Sent with every export, e.g. for authentication
+	SampleRatio        float64           `json:"sample_ratio"`        // Fraction of traces started here that are sampled (default 1)
+	ServiceName        string            `json:"service_name"`        // Reported service.name (default "mule-proxy")
+	ResourceAttributes map[string]string `json:"resource_attributes"` // Extra resource attributes, e.g. deployment.environment
+	// Baggage sends each request's tenant, key ID and priority upstream as W3C baggage
+	Baggage bool `json:"baggage"`
+	// TenantTag is the X-Proxy-Tags key naming a request's tenant, tried before its JWT subject
+	TenantTag string `json:"tenant_tag"`
+	// IdentityHeaders sends those identifiers upstream in headers, keyed by
+	// "tenant", "key_id" or "priority", e.g. {"tenant": "X-Tenant-Id"}
+	IdentityHeaders map[string]string `json:"identity_headers"`
+}
+
+// SecretsConfig fetches credentials from a secrets manager instead of this file.
+// References are a Vault path or AWS secret ID, with "#field" picking one field
+// of a secret holding several (e.g. "secret/data/proxy#openai_api_key").
+type SecretsConfig struct {
+	Provider     string          `json:"provider"`       // "vault" or "aws" (empty disables the secrets manager)
+	Vault        VaultConfig     `json:"vault"`          // Vault connection, for the vault provider
+	AWS          AWSSecretConfig `json:"aws"`            // AWS connection, for the aws provider
+	OpenAIAPIKey string          `json:"openai_api_key"` // Reference replacing openai_api_key
+	InfluxToken  string          `json:"influx_token"`   // Reference replacing influx_token
+	Refresh      int             `json:"refresh"`        // Seconds between checks for rotated secrets (default 300)
+}
+
+// VaultConfig connects to HashiCorp Vault
+type VaultConfig struct {
+	Address   string `json:"address"`    // Vault URL (default $VAULT_ADDR)
+	Token     string `json:"token"`      // Vault token (default $VAULT_TOKEN)
+	TokenFile string `json:"token_file"` // File holding the token, re-read on every fetch, e.g. a Vault Agent sink
+	Namespace string `json:"namespace"`  // Vault Enterprise namespace
 }
 
-// LoadConfig loads the configuration from a file
+// AWSSecretConfig connects to AWS Secrets Manager. Credentials come from the
+// standard AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+type AWSSecretConfig struct {
+	Region   string `json:"region"`   // AWS region (default $AWS_REGION)
+	Endpoint string `json:"endpoint"` // Overrides the regional endpoint, e.g. for a VPC endpoint
+}
+
+// Enabled reports whether a secrets manager is configured
+func (c SecretsConfig) Enabled() bool {
+	return c.Provider != ""
+}
+
+// Enabled reports whether the access log is configured
+func (c AccessLogConfig) Enabled() bool {
+	return c.File != ""
+}
+
+// Enabled reports whether the outcome log is configured
+func (c OutcomeLogConfig) Enabled() bool {
+	return c.File != ""
+}
+
+// Enabled reports whether archival is configured
+func (c ArchiveConfig) Enabled() bool {
+	return c.File != ""
+}
+
+// MaintenanceConfig sets the defaults for maintenance mode
+type MaintenanceConfig struct {
+	Message    string `json:"message"`     // Error message returned to refused requests
+	RetryAfter int    `json:"retry_after"` // Seconds clients are told to wait
+}
+
+// Enabled reports whether any rate limit is configured
+func (c RateLimitConfig) Enabled() bool {
+	return c.RequestsPerKey > 0 || c.OrgRequests > 0 || len(c.KeyLimits) > 0
+}
+
+// ScheduleWindow changes settings for as long as the current minute matches a cron expression
+type ScheduleWindow struct {
+	Name       string             `json:"name"`        // Shown in logs and /admin/status
+	Cron       string             `json:"cron"`        // Minutes the window is active, e.g. "* 0-5 * * *" for 00:00 to 06:00
+	Timezone   string             `json:"timezone"`    // IANA timezone the expression is read in (default UTC)
+	Queues     []QueueSchedule    `json:"queues"`      // Queue settings changed during the window
+	RateLimits *RateLimitSchedule `json:"rate_limits"` // Rate limits used during the window
+}
+
+// QueueSchedule changes a queue's settings during a schedule window
+type QueueSchedule struct {
+	Port          int `json:"port"`           // Port of the queue to change
+	Priority      int `json:"priority"`       // Priority the queue is dispatched at (0 keeps the configured one)
+	MaxConcurrent int `json:"max_concurrent"` // Requests run at once (0 keeps the configured limit, -1 lifts it)
+}
+
+// RateLimitSchedule changes rate limits during a schedule window; 0 keeps
+// the configured limit and -1 lifts it
+type RateLimitSchedule struct {
+	RequestsPerKey int64 `json:"requests_per_key"`
+	OrgRequests    int64 `json:"org_requests"`
+}
+
+// ScheduledRateLimits reports whether any schedule window changes rate limits
+func (c *Config) ScheduledRateLimits() bool {
+	for _, w := range c.Schedules {
+		if w.RateLimits != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig loads the configuration from a file. A file with mistakes in it
+// is refused with Problems listing them all.
 func LoadConfig(filePath string) (*Config, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	err = json.Unmarshal(data, &config)
+	// Settings the proxy doesn't know are usually typos, so they're refused
+	// rather than silently ignored
+	config, s, err := decode("", data)
 	if err != nil {
 		return nil, err
 	}
+	if err := include(config, s, filepath.Dir(filePath)); err != nil {
+		return nil, err
+	}
+	if len(s.problems) > 0 {
+		return nil, s.problems
+	}
+
+	// Endpoints naming an SLA class get its settings where they set none
+	config.applyClasses(s)
 
 	// Set defaults if not specified
 	if config.OpenAIAPIURL == "" {
 		config.OpenAIAPIURL = "https://api.openai.com/v1"
 	}
+
+	if config.Reload.Interval == 0 {
+		config.Reload.Interval = 10
+	}
+
+	if config.Fallback.Target == "" {
+		config.Fallback.Target = config.OpenAIAPIURL
+	}
+
+	if config.Fallback.Action == "" {
+		config.Fallback.Action = "bypass"
+	}
+
+	if len(config.Fallback.KnownPaths) == 0 {
+		config.Fallback.KnownPaths = []string{
+			"/v1/chat/completions/**", "/v1/completions", "/v1/responses/**", "/v1/embeddings",
+			"/v1/moderations", "/v1/images/**", "/v1/audio/**", "/v1/models/**", "/v1/files/**",
+			"/v1/assistants/**", "/v1/threads/**", "/v1/vector_stores/**", "/v1/fine_tuning/**",
+		}
+	}
 	
 	if config.InfluxBucket == "" {
 		config.InfluxBucket = "proxybucket"
 	}
 	
+	if config.Metrics.MaxTagValues == 0 {
+		config.Metrics.MaxTagValues = 1000
+	}
+
 	if config.InfluxOrg == "" {
 		config.InfluxOrg = "openaiorg"
 	}
 
-	return &config, nil
+	if config.RegionRouting.ProbeInterval == 0 {
+		config.RegionRouting.ProbeInterval = 10
+	}
+
+	if config.RegionRouting.FailbackAfter == 0 {
+		config.RegionRouting.FailbackAfter = 60
+	}
+
+	if config.RegionRouting.SlowStart == 0 {
+		config.RegionRouting.SlowStart = 30
+	}
+
+	if config.RegionRouting.TrialAfter == 0 {
+		config.RegionRouting.TrialAfter = 30
+	}
+
+	if config.ModelDiscovery.Interval == 0 {
+		config.ModelDiscovery.Interval = 60
+	}
+
+	if config.KeyRotationGrace == 0 {
+		config.KeyRotationGrace = 300
+	}
+
+	if config.SchedulerTickMs == 0 {
+		config.SchedulerTickMs = 10
+	}
+
+	if config.PreemptCheckMs == 0 {
+		config.PreemptCheckMs = 50
+	}
+
+	if config.UpstreamRetry.Backoff == 0 {
+		config.UpstreamRetry.Backoff = 500
+	}
+
+	if config.UpstreamConnections.ResolveInterval == 0 {
+		config.UpstreamConnections.ResolveInterval = 30
+	}
+
+	if config.UpstreamIdentity.UserAgent == "" {
+		config.UpstreamIdentity.UserAgent = "mule-proxy"
+	}
+
+	if config.Cache.TTL == 0 {
+		config.Cache.TTL = 300
+	}
+
+	if len(config.Cache.Paths) == 0 {
+		config.Cache.Paths = []string{"/v1/models", "/v1/models/*"}
+	}
+
+	if config.Cache.MaxEntries == 0 {
+		config.Cache.MaxEntries = 1000
+	}
+
+	if config.StatusCache.TTL == 0 {
+		config.StatusCache.TTL = 5
+	}
+
+	if len(config.StatusCache.Paths) == 0 {
+		config.StatusCache.Paths = []string{"/v1/fine_tuning/jobs", "/v1/fine_tuning/jobs/**"}
+	}
+
+	if config.StatusCache.MaxEntries == 0 {
+		config.StatusCache.MaxEntries = 1000
+	}
+
+	if config.Maintenance.Message == "" {
+		config.Maintenance.Message = "Service is under maintenance, please try again later"
+	}
+
+	if config.Maintenance.RetryAfter == 0 {
+		config.Maintenance.RetryAfter = 60
+	}
+
+	if config.Tracing.Protocol == "" {
+		config.Tracing.Protocol = "grpc"
+	}
+
+	if config.Tracing.SampleRatio == 0 {
+		config.Tracing.SampleRatio = 1
+	}
+
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "mule-proxy"
+	}
+
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = 60
+	}
+
+	if config.SpeculativeBudget == 0 {
+		config.SpeculativeBudget = 60
+	}
+
+	if config.Downgrade.Window == 0 {
+		config.Downgrade.Window = 30
+	}
+
+	if config.Archive.MaxBodyBytes == 0 {
+		config.Archive.MaxBodyBytes = 1 << 20
+	}
+
+	if config.Archive.Buffer == 0 {
+		config.Archive.Buffer = 4096
+	}
+
+	if config.DebugCapture.MaxBodyBytes == 0 {
+		config.DebugCapture.MaxBodyBytes = 1 << 20
+	}
+
+	if config.DebugCapture.MaxMinutes == 0 {
+		config.DebugCapture.MaxMinutes = 60
+	}
+
+	if config.AccessLog.Format == "" {
+		config.AccessLog.Format = "combined"
+	}
+
+	if config.AccessLog.MaxSizeMB == 0 {
+		config.AccessLog.MaxSizeMB = 100
+	}
+
+	if config.AccessLog.MaxBackups == 0 {
+		config.AccessLog.MaxBackups = 5
+	}
+
+	if config.OutcomeLog.MaxSizeMB == 0 {
+		config.OutcomeLog.MaxSizeMB = 100
+	}
+
+	if config.OutcomeLog.MaxBackups == 0 {
+		config.OutcomeLog.MaxBackups = 5
+	}
+
+	if config.Secrets.Refresh == 0 {
+		config.Secrets.Refresh = 300
+	}
+
+	if config.Quotas.Period == "" {
+		config.Quotas.Period = "monthly"
+	}
+
+	if config.Quotas.Timezone == "" {
+		config.Quotas.Timezone = "UTC"
+	}
+
+	if config.Distributed.KeyPrefix == "" {
+		config.Distributed.KeyPrefix = "proxy"
+	}
+
+	if config.Distributed.LeaseTTL == 0 {
+		config.Distributed.LeaseTTL = 15
+	}
+
+	if config.Distributed.MaxInflight == 0 {
+		config.Distributed.MaxInflight = 4
+	}
+
+	if config.Distributed.ResultTTL == 0 {
+		config.Distributed.ResultTTL = 300
+	}
+
+	if config.Distributed.AckWait == 0 {
+		config.Distributed.AckWait = 60
+	}
+
+	if config.RateLimits.Window == 0 {
+		config.RateLimits.Window = 60
+	}
+
+	if config.RateLimits.Store == "" {
+		config.RateLimits.Store = "memory"
+	}
+
+	if config.RateLimits.KeyPrefix == "" {
+		config.RateLimits.KeyPrefix = "proxy"
+	}
+
+	config.validate(s)
+	if len(s.problems) > 0 {
+		return nil, s.problems
+	}
+	return config, nil
 }
\ No newline at end of file
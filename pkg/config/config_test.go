@@ -1,7 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -110,6 +114,96 @@ func TestLoadConfigDefaults(t *testing.T) {
 	if cfg.InfluxOrg != "openaiorg" {
 		t.Errorf("Expected default InfluxOrg to be 'openaiorg', got '%s'", cfg.InfluxOrg)
 	}
+
+	if cfg.Cache.Enabled {
+		t.Error("Expected cache to be disabled by default")
+	}
+
+	if cfg.Cache.TTL != 300 || cfg.Cache.MaxEntries != 1000 || len(cfg.Cache.Paths) != 2 {
+		t.Errorf("Unexpected cache defaults: %+v", cfg.Cache)
+	}
+
+	if cfg.Maintenance.RetryAfter != 60 || cfg.Maintenance.Message == "" {
+		t.Errorf("Unexpected maintenance defaults: %+v", cfg.Maintenance)
+	}
+
+	if cfg.Quotas.Enabled() || cfg.Quotas.Period != "monthly" || cfg.Quotas.Timezone != "UTC" {
+		t.Errorf("Unexpected quota defaults: %+v", cfg.Quotas)
+	}
+
+	if cfg.UpstreamRetry.MaxRetries != 0 || cfg.UpstreamRetry.Backoff != 500 {
+		t.Errorf("Unexpected upstream retry defaults: %+v", cfg.UpstreamRetry)
+	}
+
+	if cfg.SchedulerTickMs != 10 || cfg.PreemptCheckMs != 50 {
+		t.Errorf("Unexpected scheduler defaults: tick %dms, preempt check %dms", cfg.SchedulerTickMs, cfg.PreemptCheckMs)
+	}
+
+	if cfg.KeyRotationGrace != 300 {
+		t.Errorf("Expected key rotation grace 300, got %d", cfg.KeyRotationGrace)
+	}
+
+	if cfg.Secrets.Enabled() || cfg.Secrets.Refresh != 300 {
+		t.Errorf("Unexpected secrets defaults: %+v", cfg.Secrets)
+	}
+}
+
+func TestUpstreamRetryForBackend(t *testing.T) {
+	four, zero := 4, 0
+	retry := UpstreamRetryConfig{
+		MaxRetries: 2,
+		Backoff:    500,
+		ErrorTypes: map[string]bool{"context_length_exceeded": false},
+		Backends: map[string]UpstreamRetryOverride{
+			"http://anthropic": {MaxRetries: &four, ErrorTypes: map[string]bool{"overloaded_error": true}},
+			"http://vllm":      {MaxRetries: &zero},
+		},
+	}
+
+	merged := retry.ForBackend("http://anthropic")
+	if merged.MaxRetries != 4 || merged.Backoff != 500 {
+		t.Errorf("Expected overridden retries and default backoff, got %+v", merged)
+	}
+	if retry, ok := merged.ErrorTypes["context_length_exceeded"]; !ok || retry {
+		t.Error("Expected default error types to be kept")
+	}
+	if !merged.ErrorTypes["overloaded_error"] {
+		t.Error("Expected backend error types to be added")
+	}
+
+	// A backend can turn retries off
+	if vllm := retry.ForBackend("http://vllm"); vllm.MaxRetries != 0 || vllm.Backoff != 500 {
+		t.Errorf("Expected retries disabled for vllm, got %+v", vllm)
+	}
+
+	// Other backends get the defaults, which the override must not have changed
+	if other := retry.ForBackend("http://azure"); other.MaxRetries != 2 || len(other.ErrorTypes) != 1 {
+		t.Errorf("Expected defaults for other backends, got %+v", other)
+	}
+}
+
+func TestUpstreamIdentityForBackend(t *testing.T) {
+	identity := UpstreamIdentityConfig{
+		UserAgent: "mule-proxy (team-ml)",
+		Headers:   map[string]string{"X-Deployment": "prod", "OpenAI-Organization": "org-default"},
+		Backends: map[string]UpstreamIdentityConfig{
+			"http://azure": {Headers: map[string]string{"OpenAI-Organization": "org-azure"}},
+			"http://vllm":  {UserAgent: "mule-proxy-vllm"},
+		},
+	}
+
+	azure := identity.ForBackend("http://azure")
+	if azure.UserAgent != "mule-proxy (team-ml)" || azure.Headers["X-Deployment"] != "prod" || azure.Headers["OpenAI-Organization"] != "org-azure" {
+		t.Errorf("Expected merged headers and the default user agent, got %+v", azure)
+	}
+	if vllm := identity.ForBackend("http://vllm"); vllm.UserAgent != "mule-proxy-vllm" || len(vllm.Headers) != 2 {
+		t.Errorf("Expected overridden user agent and default headers, got %+v", vllm)
+	}
+
+	// The override must not have changed the defaults
+	if identity.Headers["OpenAI-Organization"] != "org-default" {
+		t.Error("Expected default headers left unchanged")
+	}
 }
 
 func TestLoadConfigError(t *testing.T) {
@@ -137,4 +231,212 @@ func TestLoadConfigError(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error when loading invalid JSON, got nil")
 	}
-}
\ No newline at end of file
+}
+
+func TestLoadConfigProblems(t *testing.T) {
+	testConfig := `{
+	  "openai_api_url": "https://test-api.openai.com/v1",
+	  "admin_port": 8080,
+	  "endpoints": [
+	    {"port": 8080, "priority": 1, "premptive": true, "max_concurrent": 2},
+	    {"port": 70000, "priority": 1, "preemptive": false, "max_concurrent": "4"},
+	    {"port": 8082, "priority": 2, "hard_watermark": 150, "escalate": [{"after": 0}]}
+	  ],
+	  "cache": {"enabled": true, "ttl": 1.5},
+	  "pricing": {"gpt-4": {"inptu": 30}},
+	  "distributed": {"leader_election": true},
+	  "backend_quotas": {"https://azure.example.com": {"tokens_per_minute": -1}},
+	  "metrics": {"users": "plain"},
+	  "model_discovery": {"interval": -1}
+	}`
+
+	tmpfile, err := os.CreateTemp("", "config-problems-*.json")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(testConfig)); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	tmpfile.Close()
+
+	// Unknown settings and values of the wrong type are found together
+	_, err = LoadConfig(tmpfile.Name())
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("Expected Problems, got %v", err)
+	}
+	want := []string{
+		`line 5: endpoints[0].premptive: unknown setting, did you mean "preemptive"?`,
+		`line 6: endpoints[1].max_concurrent: expected a whole number, got a string`,
+		`line 9: cache.ttl: expected a whole number, got 1.5`,
+		`line 10: pricing.gpt-4.inptu: unknown setting, did you mean "input"?`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), err)
+	}
+	for i, problem := range problems {
+		if problem.Error() != want[i] {
+			t.Errorf("Problem %d: expected %q, got %q", i, want[i], problem.Error())
+		}
+	}
+
+	// Once those are fixed, settings out of range are found together
+	fixed := strings.NewReplacer(`"premptive"`, `"preemptive"`, `"4"`, `4`, `1.5`, `2`, `"inptu"`, `"input"`).Replace(testConfig)
+	if err := os.WriteFile(tmpfile.Name(), []byte(fixed), 0o600); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	_, err = LoadConfig(tmpfile.Name())
+	if !errors.As(err, &problems) {
+		t.Fatalf("Expected Problems, got %v", err)
+	}
+	want = []string{
+		`line 6: endpoints[1].port: port 70000 is not between 1 and 65535`,
+		`line 6: endpoints[1].max_concurrent: endpoints[0] shares this queue (priority 1) and sets max_concurrent differently`,
+		`line 6: endpoints[1].preemptive: endpoints[0] shares this queue (priority 1) and sets preemptive differently`,
+		`line 7: endpoints[2].hard_watermark: must not be above the queue's capacity of 100`,
+		`line 7: endpoints[2].escalate[0].after: must be positive`,
+		`line 14: model_discovery.interval: must not be negative`,
+		`line 13: metrics.users: unknown value "plain", expected hash, raw or none`,
+		`line 3: admin_port: port 8080 is already used by endpoints[0].port`,
+		`line 11: distributed.leader_election: needs a distributed backend to hold the lease`,
+		`line 12: backend_quotas.https://azure.example.com.tokens_per_minute: must not be negative`,
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("Expected %d problems, got %v", len(want), err)
+	}
+	for i, problem := range problems {
+		if problem.Error() != want[i] {
+			t.Errorf("Problem %d: expected %q, got %q", i, want[i], problem.Error())
+		}
+	}
+
+	// Malformed JSON is located too
+	if err := os.WriteFile(tmpfile.Name(), []byte("{\n  \"endpoints\": [,]\n}"), 0o600); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+	if _, err := LoadConfig(tmpfile.Name()); err == nil || !strings.HasPrefix(err.Error(), "line 2: ") {
+		t.Errorf("Expected a syntax error on line 2, got %v", err)
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{
+		  "openai_api_url": "https://test-api.openai.com/v1",
+		  "include": ["conf.d/*.json"],
+		  "endpoints": [{"port": 8080, "priority": 1, "preemptive": true, "max_concurrent": 4}],
+		  "pricing": {"gpt-4": {"input": 30, "output": 60}}
+		}`,
+		"conf.d/batch.json": `{
+		  "endpoints": [{"port": 8082, "priority": 3, "auth": {"mode": "keys", "keys": ["batch-key"]}}],
+		  "routes": [{"name": "batch-mini", "model": "gpt-4o-mini"}]
+		}`,
+		"conf.d/search.json": `{
+		  "endpoints": [{"port": 8081, "priority": 2}],
+		  "pricing": {"gpt-4o": {"input": 5, "output": 15}}
+		}`,
+	}
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		write(name, content)
+	}
+
+	// Lists are appended to in file order, maps combined
+	cfg, err := LoadConfig(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	var ports []int
+	for _, ep := range cfg.Endpoints {
+		ports = append(ports, ep.Port)
+	}
+	if fmt.Sprint(ports) != "[8080 8082 8081]" {
+		t.Errorf("Expected endpoints from every file in order, got ports %v", ports)
+	}
+	if len(cfg.Endpoints[1].Auth.Keys) != 1 || len(cfg.Routes) != 1 || len(cfg.Pricing) != 2 {
+		t.Errorf("Expected keys, routes and pricing merged, got %+v", cfg)
+	}
+
+	// Settings other files already set are refused, and problems found
+	// after merging are located in the file they came from
+	write("conf.d/search.json", `{
+	  "openai_api_url": "https://other.example.com/v1",
+	  "endpoints": [{"port": 8081, "priority": 1}],
+	  "pricing": {"gpt-4": {"input": 10}}
+	}`)
+	_, err = LoadConfig(filepath.Join(dir, "config.json"))
+	search := filepath.Join(dir, "conf.d/search.json")
+	want := search + `: line 2: openai_api_url: already set in the main config file on line 2` + "\n  " +
+		search + `: line 4: pricing.gpt-4: already set in the main config file on line 5`
+	if err == nil || !strings.HasSuffix(err.Error(), want) {
+		t.Errorf("Expected conflicts reported, got %v", err)
+	}
+
+	write("conf.d/search.json", `{
+	  "endpoints": [{"port": 8081, "priority": 1, "max_concurrent": 2}]
+	}`)
+	_, err = LoadConfig(filepath.Join(dir, "config.json"))
+	want = search + `: line 2: endpoints[2].max_concurrent: endpoints[0] shares this queue (priority 1) and sets max_concurrent differently`
+	if err == nil || err.Error() != want {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+
+	// Missing files are refused, empty directories aren't
+	write("config.json", `{"include": ["conf.d/*.json", "teams/*.json", "missing.json"]}`)
+	_, err = LoadConfig(filepath.Join(dir, "config.json"))
+	if err == nil || !strings.Contains(err.Error(), "include[2]: "+filepath.Join(dir, "missing.json")+" does not exist") {
+		t.Errorf("Expected the missing file reported, got %v", err)
+	}
+}
+
+func TestLoadConfigClasses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{
+	  "endpoints": [
+	    {"port": 8080, "class": "interactive"},
+	    {"port": 8081, "class": "standard", "upstream_timeout": 0},
+	    {"port": 8082, "class": "batch", "priority": 5, "max_concurrent": 16},
+	    {"port": 8083, "priority": 4}
+	  ]
+	}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Classes fill in what endpoints leave out, and what they set themselves
+	// wins, even zero; endpoints without a class keep raw settings
+	want := []Endpoint{
+		{Port: 8080, Class: "interactive", Priority: 1, Preemptive: true, QueueTimeout: 30, UpstreamTimeout: 120},
+		{Port: 8081, Class: "standard", Priority: 2, QueueTimeout: 300},
+		{Port: 8082, Class: "batch", Priority: 5, MaxConcurrent: 16, UpstreamTimeout: 600},
+		{Port: 8083, Priority: 4},
+	}
+	for i, ep := range cfg.Endpoints {
+		if fmt.Sprintf("%+v", ep) != fmt.Sprintf("%+v", want[i]) {
+			t.Errorf("Endpoint %d: expected %+v, got %+v", i, want[i], ep)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"endpoints": [{"port": 8080, "class": "realtime"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadConfig(path)
+	unknown := `line 1: endpoints[0].class: unknown class "realtime", expected interactive, standard or batch`
+	if err == nil || !strings.Contains(err.Error(), unknown) {
+		t.Errorf("Expected %q, got %v", unknown, err)
+	}
+}
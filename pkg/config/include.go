@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// include merges the files config's include patterns name into it, in
+// order, so teams can keep their endpoints, keys and routes in files of
+// their own. Patterns are relative to dir, the main config file's
+// directory. Problems in included files are added to s.
+func include(config *Config, s *schema, dir string) error {
+	for i, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			s.problem(fmt.Sprintf("include[%d]", i), "%v", err)
+			continue
+		}
+		// An empty conf.d is fine, a missing file probably isn't
+		if len(files) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+			s.problem(fmt.Sprintf("include[%d]", i), "%s does not exist", pattern)
+		}
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			part, ps, err := decode(file, data)
+			var problems Problems
+			if errors.As(err, &problems) {
+				s.problems = append(s.problems, problems...)
+				continue
+			} else if err != nil {
+				return err
+			}
+			if len(part.Include) > 0 {
+				ps.problem("include", "only the main config file can include others")
+				s.problems = append(s.problems, ps.problems...)
+				part.Include = nil
+			}
+			s.merge(ps, reflect.ValueOf(config).Elem(), reflect.ValueOf(part).Elem(), "", "")
+		}
+	}
+	return nil
+}
+
+// merge adds the settings of an included file, src, to those loaded so far,
+// dst. Lists are appended to, maps combined and other settings may only be
+// set once, so one team's file can't quietly override another's.
+func (s *schema) merge(inc *schema, dst, src reflect.Value, dstPath, srcPath string) {
+	switch src.Kind() {
+	case reflect.Struct:
+		t := src.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" && !f.Anonymous {
+				name = f.Name
+			}
+			s.merge(inc, dst.Field(i), src.Field(i), joinPath(dstPath, name), joinPath(srcPath, name))
+		}
+	case reflect.Slice:
+		n := dst.Len()
+		for j := 0; j < src.Len(); j++ {
+			s.relocate(inc, fmt.Sprintf("%s[%d]", srcPath, j), fmt.Sprintf("%s[%d]", dstPath, n+j))
+		}
+		if src.Len() > 0 {
+			dst.Set(reflect.AppendSlice(dst, src))
+		}
+	case reflect.Map:
+		if src.Len() == 0 {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(src.Type()))
+		}
+		iter := src.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			if dst.MapIndex(iter.Key()).IsValid() {
+				s.conflict(inc, srcPath+"."+key, dstPath+"."+key)
+				continue
+			}
+			dst.SetMapIndex(iter.Key(), iter.Value())
+			s.relocate(inc, srcPath+"."+key, dstPath+"."+key)
+		}
+	default:
+		if src.IsZero() {
+			return
+		}
+		if !dst.IsZero() && !reflect.DeepEqual(dst.Interface(), src.Interface()) {
+			s.conflict(inc, srcPath, dstPath)
+			return
+		}
+		dst.Set(src)
+		s.relocate(inc, srcPath, dstPath)
+	}
+}
+
+// conflict records an included setting that was already set elsewhere
+func (s *schema) conflict(inc *schema, srcPath, dstPath string) {
+	where := "in the main config file"
+	pos := s.lineOf(dstPath)
+	if pos.file != "" {
+		where = "in " + pos.file
+	}
+	if pos.line != 0 {
+		where = fmt.Sprintf("%s on line %d", where, pos.line)
+	}
+	at := inc.lineOf(srcPath)
+	s.problems = append(s.problems, Problem{File: at.file, Line: at.line, Path: srcPath, Msg: "already set " + where})
+}
+
+// relocate remembers where the included setting at from, and everything in
+// it, came from once it has been merged in at to
+func (s *schema) relocate(inc *schema, from, to string) {
+	for path, pos := range inc.lines {
+		if rest, ok := strings.CutPrefix(path, from); ok && (rest == "" || rest[0] == '.' || rest[0] == '[') {
+			s.lines[to+rest] = pos
+		}
+	}
+}
+
+// joinPath adds a setting's name to the path of the one containing it
+func joinPath(path, name string) string {
+	switch {
+	case name == "":
+		return path
+	case path == "":
+		return name
+	}
+	return path + "." + name
+}
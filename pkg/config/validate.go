@@ -0,0 +1,636 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Problem is one thing wrong with a config file
+type Problem struct {
+	File string // Included file the problem is in, empty for the main config file
+	Line int    // Line of the file the problem is on, 0 if it isn't in the file
+	Path string // Setting the problem is with, e.g. endpoints[1].priority
+	Msg  string
+}
+
+// Error implements error
+func (p Problem) Error() string {
+	msg := p.Msg
+	if p.Path != "" {
+		msg = p.Path + ": " + msg
+	}
+	if p.Line != 0 {
+		msg = fmt.Sprintf("line %d: %s", p.Line, msg)
+	}
+	if p.File != "" {
+		msg = p.File + ": " + msg
+	}
+	return msg
+}
+
+// Problems lists everything wrong with a config file, so it can be fixed
+// in one go rather than one restart at a time
+type Problems []Problem
+
+// Error implements error
+func (p Problems) Error() string {
+	if len(p) == 1 {
+		return p[0].Error()
+	}
+	lines := make([]string, len(p))
+	for i, problem := range p {
+		lines[i] = "\n  " + problem.Error()
+	}
+	return fmt.Sprintf("%d problems:%s", len(p), strings.Join(lines, ""))
+}
+
+var (
+	jsonUnmarshaler = reflect.TypeFor[json.Unmarshaler]()
+	textUnmarshaler = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// position is where a setting is, in the main config file or an included one
+type position struct {
+	file string // Empty for the main config file
+	line int
+}
+
+// schema walks a config file's JSON alongside the Config type, finding the
+// settings it doesn't know (usually typos) and values of the wrong type,
+// and remembering where each setting is
+type schema struct {
+	file     string
+	data     []byte
+	dec      *json.Decoder
+	lines    map[string]position
+	problems Problems
+}
+
+// checkSchema checks data, from file, against the Config type. It fails
+// with a *json.SyntaxError for data that isn't JSON at all.
+func checkSchema(file string, data []byte) (*schema, error) {
+	s := &schema{file: file, data: data, dec: json.NewDecoder(bytes.NewReader(data)), lines: make(map[string]position)}
+	s.dec.UseNumber()
+	if err := s.value("", reflect.TypeFor[Config]()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// line returns the position the decoder has read up to
+func (s *schema) line() position {
+	return position{file: s.file, line: bytes.Count(s.data[:s.dec.InputOffset()], []byte("\n")) + 1}
+}
+
+// problem records a problem with the setting at path
+func (s *schema) problem(path, format string, args ...any) {
+	pos := s.lineOf(path)
+	s.problems = append(s.problems, Problem{File: pos.file, Line: pos.line, Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// lineOf returns where the setting at path is, or the closest enclosing
+// one for settings left to their defaults
+func (s *schema) lineOf(path string) position {
+	for path != "" {
+		if pos, ok := s.lines[path]; ok {
+			return pos
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return position{}
+}
+
+// value checks the next value in the file against t
+func (s *schema) value(path string, t reflect.Type) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.lines[path]; !ok && path != "" {
+		s.lines[path] = s.line()
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// Types decoding themselves decide what they accept
+	custom := reflect.PointerTo(t).Implements(jsonUnmarshaler) || reflect.PointerTo(t).Implements(textUnmarshaler)
+	if custom || t.Kind() == reflect.Interface {
+		return s.skip(tok)
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		return nil
+	case bool:
+		if t.Kind() != reflect.Bool {
+			s.problem(path, "expected %s, got a boolean", describe(t))
+		}
+	case string:
+		if t.Kind() != reflect.String {
+			s.problem(path, "expected %s, got a string", describe(t))
+		}
+	case json.Number:
+		s.number(path, t, tok)
+	case json.Delim:
+		switch {
+		case tok == '{' && t.Kind() == reflect.Struct:
+			return s.object(path, t)
+		case tok == '{' && t.Kind() == reflect.Map:
+			return s.entries(path, t.Elem())
+		case tok == '[' && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+			return s.elements(path, t.Elem())
+		}
+		kind := "an object"
+		if tok == '[' {
+			kind = "an array"
+		}
+		s.problem(path, "expected %s, got %s", describe(t), kind)
+		return s.skip(tok)
+	}
+	return nil
+}
+
+// number checks a number fits t
+func (s *schema) number(path string, t reflect.Type, n json.Number) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(n.String(), 10, t.Bits()); err != nil {
+			s.problem(path, "expected a whole number, got %s", n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(n.String(), 10, t.Bits()); err != nil {
+			s.problem(path, "expected a positive whole number, got %s", n)
+		}
+	case reflect.Float32, reflect.Float64:
+	default:
+		s.problem(path, "expected %s, got a number", describe(t))
+	}
+}
+
+// object checks the members of an object decoded into the struct t
+func (s *schema) object(path string, t reflect.Type) error {
+	fields := jsonFields(t)
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		s.lines[child] = s.line()
+
+		field, ok := lookupField(fields, key)
+		if !ok {
+			msg := "unknown setting"
+			if guess := closestField(fields, key); guess != "" {
+				msg += fmt.Sprintf(", did you mean %q?", guess)
+			}
+			s.problem(child, "%s", msg)
+			if err := s.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.value(child, field); err != nil {
+			return err
+		}
+	}
+	_, err := s.dec.Token()
+	return err
+}
+
+// entries checks the values of an object decoded into a map
+func (s *schema) entries(path string, elem reflect.Type) error {
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return err
+		}
+		child := path + "." + tok.(string)
+		s.lines[child] = s.line()
+		if err := s.value(child, elem); err != nil {
+			return err
+		}
+	}
+	_, err := s.dec.Token()
+	return err
+}
+
+// elements checks the elements of an array
+func (s *schema) elements(path string, elem reflect.Type) error {
+	for i := 0; s.dec.More(); i++ {
+		if err := s.value(fmt.Sprintf("%s[%d]", path, i), elem); err != nil {
+			return err
+		}
+	}
+	_, err := s.dec.Token()
+	return err
+}
+
+// skipValue skips the next value in the file
+func (s *schema) skipValue() error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	return s.skip(tok)
+}
+
+// skip skips the rest of a value whose first token has been read
+func (s *schema) skip(tok json.Token) error {
+	if delim, ok := tok.(json.Delim); ok && (delim == '{' || delim == '[') {
+		for depth := 1; depth > 0; {
+			tok, err := s.dec.Token()
+			if err != nil {
+				return err
+			}
+			switch tok {
+			case json.Delim('{'), json.Delim('['):
+				depth++
+			case json.Delim('}'), json.Delim(']'):
+				depth--
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFields maps the JSON names of a struct's fields, including those of
+// embedded structs, to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for embedded, typ := range jsonFields(f.Type) {
+				if _, ok := fields[embedded]; !ok {
+					fields[embedded] = typ
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lookupField finds a field the way encoding/json does, preferring an
+// exact match but accepting any case
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// closestField returns the field a mistyped key most likely meant, if any
+// is close enough to be a typo
+func closestField(fields map[string]reflect.Type, key string) string {
+	best, bestDist := "", max(2, len(key)/3)+1
+	for name := range fields {
+		if d := editDistance(strings.ToLower(key), strings.ToLower(name)); d < bestDist || (d == bestDist && best != "" && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// describe names the JSON a type expects, for problems
+func describe(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "a whole number"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// validate checks settings the types alone can't: ports that can't be
+// listened on, and queues that clash
+func (c *Config) validate(s *schema) {
+	ports := make(map[int]string)
+	port := func(path string, p int, optional bool) {
+		if optional && p == 0 {
+			return
+		}
+		if p < 1 || p > 65535 {
+			s.problem(path, "port %d is not between 1 and 65535", p)
+			return
+		}
+		if other, ok := ports[p]; ok {
+			s.problem(path, "port %d is already used by %s", p, other)
+			return
+		}
+		ports[p] = path
+	}
+
+	queues := make(map[int][]int) // Endpoints sharing each priority's queue
+	for i, ep := range c.Endpoints {
+		path := fmt.Sprintf("endpoints[%d]", i)
+		port(path+".port", ep.Port, false)
+		if ep.Priority < 1 {
+			s.problem(path+".priority", "priority %d is not positive, 1 is the highest", ep.Priority)
+		} else {
+			c.shareQueue(s, queues[ep.Priority], i)
+			queues[ep.Priority] = append(queues[ep.Priority], i)
+		}
+		if ep.MaxConcurrent < 0 {
+			s.problem(path+".max_concurrent", "must not be negative")
+		}
+		if ep.MaxQueuedMB < 0 {
+			s.problem(path+".max_queued_mb", "must not be negative")
+		}
+		if ep.SoftWatermark < 0 {
+			s.problem(path+".soft_watermark", "must not be negative")
+		} else if ep.SoftWatermark >= QueueCapacity {
+			s.problem(path+".soft_watermark", "must be below the queue's capacity of %d", QueueCapacity)
+		}
+		if ep.HardWatermark < 0 {
+			s.problem(path+".hard_watermark", "must not be negative")
+		} else if ep.HardWatermark > QueueCapacity {
+			s.problem(path+".hard_watermark", "must not be above the queue's capacity of %d", QueueCapacity)
+		} else if ep.HardWatermark > 0 && ep.HardWatermark <= ep.SoftWatermark {
+			s.problem(path+".hard_watermark", "must be above soft_watermark %d", ep.SoftWatermark)
+		}
+		if ep.QueueTimeout < 0 {
+			s.problem(path+".queue_timeout", "must not be negative")
+		}
+		if ep.UpstreamTimeout < 0 {
+			s.problem(path+".upstream_timeout", "must not be negative")
+		}
+		for j, rule := range ep.Escalate {
+			rulePath := fmt.Sprintf("%s.escalate[%d]", path, j)
+			if rule.After <= 0 {
+				s.problem(rulePath+".after", "must be positive")
+			}
+			if rule.Steps < 0 {
+				s.problem(rulePath+".steps", "must not be negative")
+			}
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Tracing.IdentityHeaders)) {
+		switch key {
+		case "tenant", "key_id", "priority":
+		default:
+			s.problem("tracing.identity_headers."+key, "unknown identifier, expected tenant, key_id or priority")
+		}
+	}
+	if c.Tracing.TenantTag != "" && !slices.Contains(c.TagKeys, c.Tracing.TenantTag) {
+		s.problem("tracing.tenant_tag", "tag %q is not in tag_keys, so requests never carry it", c.Tracing.TenantTag)
+	}
+	if p := c.LargePrompts.Priority; p != 0 && len(queues[p]) == 0 {
+		s.problem("large_prompts.priority", "no endpoint has priority %d", p)
+	}
+	for i, route := range c.Routes {
+		if p := route.Priority; p != 0 && len(queues[p]) == 0 {
+			s.problem(fmt.Sprintf("routes[%d].priority", i), "no endpoint has priority %d", p)
+		}
+	}
+	if c.KeyConcurrency.Default < 0 {
+		s.problem("key_concurrency.default", "must not be negative")
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.KeyConcurrency.Keys)) {
+		if c.KeyConcurrency.Keys[key] < 0 {
+			s.problem("key_concurrency.keys."+key, "must not be negative")
+		}
+	}
+	if c.SlowRequests.Threshold < 0 {
+		s.problem("slow_requests.threshold_ms", "must not be negative")
+	}
+	if c.SlowRequests.Keep < 0 {
+		s.problem("slow_requests.keep", "must not be negative")
+	}
+	if c.SlowRequests.Window < 0 {
+		s.problem("slow_requests.window", "must not be negative")
+	}
+	for _, model := range slices.Sorted(maps.Keys(c.SlowRequests.Models)) {
+		if c.SlowRequests.Models[model] < 0 {
+			s.problem("slow_requests.models."+model, "must not be negative")
+		}
+	}
+	if c.Journal.TTL < 0 {
+		s.problem("journal.ttl", "must not be negative")
+	}
+	if c.Journal.AmbiguousTTL < 0 {
+		s.problem("journal.ambiguous_ttl", "must not be negative")
+	}
+	for _, model := range slices.Sorted(maps.Keys(c.ModelDefaults)) {
+		path, defaults := "model_defaults."+model, c.ModelDefaults[model]
+		if t := defaults.Temperature; t != nil && (*t < 0 || *t > 2) {
+			s.problem(path+".temperature", "must be between 0 and 2")
+		}
+		if p := defaults.TopP; p != nil && (*p < 0 || *p > 1) {
+			s.problem(path+".top_p", "must be between 0 and 1")
+		}
+		if defaults.MaxTokens < 0 {
+			s.problem(path+".max_tokens", "must not be negative")
+		}
+		if len(defaults.Stop) > 4 {
+			s.problem(path+".stop", "must have at most 4 sequences")
+		}
+	}
+	for i, rule := range c.OutputScan.Rules {
+		path := fmt.Sprintf("output_scan.rules[%d]", i)
+		if _, err := regexp.Compile(rule.Pattern); rule.Pattern == "" || err != nil {
+			s.problem(path+".pattern", "must be a valid regular expression")
+		}
+		switch rule.Action {
+		case "", "block", "redact":
+		default:
+			s.problem(path+".action", "unknown action %q, expected block or redact", rule.Action)
+		}
+	}
+	if c.OutputScan.Window < 0 {
+		s.problem("output_scan.window", "must not be negative")
+	}
+	if c.OutputScan.Timeout < 0 {
+		s.problem("output_scan.timeout_ms", "must not be negative")
+	}
+	if c.OutputScan.MaxResponse < 0 {
+		s.problem("output_scan.max_response", "must not be negative")
+	}
+	if c.ModelDiscovery.Interval < 0 {
+		s.problem("model_discovery.interval", "must not be negative")
+	}
+	switch c.ContextTrim.Strategy {
+	case "", "drop_oldest", "summarize":
+	default:
+		s.problem("context_trim.strategy", "unknown strategy %q, expected drop_oldest or summarize", c.ContextTrim.Strategy)
+	}
+	if c.ContextTrim.SummaryTokens < 0 {
+		s.problem("context_trim.summary_tokens", "must not be negative")
+	}
+	if c.ContextTrim.Timeout < 0 {
+		s.problem("context_trim.timeout", "must not be negative")
+	}
+	if c.StructuredOutputs.Retry && !c.StructuredOutputs.Validate {
+		s.problem("structured_outputs.retry", "has no effect without structured_outputs.validate")
+	}
+	checkCatalog(s, "model_catalogs.default", c.ModelCatalogs.Default)
+	for _, key := range slices.Sorted(maps.Keys(c.ModelCatalogs.Keys)) {
+		checkCatalog(s, "model_catalogs.keys."+key, c.ModelCatalogs.Keys[key])
+	}
+	if c.Preflight.Timeout < 0 {
+		s.problem("preflight.timeout", "must not be negative")
+	}
+	if c.Preflight.Interval < 0 {
+		s.problem("preflight.interval", "must not be negative")
+	}
+	if c.RetryBudget < 0 {
+		s.problem("retry_budget", "must not be negative")
+	}
+	if c.Metrics.MaxTagValues < -1 {
+		s.problem("metrics.max_tag_values", "must be -1 for unlimited or positive")
+	}
+	switch c.Metrics.Users {
+	case "", "hash", "raw", "none":
+	default:
+		s.problem("metrics.users", "unknown value %q, expected hash, raw or none", c.Metrics.Users)
+	}
+	port("admin_port", c.AdminPort, true)
+	if key := c.QueueSnapshot.Key; key != "" && len(key) < 16 {
+		s.problem("queue_snapshot.key", "must be at least 16 characters")
+	}
+	port("grpc_port", c.GRPCPort, true)
+
+	if c.Distributed.LeaderElection && c.Distributed.Backend == "" {
+		s.problem("distributed.leader_election", "needs a distributed backend to hold the lease")
+	}
+	if c.Distributed.LeaseTTL < 3 {
+		s.problem("distributed.lease_ttl", "must be at least 3 seconds")
+	}
+	for _, url := range slices.Sorted(maps.Keys(c.BackendQuotas)) {
+		quota := c.BackendQuotas[url]
+		if quota.TokensPerMinute < 0 {
+			s.problem("backend_quotas."+url+".tokens_per_minute", "must not be negative")
+		}
+		if quota.RequestsPerMinute < 0 {
+			s.problem("backend_quotas."+url+".requests_per_minute", "must not be negative")
+		}
+	}
+}
+
+// checkCatalog checks a model catalog's entries are model names or
+// patterns ending in "*"
+func checkCatalog(s *schema, path string, models []string) {
+	for i, model := range models {
+		if model == "" || strings.Contains(strings.TrimSuffix(model, "*"), "*") {
+			s.problem(fmt.Sprintf("%s[%d]", path, i), "must be a model name or a pattern ending in *")
+		}
+	}
+}
+
+// shareQueue checks endpoint i agrees with the endpoints before it with
+// the same priority, whose queue it shares, on the queue settings both set
+func (c *Config) shareQueue(s *schema, sharing []int, i int) {
+	ep := c.Endpoints[i]
+	path := fmt.Sprintf("endpoints[%d]", i)
+	for _, j := range sharing {
+		other := c.Endpoints[j]
+		conflict := func(name string, differs bool) {
+			if differs {
+				s.problem(path+"."+name, "endpoints[%d] shares this queue (priority %d) and sets %s differently", j, ep.Priority, name)
+			}
+		}
+		conflict("max_concurrent", ep.MaxConcurrent != 0 && other.MaxConcurrent != 0 && ep.MaxConcurrent != other.MaxConcurrent)
+		conflict("preemptive", c.setsPreemptive(s, i) && c.setsPreemptive(s, j) && ep.Preemptive != other.Preemptive)
+		conflict("max_queued_mb", ep.MaxQueuedMB != 0 && other.MaxQueuedMB != 0 && ep.MaxQueuedMB != other.MaxQueuedMB)
+		conflict("soft_watermark", ep.SoftWatermark != 0 && other.SoftWatermark != 0 && ep.SoftWatermark != other.SoftWatermark)
+		conflict("hard_watermark", ep.HardWatermark != 0 && other.HardWatermark != 0 && ep.HardWatermark != other.HardWatermark)
+		conflict("queue_timeout", ep.QueueTimeout != 0 && other.QueueTimeout != 0 && ep.QueueTimeout != other.QueueTimeout)
+		conflict("upstream_timeout", ep.UpstreamTimeout != 0 && other.UpstreamTimeout != 0 && ep.UpstreamTimeout != other.UpstreamTimeout)
+		conflict("escalate", len(ep.Escalate) > 0 && len(other.Escalate) > 0 && !reflect.DeepEqual(ep.Escalate, other.Escalate))
+	}
+}
+
+// setsPreemptive reports whether endpoint i sets preemptive, itself or
+// through its class, rather than leaving it to the others sharing its queue
+func (c *Config) setsPreemptive(s *schema, i int) bool {
+	_, ok := s.lines[fmt.Sprintf("endpoints[%d].preemptive", i)]
+	return ok || c.Endpoints[i].Class != ""
+}
+
+// decode parses a config file, reporting every unknown setting and value
+// of the wrong type in it at once. The schema it returns locates settings
+// for validate. Included files are named by file, the main one isn't.
+func decode(file string, data []byte) (*Config, *schema, error) {
+	s, err := checkSchema(file, data)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+			err = fmt.Errorf("line %d: %w", line, err)
+		}
+		if file != "" {
+			err = fmt.Errorf("%s: %w", file, err)
+		}
+		return nil, nil, err
+	}
+	if len(s.problems) > 0 {
+		return nil, nil, s.problems
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+	return &config, s, nil
+}
@@ -0,0 +1,143 @@
+// Package cron matches times against cron expressions, for configuring
+// recurring windows such as "every night from midnight to six".
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is one of an expression's five fields
+type field struct {
+	name  string
+	min   int
+	max   int
+	names []string // Names accepted in place of numbers, starting at min
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Expr is a parsed cron expression: minute, hour, day of month, month and
+// day of week. A time matches when every field matches its minute, except
+// that, as in cron, a time matches when either day field does if both are
+// restricted.
+type Expr struct {
+	sets      [5]uint64 // Bit n set when value n matches
+	domStar   bool
+	dowStar   bool
+	canonical string
+}
+
+// Parse parses a five-field cron expression. Fields accept *, numbers,
+// ranges (1-5), steps (*/15, 0-30/10), comma-separated lists, and month and
+// weekday names (jan, mon). Sunday is 0 or 7.
+func Parse(s string) (*Expr, error) {
+	parts := strings.Fields(s)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, got %d", s, len(fields), len(parts))
+	}
+
+	e := &Expr{canonical: strings.Join(parts, " ")}
+	for i, part := range parts {
+		set, err := fields[i].parse(strings.ToLower(part))
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", s, err)
+		}
+		e.sets[i] = set
+	}
+	e.domStar = parts[2] == "*"
+	e.dowStar = parts[4] == "*"
+
+	// Sunday can be written as 7
+	if e.sets[4]&(1<<7) != 0 {
+		e.sets[4] |= 1
+	}
+	return e, nil
+}
+
+// parse parses one field into the set of values it matches
+func (f field) parse(s string) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, f.name)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(from); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(to); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses a number or name in a field
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if s == name {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid value %q in %s field (%d-%d)", s, f.name, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Matches reports whether t's minute matches the expression, in t's location
+func (e *Expr) Matches(t time.Time) bool {
+	if !e.has(0, t.Minute()) || !e.has(1, t.Hour()) || !e.has(3, int(t.Month())) {
+		return false
+	}
+
+	dom, dow := e.has(2, t.Day()), e.has(4, int(t.Weekday()))
+	if e.domStar || e.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// has reports whether field i matches value v
+func (e *Expr) has(i, v int) bool {
+	return e.sets[i]&(1<<v) != 0
+}
+
+// String returns the expression as it was written
+func (e *Expr) String() string {
+	return e.canonical
+}
@@ -0,0 +1,56 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04 Mon", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		expr  string
+		time  string
+		match bool
+	}{
+		{"* * * * *", "2026-10-16 13:37 Fri", true},
+		{"* 0-5 * * *", "2026-10-16 00:00 Fri", true},
+		{"* 0-5 * * *", "2026-10-16 05:59 Fri", true},
+		{"* 0-5 * * *", "2026-10-16 06:00 Fri", false},
+		{"*/15 * * * *", "2026-10-16 13:45 Fri", true},
+		{"*/15 * * * *", "2026-10-16 13:46 Fri", false},
+		{"0-30/10 9 * * *", "2026-10-16 09:20 Fri", true},
+		{"0,30 9 * * *", "2026-10-16 09:30 Fri", true},
+		{"* * * * sat,sun", "2026-10-17 12:00 Sat", true},
+		{"* * * * 7", "2026-10-18 12:00 Sun", true},
+		{"* * * * mon-fri", "2026-10-18 12:00 Sun", false},
+		{"* * * dec *", "2026-10-16 12:00 Fri", false},
+		// Restricting both day fields matches either, as in cron
+		{"* * 1 * mon", "2026-10-19 12:00 Mon", true},
+		{"* * 1 * mon", "2026-10-01 12:00 Thu", true},
+		{"* * 1 * mon", "2026-10-16 12:00 Fri", false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.expr, err)
+		}
+		if got := expr.Matches(at(tt.time)); got != tt.match {
+			t.Errorf("%q matching %s: expected %v, got %v", tt.expr, tt.time, tt.match, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 5-1 * * *", "*/0 * * * *", "* * * * funday", "* * * * * *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Expected %q to be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mule-ai/proxy/pkg/proxy"
+)
+
+// AuthOptions returns the server options refusing calls auth doesn't
+// accept. Authorizers see the call's metadata as the headers of a request
+// to its method, so the credentials clients send on the ports work here too.
+func AuthOptions(auth proxy.Authorizer) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			ctx, err := authorize(ctx, auth, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := authorize(stream.Context(), auth, info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &authedStream{ServerStream: stream, ctx: ctx})
+		}),
+	}
+}
+
+// authorize checks a call's credentials, returning its context carrying
+// the principal it authenticated as
+func authorize(ctx context.Context, auth proxy.Authorizer, method string) (context.Context, error) {
+	r, err := http.NewRequestWithContext(ctx, "POST", method, nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			for _, vv := range v {
+				r.Header.Add(k, vv)
+			}
+		}
+	}
+
+	principal, err := auth.Authorize(r)
+	if err != nil {
+		if !errors.Is(err, proxy.ErrUnauthorized) {
+			fmt.Printf("Authorizer error for %s: %v\n", method, err)
+		}
+		return nil, status.Error(codes.Unauthenticated, "Invalid or missing credentials")
+	}
+	return proxy.ContextWithPrincipal(ctx, principal), nil
+}
+
+// authedStream is a server stream whose context carries its principal
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context implements grpc.ServerStream
+func (s *authedStream) Context() context.Context {
+	return s.ctx
+}
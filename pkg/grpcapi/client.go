@@ -0,0 +1,58 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client calls the proxy's gRPC service
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient creates a client on an existing connection
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// SubmitStream receives the chunks of a submitted request's response
+type SubmitStream struct {
+	stream grpc.ClientStream
+}
+
+// Recv returns the next response chunk, or io.EOF when the response is complete
+func (s *SubmitStream) Recv() (*ResponseChunk, error) {
+	chunk := new(ResponseChunk)
+	if err := s.stream.RecvMsg(chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// Submit sends a request to be scheduled at its priority and streams back the response
+func (c *Client) Submit(ctx context.Context, req *SubmitRequest, opts ...grpc.CallOption) (*SubmitStream, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/proxy.v1.Proxy/Submit", opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &SubmitStream{stream: stream}, nil
+}
+
+// Status returns the current queue state
+func (c *Client) Status(ctx context.Context, opts ...grpc.CallOption) (*StatusResponse, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	resp := new(StatusResponse)
+	if err := c.conn.Invoke(ctx, "/proxy.v1.Proxy/Status", &StatusRequest{}, resp, opts...); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,35 @@
+package grpcapi
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is the gRPC content-subtype used by this API
+// ("application/grpc+proxy-json"). It is the package's own, so registering
+// the codec doesn't replace a "json" codec other code in the process uses.
+const codecName = "proxy-json"
+
+// jsonCodec encodes gRPC messages as JSON so the service can be defined
+// without generated protobuf code
+type jsonCodec struct{}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// Marshal implements encoding.Codec
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal implements encoding.Codec
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name implements encoding.Codec
+func (jsonCodec) Name() string {
+	return codecName
+}
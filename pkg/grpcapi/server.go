@@ -0,0 +1,215 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/mule-ai/proxy/pkg/proxy"
+)
+
+// SubmitRequest is an OpenAI API request submitted at a priority
+type SubmitRequest struct {
+	Priority int                 `json:"priority"`
+	Method   string              `json:"method"`
+	Path     string              `json:"path"`
+	Header   map[string][]string `json:"header,omitempty"`
+	Body     []byte              `json:"body,omitempty"`
+}
+
+// ResponseChunk is part of a streamed response. Status and Header are only
+// set on the first chunk.
+type ResponseChunk struct {
+	Status int                 `json:"status,omitempty"`
+	Header map[string][]string `json:"header,omitempty"`
+	Data   []byte              `json:"data,omitempty"`
+}
+
+// StatusRequest asks for the current queue state
+type StatusRequest struct{}
+
+// StatusResponse reports the current queue state
+type StatusResponse struct {
+	Queues []proxy.QueueStatus `json:"queues"`
+}
+
+// ProxyServer is the gRPC service mirroring the HTTP proxy
+type ProxyServer interface {
+	Submit(req *SubmitRequest, stream grpc.ServerStream) error
+	Status(ctx context.Context, req *StatusRequest) (*StatusResponse, error)
+}
+
+// serviceDesc describes the proxy.v1.Proxy service
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "proxy.v1.Proxy",
+	HandlerType: (*ProxyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Status", Handler: statusHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Submit", Handler: submitHandler, ServerStreams: true},
+	},
+}
+
+// RegisterProxyServer registers the service on a gRPC server
+func RegisterProxyServer(s grpc.ServiceRegistrar, srv ProxyServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// statusHandler decodes a Status call and dispatches it
+func statusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(StatusRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(ProxyServer).Status(ctx, req)
+	}
+
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/proxy.v1.Proxy/Status"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ProxyServer).Status(ctx, req.(*StatusRequest))
+	})
+}
+
+// submitHandler decodes a Submit call and dispatches it
+func submitHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(SubmitRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(ProxyServer).Submit(req, stream)
+}
+
+// Server implements ProxyServer on top of the proxy's request handler, so
+// submitted requests are admitted exactly like those to the HTTP ports:
+// rate limits, quotas, catalogs, parameter defaults and the rest all apply
+type Server struct {
+	QueueManager *proxy.QueueManager
+	Handler      http.Handler // Usually the proxy's *proxy.RequestHandler
+}
+
+// NewServer creates a gRPC proxy server submitting requests through handler
+func NewServer(qm *proxy.QueueManager, handler http.Handler) *Server {
+	return &Server{QueueManager: qm, Handler: handler}
+}
+
+// Submit implements ProxyServer
+func (s *Server) Submit(req *SubmitRequest, stream grpc.ServerStream) error {
+	if req.Method == "" {
+		req.Method = "POST"
+	}
+
+	if s.QueueManager.FindQueue(req.Priority) == nil {
+		return status.Error(codes.NotFound, proxy.ErrNoQueue.Error())
+	}
+
+	ctx := proxy.ContextWithPriority(stream.Context(), req.Priority)
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+	for k, v := range req.Header {
+		for _, vv := range v {
+			httpReq.Header.Add(k, vv)
+		}
+	}
+	// Clients identify themselves with the credentials they authenticated with
+	if httpReq.Header.Get("Authorization") == "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			httpReq.Header.Set("Authorization", md.Get("authorization")[0])
+		}
+	}
+	if httpReq.Header.Get("Content-Type") == "" && len(req.Body) > 0 {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+
+	// Requests the proxy turns away are answered with the same HTTP errors
+	// as on the ports
+	w := newStreamWriter(stream)
+	s.Handler.ServeHTTP(w, httpReq)
+	return w.finish()
+}
+
+// Status implements ProxyServer
+func (s *Server) Status(ctx context.Context, req *StatusRequest) (*StatusResponse, error) {
+	return &StatusResponse{Queues: s.QueueManager.Status()}, nil
+}
+
+// streamWriter adapts a gRPC stream to http.ResponseWriter, sending every
+// write as a ResponseChunk
+type streamWriter struct {
+	stream     grpc.ServerStream
+	header     http.Header
+	status     int
+	headerSent bool
+	err        error
+}
+
+// newStreamWriter creates a writer for a Submit stream
+func newStreamWriter(stream grpc.ServerStream) *streamWriter {
+	return &streamWriter{stream: stream, header: make(http.Header)}
+}
+
+// Header implements http.ResponseWriter
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *streamWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	chunk := &ResponseChunk{Data: append([]byte(nil), p...)}
+	w.fillHeader(chunk)
+	if err := w.stream.SendMsg(chunk); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher; every Write is already sent immediately
+func (w *streamWriter) Flush() {}
+
+// fillHeader attaches status and headers to the first chunk sent
+func (w *streamWriter) fillHeader(chunk *ResponseChunk) {
+	if w.headerSent {
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	chunk.Status = w.status
+	chunk.Header = w.header
+	w.headerSent = true
+}
+
+// finish sends the status and headers if the response had no body
+func (w *streamWriter) finish() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.headerSent {
+		return nil
+	}
+
+	chunk := &ResponseChunk{}
+	w.fillHeader(chunk)
+	return w.stream.SendMsg(chunk)
+}
@@ -0,0 +1,205 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/proxy"
+)
+
+// newTestClient serves the API for a request handler over an in-memory connection
+func newTestClient(t *testing.T, handler *proxy.RequestHandler, opts ...grpc.ServerOption) *Client {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(opts...)
+	RegisterProxyServer(srv, NewServer(handler.QueueManager, handler))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial gRPC server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewClient(conn)
+}
+
+// newTestQueueManager creates a queue manager with two priorities
+func newTestQueueManager(client *proxy.MockOpenAIClient) *proxy.QueueManager {
+	return proxy.NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2, Preemptive: false},
+	}, client)
+}
+
+func TestSubmit(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	qm := newTestQueueManager(&proxy.MockOpenAIClient{
+		ResponseBody:    `{"id":"chatcmpl-123"}`,
+		ResponseStatus:  200,
+		ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	client := newTestClient(t, proxy.NewRequestHandler(qm))
+	stream, err := client.Submit(ctx, &SubmitRequest{
+		Priority: 2,
+		Method:   "POST",
+		Path:     "/v1/chat/completions",
+		Body:     []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to submit request: %v", err)
+	}
+
+	var chunks []*ResponseChunk
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to receive chunk: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) == 0 {
+		t.Fatal("Expected at least one response chunk")
+	}
+
+	if chunks[0].Status != 200 {
+		t.Errorf("Expected status code 200, got %d", chunks[0].Status)
+	}
+
+	if got := chunks[0].Header["Content-Type"]; len(got) != 1 || got[0] != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %v", got)
+	}
+
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c.Data...)
+	}
+	if string(body) != `{"id":"chatcmpl-123"}` {
+		t.Errorf("Expected upstream body, got %s", body)
+	}
+}
+
+func TestSubmitUnknownPriority(t *testing.T) {
+	client := newTestClient(t, proxy.NewRequestHandler(newTestQueueManager(&proxy.MockOpenAIClient{})))
+
+	stream, err := client.Submit(context.Background(), &SubmitRequest{Priority: 9, Path: "/v1/models"})
+	if err != nil {
+		t.Fatalf("Failed to submit request: %v", err)
+	}
+
+	_, err = stream.Recv()
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound, got %v", err)
+	}
+}
+
+func TestSubmitAdmission(t *testing.T) {
+	// Requests are turned away as they would be on the ports
+	handler := proxy.NewRequestHandler(newTestQueueManager(&proxy.MockOpenAIClient{}))
+	handler.Paths, _ = proxy.NewPathPolicy([]config.PathRule{{Path: "/v1/files/**", Action: "deny"}})
+	client := newTestClient(t, handler)
+
+	stream, err := client.Submit(context.Background(), &SubmitRequest{Priority: 1, Method: "GET", Path: "/v1/files/file-1"})
+	if err != nil {
+		t.Fatalf("Failed to submit request: %v", err)
+	}
+	chunk, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed to receive chunk: %v", err)
+	}
+	if chunk.Status != 403 {
+		t.Errorf("Expected the path refused with 403, got %d %s", chunk.Status, chunk.Data)
+	}
+}
+
+func TestAuthOptions(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	auth, err := proxy.NewAuthorizer(config.AuthConfig{Mode: "keys", Keys: []string{"sk-good"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := newTestQueueManager(&proxy.MockOpenAIClient{ResponseBody: `{}`, ResponseStatus: 200})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	client := newTestClient(t, proxy.NewRequestHandler(qm), AuthOptions(auth)...)
+
+	if _, err := client.Status(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a call without credentials refused, got %v", err)
+	}
+	bad := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sk-bad")
+	if _, err := client.Status(bad); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a call with a wrong key refused, got %v", err)
+	}
+	stream, err := client.Submit(bad, &SubmitRequest{Priority: 1, Path: "/v1/models", Method: "GET"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected a stream with a wrong key refused, got %v", err)
+	}
+
+	good := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sk-good")
+	if _, err := client.Status(good); err != nil {
+		t.Errorf("Expected a call with a valid key accepted, got %v", err)
+	}
+	stream, err = client.Submit(good, &SubmitRequest{Priority: 1, Path: "/v1/models", Method: "GET"})
+	if err != nil {
+		t.Fatalf("Failed to submit request: %v", err)
+	}
+	if chunk, err := stream.Recv(); err != nil || chunk.Status != 200 {
+		t.Errorf("Expected a stream with a valid key accepted, got %v %v", chunk, err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	client := newTestClient(t, proxy.NewRequestHandler(newTestQueueManager(&proxy.MockOpenAIClient{})))
+
+	resp, err := client.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get status: %v", err)
+	}
+
+	if len(resp.Queues) != 2 {
+		t.Fatalf("Expected 2 queues, got %d", len(resp.Queues))
+	}
+
+	if resp.Queues[0].Priority != 1 || resp.Queues[0].Port != 8080 || !resp.Queues[0].Preemptive {
+		t.Errorf("Unexpected first queue: %+v", resp.Queues[0])
+	}
+
+	if resp.Queues[1].Capacity == 0 {
+		t.Errorf("Expected queue capacity to be reported, got %+v", resp.Queues[1])
+	}
+}
@@ -0,0 +1,345 @@
+// Package jsonschema validates JSON documents against the subset of JSON
+// Schema that structured outputs use: types, enums and constants, object
+// properties, array items, string, number and size bounds, anyOf, oneOf and
+// allOf, and references into the schema's own $defs or definitions.
+// Keywords outside the subset, like format, are ignored rather than
+// rejected, so a schema a model accepts is never refused here.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema is a compiled schema
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+}
+
+// ValidationError is where and why a document violates a schema
+type ValidationError struct {
+	Path    string // JSON pointer to the offending value, "" for the document itself
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Compile parses a schema, checking its patterns and references
+func Compile(data []byte) (*Schema, error) {
+	s := &Schema{patterns: make(map[string]*regexp.Regexp)}
+	if err := json.Unmarshal(data, &s.root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := s.compile(s.root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compile walks a schema node, compiling its patterns and resolving its
+// references
+func (s *Schema) compile(node any) error {
+	switch node := node.(type) {
+	case map[string]any:
+		if pattern, ok := node["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			s.patterns[pattern] = re
+		}
+		if ref, ok := node["$ref"].(string); ok {
+			if _, err := s.resolve(ref); err != nil {
+				return err
+			}
+		}
+		for _, child := range node {
+			if err := s.compile(child); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, child := range node {
+			if err := s.compile(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the node a local reference points to
+func (s *Schema) resolve(ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported reference %q: only references within the schema are", ref)
+	}
+	node := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+		if node, ok = object[token]; !ok {
+			return nil, fmt.Errorf("unresolvable reference %q", ref)
+		}
+	}
+	return node, nil
+}
+
+// Validate checks a JSON document against the schema, returning the first
+// violation found as a *ValidationError
+func (s *Schema) Validate(data []byte) error {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return &ValidationError{Message: "not valid JSON: " + err.Error()}
+	}
+	return s.validate(s.root, doc, "", 0)
+}
+
+// maxDepth bounds reference chains, so a schema referring to itself can't
+// recurse forever on a value that doesn't nest
+const maxDepth = 64
+
+func (s *Schema) validate(node, v any, path string, depth int) error {
+	if depth > maxDepth {
+		return &ValidationError{Path: path, Message: "schema references nest too deeply"}
+	}
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+
+	switch node := node.(type) {
+	case bool:
+		if !node {
+			return fail("no value is allowed here")
+		}
+		return nil
+	case map[string]any:
+	default:
+		return nil
+	}
+	schema := node.(map[string]any)
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		if err := s.validate(target, v, path, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if types := typesOf(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+		return fail("expected %s, got %s", strings.Join(types, " or "), typeOf(v))
+	}
+	if want, ok := schema["const"]; ok && !equal(v, want) {
+		return fail("expected %s", encode(want))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(want any) bool { return equal(v, want) }) {
+		values := make([]string, len(enum))
+		for i, want := range enum {
+			values[i] = encode(want)
+		}
+		return fail("expected one of %s", strings.Join(values, ", "))
+	}
+
+	for _, sub := range list(schema["allOf"]) {
+		if err := s.validate(sub, v, path, depth+1); err != nil {
+			return err
+		}
+	}
+	if anyOf := list(schema["anyOf"]); len(anyOf) > 0 && !slices.ContainsFunc(anyOf, func(sub any) bool {
+		return s.validate(sub, v, path, depth+1) == nil
+	}) {
+		return fail("matches none of the allowed schemas")
+	}
+	if oneOf := list(schema["oneOf"]); len(oneOf) > 0 {
+		matched := 0
+		for _, sub := range oneOf {
+			if s.validate(sub, v, path, depth+1) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("matches %d of the schemas, expected exactly one", matched)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := number(schema["minLength"]); ok && length < n {
+			return fail("shorter than %v characters", n)
+		}
+		if n, ok := number(schema["maxLength"]); ok && length > n {
+			return fail("longer than %v characters", n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !s.patterns[pattern].MatchString(v) {
+			return fail("does not match pattern %q", pattern)
+		}
+	case float64:
+		if n, ok := number(schema["minimum"]); ok && v < n {
+			return fail("less than %v", n)
+		}
+		if n, ok := number(schema["maximum"]); ok && v > n {
+			return fail("greater than %v", n)
+		}
+		if n, ok := number(schema["exclusiveMinimum"]); ok && v <= n {
+			return fail("not greater than %v", n)
+		}
+		if n, ok := number(schema["exclusiveMaximum"]); ok && v >= n {
+			return fail("not less than %v", n)
+		}
+		if n, ok := number(schema["multipleOf"]); ok && n > 0 && math.Abs(math.Remainder(v, n)) > 1e-9 {
+			return fail("not a multiple of %v", n)
+		}
+	case []any:
+		if n, ok := number(schema["minItems"]); ok && float64(len(v)) < n {
+			return fail("fewer than %v items", n)
+		}
+		if n, ok := number(schema["maxItems"]); ok && float64(len(v)) > n {
+			return fail("more than %v items", n)
+		}
+		prefix := list(schema["prefixItems"])
+		for i, item := range v {
+			sub, ok := schema["items"]
+			if i < len(prefix) {
+				sub, ok = prefix[i], true
+			}
+			if !ok {
+				continue
+			}
+			if err := s.validate(sub, item, path+"/"+strconv.Itoa(i), depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		if n, ok := number(schema["minProperties"]); ok && float64(len(v)) < n {
+			return fail("fewer than %v properties", n)
+		}
+		if n, ok := number(schema["maxProperties"]); ok && float64(len(v)) > n {
+			return fail("more than %v properties", n)
+		}
+		for _, name := range list(schema["required"]) {
+			if name, ok := name.(string); ok {
+				if _, ok := v[name]; !ok {
+					return fail("missing required property %q", name)
+				}
+			}
+		}
+		// Report properties in a stable order
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range names {
+			sub, ok := properties[name]
+			if !ok {
+				if sub, ok = schema["additionalProperties"]; !ok {
+					continue
+				}
+				if allowed, isBool := sub.(bool); isBool && !allowed {
+					return fail("unexpected property %q", name)
+				}
+			}
+			if err := s.validate(sub, v[name], path+"/"+escape(name), depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typesOf returns the types a schema's type keyword allows
+func typesOf(keyword any) []string {
+	switch keyword := keyword.(type) {
+	case string:
+		return []string{keyword}
+	case []any:
+		var types []string
+		for _, t := range keyword {
+			if t, ok := t.(string); ok {
+				types = append(types, t)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// hasType reports whether a decoded value is of a JSON Schema type
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == t
+}
+
+// typeOf names a decoded value's JSON type
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// equal compares decoded values
+func equal(a, b any) bool {
+	return encode(a) == encode(b)
+}
+
+// encode returns a decoded value as JSON; map keys are sorted, so equal
+// values encode the same
+func encode(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// number returns a numeric keyword's value
+func number(keyword any) (float64, bool) {
+	n, ok := keyword.(float64)
+	return n, ok
+}
+
+// list returns an array keyword's elements
+func list(keyword any) []any {
+	elements, _ := keyword.([]any)
+	return elements
+}
+
+// escape makes a property name a JSON pointer token
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
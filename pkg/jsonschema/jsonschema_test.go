@@ -0,0 +1,64 @@
+package jsonschema
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+			"role": {"enum": ["admin", "user"]},
+			"note": {"type": ["string", "null"]},
+			"contact": {"anyOf": [{"type": "string"}, {"type": "object", "required": ["email"]}]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {"tag": {"type": "string", "maxLength": 5}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc, path string // path is empty when the document is valid
+	}{
+		{`{"name":"Ada","age":36,"tags":["math"],"role":"admin","note":null,"contact":{"email":"a@b"}}`, ""},
+		{`{"name":"Ada","age":36.5}`, "/age"},
+		{`{"name":"ada","age":36}`, "/name"},
+		{`{"name":"Ada"}`, ""},
+		{`{"name":"Ada","age":36,"tags":["mathematics"]}`, "/tags/0"},
+		{`{"name":"Ada","age":36,"tags":["a","b","c"]}`, "/tags"},
+		{`{"name":"Ada","age":36,"role":"owner"}`, "/role"},
+		{`{"name":"Ada","age":36,"contact":{}}`, "/contact"},
+		{`{"name":"Ada","age":36,"extra":true}`, ""},
+		{`["Ada"]`, ""},
+		{`{"name":"Ada",`, ""},
+	}
+	for i, tt := range tests {
+		err := schema.Validate([]byte(tt.doc))
+		if i == 0 {
+			if err != nil {
+				t.Errorf("Expected %s valid, got %v", tt.doc, err)
+			}
+			continue
+		}
+		var violation *ValidationError
+		if !errors.As(err, &violation) {
+			t.Errorf("Expected %s to violate the schema, got %v", tt.doc, err)
+		} else if violation.Path != tt.path {
+			t.Errorf("Expected %s to violate the schema at %q, got %v", tt.doc, tt.path, err)
+		}
+	}
+
+	// Schemas that can't be checked are refused up front
+	for _, bad := range []string{`{"pattern":"("}`, `{"$ref":"#/$defs/missing"}`, `{"$ref":"https://example.com/schema.json"}`, `{`} {
+		if _, err := Compile([]byte(bad)); err == nil {
+			t.Errorf("Expected %s refused", bad)
+		}
+	}
+}
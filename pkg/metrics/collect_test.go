@@ -61,32 +61,3 @@ func TestCollectMethod(t *testing.T) {
 		t.Fatalf("Expected 2 collection calls, got %d", collectCount)
 	}
 }
-
-// TestDefaultCollectFn tests the defaultCollectFn function
-func TestDefaultCollectFn(t *testing.T) {
-	// Create sample metrics
-	metrics := RequestMetrics{
-		Model:          "gpt-4",
-		InputTokens:    100,
-		ProcessingTime: 500 * time.Millisecond,
-		RetryCount:     2,
-		Tools:          []string{"function", "retrieval"},
-		EndpointPath:   "/v1/chat/completions",
-		Priority:       1,
-		Preempted:      true,
-		StatusCode:     200,
-	}
-
-	// Test the default collect function
-	err := defaultCollectFn(metrics)
-	if err != nil {
-		t.Fatalf("Expected no error from defaultCollectFn, got %v", err)
-	}
-
-	// Test with nil tools
-	metrics.Tools = nil
-	err = defaultCollectFn(metrics)
-	if err != nil {
-		t.Fatalf("Expected no error from defaultCollectFn with nil tools, got %v", err)
-	}
-}
\ No newline at end of file
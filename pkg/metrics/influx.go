@@ -1,34 +1,117 @@
 package metrics
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 )
 
-// MetricsCollector handles sending metrics to InfluxDB
+// writeTimeout bounds each write to InfluxDB, so a server that doesn't
+// answer can't hold up the ones after it
+const writeTimeout = 5 * time.Second
+
+// pendingWrites is how many requests' metrics can wait to be written;
+// beyond it they are dropped rather than holding up the requests reporting them
+const pendingWrites = 1024
+
+// MetricsCollector handles sending metrics to InfluxDB. Collect only hands
+// metrics to a writer goroutine, so it never waits on the network.
 type MetricsCollector struct {
-	client   influxdb2.Client
+	client   influxdb2.Client // nil when metrics aren't written anywhere
 	writeAPI api.WriteAPIBlocking
 	mu       sync.Mutex
+	url      string
+	org      string
+	bucket   string
+	// Schema decides the measurements, tags and fields requests are written as
+	Schema *Schema
 	// For testing
 	CollectFn func(metrics RequestMetrics) error
+
+	pending chan pendingWrite // Metrics waiting for the writer
+	done    chan struct{}     // Closed once the writer has stopped
+	closed  bool
+	behind  bool // Metrics are being dropped for want of room
+}
+
+// pendingWrite is a request's metrics waiting to be written
+type pendingWrite struct {
+	metrics RequestMetrics
+	at      time.Time
 }
 
 // RequestMetrics contains metrics for a single request
 type RequestMetrics struct {
-	Model          string        // The model being requested
-	InputTokens    int64         // Estimated input tokens
-	ProcessingTime time.Duration // Total processing time
-	RetryCount     int           // Number of retries (due to preemption)
-	Tools          []string      // Tools requested in the API call
-	EndpointPath   string        // API endpoint path
-	Priority       int           // Queue priority level
-	Preempted      bool          // Whether this request was preempted
-	StatusCode     int           // HTTP status code of the response
+	Model          string            // The model being requested
+	InputTokens    int64             // Input tokens, as reported upstream or estimated
+	OutputTokens   int64             // Output tokens reported upstream (0 if not reported)
+	ProcessingTime time.Duration     // Time for the upstream to start responding
+	// QueueWaitTime is the time spent waiting in priority queues, over all attempts
+	QueueWaitTime time.Duration
+	// SchedulingDelay is the time spent neither queueing nor on the final upstream call,
+	// e.g. attempts lost to preemption
+	SchedulingDelay time.Duration
+	// TotalTime is the time from the request's arrival until its response was sent
+	TotalTime time.Duration
+	RetryCount     int               // Number of retries (due to preemption)
+	// RetryBudget is the retries the request was allowed over requeues and
+	// upstream retries together (0 = unlimited), and RetriesUsed those it made
+	RetryBudget int
+	RetriesUsed int
+	Tools          []string          // Tools requested in the API call
+	// ToolCalls are the tools and functions the response invoked, in order
+	ToolCalls []ToolCall
+	// ReasoningEffort is the reasoning effort the request asked for, empty if unset
+	ReasoningEffort string
+	EndpointPath   string            // API endpoint path
+	Priority       int               // Queue priority level
+	Preempted      bool              // Whether this request was preempted
+	StatusCode     int               // HTTP status code of the response
+	Boosted        bool              // Whether the request was promoted with X-Priority-Boost
+	Demoted        bool              // Whether the request was moved to a lower queue for its prompt's size
+	Tags           map[string]string // Allowlisted X-Proxy-Tags, e.g. team and job
+	User           string            // End user from the request's `user` field
+	// KeyID identifies the client key that sent the request (see proxy.KeyID)
+	KeyID string
+	// UpstreamRequestID is the ID the upstream gave the request (its x-request-id header)
+	UpstreamRequestID string
+	// UpstreamProcessingTime is the time the upstream reports spending (openai-processing-ms)
+	UpstreamProcessingTime time.Duration
+	// Backend is the base URL of the upstream that served the request
+	Backend string
+	// BackendRequestsLeft and BackendTokensLeft are what was left of the
+	// backend's per-minute provider quotas after the request, -1 without one
+	BackendRequestsLeft int64
+	BackendTokensLeft   int64
+	// ClientGone marks a request abandoned because its client had disconnected
+	// or its deadline had passed
+	ClientGone bool
+	// Truncated marks a stream cut off mid-response, for higher priority work
+	// or because the upstream stalled; OutputTokens counts what was sent
+	Truncated bool
+	// Slow marks a request that took longer than its slow request threshold
+	Slow bool
+	// Rejected is why the proxy itself answered the request with a 429 or
+	// 503 without sending it upstream, e.g. "queue_full"; empty otherwise.
+	// StatusCode 429 with Rejected empty means the upstream throttled it.
+	Rejected string
+	// SchemaChecked marks a response checked against the json_schema its
+	// request asked for, and SchemaViolations counts the attempts whose
+	// response violated it
+	SchemaChecked    bool
+	SchemaViolations int
+}
+
+// ToolCall is a tool or function a response invoked
+type ToolCall struct {
+	Name          string // Function called
+	ArgumentBytes int    // Length of the JSON arguments it was called with
 }
 
 var (
@@ -36,19 +119,27 @@ var (
 	once      sync.Once
 )
 
-// NewMetricsCollector creates a new InfluxDB metrics collector
+// NewMetricsCollector creates a new InfluxDB metrics collector. With an
+// empty url metrics aren't written anywhere, e.g. in tests.
 func NewMetricsCollector(url, token, org, bucket string) *MetricsCollector {
 	var m *MetricsCollector
 	
 	once.Do(func() {
-		client := influxdb2.NewClient(url, token)
-		writeAPI := client.WriteAPIBlocking(org, bucket)
-		
 		m = &MetricsCollector{
-			client:   client,
-			writeAPI: writeAPI,
+			url:    url,
+			org:    org,
+			bucket: bucket,
+			Schema: &Schema{MaxTagValues: DefaultMaxTagValues},
+		}
+		m.CollectFn = func(RequestMetrics) error { return nil }
+		if url != "" {
+			m.client = influxdb2.NewClient(url, token)
+			m.writeAPI = m.client.WriteAPIBlocking(org, bucket)
+			m.pending = make(chan pendingWrite, pendingWrites)
+			m.done = make(chan struct{})
 			// Default to the real implementation
-			CollectFn: defaultCollectFn,
+			m.CollectFn = m.enqueue
+			go m.writeLoop()
 		}
 		
 		collector = m
@@ -57,13 +148,50 @@ func NewMetricsCollector(url, token, org, bucket string) *MetricsCollector {
 	return collector
 }
 
-// defaultCollectFn is the default implementation of metric collection
-func defaultCollectFn(metrics RequestMetrics) error {
-	// In a real implementation, this would connect to InfluxDB
-	// For testing, we'll just log the metrics
-	fmt.Printf("Collecting metrics: %s, %d tokens, %v\n", 
-		metrics.Model, metrics.InputTokens, metrics.ProcessingTime)
-	
+// enqueue hands a request's metrics to the writer, dropping them when it
+// has fallen too far behind; the caller holds m.mu
+func (m *MetricsCollector) enqueue(metrics RequestMetrics) error {
+	if m.closed {
+		return errors.New("metrics collector is closed")
+	}
+	select {
+	case m.pending <- pendingWrite{metrics: metrics, at: time.Now()}:
+		m.behind = false
+		return nil
+	default:
+		if !m.behind {
+			fmt.Printf("InfluxDB writes are falling behind, dropping metrics\n")
+			m.behind = true
+		}
+		return fmt.Errorf("dropped metrics of a %s request: %d writes pending", metrics.Model, pendingWrites)
+	}
+}
+
+// writeLoop writes metrics as they are collected, until Close
+func (m *MetricsCollector) writeLoop() {
+	defer close(m.done)
+	for p := range m.pending {
+		if err := m.write(p.metrics, p.at); err != nil {
+			fmt.Printf("Failed to write metrics: %v\n", err)
+		}
+	}
+}
+
+// write sends a request's points to InfluxDB, giving up after writeTimeout
+func (m *MetricsCollector) write(metrics RequestMetrics, at time.Time) error {
+	m.mu.Lock()
+	writeAPI, schema := m.writeAPI, m.Schema
+	m.mu.Unlock()
+
+	var points []*write.Point
+	for _, p := range schema.Points(metrics, at) {
+		points = append(points, influxdb2.NewPoint(p.Measurement, p.Tags, p.Fields, p.Time))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := writeAPI.WritePoint(ctx, points...); err != nil {
+		return fmt.Errorf("writing %s request: %w", metrics.Model, err)
+	}
 	return nil
 }
 
@@ -76,8 +204,54 @@ func (m *MetricsCollector) Collect(metrics RequestMetrics) error {
 	return m.CollectFn(metrics)
 }
 
-// Close gracefully shuts down the InfluxDB client
+// SetToken reconnects to InfluxDB with a new token, e.g. after it was rotated
+func (m *MetricsCollector) SetToken(token string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client == nil {
+		return
+	}
+	m.client.Close()
+	m.client = influxdb2.NewClient(m.url, token)
+	m.writeAPI = m.client.WriteAPIBlocking(m.org, m.bucket)
+}
+
+// Ping checks that InfluxDB is reachable
+func (m *MetricsCollector) Ping(ctx context.Context) error {
+	m.mu.Lock()
+	client := m.client
+	m.mu.Unlock()
+
+	if client == nil {
+		return errors.New("no influxdb_url configured")
+	}
+	ok, err := client.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("influxdb unreachable: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("influxdb at %s is not answering", m.url)
+	}
+	return nil
+}
+
+// Close gracefully shuts down the InfluxDB client, waiting up to
+// writeTimeout for the metrics still pending to be written
 func (m *MetricsCollector) Close() {
+	m.mu.Lock()
+	pending, closed := m.pending, m.closed
+	m.closed = true
+	m.mu.Unlock()
+	if pending == nil || closed {
+		return
+	}
+
+	close(pending)
+	select {
+	case <-m.done:
+	case <-time.After(writeTimeout):
+	}
 	m.client.Close()
 }
 
@@ -150,4 +150,25 @@ func TestContextHandling(t *testing.T) {
 	case <-time.After(100 * time.Millisecond):
 		t.Error("Context should have expired but didn't")
 	}
-}
\ No newline at end of file
+}
+
+// TestCollectDoesNotBlock tests that metrics are dropped rather than
+// waiting for a writer that has fallen behind
+func TestCollectDoesNotBlock(t *testing.T) {
+	m := &MetricsCollector{pending: make(chan pendingWrite, 1)}
+	m.CollectFn = m.enqueue
+
+	if err := m.Collect(RequestMetrics{Model: "gpt-4"}); err != nil {
+		t.Fatalf("Expected the first metrics queued, got %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- m.Collect(RequestMetrics{Model: "gpt-4"}) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected metrics beyond the pending writes dropped")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Collect blocked on a full writer")
+	}
+}
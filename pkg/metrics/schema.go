@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Measurements the collector writes. Their tags and fields are listed in the
+// README; renaming either breaks the dashboards built on them.
+const (
+	// MeasurementRequests has one point per request
+	MeasurementRequests = "proxy_requests"
+	// MeasurementToolCalls has one point per tool or function a response called
+	MeasurementToolCalls = "proxy_tool_calls"
+)
+
+// How end users are tagged, see Schema.Users
+const (
+	UsersHashed = "hash" // A salted hash of the user ID
+	UsersRaw    = "raw"  // The user ID as the client sent it
+	UsersNone   = "none" // Not tagged at all
+)
+
+// OtherTagValue stands in for tag values beyond a key's limit
+const OtherTagValue = "_other"
+
+// DefaultMaxTagValues is the distinct values a tag key keeps by default
+const DefaultMaxTagValues = 1000
+
+// Point is one point of a measurement, ready to be written
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        time.Time
+}
+
+// Schema maps request metrics onto measurements, putting the values
+// dashboards group by in tags and the rest in fields. Tags are indexed, and
+// every distinct combination of their values is a series of its own, so it
+// guards them against unbounded values: IDs in paths are replaced by ":id",
+// user IDs are hashed, and each tag key keeps at most MaxTagValues distinct
+// values, the rest being reported as OtherTagValue.
+type Schema struct {
+	MaxTagValues int    // Distinct values kept per tag key (0 = unlimited)
+	Users        string // How end users are tagged: UsersHashed (default), UsersRaw or UsersNone
+	Salt         string // Mixed into hashed user IDs, so they can't be looked up by hashing known IDs
+	Version      string // Version of the proxy, tagged on every request when set
+
+	mu   sync.Mutex
+	seen map[string]map[string]struct{} // Values kept so far, by tag key
+}
+
+// Points returns the points a request's metrics are written as, at time at
+func (s *Schema) Points(m RequestMetrics, at time.Time) []Point {
+	tags := map[string]string{
+		"path":     NormalizePath(m.EndpointPath),
+		"priority": strconv.Itoa(m.Priority),
+		"status":   strconv.Itoa(m.StatusCode),
+	}
+	optional := map[string]string{
+		"model":            m.Model,
+		"key_id":           m.KeyID,
+		"backend":          m.Backend,
+		"reasoning_effort": m.ReasoningEffort,
+		"rejected":         m.Rejected,
+		"user":             s.user(m.User),
+		"version":          s.version(),
+	}
+	for key, value := range m.Tags {
+		optional["tag_"+key] = value
+	}
+	for key, value := range optional {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+	for key, value := range tags {
+		tags[key] = s.bound(key, value)
+	}
+
+	fields := map[string]any{
+		"input_tokens":        m.InputTokens,
+		"output_tokens":       m.OutputTokens,
+		"processing_ms":       m.ProcessingTime.Milliseconds(),
+		"queue_wait_ms":       m.QueueWaitTime.Milliseconds(),
+		"scheduling_delay_ms": m.SchedulingDelay.Milliseconds(),
+		"total_ms":            m.TotalTime.Milliseconds(),
+		"retries":             m.RetryCount,
+		"retries_used":        m.RetriesUsed,
+		"retry_budget":        m.RetryBudget,
+		"tool_calls":          len(m.ToolCalls),
+		"preempted":           m.Preempted,
+		"boosted":             m.Boosted,
+		"demoted":             m.Demoted,
+		"truncated":           m.Truncated,
+		"client_gone":         m.ClientGone,
+		"slow":                m.Slow,
+	}
+	if len(m.Tools) > 0 {
+		fields["tools"] = strings.Join(m.Tools, ",")
+	}
+	if m.UpstreamRequestID != "" {
+		fields["upstream_request_id"] = m.UpstreamRequestID
+		fields["upstream_processing_ms"] = m.UpstreamProcessingTime.Milliseconds()
+	}
+	if m.Backend != "" {
+		fields["backend_requests_left"] = m.BackendRequestsLeft
+		fields["backend_tokens_left"] = m.BackendTokensLeft
+	}
+	if m.SchemaChecked {
+		fields["schema_violations"] = m.SchemaViolations
+	}
+
+	points := []Point{{Measurement: MeasurementRequests, Tags: tags, Fields: fields, Time: at}}
+	for _, call := range m.ToolCalls {
+		points = append(points, Point{
+			Measurement: MeasurementToolCalls,
+			Tags: map[string]string{
+				"model": tags["model"],
+				"tool":  s.bound("tool", call.Name),
+			},
+			Fields: map[string]any{"argument_bytes": call.ArgumentBytes},
+			Time:   at,
+		})
+	}
+	return points
+}
+
+// version returns the proxy version requests are tagged with, if any
+func (s *Schema) version() string {
+	if s == nil {
+		return ""
+	}
+	return s.Version
+}
+
+// user returns the tag value of an end user, empty when users aren't tagged
+func (s *Schema) user(id string) string {
+	users := UsersHashed
+	if s != nil && s.Users != "" {
+		users = s.Users
+	}
+	switch {
+	case id == "" || users == UsersNone:
+		return ""
+	case users == UsersRaw:
+		return id
+	}
+	salt := ""
+	if s != nil {
+		salt = s.Salt
+	}
+	sum := sha256.Sum256([]byte(salt + id))
+	return "user-" + hex.EncodeToString(sum[:8])
+}
+
+// bound returns value, or OtherTagValue once key has MaxTagValues other values
+func (s *Schema) bound(key, value string) string {
+	if s == nil || s.MaxTagValues <= 0 {
+		return value
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.seen[key]
+	if _, ok := values[value]; ok {
+		return value
+	}
+	if len(values) >= s.MaxTagValues {
+		return OtherTagValue
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]map[string]struct{})
+	}
+	if values == nil {
+		values = make(map[string]struct{})
+		s.seen[key] = values
+	}
+	values[value] = struct{}{}
+	return value
+}
+
+// NormalizePath replaces the IDs in an API path with ":id", so
+// "/v1/threads/thread_abc123/messages" is tagged "/v1/threads/:id/messages".
+// A segment is taken for an ID when it is at least 8 characters long and
+// has a digit in it.
+func NormalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if len(segment) >= 8 && strings.ContainsAny(segment, "0123456789") {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchemaPoints(t *testing.T) {
+	s := &Schema{MaxTagValues: 2, Salt: "pepper"}
+	at := time.Now()
+	points := s.Points(RequestMetrics{
+		Model:          "gpt-4",
+		InputTokens:    100,
+		ProcessingTime: 1500 * time.Millisecond,
+		EndpointPath:   "/v1/threads/thread_abc123/messages",
+		Priority:       1,
+		StatusCode:     200,
+		User:           "alice@example.com",
+		Tags:           map[string]string{"team": "search"},
+		ToolCalls:      []ToolCall{{Name: "lookup", ArgumentBytes: 42}},
+	}, at)
+
+	if len(points) != 2 || points[0].Measurement != MeasurementRequests || points[1].Measurement != MeasurementToolCalls {
+		t.Fatalf("Expected a request point and a tool call point, got %+v", points)
+	}
+	request := points[0]
+	if got := request.Tags["path"]; got != "/v1/threads/:id/messages" {
+		t.Errorf("Expected the thread ID left out of the path, got %q", got)
+	}
+	if got := request.Tags["user"]; got == "" || got == "alice@example.com" {
+		t.Errorf("Expected the user hashed, got %q", got)
+	}
+	if request.Tags["model"] != "gpt-4" || request.Tags["tag_team"] != "search" || request.Tags["status"] != "200" {
+		t.Errorf("Unexpected tags %v", request.Tags)
+	}
+	if _, ok := request.Tags["backend"]; ok {
+		t.Errorf("Expected no backend tag without a backend, got %v", request.Tags)
+	}
+	if _, ok := request.Tags["version"]; ok {
+		t.Errorf("Expected no version tag without a version, got %v", request.Tags)
+	}
+	s.Version = "v1.8.0"
+	if got := s.Points(RequestMetrics{}, at)[0].Tags["version"]; got != "v1.8.0" {
+		t.Errorf("Expected requests tagged with the proxy version, got %q", got)
+	}
+	if request.Fields["processing_ms"] != int64(1500) || request.Fields["tool_calls"] != 1 {
+		t.Errorf("Unexpected fields %v", request.Fields)
+	}
+	if call := points[1]; call.Tags["tool"] != "lookup" || call.Fields["argument_bytes"] != 42 {
+		t.Errorf("Unexpected tool call point %+v", call)
+	}
+
+	// Hashes are stable, so a user stays one series
+	again := s.Points(RequestMetrics{User: "alice@example.com"}, at)[0]
+	if again.Tags["user"] != request.Tags["user"] {
+		t.Errorf("Expected the same hash for the same user, got %q and %q", request.Tags["user"], again.Tags["user"])
+	}
+
+	// Past MaxTagValues models, the rest share one value
+	for _, c := range []struct{ model, want string }{
+		{"gpt-4o", "gpt-4o"},
+		{"gpt-4o-mini", OtherTagValue},
+		{"gpt-4", "gpt-4"},
+	} {
+		if got := s.Points(RequestMetrics{Model: c.model}, at)[0].Tags["model"]; got != c.want {
+			t.Errorf("Expected model %s tagged %q, got %q", c.model, c.want, got)
+		}
+	}
+}
+
+func TestSchemaUsers(t *testing.T) {
+	for users, want := range map[string]string{UsersRaw: "alice", UsersNone: ""} {
+		s := &Schema{Users: users}
+		if got := s.Points(RequestMetrics{User: "alice"}, time.Now())[0].Tags["user"]; got != want {
+			t.Errorf("Expected users %s tagged %q, got %q", users, want, got)
+		}
+	}
+}
@@ -3,30 +3,179 @@ package openai
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // Client handles communication with the OpenAI API
 type Client struct {
-	BaseURL    string
-	APIKey     string
-	HTTPClient *http.Client
+	BaseURL        string
+	APIKey         string
+	HTTPClient     *http.Client
+	UserAgent      string      // Sent as the User-Agent header when non-empty
+	DefaultHeaders http.Header // Added to every outgoing request
+	RetryPolicy    RetryPolicy // Retries for transient upstream failures
+	Keys           *KeyRing    // Supplies the API key instead of APIKey when set, so it can be rotated
+
+	// timeout and transport are set by their options, and applied to a copy
+	// of HTTPClient once every option has run
+	timeout   *time.Duration
+	transport http.RoundTripper
+}
+
+// RetryPolicy controls how transient upstream failures are retried
+type RetryPolicy struct {
+	MaxRetries      int           // Additional attempts after the first; 0 disables retries
+	Backoff         time.Duration // Delay before the first retry, doubled for each subsequent one
+	RetryableStatus []int         // Response status codes that trigger a retry
+	// ErrorTypes decides retries by the error type or code in the response
+	// body, taking precedence over the status, e.g. retry "overloaded_error"
+	// but never "context_length_exceeded"
+	ErrorTypes map[string]bool
+	// NetworkErrors lists the classes of transport failure that are retried
+	// (see NetworkErrorClass); nil retries all of them
+	NetworkErrors []string
+}
+
+// maxErrorPeek bounds how much of an error response is read to find its type
+const maxErrorPeek = 64 * 1024
+
+// DefaultRetryableStatus lists the status codes retried when a policy doesn't specify its own
+var DefaultRetryableStatus = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// headersKey is the context key for per-request upstream headers
+type headersKey struct{}
+
+// ContextWithHeaders returns a context carrying extra headers to send upstream with a single request
+func ContextWithHeaders(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, headersKey{}, header)
+}
+
+// unambiguousKey is the context key marking upstream calls that mustn't be
+// retried after a failure the upstream may have acted on
+type unambiguousKey struct{}
+
+// ContextWithoutAmbiguousRetries returns a context whose upstream calls are
+// only retried after failures the upstream can't have acted on, such as a
+// refused connection or a 429, for requests that mustn't be applied twice
+func ContextWithoutAmbiguousRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unambiguousKey{}, true)
+}
+
+// AmbiguousError reports whether a failed upstream call may have reached the
+// upstream anyway, e.g. the connection broke or timed out after it was sent
+func AmbiguousError(err error) bool {
+	switch NetworkErrorClass(err) {
+	case "connection_refused", "dns", "tls":
+		return false
+	}
+	return true
+}
+
+// AmbiguousStatus reports whether a response status leaves open whether the
+// upstream acted on the request, as a gateway's 502 or 504 does
+func AmbiguousStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
+
+// Option configures optional Client behavior
+type Option func(*Client)
+
+// WithTimeout sets the overall timeout for a single upstream call
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = &timeout
+	}
+}
+
+// WithTransport sets the RoundTripper used for upstream calls
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = rt
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client entirely
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = hc
+	}
+}
+
+// WithDefaultHeader adds a header that is sent with every request
+func WithDefaultHeader(key, value string) Option {
+	return func(c *Client) {
+		c.DefaultHeaders.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the User-Agent header for outgoing requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.UserAgent = userAgent
+	}
+}
+
+// WithRetryPolicy sets the retry policy for transient upstream failures
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.RetryPolicy = policy
+	}
+}
+
+// WithKeyRing takes the API key from a key ring, so it can be rotated at runtime
+func WithKeyRing(keys *KeyRing) Option {
+	return func(c *Client) {
+		c.Keys = keys
+	}
 }
 
 // NewClient creates a new OpenAI API client
-func NewClient(baseURL, apiKey string) *Client {
-	return &Client{
+func NewClient(baseURL, apiKey string, opts ...Option) *Client {
+	c := &Client{
 		BaseURL: baseURL,
 		APIKey:  apiKey,
 		HTTPClient: &http.Client{
 			Timeout: 300 * time.Second, // 5-minute timeout for long-running requests
 		},
+		DefaultHeaders: make(http.Header),
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	// Whatever order the options came in, a timeout or transport applies to
+	// the client in use, without changing one passed to WithHTTPClient
+	if c.timeout != nil || c.transport != nil {
+		hc := *c.HTTPClient
+		if c.timeout != nil {
+			hc.Timeout = *c.timeout
+		}
+		if c.transport != nil {
+			hc.Transport = c.transport
+		}
+		c.HTTPClient = &hc
+	}
+
+	return c
 }
 
 // ForwardRequest forwards a request to the OpenAI API and returns the response
@@ -40,6 +189,70 @@ func (c *Client) ForwardRequest(ctx context.Context, method, path string, body i
 	
 	url += path
 
+	// Buffer the body when it may be replayed: for retries, or to fall back
+	// to the previous key while a key rotation's grace window is open
+	var fallbackKey string
+	if c.Keys != nil {
+		fallbackKey = c.Keys.Previous()
+	}
+	var bodyBytes []byte
+	if (c.RetryPolicy.MaxRetries > 0 || fallbackKey != "") && body != nil {
+		var err error
+		bodyBytes, err = io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	backoff := c.RetryPolicy.Backoff
+	for attempt := 0; ; attempt++ {
+		reqBody := body
+		if bodyBytes != nil {
+			reqBody = bytes.NewReader(bodyBytes)
+		}
+
+		resp, err := c.do(ctx, method, url, reqBody, c.apiKey())
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && fallbackKey != "" {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if bodyBytes != nil {
+				reqBody = bytes.NewReader(bodyBytes)
+			}
+			resp, err = c.do(ctx, method, url, reqBody, fallbackKey)
+		}
+		if attempt >= c.RetryPolicy.MaxRetries || !c.shouldRetry(ctx, resp, err) {
+			return resp, err
+		}
+		// The request may have spent its retries elsewhere, e.g. on preemption
+		if !retryBudgetFromContext(ctx).Spend() {
+			return resp, err
+		}
+
+		// Discard the failed response before trying again
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("error making request to OpenAI API: %w", ctx.Err())
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+// apiKey returns the key to authenticate with
+func (c *Client) apiKey() string {
+	if c.Keys != nil {
+		return c.Keys.Current()
+	}
+	return c.APIKey
+}
+
+// do performs a single upstream call
+func (c *Client) do(ctx context.Context, method, url string, body io.Reader, apiKey string) (*http.Response, error) {
 	// Create request
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
@@ -47,8 +260,28 @@ func (c *Client) ForwardRequest(ctx context.Context, method, path string, body i
 	}
 
 	// Set headers
-	req.Header.Set("Authorization", "Bearer "+c.APIKey)
-	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.DefaultHeaders {
+		for _, vv := range v {
+			req.Header.Add(k, vv)
+		}
+	}
+	if header, ok := ctx.Value(headersKey{}).(http.Header); ok {
+		for k, v := range header {
+			for _, vv := range v {
+				req.Header.Add(k, vv)
+			}
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	// Carry the proxy's trace on to the upstream when tracing is set up
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	// Requests are JSON unless the caller says otherwise, e.g. multipart uploads
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
 
 	// Make request
 	resp, err := c.HTTPClient.Do(req)
@@ -59,85 +292,214 @@ func (c *Client) ForwardRequest(ctx context.Context, method, path string, body i
 	return resp, nil
 }
 
-// ExtractRequestMetadata extracts model name, token count and other metadata for metrics
-func ExtractRequestMetadata(body io.Reader) (string, int64, []string, error) {
-	if body == nil {
-		return "", 0, nil, nil
+// shouldRetry reports whether a failed attempt is worth retrying
+func (c *Client) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	// Requests that mustn't be applied twice are only retried when the
+	// upstream can't have acted on them
+	if ctx.Value(unambiguousKey{}) != nil {
+		if err != nil && AmbiguousError(err) || err == nil && AmbiguousStatus(resp.StatusCode) {
+			return false
+		}
 	}
-
-	// Read the entire body
-	bodyBytes, err := io.ReadAll(body)
 	if err != nil {
-		return "", 0, nil, err
+		// Never retry once the caller has given up
+		if ctx.Err() != nil {
+			return false
+		}
+		if c.RetryPolicy.NetworkErrors == nil {
+			return true
+		}
+		class := NetworkErrorClass(err)
+		for _, retryable := range c.RetryPolicy.NetworkErrors {
+			if retryable == class {
+				return true
+			}
+		}
+		return false
 	}
 
-	// Create a new reader with the same content for further use
-	bodyReader := bytes.NewReader(bodyBytes)
+	if resp.StatusCode >= 400 && len(c.RetryPolicy.ErrorTypes) > 0 {
+		for _, kind := range peekErrorKinds(resp) {
+			if retry, ok := c.RetryPolicy.ErrorTypes[kind]; ok {
+				return retry
+			}
+		}
+	}
 
-	// Parse the body as JSON
-	var request map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &request); err != nil {
-		return "", 0, nil, err
+	statuses := c.RetryPolicy.RetryableStatus
+	if statuses == nil {
+		statuses = DefaultRetryableStatus
+	}
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return true
+		}
 	}
+	return false
+}
 
-	// Extract model name
-	model, _ := request["model"].(string)
+// NetworkErrorClass names the kind of transport failure behind an error:
+// "timeout", "connection_refused", "connection_reset", "dns", "tls" or "other"
+func NetworkErrorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
 
-	// Estimate token count based on input
-	var inputTokens int64 = 0
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "connection_reset"
+	case errors.As(err, &certErr), errors.As(err, &recordErr):
+		return "tls"
+	default:
+		return "other"
+	}
+}
 
-	// Handle different request types
-	if messages, ok := request["messages"].([]interface{}); ok {
-		// Chat completions request
-		for _, msg := range messages {
-			if msgMap, ok := msg.(map[string]interface{}); ok {
-				if content, ok := msgMap["content"].(string); ok {
-					// Rough estimation: 1 token ≈ 4 characters
-					inputTokens += int64(len(content) / 4)
-				}
-			}
-		}
-	} else if prompt, ok := request["prompt"].(string); ok {
-		// Completions request
-		inputTokens += int64(len(prompt) / 4)
-	} else if promptArray, ok := request["prompt"].([]interface{}); ok {
-		// Completions request with array prompt
-		for _, p := range promptArray {
-			if promptStr, ok := p.(string); ok {
-				inputTokens += int64(len(promptStr) / 4)
-			}
+// peekErrorKinds returns the error code and type of an error response, most
+// specific first, leaving the body intact for the caller. It understands
+// OpenAI/Azure, Anthropic and vLLM error bodies.
+func peekErrorKinds(resp *http.Response) []string {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+
+	peek, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorPeek))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(peek), resp.Body), resp.Body}
+
+	type errorFields struct {
+		Type string      `json:"type"`
+		Code interface{} `json:"code"`
+	}
+	var body struct {
+		errorFields
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(peek, &body) != nil {
+		return nil
+	}
+
+	var kinds []string
+	add := func(fields errorFields) {
+		if code, ok := fields.Code.(string); ok && code != "" {
+			kinds = append(kinds, code)
 		}
-	} else if input, ok := request["input"].(string); ok {
-		// Embeddings request
-		inputTokens += int64(len(input) / 4)
-	} else if inputArray, ok := request["input"].([]interface{}); ok {
-		// Embeddings request with array input
-		for _, i := range inputArray {
-			if inputStr, ok := i.(string); ok {
-				inputTokens += int64(len(inputStr) / 4)
-			}
+		if fields.Type != "" && fields.Type != "error" {
+			kinds = append(kinds, fields.Type)
 		}
 	}
 
-	// Extract tools if present
-	var tools []string
-	if toolsArray, ok := request["tools"].([]interface{}); ok {
-		for _, tool := range toolsArray {
-			if toolMap, ok := tool.(map[string]interface{}); ok {
-				if toolType, ok := toolMap["type"].(string); ok {
-					tools = append(tools, toolType)
-				}
-			}
+	var nested errorFields
+	if len(body.Error) > 0 && json.Unmarshal(body.Error, &nested) == nil {
+		add(nested)
+	}
+	add(body.errorFields)
+	return kinds
+}
+
+// ExtractUser returns the end-user identifier from a request body's `user` field
+func ExtractUser(body []byte) string {
+	var request struct {
+		User string `json:"user"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	return request.User
+}
+
+// ExtractReasoningEffort returns the reasoning effort a request body asks
+// for, from chat completions' `reasoning_effort` or the `reasoning.effort` of
+// the responses API
+func ExtractReasoningEffort(body []byte) string {
+	var request struct {
+		ReasoningEffort string `json:"reasoning_effort"`
+		Reasoning       struct {
+			Effort string `json:"effort"`
+		} `json:"reasoning"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return ""
+	}
+	if request.ReasoningEffort != "" {
+		return request.ReasoningEffort
+	}
+	return request.Reasoning.Effort
+}
+
+// InjectUser sets the `user` field of a JSON request body
+func InjectUser(body []byte, user string) ([]byte, error) {
+	return setField(body, "user", user)
+}
+
+// ReplaceModel sets the `model` field of a JSON request body
+func ReplaceModel(body []byte, model string) ([]byte, error) {
+	return setField(body, "model", model)
+}
+
+// paramAliases are fields that set the same parameter as another, so a
+// default for one mustn't be added next to the other
+var paramAliases = map[string][]string{
+	"max_tokens": {"max_completion_tokens"},
+}
+
+// SetDefaults sets the top-level fields of a JSON request body that are
+// missing or null to their value in defaults, returning the names of the
+// fields it set, sorted
+func SetDefaults(body []byte, defaults map[string]any) ([]byte, []string, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, nil, err
+	}
+
+	omitted := func(field string) bool {
+		value, ok := request[field]
+		return !ok || string(value) == "null"
+	}
+	var set []string
+	for field, value := range defaults {
+		if !omitted(field) || slices.ContainsFunc(paramAliases[field], func(alias string) bool { return !omitted(alias) }) {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, nil, err
 		}
+		request[field] = encoded
+		set = append(set, field)
 	}
+	if len(set) == 0 {
+		return body, nil, nil
+	}
+	slices.Sort(set)
 
-	// Reset reader position for further use
-	_, err = bodyReader.Seek(0, io.SeekStart)
-	if err != nil {
-		return model, inputTokens, tools, err
+	rewritten, err := json.Marshal(request)
+	return rewritten, set, err
+}
+
+// setField sets a top-level string field of a JSON request body, leaving the others untouched
+func setField(body []byte, field, value string) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
 	}
 
-	return model, inputTokens, tools, nil
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	request[field] = encoded
+	return json.Marshal(request)
 }
 
 // RewriteBody creates a new reader with the same content as the original
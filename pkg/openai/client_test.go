@@ -3,12 +3,18 @@ package openai
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestNewClient(t *testing.T) {
@@ -178,6 +184,13 @@ func TestExtractRequestMetadata(t *testing.T) {
 			expectedTokens: 4,
 			expectedTools:  []string{"function"},
 		},
+		{
+			name:           "Responses request with input items",
+			body:           `{"model":"gpt-4o","instructions":"Answer briefly.","input":[{"role":"user","content":"What's the weather in Paris?"},{"type":"message","role":"user","content":[{"type":"input_text","text":"And in London?"}]}],"tools":[{"type":"function","name":"get_weather"},{"type":"web_search"}]}`,
+			expectedModel:  "gpt-4o",
+			expectedTokens: 13,
+			expectedTools:  []string{"function", "web_search"},
+		},
 		{
 			name:           "Empty request",
 			body:           `{}`,
@@ -254,4 +267,325 @@ func TestRewriteBody(t *testing.T) {
 	if nilBody != nil {
 		t.Errorf("Expected nil for nil body rewrite, got %v", nilBody)
 	}
-}
\ No newline at end of file
+}
+
+func TestClientOptions(t *testing.T) {
+	transport := &http.Transport{}
+
+	client := NewClient("https://api.openai.com/v1", "test-key",
+		WithTimeout(5*time.Second),
+		WithTransport(transport),
+		WithDefaultHeader("OpenAI-Organization", "org-123"),
+		WithUserAgent("test-agent/1.0"),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond}),
+	)
+
+	if client.HTTPClient.Timeout != 5*time.Second {
+		t.Errorf("Expected timeout to be 5s, got %v", client.HTTPClient.Timeout)
+	}
+
+	if client.HTTPClient.Transport != transport {
+		t.Error("Expected custom transport to be set")
+	}
+
+	if client.DefaultHeaders.Get("OpenAI-Organization") != "org-123" {
+		t.Errorf("Expected default header to be set, got %v", client.DefaultHeaders)
+	}
+
+	if client.UserAgent != "test-agent/1.0" {
+		t.Errorf("Expected UserAgent to be 'test-agent/1.0', got %s", client.UserAgent)
+	}
+
+	if client.RetryPolicy.MaxRetries != 2 {
+		t.Errorf("Expected MaxRetries to be 2, got %d", client.RetryPolicy.MaxRetries)
+	}
+
+	// A timeout and transport apply to a client passed in, in either order,
+	// without changing the caller's
+	hc := &http.Client{Timeout: time.Minute}
+	for _, opts := range [][]Option{
+		{WithHTTPClient(hc), WithTimeout(5 * time.Second), WithTransport(transport)},
+		{WithTimeout(5 * time.Second), WithTransport(transport), WithHTTPClient(hc)},
+	} {
+		client := NewClient("https://api.openai.com/v1", "test-key", opts...)
+		if client.HTTPClient.Timeout != 5*time.Second || client.HTTPClient.Transport != transport {
+			t.Errorf("Expected the timeout and transport applied, got %v and %v", client.HTTPClient.Timeout, client.HTTPClient.Transport)
+		}
+		if hc.Timeout != time.Minute || hc.Transport != nil {
+			t.Errorf("Expected the caller's client unchanged, got %v and %v", hc.Timeout, hc.Transport)
+		}
+	}
+}
+
+func TestForwardRequestHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-agent/1.0" {
+			t.Errorf("Expected User-Agent to be 'test-agent/1.0', got %s", r.Header.Get("User-Agent"))
+		}
+
+		if r.Header.Get("OpenAI-Organization") != "org-123" {
+			t.Errorf("Expected OpenAI-Organization to be 'org-123', got %s", r.Header.Get("OpenAI-Organization"))
+		}
+
+		if r.Header.Get("If-None-Match") != `"etag-1"` {
+			t.Errorf("Expected If-None-Match to be '\"etag-1\"', got %s", r.Header.Get("If-None-Match"))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key",
+		WithDefaultHeader("OpenAI-Organization", "org-123"),
+		WithUserAgent("test-agent/1.0"),
+	)
+
+	// Per-request headers travel on the context
+	ctx := ContextWithHeaders(context.Background(), http.Header{"If-None-Match": {`"etag-1"`}})
+	resp, err := client.ForwardRequest(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestForwardRequestRetry(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		// Every attempt must see the full body
+		bodyBytes, _ := io.ReadAll(r.Body)
+		if string(bodyBytes) != `{"model":"gpt-4"}` {
+			t.Errorf("Expected body to be replayed, got %s", string(bodyBytes))
+		}
+
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key",
+		WithRetryPolicy(RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond}),
+	)
+
+	resp, err := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions",
+		bytes.NewBufferString(`{"model":"gpt-4"}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+
+	// Without a retry policy the first failure is returned as-is
+	attempts = 0
+	client = NewClient(server.URL, "test-key")
+	resp, err = client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions",
+		bytes.NewBufferString(`{"model":"gpt-4"}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || attempts != 1 {
+		t.Errorf("Expected a single failed attempt, got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+}
+
+func TestForwardRequestRetryBudget(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key",
+		WithRetryPolicy(RetryPolicy{MaxRetries: 5, Backoff: time.Millisecond}),
+	)
+
+	// The budget runs out before the policy's retries do, and is shared by later calls
+	budget := NewRetryBudget(2)
+	ctx := ContextWithRetryBudget(context.Background(), budget)
+	for i, want := range []int{3, 1} {
+		attempts = 0
+		resp, err := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", bytes.NewBufferString(`{}`))
+		if err != nil {
+			t.Fatalf("Failed to forward request: %v", err)
+		}
+		resp.Body.Close()
+		if attempts != want {
+			t.Errorf("Call %d: expected %d attempts, got %d", i, want, attempts)
+		}
+	}
+	if budget.Used() != 2 || budget.Spend() {
+		t.Errorf("Expected the budget spent, %d used", budget.Used())
+	}
+}
+
+func TestForwardRequestWithoutAmbiguousRetries(t *testing.T) {
+	statuses := []int{http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusOK}
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[attempts])
+		attempts++
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key",
+		WithRetryPolicy(RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond}),
+	)
+
+	// A 503 is retried, but a gateway timeout may have been applied and isn't
+	ctx := ContextWithoutAmbiguousRetries(context.Background())
+	resp, err := client.ForwardRequest(ctx, "POST", "/v1/files", bytes.NewBufferString(`{}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout || attempts != 2 {
+		t.Errorf("Expected a 504 after 2 attempts, got %d after %d", resp.StatusCode, attempts)
+	}
+
+	if AmbiguousError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}) {
+		t.Error("Expected a refused connection to be unambiguous")
+	}
+	if !AmbiguousError(io.ErrUnexpectedEOF) {
+		t.Error("Expected a broken connection to be ambiguous")
+	}
+}
+
+func TestForwardRequestRetryErrorTypes(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch r.URL.Path {
+		case "/v1/overloaded":
+			if attempts < 2 {
+				w.WriteHeader(529)
+				w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/v1/too-long":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"message":"Too long","type":"invalid_request_error","code":"context_length_exceeded"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-key",
+		WithRetryPolicy(RetryPolicy{
+			MaxRetries: 3,
+			Backoff:    time.Millisecond,
+			ErrorTypes: map[string]bool{"overloaded_error": true, "context_length_exceeded": false},
+		}),
+	)
+
+	// 529 isn't a retryable status, but the error type is
+	resp, err := client.ForwardRequest(context.Background(), "POST", "/v1/overloaded", nil)
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || attempts != 2 {
+		t.Errorf("Expected success on the second attempt, got status %d after %d attempts", resp.StatusCode, attempts)
+	}
+
+	// 503 is a retryable status, but the error code isn't
+	attempts = 0
+	resp, err = client.ForwardRequest(context.Background(), "POST", "/v1/too-long", nil)
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if attempts != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts)
+	}
+	if !strings.Contains(string(body), "context_length_exceeded") {
+		t.Errorf("Expected the error body to be left intact, got %s", body)
+	}
+}
+
+func TestNetworkErrorClass(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{&net.DNSError{Err: "no such host", Name: "upstream"}, "dns"},
+		{fmt.Errorf("error making request: %w", context.DeadlineExceeded), "timeout"},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "connection_refused"},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, "connection_reset"},
+		{io.ErrUnexpectedEOF, "connection_reset"},
+		{errors.New("something else"), "other"},
+	}
+
+	for _, tt := range tests {
+		if class := NetworkErrorClass(tt.err); class != tt.expected {
+			t.Errorf("NetworkErrorClass(%v) = %s, expected %s", tt.err, class, tt.expected)
+		}
+	}
+
+	// Only the listed classes are retried
+	client := NewClient("http://127.0.0.1:1", "test-key",
+		WithRetryPolicy(RetryPolicy{MaxRetries: 1, NetworkErrors: []string{"timeout"}}),
+	)
+	if client.shouldRetry(context.Background(), nil, &net.DNSError{Err: "no such host"}) {
+		t.Error("Expected DNS errors not to be retried")
+	}
+	if !client.shouldRetry(context.Background(), nil, context.DeadlineExceeded) {
+		t.Error("Expected timeouts to be retried")
+	}
+}
+
+func TestExtractAndInjectUser(t *testing.T) {
+	if user := ExtractUser([]byte(`{"model":"gpt-4","user":"user-123"}`)); user != "user-123" {
+		t.Errorf("Expected user user-123, got %q", user)
+	}
+
+	if user := ExtractUser([]byte(`not json`)); user != "" {
+		t.Errorf("Expected no user from invalid JSON, got %q", user)
+	}
+
+	body, err := InjectUser([]byte(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`), "key-abc")
+	if err != nil {
+		t.Fatalf("Failed to inject user: %v", err)
+	}
+
+	if user := ExtractUser(body); user != "key-abc" {
+		t.Errorf("Expected injected user key-abc, got %q", user)
+	}
+
+	model, _, _, err := ExtractRequestMetadata(bytes.NewReader(body))
+	if err != nil || model != "gpt-4" {
+		t.Errorf("Expected other fields to be preserved, got model %q (err: %v)", model, err)
+	}
+
+	if _, err := InjectUser([]byte(`[1,2]`), "key-abc"); err == nil {
+		t.Error("Expected error injecting into a non-object body")
+	}
+}
+
+func TestExtractReasoningEffort(t *testing.T) {
+	for body, want := range map[string]string{
+		`{"model":"o3","reasoning_effort":"high"}`:    "high",
+		`{"model":"o3","reasoning":{"effort":"low"}}`: "low",
+		`{"model":"gpt-4"}`:                           "",
+		`not json`:                                    "",
+	} {
+		if got := ExtractReasoningEffort([]byte(body)); got != want {
+			t.Errorf("%s: expected effort %q, got %q", body, want, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyRing holds the upstream API key. After a rotation the key it replaced
+// stays usable for a grace window, so requests the new key is refused for
+// (e.g. before it has propagated upstream) fall back to the old one.
+type KeyRing struct {
+	mu         sync.RWMutex
+	current    string
+	previous   string
+	rotatedAt  time.Time
+	graceUntil time.Time
+	grace      time.Duration // Grace window used when a rotation doesn't set its own
+	now        func() time.Time
+}
+
+// KeyRingStatus describes the keys in use, identified by their last few
+// characters as provider dashboards show them
+type KeyRingStatus struct {
+	Current    string     `json:"current"`
+	Previous   string     `json:"previous,omitempty"` // Only while the grace window is open
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+}
+
+// NewKeyRing creates a key ring holding key, with grace as the default
+// window the old key stays usable for after a rotation
+func NewKeyRing(key string, grace time.Duration) *KeyRing {
+	return &KeyRing{current: key, grace: grace, now: time.Now}
+}
+
+// Current returns the key new requests are sent with
+func (k *KeyRing) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// Previous returns the key that was replaced, empty once its grace window has closed
+func (k *KeyRing) Previous() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.previous == "" || !k.now().Before(k.graceUntil) {
+		return ""
+	}
+	return k.previous
+}
+
+// Rotate makes key the current key, keeping the old one usable for grace
+// (the key ring's default when 0). It reports whether the key changed.
+func (k *KeyRing) Rotate(key string, grace time.Duration) bool {
+	if grace == 0 {
+		grace = k.grace
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key == k.current {
+		return false
+	}
+	k.previous = k.current
+	k.current = key
+	k.rotatedAt = k.now()
+	k.graceUntil = k.rotatedAt.Add(grace)
+	return true
+}
+
+// Status reports the keys in use without revealing them
+func (k *KeyRing) Status() KeyRingStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	status := KeyRingStatus{Current: maskKey(k.current)}
+	if !k.rotatedAt.IsZero() {
+		rotatedAt, graceUntil := k.rotatedAt, k.graceUntil
+		status.RotatedAt = &rotatedAt
+		if k.now().Before(graceUntil) {
+			status.Previous = maskKey(k.previous)
+			status.GraceUntil = &graceUntil
+		}
+	}
+	return status
+}
+
+// WatchFile rotates to the key in path whenever the file changes, checking
+// every interval until ctx is cancelled
+func (k *KeyRing) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(lastMod) {
+			continue
+		}
+		lastMod = info.ModTime()
+
+		key, err := ReadKeyFile(path)
+		if err != nil {
+			log.Printf("Error reading upstream key file: %v", err)
+			continue
+		}
+		if k.Rotate(key, 0) {
+			log.Printf("Rotated upstream API key from %s to %s", path, maskKey(key))
+		}
+	}
+}
+
+// ReadKeyFile reads an API key from a file, ignoring surrounding whitespace
+func ReadKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// maskKey shortens a key to its last four characters
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return "..." + key[len(key)-4:]
+}
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyRingRotate(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	keys := NewKeyRing("sk-old-0001", 5*time.Minute)
+	keys.now = func() time.Time { return now }
+
+	if keys.Rotate("sk-old-0001", 0) {
+		t.Error("Expected rotating to the same key to be a no-op")
+	}
+	if !keys.Rotate("sk-new-0002", 0) {
+		t.Fatal("Expected rotation to a new key")
+	}
+
+	if keys.Current() != "sk-new-0002" || keys.Previous() != "sk-old-0001" {
+		t.Errorf("Expected new current and old previous key, got %q and %q", keys.Current(), keys.Previous())
+	}
+
+	status := keys.Status()
+	if status.Current != "...0002" || status.Previous != "...0001" || status.GraceUntil == nil {
+		t.Errorf("Unexpected status during grace window: %+v", status)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if keys.Previous() != "" {
+		t.Errorf("Expected previous key to expire after the grace window, got %q", keys.Previous())
+	}
+	if status := keys.Status(); status.Previous != "" || status.RotatedAt == nil {
+		t.Errorf("Unexpected status after grace window: %+v", status)
+	}
+}
+
+func TestForwardRequestKeyFallback(t *testing.T) {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = append(seen, r.Header.Get("Authorization")+" "+string(body))
+		// The new key hasn't propagated upstream yet
+		if r.Header.Get("Authorization") != "Bearer sk-old-0001" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	keys := NewKeyRing("sk-old-0001", time.Minute)
+	client := NewClient(server.URL, "", WithKeyRing(keys))
+	keys.Rotate("sk-new-0002", 0)
+
+	resp, err := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected fallback to the old key to succeed, got status %d", resp.StatusCode)
+	}
+	want := []string{`Bearer sk-new-0002 {"model":"gpt-4"}`, `Bearer sk-old-0001 {"model":"gpt-4"}`}
+	if strings.Join(seen, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected attempts %q, got %q", want, seen)
+	}
+
+	// Once the grace window closes the new key's refusal is final
+	keys.Rotate("sk-newer-0003", time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	seen = nil
+	resp, err = client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("Failed to forward request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || len(seen) != 1 {
+		t.Errorf("Expected a single refused attempt, got status %d after %d attempts", resp.StatusCode, len(seen))
+	}
+}
+
+func TestKeyRingWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("sk-old-0001\n"), 0o600)
+
+	keys := NewKeyRing("sk-old-0001", time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keys.WatchFile(ctx, path, 10*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	os.WriteFile(path, []byte("sk-new-0002\n"), 0o600)
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+
+	deadline := time.Now().Add(time.Second)
+	for keys.Current() != "sk-new-0002" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if keys.Current() != "sk-new-0002" || keys.Previous() != "sk-old-0001" {
+		t.Errorf("Expected rotation from the key file, got current %q previous %q", keys.Current(), keys.Previous())
+	}
+}
@@ -0,0 +1,172 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Bounds on the request bodies metadata is extracted from, so a hostile
+// payload can't make the proxy spend unbounded memory or time parsing it
+const (
+	MaxMetadataBytes = 64 << 20 // Largest body parsed
+	MaxMetadataDepth = 100      // Deepest nesting of objects and arrays accepted
+)
+
+var (
+	// ErrBodyTooLarge is returned for bodies over MaxMetadataBytes
+	ErrBodyTooLarge = errors.New("request body too large to extract metadata from")
+	// ErrBodyTooDeep is returned for bodies nested deeper than MaxMetadataDepth
+	ErrBodyTooDeep = errors.New("request body nested too deeply to extract metadata from")
+)
+
+// metadataRequest holds the fields metadata is extracted from, covering chat
+// completions (messages, tools), completions (prompt), embeddings (input)
+// and responses (input items, instructions, tools). Everything else in a
+// body is skipped without being kept.
+type metadataRequest struct {
+	Model        string         `json:"model"`
+	Messages     []chatMessage  `json:"messages"`
+	Prompt       *textEstimate  `json:"prompt"`
+	Input        *textEstimate  `json:"input"`
+	Instructions textEstimate   `json:"instructions"`
+	Tools        []toolMetadata `json:"tools"`
+}
+
+// chatMessage is the part of a chat message metadata is extracted from
+type chatMessage struct {
+	Content textEstimate `json:"content"`
+}
+
+// toolMetadata is the part of a tool definition metadata is extracted from
+type toolMetadata struct {
+	Type string `json:"type"`
+}
+
+// textEstimate is the estimated tokens of a string, or an array of strings,
+// content parts and input items, roughly one token per 4 characters. Parts
+// count their text and items their content; values of any other shape count
+// as none.
+type textEstimate int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *textEstimate) UnmarshalJSON(data []byte) error {
+	*t = 0
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*t = textEstimate(len(text) / 4)
+		return nil
+	}
+
+	var items []json.RawMessage
+	if json.Unmarshal(data, &items) != nil {
+		return nil
+	}
+	for _, item := range items {
+		if json.Unmarshal(item, &text) == nil {
+			*t += textEstimate(len(text) / 4)
+			continue
+		}
+
+		var part struct {
+			Text    string       `json:"text"`
+			Content textEstimate `json:"content"`
+		}
+		if json.Unmarshal(item, &part) == nil {
+			*t += textEstimate(len(part.Text)/4) + part.Content
+		}
+	}
+	return nil
+}
+
+// ExtractRequestMetadata extracts model name, token count and other metadata for metrics.
+// The body is decoded as it is read, failing with ErrBodyTooLarge or
+// ErrBodyTooDeep past MaxMetadataBytes or MaxMetadataDepth. Fields of the
+// wrong type are ignored rather than failing the whole body.
+func ExtractRequestMetadata(body io.Reader) (string, int64, []string, error) {
+	if body == nil {
+		return "", 0, nil, nil
+	}
+
+	var request metadataRequest
+	dec := json.NewDecoder(&boundedReader{r: body, remaining: MaxMetadataBytes + 1, maxDepth: MaxMetadataDepth})
+	if err := dec.Decode(&request); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) || typeErr.Field == "" {
+			return "", 0, nil, err
+		}
+	}
+
+	// Estimate token count based on input, by request type
+	var inputTokens int64
+	switch {
+	case request.Messages != nil:
+		for _, msg := range request.Messages {
+			inputTokens += int64(msg.Content)
+		}
+	case request.Prompt != nil:
+		inputTokens = int64(*request.Prompt)
+	case request.Input != nil:
+		inputTokens = int64(*request.Input) + int64(request.Instructions)
+	}
+
+	var tools []string
+	for _, tool := range request.Tools {
+		if tool.Type != "" {
+			tools = append(tools, tool.Type)
+		}
+	}
+
+	return request.Model, inputTokens, tools, nil
+}
+
+// boundedReader passes a JSON document through, failing once more than
+// remaining-1 bytes have been read or objects and arrays nest deeper than
+// maxDepth. It tracks nesting as bytes go by, without recursing.
+type boundedReader struct {
+	r         io.Reader
+	remaining int64
+	maxDepth  int
+	depth     int
+	inString  bool
+	escaped   bool
+}
+
+// Read implements io.Reader
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining <= 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	for _, c := range p[:n] {
+		switch {
+		case b.escaped:
+			b.escaped = false
+		case b.inString:
+			switch c {
+			case '\\':
+				b.escaped = true
+			case '"':
+				b.inString = false
+			}
+		case c == '"':
+			b.inString = true
+		case c == '{' || c == '[':
+			if b.depth++; b.depth > b.maxDepth {
+				return 0, ErrBodyTooDeep
+			}
+		case c == '}' || c == ']':
+			b.depth--
+		}
+	}
+	return n, err
+}
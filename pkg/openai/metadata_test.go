@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExtractRequestMetadataBounds(t *testing.T) {
+	deep := `{"model":"gpt-4","metadata":` + strings.Repeat("[", MaxMetadataDepth) + strings.Repeat("]", MaxMetadataDepth) + `}`
+	if _, _, _, err := ExtractRequestMetadata(strings.NewReader(deep)); !errors.Is(err, ErrBodyTooDeep) {
+		t.Errorf("Expected ErrBodyTooDeep, got %v", err)
+	}
+
+	// Brackets inside strings don't count towards nesting
+	brackets := `{"model":"gpt-4","messages":[{"role":"user","content":"` + strings.Repeat("[{", 1000) + `\"]"}]}`
+	if model, _, _, err := ExtractRequestMetadata(strings.NewReader(brackets)); err != nil || model != "gpt-4" {
+		t.Errorf("Expected brackets in strings to be ignored, got %q (%v)", model, err)
+	}
+
+	large := `{"model":"gpt-4","prompt":"` + strings.Repeat("a", MaxMetadataBytes) + `"}`
+	if _, _, _, err := ExtractRequestMetadata(strings.NewReader(large)); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestExtractRequestMetadataWrongTypes(t *testing.T) {
+	// Fields of unexpected types are skipped, keeping the rest
+	body := `{"model":"gpt-4","messages":[1,{"content":"12345678"},{"content":[{"type":"text"}]}],"tools":"none","n":"two"}`
+	model, tokens, tools, err := ExtractRequestMetadata(strings.NewReader(body))
+	if err != nil || model != "gpt-4" || tokens != 2 || tools != nil {
+		t.Errorf("Expected gpt-4 with 2 tokens, got %q, %d, %v (%v)", model, tokens, tools, err)
+	}
+
+	if _, _, _, err := ExtractRequestMetadata(strings.NewReader(`[1,2]`)); err == nil {
+		t.Error("Expected an error for a body that isn't an object")
+	}
+}
+
+func FuzzExtractRequestMetadata(f *testing.F) {
+	for _, seed := range []string{
+		`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}],"tools":[{"type":"function"}]}`,
+		`{"model":"davinci","prompt":["Write a poem","about AI"]}`,
+		`{"model":"text-embedding-3-small","input":"Hello"}`,
+		`{"model":1,"messages":{"content":"x"},"prompt":[[["deep"]]]}`,
+		`{"a":"\"[{\\"}`,
+		`[[[[[[[[`,
+		``,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, tokens, _, err := ExtractRequestMetadata(strings.NewReader(string(data)))
+		if tokens < 0 {
+			t.Errorf("Negative token estimate %d", tokens)
+		}
+		// Anything accepted must be a JSON object
+		if err == nil && len(data) > 0 {
+			var object map[string]json.RawMessage
+			if json.Unmarshal(data, &object) != nil {
+				var prefix map[string]json.RawMessage
+				if json.NewDecoder(strings.NewReader(string(data))).Decode(&prefix) != nil {
+					t.Errorf("Accepted a body that isn't a JSON object: %q", data)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package openai
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resolveTimeout bounds each re-resolution of an upstream host
+const resolveTimeout = 5 * time.Second
+
+// RecyclingTransport sends requests over connections it replaces from time
+// to time, so the upstream's current DNS records are used without a
+// restart, e.g. once a cloud load balancer has scaled out or moved. Kept
+// alive connections otherwise go to the addresses they were dialed to
+// for as long as the upstream keeps them open.
+//
+// Connections are replaced by switching to a new pool: requests in flight
+// finish on the old one, whose connections are closed as they go idle.
+// This works the same for HTTP/1.1 and HTTP/2, where one busy connection
+// would otherwise carry every request.
+type RecyclingTransport struct {
+	// MaxAge replaces the pool once it is this old (0 keeps it)
+	MaxAge time.Duration
+	// ResolveInterval is how often upstream hosts are re-resolved, the pool
+	// being replaced when their addresses change (0 never re-resolves)
+	ResolveInterval time.Duration
+
+	base    *http.Transport
+	pool    atomic.Pointer[pool]
+	lookup  func(ctx context.Context, host string) ([]string, error)
+	now     func() time.Time
+	mu      sync.Mutex
+	hosts   map[string]*hostState
+	retired []*http.Transport // Pools with requests that may still be in flight
+}
+
+// pool is a set of connections and when it was started
+type pool struct {
+	transport *http.Transport
+	started   time.Time
+}
+
+// hostState is what is known of an upstream host's addresses
+type hostState struct {
+	addrs     []string
+	checked   time.Time
+	resolving bool
+}
+
+// NewRecyclingTransport creates a transport replacing its connections once
+// they are maxAge old, and when a re-resolution every resolveInterval finds
+// new addresses; either may be 0 to disable it
+func NewRecyclingTransport(maxAge, resolveInterval time.Duration) *RecyclingTransport {
+	t := &RecyclingTransport{
+		MaxAge:          maxAge,
+		ResolveInterval: resolveInterval,
+		base:            http.DefaultTransport.(*http.Transport),
+		lookup:          net.DefaultResolver.LookupHost,
+		now:             time.Now,
+		hosts:           make(map[string]*hostState),
+	}
+	t.pool.Store(&pool{transport: t.base.Clone(), started: t.now()})
+	return t
+}
+
+// WithConnectionRecycling replaces upstream connections once they are maxAge
+// old, and when the upstream's DNS records change (see RecyclingTransport)
+func WithConnectionRecycling(maxAge, resolveInterval time.Duration) Option {
+	return func(c *Client) {
+		c.HTTPClient.Transport = NewRecyclingTransport(maxAge, resolveInterval)
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RecyclingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.pool.Load()
+	if t.MaxAge > 0 && t.now().Sub(p.started) >= t.MaxAge {
+		t.recycle(p, "connections reached their maximum age")
+		p = t.pool.Load()
+	}
+	t.resolve(req.URL.Hostname())
+	return p.transport.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of every pool, so a
+// Client's CloseIdleConnections reaches them
+func (t *RecyclingTransport) CloseIdleConnections() {
+	t.pool.Load().transport.CloseIdleConnections()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, old := range t.retired {
+		old.CloseIdleConnections()
+	}
+}
+
+// recycle replaces pool p with a new one, unless another request already has
+func (t *RecyclingTransport) recycle(p *pool, reason string) {
+	next := &pool{transport: t.base.Clone(), started: t.now()}
+	if !t.pool.CompareAndSwap(p, next) {
+		return
+	}
+	log.Printf("Recycling upstream connections: %s", reason)
+
+	// Pools retired earlier have had time to finish their requests, and
+	// their connections close as they go idle anyway
+	t.mu.Lock()
+	for _, old := range t.retired {
+		old.CloseIdleConnections()
+	}
+	t.retired = []*http.Transport{p.transport}
+	t.mu.Unlock()
+	p.transport.CloseIdleConnections()
+}
+
+// resolve looks host up again in the background when ResolveInterval has
+// passed since it last was, recycling the pool if its addresses changed
+func (t *RecyclingTransport) resolve(host string) {
+	if t.ResolveInterval <= 0 || net.ParseIP(host) != nil {
+		return
+	}
+	t.mu.Lock()
+	state := t.hosts[host]
+	if state == nil {
+		state = &hostState{}
+		t.hosts[host] = state
+	}
+	if state.resolving || t.now().Sub(state.checked) < t.ResolveInterval {
+		t.mu.Unlock()
+		return
+	}
+	state.resolving = true
+	t.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		addrs, err := t.lookup(ctx, host)
+		cancel()
+		slices.Sort(addrs)
+
+		t.mu.Lock()
+		state.resolving = false
+		state.checked = t.now()
+		if err != nil {
+			t.mu.Unlock()
+			log.Printf("Error re-resolving upstream host %s: %v", host, err)
+			return
+		}
+		changed := state.addrs != nil && !slices.Equal(state.addrs, addrs)
+		state.addrs = addrs
+		t.mu.Unlock()
+
+		if changed {
+			t.recycle(t.pool.Load(), "addresses of "+host+" changed")
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer starts a server counting the connections dialed to it
+func countingServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var conns atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, &conns
+}
+
+func TestRecyclingTransportMaxAge(t *testing.T) {
+	server, conns := countingServer(t)
+	transport := NewRecyclingTransport(time.Minute, 0)
+	now := time.Now()
+	transport.now = func() time.Time { return now }
+	transport.pool.Store(&pool{transport: transport.base.Clone(), started: now})
+	client := NewClient(server.URL, "sk-test", WithTransport(transport))
+
+	send := func() {
+		resp, err := client.ForwardRequest(context.Background(), "GET", "/v1/models", nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// Connections are kept alive until they are MaxAge old
+	send()
+	send()
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("Expected one kept alive connection, got %d", got)
+	}
+
+	now = now.Add(time.Minute)
+	send()
+	send()
+	if got := conns.Load(); got != 2 {
+		t.Errorf("Expected one new connection once the first was a minute old, got %d in all", got)
+	}
+}
+
+func TestRecyclingTransportResolve(t *testing.T) {
+	server, conns := countingServer(t)
+	transport := NewRecyclingTransport(0, time.Minute)
+	// Lookups run in the background, so the clock is shared with them
+	var clock atomic.Int64
+	clock.Store(time.Now().UnixNano())
+	transport.now = func() time.Time { return time.Unix(0, clock.Load()) }
+	now := transport.now()
+
+	// upstream.test always dials the test server, whatever it resolves to
+	transport.base = transport.base.Clone()
+	transport.base.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	transport.pool.Store(&pool{transport: transport.base.Clone(), started: now})
+
+	var mu sync.Mutex
+	addrs := []string{"10.0.0.1"}
+	lookups := make(chan struct{}, 10)
+	transport.lookup = func(ctx context.Context, host string) ([]string, error) {
+		defer func() { lookups <- struct{}{} }()
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), addrs...), nil
+	}
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	client := NewClient("http://upstream.test:"+port, "sk-test", WithTransport(transport))
+	send := func() {
+		resp, err := client.ForwardRequest(context.Background(), "GET", "/v1/models", nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	waitLookup := func() {
+		select {
+		case <-lookups:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the host re-resolved")
+		}
+		// Give the lookup's goroutine time to recycle, should it
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	send()
+	waitLookup()
+
+	// The same addresses a minute later keep the connection
+	clock.Add(int64(time.Minute))
+	send()
+	waitLookup()
+	send()
+	if got := conns.Load(); got != 1 {
+		t.Fatalf("Expected the connection kept while the addresses stay the same, got %d", got)
+	}
+
+	// New addresses replace it
+	mu.Lock()
+	addrs = []string{"10.0.0.2", "10.0.0.1"}
+	mu.Unlock()
+	clock.Add(int64(time.Minute))
+	old := transport.pool.Load()
+	send()
+	waitLookup()
+	for deadline := time.Now().Add(time.Second); transport.pool.Load() == old && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	send()
+	if got := conns.Load(); got != 2 {
+		t.Errorf("Expected a new connection once the addresses changed, got %d in all", got)
+	}
+}
@@ -0,0 +1,70 @@
+package openai
+
+import (
+	"context"
+	"sync"
+)
+
+// retryBudgetKey is the context key for a request's retry budget
+type retryBudgetKey struct{}
+
+// RetryBudget caps the attempts one request makes beyond its first, over
+// every mechanism that retries it: the client's own retries of failed
+// upstream calls, and the proxy's requeues of preempted or stalled
+// requests. Without it each mechanism retries up to its own limit, and a
+// request can multiply into the product of them.
+type RetryBudget struct {
+	limit int // Retries allowed, 0 for unlimited
+	mu    sync.Mutex
+	used  int
+}
+
+// NewRetryBudget creates a budget of limit retries, unlimited when 0,
+// counting the retries spent either way
+func NewRetryBudget(limit int) *RetryBudget {
+	return &RetryBudget{limit: limit}
+}
+
+// ContextWithRetryBudget returns a context whose upstream calls spend their retries from budget
+func ContextWithRetryBudget(ctx context.Context, budget *RetryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetKey{}, budget)
+}
+
+// retryBudgetFromContext returns the budget upstream calls in ctx spend, nil if they have none
+func retryBudgetFromContext(ctx context.Context) *RetryBudget {
+	budget, _ := ctx.Value(retryBudgetKey{}).(*RetryBudget)
+	return budget
+}
+
+// Spend takes one retry from the budget, reporting false when none is
+// left. A nil budget always allows the retry.
+func (b *RetryBudget) Spend() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used >= b.limit {
+		return false
+	}
+	b.used++
+	return true
+}
+
+// Limit returns the retries allowed, 0 for unlimited
+func (b *RetryBudget) Limit() int {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Used returns the retries spent so far
+func (b *RetryBudget) Used() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,49 @@
+package pricing
+
+import (
+	"strings"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// Table looks up per-model prices
+type Table struct {
+	prices map[string]config.ModelPrice
+}
+
+// NewTable creates a pricing table. Keys are model names; a key ending in
+// "*" matches every model with that prefix, e.g. "gpt-4o-*".
+func NewTable(prices map[string]config.ModelPrice) *Table {
+	return &Table{prices: prices}
+}
+
+// Lookup returns the price for a model, preferring an exact match over the
+// longest matching prefix
+func (t *Table) Lookup(model string) (config.ModelPrice, bool) {
+	if t == nil {
+		return config.ModelPrice{}, false
+	}
+	if price, ok := t.prices[model]; ok {
+		return price, true
+	}
+
+	var best string
+	var price config.ModelPrice
+	found := false
+	for key, p := range t.prices {
+		prefix, ok := strings.CutSuffix(key, "*")
+		if ok && strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, price, found = prefix, p, true
+		}
+	}
+	return price, found
+}
+
+// Cost returns the USD cost of a request's tokens, and whether the model is priced
+func (t *Table) Cost(model string, inputTokens, outputTokens int64) (float64, bool) {
+	price, ok := t.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+	return (float64(inputTokens)*price.Input + float64(outputTokens)*price.Output) / 1e6, true
+}
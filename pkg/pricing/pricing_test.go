@@ -0,0 +1,52 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func TestLookup(t *testing.T) {
+	table := NewTable(map[string]config.ModelPrice{
+		"gpt-4o":       {Input: 2.5, Output: 10},
+		"gpt-4o-*":     {Input: 2.5, Output: 10},
+		"gpt-4o-mini*": {Input: 0.15, Output: 0.6},
+	})
+
+	tests := []struct {
+		model string
+		input float64
+		found bool
+	}{
+		{"gpt-4o", 2.5, true},
+		{"gpt-4o-2024-08-06", 2.5, true},
+		{"gpt-4o-mini-2024-07-18", 0.15, true},
+		{"gpt-3.5-turbo", 0, false},
+	}
+
+	for _, tt := range tests {
+		price, found := table.Lookup(tt.model)
+		if found != tt.found || price.Input != tt.input {
+			t.Errorf("Lookup(%q): expected %v/%v, got %v/%v", tt.model, tt.input, tt.found, price.Input, found)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	table := NewTable(map[string]config.ModelPrice{"gpt-4o": {Input: 2.5, Output: 10}})
+
+	cost, ok := table.Cost("gpt-4o", 1000, 500)
+	if !ok || math.Abs(cost-0.0075) > 1e-12 {
+		t.Errorf("Expected cost 0.0075, got %v (priced: %v)", cost, ok)
+	}
+
+	if _, ok := table.Cost("unknown", 1000, 500); ok {
+		t.Error("Expected unknown model to be unpriced")
+	}
+
+	var empty *Table
+	if _, ok := empty.Cost("gpt-4o", 1, 1); ok {
+		t.Error("Expected nil table to price nothing")
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/accesslog"
+)
+
+// RequestIDHeader carries the ID a request is logged under; clients may
+// send their own, otherwise one is generated and returned
+const RequestIDHeader = "X-Request-Id"
+
+// accessKey is the context key for a request's access log entry
+type accessKey struct{}
+
+// accessEntryFromContext returns the access log entry for a request, nil if it isn't logged
+func accessEntryFromContext(ctx context.Context) *accesslog.Entry {
+	entry, _ := ctx.Value(accessKey{}).(*accesslog.Entry)
+	return entry
+}
+
+// newRequestID returns a random request identifier
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLogHandler writes an access log line for every request it serves
+type accessLogHandler struct {
+	next   http.Handler
+	logger *accesslog.Logger
+}
+
+// NewAccessLogHandler wraps next so every request is written to logger.
+// The model, priority and queue wait are filled in by the proxy as the
+// request passes through it.
+func NewAccessLogHandler(next http.Handler, logger *accesslog.Logger) http.Handler {
+	return &accessLogHandler{next: next, logger: logger}
+}
+
+// ServeHTTP implements http.Handler
+func (h *accessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+
+	entry := &accesslog.Entry{
+		Time:       time.Now(),
+		RequestID:  id,
+		RemoteAddr: r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		KeyID:      clientKeyID(r),
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	h.next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+
+	entry.Status = recorder.status
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+	entry.Bytes = recorder.bytes
+	entry.DurationMs = time.Since(entry.Time).Milliseconds()
+	h.logger.Log(entry)
+}
+
+// statusRecorder notes the status and size of a response on its way to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher so streamed responses still reach the client as they arrive
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
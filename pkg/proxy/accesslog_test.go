@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/accesslog"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestAccessLog(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8081, Priority: 2}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	var buf bytes.Buffer
+	logger, _ := accesslog.NewLogger(&buf, accesslog.FormatJSON)
+	handler := NewAccessLogHandler(NewRequestHandler(qm), logger)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions",
+		bytes.NewBufferString(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`))
+	req.Host = "localhost:8081"
+	req.Header.Set("Authorization", "Bearer test-key")
+	req.Header.Set(RequestIDHeader, "req-42")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Header().Get(RequestIDHeader) != "req-42" {
+		t.Errorf("Expected the client's request ID to be returned, got %q", recorder.Header().Get(RequestIDHeader))
+	}
+
+	var entry accesslog.Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid access log line %q: %v", buf.String(), err)
+	}
+	if entry.RequestID != "req-42" || entry.KeyID != KeyID("test-key") || entry.Model != "gpt-4o" || entry.Priority != 2 {
+		t.Errorf("Unexpected access log entry: %+v", entry)
+	}
+	if entry.Status != http.StatusOK || entry.Bytes != int64(len(`{"id":"test-response"}`)) {
+		t.Errorf("Expected status 200 and the response size, got %d and %d", entry.Status, entry.Bytes)
+	}
+
+	// Requests refused before reaching a queue are logged too, with an ID of their own
+	buf.Reset()
+	req = httptest.NewRequest("PUT", "/v1/chat/completions", nil)
+	req.Host = "localhost:8081"
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid access log line %q: %v", buf.String(), err)
+	}
+	if entry.Status != http.StatusMethodNotAllowed || entry.RequestID == "" || entry.RequestID != recorder.Header().Get(RequestIDHeader) {
+		t.Errorf("Unexpected access log entry for refused request: %+v", entry)
+	}
+}
+
+func TestAccessLogUpstreamRequestID(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+		ResponseHeaders: map[string]string{
+			"X-Request-Id":         "req_upstream",
+			"Openai-Processing-Ms": "734",
+		},
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	var buf bytes.Buffer
+	logger, _ := accesslog.NewLogger(&buf, accesslog.FormatJSON)
+	handler := NewAccessLogHandler(NewRequestHandler(qm), logger)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4o"}`))
+	req.Host = "localhost:8080"
+	req.Header.Set(RequestIDHeader, "req-42")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	var entry accesslog.Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Invalid access log line %q: %v", buf.String(), err)
+	}
+	if entry.UpstreamRequestID != "req_upstream" || entry.UpstreamProcessingMs != 734 {
+		t.Errorf("Expected the upstream request ID and processing time to be logged, got %+v", entry)
+	}
+
+	// The client gets both IDs rather than two values in one header
+	if ids := recorder.Header().Values(RequestIDHeader); len(ids) != 1 || ids[0] != "req-42" {
+		t.Errorf("Expected X-Request-Id to be the proxy's ID, got %v", ids)
+	}
+	if id := recorder.Header().Get(UpstreamRequestIDHeader); id != "req_upstream" {
+		t.Errorf("Expected %s to be the upstream's ID, got %q", UpstreamRequestIDHeader, id)
+	}
+}
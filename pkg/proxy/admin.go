@@ -0,0 +1,422 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+	"github.com/mule-ai/proxy/pkg/usage"
+)
+
+// Admin API token scopes
+const (
+	ScopeRead  = "read"  // Inspection only: GET and HEAD requests
+	ScopeAdmin = "admin" // Everything, including requests that change state
+)
+
+// AdminHandler serves the operational admin API on a separate listener
+type AdminHandler struct {
+	QueueManager *QueueManager
+	Cache        *ResponseCache
+	Maintenance  *Maintenance       // Toggled through /admin/maintenance when set
+	Speculator   *Speculator        // Reports dual-dispatch races through /admin/speculative when set
+	Keys         *openai.KeyRing    // Upstream API key, rotated through /admin/upstream-key when set
+	Regions      *RegionalClient    // Reports regional routing through /admin/regions when set
+	Discovery    *ModelDiscovery    // Reports discovered models through /admin/models when set
+	Schedule     *Schedule          // Active schedule windows, reported by /admin/status when set
+	Reloader     *Reloader          // Reloads the config through /admin/reload when set
+	Elector      *Elector           // Reports this replica's part in leader election when set
+	Debug        *DebugCaptures     // Started and stopped through /admin/debug/captures when set
+	Snapshots    *QueueSnapshots    // Moves queued requests through /admin/queue/export and /admin/queue/import when set
+	Slow         *SlowRequests      // Lists the slowest recent requests through /admin/slow when set
+	Journal      *Journal           // Lists and clears journaled writes through /admin/journal when set
+	Catalogs     *ModelCatalogs     // Manages per-key model catalogs through /admin/catalogs when set
+	BackendUsage *BackendUsage      // Usage per upstream, reported by /admin/status when set
+	Limiter      *ratelimit.Limiter // Rate limits in effect, reported by /admin/status when set
+	Backends     []BackendInfo      // Upstreams listed by /admin/status
+	Limits       StatusLimits       // Limits reported by /admin/status
+	mux          *http.ServeMux
+	tokens       map[[sha256.Size]byte]string // Scope of each accepted token, keyed by hash; nil leaves the API open
+	started      time.Time
+	build        BuildInfo
+}
+
+// NewAdminHandler creates an admin handler and registers its routes
+func NewAdminHandler(qm *QueueManager, cache *ResponseCache) *AdminHandler {
+	h := &AdminHandler{
+		QueueManager: qm,
+		Cache:        cache,
+		mux:          http.NewServeMux(),
+		started:      time.Now(),
+		build:        ReadBuildInfo(),
+	}
+
+	h.mux.HandleFunc("GET /admin/status", h.status)
+	h.mux.HandleFunc("GET /admin/version", h.version)
+	h.mux.HandleFunc("GET /admin/scaling", h.scaling)
+	h.mux.HandleFunc("GET /admin/cache/stats", h.cacheStats)
+	h.mux.HandleFunc("GET /admin/cache/keys", h.cacheKeys)
+	h.mux.HandleFunc("POST /admin/cache/invalidate", h.cacheInvalidate)
+	h.mux.HandleFunc("GET /admin/billing/export", h.billingExport)
+	h.mux.HandleFunc("GET /admin/maintenance", h.maintenanceStatus)
+	h.mux.HandleFunc("POST /admin/maintenance", h.maintenanceToggle)
+	h.mux.HandleFunc("GET /admin/speculative", h.speculativeStats)
+	h.mux.HandleFunc("GET /admin/regions", h.regionStatus)
+	h.mux.HandleFunc("GET /admin/models", h.discoveryStatus)
+	h.mux.HandleFunc("GET /admin/upstream-key", h.upstreamKeyStatus)
+	h.mux.HandleFunc("POST /admin/upstream-key", h.upstreamKeyRotate)
+	h.mux.HandleFunc("GET /admin/reload", h.reloadStatus)
+	h.mux.HandleFunc("POST /admin/reload", h.reload)
+	h.mux.HandleFunc("GET /admin/leader", h.leaderStatus)
+	h.mux.HandleFunc("GET /admin/debug/captures", h.debugCaptures)
+	h.mux.HandleFunc("POST /admin/debug/captures", h.debugCaptureStart)
+	h.mux.HandleFunc("DELETE /admin/debug/captures/{id}", h.debugCaptureStop)
+	h.mux.HandleFunc("POST /admin/queue/export", h.queueExport)
+	h.mux.HandleFunc("POST /admin/queue/import", h.queueImport)
+	h.mux.HandleFunc("GET /admin/slow", h.slowRequests)
+	h.mux.HandleFunc("GET /admin/journal", h.journalEntries)
+	h.mux.HandleFunc("DELETE /admin/journal/{id}", h.journalClear)
+	h.mux.HandleFunc("GET /admin/catalogs", h.catalogStatus)
+	h.mux.HandleFunc("POST /admin/catalogs", h.catalogSet)
+	h.mux.HandleFunc("DELETE /admin/catalogs/{key_id}", h.catalogRemove)
+	h.mux.HandleFunc("GET /admin/structured-outputs", h.structuredStatus)
+
+	return h
+}
+
+// SetTokens requires requests to carry one of tokens, and mutating requests
+// to carry one with the admin scope
+func (h *AdminHandler) SetTokens(tokens []config.AdminToken) error {
+	if len(tokens) == 0 {
+		h.tokens = nil
+		return nil
+	}
+
+	scopes := make(map[[sha256.Size]byte]string, len(tokens))
+	for i, token := range tokens {
+		if token.Token == "" {
+			return fmt.Errorf("admin token %d is empty", i)
+		}
+		if token.Scope != ScopeRead && token.Scope != ScopeAdmin {
+			return fmt.Errorf("admin token %d has unknown scope %q", i, token.Scope)
+		}
+		scopes[sha256.Sum256([]byte(token.Token))] = token.Scope
+	}
+	h.tokens = scopes
+	return nil
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.tokens != nil {
+		scope, ok := h.tokens[sha256.Sum256([]byte(bearerToken(r)))]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "Invalid or missing admin token")
+			return
+		}
+		if scope != ScopeAdmin && r.Method != "GET" && r.Method != "HEAD" {
+			writeError(w, http.StatusForbidden, "Admin token is read-only")
+			return
+		}
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the same shape the proxy uses elsewhere
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// cacheStats reports cache hit/miss counters
+func (h *AdminHandler) cacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.Cache == nil {
+		writeError(w, http.StatusNotFound, "Cache is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Cache.Stats())
+}
+
+// speculativeStats compares the backends requests were raced between
+func (h *AdminHandler) speculativeStats(w http.ResponseWriter, r *http.Request) {
+	if h.Speculator == nil {
+		writeError(w, http.StatusNotFound, "Speculative dispatch is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Speculator.Stats())
+}
+
+// regionStatus reports the health and latency of each upstream region
+func (h *AdminHandler) regionStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Regions == nil {
+		writeError(w, http.StatusNotFound, "Regional routing is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"regions": h.Regions.Status()})
+}
+
+// discoveryStatus reports the models each backend lists and where they are routed
+func (h *AdminHandler) discoveryStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Discovery == nil {
+		writeError(w, http.StatusNotFound, "Model discovery is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Discovery.Status())
+}
+
+// upstreamKeyStatus reports which upstream keys are in use
+func (h *AdminHandler) upstreamKeyStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Keys == nil {
+		writeError(w, http.StatusNotFound, "Upstream key rotation is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Keys.Status())
+}
+
+// upstreamKeyRotate switches to a new upstream key, keeping the old one
+// usable for a grace window
+func (h *AdminHandler) upstreamKeyRotate(w http.ResponseWriter, r *http.Request) {
+	if h.Keys == nil {
+		writeError(w, http.StatusNotFound, "Upstream key rotation is not available")
+		return
+	}
+
+	var req struct {
+		Key          string `json:"key"`
+		GraceSeconds int    `json:"grace_seconds"` // 0 uses the configured grace window
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" || req.GraceSeconds < 0 {
+		writeError(w, http.StatusBadRequest, "A key is required")
+		return
+	}
+
+	h.Keys.Rotate(req.Key, time.Duration(req.GraceSeconds)*time.Second)
+	writeJSON(w, http.StatusOK, h.Keys.Status())
+}
+
+// reloadStatus reports the outcome of config reloads
+func (h *AdminHandler) reloadStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Reloader == nil {
+		writeError(w, http.StatusNotFound, "Config reloading is not available")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Reloader.Status())
+}
+
+// reload re-reads the config file and applies what changed
+func (h *AdminHandler) reload(w http.ResponseWriter, r *http.Request) {
+	if h.Reloader == nil {
+		writeError(w, http.StatusNotFound, "Config reloading is not available")
+		return
+	}
+
+	if err := h.Reloader.Reload(); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Reloader.Status())
+}
+
+// leaderStatus reports whether this replica is the elected leader
+func (h *AdminHandler) leaderStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Elector == nil {
+		writeError(w, http.StatusNotFound, "Leader election is not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Elector.Status())
+}
+
+// queueExport takes the queued requests off this instance as a sealed
+// snapshot, optionally only those of one ?priority=
+func (h *AdminHandler) queueExport(w http.ResponseWriter, r *http.Request) {
+	if h.Snapshots == nil {
+		writeError(w, http.StatusNotFound, "Queue snapshots are not enabled")
+		return
+	}
+
+	priority := 0
+	if v := r.URL.Query().Get("priority"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil || h.QueueManager.FindQueue(p) == nil {
+			writeError(w, http.StatusBadRequest, "priority must be one of the queues' priorities")
+			return
+		}
+		priority = p
+	}
+
+	snapshot, n, err := h.Snapshots.Export(priority)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="queue-%s.snapshot"`, time.Now().UTC().Format("20060102T150405Z")))
+	w.Header().Set("X-Proxy-Exported", strconv.Itoa(n))
+	w.WriteHeader(http.StatusOK)
+	w.Write(snapshot)
+}
+
+// queueImport queues the requests of a snapshot another instance exported
+func (h *AdminHandler) queueImport(w http.ResponseWriter, r *http.Request) {
+	if h.Snapshots == nil {
+		writeError(w, http.StatusNotFound, "Queue snapshots are not enabled")
+		return
+	}
+
+	snapshot, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "Snapshot is too large")
+		return
+	}
+	result, err := h.Snapshots.Import(snapshot)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// slowRequests lists the slowest recent requests, slowest first
+func (h *AdminHandler) slowRequests(w http.ResponseWriter, r *http.Request) {
+	if h.Slow == nil {
+		writeError(w, http.StatusNotFound, "Slow request reports are not enabled")
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"requests": h.Slow.Slowest(limit)})
+}
+
+// journalEntries lists journaled writes, e.g. ?state=ambiguous for the ones
+// whose fate needs checking
+func (h *AdminHandler) journalEntries(w http.ResponseWriter, r *http.Request) {
+	if h.Journal == nil {
+		writeError(w, http.StatusNotFound, "Request journal is not enabled")
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", JournalPending, JournalCompleted, JournalAmbiguous:
+	default:
+		writeError(w, http.StatusBadRequest, "Invalid state")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"entries": h.Journal.Entries(state)})
+}
+
+// journalClear drops a journaled write, so its client may send it again
+func (h *AdminHandler) journalClear(w http.ResponseWriter, r *http.Request) {
+	if h.Journal == nil {
+		writeError(w, http.StatusNotFound, "Request journal is not enabled")
+		return
+	}
+	if !h.Journal.Clear(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "No such journal entry")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cacheKeys lists the most frequently served cache entries
+func (h *AdminHandler) cacheKeys(w http.ResponseWriter, r *http.Request) {
+	if h.Cache == nil {
+		writeError(w, http.StatusNotFound, "Cache is not enabled")
+		return
+	}
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": h.Cache.HotKeys(limit)})
+}
+
+// cacheInvalidate drops entries by key pattern or model name
+func (h *AdminHandler) cacheInvalidate(w http.ResponseWriter, r *http.Request) {
+	if h.Cache == nil {
+		writeError(w, http.StatusNotFound, "Cache is not enabled")
+		return
+	}
+
+	pattern := r.URL.Query().Get("pattern")
+	model := r.URL.Query().Get("model")
+
+	var removed int
+	switch {
+	case pattern != "":
+		removed = h.Cache.Invalidate(pattern)
+	case model != "":
+		removed = h.Cache.InvalidateModel(model)
+	default:
+		writeError(w, http.StatusBadRequest, "Either pattern or model is required")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"invalidated": removed})
+}
+
+// billingExport reports a month's per-key, per-model usage and cost as JSON or CSV
+func (h *AdminHandler) billingExport(w http.ResponseWriter, r *http.Request) {
+	if h.QueueManager == nil || h.QueueManager.Ledger == nil {
+		writeError(w, http.StatusNotFound, "Usage accounting is not enabled")
+		return
+	}
+
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format("2006-01")
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		writeError(w, http.StatusBadRequest, "month must be formatted as YYYY-MM")
+		return
+	}
+
+	entries := h.QueueManager.Ledger.Report(month)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		usage.WriteJSON(w, entries)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.csv"`, month))
+		usage.WriteCSV(w, entries)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+	}
+}
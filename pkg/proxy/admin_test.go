@@ -0,0 +1,304 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/usage"
+)
+
+// newTestCache returns a cache primed with a few entries
+func newTestCache() *ResponseCache {
+	cache := NewResponseCache(config.CacheConfig{TTL: 60, Paths: []string{"/v1/models", "/v1/models/*"}})
+	cache.store("/v1/models", 200, http.Header{}, []byte(`{"data":[]}`))
+	cache.store("/v1/models/gpt-4", 200, http.Header{}, []byte(`{"id":"gpt-4"}`))
+	cache.store("/v1/models/gpt-3.5-turbo", 200, http.Header{}, []byte(`{"id":"gpt-3.5-turbo"}`))
+	return cache
+}
+
+func TestAdminCacheStats(t *testing.T) {
+	cache := newTestCache()
+	entry, _ := cache.lookup("/v1/models/gpt-4")
+	cache.hit(entry)
+	cache.lookup("/v1/models/unknown")
+
+	handler := NewAdminHandler(nil, cache)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cache/stats", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	var stats CacheStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+
+	if stats.Entries != 3 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if stats.HitRatio != 0.5 {
+		t.Errorf("Expected hit ratio 0.5, got %v", stats.HitRatio)
+	}
+}
+
+func TestAdminCacheKeys(t *testing.T) {
+	cache := newTestCache()
+	entry, _ := cache.lookup("/v1/models/gpt-4")
+	cache.hit(entry)
+	cache.hit(entry)
+
+	handler := NewAdminHandler(nil, cache)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cache/keys?limit=2", nil))
+
+	var resp struct {
+		Keys []CacheKeyInfo `json:"keys"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode keys: %v", err)
+	}
+
+	if len(resp.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(resp.Keys))
+	}
+
+	if resp.Keys[0].Key != "/v1/models/gpt-4" || resp.Keys[0].Hits != 2 {
+		t.Errorf("Expected hottest key to be /v1/models/gpt-4 with 2 hits, got %+v", resp.Keys[0])
+	}
+
+	// Invalid limits are rejected
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cache/keys?limit=abc", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for invalid limit, got %d", recorder.Code)
+	}
+}
+
+func TestAdminCacheInvalidate(t *testing.T) {
+	cache := newTestCache()
+	handler := NewAdminHandler(nil, cache)
+
+	// Invalidate by model
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/cache/invalidate?model=gpt-4", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	var resp map[string]int
+	json.Unmarshal(recorder.Body.Bytes(), &resp)
+	if resp["invalidated"] != 1 {
+		t.Errorf("Expected 1 entry invalidated by model, got %d", resp["invalidated"])
+	}
+
+	// Invalidate by pattern
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/cache/invalidate?pattern=/v1/models/**", nil))
+	json.Unmarshal(recorder.Body.Bytes(), &resp)
+	if resp["invalidated"] != 2 {
+		t.Errorf("Expected 2 entries invalidated by pattern, got %d", resp["invalidated"])
+	}
+
+	if cache.Stats().Entries != 0 {
+		t.Errorf("Expected cache to be empty, got %d entries", cache.Stats().Entries)
+	}
+
+	// A selector is required
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/cache/invalidate", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 without a selector, got %d", recorder.Code)
+	}
+}
+
+func TestAdminCacheDisabled(t *testing.T) {
+	handler := NewAdminHandler(nil, nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/cache/stats", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404 when cache is disabled, got %d", recorder.Code)
+	}
+}
+
+func TestAdminBillingExport(t *testing.T) {
+	qm := NewQueueManager(nil, &MockOpenAIClient{})
+	qm.Ledger = usage.NewLedger(nil, false)
+	qm.Ledger.Record(usage.Record{Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), KeyID: "key-a", Model: "gpt-4", InputTokens: 10})
+
+	handler := NewAdminHandler(qm, nil)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/billing/export?month=2026-10", nil))
+
+	var entries []usage.Entry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	if len(entries) != 1 || entries[0].KeyID != "key-a" || entries[0].InputTokens != 10 {
+		t.Errorf("Unexpected export: %+v", entries)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/billing/export?month=2026-10&format=csv", nil))
+	if recorder.Header().Get("Content-Type") != "text/csv" || !strings.Contains(recorder.Body.String(), "2026-10,key-a,gpt-4") {
+		t.Errorf("Unexpected CSV export: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/billing/export?month=october", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for an invalid month, got %d", recorder.Code)
+	}
+}
+
+func TestAdminTokenScopes(t *testing.T) {
+	handler := NewAdminHandler(nil, newTestCache())
+	err := handler.SetTokens([]config.AdminToken{
+		{Token: "dashboard", Scope: ScopeRead},
+		{Token: "ops", Scope: ScopeAdmin},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set tokens: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "no token", method: "GET", path: "/admin/cache/stats", status: http.StatusUnauthorized},
+		{name: "unknown token", method: "GET", path: "/admin/cache/stats", token: "guess", status: http.StatusUnauthorized},
+		{name: "read token reads", method: "GET", path: "/admin/cache/stats", token: "dashboard", status: http.StatusOK},
+		{name: "read token mutates", method: "POST", path: "/admin/cache/invalidate?model=gpt-4", token: "dashboard", status: http.StatusForbidden},
+		{name: "admin token reads", method: "GET", path: "/admin/cache/stats", token: "ops", status: http.StatusOK},
+		{name: "admin token mutates", method: "POST", path: "/admin/cache/invalidate?model=gpt-4", token: "ops", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, recorder.Code)
+			}
+		})
+	}
+
+	if err := handler.SetTokens([]config.AdminToken{{Token: "x", Scope: "write"}}); err == nil {
+		t.Error("Expected error for unknown scope")
+	}
+}
+
+func TestAdminUpstreamKeyRotate(t *testing.T) {
+	handler := NewAdminHandler(nil, nil)
+	handler.Keys = openai.NewKeyRing("sk-old-0001", time.Minute)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/upstream-key", strings.NewReader(`{"key":"sk-new-0002"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+	if strings.Contains(recorder.Body.String(), "sk-new") {
+		t.Errorf("Expected the key to be masked, got %s", recorder.Body.String())
+	}
+
+	var status openai.KeyRingStatus
+	json.Unmarshal(recorder.Body.Bytes(), &status)
+	if status.Current != "...0002" || status.Previous != "...0001" || status.GraceUntil == nil {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if handler.Keys.Current() != "sk-new-0002" || handler.Keys.Previous() != "sk-old-0001" {
+		t.Errorf("Expected rotation with the old key in its grace window, got %q and %q", handler.Keys.Current(), handler.Keys.Previous())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/upstream-key", strings.NewReader(`{}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 without a key, got %d", recorder.Code)
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2},
+	}, &MockOpenAIClient{})
+	qm.FindQueue(2).Requests <- &workRequest{}
+	qm.FindQueue(2).running.Add(1)
+
+	handler := NewAdminHandler(qm, newTestCache())
+	handler.Maintenance = NewMaintenance("", time.Minute)
+	handler.Keys = openai.NewKeyRing("sk-test-0001", time.Minute)
+	handler.Backends = []BackendInfo{{URL: "https://api.openai.com", Role: "default"}}
+	handler.Limits = StatusLimits{RateWindowSeconds: 60, RequestsPerKey: 100}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/status", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	var status StatusSnapshot
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+
+	if len(status.Queues) != 2 || status.Queues[1].Depth != 1 || status.Queues[1].Running != 1 {
+		t.Errorf("Unexpected queues: %+v", status.Queues)
+	}
+	if status.Queued != 1 || status.Inflight != 1 {
+		t.Errorf("Expected 1 queued and 1 in flight, got %d and %d", status.Queued, status.Inflight)
+	}
+	if len(status.Backends) != 1 || status.Limits.RequestsPerKey != 100 {
+		t.Errorf("Unexpected backends or limits: %+v %+v", status.Backends, status.Limits)
+	}
+	if status.Maintenance == nil || status.Cache == nil || status.Cache.Entries != 3 || status.UpstreamKey == nil || status.UpstreamKey.Current != "...0001" {
+		t.Errorf("Expected maintenance, cache and upstream key sections, got %s", recorder.Body.String())
+	}
+	if status.Build.GoVersion == "" {
+		t.Errorf("Expected build info, got %+v", status.Build)
+	}
+
+	// Disabled features are left out
+	recorder = httptest.NewRecorder()
+	NewAdminHandler(nil, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/status", nil))
+	body := recorder.Body.String()
+	if strings.Contains(body, `"cache"`) || strings.Contains(body, `"maintenance"`) || !strings.Contains(body, `"queues":[]`) {
+		t.Errorf("Expected only the always-present sections, got %s", body)
+	}
+}
+
+func TestAdminVersion(t *testing.T) {
+	// Details set at link time win over the toolchain's
+	Version, Commit, BuildDate = "v1.8.0", "0123abc", "2026-10-16T09:00:00Z"
+	defer func() { Version, Commit, BuildDate = "", "", "" }()
+
+	h := NewAdminHandler(nil, nil)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/version", nil))
+
+	var build BuildInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &build); err != nil {
+		t.Fatalf("Failed to decode version: %v", err)
+	}
+	if build.Version != "v1.8.0" || build.Revision != "0123abc" || build.BuiltAt != "2026-10-16T09:00:00Z" || build.GoVersion == "" {
+		t.Errorf("Expected the linked build details, got %+v", build)
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// admit estimates a request's tokens before it is queued, rejecting it when
+// they can't fit the model's context window or, with QuotaPrecheck, the
+// key's remaining token budget. Those requests would otherwise wait in the
+// queue only to fail upstream or overrun the quota.
+func (h *RequestHandler) admit(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, body []byte, model string) bool {
+	price, _ := h.Pricing.Lookup(model)
+	checkQuota := h.QuotaPrecheck && h.QueueManager.Quotas != nil
+	if len(body) == 0 || model == "" || (price.ContextWindow == 0 && !checkQuota) {
+		return true
+	}
+
+	// Bodies the estimator can't read are left for the upstream to judge
+	var est estimateBody
+	if err := json.Unmarshal(body, &est); err != nil {
+		return true
+	}
+	est.Model = model
+	input, err := countInputTokens(&est)
+	if err != nil {
+		return true
+	}
+
+	output := est.maxOutput()
+
+	if window := price.ContextWindow; window > 0 && input+output > window {
+		message := fmt.Sprintf("This request needs %d tokens (%d input + %d max output) but %s's context window is %d tokens",
+			input+output, input, output, model, window)
+		if output == 0 {
+			message = fmt.Sprintf("This request has %d input tokens but %s's context window is %d tokens", input, model, window)
+		}
+		writeAdmissionError(w, http.StatusBadRequest, message, "invalid_request_error", "context_length_exceeded")
+		return false
+	}
+
+	if !checkQuota {
+		return true
+	}
+	decision := h.QueueManager.Quotas.AllowBudget(clientKeyID(r), principalFromContext(r.Context()).Limits.TokensPerPeriod)
+	if decision.Limit == 0 {
+		return true
+	}
+
+	// The budget has to cover the most the request could generate
+	if output == 0 {
+		output = price.MaxOutputTokens
+	}
+	if est.N > 1 {
+		output *= est.N
+	}
+	if input+output <= decision.Remaining {
+		return true
+	}
+
+	retryAfter := time.Until(h.QueueManager.Quotas.NextReset())
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := fmt.Sprintf("This request may use up to %d tokens (%d input + %d max output) but only %d of this key's %d token quota remain until it resets",
+		input+output, input, output, decision.Remaining, decision.Limit)
+	writeAdmissionError(w, http.StatusTooManyRequests, message, "rate_limit_error", "insufficient_quota")
+	h.rejected(r, queue, model, http.StatusTooManyRequests, RejectQuota)
+	return false
+}
+
+// writeAdmissionError writes an error in the shape OpenAI returns, so clients
+// handle it as they would the upstream's own rejection
+func writeAdmissionError(w http.ResponseWriter, status int, message, errType, code string) {
+	writeJSON(w, status, map[string]OpenAIError{"error": {Message: message, Type: errType, Code: code}})
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/pricing"
+	"github.com/mule-ai/proxy/pkg/quota"
+)
+
+func TestAdmit(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{})
+	qm.Quotas = quota.NewManager(quota.Daily, time.UTC, 1000, nil, false, 0)
+	keyed := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	keyed.Header.Set("Authorization", "Bearer client-key")
+	qm.Quotas.Consume(clientKeyID(keyed), 900)
+
+	handler := NewRequestHandler(qm)
+	handler.QuotaPrecheck = true
+	handler.Pricing = pricing.NewTable(map[string]config.ModelPrice{
+		"gpt-4": {ContextWindow: 50, MaxOutputTokens: 40},
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"fits", `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":10}`, http.StatusOK, ""},
+		{"over context window", `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":100}`, http.StatusBadRequest, "context_length_exceeded"},
+		{"over remaining quota", `{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":40,"n":3}`, http.StatusTooManyRequests, "insufficient_quota"},
+		{"no context window", `{"model":"other","messages":[{"role":"user","content":"Hi"}],"max_tokens":60}`, http.StatusOK, ""},
+		{"unreadable body", `not json`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer client-key")
+		w := httptest.NewRecorder()
+		var model string
+		if strings.HasPrefix(tt.body, "{") {
+			var body struct{ Model string }
+			json.Unmarshal([]byte(tt.body), &body)
+			model = body.Model
+		}
+
+		if ok := handler.admit(w, req, nil, []byte(tt.body), model); ok != (tt.status == http.StatusOK) {
+			t.Errorf("%s: expected admitted %v, got %v", tt.name, tt.status == http.StatusOK, ok)
+			continue
+		}
+		if tt.status == http.StatusOK {
+			continue
+		}
+
+		var resp struct{ Error OpenAIError }
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != tt.status || resp.Error.Code != tt.code {
+			t.Errorf("%s: expected %d %s, got %d %s", tt.name, tt.status, tt.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAdmitMessage(t *testing.T) {
+	handler := NewRequestHandler(NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{}))
+	handler.Pricing = pricing.NewTable(map[string]config.ModelPrice{"gpt-4": {ContextWindow: 20}})
+
+	body := []byte(`{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}],"max_tokens":30}`)
+	w := httptest.NewRecorder()
+	if handler.admit(w, httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body)), nil, body, "gpt-4") {
+		t.Fatal("Expected the request to be rejected")
+	}
+
+	// The message says how far over the window the request is
+	var resp struct{ Error OpenAIError }
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	want := "This request needs 37 tokens (7 input + 30 max output) but gpt-4's context window is 20 tokens"
+	if resp.Error.Message != want {
+		t.Errorf("Expected %q, got %q", want, resp.Error.Message)
+	}
+}
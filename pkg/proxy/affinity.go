@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// SessionHeader names the conversation a request belongs to
+const SessionHeader = "X-Session-Id"
+
+// sessionKey is the context key for a request's session ID
+type sessionKey struct{}
+
+// contextWithSession returns a context carrying the session a request belongs to
+func contextWithSession(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, id)
+}
+
+// sessionFromContext returns the session ID carried by a context, if any
+func sessionFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+// sessionID identifies the conversation a request belongs to. Clients can
+// name it with X-Session-Id; otherwise chat requests are keyed by their
+// opening messages, which stay the same on every turn.
+func sessionID(r *http.Request, body []byte) string {
+	if id := r.Header.Get(SessionHeader); id != "" {
+		return id
+	}
+
+	var request struct {
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || len(request.Messages) == 0 {
+		return ""
+	}
+
+	// The leading instructions and first user message identify the
+	// conversation; whatever follows them changes from turn to turn
+	h := sha256.New()
+	for _, msg := range request.Messages {
+		var role struct {
+			Role string `json:"role"`
+		}
+		json.Unmarshal(msg, &role)
+		switch role.Role {
+		case "system", "developer":
+			h.Write(msg)
+			continue
+		case "user":
+			h.Write(msg)
+		}
+		break
+	}
+	return "messages-" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Replica balancing policies, choosing what requests are pinned to a replica by
+const (
+	BalanceSession = "session" // Each conversation, keeping the replica's prefix cache warm
+	BalanceKey     = "key"     // Each client API key, for replicas that keep per-client state or caches
+)
+
+// clientKeyContextKey is the context key for the client key a request is billed to
+type clientKeyContextKey struct{}
+
+// contextWithClientKey returns a context carrying the key ID of the client that sent a request
+func contextWithClientKey(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, clientKeyContextKey{}, keyID)
+}
+
+// clientKeyFromContext returns the client key ID carried by a context, if any
+func clientKeyFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(clientKeyContextKey{}).(string)
+	return id
+}
+
+// AffinityClient spreads requests over several upstream replicas, sending
+// every request of a session, or of a client key, to the same replica so its
+// caches stay warm. Requests without one are distributed round robin.
+// Assistants, threads and fine-tuning jobs exist only on the replica that
+// created them, so requests naming one go where it was created, and the
+// rest of those APIs are pinned by client key whatever the Policy.
+type AffinityClient struct {
+	Replicas []OpenAIClient
+	// Policy is what requests are pinned by: BalanceSession (default) or BalanceKey
+	Policy string
+	// LoadFactor caps each replica's in-flight requests at this multiple of
+	// the average, sending requests whose replica is full to the next one in
+	// their hash order; 0 leaves replicas unbounded
+	LoadFactor float64
+	next       atomic.Uint64
+	mu         sync.Mutex
+	inflight   []int
+	pins       pins
+}
+
+// NewAffinityClient creates a client over a set of upstream replicas
+func NewAffinityClient(replicas ...OpenAIClient) *AffinityClient {
+	return &AffinityClient{Replicas: replicas}
+}
+
+// ForwardRequest implements OpenAIClient
+func (c *AffinityClient) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	stateful := isStateful(path)
+	var i int
+	if stateful {
+		i = c.statefulReplica(clientKeyFromContext(ctx), statefulIDs(path))
+	} else {
+		i = c.replica(c.affinityKey(ctx))
+	}
+
+	resp, err := c.Replicas[i].ForwardRequest(ctx, method, path, body)
+	if err != nil {
+		c.release(i)
+		return nil, err
+	}
+	// Objects the response creates stay on this replica
+	if stateful {
+		resp.Body = &pinBody{ReadCloser: resp.Body, pin: func(ids []string) {
+			c.mu.Lock()
+			c.pins.add(ids, i)
+			c.mu.Unlock()
+		}}
+	}
+	// The request holds its replica until the response has been read
+	resp.Body = &replicaBody{ReadCloser: resp.Body, release: func() { c.release(i) }}
+	return resp, nil
+}
+
+// affinityKey returns what a request is pinned to a replica by, empty for none
+func (c *AffinityClient) affinityKey(ctx context.Context) string {
+	if c.Policy != BalanceKey {
+		return sessionFromContext(ctx)
+	}
+	// Anonymous clients share no state on the replicas
+	if key := clientKeyFromContext(ctx); key != "anonymous" {
+		return key
+	}
+	return ""
+}
+
+// replica picks the upstream for an affinity key using rendezvous hashing,
+// so appending a replica only moves the keys it takes over, and counts the
+// request against it. With a LoadFactor, a key whose replica is full goes to
+// the next replica in its own order, so keys spill over consistently.
+func (c *AffinityClient) replica(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.growInflight()
+
+	var pick int
+	if key == "" {
+		pick = int((c.next.Add(1) - 1) % uint64(len(c.Replicas)))
+	} else {
+		order := c.rank(key)
+		pick = order[0]
+		if c.LoadFactor > 0 {
+			total := 1
+			for _, n := range c.inflight {
+				total += n
+			}
+			// Some replica is always under the cap, since the cap is at least the average
+			limit := int(math.Ceil(c.LoadFactor * float64(total) / float64(len(c.Replicas))))
+			for _, i := range order {
+				if c.inflight[i] < limit {
+					pick = i
+					break
+				}
+			}
+		}
+	}
+
+	c.inflight[pick]++
+	return pick
+}
+
+// statefulReplica picks the upstream for a request to an API whose objects
+// live on one replica: the replica the first of ids was pinned to,
+// otherwise the client key's first choice, never spilling over, so the
+// objects it creates and finds are on the same replica. It counts the
+// request against the replica.
+func (c *AffinityClient) statefulReplica(key string, ids []string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.growInflight()
+
+	pick, ok := c.pins.lookup(ids)
+	if !ok || pick >= len(c.Replicas) {
+		pick = c.rank(key)[0]
+	}
+	c.inflight[pick]++
+	return pick
+}
+
+// rank orders the replicas by an affinity key's preference for them
+func (c *AffinityClient) rank(key string) []int {
+	order := make([]int, len(c.Replicas))
+	scores := make([]uint64, len(c.Replicas))
+	for i := range c.Replicas {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{byte(i)})
+		order[i], scores[i] = i, h.Sum64()
+	}
+	sort.Slice(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	return order
+}
+
+// growInflight makes room to count requests on replicas appended since the
+// last request. Callers hold mu.
+func (c *AffinityClient) growInflight() {
+	if len(c.inflight) < len(c.Replicas) {
+		c.inflight = append(c.inflight, make([]int, len(c.Replicas)-len(c.inflight))...)
+	}
+}
+
+// release ends a request's hold on a replica
+func (c *AffinityClient) release(i int) {
+	c.mu.Lock()
+	c.inflight[i]--
+	c.mu.Unlock()
+}
+
+// replicaBody releases its replica when the response body is closed
+type replicaBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close implements io.Closer
+func (b *replicaBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionID(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set(SessionHeader, "conversation-1")
+	if id := sessionID(req, nil); id != "conversation-1" {
+		t.Errorf("Expected session from header, got %q", id)
+	}
+
+	// Later turns share their opening messages with the first turn
+	req = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	first := sessionID(req, []byte(`{"messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hi"}]}`))
+	later := sessionID(req, []byte(`{"messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello"},{"role":"user","content":"Bye"}]}`))
+	other := sessionID(req, []byte(`{"messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hey"}]}`))
+
+	if first == "" || first != later {
+		t.Errorf("Expected turns of a conversation to share a session, got %q and %q", first, later)
+	}
+	if first == other {
+		t.Error("Expected different conversations to have different sessions")
+	}
+
+	// Conversations without a system prompt are keyed by their first message alone
+	opening := sessionID(req, []byte(`{"messages":[{"role":"user","content":"Hi"}]}`))
+	reply := sessionID(req, []byte(`{"messages":[{"role":"user","content":"Hi"},{"role":"assistant","content":"Hello"},{"role":"user","content":"Bye"}]}`))
+	if opening == "" || opening != reply {
+		t.Errorf("Expected turns without a system prompt to share a session, got %q and %q", opening, reply)
+	}
+
+	if id := sessionID(req, []byte(`{"input":"text"}`)); id != "" {
+		t.Errorf("Expected no session for requests without messages, got %q", id)
+	}
+}
+
+func TestAffinityClient(t *testing.T) {
+	replicas := []*MockOpenAIClient{
+		{ResponseStatus: 200},
+		{ResponseStatus: 200},
+		{ResponseStatus: 200},
+	}
+	client := NewAffinityClient(replicas[0], replicas[1], replicas[2])
+
+	// Every request of a session goes to the same replica
+	ctx := contextWithSession(context.Background(), "conversation-1")
+	for i := 0; i < 5; i++ {
+		client.ForwardRequest(ctx, "POST", "/v1/chat/completions", nil)
+	}
+
+	pinned := 0
+	for _, r := range replicas {
+		if r.CallCount == 5 {
+			pinned++
+		} else if r.CallCount != 0 {
+			t.Errorf("Expected session to stay on one replica, got call counts %d/%d/%d",
+				replicas[0].CallCount, replicas[1].CallCount, replicas[2].CallCount)
+		}
+	}
+	if pinned != 1 {
+		t.Errorf("Expected exactly one replica to serve the session, got %d", pinned)
+	}
+
+	// Requests without a session are spread over all replicas
+	for _, r := range replicas {
+		r.CallCount = 0
+	}
+	for i := 0; i < 3; i++ {
+		client.ForwardRequest(context.Background(), "GET", "/v1/models", nil)
+	}
+	for i, r := range replicas {
+		if r.CallCount != 1 {
+			t.Errorf("Expected replica %d to get one request, got %d", i, r.CallCount)
+		}
+	}
+}
+
+func TestAffinityClientByKey(t *testing.T) {
+	replicas := []*MockOpenAIClient{{ResponseStatus: 200}, {ResponseStatus: 200}}
+	client := NewAffinityClient(replicas[0], replicas[1])
+	client.Policy = BalanceKey
+
+	// Every request of a client key goes to the same replica, whatever its session
+	for i := 0; i < 4; i++ {
+		ctx := contextWithClientKey(contextWithSession(context.Background(), fmt.Sprintf("conversation-%d", i)), "key-1")
+		resp, _ := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", nil)
+		resp.Body.Close()
+	}
+	if replicas[0].CallCount != 4 && replicas[1].CallCount != 4 {
+		t.Errorf("Expected the key to stay on one replica, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+
+	// Anonymous clients are spread over all replicas
+	replicas[0].CallCount, replicas[1].CallCount = 0, 0
+	for i := 0; i < 2; i++ {
+		resp, _ := client.ForwardRequest(contextWithClientKey(context.Background(), "anonymous"), "GET", "/v1/models", nil)
+		resp.Body.Close()
+	}
+	if replicas[0].CallCount != 1 || replicas[1].CallCount != 1 {
+		t.Errorf("Expected anonymous requests round robin, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+}
+
+func TestAffinityClientBoundedLoad(t *testing.T) {
+	replicas := []*MockOpenAIClient{{ResponseStatus: 200}, {ResponseStatus: 200}}
+	client := NewAffinityClient(replicas[0], replicas[1])
+	client.Policy = BalanceKey
+	client.LoadFactor = 1
+
+	ctx := contextWithClientKey(context.Background(), "key-1")
+	preferred := client.replica("key-1")
+	client.release(preferred)
+
+	// With the key's replica at its share of in-flight requests, the next one spills over
+	first, _ := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", nil)
+	second, _ := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", nil)
+	if replicas[preferred].CallCount != 1 || replicas[1-preferred].CallCount != 1 {
+		t.Fatalf("Expected the second request to spill over, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+
+	// Once responses have been read, the key's replica has room again
+	first.Body.Close()
+	second.Body.Close()
+	third, _ := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", nil)
+	third.Body.Close()
+	if replicas[preferred].CallCount != 2 {
+		t.Errorf("Expected the key back on its replica, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+	if client.inflight[0] != 0 || client.inflight[1] != 0 {
+		t.Errorf("Expected no requests in flight, got %v", client.inflight)
+	}
+}
+
+func TestAffinityClientStateful(t *testing.T) {
+	thread := `{"id":"thread_abc123","object":"thread"}`
+	replicas := []*MockOpenAIClient{{ResponseStatus: 200, ResponseBody: thread}, {ResponseStatus: 200, ResponseBody: thread}}
+	client := NewAffinityClient(replicas[0], replicas[1])
+	client.LoadFactor = 1
+
+	// The thread is created on the creating key's replica
+	resp, _ := client.ForwardRequest(contextWithClientKey(context.Background(), "key-1"), "POST", "/v1/threads", nil)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	created := client.rank("key-1")[0]
+	if replicas[created].CallCount != 1 {
+		t.Fatalf("Expected the thread created on the key's replica, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+
+	// A key that prefers the other replica still finds the thread where it was created
+	other := "key-2"
+	for i := 3; client.rank(other)[0] == created; i++ {
+		other = fmt.Sprintf("key-%d", i)
+	}
+	resp, _ = client.ForwardRequest(contextWithClientKey(context.Background(), other), "GET", "/v1/threads/thread_abc123/messages?limit=20", nil)
+	resp.Body.Close()
+	if replicas[created].CallCount != 2 {
+		t.Errorf("Expected the thread's requests pinned to its replica, got call counts %d/%d", replicas[0].CallCount, replicas[1].CallCount)
+	}
+}
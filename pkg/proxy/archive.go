@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/mule-ai/proxy/pkg/archive"
+)
+
+// archiveTee hands a response body to archivers chunk by chunk as the
+// client reads it. Archivers never block, so the client sees no delay.
+type archiveTee struct {
+	io.ReadCloser
+	captures []*archive.Capture
+}
+
+// newArchiveTee wraps a response body
+func newArchiveTee(body io.ReadCloser, captures ...*archive.Capture) *archiveTee {
+	return &archiveTee{ReadCloser: body, captures: captures}
+}
+
+// Read implements io.Reader
+func (t *archiveTee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		for _, capture := range t.captures {
+			capture.Chunk(p[:n])
+		}
+	}
+	return n, err
+}
+
+// Close completes the archived records and closes the body
+func (t *archiveTee) Close() error {
+	for _, capture := range t.captures {
+		capture.Finish()
+	}
+	return t.ReadCloser.Close()
+}
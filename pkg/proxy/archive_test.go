@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/archive"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestArchiveStreamingResponse(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n" +
+		"data: [DONE]\n\n"
+
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			// Send the stream in several chunks like a real upstream would
+			pr, pw := io.Pipe()
+			go func() {
+				for _, event := range strings.SplitAfter(stream, "\n\n") {
+					pw.Write([]byte(event))
+				}
+				pw.Close()
+			}()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/event-stream"}},
+				Body:       pr,
+			}, nil
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+	sink, err := archive.NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Archiver = archive.NewArchiver(sink, 1<<20, 64)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	requestBody := `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"Hi"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(requestBody))
+	req.Host = "localhost:8080"
+	recorder := httptest.NewRecorder()
+	NewRequestHandler(qm).ServeHTTP(recorder, req)
+
+	if recorder.Body.String() != stream {
+		t.Fatalf("Expected the client to receive the whole stream, got %q", recorder.Body.String())
+	}
+
+	if err := qm.Archiver.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	var rec archive.Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Invalid archive record %q: %v", data, err)
+	}
+
+	if !rec.Streamed || rec.Model != "gpt-4" || rec.Status != http.StatusOK {
+		t.Errorf("Unexpected archive record: %+v", rec)
+	}
+	if rec.Request != requestBody {
+		t.Errorf("Expected the request body to be archived, got %q", rec.Request)
+	}
+	if rec.Response != stream || rec.Truncated {
+		t.Errorf("Expected the whole stream to be archived, got %q (truncated: %v)", rec.Response, rec.Truncated)
+	}
+}
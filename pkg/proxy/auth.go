@@ -0,0 +1,374 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// Built-in authentication modes an endpoint can use, see RegisterAuthorizer for others
+const (
+	AuthNone = "none" // Anyone who can reach the port may use it
+	AuthKeys = "keys" // Clients send one of a set of static bearer keys
+	AuthJWT  = "jwt"  // Clients send a signed bearer JWT
+)
+
+// ErrUnauthorized is returned by authorizers for requests without valid credentials
+var ErrUnauthorized = errors.New("invalid or missing credentials")
+
+// Principal is who a request authenticated as, and what its authorizer
+// allows it. Zero fields leave the endpoint's own settings in place.
+type Principal struct {
+	// Subject identifies the client in place of its bearer key, e.g. a
+	// JWT's "sub", so it is tracked the same whatever token it sends
+	Subject string
+	// Priority sends the client's requests to the queue of this priority
+	// rather than their port's; path rules still take precedence
+	Priority int
+	Limits   Limits
+}
+
+// Limits override the configured rate limit and quota for one principal
+// (0 keeps the configured one)
+type Limits struct {
+	RequestsPerWindow int64 // Requests per rate limit window
+	TokensPerPeriod   int64 // Tokens per quota period
+}
+
+// principalKey is the context key for the principal a request authenticated as
+type principalKey struct{}
+
+// principalFromContext returns the principal a request authenticated as,
+// the zero principal for requests to open endpoints
+func principalFromContext(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(*Principal); ok {
+		return *p
+	}
+	return Principal{}
+}
+
+// ContextWithPrincipal returns ctx carrying the principal a request
+// authenticated as, for transports that authenticate outside the HTTP
+// listeners, e.g. gRPC
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	if principal == nil || *principal == (Principal{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// subjectFromContext returns the subject a request authenticated as, if any
+func subjectFromContext(ctx context.Context) string {
+	return principalFromContext(ctx).Subject
+}
+
+// Authorizer checks a request's credentials, returning who it is from and
+// what it is allowed, or an error (ErrUnauthorized or another) to refuse it.
+// Implementations are called concurrently.
+type Authorizer interface {
+	Authorize(r *http.Request) (*Principal, error)
+}
+
+// AuthorizerFactory creates an authorizer from an endpoint's auth settings
+type AuthorizerFactory func(cfg config.AuthConfig) (Authorizer, error)
+
+// authorizers are the factories of the auth modes, by mode
+var authorizers = struct {
+	sync.RWMutex
+	byMode map[string]AuthorizerFactory
+}{byMode: map[string]AuthorizerFactory{
+	AuthKeys: func(cfg config.AuthConfig) (Authorizer, error) {
+		if len(cfg.Keys) == 0 {
+			return nil, errors.New("keys auth needs at least one key")
+		}
+		return newKeyAuthorizer(cfg.Keys), nil
+	},
+	AuthJWT: func(cfg config.AuthConfig) (Authorizer, error) {
+		return newJWTAuthorizer(cfg.JWT)
+	},
+}}
+
+// RegisterAuthorizer makes a custom auth mode available to endpoints, for
+// programs embedding the proxy. Endpoints name it as their auth mode, and
+// the factory reads its settings from their auth options. Registering a
+// built-in mode replaces it; "none" can't be.
+func RegisterAuthorizer(mode string, factory AuthorizerFactory) error {
+	if mode == "" || mode == AuthNone {
+		return fmt.Errorf("auth mode %q can't be registered", mode)
+	}
+	authorizers.Lock()
+	defer authorizers.Unlock()
+	authorizers.byMode[mode] = factory
+	return nil
+}
+
+// NewAuthorizer creates the authorizer for an endpoint's auth settings, nil
+// when the endpoint is open
+func NewAuthorizer(cfg config.AuthConfig) (Authorizer, error) {
+	if cfg.Mode == "" || cfg.Mode == AuthNone {
+		return nil, nil
+	}
+	authorizers.RLock()
+	factory, ok := authorizers.byMode[cfg.Mode]
+	authorizers.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Mode)
+	}
+	return factory(cfg)
+}
+
+// OpenAuthorizer accepts every request without identifying it, as an open
+// endpoint does, e.g. for custom authorizers to fall back on
+type OpenAuthorizer struct{}
+
+// Authorize implements Authorizer
+func (OpenAuthorizer) Authorize(r *http.Request) (*Principal, error) {
+	return &Principal{}, nil
+}
+
+// keyAuthorizer accepts a fixed set of bearer keys
+type keyAuthorizer struct {
+	keys map[[sha256.Size]byte]bool // Hashed so lookups don't leak key prefixes through timing
+}
+
+// newKeyAuthorizer accepts the given keys
+func newKeyAuthorizer(keys []string) *keyAuthorizer {
+	a := &keyAuthorizer{keys: make(map[[sha256.Size]byte]bool, len(keys))}
+	for _, key := range keys {
+		a.keys[sha256.Sum256([]byte(key))] = true
+	}
+	return a
+}
+
+// Authorize implements Authorizer
+func (a *keyAuthorizer) Authorize(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" || !a.keys[sha256.Sum256([]byte(token))] {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{}, nil
+}
+
+// jwtAuthorizer accepts bearer JWTs signed with a shared secret (HS256)
+// or an RSA key (RS256)
+type jwtAuthorizer struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	now       func() time.Time
+}
+
+// newJWTAuthorizer validates tokens against the configured key and claims
+func newJWTAuthorizer(cfg config.JWTConfig) (*jwtAuthorizer, error) {
+	a := &jwtAuthorizer{issuer: cfg.Issuer, audience: cfg.Audience, now: time.Now}
+	switch {
+	case cfg.Secret != "" && cfg.PublicKeyFile != "":
+		return nil, errors.New("jwt auth takes a secret or a public key, not both")
+	case cfg.Secret != "":
+		a.secret = []byte(cfg.Secret)
+	case cfg.PublicKeyFile != "":
+		key, err := loadRSAPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.publicKey = key
+	default:
+		return nil, errors.New("jwt auth needs a secret or a public key")
+	}
+	return a, nil
+}
+
+// loadRSAPublicKey reads a PEM encoded RSA public key
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwt public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jwt public key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt public key in %s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// jwtClaims are the registered claims checked on every token
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // A string or an array of strings
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+// hasAudience reports whether the token was issued for an audience
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var one string
+	if json.Unmarshal(c.Audience, &one) == nil {
+		return one == audience
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		for _, aud := range many {
+			if aud == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize implements Authorizer
+func (a *jwtAuthorizer) Authorize(r *http.Request) (*Principal, error) {
+	parts := strings.Split(bearerToken(r), ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnauthorized
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if !a.verify(header.Alg, parts[0]+"."+parts[1], signature) {
+		return nil, ErrUnauthorized
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrUnauthorized
+	}
+	now := a.now().Unix()
+	if claims.ExpiresAt != nil && now >= *claims.ExpiresAt {
+		return nil, ErrUnauthorized
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return nil, ErrUnauthorized
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, ErrUnauthorized
+	}
+	if a.audience != "" && !claims.hasAudience(a.audience) {
+		return nil, ErrUnauthorized
+	}
+	if claims.Subject == "" {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{Subject: claims.Subject}, nil
+}
+
+// verify checks a token's signature with the configured key. The algorithm
+// must match the key, so an RS256 key can't be used as an HS256 secret.
+func (a *jwtAuthorizer) verify(alg, signed string, signature []byte) bool {
+	switch {
+	case alg == "HS256" && a.secret != nil:
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write([]byte(signed))
+		return hmac.Equal(mac.Sum(nil), signature)
+	case alg == "RS256" && a.publicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// AuthSwitch is an Authorizer that can be replaced while the proxy runs,
+// e.g. to change an endpoint's keys when the config is reloaded
+type AuthSwitch struct {
+	current atomic.Pointer[Authorizer]
+}
+
+// NewAuthSwitch creates a switch authorizing with auth until it is replaced
+func NewAuthSwitch(auth Authorizer) *AuthSwitch {
+	s := &AuthSwitch{}
+	s.Set(auth)
+	return s
+}
+
+// Set replaces the authorizer requests are checked with from now on
+func (s *AuthSwitch) Set(auth Authorizer) {
+	s.current.Store(&auth)
+}
+
+// Authorize implements Authorizer
+func (s *AuthSwitch) Authorize(r *http.Request) (*Principal, error) {
+	return (*s.current.Load()).Authorize(r)
+}
+
+// authHandler refuses requests to an endpoint that don't authenticate
+type authHandler struct {
+	next http.Handler
+	auth Authorizer
+}
+
+// NewAuthHandler wraps next so only requests auth accepts reach it. The
+// readiness check stays open for load balancers, as do CORS preflights.
+func NewAuthHandler(next http.Handler, auth Authorizer) http.Handler {
+	return &authHandler{next: next, auth: auth}
+}
+
+// ServeHTTP implements http.Handler
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" || r.URL.Path == "/proxy/ready" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	principal, err := h.auth.Authorize(r)
+	if err != nil {
+		if !errors.Is(err, ErrUnauthorized) {
+			fmt.Printf("Authorizer error for %s: %v\n", r.URL.Path, err)
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="proxy"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"Invalid or missing credentials"}`))
+		return
+	}
+
+	if principal != nil && *principal != (Principal{}) {
+		r = r.WithContext(ContextWithPrincipal(r.Context(), principal))
+		if entry := accessEntryFromContext(r.Context()); entry != nil {
+			entry.KeyID = clientKeyID(r)
+		}
+	}
+	h.next.ServeHTTP(w, r)
+}
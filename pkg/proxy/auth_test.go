@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+// signJWT builds a token with the given claims, signed by sign
+func signJWT(t *testing.T, alg string, claims map[string]any, sign func(signed string) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+func hs256(secret string) func(string) []byte {
+	return func(signed string) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(signed))
+		return mac.Sum(nil)
+	}
+}
+
+// serveAuth sends a request with token to an auth handler and returns the
+// response and the key ID the wrapped handler saw
+func serveAuth(auth Authorizer, path, token string) (*httptest.ResponseRecorder, string) {
+	var keyID string
+	handler := NewAuthHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID = clientKeyID(r)
+	}), auth)
+
+	req := httptest.NewRequest("POST", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder, keyID
+}
+
+func TestNewAuthorizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.AuthConfig
+		open    bool
+		wantErr bool
+	}{
+		{name: "default", cfg: config.AuthConfig{}, open: true},
+		{name: "none", cfg: config.AuthConfig{Mode: "none"}, open: true},
+		{name: "keys", cfg: config.AuthConfig{Mode: "keys", Keys: []string{"sk-1"}}},
+		{name: "keys without keys", cfg: config.AuthConfig{Mode: "keys"}, wantErr: true},
+		{name: "jwt", cfg: config.AuthConfig{Mode: "jwt", JWT: config.JWTConfig{Secret: "s"}}},
+		{name: "jwt without key", cfg: config.AuthConfig{Mode: "jwt"}, wantErr: true},
+		{name: "jwt missing key file", cfg: config.AuthConfig{Mode: "jwt", JWT: config.JWTConfig{PublicKeyFile: "/nonexistent.pem"}}, wantErr: true},
+		{name: "unknown", cfg: config.AuthConfig{Mode: "basic"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewAuthorizer(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && (auth == nil) != tt.open {
+				t.Errorf("Expected open endpoint %v, got authorizer %v", tt.open, auth)
+			}
+		})
+	}
+}
+
+func TestKeyAuth(t *testing.T) {
+	auth, _ := NewAuthorizer(config.AuthConfig{Mode: "keys", Keys: []string{"sk-good", "sk-other"}})
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "valid key", path: "/v1/chat/completions", token: "sk-good", status: http.StatusOK},
+		{name: "second key", path: "/v1/chat/completions", token: "sk-other", status: http.StatusOK},
+		{name: "wrong key", path: "/v1/chat/completions", token: "sk-bad", status: http.StatusUnauthorized},
+		{name: "no key", path: "/v1/chat/completions", status: http.StatusUnauthorized},
+		{name: "readiness is open", path: "/proxy/ready", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, keyID := serveAuth(auth, tt.path, tt.token)
+			if recorder.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, recorder.Code)
+			}
+			if tt.status == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on rejection")
+			}
+			if tt.status == http.StatusOK && tt.token != "" && keyID != KeyID(tt.token) {
+				t.Errorf("Expected key ID %s, got %s", KeyID(tt.token), keyID)
+			}
+		})
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	auth, err := NewAuthorizer(config.AuthConfig{Mode: "jwt", JWT: config.JWTConfig{
+		Secret:   "shh",
+		Issuer:   "https://issuer.example",
+		Audience: "proxy",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+
+	now := time.Now().Unix()
+	valid := map[string]any{"sub": "alice", "iss": "https://issuer.example", "aud": "proxy", "exp": now + 60}
+	with := func(key string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "valid", token: signJWT(t, "HS256", valid, hs256("shh")), ok: true},
+		{name: "audience list", token: signJWT(t, "HS256", with("aud", []string{"other", "proxy"}), hs256("shh")), ok: true},
+		{name: "wrong secret", token: signJWT(t, "HS256", valid, hs256("nope"))},
+		{name: "alg none", token: signJWT(t, "none", valid, func(string) []byte { return nil })},
+		{name: "expired", token: signJWT(t, "HS256", with("exp", now-1), hs256("shh"))},
+		{name: "not yet valid", token: signJWT(t, "HS256", with("nbf", now+60), hs256("shh"))},
+		{name: "wrong issuer", token: signJWT(t, "HS256", with("iss", "https://evil.example"), hs256("shh"))},
+		{name: "wrong audience", token: signJWT(t, "HS256", with("aud", "other"), hs256("shh"))},
+		{name: "no subject", token: signJWT(t, "HS256", with("sub", nil), hs256("shh"))},
+		{name: "garbage", token: "not-a-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, keyID := serveAuth(auth, "/v1/chat/completions", tt.token)
+			if tt.ok != (recorder.Code == http.StatusOK) {
+				t.Fatalf("Expected accepted %v, got status %d", tt.ok, recorder.Code)
+			}
+			if tt.ok && keyID != "sub:alice" {
+				t.Errorf("Expected key ID sub:alice, got %s", keyID)
+			}
+		})
+	}
+}
+
+func TestJWTAuthRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	auth, err := NewAuthorizer(config.AuthConfig{Mode: "jwt", JWT: config.JWTConfig{PublicKeyFile: keyFile}})
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+
+	rs256 := func(signed string) []byte {
+		digest := sha256.Sum256([]byte(signed))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return sig
+	}
+	claims := map[string]any{"sub": "batch-job"}
+
+	if recorder, keyID := serveAuth(auth, "/v1/embeddings", signJWT(t, "RS256", claims, rs256)); recorder.Code != http.StatusOK || keyID != "sub:batch-job" {
+		t.Errorf("Expected RS256 token accepted as sub:batch-job, got status %d key %s", recorder.Code, keyID)
+	}
+
+	// Signing with the public key as an HMAC secret must not pass for RS256 keys
+	pemKey, _ := os.ReadFile(keyFile)
+	if recorder, _ := serveAuth(auth, "/v1/embeddings", signJWT(t, "HS256", claims, hs256(string(pemKey)))); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected HS256 token rejected by RS256 key, got status %d", recorder.Code)
+	}
+}
+
+// teamAuthorizer is a custom authorizer identifying clients by a header
+type teamAuthorizer struct {
+	header string
+}
+
+func (a teamAuthorizer) Authorize(r *http.Request) (*Principal, error) {
+	team := r.Header.Get(a.header)
+	if team == "" {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{Subject: team, Priority: 2, Limits: Limits{RequestsPerWindow: 1}}, nil
+}
+
+func TestRegisterAuthorizer(t *testing.T) {
+	if err := RegisterAuthorizer(AuthNone, nil); err == nil {
+		t.Error("Expected the none mode refused")
+	}
+	err := RegisterAuthorizer("test-teams", func(cfg config.AuthConfig) (Authorizer, error) {
+		return teamAuthorizer{header: cfg.Options["header"]}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := NewAuthorizer(config.AuthConfig{Mode: "test-teams", Options: map[string]string{"header": "X-Team"}})
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}, {Port: 8081, Priority: 2}},
+		&MockOpenAIClient{ResponseBody: `{"id":"test-response"}`, ResponseStatus: http.StatusOK})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	requests := NewRequestHandler(qm)
+	requests.Limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), time.Minute, 0, 0, nil)
+	handler := NewAuthHandler(requests, auth)
+
+	send := func(team string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Team", team)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := send(""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a request without a team refused, got %d", recorder.Code)
+	}
+
+	// The principal's priority and identity replace the port's and the key's
+	if recorder := send("search"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the request served, got %d", recorder.Code)
+	}
+	if m := <-recorded; m.Priority != 2 || m.KeyID != "sub:search" {
+		t.Errorf("Expected the request queued at priority 2 as search, got priority %d as %s", m.Priority, m.KeyID)
+	}
+
+	// Its limit replaces the configured one, which is unlimited
+	if recorder := send("search"); recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the principal's limit of one request applied, got %d", recorder.Code)
+	}
+	<-recorded
+}
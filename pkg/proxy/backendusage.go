@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// servedByKey is the context key for where the upstream that answered a request is noted
+type servedByKey struct{}
+
+// contextWithServedBy returns a context in which the tracked client that
+// answers a request notes its upstream, and where to read it back
+func contextWithServedBy(ctx context.Context) (context.Context, *atomic.Pointer[string]) {
+	served := new(atomic.Pointer[string])
+	return context.WithValue(ctx, servedByKey{}, served), served
+}
+
+// BackendUsage counts the requests and tokens each upstream serves, over
+// the proxy's lifetime and over the last minute, against the per-minute
+// quotas its provider enforces. Seeing how close a backend runs to its
+// quota, e.g. an Azure deployment's TPM, warns of 429s before they start.
+type BackendUsage struct {
+	mu       sync.Mutex
+	backends map[string]*backendCounter
+	order    []string // Backend URLs in the order they were tracked
+}
+
+// backendCounter is one upstream's usage
+type backendCounter struct {
+	quota        config.BackendQuota
+	requests     int64
+	inputTokens  int64
+	outputTokens int64
+	seconds      [60]usageSecond // Usage in each second of the last minute, by Unix second mod 60
+}
+
+// usageSecond is the usage in one second
+type usageSecond struct {
+	at       int64 // Unix second the counts are for
+	requests int64
+	tokens   int64
+}
+
+// BackendUsageStatus reports an upstream's usage and what is left of its quota
+type BackendUsageStatus struct {
+	URL                string `json:"url"`
+	Requests           int64  `json:"requests"` // Responses received since the proxy started
+	InputTokens        int64  `json:"input_tokens"`
+	OutputTokens       int64  `json:"output_tokens"`
+	RequestsLastMinute int64  `json:"requests_last_minute"`
+	TokensLastMinute   int64  `json:"tokens_last_minute"`
+	RequestsPerMinute  int64  `json:"requests_per_minute,omitempty"` // The provider's quota, 0 when none is set
+	TokensPerMinute    int64  `json:"tokens_per_minute,omitempty"`
+	// RequestsRemaining and TokensRemaining are what is left of the quotas
+	// over the last minute, left out when there is no quota
+	RequestsRemaining *int64 `json:"requests_remaining,omitempty"`
+	TokensRemaining   *int64 `json:"tokens_remaining,omitempty"`
+}
+
+// NewBackendUsage creates a tracker holding upstreams to quotas, keyed by base URL
+func NewBackendUsage(quotas map[string]config.BackendQuota) *BackendUsage {
+	u := &BackendUsage{backends: make(map[string]*backendCounter)}
+	for url, quota := range quotas {
+		u.counter(url).quota = quota
+	}
+	return u
+}
+
+// counter returns the counter for url, creating it if needed; u.mu must be held
+func (u *BackendUsage) counter(url string) *backendCounter {
+	c, ok := u.backends[url]
+	if !ok {
+		c = &backendCounter{}
+		u.backends[url] = c
+		u.order = append(u.order, url)
+	}
+	return c
+}
+
+// Track wraps the client for the upstream at url, counting the responses
+// it receives and noting itself as the upstream that served them
+func (u *BackendUsage) Track(url string, client OpenAIClient) OpenAIClient {
+	u.mu.Lock()
+	u.counter(url)
+	u.mu.Unlock()
+	return &trackedClient{url: url, client: client, usage: u}
+}
+
+// Record charges tokens of a request served by url to it, returning what
+// is left of its quotas over the last minute; -1 where there is no quota
+func (u *BackendUsage) Record(url string, inputTokens, outputTokens int64) (requestsLeft, tokensLeft int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	c := u.counter(url)
+	c.inputTokens += inputTokens
+	c.outputTokens += outputTokens
+	c.second(now).tokens += inputTokens + outputTokens
+	return c.remaining(now)
+}
+
+// request counts a response received from url
+func (u *BackendUsage) request(url string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	c := u.counter(url)
+	c.requests++
+	c.second(time.Now()).requests++
+}
+
+// Status reports every upstream's usage, in the order they were tracked
+func (u *BackendUsage) Status() []BackendUsageStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	statuses := make([]BackendUsageStatus, 0, len(u.order))
+	for _, url := range u.order {
+		c := u.backends[url]
+		status := BackendUsageStatus{
+			URL:               url,
+			Requests:          c.requests,
+			InputTokens:       c.inputTokens,
+			OutputTokens:      c.outputTokens,
+			RequestsPerMinute: c.quota.RequestsPerMinute,
+			TokensPerMinute:   c.quota.TokensPerMinute,
+		}
+		status.RequestsLastMinute, status.TokensLastMinute = c.lastMinute(now)
+		requestsLeft, tokensLeft := c.remaining(now)
+		if requestsLeft >= 0 {
+			status.RequestsRemaining = &requestsLeft
+		}
+		if tokensLeft >= 0 {
+			status.TokensRemaining = &tokensLeft
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// second returns the counts for the second now falls in, clearing them
+// if they were for a second a minute or more ago
+func (c *backendCounter) second(now time.Time) *usageSecond {
+	at := now.Unix()
+	s := &c.seconds[at%int64(len(c.seconds))]
+	if s.at != at {
+		*s = usageSecond{at: at}
+	}
+	return s
+}
+
+// lastMinute sums the requests and tokens of the last minute
+func (c *backendCounter) lastMinute(now time.Time) (requests, tokens int64) {
+	at := now.Unix()
+	for _, s := range c.seconds {
+		if at-s.at < int64(len(c.seconds)) {
+			requests += s.requests
+			tokens += s.tokens
+		}
+	}
+	return requests, tokens
+}
+
+// remaining returns what is left of the quotas over the last minute, never
+// below 0, and -1 for each quota that isn't set
+func (c *backendCounter) remaining(now time.Time) (requests, tokens int64) {
+	usedRequests, usedTokens := c.lastMinute(now)
+	requests, tokens = -1, -1
+	if c.quota.RequestsPerMinute > 0 {
+		requests = max(c.quota.RequestsPerMinute-usedRequests, 0)
+	}
+	if c.quota.TokensPerMinute > 0 {
+		tokens = max(c.quota.TokensPerMinute-usedTokens, 0)
+	}
+	return requests, tokens
+}
+
+// trackedClient counts the responses of the upstream its client sends to
+type trackedClient struct {
+	url    string
+	client OpenAIClient
+	usage  *BackendUsage
+}
+
+// ForwardRequest forwards the request, counting its response against the
+// upstream and noting the upstream served it, unless another already did
+// (e.g. the other side of a speculative race)
+func (c *trackedClient) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	resp, err := c.client.ForwardRequest(ctx, method, path, body)
+	if err != nil {
+		return resp, err
+	}
+	c.usage.request(c.url)
+	if served, ok := ctx.Value(servedByKey{}).(*atomic.Pointer[string]); ok {
+		served.CompareAndSwap(nil, &c.url)
+	}
+	return resp, nil
+}
+
+// servedBy returns the upstream noted as serving a request, empty if none was
+func servedBy(served *atomic.Pointer[string]) string {
+	if served == nil {
+		return ""
+	}
+	if url := served.Load(); url != nil {
+		return *url
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestBackendUsage(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	usage := NewBackendUsage(map[string]config.BackendQuota{
+		"http://azure": {TokensPerMinute: 1000, RequestsPerMinute: 10},
+	})
+	client := usage.Track("http://azure", &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response","usage":{"prompt_tokens":100,"completion_tokens":50}}`,
+		ResponseStatus: http.StatusOK,
+	})
+	usage.Track("http://vllm", &MockOpenAIClient{})
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.BackendUsage = usage
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	for range 2 {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", recorder.Code)
+		}
+	}
+
+	admin := NewAdminHandler(qm, nil)
+	admin.BackendUsage = usage
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/status", nil))
+	var snapshot StatusSnapshot
+	if err := json.NewDecoder(recorder.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshot.BackendUsage) != 2 {
+		t.Fatalf("Expected both backends, got %+v", snapshot.BackendUsage)
+	}
+	azure := snapshot.BackendUsage[0]
+	if azure.URL != "http://azure" || azure.Requests != 2 || azure.InputTokens != 200 || azure.OutputTokens != 100 {
+		t.Errorf("Expected two requests of 150 tokens on the Azure backend, got %+v", azure)
+	}
+	if azure.TokensLastMinute != 300 || azure.RequestsLastMinute != 2 {
+		t.Errorf("Expected the last minute to hold both requests, got %+v", azure)
+	}
+	if azure.TokensRemaining == nil || *azure.TokensRemaining != 700 || azure.RequestsRemaining == nil || *azure.RequestsRemaining != 8 {
+		t.Errorf("Expected 700 tokens and 8 requests of headroom, got %+v", azure)
+	}
+	if vllm := snapshot.BackendUsage[1]; vllm.Requests != 0 || vllm.TokensRemaining != nil {
+		t.Errorf("Expected the idle backend without a quota, got %+v", vllm)
+	}
+
+	// Usage past the quota leaves no headroom rather than a negative one
+	if requestsLeft, tokensLeft := usage.Record("http://azure", 1000, 0); requestsLeft != 8 || tokensLeft != 0 {
+		t.Errorf("Expected no tokens left, got %d requests and %d tokens", requestsLeft, tokensLeft)
+	}
+	if requestsLeft, tokensLeft := usage.Record("http://vllm", 10, 10); requestsLeft != -1 || tokensLeft != -1 {
+		t.Errorf("Expected no quota on the vLLM backend, got %d requests and %d tokens", requestsLeft, tokensLeft)
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BackpressureHeader tells clients their queue is backing up: "soft" once
+// it is past its soft watermark, "hard" when it turns requests away
+const BackpressureHeader = "X-Proxy-Backpressure"
+
+// SuggestedDelayHeader is how many seconds a client past the soft watermark
+// is advised to wait before sending its next request
+const SuggestedDelayHeader = "X-Proxy-Suggested-Delay"
+
+// backpressure reports whether queue is past its soft watermark, and how
+// long its backlog will take to clear
+func (qm *QueueManager) backpressure(queue *PriorityQueue) (soft bool, delay time.Duration) {
+	waiting := queue.waiting()
+	soft = queue.SoftWatermark > 0 && waiting > queue.SoftWatermark
+	return soft, qm.backlog(queue, waiting)
+}
+
+// full reports whether queue is at its hard watermark, or its capacity,
+// and takes no more requests
+func (q *PriorityQueue) full() bool {
+	limit := q.HardWatermark
+	if limit <= 0 {
+		limit = cap(q.Requests)
+	}
+	return q.waiting() >= limit
+}
+
+// waiting returns how many requests wait in queue, held ones included
+func (q *PriorityQueue) waiting() int {
+	return len(q.Requests) + int(q.held.Load())
+}
+
+// signalBackpressure advises clients of a queue past its soft watermark to
+// slow down, so they can throttle themselves before requests are rejected
+func (h *RequestHandler) signalBackpressure(w http.ResponseWriter, queue *PriorityQueue) {
+	if soft, delay := h.QueueManager.backpressure(queue); soft {
+		w.Header().Set(BackpressureHeader, "soft")
+		w.Header().Set(SuggestedDelayHeader, delaySeconds(delay))
+	}
+}
+
+// delaySeconds formats a delay as whole seconds for a header, at least 1
+func delaySeconds(delay time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(delay.Seconds())), 1))
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func TestBackpressureWatermarks(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, SoftWatermark: 1, HardWatermark: 3}}, &MockOpenAIClient{})
+	qm.WaitWindow = 10 * time.Second
+	queue := qm.Queues[0]
+	h := NewRequestHandler(qm)
+	submit := func() (*httptest.ResponseRecorder, bool) {
+		recorder := httptest.NewRecorder()
+		req := &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/test", nil),
+			ResponseWriter: recorder,
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+		}
+		return recorder, h.submit(recorder, queue, req)
+	}
+
+	// Up to the soft watermark waiting, clients aren't told anything
+	for range 2 {
+		recorder, ok := submit()
+		if !ok || recorder.Header().Get(BackpressureHeader) != "" {
+			t.Fatalf("Expected the request accepted without advice, got %v", recorder.Header())
+		}
+	}
+
+	// Past it they are advised to wait for the backlog, nothing having been picked up in the window
+	recorder, ok := submit()
+	if !ok {
+		t.Fatal("Expected the request accepted past the soft watermark")
+	}
+	if got := recorder.Header().Get(BackpressureHeader); got != "soft" {
+		t.Errorf("Expected soft backpressure, got %q", got)
+	}
+	if got := recorder.Header().Get(SuggestedDelayHeader); got != "10" {
+		t.Errorf("Expected a suggested delay of the whole window, got %q", got)
+	}
+
+	// At the hard watermark they are turned away
+	recorder, ok = submit()
+	if ok || recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected a 429 at the hard watermark, got %d", recorder.Code)
+	}
+	if recorder.Header().Get(BackpressureHeader) != "hard" || recorder.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected hard backpressure with Retry-After, got %v", recorder.Header())
+	}
+	if len(queue.Requests) != 3 {
+		t.Errorf("Expected three requests waiting, got %d", len(queue.Requests))
+	}
+}
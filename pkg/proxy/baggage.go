@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/baggage"
+)
+
+// Identifiers a request can carry upstream, as baggage members and as keys
+// of IdentityPropagation.Headers
+const (
+	IdentityTenant   = "tenant"
+	IdentityKeyID    = "key_id"
+	IdentityPriority = "priority"
+)
+
+// IdentityPropagation passes a request's tenant, client key ID and priority
+// on to the upstream, so inference services behind the proxy can log and
+// meter per tenant with the identifiers the proxy uses
+type IdentityPropagation struct {
+	Baggage   bool              // Add the identifiers to the W3C baggage sent upstream
+	TenantTag string            // X-Proxy-Tags key naming the tenant, used before the JWT subject
+	Headers   map[string]string // Upstream header to send each identifier in, keyed by identifier
+}
+
+// tenant returns who a request is for: the value of its TenantTag tag when
+// it has one, otherwise the subject of its JWT, if any
+func (p *IdentityPropagation) tenant(req *workRequest) string {
+	if p != nil && p.TenantTag != "" && req.Tags[p.TenantTag] != "" {
+		return req.Tags[p.TenantTag]
+	}
+	if subject, ok := strings.CutPrefix(req.KeyID, "sub:"); ok {
+		return subject
+	}
+	return ""
+}
+
+// identity returns the identifiers of a request, leaving out those it lacks
+func (p *IdentityPropagation) identity(req *workRequest) map[string]string {
+	ids := map[string]string{IdentityPriority: strconv.Itoa(req.Priority)}
+	if tenant := p.tenant(req); tenant != "" {
+		ids[IdentityTenant] = tenant
+	}
+	if req.KeyID != "" {
+		ids[IdentityKeyID] = req.KeyID
+	}
+	return ids
+}
+
+// propagate adds a request's identifiers to the baggage in ctx and to the
+// headers sent upstream, returning header unchanged when there is nothing
+// to add. header may be shared between attempts, so it is copied first.
+func (p *IdentityPropagation) propagate(ctx context.Context, req *workRequest, header http.Header) (context.Context, http.Header) {
+	if p == nil {
+		return ctx, header
+	}
+	ids := p.identity(req)
+
+	if p.Baggage {
+		bag := baggage.FromContext(ctx)
+		for key, value := range ids {
+			member, err := baggage.NewMemberRaw(key, value)
+			if err == nil {
+				bag, err = bag.SetMember(member)
+			}
+			if err != nil {
+				log.Printf("Error adding %s to baggage: %v", key, err)
+			}
+		}
+		ctx = baggage.ContextWithBaggage(ctx, bag)
+	}
+
+	if len(p.Headers) > 0 {
+		header = header.Clone()
+		if header == nil {
+			header = make(http.Header, len(p.Headers))
+		}
+		for key, name := range p.Headers {
+			if value, ok := ids[key]; ok {
+				header.Set(name, value)
+			}
+		}
+	}
+	return ctx, header
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// PriorityBoostHeader asks for a request to be promoted to a higher queue
+const PriorityBoostHeader = "X-Priority-Boost"
+
+// boost returns the queue a request should run in. A request asking for a
+// boost from an authorized key is promoted to the boost queue and recorded
+// in the audit log; one from any other key is rejected with a 403.
+func (h *RequestHandler) boost(w http.ResponseWriter, r *http.Request, queue *PriorityQueue) (*PriorityQueue, bool, bool) {
+	requested, _ := strconv.ParseBool(r.Header.Get(PriorityBoostHeader))
+	if !requested {
+		return queue, false, true
+	}
+
+	keyID := clientKeyID(r)
+	if !h.BoostKeys[keyID] {
+		fmt.Printf("AUDIT: priority boost denied (key: %s, path: %s, priority: %d)\n",
+			keyID, r.URL.Path, queue.Priority)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Priority boost not permitted for this key"}`))
+		return nil, false, false
+	}
+
+	target := h.boostQueue()
+	if target == nil || target.Priority >= queue.Priority {
+		// Already at or above the boost level
+		return queue, false, true
+	}
+
+	fmt.Printf("AUDIT: priority boost granted (key: %s, path: %s, priority: %d -> %d)\n",
+		keyID, r.URL.Path, queue.Priority, target.Priority)
+	return target, true, true
+}
+
+// boostQueue returns the queue boosted requests run in
+func (h *RequestHandler) boostQueue() *PriorityQueue {
+	if h.BoostPriority != 0 {
+		return h.QueueManager.FindQueue(h.BoostPriority)
+	}
+
+	priorities := h.QueueManager.priorities()
+	if len(priorities) == 0 {
+		return nil
+	}
+	return h.QueueManager.FindQueue(priorities[0])
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestPriorityBoost(t *testing.T) {
+	// Initialize metrics collector and capture what it records
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+
+	endpoints := []config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2, Preemptive: false},
+	}
+	qm := NewQueueManager(endpoints, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.BoostKeys = map[string]bool{KeyID("oncall-key"): true}
+
+	newBoostRequest := func(key string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8081"
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set(PriorityBoostHeader, "true")
+		return req
+	}
+
+	// An authorized key is promoted to the highest priority queue
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newBoostRequest("oncall-key"))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	m := <-recorded
+	if m.Priority != 1 || !m.Boosted {
+		t.Errorf("Expected boosted request at priority 1, got priority %d (boosted: %v)", m.Priority, m.Boosted)
+	}
+
+	// Any other key is refused rather than silently running at normal priority
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newBoostRequest("other-key"))
+
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status code 403, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// errUpstreamTimeout ends an upstream call that ran past its endpoint's
+// UpstreamTimeout
+var errUpstreamTimeout = errors.New("upstream timeout exceeded")
+
+// arrival returns the queue a request waiting on queue arrived on, whose
+// settings apply to it wherever it has moved. Callers hold mu.
+func (qm *QueueManager) arrival(req *workRequest, queue *PriorityQueue) *PriorityQueue {
+	if req.Priority == 0 || req.Priority == queue.Priority {
+		return queue
+	}
+	for _, q := range qm.Queues {
+		if q.Priority == req.Priority {
+			return q
+		}
+	}
+	return queue
+}
+
+// queueTimedOut reports whether a request waiting on queue has waited past
+// the QueueTimeout of the queue it arrived on. Callers hold mu.
+func (qm *QueueManager) queueTimedOut(req *workRequest, queue *PriorityQueue, now time.Time) bool {
+	timeout := qm.arrival(req, queue).QueueTimeout
+	return timeout > 0 && queuedFor(req, now) >= timeout
+}
+
+// expiredRequest is a request taken off its queue for waiting past its
+// queue timeout, answered once the queues are unlocked
+type expiredRequest struct {
+	req     *workRequest
+	timeout time.Duration
+}
+
+// expire takes a request off queue for waiting past its queue timeout, for
+// answerExpired to answer. Callers hold mu for writing.
+func (qm *QueueManager) expire(req *workRequest, queue *PriorityQueue, now time.Time) expiredRequest {
+	queue.queuedBytes.Add(-req.BodySize)
+	queue.timedOut.Add(1)
+	req.QueueWait = queuedFor(req, now)
+	req.RequeuedAt = time.Time{}
+	qm.tracef("expire request %s on priority %d queue after waiting %s",
+		traceID(req), queue.Priority, req.QueueWait.Round(time.Millisecond))
+	return expiredRequest{req: req, timeout: qm.arrival(req, queue).QueueTimeout}
+}
+
+// answerExpired answers an expired request with a 504, so an interactive
+// client hears straight away rather than once its own deadline passes.
+// Callers don't hold mu, as writing to the client may block.
+func (qm *QueueManager) answerExpired(e expiredRequest) {
+	req := e.req
+	req.ResponseWriter.Header().Set("Content-Type", "application/json")
+	req.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	req.ResponseWriter.Write([]byte(fmt.Sprintf(`{"error":"Request waited in queue longer than %s"}`, e.timeout)))
+
+	o := qm.outcome(req, OutcomeRejected)
+	o.Reason = RejectQueueTimeout
+	o.Status = http.StatusGatewayTimeout
+	qm.finish(o, metrics.RequestMetrics{
+		QueueWaitTime: req.QueueWait,
+		RetryCount:    req.RetryCount,
+		Preempted:     req.Preempted,
+		Tags:          req.Tags,
+		User:          req.User,
+	})
+	close(req.Done)
+}
+
+// withUpstreamTimeout bounds an attempt's upstream call, response included,
+// by the UpstreamTimeout of the queue the request arrived on
+func (qm *QueueManager) withUpstreamTimeout(ctx context.Context, req *workRequest, queue *PriorityQueue) (context.Context, context.CancelFunc) {
+	qm.mu.RLock()
+	timeout := qm.arrival(req, queue).UpstreamTimeout
+	qm.mu.RUnlock()
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, timeout, errUpstreamTimeout)
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/internal/mockopenai"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestQueueTimeout(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, QueueTimeout: 20},
+		{Port: 8081, Priority: 2},
+	}, &MockOpenAIClient{})
+	qm.sortByPriority()
+	sink := &outcomeRecorder{}
+	qm.Outcomes = []OutcomeSink{sink}
+	interactive, batch := qm.FindQueue(1), qm.FindQueue(2)
+
+	newRequest := func(waited time.Duration) (*workRequest, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		return &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+			ResponseWriter: recorder,
+			Done:           make(chan struct{}),
+			StartTime:      time.Now().Add(-waited),
+		}, recorder
+	}
+	fresh, _ := newRequest(time.Second)
+	stale, staleRecorder := newRequest(30 * time.Second)
+	unbounded, _ := newRequest(time.Hour)
+	interactive.Requests <- fresh
+	interactive.Requests <- stale
+	batch.Requests <- unbounded
+
+	qm.escalate()
+
+	// Only the request past its endpoint's budget is answered
+	select {
+	case <-stale.Done:
+	default:
+		t.Fatal("Expected the request waiting 30s answered")
+	}
+	if staleRecorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected a 504, got %d", staleRecorder.Code)
+	}
+	if len(interactive.Requests) != 1 || <-interactive.Requests != fresh {
+		t.Error("Expected the fresh request left queued")
+	}
+	if len(batch.Requests) != 1 {
+		t.Error("Expected requests on an endpoint without a queue timeout left queued")
+	}
+	if interactive.timedOut.Load() != 1 {
+		t.Errorf("Expected 1 timeout counted, got %d", interactive.timedOut.Load())
+	}
+	if len(sink.outcomes) != 1 || sink.outcomes[0].Reason != RejectQueueTimeout || sink.outcomes[0].Status != http.StatusGatewayTimeout {
+		t.Errorf("Expected a queue_timeout rejection, got %+v", sink.outcomes)
+	}
+
+	// A request escalated from the interactive port keeps its budget
+	<-batch.Requests
+	moved, _ := newRequest(30 * time.Second)
+	moved.Priority = 1
+	batch.Requests <- moved
+	qm.escalate()
+	select {
+	case <-moved.Done:
+	default:
+		t.Error("Expected the arrival queue's timeout applied wherever the request waits")
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{Script: []mockopenai.Response{
+		{Delay: time.Second, Body: `{"id":"late"}`},
+		mockopenai.Stream(`{"n":1}`, `{"n":2}`, `{"n":3}`),
+	}}
+	client.Script[1].ChunkDelay = 40 * time.Millisecond
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	queue := qm.Queues[0]
+	queue.UpstreamTimeout = 100 * time.Millisecond
+	sink := &outcomeRecorder{}
+	qm.Outcomes = []OutcomeSink{sink}
+
+	send := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+			ResponseWriter: recorder,
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+		}
+		qm.processRequest(req, queue)
+		<-req.Done
+		return recorder
+	}
+
+	// An upstream that hasn't answered within the budget is a gateway timeout
+	if recorder := send(); recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected a 504 from a slow upstream, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// A stream still going at the budget is ended with an error event
+	recorder := send()
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "upstream_timeout") {
+		t.Errorf("Expected the stream ended with an upstream_timeout event, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), `{"n":3}`) {
+		t.Error("Expected the stream cut off before its last chunk")
+	}
+
+	if len(sink.outcomes) != 2 || sink.outcomes[0].Outcome != OutcomeFailed || sink.outcomes[1].Outcome != OutcomeTruncated {
+		t.Errorf("Expected a failed then a truncated outcome, got %+v", sink.outcomes)
+	}
+}
@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// cacheEntry is a cached upstream response
+type cacheEntry struct {
+	Key      string
+	Status   int
+	Header   http.Header
+	Body     []byte
+	ETag     string
+	StoredAt time.Time
+	Expires  time.Time
+	Hits     int64
+}
+
+// ResponseCache caches upstream responses for read-only GET endpoints
+type ResponseCache struct {
+	ttl           time.Duration
+	paths         []string
+	maxEntries    int
+	mu            sync.Mutex
+	entries       map[string]*cacheEntry
+	fills         map[string]chan struct{} // Keys being fetched upstream, closed once fetched
+	hits          int64
+	misses        int64
+	revalidations int64
+}
+
+// CacheStats summarizes cache effectiveness
+type CacheStats struct {
+	Entries       int     `json:"entries"`
+	Hits          int64   `json:"hits"`
+	Misses        int64   `json:"misses"`
+	Revalidations int64   `json:"revalidations"`
+	HitRatio      float64 `json:"hit_ratio"`
+}
+
+// CacheKeyInfo describes a single cached entry
+type CacheKeyInfo struct {
+	Key      string    `json:"key"`
+	Hits     int64     `json:"hits"`
+	Size     int       `json:"size"`
+	StoredAt time.Time `json:"stored_at"`
+	Expires  time.Time `json:"expires"`
+}
+
+// NewResponseCache creates a response cache from configuration
+func NewResponseCache(cfg config.CacheConfig) *ResponseCache {
+	return &ResponseCache{
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		paths:      cfg.Paths,
+		maxEntries: cfg.MaxEntries,
+		entries:    make(map[string]*cacheEntry),
+		fills:      make(map[string]chan struct{}),
+	}
+}
+
+// Cacheable reports whether a request may be served from the cache
+func (c *ResponseCache) Cacheable(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	// Event streams, like a fine-tuning job's, are sent as they happen
+	if r.URL.Query().Get("stream") == "true" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+
+	for _, pattern := range c.paths {
+		if matchPath(pattern, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey identifies a cached response by path and query
+func cacheKey(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.RawQuery
+}
+
+// lookup returns the entry for a key and whether it is still fresh
+func (c *ResponseCache) lookup(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	fresh := time.Now().Before(entry.Expires)
+	if !fresh {
+		c.misses++
+	}
+	return entry, fresh
+}
+
+// store caches a response, honoring the upstream Cache-Control header
+func (c *ResponseCache) store(key string, status int, header http.Header, body []byte) *cacheEntry {
+	entry := &cacheEntry{
+		Key:      key,
+		Status:   status,
+		Header:   header.Clone(),
+		Body:     body,
+		ETag:     header.Get("ETag"),
+		StoredAt: time.Now(),
+	}
+
+	// Generate a validator so clients can revalidate even if upstream doesn't send one
+	if entry.ETag == "" {
+		sum := sha256.Sum256(body)
+		entry.ETag = `"` + hex.EncodeToString(sum[:8]) + `"`
+		entry.Header.Set("ETag", entry.ETag)
+	}
+
+	ttl, cacheable := c.ttlFor(header)
+	if !cacheable {
+		return entry
+	}
+	entry.Expires = entry.StoredAt.Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = entry
+
+	return entry
+}
+
+// startFill reports whether the caller is the one to fetch key upstream.
+// Otherwise it returns a channel closed once the fetch under way ends.
+func (c *ResponseCache) startFill(key string) (chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if fetching, ok := c.fills[key]; ok {
+		return fetching, false
+	}
+	c.fills[key] = make(chan struct{})
+	return nil, true
+}
+
+// endFill ends the caller's fetch of key, letting requests waiting on it go
+func (c *ResponseCache) endFill(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	close(c.fills[key])
+	delete(c.fills, key)
+}
+
+// refresh extends a cached entry after upstream confirmed it is unchanged
+func (c *ResponseCache) refresh(key string, header http.Header) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	c.revalidations++
+	ttl, _ := c.ttlFor(header)
+	entry.StoredAt = time.Now()
+	entry.Expires = entry.StoredAt.Add(ttl)
+	return entry
+}
+
+// ttlFor derives the cache lifetime from upstream Cache-Control, falling back to the configured TTL
+func (c *ResponseCache) ttlFor(header http.Header) (time.Duration, bool) {
+	ttl := c.ttl
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(strings.ToLower(directive))
+		switch {
+		case directive == "no-store" || directive == "private":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return ttl, true
+}
+
+// evictOldest drops the least recently stored entry; callers must hold c.mu
+func (c *ResponseCache) evictOldest() {
+	var oldest *cacheEntry
+	for _, entry := range c.entries {
+		if oldest == nil || entry.StoredAt.Before(oldest.StoredAt) {
+			oldest = entry
+		}
+	}
+	if oldest != nil {
+		delete(c.entries, oldest.Key)
+	}
+}
+
+// hit records that an entry was served from the cache
+func (c *ResponseCache) hit(entry *cacheEntry) {
+	c.mu.Lock()
+	entry.Hits++
+	c.hits++
+	c.mu.Unlock()
+}
+
+// Stats returns a snapshot of cache counters
+func (c *ResponseCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Entries:       len(c.entries),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Revalidations: c.revalidations,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// HotKeys returns up to limit cached entries ordered by hit count
+func (c *ResponseCache) HotKeys(limit int) []CacheKeyInfo {
+	c.mu.Lock()
+	keys := make([]CacheKeyInfo, 0, len(c.entries))
+	for _, entry := range c.entries {
+		keys = append(keys, CacheKeyInfo{
+			Key:      entry.Key,
+			Hits:     entry.Hits,
+			Size:     len(entry.Body),
+			StoredAt: entry.StoredAt,
+			Expires:  entry.Expires,
+		})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Hits != keys[j].Hits {
+			return keys[i].Hits > keys[j].Hits
+		}
+		return keys[i].Key < keys[j].Key
+	})
+
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// Invalidate removes entries whose key path matches the pattern and returns how many were dropped
+func (c *ResponseCache) Invalidate(pattern string) int {
+	return c.invalidateWhere(func(key string) bool {
+		keyPath, _, _ := strings.Cut(key, "?")
+		return matchPath(pattern, keyPath)
+	})
+}
+
+// InvalidateAncestors removes entries for a path and the paths above it,
+// e.g. a job and the job list after the job was cancelled
+func (c *ResponseCache) InvalidateAncestors(reqPath string) int {
+	return c.invalidateWhere(func(key string) bool {
+		keyPath, _, _ := strings.Cut(key, "?")
+		return keyPath == reqPath || strings.HasPrefix(reqPath, keyPath+"/")
+	})
+}
+
+// InvalidateModel removes entries that refer to a model, either as a path
+// segment or as a "model" query parameter
+func (c *ResponseCache) InvalidateModel(model string) int {
+	return c.invalidateWhere(func(key string) bool {
+		keyPath, rawQuery, _ := strings.Cut(key, "?")
+		for _, segment := range strings.Split(keyPath, "/") {
+			if segment == model {
+				return true
+			}
+		}
+		query, _ := url.ParseQuery(rawQuery)
+		return query.Get("model") == model
+	})
+}
+
+// invalidateWhere removes every entry whose key satisfies match
+func (c *ResponseCache) invalidateWhere(match func(key string) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key := range c.entries {
+		if match(key) {
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// writeCached serves a cached entry, answering conditional requests with 304
+func writeCached(w http.ResponseWriter, r *http.Request, entry *cacheEntry, status string) {
+	copyUpstreamHeaders(w, entry.Header)
+	w.Header().Set("X-Proxy-Cache", status)
+
+	if match := r.Header.Get("If-None-Match"); match != "" && match == entry.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body)
+}
+
+// noCache reports whether the client asked to bypass cached copies
+func noCache(r *http.Request) bool {
+	cc := strings.ToLower(r.Header.Get("Cache-Control"))
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "max-age=0")
+}
+
+// responseBuffer captures a response so it can be inspected before reaching the client
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// newResponseBuffer creates an empty response buffer
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+// Header implements http.ResponseWriter
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeTo replays the buffered response to the client
+func (b *responseBuffer) writeTo(w http.ResponseWriter) {
+	copyUpstreamHeaders(w, b.header)
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
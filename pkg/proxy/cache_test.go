@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// newCachingHandler builds a handler with a running scheduler and a response cache
+func newCachingHandler(t *testing.T, client OpenAIClient, ttl int) *RequestHandler {
+	t.Helper()
+
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, Preemptive: true}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.Cache = NewResponseCache(config.CacheConfig{
+		Enabled:    true,
+		TTL:        ttl,
+		Paths:      []string{"/v1/models", "/v1/models/*"},
+		MaxEntries: 10,
+	})
+	return handler
+}
+
+func TestCacheServesRepeatedGets(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"data":[{"id":"gpt-4"}]}`)),
+			}, nil
+		},
+	}
+	handler := newCachingHandler(t, client, 60)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", recorder.Code)
+		}
+
+		if recorder.Body.String() != `{"data":[{"id":"gpt-4"}]}` {
+			t.Errorf("Unexpected body: %s", recorder.Body.String())
+		}
+
+		expected := "HIT"
+		if i == 0 {
+			expected = "MISS"
+		}
+		if recorder.Header().Get("X-Proxy-Cache") != expected {
+			t.Errorf("Request %d: expected X-Proxy-Cache %s, got %s", i, expected, recorder.Header().Get("X-Proxy-Cache"))
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("Expected 1 upstream call, got %d", calls)
+	}
+}
+
+func TestCacheConditionalRequests(t *testing.T) {
+	client := &MockOpenAIClient{
+		ResponseBody:    `{"data":[]}`,
+		ResponseStatus:  200,
+		ResponseHeaders: map[string]string{"ETag": `"v1"`},
+	}
+	handler := newCachingHandler(t, client, 60)
+
+	// Prime the cache
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	req.Host = "localhost:8080"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// A client that already has the current version gets a 304
+	req = httptest.NewRequest("GET", "/v1/models", nil)
+	req.Host = "localhost:8080"
+	req.Header.Set("If-None-Match", `"v1"`)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("Expected status code 304, got %d", recorder.Code)
+	}
+
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Expected empty body for 304, got %s", recorder.Body.String())
+	}
+}
+
+func TestCacheRevalidatesStaleEntries(t *testing.T) {
+	var mu sync.Mutex
+	var validators []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Capture the validator the proxy sends upstream
+		mu.Lock()
+		validators = append(validators, r.Header.Get("If-None-Match"))
+		mu.Unlock()
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"data":[{"id":"gpt-4"}]}`))
+	}))
+	defer server.Close()
+
+	// A zero TTL makes every entry stale immediately
+	handler := newCachingHandler(t, openai.NewClient(server.URL, "test-key"), 0)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", recorder.Code)
+		}
+
+		if recorder.Body.String() != `{"data":[{"id":"gpt-4"}]}` {
+			t.Errorf("Unexpected body: %s", recorder.Body.String())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(validators) != 2 || validators[0] != "" || validators[1] != `"v1"` {
+		t.Errorf("Expected second fetch to revalidate with the cached ETag, got %v", validators)
+	}
+}
+
+func TestCacheHonorsNoStore(t *testing.T) {
+	cache := NewResponseCache(config.CacheConfig{TTL: 60, Paths: []string{"/v1/models"}})
+
+	cache.store("/v1/models", 200, http.Header{"Cache-Control": {"no-store"}}, []byte(`{}`))
+	if entry, _ := cache.lookup("/v1/models"); entry != nil {
+		t.Error("Expected no-store response not to be cached")
+	}
+
+	cache.store("/v1/models", 200, http.Header{"Cache-Control": {"max-age=1"}}, []byte(`{}`))
+	entry, fresh := cache.lookup("/v1/models")
+	if entry == nil || !fresh {
+		t.Fatal("Expected max-age response to be cached")
+	}
+
+	if entry.Expires.Sub(entry.StoredAt) != time.Second {
+		t.Errorf("Expected max-age to override the TTL, got %v", entry.Expires.Sub(entry.StoredAt))
+	}
+}
+
+func TestCacheableRequests(t *testing.T) {
+	cache := NewResponseCache(config.CacheConfig{TTL: 60, Paths: []string{"/v1/models", "/v1/models/*"}})
+
+	if !cache.Cacheable(httptest.NewRequest("GET", "/v1/models/gpt-4", nil)) {
+		t.Error("Expected GET /v1/models/gpt-4 to be cacheable")
+	}
+
+	if cache.Cacheable(httptest.NewRequest("POST", "/v1/models", nil)) {
+		t.Error("Expected POST requests not to be cacheable")
+	}
+
+	if cache.Cacheable(httptest.NewRequest("GET", "/v1/files", nil)) {
+		t.Error("Expected unconfigured paths not to be cacheable")
+	}
+
+	if cache.Cacheable(httptest.NewRequest("GET", "/v1/models/gpt-4?stream=true", nil)) {
+		t.Error("Expected event streams not to be cacheable")
+	}
+}
+
+func TestStatusCache(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	release := make(chan struct{})
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			mu.Lock()
+			calls[method+" "+path]++
+			mu.Unlock()
+			if method == "GET" {
+				<-release
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"id":"ftjob-1","status":"running"}`)),
+			}, nil
+		},
+	}
+	handler := newCachingHandler(t, client, 60)
+	handler.Cache = nil
+	handler.StatusCache = NewResponseCache(config.CacheConfig{
+		TTL:        60,
+		Paths:      []string{"/v1/fine_tuning/jobs", "/v1/fine_tuning/jobs/**"},
+		MaxEntries: 10,
+	})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Polls arriving while the job is being fetched share that fetch
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if recorder := do("GET", "/v1/fine_tuning/jobs/ftjob-1"); recorder.Code != http.StatusOK {
+				t.Errorf("Expected status code 200, got %d", recorder.Code)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls["GET /v1/fine_tuning/jobs/ftjob-1"] != 1 {
+		t.Errorf("Expected one upstream fetch for concurrent polls, got %d", calls["GET /v1/fine_tuning/jobs/ftjob-1"])
+	}
+
+	// Cancelling the job drops its cached status
+	do("POST", "/v1/fine_tuning/jobs/ftjob-1/cancel")
+	if recorder := do("GET", "/v1/fine_tuning/jobs/ftjob-1"); recorder.Header().Get("X-Proxy-Cache") != "MISS" {
+		t.Errorf("Expected the job fetched again after it was cancelled, got %s", recorder.Header().Get("X-Proxy-Cache"))
+	}
+}
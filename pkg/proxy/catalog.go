@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// ModelCatalogs limits the models each client key sees in /v1/models and
+// may invoke, e.g. to give one team early access to a new model. A key
+// without a catalog of its own gets the default catalog, and is unrestricted
+// when there is none. Catalogs list model names and "prefix*" patterns; "*"
+// allows every model.
+type ModelCatalogs struct {
+	mu       sync.RWMutex
+	def      []string
+	catalogs map[string][]string // By key ID
+}
+
+// CatalogStatus reports the catalogs in effect
+type CatalogStatus struct {
+	Default []string            `json:"default"`
+	Keys    map[string][]string `json:"keys"` // By key ID
+}
+
+// NewModelCatalogs creates catalogs with def for keys without their own and
+// the catalogs of keys, keyed by key ID
+func NewModelCatalogs(def []string, keys map[string][]string) *ModelCatalogs {
+	catalogs := make(map[string][]string, len(keys))
+	for keyID, models := range keys {
+		catalogs[keyID] = slices.Clone(models)
+	}
+	return &ModelCatalogs{def: slices.Clone(def), catalogs: catalogs}
+}
+
+// catalog returns a key's catalog, false when it may use any model
+func (c *ModelCatalogs) catalog(keyID string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if models, ok := c.catalogs[keyID]; ok {
+		return models, true
+	}
+	return c.def, len(c.def) > 0
+}
+
+// Allowed reports whether a key may see and invoke model
+func (c *ModelCatalogs) Allowed(keyID, model string) bool {
+	models, restricted := c.catalog(keyID)
+	if !restricted {
+		return true
+	}
+	return matchModel(models, model)
+}
+
+// Set gives a key its own catalog
+func (c *ModelCatalogs) Set(keyID string, models []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catalogs[keyID] = slices.Clone(models)
+}
+
+// SetDefault replaces the catalog of keys without their own; empty lifts it
+func (c *ModelCatalogs) SetDefault(models []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.def = slices.Clone(models)
+}
+
+// Remove returns a key to the default catalog, reporting whether it had its own
+func (c *ModelCatalogs) Remove(keyID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.catalogs[keyID]
+	delete(c.catalogs, keyID)
+	return ok
+}
+
+// Status reports the default catalog and every key's own
+func (c *ModelCatalogs) Status() CatalogStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return CatalogStatus{Default: slices.Clone(c.def), Keys: maps.Clone(c.catalogs)}
+}
+
+// writeModelNotFound refuses a model outside the client's catalog as OpenAI
+// does a model the key has no access to, so the model's existence isn't given away
+func writeModelNotFound(w http.ResponseWriter, model string) {
+	message := fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", model)
+	writeAdmissionError(w, http.StatusNotFound, message, "invalid_request_error", "model_not_found")
+}
+
+// filterModelList writes a buffered /v1/models response to w with the
+// models outside keyID's catalog left out. Its ETag is that of the list
+// the key sees, so a validator of the full list never reaches the client.
+// Responses that aren't a model list are written as they are.
+func (c *ModelCatalogs) filterModelList(w http.ResponseWriter, buf *responseBuffer, keyID string) {
+	var list map[string]json.RawMessage
+	var data []json.RawMessage
+	if buf.status != http.StatusOK || json.Unmarshal(buf.body.Bytes(), &list) != nil || json.Unmarshal(list["data"], &data) != nil {
+		buf.writeTo(w)
+		return
+	}
+
+	kept := make([]json.RawMessage, 0, len(data))
+	for _, item := range data {
+		var model struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(item, &model) == nil && c.Allowed(keyID, model.ID) {
+			kept = append(kept, item)
+		}
+	}
+	list["data"], _ = json.Marshal(kept)
+	body, err := json.Marshal(list)
+	if err != nil {
+		buf.writeTo(w)
+		return
+	}
+	buf.body.Reset()
+	buf.body.Write(body)
+	buf.header.Del("Content-Length")
+	if buf.header.Get("ETag") != "" {
+		sum := sha256.Sum256(body)
+		buf.header.Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+	}
+	buf.writeTo(w)
+}
+
+// catalogRequest is the body of POST /admin/catalogs. It sets the default
+// catalog, or the catalog of the key given raw or by its ID.
+type catalogRequest struct {
+	Key     string   `json:"key,omitempty"`
+	KeyID   string   `json:"key_id,omitempty"`
+	Default bool     `json:"default,omitempty"`
+	Models  []string `json:"models"`
+}
+
+// catalogStatus reports the catalogs in effect
+func (h *AdminHandler) catalogStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Catalogs == nil {
+		writeError(w, http.StatusNotFound, "Model catalogs are not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Catalogs.Status())
+}
+
+// catalogSet replaces the default catalog or a key's own
+func (h *AdminHandler) catalogSet(w http.ResponseWriter, r *http.Request) {
+	if h.Catalogs == nil {
+		writeError(w, http.StatusNotFound, "Model catalogs are not enabled")
+		return
+	}
+
+	var req catalogRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	keyID := req.KeyID
+	if req.Key != "" {
+		keyID = KeyID(req.Key)
+	}
+	switch {
+	case req.Default && keyID != "":
+		writeError(w, http.StatusBadRequest, "Set either the default catalog or a key's, not both")
+		return
+	case req.Default:
+		h.Catalogs.SetDefault(req.Models)
+	case keyID == "":
+		writeError(w, http.StatusBadRequest, "A key, key_id or default is required")
+		return
+	default:
+		h.Catalogs.Set(keyID, req.Models)
+	}
+	writeJSON(w, http.StatusOK, h.Catalogs.Status())
+}
+
+// catalogRemove returns a key to the default catalog
+func (h *AdminHandler) catalogRemove(w http.ResponseWriter, r *http.Request) {
+	if h.Catalogs == nil {
+		writeError(w, http.StatusNotFound, "Model catalogs are not enabled")
+		return
+	}
+	if !h.Catalogs.Remove(r.PathValue("key_id")) {
+		writeError(w, http.StatusNotFound, "Key has no catalog of its own")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestModelCatalogs(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		respBody := `{"id":"chatcmpl-1"}`
+		if path == "/v1/models" {
+			respBody = `{"object":"list","data":[{"id":"gpt-4o","object":"model"},{"id":"gpt-4o-mini","object":"model"},{"id":"gpt-5-preview","object":"model"}]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}, "Etag": {`"full-list"`}},
+			Body:       io.NopCloser(strings.NewReader(respBody)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+	handler.Catalogs = NewModelCatalogs([]string{"gpt-4o*"}, map[string][]string{KeyID("sk-early"): {"*"}})
+	admin := NewAdminHandler(qm, nil)
+	admin.Catalogs = handler.Catalogs
+
+	send := func(method, path, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Host = "localhost:8080"
+		r.Header.Set("Authorization", "Bearer "+key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+	listed := func(key string) []string {
+		recorder := send("GET", "/v1/models", key, "")
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+			t.Fatalf("Expected a model list, got %d %s", recorder.Code, recorder.Body.String())
+		}
+		var ids []string
+		for _, model := range list.Data {
+			ids = append(ids, model.ID)
+		}
+		return ids
+	}
+	chat := func(key, model string) int {
+		return send("POST", "/v1/chat/completions", key, `{"model":"`+model+`","messages":[]}`).Code
+	}
+
+	// Keys on the default catalog don't see or reach the preview model
+	if ids := listed("sk-team"); strings.Join(ids, ",") != "gpt-4o,gpt-4o-mini" {
+		t.Errorf("Expected the default catalog listed, got %v", ids)
+	}
+	if etag := send("GET", "/v1/models", "sk-team", "").Header().Get("ETag"); etag == "" || etag == `"full-list"` {
+		t.Errorf("Expected an ETag of the filtered list, got %q", etag)
+	}
+	recorder := send("POST", "/v1/chat/completions", "sk-team", `{"model":"gpt-5-preview","messages":[]}`)
+	if recorder.Code != http.StatusNotFound || !strings.Contains(recorder.Body.String(), "model_not_found") {
+		t.Errorf("Expected a 404 model_not_found for a model outside the catalog, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send("GET", "/v1/models/gpt-5-preview", "sk-team", ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected a lookup of a model outside the catalog to 404, got %d", recorder.Code)
+	}
+	if code := chat("sk-team", "gpt-4o-mini"); code != http.StatusOK {
+		t.Errorf("Expected a model in the catalog invoked, got %d", code)
+	}
+
+	// The early access key sees everything
+	if ids := listed("sk-early"); len(ids) != 3 {
+		t.Errorf("Expected every model listed for the early access key, got %v", ids)
+	}
+	if code := chat("sk-early", "gpt-5-preview"); code != http.StatusOK {
+		t.Errorf("Expected the preview model invoked by the early access key, got %d", code)
+	}
+
+	// Granting a key its own catalog through the admin API takes effect at once
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/catalogs", strings.NewReader(`{"key":"sk-team","models":["gpt-5-preview"]}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the catalog set, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if ids := listed("sk-team"); strings.Join(ids, ",") != "gpt-5-preview" {
+		t.Errorf("Expected the key's own catalog listed, got %v", ids)
+	}
+	if code := chat("sk-team", "gpt-4o"); code != http.StatusNotFound {
+		t.Errorf("Expected models outside the key's own catalog refused, got %d", code)
+	}
+
+	// Removing it returns the key to the default
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/admin/catalogs/"+KeyID("sk-team"), nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected the catalog removed, got %d", recorder.Code)
+	}
+	if code := chat("sk-team", "gpt-5-preview"); code != http.StatusNotFound {
+		t.Errorf("Expected the key back on the default catalog, got %d", code)
+	}
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/catalogs", strings.NewReader(`{"models":["*"]}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a catalog without a key refused, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// isJSONContent reports whether a Content-Type names a JSON body. Most
+// OpenAI clients send JSON, so a missing type counts as JSON too.
+func isJSONContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// upstreamHeaders returns the extra headers to send upstream with a request.
+// Bodies that aren't JSON (multipart audio uploads, files, ...) keep the
+// client's Content-Type, since the upstream client otherwise sends JSON.
+// The client's OpenAI-Beta header is passed on, since the assistants API
+// refuses requests without the version it names.
+func upstreamHeaders(req *workRequest) http.Header {
+	contentType := req.Request.Header.Get("Content-Type")
+	beta := req.Request.Header.Values("OpenAI-Beta")
+	if isJSONContent(contentType) && len(beta) == 0 {
+		return req.UpstreamHeaders
+	}
+
+	header := make(http.Header, len(req.UpstreamHeaders)+2)
+	for k, v := range req.UpstreamHeaders {
+		header[k] = v
+	}
+	if !isJSONContent(contentType) {
+		header.Set("Content-Type", contentType)
+	}
+	for _, v := range beta {
+		header.Add("OpenAI-Beta", v)
+	}
+	return header
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestIsJSONContent(t *testing.T) {
+	tests := map[string]bool{
+		"":                                 true,
+		"application/json":                 true,
+		"application/json; charset=utf-8":  true,
+		"application/merge-patch+json":     true,
+		"multipart/form-data; boundary=xx": false,
+		"audio/mpeg":                       false,
+		"application/octet-stream":         false,
+		"not a media type;;":               false,
+	}
+	for contentType, want := range tests {
+		if got := isJSONContent(contentType); got != want {
+			t.Errorf("isJSONContent(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestBinaryPassthrough(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// A multipart audio upload with bytes that are not valid JSON or UTF-8
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	form.WriteField("model", "whisper-1")
+	part, _ := form.CreateFormFile("file", "speech.mp3")
+	part.Write([]byte{0xff, 0xfb, 0x90, 0x00, 0x7b, 0x22})
+	form.Close()
+	sent := upload.Bytes()
+
+	audio := []byte{0x49, 0x44, 0x33, 0x04, 0x00, 0xff}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != form.FormDataContentType() {
+			t.Errorf("Expected the client's Content-Type upstream, got %q", r.Header.Get("Content-Type"))
+		}
+		received, _ := io.ReadAll(r.Body)
+		if !bytes.Equal(received, sent) {
+			t.Errorf("Expected the upload to reach upstream untouched")
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(audio)
+	}))
+	defer server.Close()
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, openai.NewClient(server.URL, "test-key"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.InjectUser = true
+
+	req := httptest.NewRequest("POST", "/v1/audio/transcriptions", bytes.NewReader(sent))
+	req.Host = "localhost:8080"
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+	if !bytes.Equal(recorder.Body.Bytes(), audio) {
+		t.Errorf("Expected the binary response to reach the client untouched, got %v", recorder.Body.Bytes())
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamRequestIDHeader returns the upstream's request ID to clients
+// whose own X-Request-Id is taken by the proxy's
+const UpstreamRequestIDHeader = "X-Upstream-Request-Id"
+
+// upstreamCorrelation returns the ID the upstream gave a request and how
+// long it reports spending on it, so proxy logs can be matched to the
+// provider's (e.g. for OpenAI support tickets)
+func upstreamCorrelation(header http.Header) (string, time.Duration) {
+	var processing time.Duration
+	if ms, err := strconv.ParseFloat(header.Get("Openai-Processing-Ms"), 64); err == nil {
+		processing = time.Duration(ms * float64(time.Millisecond))
+	}
+	return header.Get("X-Request-Id"), processing
+}
+
+// hopHeaders describe a single connection rather than the response, so they
+// are never relayed; the client connection gets its own framing
+var hopHeaders = map[string]bool{
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Connection":    true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true, // Announced again by announceTrailers
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// copyUpstreamHeaders copies an upstream response's headers to the client,
+// leaving out hop-by-hop headers and any the upstream's Connection header
+// names. When the proxy has already given the request an ID, the upstream's
+// is passed on as X-Upstream-Request-Id instead of being added alongside it.
+func copyUpstreamHeaders(w http.ResponseWriter, header http.Header) {
+	connection := make(map[string]bool)
+	for _, v := range header.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			connection[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+
+	for k, v := range header {
+		if hopHeaders[k] || connection[k] {
+			continue
+		}
+		if k == RequestIDHeader && w.Header().Get(RequestIDHeader) != "" {
+			k = UpstreamRequestIDHeader
+		}
+		for _, vv := range v {
+			w.Header().Add(k, vv)
+		}
+	}
+}
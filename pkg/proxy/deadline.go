@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RequestTimeoutHeader lets clients that can't time out themselves (e.g.
+// behind a load balancer) say how long, in milliseconds, they will wait
+const RequestTimeoutHeader = "X-Request-Timeout-Ms"
+
+// errBadTimeout is returned for an X-Request-Timeout-Ms that isn't a positive number
+var errBadTimeout = errors.New("invalid " + RequestTimeoutHeader)
+
+// withRequestTimeout applies the client's X-Request-Timeout-Ms to the
+// request's context, so queueing and the upstream call both stop at it
+func withRequestTimeout(r *http.Request) (*http.Request, context.CancelFunc, error) {
+	v := r.Header.Get(RequestTimeoutHeader)
+	if v == "" {
+		return r, func() {}, nil
+	}
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return r, func() {}, errBadTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(ms)*time.Millisecond)
+	return r.WithContext(ctx), cancel, nil
+}
+
+// deadlineWriter sits between the request pipeline and the client, so the
+// handler can answer a request whose deadline passes before its response
+// has started without racing the pipeline, which may still pick it up later.
+// Until the response starts, headers are set on a copy of the client's.
+type deadlineWriter struct {
+	http.ResponseWriter
+	mu      sync.Mutex
+	header  http.Header
+	started bool // The pipeline has started the response
+	expired bool // The handler has answered, anything written later is dropped
+}
+
+// newDeadlineWriter wraps the client's response writer
+func newDeadlineWriter(w http.ResponseWriter) *deadlineWriter {
+	return &deadlineWriter{ResponseWriter: w, header: w.Header().Clone()}
+}
+
+// Header implements http.ResponseWriter
+func (w *deadlineWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *deadlineWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeHeader(status)
+}
+
+// writeHeader starts the response with the pipeline's headers; callers hold mu
+func (w *deadlineWriter) writeHeader(status int) {
+	if w.expired || w.started {
+		return
+	}
+	w.started = true
+	header := w.ResponseWriter.Header()
+	for k := range header {
+		if _, ok := w.header[k]; !ok {
+			delete(header, k)
+		}
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.writeHeader(http.StatusOK)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher
+func (w *deadlineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.expired {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// expire takes the response away from the pipeline unless it has already
+// started, answering with a 504 if err is a passed deadline. It reports
+// whether the response was taken.
+func (w *deadlineWriter) expire(err error) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		return false
+	}
+	w.expired = true
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeDeadlineExceeded(w.ResponseWriter)
+	}
+	return true
+}
+
+// waitDone waits for the pipeline to finish a request, or for its client to
+// stop waiting while the response hasn't started yet
+func waitDone(ctx context.Context, w *deadlineWriter, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	if !w.expire(ctx.Err()) {
+		// The response is underway, the pipeline notices the cancellation itself
+		<-done
+	}
+}
+
+// writeDeadlineExceeded answers a request whose deadline passed before its response started
+func writeDeadlineExceeded(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write([]byte(`{"error":"Request deadline exceeded"}`))
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	var calls atomic.Int32
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			calls.Add(1)
+			return &http.Response{StatusCode: 200, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		},
+	}
+
+	// No scheduler runs yet, so requests wait in the queue
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	handler := NewRequestHandler(qm)
+
+	newRequest := func(timeout string) *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(RequestTimeoutHeader, timeout)
+		return req
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest("soon"))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid timeout, got %d", recorder.Code)
+	}
+
+	// The handler answers once the deadline passes, without waiting for the queue
+	recorder = httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(recorder, newRequest("50"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the handler to give up at the deadline, took %v", elapsed)
+	}
+	if recorder.Code != http.StatusGatewayTimeout || !strings.Contains(recorder.Body.String(), "deadline exceeded") {
+		t.Errorf("Expected 504 at the deadline, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Once picked up, the expired request is dropped rather than sent upstream
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	time.Sleep(100 * time.Millisecond)
+	if calls.Load() != 0 {
+		t.Errorf("Expected no upstream call for an expired request, got %d", calls.Load())
+	}
+
+	// Requests that finish in time are unaffected
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest("5000"))
+	if recorder.Code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("Expected 200 within the deadline, got %d after %d calls", recorder.Code, calls.Load())
+	}
+}
+
+func TestProcessRequestClientCancel(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	upstreamCancelled := make(chan struct{})
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			<-ctx.Done()
+			close(upstreamCancelled)
+			return nil, ctx.Err()
+		},
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	queue := qm.FindQueue(1)
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	httpReq, _ := http.NewRequestWithContext(clientCtx, "POST", "http://localhost:8080/v1/chat/completions", strings.NewReader(`{}`))
+	recorder := httptest.NewRecorder()
+	req := &workRequest{
+		Request:        httpReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+	}
+
+	go qm.processRequest(req, queue)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the client's cancellation to reach the upstream call")
+	}
+	select {
+	case <-req.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to finish after its client went away")
+	}
+
+	// Nobody is listening, so nothing is written; in particular not a 502
+	if recorder.Body.Len() != 0 {
+		t.Errorf("Expected no response for a cancelled client, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/archive"
+)
+
+// redactedHeaders carry credentials, so debug captures never keep their values
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Api-Key":             true,
+	"X-Api-Key":           true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// DebugCaptures records the full exchanges of one client key, or of
+// requests whose ID matches a pattern, to a file of their own for a limited
+// time. Support can look into a customer's problem this way without turning
+// on archival for all traffic.
+type DebugCaptures struct {
+	dir        string
+	maxBytes   int
+	maxMinutes int
+	mu         sync.Mutex
+	captures   map[string]*debugCapture
+	nextID     int
+}
+
+// DebugCapture describes a capture in progress
+type DebugCapture struct {
+	ID        string    `json:"id"`
+	KeyID     string    `json:"key_id,omitempty"`     // Client key whose requests are captured
+	RequestID string    `json:"request_id,omitempty"` // Pattern request IDs are matched against, e.g. support-123-*
+	File      string    `json:"file"`
+	Until     time.Time `json:"until"`
+	Captured  int64     `json:"captured"` // Requests matched so far
+}
+
+// debugCapture is a capture with the archiver writing its file
+type debugCapture struct {
+	DebugCapture
+	archiver *archive.Archiver
+	timer    *time.Timer
+	captured atomic.Int64
+}
+
+// NewDebugCaptures creates captures writing files to dir, keeping up to
+// maxBytes of each request and response, and running for at most
+// maxMinutes each
+func NewDebugCaptures(dir string, maxBytes, maxMinutes int) *DebugCaptures {
+	return &DebugCaptures{
+		dir:        dir,
+		maxBytes:   maxBytes,
+		maxMinutes: maxMinutes,
+		captures:   make(map[string]*debugCapture),
+	}
+}
+
+// Start captures the exchanges of keyID, or of requests with an ID
+// matching the requestID pattern, for the next minutes
+func (d *DebugCaptures) Start(keyID, requestID string, minutes int) (DebugCapture, error) {
+	if keyID == "" && requestID == "" {
+		return DebugCapture{}, errors.New("a key, key_id or request_id is required")
+	}
+	if _, err := path.Match(requestID, ""); err != nil {
+		return DebugCapture{}, fmt.Errorf("invalid request_id pattern: %w", err)
+	}
+	if minutes <= 0 || minutes > d.maxMinutes {
+		return DebugCapture{}, fmt.Errorf("minutes must be between 1 and %d", d.maxMinutes)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	now := time.Now()
+	id := fmt.Sprintf("%s-%d", now.UTC().Format("20060102T150405"), d.nextID)
+	file := filepath.Join(d.dir, "capture-"+id+".jsonl")
+	sink, err := archive.NewFileSink(file)
+	if err != nil {
+		return DebugCapture{}, err
+	}
+
+	c := &debugCapture{
+		DebugCapture: DebugCapture{
+			ID:        id,
+			KeyID:     keyID,
+			RequestID: requestID,
+			File:      file,
+			Until:     now.Add(time.Duration(minutes) * time.Minute),
+		},
+		archiver: archive.NewArchiver(sink, d.maxBytes, 256),
+	}
+	c.timer = time.AfterFunc(time.Until(c.Until), func() { d.Stop(id) })
+	d.captures[id] = c
+	log.Printf("Started debug capture %s to %s until %s", id, file, c.Until.Format(time.RFC3339))
+	return c.status(), nil
+}
+
+// Stop ends a capture, writing out the exchanges it has finished, and
+// reports whether it was running
+func (d *DebugCaptures) Stop(id string) bool {
+	d.mu.Lock()
+	c, ok := d.captures[id]
+	delete(d.captures, id)
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	c.timer.Stop()
+	if err := c.archiver.Close(); err != nil {
+		log.Printf("Error closing debug capture %s: %v", id, err)
+	}
+	log.Printf("Stopped debug capture %s after %d requests", id, c.captured.Load())
+	return true
+}
+
+// Close stops every capture
+func (d *DebugCaptures) Close() {
+	for _, c := range d.List() {
+		d.Stop(c.ID)
+	}
+}
+
+// List returns the captures in progress, those ending soonest first
+func (d *DebugCaptures) List() []DebugCapture {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	list := make([]DebugCapture, 0, len(d.captures))
+	for _, c := range d.captures {
+		list = append(list, c.status())
+	}
+	slices.SortFunc(list, func(a, b DebugCapture) int { return a.Until.Compare(b.Until) })
+	return list
+}
+
+// status reports the capture
+func (c *debugCapture) status() DebugCapture {
+	status := c.DebugCapture
+	status.Captured = c.captured.Load()
+	return status
+}
+
+// match returns the archivers of the captures a request is for
+func (d *DebugCaptures) match(req *workRequest) []*archive.Archiver {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var archivers []*archive.Archiver
+	requestID := req.requestID()
+	for _, c := range d.captures {
+		matched := c.KeyID != "" && c.KeyID == req.KeyID
+		if c.RequestID != "" && requestID != "" {
+			ok, _ := path.Match(c.RequestID, requestID)
+			matched = matched || ok
+		}
+		if matched {
+			c.captured.Add(1)
+			archivers = append(archivers, c.archiver)
+		}
+	}
+	return archivers
+}
+
+// redactHeaders copies header, replacing the values of credentials
+func redactHeaders(header http.Header) map[string][]string {
+	redacted := make(map[string][]string, len(header))
+	for k, v := range header {
+		if redactedHeaders[k] {
+			v = []string{"[redacted]"}
+		}
+		redacted[k] = slices.Clone(v)
+	}
+	return redacted
+}
+
+// debugCaptures lists the debug captures in progress
+func (h *AdminHandler) debugCaptures(w http.ResponseWriter, r *http.Request) {
+	if h.Debug == nil {
+		writeError(w, http.StatusNotFound, "Debug captures are not enabled")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.Debug.List())
+}
+
+// debugCaptureStart starts capturing a client key's or request IDs' exchanges
+func (h *AdminHandler) debugCaptureStart(w http.ResponseWriter, r *http.Request) {
+	if h.Debug == nil {
+		writeError(w, http.StatusNotFound, "Debug captures are not enabled")
+		return
+	}
+
+	var req struct {
+		Key       string `json:"key"`    // Client API key, identified by its key ID
+		KeyID     string `json:"key_id"` // Or the key ID itself, as logged
+		RequestID string `json:"request_id"`
+		Minutes   int    `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	keyID := strings.TrimSpace(req.KeyID)
+	if req.Key != "" {
+		keyID = KeyID(req.Key)
+	}
+
+	capture, err := h.Debug.Start(keyID, req.RequestID, req.Minutes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, capture)
+}
+
+// debugCaptureStop ends a debug capture
+func (h *AdminHandler) debugCaptureStop(w http.ResponseWriter, r *http.Request) {
+	if h.Debug == nil {
+		writeError(w, http.StatusNotFound, "Debug captures are not enabled")
+		return
+	}
+
+	if !h.Debug.Stop(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "No such debug capture")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
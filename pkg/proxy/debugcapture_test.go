@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/archive"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestDebugCaptures(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{ResponseBody: `{"id":"test-response"}`, ResponseStatus: http.StatusOK}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Debug = NewDebugCaptures(t.TempDir(), 1<<20, 60)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	admin := NewAdminHandler(qm, nil)
+	admin.Debug = qm.Debug
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		admin.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return recorder
+	}
+
+	for body, want := range map[string]int{
+		`{"key": "client-a", "minutes": 0}`:         http.StatusBadRequest,
+		`{"key": "client-a", "minutes": 61}`:        http.StatusBadRequest,
+		`{"minutes": 5}`:                            http.StatusBadRequest,
+		`{"request_id": "support-[", "minutes": 5}`: http.StatusBadRequest,
+	} {
+		if recorder := call("POST", "/admin/debug/captures", body); recorder.Code != want {
+			t.Errorf("%s: expected %d, got %d %s", body, want, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	var byKey, byRequest DebugCapture
+	for body, capture := range map[string]*DebugCapture{
+		`{"key": "client-a", "minutes": 5}`:           &byKey,
+		`{"request_id": "support-7-*", "minutes": 5}`: &byRequest,
+	} {
+		recorder := call("POST", "/admin/debug/captures", body)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("Expected the capture started, got %d %s", recorder.Code, recorder.Body.String())
+		}
+		json.NewDecoder(recorder.Body).Decode(capture)
+	}
+
+	send := func(key, requestID string) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+key)
+		if requestID != "" {
+			req.Header.Set(RequestIDHeader, requestID)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send("client-a", "")
+	send("client-b", "")
+	send("client-b", "support-7-1")
+
+	var list []DebugCapture
+	json.NewDecoder(call("GET", "/admin/debug/captures", "").Body).Decode(&list)
+	if len(list) != 2 || list[0].Captured != 1 || list[1].Captured != 1 {
+		t.Errorf("Expected two captures of one request each, got %+v", list)
+	}
+
+	// Stopping a capture writes out its file
+	if recorder := call("DELETE", "/admin/debug/captures/"+byKey.ID, ""); recorder.Code != http.StatusNoContent {
+		t.Fatalf("Expected the capture stopped, got %d", recorder.Code)
+	}
+	if recorder := call("DELETE", "/admin/debug/captures/"+byKey.ID, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected a stopped capture to be gone, got %d", recorder.Code)
+	}
+	qm.Debug.Close()
+
+	read := func(path string) []archive.Record {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var records []archive.Record
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec archive.Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatalf("Invalid record %q: %v", scanner.Text(), err)
+			}
+			records = append(records, rec)
+		}
+		return records
+	}
+
+	records := read(byKey.File)
+	if len(records) != 1 {
+		t.Fatalf("Expected one record for the key, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.KeyID != KeyID("client-a") || rec.Request != `{"model":"gpt-4"}` || rec.Response != `{"id":"test-response"}` {
+		t.Errorf("Expected the whole exchange captured, got %+v", rec)
+	}
+	if auth := rec.RequestHeaders["Authorization"]; len(auth) != 1 || auth[0] != "[redacted]" {
+		t.Errorf("Expected the Authorization header redacted, got %v", auth)
+	}
+	if records := read(byRequest.File); len(records) != 1 || records[0].RequestID != "support-7-1" {
+		t.Errorf("Expected the matching request ID captured, got %+v", records)
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// DefaultsHeader lists the parameters the proxy filled in for a request
+const DefaultsHeader = "X-Proxy-Defaults-Applied"
+
+// defaultsPaths are the endpoints whose requests take the parameters a
+// profile sets
+var defaultsPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+}
+
+// ParamDefaults fills in the sampling parameters a model's requests leave
+// out, so tuning decided for the whole platform doesn't need every client
+// to change. Parameters a request sets, even to their zero value, are kept.
+type ParamDefaults struct {
+	profiles map[string]config.ParamDefaults // By model name or "prefix*" pattern
+}
+
+// NewParamDefaults applies profiles, keyed by model name or "prefix*" pattern
+func NewParamDefaults(profiles map[string]config.ParamDefaults) *ParamDefaults {
+	return &ParamDefaults{profiles: profiles}
+}
+
+// profile returns a model's profile, preferring an exact match over the
+// longest matching prefix
+func (d *ParamDefaults) profile(model string) (config.ParamDefaults, bool) {
+	if profile, ok := d.profiles[model]; ok {
+		return profile, true
+	}
+	var profile config.ParamDefaults
+	best := -1
+	for pattern, p := range d.profiles {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(prefix) > best {
+			profile, best = p, len(prefix)
+		}
+	}
+	return profile, best >= 0
+}
+
+// applyDefaults returns the body with the parameters of its model's profile
+// it leaves out filled in, listing them in DefaultsHeader
+func (h *RequestHandler) applyDefaults(w http.ResponseWriter, r *http.Request, body []byte, model string) []byte {
+	if h.Defaults == nil || model == "" || r.Method != "POST" || !defaultsPaths[r.URL.Path] {
+		return body
+	}
+	profile, ok := h.Defaults.profile(model)
+	if !ok {
+		return body
+	}
+
+	params := make(map[string]any)
+	if profile.Temperature != nil {
+		params["temperature"] = *profile.Temperature
+	}
+	if profile.TopP != nil {
+		params["top_p"] = *profile.TopP
+	}
+	if profile.MaxTokens > 0 {
+		params["max_tokens"] = profile.MaxTokens
+	}
+	if len(profile.Stop) > 0 {
+		params["stop"] = profile.Stop
+	}
+
+	rewritten, set, err := openai.SetDefaults(body, params)
+	if err != nil {
+		// Not a JSON object; leave it for upstream to reject
+		return body
+	}
+	if len(set) > 0 {
+		w.Header().Set(DefaultsHeader, strings.Join(set, ", "))
+	}
+	return rewritten
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestParamDefaults(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// Each upstream call records the body it was sent
+	var sent map[string]any
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		sent = nil
+		json.NewDecoder(body).Decode(&sent)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id":"chatcmpl-1"}`)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+	low, high := 0.2, 0.9
+	handler.Defaults = NewParamDefaults(map[string]config.ParamDefaults{
+		"gpt-4o":  {Temperature: &low, MaxTokens: 512, Stop: []string{"END"}},
+		"gpt-4o*": {Temperature: &high, TopP: &high},
+	})
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Host = "localhost:8080"
+		r.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// Omitted and null parameters get the model's defaults
+	recorder := send("/v1/chat/completions", `{"model":"gpt-4o","messages":[],"stop":null}`)
+	if sent["temperature"] != 0.2 || sent["max_tokens"] != 512.0 || sent["stop"] == nil {
+		t.Errorf("Expected the defaults filled in, got %v", sent)
+	}
+	if got := recorder.Header().Get(DefaultsHeader); got != "max_tokens, stop, temperature" {
+		t.Errorf("Expected the filled in parameters listed, got %q", got)
+	}
+
+	// Parameters the client set are kept, even zero, and max_completion_tokens stands in for max_tokens
+	recorder = send("/v1/chat/completions", `{"model":"gpt-4o","messages":[],"temperature":0,"max_completion_tokens":64}`)
+	if sent["temperature"] != 0.0 || sent["max_tokens"] != nil || sent["max_completion_tokens"] != 64.0 {
+		t.Errorf("Expected the client's parameters kept, got %v", sent)
+	}
+	if got := recorder.Header().Get(DefaultsHeader); got != "stop" {
+		t.Errorf("Expected only stop filled in, got %q", got)
+	}
+
+	// Models without an exact profile take the longest matching pattern's
+	send("/v1/completions", `{"model":"gpt-4o-mini","prompt":"hi"}`)
+	if sent["temperature"] != 0.9 || sent["top_p"] != 0.9 || sent["max_tokens"] != nil {
+		t.Errorf("Expected the pattern's defaults, got %v", sent)
+	}
+
+	// Other models and endpoints are left alone
+	recorder = send("/v1/chat/completions", `{"model":"o3","messages":[]}`)
+	if _, ok := sent["temperature"]; ok || recorder.Header().Get(DefaultsHeader) != "" {
+		t.Errorf("Expected a model without a profile left alone, got %v", sent)
+	}
+	send("/v1/embeddings", `{"model":"gpt-4o","input":"hi"}`)
+	if _, ok := sent["temperature"]; ok {
+		t.Errorf("Expected embeddings left alone, got %v", sent)
+	}
+}
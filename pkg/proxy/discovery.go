@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ways of settling which backend serves a model several backends list
+const (
+	DiscoveryFirst  = "first"  // The backend listed first
+	DiscoverySticky = "sticky" // The backend already serving it, while it still lists it
+)
+
+// DiscoveredBackend is an upstream whose models are discovered
+type DiscoveredBackend struct {
+	URL    string
+	Client OpenAIClient
+}
+
+// DiscoveredModel is where discovery sends a model
+type DiscoveredModel struct {
+	Model   string   `json:"model"`
+	Backend string   `json:"backend"`           // Upstream base URL requests for the model go to
+	Listed  []string `json:"listed"`            // Every backend listing the model, in configured order
+	Skipped bool     `json:"skipped,omitempty"` // A route names the model, so discovery leaves it alone
+}
+
+// ModelDiscovery routes models that no route names to the backend listing
+// them in its /v1/models, so models added to a backend are served without a
+// config change. A backend whose listing fails keeps its last known models
+// until it answers again.
+type ModelDiscovery struct {
+	backends []DiscoveredBackend
+	// Conflict picks the backend for a model several list: DiscoveryFirst
+	// (default) or DiscoverySticky
+	Conflict string
+	// Default is the URL of the default upstream; models it wins go to the
+	// default client instead of a route
+	Default string
+	// explicit reports whether a route names a model, set from the router
+	explicit func(model string) bool
+
+	mu      sync.RWMutex
+	listed  map[string][]string // Models listed by each backend URL
+	errors  map[string]string   // Last refresh error of each backend URL
+	routes  map[string]string   // Model to backend URL
+	checked time.Time
+}
+
+// NewModelDiscovery creates a discovery over backends, which are preferred in
+// the order given when several list the same model. Models the router's
+// rules name are left to the rules; router may be nil.
+func NewModelDiscovery(router *RuleRouter, backends ...DiscoveredBackend) *ModelDiscovery {
+	d := &ModelDiscovery{
+		backends: backends,
+		listed:   make(map[string][]string),
+		errors:   make(map[string]string),
+		routes:   make(map[string]string),
+	}
+	if router != nil {
+		d.explicit = router.names
+	}
+	return d
+}
+
+// Backend returns the upstream base URL a discovered model is served by,
+// empty for the default upstream, and whether the model was discovered
+func (d *ModelDiscovery) Backend(model string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	backend, ok := d.routes[model]
+	if backend == d.Default {
+		backend = ""
+	}
+	return backend, ok
+}
+
+// Refresh lists every backend's models and rebuilds the routes
+func (d *ModelDiscovery) Refresh(ctx context.Context) {
+	listed := make(map[string][]string, len(d.backends))
+	errors := make(map[string]string)
+	for _, backend := range d.backends {
+		models, err := listModels(ctx, backend.Client)
+		if err != nil {
+			errors[backend.URL] = err.Error()
+			continue
+		}
+		listed[backend.URL] = models
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for url, models := range listed {
+		d.listed[url] = models
+	}
+	d.errors = errors
+	d.checked = time.Now()
+
+	routes := make(map[string]string)
+	for _, backend := range d.backends {
+		for _, model := range d.listed[backend.URL] {
+			if _, taken := routes[model]; taken || (d.explicit != nil && d.explicit(model)) {
+				continue
+			}
+			routes[model] = backend.URL
+		}
+	}
+	if d.Conflict == DiscoverySticky {
+		for model, url := range d.routes {
+			if _, ok := routes[model]; ok && slices.Contains(d.listed[url], model) {
+				routes[model] = url
+			}
+		}
+	}
+
+	for model, url := range routes {
+		if old, ok := d.routes[model]; !ok {
+			fmt.Printf("Discovered model %s on %s\n", model, url)
+		} else if old != url {
+			fmt.Printf("Moved discovered model %s from %s to %s\n", model, old, url)
+		}
+	}
+	for model, url := range d.routes {
+		if _, ok := routes[model]; !ok {
+			fmt.Printf("Model %s is no longer listed by %s\n", model, url)
+		}
+	}
+	d.routes = routes
+}
+
+// Run refreshes the routes now and every interval until ctx is cancelled
+func (d *ModelDiscovery) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, interval)
+		d.Refresh(refreshCtx)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DiscoveryStatus is what discovery knows, as reported by the admin API
+type DiscoveryStatus struct {
+	Checked  time.Time           `json:"checked"`
+	Models   []DiscoveredModel   `json:"models"`
+	Backends map[string][]string `json:"backends"`         // Models listed by each backend
+	Errors   map[string]string   `json:"errors,omitempty"` // Backends whose last refresh failed
+}
+
+// Status returns every discovered model and where it is routed
+func (d *ModelDiscovery) Status() DiscoveryStatus {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	status := DiscoveryStatus{
+		Checked:  d.checked,
+		Models:   []DiscoveredModel{},
+		Backends: make(map[string][]string, len(d.listed)),
+		Errors:   make(map[string]string, len(d.errors)),
+	}
+	byModel := make(map[string]*DiscoveredModel)
+	for _, backend := range d.backends {
+		status.Backends[backend.URL] = slices.Clone(d.listed[backend.URL])
+		for _, model := range d.listed[backend.URL] {
+			m, ok := byModel[model]
+			if !ok {
+				m = &DiscoveredModel{Model: model, Backend: d.routes[model], Skipped: d.explicit != nil && d.explicit(model)}
+				byModel[model] = m
+			}
+			m.Listed = append(m.Listed, backend.URL)
+		}
+	}
+	for url, err := range d.errors {
+		status.Errors[url] = err
+	}
+	for _, m := range byModel {
+		status.Models = append(status.Models, *m)
+	}
+	sort.Slice(status.Models, func(i, j int) bool { return status.Models[i].Model < status.Models[j].Model })
+	return status
+}
+
+// listModels returns the IDs of the models a backend's /v1/models lists
+func listModels(ctx context.Context, client OpenAIClient) ([]string, error) {
+	resp, err := client.ForwardRequest(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("listing models returned status %d", resp.StatusCode)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid model list: %w", err)
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		if m.ID != "" {
+			models = append(models, m.ID)
+		}
+	}
+	return models, nil
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// fakeModelList is a backend whose /v1/models listing can be changed
+type fakeModelList struct {
+	mu     sync.Mutex
+	models []string
+	down   bool
+}
+
+func (f *fakeModelList) set(models ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.models, f.down = models, false
+}
+
+func (f *fakeModelList) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	data := make([]string, 0, len(f.models))
+	for _, model := range f.models {
+		data = append(data, `{"id":"`+model+`","object":"model"}`)
+	}
+	list := `{"object":"list","data":[` + strings.Join(data, ",") + `]}`
+	return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(list))}, nil
+}
+
+func TestModelDiscovery(t *testing.T) {
+	vllm, ollama := &fakeModelList{}, &fakeModelList{}
+	vllm.set("llama-3-70b", "qwen-2.5")
+	ollama.set("qwen-2.5", "mistral-7b", "gpt-4o")
+
+	router := NewRuleRouter([]config.RouteRule{{Match: config.RouteMatch{Models: []string{"gpt-4o*"}}, Model: "gpt-4o-mini"}})
+	d := NewModelDiscovery(router,
+		DiscoveredBackend{URL: "http://vllm", Client: vllm},
+		DiscoveredBackend{URL: "http://ollama", Client: ollama},
+	)
+	d.Refresh(context.Background())
+
+	backend := func(model string) string {
+		b, _ := d.Backend(model)
+		return b
+	}
+	if backend("llama-3-70b") != "http://vllm" || backend("mistral-7b") != "http://ollama" {
+		t.Errorf("Expected models routed to the backends listing them, got %+v", d.Status())
+	}
+
+	// A model several backends list goes to the first configured
+	if backend("qwen-2.5") != "http://vllm" {
+		t.Errorf("Expected qwen-2.5 on the first listed backend, got %q", backend("qwen-2.5"))
+	}
+
+	// Models a route names are left to the route
+	if _, ok := d.Backend("gpt-4o"); ok {
+		t.Error("Expected gpt-4o left to its route")
+	}
+
+	// A backend that can't be reached keeps its last known models
+	vllm.mu.Lock()
+	vllm.down = true
+	vllm.mu.Unlock()
+	d.Refresh(context.Background())
+	if backend("llama-3-70b") != "http://vllm" {
+		t.Error("Expected models kept while their backend is unreachable")
+	}
+	if status := d.Status(); status.Errors["http://vllm"] == "" {
+		t.Errorf("Expected the failed refresh reported, got %+v", status)
+	}
+
+	// Models dropped from a listing are no longer routed
+	vllm.set("qwen-2.5")
+	d.Refresh(context.Background())
+	if _, ok := d.Backend("llama-3-70b"); ok {
+		t.Error("Expected llama-3-70b dropped once vllm stopped listing it")
+	}
+}
+
+func TestModelDiscoverySticky(t *testing.T) {
+	first, second := &fakeModelList{}, &fakeModelList{}
+	second.set("qwen-2.5")
+	d := NewModelDiscovery(nil,
+		DiscoveredBackend{URL: "http://first", Client: first},
+		DiscoveredBackend{URL: "http://second", Client: second},
+	)
+	d.Conflict = DiscoverySticky
+	d.Refresh(context.Background())
+
+	// The model stays where it is when a preferred backend starts listing it
+	first.set("qwen-2.5")
+	d.Refresh(context.Background())
+	if backend, _ := d.Backend("qwen-2.5"); backend != "http://second" {
+		t.Errorf("Expected qwen-2.5 to stay on second, got %q", backend)
+	}
+
+	// Until its backend stops listing it
+	second.set()
+	d.Refresh(context.Background())
+	if backend, _ := d.Backend("qwen-2.5"); backend != "http://first" {
+		t.Errorf("Expected qwen-2.5 to move to first, got %q", backend)
+	}
+}
+
+func TestHandlerRoutesDiscoveredModels(t *testing.T) {
+	vllm, def := &fakeModelList{}, &fakeModelList{}
+	vllm.set("llama-3-70b")
+	def.set("gpt-4o")
+	handler := NewRequestHandler(NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{}))
+	handler.Discovery = NewModelDiscovery(nil,
+		DiscoveredBackend{URL: "http://vllm", Client: vllm},
+		DiscoveredBackend{URL: "http://default", Client: def},
+	)
+	handler.Discovery.Default = "http://default"
+	handler.Discovery.Refresh(context.Background())
+
+	// Models found on the default upstream stay on the default client
+	for model, want := range map[string]string{"llama-3-70b": "http://vllm", "gpt-4o": "", "unknown": ""} {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		_, _, target, _ := handler.route(r, []byte(`{"model":"`+model+`"}`), model, 1)
+		if target.Backend != want {
+			t.Errorf("%s: expected backend %q, got %q", model, want, target.Backend)
+		}
+	}
+}
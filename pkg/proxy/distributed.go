@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Job is a request serialized for a shared queue backend
+type Job struct {
+	ID              string            `json:"id"`
+	Priority        int               `json:"priority"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	RawQuery        string            `json:"raw_query,omitempty"`
+	Header          http.Header       `json:"header"`
+	Body            []byte            `json:"body,omitempty"`
+	Model           string            `json:"model,omitempty"`
+	InputTokens     int64             `json:"input_tokens,omitempty"`
+	Tools           []string          `json:"tools,omitempty"`
+	ReasoningEffort string            `json:"reasoning_effort,omitempty"`
+	Boosted         bool              `json:"boosted,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	User            string            `json:"user,omitempty"`
+	SessionID       string            `json:"session_id,omitempty"`
+	KeyID           string            `json:"key_id,omitempty"`
+	Backend         string            `json:"backend,omitempty"`
+	Speculative     string            `json:"speculative,omitempty"`
+	EnqueuedAt      time.Time         `json:"enqueued_at"`
+	Deadline        time.Time         `json:"deadline,omitzero"` // When the client stops waiting, zero for never
+}
+
+// JobResult is the response to a Job, returned to the replica that submitted it
+type JobResult struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// QueueBackend shares queued work between proxy replicas so they form one
+// logical priority queue. The replica that accepted the client connection
+// submits a Job and waits for its result; whichever replica pops the Job
+// runs it through its local scheduler.
+type QueueBackend interface {
+	// Push enqueues a job at its priority
+	Push(ctx context.Context, job *Job) error
+	// Pop returns the oldest job from the highest priority non-empty queue,
+	// or nil if none arrived before the backend's poll interval elapsed
+	Pop(ctx context.Context, priorities []int) (*Job, error)
+	// Complete publishes a job's result to its submitter
+	Complete(ctx context.Context, job *Job, result *JobResult) error
+	// Wait blocks until the result for a job is published
+	Wait(ctx context.Context, jobID string) (*JobResult, error)
+	// Depth returns the number of jobs waiting at a priority
+	Depth(ctx context.Context, priority int) (int64, error)
+	// Close releases backend resources
+	Close() error
+}
+
+// newJobID returns a random job identifier
+func newJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newJob serializes a request waiting for priority's queue, with its body
+func newJob(req *workRequest, priority int, body []byte) *Job {
+	r := req.Request
+	job := &Job{
+		ID:              newJobID(),
+		Priority:        priority,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		RawQuery:        r.URL.RawQuery,
+		Header:          r.Header.Clone(),
+		Body:            body,
+		Model:           req.Model,
+		InputTokens:     req.InputTokens,
+		Tools:           req.Tools,
+		ReasoningEffort: req.ReasoningEffort,
+		Boosted:         req.Boosted,
+		Tags:            req.Tags,
+		User:            req.User,
+		SessionID:       req.SessionID,
+		KeyID:           req.KeyID,
+		Backend:         req.Backend,
+		Speculative:     req.Speculative,
+		EnqueuedAt:      req.StartTime,
+	}
+	if deadline, ok := r.Context().Deadline(); ok {
+		job.Deadline = deadline
+	}
+	return job
+}
+
+// request rebuilds the request a job was made from, answered on w. Its
+// context ends at the job's deadline; cancel releases it once the request is done.
+func (job *Job) request(w http.ResponseWriter) (req *workRequest, cancel context.CancelFunc, err error) {
+	target := job.Path
+	if job.RawQuery != "" {
+		target += "?" + job.RawQuery
+	}
+	// Carry the client's deadline over so the job stops where the client does
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if !job.Deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, job.Deadline)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, job.Method, target, bytes.NewReader(job.Body))
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	httpReq.Header = job.Header
+
+	return &workRequest{
+		Request:         httpReq,
+		ResponseWriter:  w,
+		Done:            make(chan struct{}),
+		StartTime:       job.EnqueuedAt,
+		Model:           job.Model,
+		InputTokens:     job.InputTokens,
+		Tools:           job.Tools,
+		ReasoningEffort: job.ReasoningEffort,
+		Boosted:         job.Boosted,
+		Tags:            job.Tags,
+		User:            job.User,
+		SessionID:       job.SessionID,
+		KeyID:           job.KeyID,
+		Backend:         job.Backend,
+		Speculative:     job.Speculative,
+		BodySize:        int64(len(job.Body)),
+	}, cancel, nil
+}
+
+// priorities returns the distinct queue priorities, highest priority first
+func (qm *QueueManager) priorities() []int {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	seen := make(map[int]bool)
+	var priorities []int
+	for _, q := range qm.Queues {
+		if !seen[q.Priority] {
+			seen[q.Priority] = true
+			priorities = append(priorities, q.Priority)
+		}
+	}
+	sort.Ints(priorities)
+	return priorities
+}
+
+// StartDistributedWorker pulls jobs from the shared backend into the local
+// queues, running at most maxInflight of them at a time
+func (qm *QueueManager) StartDistributedWorker(ctx context.Context, maxInflight int) {
+	if maxInflight <= 0 {
+		maxInflight = 1
+	}
+	slots := make(chan struct{}, maxInflight)
+	priorities := qm.priorities()
+
+	for {
+		// Only pull work this replica has capacity to run
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		job, err := qm.Backend.Pop(ctx, priorities)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Error pulling job from queue backend: %v\n", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		if job == nil {
+			<-slots
+			continue
+		}
+
+		go func() {
+			defer func() { <-slots }()
+			qm.runJob(ctx, job)
+		}()
+	}
+}
+
+// runJob executes a job pulled from the backend and publishes its result
+func (qm *QueueManager) runJob(ctx context.Context, job *Job) {
+	result := &JobResult{Header: make(http.Header)}
+	defer func() {
+		if err := qm.Backend.Complete(context.Background(), job, result); err != nil {
+			fmt.Printf("Error publishing result for job %s: %v\n", job.ID, err)
+		}
+	}()
+
+	queue := qm.FindQueue(job.Priority)
+	if queue == nil {
+		result.Status = http.StatusNotFound
+		result.Body = []byte(`{"error":"No queue configured for this priority"}`)
+		return
+	}
+
+	buf := newResponseBuffer()
+	req, cancel, err := job.request(buf)
+	if err != nil {
+		result.Status = http.StatusBadRequest
+		result.Body = []byte(`{"error":"Invalid queued request"}`)
+		return
+	}
+	defer cancel()
+	httpReq := req.Request
+
+	// Wait for room on the queue rather than dropping a job already claimed
+	for {
+		err := qm.enqueue(queue, req)
+		if err == nil {
+			break
+		}
+		if err == ErrQueueBytes && req.BodySize > queue.MaxQueuedBytes {
+			// It would never fit, however long it waited
+			result.Status = http.StatusRequestEntityTooLarge
+			result.Body = []byte(`{"error":"Request body is larger than this endpoint queues"}`)
+			return
+		}
+		if err != ErrStopping {
+			select {
+			case <-time.After(qm.schedulerTick()):
+				continue
+			case <-ctx.Done():
+			}
+		}
+		result.Status = http.StatusServiceUnavailable
+		result.Body = []byte(`{"error":"Proxy shutting down"}`)
+		qm.recordRejection(httpReq, queue.Priority, job.Model, http.StatusServiceUnavailable, RejectShuttingDown)
+		return
+	}
+	<-req.Done
+
+	result.Status = buf.status
+	result.Header = buf.header
+	result.Body = buf.body.Bytes()
+}
+
+// serveDistributed submits a request to the shared backend and relays the
+// result from whichever replica ran it
+func (h *RequestHandler) serveDistributed(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, req *workRequest, body []byte) {
+	backend := h.QueueManager.Backend
+	job := newJob(req, queue.Priority, body)
+
+	if err := backend.Push(r.Context(), job); err != nil {
+		fmt.Printf("Error pushing job to queue backend: %v\n", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"Queue backend unavailable"}`))
+		h.rejected(r, queue, req.Model, http.StatusServiceUnavailable, RejectBackend)
+		return
+	}
+
+	result, err := backend.Wait(r.Context(), job.ID)
+	if err != nil {
+		// The client went away or the backend failed; nothing useful to send
+		w.WriteHeader(http.StatusGatewayTimeout)
+		w.Write([]byte(`{"error":"Timed out waiting for queued request"}`))
+		return
+	}
+
+	copyUpstreamHeaders(w, result.Header)
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	w.WriteHeader(result.Status)
+	w.Write(result.Body)
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// DowngradeHeader names the model a request asked for when it was served by a cheaper one
+const DowngradeHeader = "X-Proxy-Downgraded-From"
+
+// defaultWaitWindow is how far back queue wait averages look
+const defaultWaitWindow = 30 * time.Second
+
+// maxWaitSamples bounds the wait history kept per queue
+const maxWaitSamples = 1024
+
+// downgradePaths are the endpoints whose requests can switch models without
+// changing the shape of the result. Embeddings are left alone since vectors
+// from different models can't be compared.
+var downgradePaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/responses":        true,
+}
+
+// waitSample is how long one request waited before it was picked up
+type waitSample struct {
+	at   time.Time
+	wait time.Duration
+}
+
+// waitStats tracks how long recent requests waited in a queue
+type waitStats struct {
+	mu      sync.Mutex
+	samples []waitSample
+}
+
+// record adds a request's wait, dropping samples older than window
+func (s *waitStats) record(wait, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now, window)
+	if len(s.samples) == maxWaitSamples {
+		s.samples = s.samples[1:]
+	}
+	s.samples = append(s.samples, waitSample{at: now, wait: wait})
+}
+
+// average returns the mean wait of requests picked up within window
+func (s *waitStats) average(window time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune(time.Now(), window)
+	if len(s.samples) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, sample := range s.samples {
+		total += sample.wait
+	}
+	return total / time.Duration(len(s.samples))
+}
+
+// rate returns how many requests a second were picked up within window
+func (s *waitStats) rate(window time.Duration) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now, window)
+	if len(s.samples) == 0 {
+		return 0
+	}
+	// Once the history is full it covers less than the window
+	span := window
+	if len(s.samples) == maxWaitSamples {
+		span = max(now.Sub(s.samples[0].at), time.Millisecond)
+	}
+	return float64(len(s.samples)) / span.Seconds()
+}
+
+// prune drops samples older than window; samples are kept in time order
+func (s *waitStats) prune(now time.Time, window time.Duration) {
+	i := 0
+	for i < len(s.samples) && now.Sub(s.samples[i].at) > window {
+		i++
+	}
+	s.samples = s.samples[i:]
+}
+
+// waitWindow returns how far back queue wait averages look
+func (qm *QueueManager) waitWindow() time.Duration {
+	if qm.WaitWindow > 0 {
+		return qm.WaitWindow
+	}
+	return defaultWaitWindow
+}
+
+// AverageWait returns how long requests picked up from a queue recently waited on average
+func (qm *QueueManager) AverageWait(queue *PriorityQueue) time.Duration {
+	return queue.waits.average(qm.waitWindow())
+}
+
+// DowngradePolicy moves requests to cheaper models while their queue is
+// backed up, trading quality for latency during spikes
+type DowngradePolicy struct {
+	// MaxWait is the queue wait above which requests are downgraded: the
+	// longer of how long recent requests waited on average and how long
+	// those waiting now will take to be picked up at the recent rate
+	MaxWait time.Duration
+	// Models maps a model to the model used in its place
+	Models map[string]string
+	// Priorities limits downgrades to these queues; empty means every queue
+	Priorities map[int]bool
+}
+
+// downgrade returns the body and model a request should be sent upstream
+// with. When the request is downgraded the original model is reported to
+// the client in DowngradeHeader.
+func (h *RequestHandler) downgrade(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, body []byte, model string) ([]byte, string) {
+	policy := h.Downgrade
+	if policy == nil || r.Method != "POST" || !downgradePaths[r.URL.Path] {
+		return body, model
+	}
+
+	target, ok := policy.Models[model]
+	if !ok || target == model {
+		return body, model
+	}
+	if len(policy.Priorities) > 0 && !policy.Priorities[queue.Priority] {
+		return body, model
+	}
+
+	// The requests waiting now count at the queue's recent pickup rate.
+	// Without one, e.g. after an idle spell, how long they will wait is
+	// unknown, and only the waits of requests picked up count.
+	wait := h.QueueManager.AverageWait(queue)
+	if pickup, ok := h.QueueManager.pickupTime(queue, queue.waiting()); ok {
+		wait = max(wait, pickup)
+	}
+	if wait <= policy.MaxWait {
+		return body, model
+	}
+
+	rewritten, err := openai.ReplaceModel(body, target)
+	if err != nil {
+		// Not a JSON object; leave it for upstream to reject
+		return body, model
+	}
+
+	fmt.Printf("Downgrading request from %s to %s (priority %d, queue wait %v)\n",
+		model, target, queue.Priority, wait)
+	w.Header().Set(DowngradeHeader, model)
+	return rewritten, target
+}
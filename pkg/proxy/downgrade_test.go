@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestDowngradeUnderLoad(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	models := make(chan string, 1)
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			model, _, _, _ := openai.ExtractRequestMetadata(body)
+			models <- model
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+			}, nil
+		},
+	}
+
+	endpoints := []config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2, Preemptive: false},
+	}
+	qm := NewQueueManager(endpoints, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.Downgrade = &DowngradePolicy{
+		MaxWait:    time.Second,
+		Models:     map[string]string{"gpt-4o": "gpt-4o-mini"},
+		Priorities: map[int]bool{2: true},
+	}
+
+	send := func(port, path string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(`{"model":"gpt-4o","input":"Hello"}`))
+		req.Host = "localhost:" + port
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder, <-models
+	}
+
+	// Short waits leave the model alone
+	recorder, model := send("8081", "/v1/chat/completions")
+	if model != "gpt-4o" || recorder.Header().Get(DowngradeHeader) != "" {
+		t.Errorf("Expected no downgrade, got model %s and header %q", model, recorder.Header().Get(DowngradeHeader))
+	}
+
+	// Simulate a backlog on both queues
+	for _, q := range qm.Queues {
+		for i := 0; i < 10; i++ {
+			q.waits.record(5*time.Second, qm.waitWindow())
+		}
+	}
+
+	recorder, model = send("8081", "/v1/chat/completions")
+	if model != "gpt-4o-mini" {
+		t.Errorf("Expected request to be downgraded to gpt-4o-mini, got %s", model)
+	}
+	if recorder.Header().Get(DowngradeHeader) != "gpt-4o" {
+		t.Errorf("Expected %s to name the original model, got %q", DowngradeHeader, recorder.Header().Get(DowngradeHeader))
+	}
+
+	// Queues not covered by the policy and endpoints that can't switch models are left alone
+	if _, model = send("8080", "/v1/chat/completions"); model != "gpt-4o" {
+		t.Errorf("Expected priority 1 to keep its model, got %s", model)
+	}
+	if _, model = send("8081", "/v1/embeddings"); model != "gpt-4o" {
+		t.Errorf("Expected embeddings to keep their model, got %s", model)
+	}
+}
+
+func TestDowngradeWaitingRequests(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{})
+	handler := NewRequestHandler(qm)
+	handler.Downgrade = &DowngradePolicy{MaxWait: time.Second, Models: map[string]string{"gpt-4o": "gpt-4o-mini"}}
+	queue := qm.FindQueue(1)
+	body := []byte(`{"model":"gpt-4o","messages":[]}`)
+	r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	downgraded := func() bool {
+		_, model := handler.downgrade(httptest.NewRecorder(), r, queue, body, "gpt-4o")
+		return model == "gpt-4o-mini"
+	}
+
+	// A fresh request on a queue that has been idle has no rate to wait at
+	queue.Requests <- &workRequest{Request: r, StartTime: time.Now()}
+	if downgraded() {
+		t.Error("Expected a fresh request on an idle queue to keep its model")
+	}
+
+	// Requests waiting count at the recent pickup rate, though those picked
+	// up barely waited
+	for i := 0; i < 3; i++ {
+		queue.waits.record(10*time.Millisecond, qm.waitWindow())
+	}
+	for i := 0; i < 2; i++ {
+		queue.Requests <- &workRequest{Request: r, StartTime: time.Now()}
+	}
+	if !downgraded() {
+		t.Error("Expected a queue backed up at its pickup rate to downgrade")
+	}
+}
+
+func TestWaitStats(t *testing.T) {
+	var stats waitStats
+	stats.record(100*time.Millisecond, time.Minute)
+	stats.record(300*time.Millisecond, time.Minute)
+
+	if avg := stats.average(time.Minute); avg != 200*time.Millisecond {
+		t.Errorf("Expected average wait of 200ms, got %v", avg)
+	}
+
+	// Samples outside the window no longer count
+	time.Sleep(20 * time.Millisecond)
+	if avg := stats.average(10 * time.Millisecond); avg != 0 {
+		t.Errorf("Expected old samples to be dropped, got %v", avg)
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxErrorBody bounds how much of an upstream error response is read
+const maxErrorBody = 64 * 1024
+
+// maxErrorHeader bounds the raw error copied into the debug header
+const maxErrorHeader = 1024
+
+// UpstreamErrorHeader carries the raw upstream error when enabled
+const UpstreamErrorHeader = "X-Proxy-Upstream-Error"
+
+// OpenAIError is an error in the shape OpenAI returns
+type OpenAIError struct {
+	Message string      `json:"message"`
+	Type    string      `json:"type"`
+	Param   interface{} `json:"param"`
+	Code    interface{} `json:"code"`
+}
+
+// upstreamError covers the error bodies of the backends we proxy to:
+// OpenAI and Azure ({"error":{...}}), Anthropic ({"type":"error","error":{...}}),
+// vLLM ({"object":"error","message":...}) and FastAPI ({"detail":...})
+type upstreamError struct {
+	Error   json.RawMessage `json:"error"`
+	Object  string          `json:"object"`
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Param   interface{}     `json:"param"`
+	Code    interface{}     `json:"code"`
+	Detail  json.RawMessage `json:"detail"`
+}
+
+// errorTypeForStatus is the OpenAI error type used for a status when the upstream doesn't give a usable one
+func errorTypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return "timeout_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// openAIErrorTypes are the error types clients already handle
+var openAIErrorTypes = map[string]bool{
+	"invalid_request_error": true,
+	"authentication_error":  true,
+	"permission_error":      true,
+	"not_found_error":       true,
+	"rate_limit_error":      true,
+	"timeout_error":         true,
+	"server_error":          true,
+	"insufficient_quota":    true,
+	"tokens":                true,
+	"requests":              true,
+}
+
+// normalizeError converts an upstream error body to an OpenAI-format error
+func normalizeError(status int, body []byte) OpenAIError {
+	e := OpenAIError{Type: errorTypeForStatus(status)}
+
+	var up upstreamError
+	if json.Unmarshal(body, &up) != nil {
+		// Plain text or HTML from a load balancer or crashed server
+		e.Message = strings.TrimSpace(string(body))
+		if e.Message == "" {
+			e.Message = http.StatusText(status)
+		}
+		return e
+	}
+
+	var nested OpenAIError
+	var nestedMessage string
+	if len(up.Error) > 0 && json.Unmarshal(up.Error, &nested) != nil {
+		// {"error":"message"}
+		json.Unmarshal(up.Error, &nestedMessage)
+	}
+
+	switch {
+	case nested.Message != "" || nested.Type != "":
+		e.Message, e.Param, e.Code = nested.Message, nested.Param, nested.Code
+		if openAIErrorTypes[nested.Type] {
+			e.Type = nested.Type
+		} else if nested.Type != "" && e.Code == nil {
+			// Anthropic types like overloaded_error become the code
+			e.Code = strings.TrimSuffix(nested.Type, "_error")
+		}
+	case nestedMessage != "":
+		e.Message = nestedMessage
+	case up.Message != "":
+		e.Message, e.Param, e.Code = up.Message, up.Param, up.Code
+	case len(up.Detail) > 0:
+		var detail string
+		if json.Unmarshal(up.Detail, &detail) != nil {
+			detail = string(up.Detail)
+		}
+		e.Message = detail
+	default:
+		e.Message = http.StatusText(status)
+	}
+
+	// Codes are strings in OpenAI errors; vLLM and Azure send numbers or numeric strings
+	switch code := e.Code.(type) {
+	case float64:
+		e.Code = nil
+	case string:
+		if _, err := strconv.Atoi(code); err == nil {
+			e.Code = nil
+		}
+	}
+	if e.Code == nil && status == http.StatusTooManyRequests {
+		e.Code = "rate_limit_exceeded"
+	}
+	return e
+}
+
+// normalizeErrorResponse rewrites a non-streaming upstream error response as
+// an OpenAI-format error, logging the raw error it replaces
+func normalizeErrorResponse(resp *http.Response, exposeRaw bool) {
+	if resp.StatusCode < 400 || isEventStream(resp.Header) {
+		return
+	}
+
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+
+	fmt.Printf("Upstream error (status %d): %s\n", resp.StatusCode, raw)
+
+	normalized, err := json.Marshal(map[string]OpenAIError{"error": normalizeError(resp.StatusCode, raw)})
+	if err != nil {
+		normalized = raw
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(normalized))
+	resp.ContentLength = int64(len(normalized))
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Type", "application/json")
+
+	if exposeRaw {
+		compact := strings.Join(strings.Fields(string(raw)), " ")
+		if len(compact) > maxErrorHeader {
+			compact = compact[:maxErrorHeader]
+		}
+		resp.Header.Set(UpstreamErrorHeader, compact)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+		errType string
+		code    interface{}
+	}{
+		{
+			name:    "openai",
+			status:  400,
+			body:    `{"error":{"message":"Too long","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`,
+			message: "Too long", errType: "invalid_request_error", code: "context_length_exceeded",
+		},
+		{
+			name:    "azure",
+			status:  429,
+			body:    `{"error":{"code":"429","message":"Rate limit is exceeded. Try again in 2 seconds."}}`,
+			message: "Rate limit is exceeded. Try again in 2 seconds.", errType: "rate_limit_error", code: "rate_limit_exceeded",
+		},
+		{
+			name:    "anthropic",
+			status:  529,
+			body:    `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			message: "Overloaded", errType: "server_error", code: "overloaded",
+		},
+		{
+			name:    "vllm",
+			status:  400,
+			body:    `{"object":"error","message":"max_tokens is too large","type":"BadRequestError","param":null,"code":400}`,
+			message: "max_tokens is too large", errType: "invalid_request_error", code: nil,
+		},
+		{
+			name:    "fastapi",
+			status:  404,
+			body:    `{"detail":"Not Found"}`,
+			message: "Not Found", errType: "not_found_error", code: nil,
+		},
+		{
+			name:    "plain text",
+			status:  502,
+			body:    "<html>Bad Gateway</html>\n",
+			message: "<html>Bad Gateway</html>", errType: "server_error", code: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		e := normalizeError(tt.status, []byte(tt.body))
+		if e.Message != tt.message || e.Type != tt.errType || e.Code != tt.code {
+			t.Errorf("%s: expected %q/%s/%v, got %q/%s/%v", tt.name, tt.message, tt.errType, tt.code, e.Message, e.Type, e.Code)
+		}
+	}
+}
+
+func TestNormalizeErrorResponse(t *testing.T) {
+	raw := `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`
+	resp := &http.Response{
+		StatusCode: 529,
+		Header:     http.Header{"Content-Type": {"application/json"}, "Content-Length": {"77"}},
+		Body:       io.NopCloser(strings.NewReader(raw)),
+	}
+
+	normalizeErrorResponse(resp, true)
+
+	body, _ := io.ReadAll(resp.Body)
+	var out struct {
+		Error OpenAIError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatalf("Expected JSON error body, got %s", body)
+	}
+	if out.Error.Type != "server_error" || out.Error.Message != "Overloaded" {
+		t.Errorf("Unexpected normalized error: %+v", out.Error)
+	}
+
+	if resp.Header.Get("Content-Length") != "" {
+		t.Error("Expected stale Content-Length to be removed")
+	}
+	if resp.Header.Get(UpstreamErrorHeader) != raw {
+		t.Errorf("Expected raw error in debug header, got %q", resp.Header.Get(UpstreamErrorHeader))
+	}
+
+	// Successful responses are left alone
+	ok := &http.Response{StatusCode: 200, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"id":"1"}`))}
+	normalizeErrorResponse(ok, true)
+	if body, _ := io.ReadAll(ok.Body); string(body) != `{"id":"1"}` {
+		t.Errorf("Expected success body untouched, got %s", body)
+	}
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// Escalation moves a request that has waited in queues for After up Steps
+// queues from the one it arrived on, stopping short of preemptive queues.
+// Unlike RequeueBoost it applies to requests still waiting for their first
+// attempt, so an endpoint can promise when its requests start.
+type Escalation struct {
+	After time.Duration
+	Steps int
+}
+
+// newEscalations converts an endpoint's escalation rules, soonest first
+func newEscalations(rules []config.EscalationRule) []Escalation {
+	if len(rules) == 0 {
+		return nil
+	}
+	escalations := make([]Escalation, 0, len(rules))
+	for _, rule := range rules {
+		steps := rule.Steps
+		if steps == 0 {
+			steps = 1
+		}
+		escalations = append(escalations, Escalation{After: time.Duration(rule.After) * time.Second, Steps: steps})
+	}
+	sort.SliceStable(escalations, func(i, j int) bool {
+		return escalations[i].After < escalations[j].After
+	})
+	return escalations
+}
+
+// escalate moves queued requests that have waited past one of their
+// arrival queue's escalation rules to the queue the rule sends them to,
+// and answers those that have waited past its queue timeout. Requests left
+// where they are keep their order.
+func (qm *QueueManager) escalate() {
+	// Expired requests are answered once the queues are unlocked
+	var expired []expiredRequest
+	defer func() {
+		for _, e := range expired {
+			qm.answerExpired(e)
+		}
+	}()
+
+	// The write lock keeps new requests out while queues are rearranged
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	escalating := false
+	for _, q := range qm.Queues {
+		escalating = escalating || len(q.Escalation) > 0 || q.QueueTimeout > 0
+	}
+	if !escalating {
+		return
+	}
+
+	now := time.Now()
+	for _, q := range qm.Queues {
+		for n := len(q.Requests); n > 0; n-- {
+			var req *workRequest
+			select {
+			case req = <-q.Requests:
+			default:
+			}
+			if req == nil {
+				break
+			}
+
+			// Requests keep the priority they arrived at wherever they move
+			if req.Priority == 0 {
+				req.Priority = q.Priority
+			}
+			if qm.queueTimedOut(req, q, now) {
+				expired = append(expired, qm.expire(req, q, now))
+				continue
+			}
+			if target := qm.escalationTarget(req, q, now); target != q {
+				select {
+				case target.Requests <- req:
+					// Its bytes go with it, even over the target's limit
+					q.queuedBytes.Add(-req.BodySize)
+					target.queuedBytes.Add(req.BodySize)
+					q.escalated.Add(1)
+					qm.tracef("escalate request %s from priority %d queue to priority %d after waiting %s",
+						traceID(req), q.Priority, target.Priority, queuedFor(req, now).Round(time.Millisecond))
+					continue
+				default:
+					// No room higher up yet, it stays put
+				}
+			}
+
+			qm.keepQueued(req, q)
+		}
+
+		// Requests held for their key's concurrency cap time out too, but
+		// don't move up: they would wait for their key there just the same
+		for _, req := range q.removeHeld(func(req *workRequest) bool { return qm.queueTimedOut(req, q, now) }) {
+			expired = append(expired, qm.expire(req, q, now))
+		}
+	}
+}
+
+// keepQueued puts a request taken off queue while it was rearranged back on
+// it, its bytes still counted there. Callers hold mu for writing.
+func (qm *QueueManager) keepQueued(req *workRequest, queue *PriorityQueue) {
+	select {
+	case queue.Requests <- req:
+	default:
+		// A requeued request took its place, this shouldn't happen but handle it
+		fmt.Printf("ERROR: Could not keep request queued, queue is full\n")
+		queue.queuedBytes.Add(-req.BodySize)
+		req.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+		req.ResponseWriter.Write([]byte(`{"error":"Service overloaded, please try again later"}`))
+		qm.recordRejection(req.Request, req.Priority, req.Model, http.StatusServiceUnavailable, RejectQueueFull)
+		close(req.Done)
+	}
+}
+
+// escalationTarget returns the queue a request waiting on queue belongs on:
+// the one the latest rule it has waited past sends it to, if that is
+// scheduled ahead of queue. Rules are those of the queue it arrived on.
+// Callers hold mu.
+func (qm *QueueManager) escalationTarget(req *workRequest, queue *PriorityQueue, now time.Time) *PriorityQueue {
+	arrival := qm.arrival(req, queue)
+	waited := queuedFor(req, now)
+	steps := 0
+	for _, e := range arrival.Escalation {
+		if waited >= e.After {
+			steps = e.Steps
+		}
+	}
+	if steps == 0 {
+		return queue
+	}
+
+	if target := qm.higherQueue(arrival, steps); qm.rank(target) < qm.rank(queue) {
+		return target
+	}
+	return queue
+}
+
+// queuedFor returns how long a request has waited in queues, over all attempts
+func queuedFor(req *workRequest, now time.Time) time.Duration {
+	queuedAt := req.StartTime
+	if !req.RequeuedAt.IsZero() {
+		queuedAt = req.RequeuedAt
+	}
+	return req.QueueWait + now.Sub(queuedAt)
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func TestEscalate(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2},
+		{Port: 8082, Priority: 3},
+		{Port: 8083, Priority: 4, Escalate: []config.EscalationRule{{After: 3600, Steps: 3}, {After: 60}}},
+	}, &MockOpenAIClient{})
+	qm.sortByPriority()
+	batch := qm.FindQueue(4)
+
+	newRequest := func(waited time.Duration) *workRequest {
+		return &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now().Add(-waited),
+		}
+	}
+	fresh, late, overdue := newRequest(time.Second), newRequest(2*time.Minute), newRequest(2*time.Hour)
+	for _, req := range []*workRequest{fresh, late, overdue} {
+		batch.Requests <- req
+	}
+
+	qm.escalate()
+
+	// Requests move up as far as the latest rule they waited past allows,
+	// never into the preemptive queue
+	if got := <-qm.FindQueue(3).Requests; got != late {
+		t.Error("Expected the request waiting two minutes moved up one queue")
+	}
+	if got := <-qm.FindQueue(2).Requests; got != overdue {
+		t.Error("Expected the request waiting two hours moved up to the highest non-preemptive queue")
+	}
+	if len(qm.FindQueue(1).Requests) != 0 {
+		t.Error("Expected nothing escalated into the preemptive queue")
+	}
+	if len(batch.Requests) != 1 || <-batch.Requests != fresh {
+		t.Error("Expected the fresh request left where it was")
+	}
+	if batch.escalated.Load() != 2 {
+		t.Errorf("Expected 2 escalations counted, got %d", batch.escalated.Load())
+	}
+
+	// Escalated requests keep the priority they arrived at, and aren't
+	// escalated again by the rules of the queue they were moved to
+	if late.Priority != 4 {
+		t.Errorf("Expected arrival priority 4 kept, got %d", late.Priority)
+	}
+	qm.FindQueue(3).Escalation = []Escalation{{After: time.Second, Steps: 1}}
+	qm.FindQueue(3).Requests <- late
+	qm.escalate()
+	if len(qm.FindQueue(3).Requests) != 1 {
+		t.Error("Expected an escalated request to follow its arrival queue's rules only")
+	}
+}
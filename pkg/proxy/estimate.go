@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mule-ai/proxy/pkg/tokenizer"
+)
+
+// EstimateResponse is the projected size and cost of a request
+type EstimateResponse struct {
+	Model           string   `json:"model"`
+	InputTokens     int64    `json:"input_tokens"`
+	MaxOutputTokens int64    `json:"max_output_tokens"`
+	InputCost       *float64 `json:"input_cost,omitempty"`      // USD, omitted for unpriced models
+	MaxOutputCost   *float64 `json:"max_output_cost,omitempty"` // USD, omitted for unpriced models
+	MaxCost         *float64 `json:"max_cost,omitempty"`        // USD, omitted for unpriced models
+}
+
+// estimateBody is the part of an OpenAI request body an estimate needs
+type estimateBody struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Content json.RawMessage `json:"content"`
+		Name    string          `json:"name"`
+	} `json:"messages"`
+	Prompt              json.RawMessage `json:"prompt"`
+	Input               json.RawMessage `json:"input"`
+	Instructions        string          `json:"instructions"`
+	MaxTokens           int64           `json:"max_tokens"`
+	MaxCompletionTokens int64           `json:"max_completion_tokens"`
+	MaxOutputTokens     int64           `json:"max_output_tokens"`
+	N                   int64           `json:"n"`
+}
+
+// maxOutput returns the output limit a request sets, under whichever name
+// its endpoint uses, or 0 if it sets none
+func (b *estimateBody) maxOutput() int64 {
+	switch {
+	case b.MaxCompletionTokens > 0:
+		return b.MaxCompletionTokens
+	case b.MaxTokens > 0:
+		return b.MaxTokens
+	default:
+		return b.MaxOutputTokens
+	}
+}
+
+// Chat formatting overhead, following OpenAI's token counting guide
+const (
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+)
+
+// serveEstimate projects the tokens and cost of an OpenAI request without sending it
+func (h *RequestHandler) serveEstimate(w http.ResponseWriter, r *http.Request) {
+	var body estimateBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if body.Model == "" {
+		writeError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	inputTokens, err := countInputTokens(&body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := EstimateResponse{
+		Model:           body.Model,
+		InputTokens:     inputTokens,
+		MaxOutputTokens: body.maxOutput(),
+	}
+
+	price, priced := h.Pricing.Lookup(body.Model)
+	if resp.MaxOutputTokens == 0 {
+		resp.MaxOutputTokens = price.MaxOutputTokens
+	}
+	// Every choice generates its own output
+	if body.N > 1 {
+		resp.MaxOutputTokens *= body.N
+	}
+
+	if priced {
+		inputCost, _ := h.Pricing.Cost(body.Model, resp.InputTokens, 0)
+		outputCost, _ := h.Pricing.Cost(body.Model, 0, resp.MaxOutputTokens)
+		maxCost := inputCost + outputCost
+		resp.InputCost, resp.MaxOutputCost, resp.MaxCost = &inputCost, &outputCost, &maxCost
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// countInputTokens counts the prompt tokens of a chat, completion or embedding request
+func countInputTokens(body *estimateBody) (int64, error) {
+	var total int64
+	count := func(texts []string) error {
+		for _, text := range texts {
+			n, err := tokenizer.Count(body.Model, text)
+			if err != nil {
+				return err
+			}
+			total += int64(n)
+		}
+		return nil
+	}
+
+	if len(body.Messages) > 0 {
+		for _, msg := range body.Messages {
+			total += tokensPerMessage
+			if msg.Name != "" {
+				total += tokensPerName
+			}
+			if err := count(textOf(msg.Content)); err != nil {
+				return 0, err
+			}
+		}
+		return total + tokensPerReply, nil
+	}
+
+	if err := count(textOf(body.Prompt)); err != nil {
+		return 0, err
+	}
+	if err := count(textOf(body.Input)); err != nil {
+		return 0, err
+	}
+	if err := count([]string{body.Instructions}); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// textOf extracts the text from a string, an array of strings, or an array
+// of content parts like {"type":"text","text":"..."}. Arrays of the
+// responses API's input items give the text of each item's content.
+func textOf(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []string{text}
+	}
+
+	var parts []json.RawMessage
+	if json.Unmarshal(raw, &parts) != nil {
+		return nil
+	}
+
+	var texts []string
+	for _, part := range parts {
+		var s string
+		if json.Unmarshal(part, &s) == nil {
+			texts = append(texts, s)
+			continue
+		}
+
+		var content struct {
+			Text    string          `json:"text"`
+			Content json.RawMessage `json:"content"`
+		}
+		if json.Unmarshal(part, &content) != nil {
+			continue
+		}
+		if content.Text != "" {
+			texts = append(texts, content.Text)
+		}
+		texts = append(texts, textOf(content.Content)...)
+	}
+	return texts
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/pricing"
+)
+
+// estimate posts a body to the estimate endpoint
+func estimate(t *testing.T, handler *RequestHandler, body string) (*httptest.ResponseRecorder, EstimateResponse) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("POST", "/proxy/estimate", bytes.NewBufferString(body)))
+
+	var resp EstimateResponse
+	json.Unmarshal(recorder.Body.Bytes(), &resp)
+	return recorder, resp
+}
+
+func TestEstimateEndpoint(t *testing.T) {
+	client := &MockOpenAIClient{ResponseStatus: 200}
+	handler := NewRequestHandler(NewQueueManager(nil, client))
+	handler.Pricing = pricing.NewTable(map[string]config.ModelPrice{
+		"gpt-4": {Input: 30, Output: 60, MaxOutputTokens: 4096},
+	})
+
+	// "hello world" is 2 tokens, plus 3 for the message and 3 to prime the reply
+	recorder, resp := estimate(t, handler,
+		`{"model":"gpt-4","messages":[{"role":"user","content":"hello world"}],"max_tokens":100,"n":2}`)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	if resp.InputTokens != 8 || resp.MaxOutputTokens != 200 {
+		t.Errorf("Expected 8 input and 200 output tokens, got %d and %d", resp.InputTokens, resp.MaxOutputTokens)
+	}
+
+	expected := (8*30.0 + 200*60.0) / 1e6
+	if resp.MaxCost == nil || math.Abs(*resp.MaxCost-expected) > 1e-12 {
+		t.Errorf("Expected max cost %v, got %v", expected, resp.MaxCost)
+	}
+
+	if client.CallCount != 0 {
+		t.Errorf("Expected no upstream calls, got %d", client.CallCount)
+	}
+
+	// Without a limit the model's configured output budget is assumed
+	_, resp = estimate(t, handler, `{"model":"gpt-4","prompt":["hello world","hello"]}`)
+	if resp.InputTokens != 3 || resp.MaxOutputTokens != 4096 {
+		t.Errorf("Expected 3 input and 4096 output tokens, got %d and %d", resp.InputTokens, resp.MaxOutputTokens)
+	}
+
+	// Unpriced models still get token estimates
+	_, resp = estimate(t, handler,
+		`{"model":"local-model","messages":[{"role":"user","content":[{"type":"text","text":"hello world"}]}]}`)
+	if resp.InputTokens != 8 || resp.MaxCost != nil {
+		t.Errorf("Expected 8 input tokens and no cost, got %+v", resp)
+	}
+
+	// Responses count their instructions and the content of each input item
+	_, resp = estimate(t, handler, `{"model":"gpt-4","instructions":"hello world","max_output_tokens":50,`+
+		`"input":[{"role":"user","content":"hello"},{"role":"user","content":[{"type":"input_text","text":"hello world"}]}]}`)
+	if resp.InputTokens != 5 || resp.MaxOutputTokens != 50 {
+		t.Errorf("Expected 5 input and 50 output tokens, got %d and %d", resp.InputTokens, resp.MaxOutputTokens)
+	}
+}
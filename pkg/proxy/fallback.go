@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// Fallback relays requests for paths the proxy doesn't handle itself to the
+// upstream as they are, so new upstream endpoints work before the proxy
+// learns about them. Their bodies are neither read nor rewritten.
+type Fallback struct {
+	// Queue has requests wait their turn in a queue rather than go straight upstream
+	Queue bool
+	// Priority is the queue requests wait in, 0 for the port's
+	Priority int
+	known    []string
+	proxy    *httputil.ReverseProxy
+}
+
+// NewFallback creates a fallback to the upstream base URL target, sending
+// key's current value as the upstream key. Paths matching one of the known
+// patterns are left to the proxy.
+func NewFallback(target string, key func() string, known []string) (*Fallback, error) {
+	base, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback target: %w", err)
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("fallback target %q is not an absolute URL", target)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(base)
+			// The client's key is for the proxy, the upstream gets the proxy's own
+			pr.Out.Header.Set("Authorization", "Bearer "+key())
+			for name := range pr.Out.Header {
+				if strings.HasPrefix(http.CanonicalHeaderKey(name), "X-Proxy-") {
+					pr.Out.Header.Del(name)
+				}
+			}
+			otel.GetTextMapPropagator().Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+		},
+		// Unknown endpoints may stream, so nothing is held back
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(fmt.Sprintf(`{"error":"Error forwarding request: %v"}`, err)))
+		},
+	}
+	return &Fallback{known: known, proxy: proxy}, nil
+}
+
+// Known reports whether the proxy handles a path itself
+func (f *Fallback) Known(reqPath string) bool {
+	for _, pattern := range f.known {
+		if matchPath(pattern, reqPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeHTTP implements http.Handler, relaying the request upstream
+func (f *Fallback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.proxy.ServeHTTP(w, r)
+}
+
+// serveFallback relays a request for an unknown path, straight away or once
+// its queue gets to it. It counts against the queue's concurrency like any
+// other request, but is never preempted or replayed. With a shared queue
+// backend it still waits in this replica's queue.
+func (h *RequestHandler) serveFallback(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, rule config.PathRule) {
+	if h.Fallback.Priority > 0 && rule.Priority == 0 {
+		if queue = h.QueueManager.FindQueue(h.Fallback.Priority); queue == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"No queue configured for this priority"}`))
+			return
+		}
+	}
+
+	if entry := accessEntryFromContext(r.Context()); entry != nil {
+		entry.Priority = queue.Priority
+	}
+	annotateSpan(r, "", queue.Priority)
+
+	done := make(chan struct{})
+	dw := newDeadlineWriter(w)
+	req := &workRequest{
+		Request:        r,
+		ResponseWriter: dw,
+		Done:           done,
+		StartTime:      time.Now(),
+		Tags:           parseTags(r, h.TagKeys),
+		KeyID:          clientKeyID(r),
+		Passthrough:    true,
+		Bypass:         !h.Fallback.Queue || rule.Action == PathBypass,
+		Forward:        h.Fallback,
+	}
+	if !h.submit(w, queue, req) {
+		return
+	}
+	waitDone(r.Context(), dw, done)
+}
+
+// forward runs a request through its Forward handler in place of the
+// upstream client, recording what can be known without reading the bodies
+func (qm *QueueManager) forward(ctx context.Context, req *workRequest, queue *PriorityQueue) {
+	defer close(req.Done)
+	req.claim()
+
+	recorder := &statusRecorder{ResponseWriter: req.ResponseWriter}
+	startTime := time.Now()
+	req.Forward.ServeHTTP(recorder, req.Request.WithContext(ctx))
+	processingTime := time.Since(startTime)
+
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	o := qm.outcome(req, OutcomeCompleted)
+	o.Status = status
+	qm.finish(o, metrics.RequestMetrics{
+		ProcessingTime: processingTime,
+		QueueWaitTime:  req.QueueWait,
+		Tags:           req.Tags,
+	})
+
+	kind := logSuccess
+	if status >= 400 {
+		kind = logError
+	}
+	qm.LogSampler.logf(kind, "Completed fallback request (Path: %s, Priority: %d, Status: %d, Time: %v, Queue wait: %v)\n",
+		req.Request.URL.Path, queue.Priority, status, processingTime, req.QueueWait)
+}
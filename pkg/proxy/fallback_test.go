@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestFallback(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s?%s auth=%s tags=%s body=%s",
+			r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), r.Header.Get("X-Proxy-Tags"), body)
+	}))
+	defer upstream.Close()
+
+	client := &MockOpenAIClient{ResponseBody: `{"id":"test-response"}`, ResponseStatus: http.StatusOK}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}, {Port: 8081, Priority: 2}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	fallback, err := NewFallback(upstream.URL, func() string { return "upstream-key" },
+		[]string{"/v1/chat/completions/**", "/v1/models/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewRequestHandler(qm)
+	handler.Fallback = fallback
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Host = "localhost:8080"
+		req.Header.Set("Authorization", "Bearer client-key")
+		req.Header.Set("X-Proxy-Tags", "team=search")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Known paths go through the proxy as usual
+	if recorder := send("/v1/chat/completions", `{"model":"gpt-4"}`); recorder.Body.String() != `{"id":"test-response"}` {
+		t.Errorf("Expected a known path handled by the proxy, got %q", recorder.Body.String())
+	}
+
+	// Unknown ones are relayed untouched, with the upstream's key and without the proxy's headers
+	want := "POST /v1/batches?limit=2 auth=Bearer upstream-key tags= body=not json"
+	for _, queued := range []bool{false, true} {
+		fallback.Queue = queued
+		fallback.Priority = 2
+		recorder := send("/v1/batches?limit=2", "not json")
+		if recorder.Code != http.StatusOK || recorder.Body.String() != want {
+			t.Errorf("queued %v: expected %q, got %d %q", queued, want, recorder.Code, recorder.Body.String())
+		}
+	}
+	if client.CallCount != 1 {
+		t.Errorf("Expected only the known path sent to the client, got %d calls", client.CallCount)
+	}
+
+	// An unreachable upstream is a bad gateway
+	upstream.Close()
+	if recorder := send("/v1/batches", ""); recorder.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 from an unreachable upstream, got %d", recorder.Code)
+	}
+
+	if _, err := NewFallback("localhost:8080", nil, nil); err == nil {
+		t.Error("Expected a target without a scheme refused")
+	}
+}
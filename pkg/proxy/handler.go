@@ -2,25 +2,85 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/pricing"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
 )
 
 // RequestHandler handles incoming HTTP requests and routes them to the appropriate queue
 type RequestHandler struct {
 	QueueManager *QueueManager
+	Cache        *ResponseCache     // Serves read-only GET endpoints locally when set
+	Limiter      *ratelimit.Limiter // Enforces per-key and org request limits when set
+	// BoostKeys are the key IDs (see KeyID) allowed to send X-Priority-Boost
+	BoostKeys map[string]bool
+	// BoostPriority is the queue priority boosted requests run at (0 = highest configured)
+	BoostPriority int
+	// TagKeys are the X-Proxy-Tags keys recorded in metrics; others are ignored
+	TagKeys map[string]bool
+	// InjectUser sets the OpenAI `user` field to the client's key ID when a request has none
+	InjectUser bool
+	// Pricing prices models for cost estimates
+	Pricing *pricing.Table
+	// QuotaPrecheck rejects requests whose estimated tokens exceed the key's remaining quota before they are queued
+	QuotaPrecheck bool
+	// Maintenance refuses new requests while enabled and tracks in-flight ones for draining
+	Maintenance *Maintenance
+	// Downgrade switches requests to cheaper models while their queue is backed up
+	Downgrade *DowngradePolicy
+	// LargePrompts moves requests with very long prompts to a lower priority queue when set
+	LargePrompts *LargePromptPolicy
+	// Router picks a model, backend and queue for requests when set, see RuleRouter for the config's routes
+	Router Router
+	// Discovery routes models no route names to the backend listing them when set
+	Discovery *ModelDiscovery
+	// Methods decides which HTTP methods are forwarded for each path; nil uses the defaults
+	Methods *MethodPolicy
+	// Paths decides per path whether requests are queued, bypass the queues or are denied; nil queues everything
+	Paths *PathPolicy
+	// StatusCache briefly serves polled status endpoints, such as fine-tuning jobs, locally when set
+	StatusCache *ResponseCache
+	// Fallback relays paths the proxy doesn't handle itself to the upstream as they are when set
+	Fallback *Fallback
+	// Catalogs limit the models each client key sees and may invoke when set
+	Catalogs *ModelCatalogs
+	// Defaults fill in the sampling parameters requests leave out, per model, when set
+	Defaults *ParamDefaults
+	// Trim shortens conversations that don't fit their model's context window when set
+	Trim *ContextTrimmer
+	// local serves the proxy's own /proxy/ endpoints
+	local *http.ServeMux
 }
 
 // NewRequestHandler creates a new request handler
 func NewRequestHandler(qm *QueueManager) *RequestHandler {
-	return &RequestHandler{
+	h := &RequestHandler{
 		QueueManager: qm,
 	}
+	h.local = h.newLocalMux()
+	return h
+}
+
+// newLocalMux routes the proxy's own endpoints, which are answered without going upstream
+func (h *RequestHandler) newLocalMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /proxy/tokenize", serveTokenize)
+	mux.HandleFunc("POST /proxy/estimate", h.serveEstimate)
+	mux.HandleFunc("GET /proxy/ready", func(w http.ResponseWriter, r *http.Request) {
+		h.Maintenance.serveReady(w, r)
+	})
+	return mux
 }
 
 // ServeHTTP implements the http.Handler interface
@@ -36,28 +96,131 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Only allow POST and GET for OpenAI API
-	if r.Method != "POST" && r.Method != "GET" {
+	// Only forward the methods configured for this path
+	methods := h.Methods
+	if methods == nil {
+		methods = NewMethodPolicy(nil)
+	}
+	if !methods.Allowed(r.Method, r.URL.Path) {
+		w.Header().Set("Allow", strings.Join(append([]string{"OPTIONS"}, methods.Methods(r.URL.Path)...), ", "))
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte(`{"error":"Method not allowed"}`))
 		return
 	}
 
-	// Extract the port from the server address
-	portStr := strings.TrimPrefix(r.Host, "localhost:")
-	portStr = strings.TrimPrefix(portStr, "127.0.0.1:")
-	port, err := strconv.Atoi(portStr)
+	// Answer the proxy's utility endpoints without queueing or going upstream
+	if strings.HasPrefix(r.URL.Path, "/proxy/") {
+		h.local.ServeHTTP(w, r)
+		return
+	}
+
+	// Refuse paths that aren't proxied, and answer probes, before they take up any capacity
+	rule := h.Paths.Match(r.URL.Path)
+	if rule.Action == PathDeny {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Path not allowed"}`))
+		return
+	}
+	if rule.Action == PathRespond {
+		respond(w, rule)
+		return
+	}
+
+	// Stop queueing and upstream calls at the deadline the client asks for
+	r, cancel, err := withRequestTimeout(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"error":"Invalid port"}`))
+		w.Write([]byte(`{"error":"Invalid X-Request-Timeout-Ms"}`))
 		return
 	}
+	defer cancel()
+
+	// Refuse new work during maintenance while accepted requests drain
+	if h.Maintenance != nil {
+		done, ok := h.Maintenance.admit(w)
+		if !ok {
+			h.rejected(r, nil, "", http.StatusServiceUnavailable, RejectMaintenance)
+			return
+		}
+		defer done()
+	}
+
+	// Find the queue for the port the request arrived on, or the priority
+	// it was submitted at
+	var queue *PriorityQueue
+	if priority, ok := submittedPriority(r.Context()); ok {
+		if queue = h.QueueManager.FindQueue(priority); queue == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"No queue configured for this priority"}`))
+			return
+		}
+	} else {
+		port, err := listenerPort(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"Invalid port"}`))
+			return
+		}
+		if queue = h.QueueManager.FindQueueByPort(port); queue == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"No queue configured for this port"}`))
+			return
+		}
+	}
+
+	// Send the client to the queue its authorizer names rather than the port's
+	if priority := principalFromContext(r.Context()).Priority; priority > 0 {
+		if queue = h.QueueManager.FindQueue(priority); queue == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"No queue configured for this priority"}`))
+			return
+		}
+	}
 
-	// Find the queue for this port
-	queue := h.QueueManager.FindQueueByPort(port)
-	if queue == nil {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(`{"error":"No queue configured for this port"}`))
+	// Send the path to the queue its rule names rather than the port's
+	if rule.Priority > 0 {
+		if queue = h.QueueManager.FindQueue(rule.Priority); queue == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"No queue configured for this priority"}`))
+			return
+		}
+	}
+
+	// Enforce rate limits before the request takes up queue capacity
+	if h.Limiter != nil && !h.allow(w, r) {
+		h.rejected(r, queue, "", http.StatusTooManyRequests, RejectRateLimited)
+		return
+	}
+
+	// Keep models outside the client's catalog out of sight
+	if h.Catalogs != nil && strings.HasPrefix(r.URL.Path, "/v1/models") {
+		keyID := clientKeyID(r)
+		if model, ok := strings.CutPrefix(r.URL.Path, "/v1/models/"); ok && !h.Catalogs.Allowed(keyID, model) {
+			writeModelNotFound(w, model)
+			return
+		}
+		if _, restricted := h.Catalogs.catalog(keyID); restricted && r.Method == "GET" && r.URL.Path == "/v1/models" {
+			buf := newResponseBuffer()
+			defer h.Catalogs.filterModelList(w, buf, keyID)
+			w = buf
+		}
+	}
+
+	// Relay paths the proxy doesn't know untouched, so new upstream endpoints work before they are supported
+	if h.Fallback != nil && !h.Fallback.Known(r.URL.Path) {
+		h.serveFallback(w, r, queue, rule)
+		return
+	}
+
+	// Refuse keys that have used up this period's token budget
+	if h.QueueManager.Quotas != nil && !h.withinQuota(w, r) {
+		h.rejected(r, queue, "", http.StatusTooManyRequests, RejectQuota)
+		return
+	}
+
+	// Promote urgent requests from keys allowed to boost
+	queue, boosted, ok := h.boost(w, r, queue)
+	if !ok {
 		return
 	}
 
@@ -66,8 +229,15 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var model string
 	var inputTokens int64
 	var tools []string
+	var user string
+	var target upstreamTarget
+	var demoted bool
+
+	// Binary uploads (audio, files) stream to upstream untouched; there is
+	// no JSON in them to extract metadata from or rewrite
+	passthrough := r.Body != nil && r.Body != http.NoBody && !isJSONContent(r.Header.Get("Content-Type"))
 
-	if r.Body != nil {
+	if r.Body != nil && !passthrough {
 		bodyBytes, err = io.ReadAll(r.Body)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
@@ -76,15 +246,51 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		r.Body.Close()
 
-		// Extract metrics data
-		model, inputTokens, tools, err = openai.ExtractRequestMetadata(bytes.NewReader(bodyBytes))
-		if err != nil {
-			// Just log the error, don't fail the request
-			println("Failed to extract request metadata:", err.Error())
+		// Extract metrics data; bodiless requests like GET and DELETE have none
+		if len(bodyBytes) > 0 {
+			model, inputTokens, tools, err = openai.ExtractRequestMetadata(bytes.NewReader(bodyBytes))
+			if err != nil {
+				// Just log the error, don't fail the request
+				println("Failed to extract request metadata:", err.Error())
+			}
+		}
+
+		// Refuse models outside the client's catalog before they take up any capacity
+		if model != "" && !h.Catalogs.Allowed(clientKeyID(r), model) {
+			writeModelNotFound(w, model)
+			return
+		}
+
+		// Attribute the request to an end user, possibly rewriting the body
+		bodyBytes, user = h.attributeUser(r, bodyBytes)
+
+		// Pick the model and backend suited to the request, e.g. long-context models for long prompts
+		var routed *PriorityQueue
+		bodyBytes, model, target, routed = h.route(r, bodyBytes, model, queue.Priority)
+		if routed != nil && !boosted {
+			queue = routed
+		}
+
+		// Keep giant prompts from holding up interactive traffic at their priority
+		queue, demoted = h.demote(w, r, queue, inputTokens, boosted)
+
+		// Trade quality for latency while the queue is backed up
+		bodyBytes, model = h.downgrade(w, r, queue, bodyBytes, model)
+
+		// Fill in the parameters the client left to the platform's defaults for the model it ends up with
+		bodyBytes = h.applyDefaults(w, r, bodyBytes, model)
+
+		// Trim conversations too long for the model rather than let them fail upstream
+		bodyBytes = h.trimContext(w, r, queue, target.Backend, bodyBytes, model)
+
+		// Turn away requests that can't fit the model's context window or the key's budget
+		if !h.admit(w, r, queue, bodyBytes, model) {
+			return
 		}
 
 		// Restore body for the upcoming request
-		r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		setBody(r, bodyBytes)
+		r.ContentLength = int64(len(bodyBytes))
 	}
 
 	// Create a done channel to signal completion
@@ -92,28 +298,274 @@ func (h *RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Create work request
 	req := &workRequest{
-		Request:        r,
-		ResponseWriter: w,
-		Done:           done,
-		StartTime:      time.Now(),
-		Model:          model,
-		InputTokens:    inputTokens,
-		Tools:          tools,
-		RetryCount:     0,
-		Preempted:      false,
-	}
-
-	// Send to appropriate queue
-	select {
-	case queue.Requests <- req:
-		// Request queued successfully
+		Request:         r,
+		ResponseWriter:  w,
+		Done:            done,
+		StartTime:       time.Now(),
+		Model:           model,
+		InputTokens:     inputTokens,
+		Tools:           tools,
+		ReasoningEffort: openai.ExtractReasoningEffort(bodyBytes),
+		RetryCount:      0,
+		Preempted:       false,
+		Boosted:         boosted,
+		Demoted:         demoted,
+		Tags:            parseTags(r, h.TagKeys),
+		User:            user,
+		SessionID:       sessionID(r, bodyBytes),
+		KeyID:           clientKeyID(r),
+		Backend:         target.Backend,
+		Speculative:     target.Speculative,
+		Passthrough:     passthrough,
+		BodySize:        int64(len(bodyBytes)),
+		Bypass:          rule.Action == PathBypass,
+		Schema:          h.QueueManager.Structured.schema(r, bodyBytes),
+	}
+
+	// Note what the access log can't see from outside the proxy
+	if entry := accessEntryFromContext(r.Context()); entry != nil {
+		entry.Model, entry.Priority = model, queue.Priority
+	}
+	annotateSpan(r, model, queue.Priority)
+
+	// Serve read-only endpoints from the local cache when possible
+	if h.Cache != nil && h.Cache.Cacheable(r) {
+		h.serveWithCache(w, r, h.Cache, queue, req)
+		return
+	}
+	if h.StatusCache != nil && h.StatusCache.Cacheable(r) {
+		h.serveWithCache(w, r, h.StatusCache, queue, req)
+		return
+	}
+	// Changes, like cancelling a job, show up in the next status poll
+	if h.StatusCache != nil && r.Method != "GET" {
+		defer h.StatusCache.InvalidateAncestors(r.URL.Path)
+	}
+
+	// Hand the request to the shared queue when running as one of several
+	// replicas; requests bypassing the queues are always run here
+	if h.QueueManager.Backend != nil && !req.Bypass {
+		// Jobs carry their body, so even passthrough bodies are read in full here
+		if passthrough {
+			bodyBytes, err = io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"Failed to read request body"}`))
+				return
+			}
+			r.Body.Close()
+		}
+		h.serveDistributed(w, r, queue, req, bodyBytes)
+		return
+	}
+
+	// Answer retries of writes already sent upstream rather than send them again
+	entry, ok := h.QueueManager.Journal.begin(w, r, req.KeyID)
+	if !ok {
+		return
+	}
+
+	// Let the handler answer for itself if the client stops waiting first
+	dw := newDeadlineWriter(w)
+	req.ResponseWriter = dw
+	var jw *journalWriter
+	if entry != nil {
+		jw = &journalWriter{ResponseWriter: dw}
+		req.ResponseWriter, req.journal = jw, entry
+	}
+
+	if !h.submit(w, queue, req) {
+		h.QueueManager.Journal.forget(entry)
+		return
+	}
+	if entry != nil {
+		go func() {
+			<-done
+			h.QueueManager.Journal.settle(entry, req, jw)
+		}()
+	}
+
+	// Wait for the request to complete
+	waitDone(r.Context(), dw, done)
+}
+
+// priorityKey is the context key for the priority a request was submitted at
+type priorityKey struct{}
+
+// ContextWithPriority returns ctx for a request to be queued at priority
+// rather than by the port it arrived on, for transports other than the HTTP
+// listeners, e.g. gRPC. It is admitted like any other request.
+func ContextWithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// submittedPriority returns the priority a request was submitted at, if any
+func submittedPriority(ctx context.Context) (int, bool) {
+	priority, ok := ctx.Value(priorityKey{}).(int)
+	return priority, ok
+}
+
+// listenerPort returns the port of the listener a request arrived on. The
+// Host header is only consulted for requests that didn't come through a
+// listener, such as ones built in-process.
+func listenerPort(r *http.Request) (int, error) {
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcp, ok := addr.(*net.TCPAddr); ok {
+			return tcp.Port, nil
+		}
+	}
+
+	_, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+// allow checks the rate limiter, writing a 429 with Retry-After when the request is over its limit
+func (h *RequestHandler) allow(w http.ResponseWriter, r *http.Request) bool {
+	limits := principalFromContext(r.Context()).Limits
+	decision, err := h.Limiter.AllowLimit(r.Context(), clientKeyID(r), limits.RequestsPerWindow)
+	if err != nil {
+		// Fail open so a limiter outage doesn't take the proxy down with it
+		fmt.Printf("Rate limiter error: %v\n", err)
+		return true
+	}
+
+	if decision.Limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+	}
+
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"Rate limit exceeded"}`))
+		return false
+	}
+	return true
+}
+
+// withinQuota checks the key's token budget, writing a 429 with Retry-After until the next reset when it is spent
+func (h *RequestHandler) withinQuota(w http.ResponseWriter, r *http.Request) bool {
+	limits := principalFromContext(r.Context()).Limits
+	decision := h.QueueManager.Quotas.AllowBudget(clientKeyID(r), limits.TokensPerPeriod)
+	if decision.Limit > 0 {
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(decision.Limit, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(decision.Remaining, 10))
+	}
+
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"Token quota exceeded"}`))
+		return false
+	}
+	return true
+}
+
+// submit places a request on its queue, rejecting it if the queue is full,
+// holds too many body bytes already or the proxy is shutting down. Requests
+// bypassing the queues start straight away instead. Clients of a queue past
+// its soft watermark are advised to slow down.
+func (h *RequestHandler) submit(w http.ResponseWriter, queue *PriorityQueue, req *workRequest) bool {
+	// The response may start as soon as the request is queued
+	if !req.Bypass {
+		h.signalBackpressure(req.ResponseWriter, queue)
+	}
+	switch err := h.QueueManager.enqueue(queue, req); err {
+	case nil:
+		return true
+	case ErrStopping:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"Proxy is shutting down"}`))
+		h.rejected(req.Request, queue, req.Model, http.StatusServiceUnavailable, RejectShuttingDown)
+		return false
+	case ErrQueueBytes:
+		// A body that could never fit is the client's to shrink
+		if req.BodySize > queue.MaxQueuedBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(`{"error":"Request body is larger than this endpoint queues"}`))
+			return false
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"Service overloaded, please try again later"}`))
+		h.rejected(req.Request, queue, req.Model, http.StatusTooManyRequests, RejectQueueBytes)
+		return false
 	default:
-		// Queue is full
+		// Queue is full, or at its hard watermark; the client may come back once its backlog clears
+		_, delay := h.QueueManager.backpressure(queue)
+		w.Header().Set(BackpressureHeader, "hard")
+		w.Header().Set("Retry-After", delaySeconds(delay))
 		w.WriteHeader(http.StatusTooManyRequests)
 		w.Write([]byte(`{"error":"Service overloaded, please try again later"}`))
+		h.rejected(req.Request, queue, req.Model, http.StatusTooManyRequests, RejectQueueFull)
+		return false
+	}
+}
+
+// serveWithCache answers from cache when fresh, otherwise fetches through
+// the queue (revalidating with the cached ETag) and stores the result.
+// Requests missing while another fetches the same key wait for its result
+// rather than each taking a queue slot.
+func (h *RequestHandler) serveWithCache(w http.ResponseWriter, r *http.Request, cache *ResponseCache, queue *PriorityQueue, req *workRequest) {
+	key := cacheKey(r)
+	entry, fresh := cache.lookup(key)
+	if entry != nil && fresh && !noCache(r) {
+		cache.hit(entry)
+		writeCached(w, r, entry, "HIT")
 		return
 	}
 
-	// Wait for the request to complete
-	<-done
+	if fetching, ok := cache.startFill(key); ok {
+		defer cache.endFill(key)
+	} else if !noCache(r) {
+		select {
+		case <-fetching:
+		case <-r.Context().Done():
+			if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+				writeDeadlineExceeded(w)
+			}
+			return
+		}
+		// Fetch it here too if the other fetch didn't store a response
+		if entry, fresh = cache.lookup(key); entry != nil && fresh {
+			cache.hit(entry)
+			writeCached(w, r, entry, "HIT")
+			return
+		}
+	}
+
+	// Capture the upstream response so it can be cached before reaching the client
+	buf := newResponseBuffer()
+	req.ResponseWriter = buf
+	if entry != nil {
+		req.UpstreamHeaders = http.Header{"If-None-Match": {entry.ETag}}
+	}
+
+	if !h.submit(w, queue, req) {
+		return
+	}
+	select {
+	case <-req.Done:
+	case <-r.Context().Done():
+		// The pipeline only ever writes to buf, so it can be left to finish
+		if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+			writeDeadlineExceeded(w)
+		}
+		return
+	}
+
+	switch {
+	case buf.status == http.StatusNotModified && entry != nil:
+		if refreshed := cache.refresh(key, buf.header); refreshed != nil {
+			entry = refreshed
+		}
+		writeCached(w, r, entry, "REVALIDATED")
+	case buf.status == http.StatusOK:
+		entry = cache.store(key, buf.status, buf.header, buf.body.Bytes())
+		writeCached(w, r, entry, "MISS")
+	default:
+		buf.writeTo(w)
+	}
 }
\ No newline at end of file
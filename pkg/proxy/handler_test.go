@@ -3,6 +3,7 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,11 +13,13 @@ import (
 
 	"github.com/mule-ai/proxy/pkg/config"
 	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/quota"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
 )
 
 func TestHandlerServeHTTP(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 
 	// Create a mock client for testing
 	client := &MockOpenAIClient{
@@ -116,7 +119,7 @@ func TestHandlerServeHTTP(t *testing.T) {
 
 func TestHandlerWithFullQueue(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a mock client with delay to ensure queue fills up
 	client := &MockOpenAIClient{
@@ -181,4 +184,141 @@ func TestHandlerWithFullQueue(t *testing.T) {
 	
 	// Clean up
 	close(req.Done)
-}
\ No newline at end of file
+}
+
+func TestHandlerRateLimit(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, Preemptive: true}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.Limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), time.Minute, 1, 0, nil)
+
+	codes := make([]int, 0, 2)
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Authorization", "Bearer client-key")
+		last = httptest.NewRecorder()
+		handler.ServeHTTP(last, req)
+		codes = append(codes, last.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected [200 429], got %v", codes)
+	}
+
+	if last.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on rate limited response")
+	}
+
+	if last.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("Expected X-RateLimit-Remaining to be 0, got %s", last.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestHandlerQuota(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response","usage":{"prompt_tokens":60,"completion_tokens":50}}`,
+		ResponseStatus: 200,
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, Preemptive: true}}, client)
+	qm.Quotas = quota.NewManager(quota.Daily, time.UTC, 100, nil, false, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+
+	// The first request fits the budget and reports 110 tokens used, exhausting it
+	codes := make([]int, 0, 2)
+	var last *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Authorization", "Bearer client-key")
+		last = httptest.NewRecorder()
+		handler.ServeHTTP(last, req)
+		codes = append(codes, last.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Expected [200 429], got %v", codes)
+	}
+
+	if last.Header().Get("Retry-After") == "" || last.Header().Get("X-Quota-Remaining") != "0" {
+		t.Errorf("Expected quota headers on the rejected response, got %v", last.Header())
+	}
+}
+
+func TestHandlerQueueFromListener(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+
+	server := httptest.NewUnstartedServer(nil)
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	qm := NewQueueManager([]config.Endpoint{{Port: port, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	server.Config.Handler = NewRequestHandler(qm)
+	server.Start()
+	defer server.Close()
+
+	// The queue comes from the listener whatever the client puts in Host,
+	// e.g. a DNS name or a load balancer's address
+	req, _ := http.NewRequest("POST", server.URL+"/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+	req.Host = "api.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestListenerPortFromHost(t *testing.T) {
+	tests := map[string]int{
+		"localhost:8080":      8080,
+		"127.0.0.1:8081":      8081,
+		"[::1]:8082":          8082,
+		"proxy.internal:8083": 8083,
+	}
+	for host, want := range tests {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		req.Host = host
+		if port, err := listenerPort(req); err != nil || port != want {
+			t.Errorf("listenerPort(%s) = %d, %v, expected %d", host, port, err, want)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	req.Host = "proxy.internal"
+	if _, err := listenerPort(req); err == nil {
+		t.Error("Expected a Host without a port to be rejected")
+	}
+}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// IdempotencyKeyHeader lets a client mark its retries of one write as such,
+// so the journal answers them rather than sending the write again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// JournalHeader tells a client its retry was answered from the journal,
+// with the state of the write it repeats
+const JournalHeader = "X-Proxy-Journal"
+
+// Journal entry states
+const (
+	JournalPending   = "pending"   // Accepted, not answered yet
+	JournalCompleted = "completed" // Answered by the upstream; retries get the same answer
+	JournalAmbiguous = "ambiguous" // Failed in a way the upstream may have acted on anyway
+)
+
+// Defaults for the journal
+const (
+	defaultJournalTTL   = 24 * time.Hour
+	defaultAmbiguousTTL = 7 * 24 * time.Hour
+	maxJournalResponse  = 1 << 20 // Responses larger than this aren't kept for replays
+)
+
+// JournalEntry records a write sent upstream and what became of it
+type JournalEntry struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"idempotency_key,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	State     string    `json:"state"`
+	Status    int       `json:"status,omitempty"` // What the upstream answered a completed write with
+	Error     string    `json:"error,omitempty"`  // Why an ambiguous write's fate is unknown
+	Replays   int       `json:"replays"`          // Retries answered from the journal
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	header http.Header
+	body   []byte // nil when the response was too large to keep
+}
+
+// Journal gives writes that mustn't be applied twice, such as file uploads
+// and fine-tune creation, exactly-once semantics. Each is recorded before
+// it is dispatched, and its upstream call is only retried after failures
+// the upstream can't have acted on. A client retrying it with the same
+// Idempotency-Key gets the recorded answer instead of a second write, or a
+// 409 while the first is still running or when a failure left it unknown
+// whether the upstream applied it. Those stay until an admin clears them or
+// AmbiguousTTL passes. Requests are writes when the retry classifier deems
+// them unsafe to replay; writes without an Idempotency-Key have no retries
+// to answer, so they aren't journaled.
+type Journal struct {
+	TTL          time.Duration // How long completed entries are kept (default 24h)
+	AmbiguousTTL time.Duration // How long ambiguous entries are kept unless cleared (default 7 days)
+
+	classifier *RetryClassifier
+	mu         sync.Mutex
+	entries    map[string]*JournalEntry // By journalKey
+	seq        int64
+	now        func() time.Time
+}
+
+// NewJournal journals the requests classifier deems unsafe to replay, nil
+// using the default rules
+func NewJournal(classifier *RetryClassifier) *Journal {
+	if classifier == nil {
+		classifier = NewRetryClassifier(nil)
+	}
+	return &Journal{classifier: classifier, entries: make(map[string]*JournalEntry), now: time.Now}
+}
+
+// journalKey is where an entry is kept: by client key and Idempotency-Key
+func journalKey(keyID, key string) string {
+	return keyID + "\x00" + key
+}
+
+// begin records a write before it is dispatched, returning its entry, or
+// nil for requests that aren't journaled. A retry of a request already
+// recorded, a write or one adopted from another instance, is answered from
+// the journal, reporting false.
+func (j *Journal) begin(w http.ResponseWriter, r *http.Request, keyID string) (*JournalEntry, bool) {
+	if j == nil || r.Method == "GET" || r.Method == "HEAD" {
+		return nil, true
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire()
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return nil, true
+	}
+	if entry, ok := j.entries[journalKey(keyID, key)]; ok {
+		j.answer(w, r, entry)
+		return nil, false
+	}
+	if j.classifier.IsRetryable(r.Method, r.URL.Path) {
+		return nil, true
+	}
+	return j.record(r, keyID, key), true
+}
+
+// adopt records a request moved here from another instance before it runs,
+// so its client collects the answer by retrying with the same
+// Idempotency-Key. It returns nil when the key is already in use here.
+func (j *Journal) adopt(r *http.Request, keyID string) *JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire()
+
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if _, ok := j.entries[journalKey(keyID, key)]; ok || key == "" {
+		return nil
+	}
+	return j.record(r, keyID, key)
+}
+
+// record adds a pending entry for a request; callers hold mu
+func (j *Journal) record(r *http.Request, keyID, key string) *JournalEntry {
+	j.seq++
+	now := j.now()
+	entry := &JournalEntry{
+		ID:        strconv.FormatInt(j.seq, 10),
+		Key:       key,
+		KeyID:     keyID,
+		RequestID: requestID(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		State:     JournalPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	j.entries[journalKey(keyID, key)] = entry
+	return entry
+}
+
+// answer responds to a retry of a recorded write; callers hold mu
+func (j *Journal) answer(w http.ResponseWriter, r *http.Request, entry *JournalEntry) {
+	w.Header().Set(JournalHeader, entry.State)
+	if r.Method != entry.Method || r.URL.Path != entry.Path {
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for another request")
+		return
+	}
+
+	switch {
+	case entry.State == JournalPending:
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+	case entry.State == JournalAmbiguous:
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key failed in a way the upstream may have applied; check before retrying")
+	case entry.body == nil:
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key already completed; its response was too large to keep")
+	default:
+		entry.Replays++
+		for k, v := range entry.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set(JournalHeader, "replayed")
+		w.WriteHeader(entry.Status)
+		w.Write(entry.body)
+	}
+}
+
+// settle records how a journaled request ended, once it is done
+func (j *Journal) settle(entry *JournalEntry, req *workRequest, w *journalWriter) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry.State != JournalPending {
+		// Settled when its upstream call failed
+		return
+	}
+	switch {
+	case req.DispatchedAt.IsZero():
+		// Never sent upstream, so a retry may send it
+		j.drop(entry)
+	case (w.status == 0 || openai.AmbiguousStatus(w.status)) && j.classifier.IsRetryable(entry.Method, entry.Path):
+		// An adopted request that is safe to send again
+		j.drop(entry)
+	case w.status == 0:
+		j.ambiguous(entry, "no response was recorded")
+	case openai.AmbiguousStatus(w.status):
+		j.ambiguous(entry, fmt.Sprintf("upstream answered %d", w.status))
+	default:
+		entry.State, entry.Status, entry.header = JournalCompleted, w.status, w.header
+		if !w.overflow {
+			entry.body = append([]byte{}, w.body.Bytes()...) // Empty rather than nil for an empty body
+		}
+		entry.UpdatedAt = j.now()
+	}
+}
+
+// failed settles a journaled request whose upstream call failed or was
+// abandoned. One the upstream can't have seen may be retried; any other is
+// ambiguous.
+func (j *Journal) failed(req *workRequest, err error) {
+	entry := req.journal
+	if j == nil || entry == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if entry.State != JournalPending {
+		return
+	}
+	if req.DispatchedAt.IsZero() || !openai.AmbiguousError(err) || j.classifier.IsRetryable(entry.Method, entry.Path) {
+		j.drop(entry)
+		return
+	}
+	j.ambiguous(entry, err.Error())
+}
+
+// forget drops the entry of a request turned away before it was queued
+func (j *Journal) forget(entry *JournalEntry) {
+	if j == nil || entry == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.drop(entry)
+}
+
+// ambiguous marks an entry's fate unknown; callers hold mu
+func (j *Journal) ambiguous(entry *JournalEntry, reason string) {
+	entry.State, entry.Error, entry.UpdatedAt = JournalAmbiguous, reason, j.now()
+	log.Printf("Journaled %s %s (request %s) may have been applied upstream: %s",
+		entry.Method, entry.Path, entry.RequestID, reason)
+}
+
+// drop forgets an entry, so the write may be sent again; callers hold mu
+func (j *Journal) drop(entry *JournalEntry) {
+	delete(j.entries, journalKey(entry.KeyID, entry.Key))
+	// Nothing settles it any more
+	entry.State = ""
+}
+
+// expire drops completed entries older than the TTL, and ambiguous ones
+// older than AmbiguousTTL that no admin has cleared. Callers hold mu.
+func (j *Journal) expire() {
+	now := j.now()
+	completed := now.Add(-cmp.Or(max(j.TTL, 0), defaultJournalTTL))
+	ambiguous := now.Add(-cmp.Or(max(j.AmbiguousTTL, 0), defaultAmbiguousTTL))
+	for key, entry := range j.entries {
+		switch {
+		case entry.State == JournalCompleted && entry.UpdatedAt.Before(completed):
+			delete(j.entries, key)
+		case entry.State == JournalAmbiguous && entry.UpdatedAt.Before(ambiguous):
+			log.Printf("Forgetting ambiguous journaled %s %s (request %s), never cleared",
+				entry.Method, entry.Path, entry.RequestID)
+			delete(j.entries, key)
+		}
+	}
+}
+
+// Entries lists the journal's entries in state, or all for "", newest first
+func (j *Journal) Entries(state string) []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.expire()
+
+	var entries []JournalEntry
+	for _, entry := range j.entries {
+		if state == "" || entry.State == state {
+			entries = append(entries, *entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b JournalEntry) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return entries
+}
+
+// Clear drops the entry with id, e.g. once an admin has checked whether an
+// ambiguous write was applied, so its client may retry it. It reports
+// whether there was one.
+func (j *Journal) Clear(id string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, entry := range j.entries {
+		if entry.ID == id {
+			j.drop(entry)
+			return true
+		}
+	}
+	return false
+}
+
+// journalWriter keeps a copy of the response a journaled request gets
+type journalWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool // The body was too large to keep
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *journalWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status, w.header = status, w.ResponseWriter.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *journalWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if w.body.Len()+len(p) > maxJournalResponse {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(p)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher
+func (w *journalWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
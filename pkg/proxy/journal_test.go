@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestJournal(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// Each upstream call fails with the next error, then succeeds
+	var calls []string
+	var failures []error
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		calls = append(calls, path)
+		if len(failures) > 0 {
+			err := failures[0]
+			failures = failures[1:]
+			return nil, err
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id":"ftjob-1"}`)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Journal = NewJournal(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+	admin := NewAdminHandler(qm, nil)
+	admin.Journal = qm.Journal
+
+	send := func(path, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"gpt-4o-mini","training_file":"file-1"}`))
+		r.Host = "localhost:8080"
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+	settled := func(state string) []JournalEntry {
+		// Entries settle once their request is done
+		for {
+			entries := qm.Journal.Entries("")
+			if len(entries) > 0 && entries[0].State != JournalPending {
+				return qm.Journal.Entries(state)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A retry of a completed write gets its answer again without a second write
+	if recorder := send("/v1/fine_tuning/jobs", "job-a"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected the write to go through, got %d", recorder.Code)
+	}
+	if completed := settled(JournalCompleted); len(completed) != 1 || completed[0].Status != http.StatusOK {
+		t.Fatalf("Expected a completed entry, got %+v", completed)
+	}
+	recorder := send("/v1/fine_tuning/jobs", "job-a")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != `{"id":"ftjob-1"}` || recorder.Header().Get(JournalHeader) != "replayed" {
+		t.Errorf("Expected the recorded answer replayed, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if len(calls) != 1 {
+		t.Errorf("Expected one upstream call, got %d", len(calls))
+	}
+	if recorder := send("/v1/files", "job-a"); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a key reused for another request refused with 422, got %d", recorder.Code)
+	}
+
+	// A connection broken after the write was sent leaves it unknown whether it was applied
+	failures = []error{io.ErrUnexpectedEOF}
+	if recorder := send("/v1/fine_tuning/jobs", "job-b"); recorder.Code != http.StatusBadGateway {
+		t.Fatalf("Expected a 502 for the broken connection, got %d", recorder.Code)
+	}
+	ambiguous := settled(JournalAmbiguous)
+	if len(ambiguous) != 1 || ambiguous[0].Key != "job-b" {
+		t.Fatalf("Expected an ambiguous entry, got %+v", ambiguous)
+	}
+	if recorder := send("/v1/fine_tuning/jobs", "job-b"); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected the retry of an ambiguous write refused with 409, got %d", recorder.Code)
+	}
+	if len(calls) != 2 {
+		t.Errorf("Expected the ambiguous write sent once, got %d upstream calls", len(calls))
+	}
+
+	// The admin API lists it, and clearing it lets the client retry
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/journal?state=ambiguous", nil))
+	var listed struct {
+		Entries []JournalEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil || len(listed.Entries) != 1 || listed.Entries[0].Error == "" {
+		t.Fatalf("Expected the ambiguous entry listed with its error, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("DELETE", "/admin/journal/"+listed.Entries[0].ID, nil))
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected the entry cleared, got %d", recorder.Code)
+	}
+	if recorder := send("/v1/fine_tuning/jobs", "job-b"); recorder.Code != http.StatusOK || len(calls) != 3 {
+		t.Errorf("Expected the cleared write sent again, got %d after %d calls", recorder.Code, len(calls))
+	}
+	settled("")
+
+	// A write the upstream never saw may simply be retried
+	failures = []error{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}
+	send("/v1/fine_tuning/jobs", "job-c")
+	if recorder := send("/v1/fine_tuning/jobs", "job-c"); recorder.Code != http.StatusOK || len(calls) != 5 {
+		t.Errorf("Expected a refused write retried upstream, got %d after %d calls", recorder.Code, len(calls))
+	}
+
+	// Requests safe to replay aren't journaled
+	before := len(qm.Journal.Entries(""))
+	send("/v1/chat/completions", "chat-a")
+	if len(qm.Journal.Entries("")) != before {
+		t.Error("Expected chat completions left out of the journal")
+	}
+
+	// nor are writes without a key, having no retries to answer
+	send("/v1/fine_tuning/jobs", "")
+	if len(qm.Journal.Entries("")) != before {
+		t.Error("Expected a write without an Idempotency-Key left out of the journal")
+	}
+
+	// Ambiguous writes nobody clears are forgotten in the end
+	failures = []error{io.ErrUnexpectedEOF}
+	send("/v1/fine_tuning/jobs", "job-d")
+	if ambiguous := settled(JournalAmbiguous); len(ambiguous) != 1 {
+		t.Fatalf("Expected an ambiguous entry, got %+v", ambiguous)
+	}
+	qm.Journal.mu.Lock()
+	qm.Journal.now = func() time.Time { return time.Now().Add(defaultAmbiguousTTL + time.Minute) }
+	qm.Journal.mu.Unlock()
+	if ambiguous := qm.Journal.Entries(JournalAmbiguous); len(ambiguous) != 0 {
+		t.Errorf("Expected the ambiguous entry forgotten after its TTL, got %+v", ambiguous)
+	}
+}
+
+func TestWriteNotRetriedAmbiguously(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The upstream answers every call with a 502, after which it may or may
+	// not have stored the file
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	client := openai.NewClient(upstream.URL, "test-key",
+		openai.WithRetryPolicy(openai.RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond, RetryableStatus: []int{http.StatusBadGateway}}))
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+
+	// Without a journal or an Idempotency-Key, the write is still sent once
+	r := httptest.NewRequest("POST", "/v1/files", strings.NewReader(`{"purpose":"batch"}`))
+	r.Host = "localhost:8080"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusBadGateway || calls.Load() != 1 {
+		t.Errorf("Expected the write sent once, got %d after %d upstream calls", recorder.Code, calls.Load())
+	}
+
+	// Requests safe to replay are still retried
+	calls.Store(0)
+	r = httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	r.Host = "localhost:8080"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if calls.Load() != 4 {
+		t.Errorf("Expected a chat completion retried, got %d upstream calls", calls.Load())
+	}
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"sync"
+)
+
+// KeyConcurrency caps how many requests each client key has in flight at
+// once, independently of its rate limit, so one client's parallel fan-out
+// can't take up every queue's concurrency. Requests of a key at its cap
+// are held aside in their queue while requests of other keys behind them
+// are dispatched, and go first once their key has room. Requests without a key share the cap of
+// "anonymous".
+type KeyConcurrency struct {
+	Default int            // In-flight requests per key (0 = unlimited)
+	Keys    map[string]int // Per-key caps overriding Default, keyed by key ID
+
+	mu      sync.Mutex
+	running map[string]int // In-flight requests, by key ID
+}
+
+// NewKeyConcurrency caps every key at def in-flight requests, and the keys
+// in keys, by key ID, at theirs
+func NewKeyConcurrency(def int, keys map[string]int) *KeyConcurrency {
+	return &KeyConcurrency{Default: def, Keys: keys, running: make(map[string]int)}
+}
+
+// limit returns a key's cap, 0 for none
+func (c *KeyConcurrency) limit(keyID string) int {
+	if limit, ok := c.Keys[keyID]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// room reports whether a key is below its cap
+func (c *KeyConcurrency) room(keyID string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limit := c.limit(keyID)
+	return limit <= 0 || c.running[keyID] < limit
+}
+
+// acquire counts a request of a key as in flight
+func (c *KeyConcurrency) acquire(keyID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running[keyID]++
+}
+
+// release counts a request of a key as no longer in flight
+func (c *KeyConcurrency) release(keyID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running[keyID]--; c.running[keyID] <= 0 {
+		delete(c.running, keyID)
+	}
+}
+
+// dequeueUncapped takes the oldest held request whose key now has room off
+// queue, or else the first request in it whose key is below its cap,
+// holding the ones passed over. It returns nil when every request waiting
+// is capped; requests whose clients are gone are added to gone as with
+// dequeue.
+func (qm *QueueManager) dequeueUncapped(queue *PriorityQueue, gone *[]*workRequest) *workRequest {
+	for _, req := range queue.removeHeld(func(req *workRequest) bool { return req.Request.Context().Err() != nil }) {
+		queue.queuedBytes.Add(-req.BodySize)
+		qm.dropClientGone(req, queue)
+		*gone = append(*gone, req)
+	}
+	if req := queue.unhold(qm.KeyConcurrency); req != nil {
+		return req
+	}
+	for n := len(queue.Requests); n > 0; n-- {
+		req := qm.dequeue(queue, gone)
+		if req == nil {
+			return nil
+		}
+		if qm.KeyConcurrency.room(req.KeyID) {
+			return req
+		}
+		qm.tracef("hold request %s on priority %d queue: key %s at its concurrency cap",
+			traceID(req), queue.Priority, req.KeyID)
+		queue.hold(req)
+	}
+	return nil
+}
+
+// hold sets a request taken off Requests aside until its key has room, its
+// bytes still counted as waiting
+func (q *PriorityQueue) hold(req *workRequest) {
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+	if q.heldBy == nil {
+		q.heldBy = make(map[string][]*workRequest)
+	}
+	q.heldBy[req.KeyID] = append(q.heldBy[req.KeyID], req)
+	q.held.Add(1)
+	q.queuedBytes.Add(req.BodySize)
+}
+
+// unhold takes the oldest held request whose key has room off queue
+func (q *PriorityQueue) unhold(c *KeyConcurrency) *workRequest {
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+	var oldest *workRequest
+	for keyID, held := range q.heldBy {
+		if (oldest == nil || held[0].StartTime.Before(oldest.StartTime)) && c.room(keyID) {
+			oldest = held[0]
+		}
+	}
+	if oldest == nil {
+		return nil
+	}
+	if q.heldBy[oldest.KeyID] = q.heldBy[oldest.KeyID][1:]; len(q.heldBy[oldest.KeyID]) == 0 {
+		delete(q.heldBy, oldest.KeyID)
+	}
+	q.held.Add(-1)
+	q.queuedBytes.Add(-oldest.BodySize)
+	return oldest
+}
+
+// removeHeld takes the held requests matching take off queue, in the order
+// they arrived per key. Their bytes stay counted for the caller to release.
+func (q *PriorityQueue) removeHeld(take func(*workRequest) bool) []*workRequest {
+	if q.held.Load() == 0 {
+		return nil
+	}
+	q.heldMu.Lock()
+	defer q.heldMu.Unlock()
+	var taken []*workRequest
+	for keyID, held := range q.heldBy {
+		kept := held[:0]
+		for _, req := range held {
+			if take(req) {
+				taken = append(taken, req)
+			} else {
+				kept = append(kept, req)
+			}
+		}
+		if len(kept) == 0 {
+			delete(q.heldBy, keyID)
+		} else {
+			q.heldBy[keyID] = kept
+		}
+	}
+	q.held.Add(-int64(len(taken)))
+	return taken
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestKeyConcurrency(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// Upstream calls hang until released, so dispatched requests stay in flight
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			started <- path
+			<-release
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		},
+	}
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2},
+	}, client)
+	qm.sortByPriority()
+	qm.KeyConcurrency = NewKeyConcurrency(1, map[string]int{"key-batch": 2})
+	queue := qm.FindQueue(1)
+
+	newRequest := func(keyID, name string) *workRequest {
+		return &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/chat/completions?n="+name, nil),
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+			KeyID:          keyID,
+		}
+	}
+	first, second, other := newRequest("key-a", "first"), newRequest("key-a", "second"), newRequest("key-b", "other")
+	for _, req := range []*workRequest{first, second, other} {
+		if err := qm.enqueue(queue, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	qm.processNextRequest()
+	if path := <-started; !strings.HasSuffix(path, "first") {
+		t.Fatalf("Expected the first request dispatched, got %s", path)
+	}
+
+	// The key's second request waits while the other key's goes ahead of it
+	qm.processNextRequest()
+	if path := <-started; !strings.HasSuffix(path, "other") {
+		t.Fatalf("Expected the other key's request dispatched, got %s", path)
+	}
+	qm.processNextRequest()
+	select {
+	case path := <-started:
+		t.Fatalf("Expected the capped key's request held back, got %s dispatched", path)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if len(queue.Requests) != 0 || queue.waiting() != 1 {
+		t.Fatalf("Expected the capped request held, %d are queued and %d held", len(queue.Requests), queue.held.Load())
+	}
+
+	// Held requests don't preempt lower priority work they couldn't replace
+	if qm.ShouldPreempt(2) {
+		t.Error("Expected no preemption for requests held by their key's cap")
+	}
+
+	// Once the key's request finishes, the one waiting is dispatched
+	close(release)
+	<-first.Done
+	<-other.Done
+	deadline := time.Now().Add(time.Second)
+	for !qm.KeyConcurrency.room("key-a") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	qm.processNextRequest()
+	if path := <-started; !strings.HasSuffix(path, "second") {
+		t.Errorf("Expected the held request dispatched, got %s", path)
+	}
+	<-second.Done
+
+	if limit := qm.KeyConcurrency.limit("key-batch"); limit != 2 {
+		t.Errorf("Expected the key's own cap of 2, got %d", limit)
+	}
+}
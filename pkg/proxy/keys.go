@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// KeyID derives a stable, non-reversible identifier for a client API key
+// so keys can be tracked without storing them in metrics or shared state
+func KeyID(apiKey string) string {
+	if apiKey == "" {
+		return "anonymous"
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key-" + hex.EncodeToString(sum[:8])
+}
+
+// bearerToken extracts the API key from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// clientKeyID identifies the client key that sent a request. Clients that
+// authenticated as a subject, e.g. with a JWT, are identified by it instead,
+// since the token itself changes every time it is reissued.
+func clientKeyID(r *http.Request) string {
+	if subject := subjectFromContext(r.Context()); subject != "" {
+		return "sub:" + subject
+	}
+	return KeyID(bearerToken(r))
+}
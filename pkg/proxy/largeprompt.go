@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// DemotedHeader reports the queue priority a request was moved from because of its prompt's size
+const DemotedHeader = "X-Proxy-Demoted-From"
+
+// LargePromptPolicy moves requests with very long prompts to a lower
+// priority queue. A giant context holds a backend for long, so mixed in with
+// interactive traffic at the same priority it delays everything behind it.
+type LargePromptPolicy struct {
+	// MinTokens is the estimated input tokens from which a request is moved
+	MinTokens int64
+	// Priority is the queue large requests run in (0 = lowest configured),
+	// e.g. one of its own with little concurrency
+	Priority int
+}
+
+// demote returns the queue a request with inputTokens of prompt should run
+// in, reporting the queue it was moved from in DemotedHeader. Boosted
+// requests and those already at or below the policy's queue stay put.
+func (h *RequestHandler) demote(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, inputTokens int64, boosted bool) (*PriorityQueue, bool) {
+	policy := h.LargePrompts
+	if policy == nil || boosted || inputTokens < policy.MinTokens {
+		return queue, false
+	}
+
+	target := h.largePromptQueue()
+	if target == nil || target.Priority <= queue.Priority {
+		return queue, false
+	}
+
+	fmt.Printf("Demoting request with %d input tokens from priority %d to %d (key: %s, path: %s)\n",
+		inputTokens, queue.Priority, target.Priority, clientKeyID(r), r.URL.Path)
+	w.Header().Set(DemotedHeader, strconv.Itoa(queue.Priority))
+	return target, true
+}
+
+// largePromptQueue returns the queue large requests run in
+func (h *RequestHandler) largePromptQueue() *PriorityQueue {
+	if h.LargePrompts.Priority != 0 {
+		return h.QueueManager.FindQueue(h.LargePrompts.Priority)
+	}
+
+	priorities := h.QueueManager.priorities()
+	if len(priorities) == 0 {
+		return nil
+	}
+	return h.QueueManager.FindQueue(priorities[len(priorities)-1])
+}
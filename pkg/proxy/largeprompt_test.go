@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestLargePromptDemotion(t *testing.T) {
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1},
+		{Port: 8081, Priority: 2},
+		{Port: 8082, Priority: 3},
+	}, &MockOpenAIClient{ResponseBody: `{"id":"test-response"}`, ResponseStatus: http.StatusOK})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.LargePrompts = &LargePromptPolicy{MinTokens: 50}
+	handler.BoostKeys = map[string]bool{KeyID("oncall-key"): true}
+
+	send := func(prompt string, boost bool) (*httptest.ResponseRecorder, metrics.RequestMetrics) {
+		body := fmt.Sprintf(`{"model":"gpt-4","messages":[{"role":"user","content":%q}]}`, prompt)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		if boost {
+			// Promoted from the lowest queue to the highest
+			req.Host = "localhost:8082"
+			req.Header.Set("Authorization", "Bearer oncall-key")
+			req.Header.Set(PriorityBoostHeader, "true")
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder, <-recorded
+	}
+	large := strings.Repeat("word ", 500)
+
+	// Short prompts stay on the queue they arrived at
+	recorder, m := send("Hello", false)
+	if m.Priority != 1 || m.Demoted || recorder.Header().Get(DemotedHeader) != "" {
+		t.Errorf("Expected a short prompt kept at priority 1, got %d (demoted %v)", m.Priority, m.Demoted)
+	}
+
+	// Large ones move to the lowest priority queue
+	recorder, m = send(large, false)
+	if m.Priority != 3 || !m.Demoted {
+		t.Errorf("Expected a large prompt moved to priority 3, got %d (demoted %v)", m.Priority, m.Demoted)
+	}
+	if got := recorder.Header().Get(DemotedHeader); got != "1" {
+		t.Errorf("Expected %s of 1, got %q", DemotedHeader, got)
+	}
+
+	// Or to the queue the policy names
+	handler.LargePrompts.Priority = 2
+	if _, m = send(large, false); m.Priority != 2 {
+		t.Errorf("Expected a large prompt moved to priority 2, got %d", m.Priority)
+	}
+
+	// Boosted requests are left where the boost put them
+	if _, m = send(large, true); m.Priority != 1 || m.Demoted {
+		t.Errorf("Expected a boosted large prompt kept at priority 1, got %d (demoted %v)", m.Priority, m.Demoted)
+	}
+}
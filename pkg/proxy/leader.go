@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Lease is held by one replica at a time and lapses unless it is renewed
+type Lease interface {
+	// Acquire takes the lease for id when no one holds it, or renews it
+	// when id already does, reporting whether id holds it for the next ttl
+	Acquire(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// Release gives the lease up if id holds it
+	Release(ctx context.Context, id string) error
+}
+
+// Elector elects one of the replicas sharing a Lease as leader, to run the
+// duties that would be duplicated if every replica ran them, like resetting
+// quotas on schedule. The leader renews the lease every third of its TTL and
+// steps down when it can't be sure of holding it until the next renewal,
+// before the lease lapses and another replica takes over.
+type Elector struct {
+	ID    string // Names this replica in the election
+	lease Lease
+	ttl   time.Duration
+	mu    sync.Mutex
+	since time.Time // When this replica became leader, zero when it isn't
+	terms int
+}
+
+// LeaderStatus reports a replica's part in the election
+type LeaderStatus struct {
+	ID     string    `json:"id"`
+	Leader bool      `json:"leader"`
+	Since  time.Time `json:"since,omitempty"` // When this replica became leader
+	Terms  int       `json:"terms"`           // Times this replica has become leader
+}
+
+// NewElector creates an elector for the replica id, competing for lease
+// with leases lasting ttl
+func NewElector(lease Lease, id string, ttl time.Duration) *Elector {
+	return &Elector{ID: id, lease: lease, ttl: ttl}
+}
+
+// Run takes part in the election until ctx is cancelled, running duties
+// while this replica leads. Each duty gets a context that is cancelled when
+// the replica steps down. The lease is released on the way out, so another
+// replica takes over without waiting for it to lapse.
+func (e *Elector) Run(ctx context.Context, duties ...func(context.Context)) {
+	interval := e.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var expires time.Time
+	var stop func()
+	defer func() {
+		if stop == nil {
+			return
+		}
+		stop()
+		releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := e.lease.Release(releaseCtx, e.ID); err != nil {
+			log.Printf("Error releasing the leader lease: %v", err)
+		}
+	}()
+
+	for {
+		start := time.Now()
+		held, err := e.lease.Acquire(ctx, e.ID, e.ttl)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Error renewing the leader lease: %v", err)
+			// The lease is still ours until it lapses, if we had it
+			held = stop != nil && time.Now().Add(interval).Before(expires)
+		} else if held {
+			expires = start.Add(e.ttl)
+		}
+
+		switch {
+		case held && stop == nil:
+			stop = e.lead(ctx, duties)
+		case !held && stop != nil:
+			stop()
+			stop = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead starts duties, returning a func that stops them and waits for them to return
+func (e *Elector) lead(ctx context.Context, duties []func(context.Context)) func() {
+	e.mu.Lock()
+	e.since = time.Now()
+	e.terms++
+	e.mu.Unlock()
+	log.Printf("Replica %s is now the leader", e.ID)
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, duty := range duties {
+		wg.Go(func() { duty(ctx) })
+	}
+
+	return func() {
+		e.mu.Lock()
+		e.since = time.Time{}
+		e.mu.Unlock()
+		cancel()
+		wg.Wait()
+		log.Printf("Replica %s is no longer the leader", e.ID)
+	}
+}
+
+// IsLeader reports whether this replica currently leads
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.since.IsZero()
+}
+
+// Status reports this replica's part in the election
+func (e *Elector) Status() LeaderStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return LeaderStatus{ID: e.ID, Leader: !e.since.IsZero(), Since: e.since, Terms: e.terms}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLease checks a Lease is held by one replica at a time
+func testLease(t *testing.T, lease Lease) {
+	t.Helper()
+	ctx := context.Background()
+	acquire := func(id string, want bool) {
+		t.Helper()
+		held, err := lease.Acquire(ctx, id, 5*time.Second)
+		if err != nil || held != want {
+			t.Errorf("Expected %s to hold the lease %v, got %v %v", id, want, held, err)
+		}
+	}
+
+	acquire("a", true)
+	acquire("b", false)
+	acquire("a", true) // Renewed by its holder
+
+	// Only the holder can give the lease up
+	if err := lease.Release(ctx, "b"); err != nil {
+		t.Errorf("Release by another replica failed: %v", err)
+	}
+	acquire("b", false)
+	if err := lease.Release(ctx, "a"); err != nil {
+		t.Errorf("Release failed: %v", err)
+	}
+	acquire("b", true)
+}
+
+func TestElector(t *testing.T) {
+	backend := newTestRedisBackend(t)
+	var running [2]atomic.Int32
+	electors := [2]*Elector{
+		NewElector(backend, "a", 300*time.Millisecond),
+		NewElector(backend, "b", 300*time.Millisecond),
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	for i, ctx := range []context.Context{ctxA, ctxB} {
+		go electors[i].Run(ctx, func(ctx context.Context) {
+			running[i].Add(1)
+			<-ctx.Done()
+			running[i].Add(-1)
+		})
+		// Let the first replica win
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	waitFor := func(leader int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if electors[leader].IsLeader() && !electors[1-leader].IsLeader() &&
+				running[leader].Load() == 1 && running[1-leader].Load() == 0 {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected replica %s to lead alone, got %+v %+v", electors[leader].ID, electors[0].Status(), electors[1].Status())
+	}
+
+	// Renewals keep the leader in place
+	waitFor(0)
+	time.Sleep(400 * time.Millisecond)
+	waitFor(0)
+
+	// A leader shutting down hands over without waiting for its lease to lapse
+	cancelA()
+	waitFor(1)
+	if status := electors[1].Status(); status.Terms != 1 || status.Since.IsZero() {
+		t.Errorf("Expected one term in progress, got %+v", status)
+	}
+}
+
+// failingLease grants the lease until it is told to fail
+type failingLease struct {
+	fail atomic.Bool
+}
+
+func (l *failingLease) Acquire(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	if l.fail.Load() {
+		return false, errors.New("unreachable")
+	}
+	return true, nil
+}
+
+func (l *failingLease) Release(ctx context.Context, id string) error {
+	return nil
+}
+
+func TestElectorStepsDown(t *testing.T) {
+	lease := &failingLease{}
+	elector := NewElector(lease, "a", 300*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopped := make(chan time.Time, 1)
+	go elector.Run(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		stopped <- time.Now()
+	})
+	time.Sleep(50 * time.Millisecond)
+	if !elector.IsLeader() {
+		t.Fatal("Expected the replica to lead")
+	}
+
+	// Losing the lease store steps the leader down before its lease could lapse
+	failed := time.Now()
+	lease.fail.Store(true)
+	select {
+	case at := <-stopped:
+		if at.Sub(failed) > 300*time.Millisecond {
+			t.Errorf("Expected the leader to step down within the lease, took %v", at.Sub(failed))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the leader to step down")
+	}
+	if elector.IsLeader() {
+		t.Error("Expected the replica to no longer lead")
+	}
+}
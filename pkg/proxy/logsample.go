@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// requestLog classifies a per-request log line for sampling
+type requestLog int
+
+const (
+	logSuccess    requestLog = iota // A request that completed without error
+	logError                        // A request that failed or was abandoned
+	logPreemption                   // A request preempted, retried or cut off
+)
+
+// LogSampler thins out per-request log lines so busy proxies don't drown in
+// them: successful requests are sampled, while errors and preemptions are
+// always logged. A nil LogSampler logs every line.
+type LogSampler struct {
+	// SuccessEvery logs one in this many successful requests (0 or 1 logs all)
+	SuccessEvery int
+	// MaxPerSecond caps the request lines logged per second, reporting how
+	// many were left out (0 is unlimited). Errors and preemptions count
+	// towards it but are never left out.
+	MaxPerSecond int
+
+	successes  atomic.Int64
+	mu         sync.Mutex
+	window     time.Time // Start of the second being counted
+	written    int       // Lines written in the window
+	suppressed int       // Lines left out in the window
+}
+
+// NewLogSampler creates a sampler logging one in successEvery successful
+// requests and at most maxPerSecond lines a second
+func NewLogSampler(successEvery, maxPerSecond int) *LogSampler {
+	return &LogSampler{SuccessEvery: successEvery, MaxPerSecond: maxPerSecond}
+}
+
+// logf writes a per-request log line if the sampler lets it through
+func (s *LogSampler) logf(kind requestLog, format string, args ...interface{}) {
+	if s.allow(kind, time.Now()) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// allow reports whether a line of a kind is written at now
+func (s *LogSampler) allow(kind requestLog, now time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if kind == logSuccess && s.SuccessEvery > 1 && (s.successes.Add(1)-1)%int64(s.SuccessEvery) != 0 {
+		return false
+	}
+	if s.MaxPerSecond <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.window) >= time.Second {
+		if s.suppressed > 0 {
+			fmt.Printf("Suppressed %d request log lines over the limit of %d per second\n", s.suppressed, s.MaxPerSecond)
+		}
+		s.window, s.written, s.suppressed = now, 0, 0
+	}
+	if s.written >= s.MaxPerSecond && kind == logSuccess {
+		s.suppressed++
+		return false
+	}
+	s.written++
+	return true
+}
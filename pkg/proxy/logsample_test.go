@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLogSampler(t *testing.T) {
+	// Without a sampler everything is logged
+	var none *LogSampler
+	if !none.allow(logSuccess, time.Now()) {
+		t.Error("Expected a nil sampler to log every line")
+	}
+
+	// One in four successes is logged, every error and preemption
+	s := NewLogSampler(4, 0)
+	now := time.Now()
+	logged := 0
+	for i := 0; i < 8; i++ {
+		if s.allow(logSuccess, now) {
+			logged++
+		}
+		if !s.allow(logError, now) || !s.allow(logPreemption, now) {
+			t.Fatal("Expected errors and preemptions always logged")
+		}
+	}
+	if logged != 2 {
+		t.Errorf("Expected 2 of 8 successes logged, got %d", logged)
+	}
+
+	// Lines over the per-second limit are left out until the next second
+	s = NewLogSampler(0, 2)
+	for i, want := range []bool{true, true, false, false} {
+		if got := s.allow(logSuccess, now); got != want {
+			t.Errorf("Line %d: expected logged %v, got %v", i, want, got)
+		}
+	}
+	if s.suppressed != 2 {
+		t.Errorf("Expected 2 lines counted as suppressed, got %d", s.suppressed)
+	}
+	// except errors and preemptions
+	if !s.allow(logError, now) || !s.allow(logPreemption, now) || s.suppressed != 2 {
+		t.Error("Expected errors and preemptions logged over the limit")
+	}
+	if !s.allow(logSuccess, now.Add(time.Second)) || s.suppressed != 0 {
+		t.Error("Expected the limit to reset after a second")
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Maintenance is the proxy's maintenance switch. While enabled, requests
+// already accepted drain normally but new ones are refused with a 503 and
+// readiness reports not-ready so load balancers shift traffic away.
+type Maintenance struct {
+	mu             sync.RWMutex
+	enabled        bool
+	message        string
+	retryAfter     time.Duration
+	since          time.Time
+	defaultMessage string
+	defaultRetry   time.Duration
+	inflight       atomic.Int64
+	reloading      atomic.Int32
+	starting       atomic.Bool // Held by pre-flight checks until they pass
+}
+
+// MaintenanceStatus reports the maintenance state and drain progress
+type MaintenanceStatus struct {
+	Enabled    bool      `json:"enabled"`
+	Message    string    `json:"message,omitempty"`
+	RetryAfter int       `json:"retry_after,omitempty"` // Seconds
+	Since      time.Time `json:"since,omitempty"`
+	Inflight   int64     `json:"inflight"` // Accepted requests that haven't finished yet
+	Drained    bool      `json:"drained"`  // Enabled and no requests left in flight
+}
+
+// NewMaintenance creates a maintenance switch with the message and
+// Retry-After used when enabling it doesn't specify them
+func NewMaintenance(message string, retryAfter time.Duration) *Maintenance {
+	return &Maintenance{defaultMessage: message, defaultRetry: retryAfter}
+}
+
+// Enable starts maintenance, falling back to the defaults for an empty message or zero retryAfter
+func (m *Maintenance) Enable(message string, retryAfter time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if message == "" {
+		message = m.defaultMessage
+	}
+	if retryAfter <= 0 {
+		retryAfter = m.defaultRetry
+	}
+	if !m.enabled {
+		m.since = time.Now()
+	}
+	m.enabled, m.message, m.retryAfter = true, message, retryAfter
+}
+
+// Disable ends maintenance
+func (m *Maintenance) Disable() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = false
+}
+
+// Enabled reports whether the proxy is in maintenance mode
+func (m *Maintenance) Enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// Status reports the current state
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := MaintenanceStatus{Enabled: m.enabled, Inflight: m.inflight.Load()}
+	if m.enabled {
+		status.Message = m.message
+		status.RetryAfter = int(m.retryAfter.Seconds())
+		status.Since = m.since
+		status.Drained = status.Inflight == 0
+	}
+	return status
+}
+
+// admit tracks a new request, or refuses it with a 503 during maintenance.
+// The returned func must be called when an admitted request finishes.
+func (m *Maintenance) admit(w http.ResponseWriter) (func(), bool) {
+	// Hold the read lock while counting so Enable can't slip in between
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.enabled {
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		writeError(w, http.StatusServiceUnavailable, m.message)
+		return nil, false
+	}
+
+	m.inflight.Add(1)
+	return func() { m.inflight.Add(-1) }, true
+}
+
+// holdReadiness reports not ready, without refusing requests, until the
+// returned func is called, e.g. while a config reload is applied
+func (m *Maintenance) holdReadiness() func() {
+	m.reloading.Add(1)
+	return func() { m.reloading.Add(-1) }
+}
+
+// serveReady reports readiness for load balancer health checks
+func (m *Maintenance) serveReady(w http.ResponseWriter, r *http.Request) {
+	if m != nil && m.Enabled() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "maintenance"})
+		return
+	}
+	if m != nil && m.starting.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "starting"})
+		return
+	}
+	if m != nil && m.reloading.Load() > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "reloading"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// maintenanceRequest toggles maintenance through the admin API
+type maintenanceRequest struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"` // Seconds
+}
+
+// maintenanceStatus reports maintenance state and drain progress
+func (h *AdminHandler) maintenanceStatus(w http.ResponseWriter, r *http.Request) {
+	if h.Maintenance == nil {
+		writeError(w, http.StatusNotFound, "Maintenance mode is not available")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Maintenance.Status())
+}
+
+// maintenanceToggle turns maintenance mode on or off
+func (h *AdminHandler) maintenanceToggle(w http.ResponseWriter, r *http.Request) {
+	if h.Maintenance == nil {
+		writeError(w, http.StatusNotFound, "Maintenance mode is not available")
+		return
+	}
+
+	var req maintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if req.Enabled {
+		h.Maintenance.Enable(req.Message, time.Duration(req.RetryAfter)*time.Second)
+	} else {
+		h.Maintenance.Disable()
+	}
+	writeJSON(w, http.StatusOK, h.Maintenance.Status())
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+		RequestDelay:   200 * time.Millisecond,
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, Preemptive: true}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	maintenance := NewMaintenance("Down for maintenance", time.Minute)
+	handler := NewRequestHandler(qm)
+	handler.Maintenance = maintenance
+	admin := NewAdminHandler(qm, nil)
+	admin.Maintenance = maintenance
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		return req
+	}
+
+	// Start a slow request, then enable maintenance while it is in flight
+	inflight := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(inflight, newRequest())
+		close(finished)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/maintenance",
+		strings.NewReader(`{"enabled":true,"retry_after":120}`)))
+
+	var status MaintenanceStatus
+	json.Unmarshal(recorder.Body.Bytes(), &status)
+	if !status.Enabled || status.Inflight != 1 || status.Drained {
+		t.Errorf("Expected maintenance with one request draining, got %+v", status)
+	}
+
+	// New requests are refused with the configured message
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest())
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "120" {
+		t.Errorf("Expected 503 with Retry-After 120, got %d %v", recorder.Code, recorder.Header())
+	}
+	if !strings.Contains(recorder.Body.String(), "Down for maintenance") {
+		t.Errorf("Expected maintenance message, got %s", recorder.Body.String())
+	}
+
+	// Readiness reports not-ready
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/proxy/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness 503 during maintenance, got %d", recorder.Code)
+	}
+
+	// The in-flight request still completes
+	<-finished
+	if inflight.Code != http.StatusOK {
+		t.Errorf("Expected in-flight request to complete, got %d", inflight.Code)
+	}
+	if status := maintenance.Status(); !status.Drained {
+		t.Errorf("Expected proxy to be drained, got %+v", status)
+	}
+
+	// Turning maintenance off restores service
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/maintenance", strings.NewReader(`{"enabled":false}`)))
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/proxy/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected readiness 200 after maintenance, got %d", recorder.Code)
+	}
+}
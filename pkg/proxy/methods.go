@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// defaultMethodRules allow GET and POST everywhere, and DELETE on the
+// OpenAI resources that can be deleted (files, fine-tuned models,
+// assistants, threads, vector stores, stored completions and responses)
+var defaultMethodRules = []config.MethodRule{
+	{Path: "/v1/files/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/models/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/assistants/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/threads/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/threads/*/messages/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/vector_stores/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/vector_stores/*/files/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/chat/completions/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/v1/responses/*", Methods: []string{"GET", "POST", "DELETE"}},
+	{Path: "/**", Methods: []string{"GET", "POST"}},
+}
+
+// MethodPolicy decides which HTTP methods are forwarded for a path
+type MethodPolicy struct {
+	rules []config.MethodRule
+}
+
+// NewMethodPolicy creates a policy where the given rules take precedence over the defaults
+func NewMethodPolicy(rules []config.MethodRule) *MethodPolicy {
+	all := make([]config.MethodRule, 0, len(rules)+len(defaultMethodRules))
+	all = append(all, rules...)
+	all = append(all, defaultMethodRules...)
+
+	return &MethodPolicy{rules: all}
+}
+
+// Methods returns the methods allowed for a path; the first matching rule wins
+func (p *MethodPolicy) Methods(reqPath string) []string {
+	for _, rule := range p.rules {
+		if matchPath(rule.Path, reqPath) {
+			return rule.Methods
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether a method may be forwarded for a path
+func (p *MethodPolicy) Allowed(method, reqPath string) bool {
+	return slices.ContainsFunc(p.Methods(reqPath), func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestMethodPolicyDefaults(t *testing.T) {
+	policy := NewMethodPolicy(nil)
+
+	tests := []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{"GET", "/v1/models", true},
+		{"POST", "/v1/chat/completions", true},
+		{"DELETE", "/v1/files/file-123", true},
+		{"DELETE", "/v1/models/ft:gpt-4o-mini:acme::abc123", true},
+		{"DELETE", "/v1/threads/thread-1/messages/msg-1", true},
+		{"POST", "/v1/fine_tuning/jobs/ftjob-1/cancel", true},
+		{"DELETE", "/v1/chat/completions", false},
+		{"PUT", "/v1/files/file-123", false},
+		{"PATCH", "/v1/models", false},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.method, tt.path); got != tt.allowed {
+			t.Errorf("Allowed(%s, %s) = %v, expected %v", tt.method, tt.path, got, tt.allowed)
+		}
+	}
+}
+
+func TestMethodPolicyOverrides(t *testing.T) {
+	policy := NewMethodPolicy([]config.MethodRule{
+		{Path: "/v1/files/**", Methods: []string{"GET"}},
+		{Path: "/v1/organization/**", Methods: []string{"get", "post", "delete"}},
+	})
+
+	if policy.Allowed("DELETE", "/v1/files/file-123") {
+		t.Error("Expected configured rule to forbid deleting files")
+	}
+
+	if !policy.Allowed("DELETE", "/v1/organization/users/user-1") {
+		t.Error("Expected configured rule to allow DELETE, whatever its case")
+	}
+
+	// Defaults still apply to anything the overrides don't match
+	if !policy.Allowed("DELETE", "/v1/assistants/asst-1") {
+		t.Error("Expected default rule to allow deleting assistants")
+	}
+}
+
+func TestHandlerForwardsDelete(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	type call struct {
+		method string
+		path   string
+	}
+	calls := make(chan call, 1)
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			calls <- call{method, path}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"id":"file-123","object":"file","deleted":true}`)),
+			}, nil
+		},
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+
+	req := httptest.NewRequest("DELETE", "/v1/files/file-123", nil)
+	req.Host = "localhost:8080"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+	if c := <-calls; c != (call{"DELETE", "/v1/files/file-123"}) {
+		t.Errorf("Expected DELETE to be forwarded upstream, got %+v", c)
+	}
+
+	// Methods not allowed for a path are refused with the ones that are
+	req = httptest.NewRequest("DELETE", "/v1/chat/completions", nil)
+	req.Host = "localhost:8080"
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code 405, got %d", recorder.Code)
+	}
+	if allow := recorder.Header().Get("Allow"); allow != "OPTIONS, GET, POST" {
+		t.Errorf("Expected Allow header to list GET and POST, got %q", allow)
+	}
+}
@@ -0,0 +1,348 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSBackend is a QueueBackend on NATS JetStream. Jobs are published to
+// one subject per priority on a work-queue stream and pulled through a
+// durable consumer per priority. A job is only acknowledged once its
+// result is published, so jobs held by a replica that dies are redelivered
+// after the ack wait.
+type NATSBackend struct {
+	conn         *nats.Conn
+	js           jetstream.JetStream
+	prefix       string
+	jobsStream   string
+	resultStream string
+	ackWait      time.Duration
+	pollInterval time.Duration
+	mu           sync.Mutex
+	consumers    map[int]jetstream.Consumer
+	inflight     map[string]*natsInflight
+	leader       jetstream.KeyValue // Bucket holding the leader lease, created on first use
+}
+
+// natsInflight tracks an unacknowledged job message
+type natsInflight struct {
+	msg  jetstream.Msg
+	stop chan struct{}
+}
+
+// NewNATSBackend connects to NATS and ensures the job and result streams exist
+func NewNATSBackend(ctx context.Context, natsURL, prefix string, ackWait, resultTTL time.Duration) (*NATSBackend, error) {
+	conn, err := nats.Connect(natsURL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error creating JetStream context: %w", err)
+	}
+
+	b := &NATSBackend{
+		conn:         conn,
+		js:           js,
+		prefix:       prefix,
+		jobsStream:   strings.ToUpper(prefix) + "_JOBS",
+		resultStream: strings.ToUpper(prefix) + "_RESULTS",
+		ackWait:      ackWait,
+		pollInterval: time.Second,
+		consumers:    make(map[int]jetstream.Consumer),
+		inflight:     make(map[string]*natsInflight),
+	}
+
+	// Work-queue retention removes a job once it is acknowledged
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      b.jobsStream,
+		Subjects:  []string{prefix + ".jobs.*"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error creating job stream: %w", err)
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     b.resultStream,
+		Subjects: []string{prefix + ".results.*"},
+		MaxAge:   resultTTL,
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error creating result stream: %w", err)
+	}
+
+	return b, nil
+}
+
+// jobSubject is the subject jobs of a priority are published to
+func (b *NATSBackend) jobSubject(priority int) string {
+	return fmt.Sprintf("%s.jobs.%d", b.prefix, priority)
+}
+
+// resultSubject is the subject a job's result is published to
+func (b *NATSBackend) resultSubject(jobID string) string {
+	return fmt.Sprintf("%s.results.%s", b.prefix, jobID)
+}
+
+// consumer returns the durable pull consumer for a priority, creating it on first use
+func (b *NATSBackend) consumer(ctx context.Context, priority int) (jetstream.Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.consumers[priority]; ok {
+		return c, nil
+	}
+
+	c, err := b.js.CreateOrUpdateConsumer(ctx, b.jobsStream, jetstream.ConsumerConfig{
+		Durable:       fmt.Sprintf("%s-priority-%d", b.prefix, priority),
+		FilterSubject: b.jobSubject(priority),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       b.ackWait,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.consumers[priority] = c
+	return c, nil
+}
+
+// Push implements QueueBackend
+func (b *NATSBackend) Push(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.js.Publish(ctx, b.jobSubject(job.Priority), data)
+	return err
+}
+
+// Pop implements QueueBackend
+func (b *NATSBackend) Pop(ctx context.Context, priorities []int) (*Job, error) {
+	deadline := time.Now().Add(b.pollInterval)
+	for {
+		// Check every priority in order so higher priorities always win
+		for _, p := range priorities {
+			c, err := b.consumer(ctx, p)
+			if err != nil {
+				return nil, err
+			}
+
+			batch, err := c.FetchNoWait(1)
+			if err != nil {
+				return nil, err
+			}
+
+			if msg, ok := <-batch.Messages(); ok {
+				return b.claim(msg)
+			}
+			if err := batch.Error(); err != nil {
+				return nil, err
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// claim decodes a job message and keeps it in progress until the job completes
+func (b *NATSBackend) claim(msg jetstream.Msg) (*Job, error) {
+	var job Job
+	if err := json.Unmarshal(msg.Data(), &job); err != nil {
+		// A malformed job will never succeed, so don't redeliver it
+		msg.Term()
+		return nil, fmt.Errorf("invalid job on %s: %w", msg.Subject(), err)
+	}
+
+	inflight := &natsInflight{msg: msg, stop: make(chan struct{})}
+	b.mu.Lock()
+	b.inflight[job.ID] = inflight
+	b.mu.Unlock()
+
+	// Long generations can outlive the ack wait; keep extending it
+	go func() {
+		ticker := time.NewTicker(b.ackWait / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-inflight.stop:
+				return
+			case <-ticker.C:
+				msg.InProgress()
+			}
+		}
+	}()
+
+	return &job, nil
+}
+
+// Complete implements QueueBackend
+func (b *NATSBackend) Complete(ctx context.Context, job *Job, result *JobResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	if _, err := b.js.Publish(ctx, b.resultSubject(job.ID), data); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	inflight, ok := b.inflight[job.ID]
+	delete(b.inflight, job.ID)
+	b.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	close(inflight.stop)
+	return inflight.msg.Ack()
+}
+
+// Wait implements QueueBackend
+func (b *NATSBackend) Wait(ctx context.Context, jobID string) (*JobResult, error) {
+	// An ordered consumer replays the subject from the start, so a result
+	// published before we started waiting is still seen
+	c, err := b.js.OrderedConsumer(ctx, b.resultStream, jetstream.OrderedConsumerConfig{
+		FilterSubjects: []string{b.resultSubject(jobID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		msg, err := c.Next(jetstream.FetchMaxWait(b.pollInterval))
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, jetstream.ErrNoMessages) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var result JobResult
+		if err := json.Unmarshal(msg.Data(), &result); err != nil {
+			return nil, fmt.Errorf("invalid result for job %s: %w", jobID, err)
+		}
+		return &result, nil
+	}
+}
+
+// Depth implements QueueBackend
+func (b *NATSBackend) Depth(ctx context.Context, priority int) (int64, error) {
+	c, err := b.consumer(ctx, priority)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := c.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(info.NumPending), nil
+}
+
+// leaderKey is the key in the leader bucket holding the leading replica's ID
+const leaderKey = "leader"
+
+// leaderBucket returns the key-value bucket holding the leader lease,
+// creating it on first use. Keys in it expire after ttl unless updated.
+func (b *NATSBackend) leaderBucket(ctx context.Context, ttl time.Duration) (jetstream.KeyValue, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.leader != nil {
+		return b.leader, nil
+	}
+	kv, err := b.js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:  strings.ToUpper(b.prefix) + "_LEADER",
+		TTL:     ttl,
+		Storage: jetstream.MemoryStorage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.leader = kv
+	return kv, nil
+}
+
+// Acquire implements Lease
+func (b *NATSBackend) Acquire(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	kv, err := b.leaderBucket(ctx, ttl)
+	if err != nil {
+		return false, err
+	}
+
+	entry, err := kv.Get(ctx, leaderKey)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		_, err = kv.Create(ctx, leaderKey, []byte(id))
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if string(entry.Value()) != id {
+		return false, nil
+	}
+
+	// Updating the key restarts its TTL, unless another replica got there first
+	_, err = kv.Update(ctx, leaderKey, []byte(id), entry.Revision())
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Release implements Lease
+func (b *NATSBackend) Release(ctx context.Context, id string) error {
+	b.mu.Lock()
+	kv := b.leader
+	b.mu.Unlock()
+	if kv == nil {
+		return nil
+	}
+
+	entry, err := kv.Get(ctx, leaderKey)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil || string(entry.Value()) != id {
+		return err
+	}
+	return kv.Delete(ctx, leaderKey, jetstream.LastRevision(entry.Revision()))
+}
+
+// Close implements QueueBackend
+func (b *NATSBackend) Close() error {
+	b.conn.Close()
+	return nil
+}
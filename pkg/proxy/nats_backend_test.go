@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// newTestNATSBackend starts an embedded JetStream server and connects a backend to it
+func newTestNATSBackend(t *testing.T, ackWait time.Duration) *NATSBackend {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	backend, err := NewNATSBackend(context.Background(), srv.ClientURL(), "test", ackWait, time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create NATS backend: %v", err)
+	}
+	backend.pollInterval = 200 * time.Millisecond
+	t.Cleanup(func() { backend.Close() })
+
+	return backend
+}
+
+func TestNATSBackendPriorityOrder(t *testing.T) {
+	backend := newTestNATSBackend(t, time.Minute)
+	ctx := context.Background()
+
+	backend.Push(ctx, &Job{ID: "low-1", Priority: 2})
+	backend.Push(ctx, &Job{ID: "high-1", Priority: 1})
+	backend.Push(ctx, &Job{ID: "low-2", Priority: 2})
+
+	depth, err := backend.Depth(ctx, 2)
+	if err != nil || depth != 2 {
+		t.Errorf("Expected depth 2 for priority 2, got %d (err: %v)", depth, err)
+	}
+
+	// Higher priority jobs come out first, then FIFO within a priority
+	for _, expected := range []string{"high-1", "low-1", "low-2"} {
+		job, err := backend.Pop(ctx, []int{1, 2})
+		if err != nil {
+			t.Fatalf("Failed to pop job: %v", err)
+		}
+		if job == nil || job.ID != expected {
+			t.Fatalf("Expected job %s, got %+v", expected, job)
+		}
+		backend.Complete(ctx, job, &JobResult{Status: 200})
+	}
+
+	// An empty queue returns nil after the poll interval
+	job, err := backend.Pop(ctx, []int{1, 2})
+	if err != nil || job != nil {
+		t.Errorf("Expected no job from empty queues, got %+v (err: %v)", job, err)
+	}
+}
+
+func TestNATSBackendResults(t *testing.T) {
+	backend := newTestNATSBackend(t, time.Minute)
+	ctx := context.Background()
+
+	backend.Push(ctx, &Job{ID: "job-1", Priority: 1})
+	job, err := backend.Pop(ctx, []int{1})
+	if err != nil || job == nil {
+		t.Fatalf("Failed to pop job: %v", err)
+	}
+
+	// Results published before the submitter waits are still delivered
+	backend.Complete(ctx, job, &JobResult{
+		Status: 201,
+		Header: http.Header{"X-Test": {"yes"}},
+		Body:   []byte(`{"ok":true}`),
+	})
+
+	result, err := backend.Wait(ctx, "job-1")
+	if err != nil {
+		t.Fatalf("Failed to wait for result: %v", err)
+	}
+
+	if result.Status != 201 || result.Header.Get("X-Test") != "yes" || string(result.Body) != `{"ok":true}` {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestNATSBackendRedeliversUnacknowledgedJobs(t *testing.T) {
+	backend := newTestNATSBackend(t, 500*time.Millisecond)
+	ctx := context.Background()
+
+	backend.Push(ctx, &Job{ID: "job-1", Priority: 1})
+
+	// Simulate a replica that claimed the job and then died
+	job, err := backend.Pop(ctx, []int{1})
+	if err != nil || job == nil {
+		t.Fatalf("Failed to pop job: %v", err)
+	}
+	backend.mu.Lock()
+	close(backend.inflight[job.ID].stop)
+	delete(backend.inflight, job.ID)
+	backend.mu.Unlock()
+
+	time.Sleep(700 * time.Millisecond)
+
+	redelivered, err := backend.Pop(ctx, []int{1})
+	if err != nil {
+		t.Fatalf("Failed to pop job: %v", err)
+	}
+	if redelivered == nil || redelivered.ID != "job-1" {
+		t.Errorf("Expected job-1 to be redelivered, got %+v", redelivered)
+	}
+}
+
+func TestNATSBackendLease(t *testing.T) {
+	testLease(t, newTestNATSBackend(t, time.Minute))
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// How a request can end
+const (
+	OutcomeCompleted  = "completed"   // The upstream's response was relayed in full, whatever its status
+	OutcomeTruncated  = "truncated"   // A stream was cut off, for higher priority work or because the upstream stalled or ran out of time
+	OutcomeFailed     = "failed"      // The upstream couldn't be reached, or didn't answer within its timeout
+	OutcomeRejected   = "rejected"    // The proxy turned the request away itself, see Reason
+	OutcomeClientGone = "client_gone" // The client stopped waiting before its response
+)
+
+// RequestOutcome is the one record of how a request ended, made once it
+// has, whichever way that was. Metrics, the outcome log and other sinks are
+// all fed from it, so they agree on what happened to each request.
+type RequestOutcome struct {
+	RequestID    string    `json:"request_id,omitempty"`
+	KeyID        string    `json:"key_id,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Path         string    `json:"path"`
+	Priority     int       `json:"priority"` // Priority of the queue the request arrived on
+	Backend      string    `json:"backend,omitempty"`
+	Blocked      string    `json:"blocked,omitempty"` // The output scan check that blocked the response
+	Outcome      string    `json:"outcome"`
+	Reason       string    `json:"reason,omitempty"` // Why a rejected request was turned away, e.g. "queue_full"
+	Status       int       `json:"status,omitempty"` // Status the client was answered with
+	QueuedAt     time.Time `json:"queued_at"`
+	DispatchedAt time.Time `json:"dispatched_at,omitzero"` // When the last attempt was sent upstream
+	FinishedAt   time.Time `json:"finished_at"`
+	Retries      int       `json:"retries"`     // Attempts beyond the first, over requeues and upstream retries
+	Preemptions  int       `json:"preemptions"` // Attempts lost to higher priority work
+	InputTokens  int64     `json:"input_tokens"`
+	OutputTokens int64     `json:"output_tokens"`
+	Cost         float64   `json:"cost,omitempty"` // USD, when the model is priced
+}
+
+// OutcomeSink receives the outcome of every request
+type OutcomeSink interface {
+	RecordOutcome(RequestOutcome)
+}
+
+// OutcomeLog writes outcomes as JSON lines
+type OutcomeLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewOutcomeLog creates a log writing outcomes to out
+func NewOutcomeLog(out io.Writer) *OutcomeLog {
+	return &OutcomeLog{out: out}
+}
+
+// RecordOutcome implements OutcomeSink
+func (l *OutcomeLog) RecordOutcome(o RequestOutcome) {
+	line, err := json.Marshal(o)
+	if err != nil {
+		fmt.Printf("Error encoding request outcome: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(line); err != nil {
+		fmt.Printf("Error writing outcome log: %v\n", err)
+	}
+}
+
+// outcome starts the outcome of req, filling in what the request itself knows
+func (qm *QueueManager) outcome(req *workRequest, outcome string) RequestOutcome {
+	o := RequestOutcome{
+		RequestID:    req.requestID(),
+		KeyID:        req.KeyID,
+		Model:        req.Model,
+		Path:         req.Request.URL.Path,
+		Priority:     req.Priority,
+		Backend:      req.Backend,
+		Outcome:      outcome,
+		QueuedAt:     req.StartTime,
+		Preemptions:  req.preemptions(),
+		Retries:      req.RetryCount,
+		InputTokens:  req.InputTokens,
+		FinishedAt:   time.Now(),
+		DispatchedAt: req.DispatchedAt,
+	}
+	if req.Retries != nil {
+		// The budget also counts the upstream client's own retries
+		o.Retries = req.Retries.Used()
+	}
+	return o
+}
+
+// finish reports how a request ended: detail carries the metrics only some
+// outcomes have, like the tools a response called, and the fields the
+// outcome covers are filled in from it
+func (qm *QueueManager) finish(o RequestOutcome, detail metrics.RequestMetrics) {
+	if cost, ok := qm.Pricing.Cost(o.Model, o.InputTokens, o.OutputTokens); ok {
+		o.Cost = cost
+	}
+
+	detail.Model = o.Model
+	detail.InputTokens = o.InputTokens
+	detail.OutputTokens = o.OutputTokens
+	detail.EndpointPath = o.Path
+	detail.Priority = o.Priority
+	detail.StatusCode = o.Status
+	detail.KeyID = o.KeyID
+	detail.Backend = o.Backend
+	detail.TotalTime = o.FinishedAt.Sub(o.QueuedAt)
+	detail.Rejected = o.Reason
+	detail.ClientGone = o.Outcome == OutcomeClientGone
+	detail.Truncated = o.Outcome == OutcomeTruncated
+	detail.Slow = qm.Slow.observe(o, detail)
+	if collector := metrics.GetCollector(); collector != nil {
+		collector.Collect(detail)
+	}
+
+	for _, sink := range qm.Outcomes {
+		sink.RecordOutcome(o)
+	}
+}
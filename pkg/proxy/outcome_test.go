@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/pricing"
+)
+
+// outcomeRecorder keeps the outcomes it receives
+type outcomeRecorder struct {
+	mu       sync.Mutex
+	outcomes []RequestOutcome
+}
+
+func (r *outcomeRecorder) RecordOutcome(o RequestOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.outcomes = append(r.outcomes, o)
+}
+
+func TestRequestOutcomes(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 2, HardWatermark: 1}}, &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: http.StatusOK,
+	})
+	qm.Pricing = pricing.NewTable(map[string]config.ModelPrice{"gpt-4": {Input: 2}})
+	var log bytes.Buffer
+	sink := &outcomeRecorder{}
+	qm.Outcomes = []OutcomeSink{sink, NewOutcomeLog(&log)}
+	queue := qm.Queues[0]
+	h := NewRequestHandler(qm)
+
+	r := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+	r.Header.Set(RequestIDHeader, "req-1")
+	req := &workRequest{
+		Request:        r,
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+		Model:          "gpt-4",
+		InputTokens:    500,
+	}
+	if !h.submit(req.ResponseWriter, queue, req) {
+		t.Fatal("Expected the first request accepted")
+	}
+
+	// The queue is at its hard watermark, so the next is turned away
+	recorder := httptest.NewRecorder()
+	rejected := &workRequest{
+		Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+		Model:          "gpt-4",
+	}
+	if h.submit(recorder, queue, rejected) {
+		t.Fatal("Expected the second request rejected")
+	}
+
+	qm.processRequest(<-queue.Requests, queue)
+	<-req.Done
+
+	if len(sink.outcomes) != 2 {
+		t.Fatalf("Expected an outcome per request, got %+v", sink.outcomes)
+	}
+	turnedAway, completed := sink.outcomes[0], sink.outcomes[1]
+	if turnedAway.Outcome != OutcomeRejected || turnedAway.Reason != RejectQueueFull || turnedAway.Status != http.StatusTooManyRequests {
+		t.Errorf("Expected a queue_full rejection, got %+v", turnedAway)
+	}
+	if !turnedAway.DispatchedAt.IsZero() {
+		t.Errorf("Expected a rejected request never dispatched, got %v", turnedAway.DispatchedAt)
+	}
+
+	if completed.Outcome != OutcomeCompleted || completed.Status != http.StatusOK || completed.RequestID != "req-1" {
+		t.Errorf("Expected req-1 completed with a 200, got %+v", completed)
+	}
+	if completed.Priority != 2 || completed.Model != "gpt-4" || completed.Retries != 0 || completed.Preemptions != 0 {
+		t.Errorf("Unexpected outcome %+v", completed)
+	}
+	if completed.DispatchedAt.Before(completed.QueuedAt) || completed.FinishedAt.Before(completed.DispatchedAt) {
+		t.Errorf("Expected queued, dispatched and finished in order, got %+v", completed)
+	}
+	if want := float64(completed.InputTokens) * 2 / 1e6; completed.InputTokens == 0 || completed.Cost != want {
+		t.Errorf("Expected a cost of %v for %d input tokens, got %v", want, completed.InputTokens, completed.Cost)
+	}
+
+	// The outcome log has the same records, one per line
+	lines := bytes.Split(bytes.TrimSpace(log.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("Expected two log lines, got %q", log.String())
+	}
+	var logged RequestOutcome
+	if err := json.Unmarshal(lines[1], &logged); err != nil || logged.RequestID != "req-1" || logged.Outcome != OutcomeCompleted {
+		t.Errorf("Expected req-1 logged as completed, got %s (%v)", lines[1], err)
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// Path actions
+const (
+	PathQueue  = "queue"  // Wait in a priority queue (the default)
+	PathBypass = "bypass" // Go straight upstream without queueing or preemption
+	PathDeny   = "deny"   // Refuse without going upstream
+	// PathRespond answers from the proxy itself, whatever the state of the
+	// queues, e.g. a /ping for monitoring probes
+	PathRespond = "respond"
+)
+
+// defaultRespondBody is what respond rules answer without a body of their own
+const defaultRespondBody = `{"status":"ok"}`
+
+// PathPolicy decides how requests are handled by path
+type PathPolicy struct {
+	rules []config.PathRule
+}
+
+// NewPathPolicy creates a policy whose rules are tried in order, the first
+// match winning. Paths no rule matches are queued.
+func NewPathPolicy(rules []config.PathRule) (*PathPolicy, error) {
+	for i, rule := range rules {
+		switch rule.Action {
+		case "":
+			rules[i].Action = PathQueue
+		case PathQueue, PathBypass, PathDeny, PathRespond:
+		default:
+			return nil, fmt.Errorf("unknown action %q for path %s", rule.Action, rule.Path)
+		}
+	}
+	return &PathPolicy{rules: rules}, nil
+}
+
+// Match returns the rule for a path
+func (p *PathPolicy) Match(reqPath string) config.PathRule {
+	if p != nil {
+		for _, rule := range p.rules {
+			if matchPath(rule.Path, reqPath) {
+				return rule
+			}
+		}
+	}
+	return config.PathRule{Path: reqPath, Action: PathQueue}
+}
+
+// respond answers a request to a respond rule's path with 200 and its body
+func respond(w http.ResponseWriter, rule config.PathRule) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(cmp.Or(rule.Body, defaultRespondBody)))
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestPathPolicyMatch(t *testing.T) {
+	policy, err := NewPathPolicy([]config.PathRule{
+		{Path: "/v1/models/**", Action: PathBypass},
+		{Path: "/v1/fine_tuning/**", Action: PathDeny},
+		{Path: "/v1/embeddings", Priority: 3},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create path policy: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		action   string
+		priority int
+	}{
+		{"/v1/models", PathBypass, 0},
+		{"/v1/models/gpt-4o", PathBypass, 0},
+		{"/v1/fine_tuning/jobs", PathDeny, 0},
+		{"/v1/embeddings", PathQueue, 3},
+		{"/v1/chat/completions", PathQueue, 0},
+	}
+	for _, tt := range tests {
+		rule := policy.Match(tt.path)
+		if rule.Action != tt.action || rule.Priority != tt.priority {
+			t.Errorf("Match(%s) = %s at priority %d, expected %s at priority %d",
+				tt.path, rule.Action, rule.Priority, tt.action, tt.priority)
+		}
+	}
+
+	if _, err := NewPathPolicy([]config.PathRule{{Path: "/**", Action: "drop"}}); err == nil {
+		t.Error("Expected an unknown action to be rejected")
+	}
+}
+
+func TestHandlerPathRules(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	forwarded := make(chan string, 1)
+	release := make(chan struct{})
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			forwarded <- path
+			if path == "/v1/chat/completions" {
+				<-release
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+			}, nil
+		},
+	}
+
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2},
+	}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := NewRequestHandler(qm)
+	handler.Paths, _ = NewPathPolicy([]config.PathRule{
+		{Path: "/v1/models", Action: PathBypass},
+		{Path: "/v1/fine_tuning/**", Action: PathDeny},
+		{Path: "/v1/chat/completions", Priority: 2},
+	})
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Denied paths never reach upstream
+	if recorder := send("POST", "/v1/fine_tuning/jobs"); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected status code 403 for a denied path, got %d", recorder.Code)
+	}
+
+	// With the scheduler stopped, only requests bypassing the queues get through
+	if recorder := send("GET", "/v1/models"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code 200 for a bypassed path, got %d", recorder.Code)
+	}
+	if path := <-forwarded; path != "/v1/models" {
+		t.Errorf("Expected /v1/models to be forwarded, got %s", path)
+	}
+
+	// Queued paths land in the queue their rule names, not the port's
+	done := make(chan struct{})
+	go func() {
+		send("POST", "/v1/chat/completions")
+		close(done)
+	}()
+	for len(qm.FindQueue(2).Requests) == 0 {
+		select {
+		case <-done:
+			t.Fatal("Expected the request to wait in the priority 2 queue")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if depth := len(qm.FindQueue(1).Requests); depth != 0 {
+		t.Errorf("Expected the port's own queue to stay empty, got depth %d", depth)
+	}
+
+	go qm.StartScheduler(ctx)
+	<-forwarded
+	close(release)
+	<-done
+}
+
+func TestHandlerPathProbes(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	release := make(chan struct{})
+	forwarded := make(chan string, 2)
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			forwarded <- path
+			if path == "/v1/models" {
+				<-release
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+			}, nil
+		},
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, MaxConcurrent: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.Paths, _ = NewPathPolicy([]config.PathRule{
+		{Path: "/v1/models", Action: PathBypass},
+		{Path: "/ping", Action: PathRespond},
+		{Path: "/healthz", Action: PathRespond, Body: `{"healthy":true}`},
+	})
+	handler.Maintenance = NewMaintenance("", 0)
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// A probe held up upstream doesn't take the queue's only slot
+	probed := make(chan struct{})
+	go func() {
+		send("GET", "/v1/models")
+		close(probed)
+	}()
+	<-forwarded
+	if recorder := send("POST", "/v1/chat/completions"); recorder.Code != http.StatusOK {
+		t.Errorf("Expected the queued request dispatched beside the probe, got %d", recorder.Code)
+	}
+	close(release)
+	<-probed
+
+	// Respond rules are answered by the proxy itself, even during maintenance
+	handler.Maintenance.Enable("", 0)
+	if recorder := send("GET", "/ping"); recorder.Code != http.StatusOK || recorder.Body.String() != `{"status":"ok"}` {
+		t.Errorf("Expected /ping answered with 200 ok, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := send("GET", "/healthz"); recorder.Body.String() != `{"healthy":true}` {
+		t.Errorf("Expected the rule's own body, got %s", recorder.Body.String())
+	}
+	select {
+	case path := <-forwarded:
+		if path != "/v1/chat/completions" {
+			t.Errorf("Expected nothing but the queued request forwarded, got %s", path)
+		}
+	default:
+	}
+	if len(forwarded) != 0 {
+		t.Errorf("Expected respond rules never forwarded, got %s", <-forwarded)
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults for pre-flight checks
+const (
+	defaultPreflightTimeout  = 10 * time.Second
+	defaultPreflightInterval = 15 * time.Second
+)
+
+// PreflightCheck is one thing verified at startup, e.g. that the upstream
+// is reachable
+type PreflightCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Preflight verifies at startup that what the proxy depends on works before
+// it reports ready, so a misconfigured deploy fails its readiness probe, or
+// exits, instead of answering live traffic with 502s. Readiness reports
+// "starting" from NewPreflight until every check has passed.
+type Preflight struct {
+	Checks   []PreflightCheck
+	Timeout  time.Duration // Per check (default 10s)
+	Interval time.Duration // Between rounds while checks fail, when waiting for them to pass (default 15s)
+
+	maintenance *Maintenance
+	release     sync.Once
+}
+
+// NewPreflight holds m's readiness until the checks pass
+func NewPreflight(m *Maintenance) *Preflight {
+	if m != nil {
+		m.starting.Store(true)
+	}
+	return &Preflight{maintenance: m}
+}
+
+// Add registers a check
+func (p *Preflight) Add(name string, check func(ctx context.Context) error) {
+	p.Checks = append(p.Checks, PreflightCheck{Name: name, Check: check})
+}
+
+// Run runs every check at once, logging each result, and reports ready if
+// they all pass. The error joins the failures.
+func (p *Preflight) Run(ctx context.Context) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultPreflightTimeout
+	}
+
+	failures := make([]error, len(p.Checks))
+	var wg sync.WaitGroup
+	for i, check := range p.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			if err := check.Check(checkCtx); err != nil {
+				failures[i] = fmt.Errorf("%s: %w", check.Name, err)
+				log.Printf("Pre-flight check %s failed after %v: %v", check.Name, time.Since(start).Round(time.Millisecond), err)
+				return
+			}
+			log.Printf("Pre-flight check %s passed in %v", check.Name, time.Since(start).Round(time.Millisecond))
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(failures...); err != nil {
+		return err
+	}
+	p.ready()
+	return nil
+}
+
+// RunUntilPassed runs the checks every Interval until they all pass, or ctx
+// is done, keeping the proxy not ready meanwhile
+func (p *Preflight) RunUntilPassed(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultPreflightInterval
+	}
+	for p.Run(ctx) != nil {
+		log.Printf("Not ready until pre-flight checks pass, retrying in %v", interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ready ends the hold on readiness
+func (p *Preflight) ready() {
+	p.release.Do(func() {
+		if p.maintenance != nil {
+			p.maintenance.starting.Store(false)
+		}
+		log.Printf("Pre-flight checks passed, reporting ready")
+	})
+}
+
+// CheckUpstream verifies that the upstream answers and accepts the API key,
+// with the cheap GET /v1/models
+func CheckUpstream(client OpenAIClient) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		resp, err := client.ForwardRequest(ctx, "GET", "/v1/models", nil)
+		if err != nil {
+			return fmt.Errorf("upstream unreachable: %w", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("upstream rejected the API key with a %d", resp.StatusCode)
+		case resp.StatusCode >= 300:
+			return fmt.Errorf("upstream answered /v1/models with a %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
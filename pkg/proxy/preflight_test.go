@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/internal/mockopenai"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestPreflight(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{Script: []mockopenai.Response{
+		{Status: http.StatusUnauthorized, Body: `{"error":{"message":"Incorrect API key provided"}}`},
+		{Body: `{"object":"list","data":[]}`},
+	}}
+	handler := NewRequestHandler(NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client))
+	handler.Maintenance = NewMaintenance("", 0)
+	ready := func() (int, string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/proxy/ready", nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	preflight := NewPreflight(handler.Maintenance)
+	preflight.Add("upstream", CheckUpstream(client))
+	// InfluxDB comes up on the third attempt
+	var pings atomic.Int32
+	preflight.Add("influxdb", func(ctx context.Context) error {
+		if pings.Add(1) < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+
+	// Not ready from the start
+	if code, body := ready(); code != http.StatusServiceUnavailable || !strings.Contains(body, "starting") {
+		t.Errorf("Expected readiness 503 starting before the checks ran, got %d %s", code, body)
+	}
+
+	// A rejected key and an unreachable InfluxDB are both reported
+	err := preflight.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "upstream: upstream rejected the API key with a 401") ||
+		!strings.Contains(err.Error(), "influxdb: connection refused") {
+		t.Errorf("Expected both checks failed, got %v", err)
+	}
+	if code, _ := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness held while checks fail, got %d", code)
+	}
+
+	// Retries report ready once everything passes
+	preflight.Interval = 10 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		preflight.RunUntilPassed(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the checks to pass")
+	}
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("Expected ready after the checks passed, got %d %s", code, body)
+	}
+}
+
+func TestCheckUpstream(t *testing.T) {
+	client := &MockOpenAIClient{Script: []mockopenai.Response{{Status: http.StatusBadGateway}}}
+	if err := CheckUpstream(client)(context.Background()); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("Expected a 502 from /v1/models reported, got %v", err)
+	}
+
+	client = &MockOpenAIClient{}
+	client.CustomForwarder = func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		return nil, errors.New("dial tcp: connection refused")
+	}
+	if err := CheckUpstream(client)(context.Background()); err == nil || !strings.Contains(err.Error(), "unreachable") {
+		t.Errorf("Expected an unreachable upstream reported, got %v", err)
+	}
+}
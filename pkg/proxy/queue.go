@@ -1,29 +1,61 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mule-ai/proxy/pkg/archive"
 	"github.com/mule-ai/proxy/pkg/config"
 	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/pricing"
+	"github.com/mule-ai/proxy/pkg/quota"
+	"github.com/mule-ai/proxy/pkg/usage"
 )
 
-// OpenAIClient defines the interface for an OpenAI API client
+// OpenAIClient defines the interface for an OpenAI API client. Paths
+// include the client's query string, if it sent one.
 type OpenAIClient interface {
 	ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error)
 }
 
 // PriorityQueue represents a queue for requests with specific priority
 type PriorityQueue struct {
-	Port       int
-	Priority   int      // Lower number = higher priority (1 is top)
-	Preemptive bool     // Whether this queue can preempt lower-priority ones
-	Requests   chan *workRequest
+	Port           int
+	Priority       int          // Lower number = higher priority (1 is top)
+	Preemptive     bool         // Whether this queue can preempt lower-priority ones
+	MaxConcurrent  int          // Requests from this queue run at once (0 = unlimited)
+	MaxQueuedBytes int64        // Request body bytes waiting in this queue at once (0 = unlimited)
+	Escalation     []Escalation // When requests arriving here move up, soonest first
+	SharedPorts    []int        // Ports of other endpoints with the same priority, whose requests join this queue
+	SoftWatermark  int          // Requests waiting past which clients are advised to slow down (0 = never)
+	HardWatermark  int          // Requests waiting at which new ones are rejected (0 = the queue's capacity)
+	Requests       chan *workRequest
+	waits          waitStats    // How long recently picked up requests waited
+	clientGone     atomic.Int64 // Requests dropped because their client left while they were queued
+	running        atomic.Int64 // Requests currently being processed
+	escalated      atomic.Int64 // Requests moved from here to a higher queue for waiting too long
+	timedOut       atomic.Int64 // Requests answered from here for waiting past their queue timeout
+	queuedBytes    atomic.Int64 // Body bytes of the requests waiting here, held ones included
+	// heldBy are requests taken off Requests because their key was at its
+	// concurrency cap, by key in the order they arrived, dispatched ahead of
+	// Requests once their key has room. heldMu guards them; held counts them.
+	heldMu sync.Mutex
+	heldBy map[string][]*workRequest
+	held   atomic.Int64
+	// QueueTimeout and UpstreamTimeout split the time a request arriving
+	// here may take between waiting in queues and its upstream call (0 = no limit)
+	QueueTimeout    time.Duration
+	UpstreamTimeout time.Duration
 }
 
 // workRequest encapsulates a single request and its state
@@ -38,33 +70,197 @@ type workRequest struct {
 	InputTokens       int64
 	ProcessingTime    time.Duration
 	Tools             []string
+	ReasoningEffort   string            // Reasoning effort the request asks for, empty if unset
 	RetryCount        int
 	Preempted         bool
+	Boosted           bool              // Promoted to a higher queue with X-Priority-Boost
+	Demoted           bool              // Moved to a lower queue for the size of its prompt
+	Tags              map[string]string // Allowlisted X-Proxy-Tags
+	User              string            // End user the request is made on behalf of
+	SessionID         string            // Conversation the request belongs to, for upstream affinity
+	KeyID             string            // Client key the request is billed to (see KeyID)
+	Backend           string            // Upstream a route picked, empty for the default
+	Speculative       string            // Upstream raced against Backend, empty for none
+	Passthrough       bool              // Body streams from the client unread, so it can't be replayed
+	BodySize          int64             // Bytes of body held while the request is queued
+	Bypass            bool              // Sent straight upstream without queueing, never preempted
+	Forward           http.Handler      // Serves the request in place of the upstream client, e.g. the fallback for unknown paths
+	Priority          int               // Priority of the queue the request arrived on, kept when it is requeued
+	IdleRetries       int               // Times the request was requeued for stalling before its first chunk (see StreamIdleRetries)
+	Retries           *openai.RetryBudget // Retries left to the request over requeues and upstream retries, set on its first attempt
+	RequeuedAt        time.Time     // When the request went back on a queue for another attempt
+	QueueWait         time.Duration // Time spent waiting in queues, over all attempts
+	DispatchedAt      time.Time     // When the latest attempt was sent upstream
+	UpstreamHeaders   http.Header // Extra headers sent upstream, e.g. cache validators
+	Schema            *responseSchema // Schema the response is checked against, nil when it isn't
+	SchemaRetried     bool            // Already retried once for a response violating its schema
+	// stateMu guards the hand-off between the preemption monitor and the response writer
+	stateMu           sync.Mutex
+	responseStarted   bool
+	preempting        bool
+	eventStream       bool // The started response is an SSE stream
+	streamCut         bool // The started stream was cut off for higher priority work
+	// journal records the request when it is a write that mustn't be applied twice
+	journal *JournalEntry
+}
+
+// claim commits the current attempt to the request, exempting it from
+// preemption. It reports false if the preemption monitor got there first.
+func (req *workRequest) claim() bool {
+	req.stateMu.Lock()
+	defer req.stateMu.Unlock()
+	if req.preempting {
+		return false
+	}
+	req.responseStarted = true
+	return true
 }
 
 // QueueManager manages all priority queues
 type QueueManager struct {
 	Queues      []*PriorityQueue
 	OpenAIClient OpenAIClient
+	// StreamIdleTimeout aborts an upstream response that sends nothing for this long (0 disables)
+	StreamIdleTimeout time.Duration
+	// StreamIdleRetries is how many times a request that stalls before its first chunk is requeued
+	StreamIdleRetries int
+	// RetryClassifier decides which requests are safe to replay; unsafe ones are never preempted
+	RetryClassifier *RetryClassifier
+	// Backend shares queued work with other replicas; nil keeps queues in-process
+	Backend     QueueBackend
+	// Ledger accumulates per-key usage for billing when set
+	Ledger      *usage.Ledger
+	// Quotas charges completed requests' tokens to per-key budgets when set
+	Quotas      *quota.Manager
+	// ExposeUpstreamErrors adds the raw upstream error to normalized error responses
+	ExposeUpstreamErrors bool
+	// Archiver records requests and their responses, streamed or not, when set
+	Archiver    *archive.Archiver
+	// Debug records the exchanges of the keys and request IDs admins are looking into when set
+	Debug       *DebugCaptures
+	// Identity passes requests' tenant, key ID and priority upstream when set
+	Identity *IdentityPropagation
+	// RetryBudget caps the retries of a request, over preemption and stall
+	// requeues and upstream retries together (0 = unlimited)
+	RetryBudget int
+	// BackendUsage counts the tokens each upstream serves against its provider's quotas when set
+	BackendUsage *BackendUsage
+	// Pricing puts a cost on each request's outcome when set
+	Pricing *pricing.Table
+	// Outcomes receive the outcome of every request, e.g. the outcome log
+	Outcomes []OutcomeSink
+	// Slow reports requests taking longer than their threshold when set
+	Slow *SlowRequests
+	// Journal keeps writes from being applied twice when set
+	Journal *Journal
+	// Scanner checks generated text before it reaches the client when set
+	Scanner *OutputScanner
+	// Structured checks responses against the json_schema their request asked for when set
+	Structured *StructuredOutputs
+	// WaitWindow is how far back queue wait averages look (default 30s)
+	WaitWindow  time.Duration
+	// SchedulerTick is how long the scheduler sleeps between dispatches (default 10ms)
+	SchedulerTick time.Duration
+	// PreemptCheckInterval is how often running requests check whether to yield (default 50ms)
+	PreemptCheckInterval time.Duration
+	// Trace logs every scheduling decision, for diagnosing starvation
+	Trace       bool
+	// RequeueBoost is how many queues a preempted or stalled request moves up
+	// when it is requeued (0 keeps it in its queue). It never moves into a
+	// preemptive queue.
+	RequeueBoost int
+	// PreemptStreams cuts off streaming responses that have already started
+	// when higher priority work arrives, ending them with a marker event
+	// rather than letting them run to completion
+	PreemptStreams bool
+	// KeyConcurrency caps the requests each client key has in flight when set
+	KeyConcurrency *KeyConcurrency
+	// LogSampler decides which per-request lines are logged; nil logs them all
+	LogSampler  *LogSampler
 	mu          sync.RWMutex
-	stopping    bool
+	stopping    atomic.Bool           // Set under mu; no new requests are accepted once it is
+	inflight    sync.WaitGroup        // Accepted requests that haven't finished
+	overrides   map[int]QueueOverride // Settings an active schedule window changes, by queue port
+}
+
+// Scheduler timings used when none are configured
+const (
+	defaultSchedulerTick        = 10 * time.Millisecond
+	defaultPreemptCheckInterval = 50 * time.Millisecond
+)
+
+// schedulerTick returns how long the scheduler sleeps between dispatches
+func (qm *QueueManager) schedulerTick() time.Duration {
+	if qm.SchedulerTick > 0 {
+		return qm.SchedulerTick
+	}
+	return defaultSchedulerTick
+}
+
+// preemptCheckInterval returns how often running requests check for preemption
+func (qm *QueueManager) preemptCheckInterval() time.Duration {
+	if qm.PreemptCheckInterval > 0 {
+		return qm.PreemptCheckInterval
+	}
+	return defaultPreemptCheckInterval
 }
 
 // NewQueueManager creates a new queue manager with specified priority queues
 func NewQueueManager(endpoints []config.Endpoint, openaiClient OpenAIClient) *QueueManager {
 	queues := make([]*PriorityQueue, 0, len(endpoints))
+	byPriority := make(map[int]*PriorityQueue)
 	for _, ep := range endpoints {
-		queues = append(queues, &PriorityQueue{
-			Port:       ep.Port,
-			Priority:   ep.Priority,
-			Preemptive: ep.Preemptive,
-			Requests:   make(chan *workRequest, 100),
-		})
+		// Endpoints sharing a priority share its queue, which takes the
+		// settings whichever of them sets
+		if q, ok := byPriority[ep.Priority]; ok {
+			q.SharedPorts = append(q.SharedPorts, ep.Port)
+			q.Preemptive = q.Preemptive || ep.Preemptive
+			if q.MaxConcurrent == 0 {
+				q.MaxConcurrent = ep.MaxConcurrent
+			}
+			if q.MaxQueuedBytes == 0 {
+				q.MaxQueuedBytes = int64(ep.MaxQueuedMB) << 20
+			}
+			if len(q.Escalation) == 0 {
+				q.Escalation = newEscalations(ep.Escalate)
+			}
+			if q.SoftWatermark == 0 {
+				q.SoftWatermark = ep.SoftWatermark
+			}
+			if q.HardWatermark == 0 {
+				q.HardWatermark = ep.HardWatermark
+			}
+			if q.QueueTimeout == 0 {
+				q.QueueTimeout = time.Duration(ep.QueueTimeout) * time.Second
+			}
+			if q.UpstreamTimeout == 0 {
+				q.UpstreamTimeout = time.Duration(ep.UpstreamTimeout) * time.Second
+			}
+			continue
+		}
+
+		q := &PriorityQueue{
+			Port:           ep.Port,
+			Priority:       ep.Priority,
+			Preemptive:     ep.Preemptive,
+			MaxConcurrent:  ep.MaxConcurrent,
+			MaxQueuedBytes: int64(ep.MaxQueuedMB) << 20,
+			Escalation:     newEscalations(ep.Escalate),
+			SoftWatermark:  ep.SoftWatermark,
+			HardWatermark:  ep.HardWatermark,
+			Requests:       make(chan *workRequest, config.QueueCapacity),
+			// Budgets are set in seconds
+			QueueTimeout:    time.Duration(ep.QueueTimeout) * time.Second,
+			UpstreamTimeout: time.Duration(ep.UpstreamTimeout) * time.Second,
+		}
+		byPriority[ep.Priority] = q
+		queues = append(queues, q)
 	}
 	
 	return &QueueManager{
 		Queues:      queues,
 		OpenAIClient: openaiClient,
+		RetryClassifier: NewRetryClassifier(nil),
 	}
 }
 
@@ -81,104 +277,574 @@ func (qm *QueueManager) FindQueue(priority int) *PriorityQueue {
 	return nil
 }
 
-// FindQueueByPort gets a queue by port number
+// FindQueueByPort gets the queue requests to a port join
 func (qm *QueueManager) FindQueueByPort(port int) *PriorityQueue {
 	qm.mu.RLock()
 	defer qm.mu.RUnlock()
 	
 	for _, q := range qm.Queues {
-		if q.Port == port {
+		if q.Port == port || slices.Contains(q.SharedPorts, port) {
 			return q
 		}
 	}
 	return nil
 }
 
-// Sort queues by priority (ascending)
+// Sort queues by priority (ascending), as scheduled
 func (qm *QueueManager) sortByPriority() {
-	sort.Slice(qm.Queues, func(i, j int) bool {
-		return qm.Queues[i].Priority < qm.Queues[j].Priority
+	sort.SliceStable(qm.Queues, func(i, j int) bool {
+		return qm.rank(qm.Queues[i]) < qm.rank(qm.Queues[j])
 	})
 }
 
+// rank returns the priority a queue is dispatched at, which a schedule
+// window may change from its configured one. Callers hold mu.
+func (qm *QueueManager) rank(q *PriorityQueue) int {
+	if o, ok := qm.overrides[q.Port]; ok && o.Priority > 0 {
+		return o.Priority
+	}
+	return q.Priority
+}
+
+// concurrencyLimit returns how many requests from a queue may run at once,
+// as scheduled; 0 is unlimited. Callers hold mu.
+func (qm *QueueManager) concurrencyLimit(q *PriorityQueue) int {
+	if o, ok := qm.overrides[q.Port]; ok && o.MaxConcurrent != 0 {
+		return max(o.MaxConcurrent, 0)
+	}
+	return q.MaxConcurrent
+}
+
+// SetQueueOverrides replaces the queue settings changed by schedule windows,
+// keyed by queue port. Queues without an override go back to their
+// configured settings.
+func (qm *QueueManager) SetQueueOverrides(overrides map[int]QueueOverride) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.overrides = overrides
+	qm.sortByPriority()
+}
+
+// SetMaxConcurrent changes the configured concurrency limit of the queue on
+// a port, e.g. when the config is reloaded, reporting whether there is one
+func (qm *QueueManager) SetMaxConcurrent(port, limit int) bool {
+	q := qm.FindQueueByPort(port)
+	if q == nil {
+		return false
+	}
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	q.MaxConcurrent = limit
+	return true
+}
+
 // StartScheduler begins the queue processing and preemption logic
 func (qm *QueueManager) StartScheduler(ctx context.Context) {
+	qm.mu.Lock()
 	qm.sortByPriority()
+	qm.mu.Unlock()
+	tick := qm.schedulerTick()
 	
 	for {
 		select {
 		case <-ctx.Done():
-			qm.stopping = true
-			// Wait for all queues to drain
+			// Nothing queued from here on would be dispatched
+			qm.mu.Lock()
+			qm.stopping.Store(true)
+			qm.mu.Unlock()
 			return
 		default:
-			// Process the highest priority queue with requests
+			// Move up or time out requests that have waited too long, then
+			// process the highest priority queue with requests
+			qm.escalate()
 			qm.processNextRequest()
-			time.Sleep(10 * time.Millisecond)
+			time.Sleep(tick)
 		}
 	}
 }
 
 // processNextRequest finds and processes the highest priority request
 func (qm *QueueManager) processNextRequest() {
+	// Requests whose clients are gone are dropped once the queues are unlocked
+	var gone []*workRequest
+	defer func() {
+		for _, req := range gone {
+			qm.abandon(req)
+		}
+	}()
+
 	qm.mu.RLock()
 	defer qm.mu.RUnlock()
 	
-	// Find the highest priority queue with requests
-	var activeQueue *PriorityQueue
+	// Find the highest priority queue with requests and room to run one
 	for _, q := range qm.Queues {
-		select {
-		case req := <-q.Requests:
-			// Found a request in this queue
-			activeQueue = q
-			
+		if limit := qm.concurrencyLimit(q); limit > 0 && q.running.Load() >= int64(limit) {
+			continue
+		}
+		dequeue := qm.dequeue
+		if qm.KeyConcurrency != nil {
+			dequeue = qm.dequeueUncapped
+		}
+		if req := dequeue(q, &gone); req != nil {
+			qm.tracef("dispatch request %s (model %s) from priority %d queue, %d left queued",
+				traceID(req), req.Model, q.Priority, len(q.Requests))
+
 			// Process the request
-			go qm.processRequest(req, activeQueue)
+			qm.start(req, q)
 			return
+		}
+		// Queue is empty, try the next one
+	}
+}
+
+// start runs a request in the background, counting it as running from the
+// moment it is dispatched so concurrency limits hold between ticks. Requests
+// bypassing the queues take up none of their queue's or key's concurrency,
+// so probes of bypassed paths never hold up queued work.
+func (qm *QueueManager) start(req *workRequest, queue *PriorityQueue) {
+	if req.Bypass {
+		go qm.processRequest(req, queue)
+		return
+	}
+	queue.running.Add(1)
+	qm.KeyConcurrency.acquire(req.KeyID)
+	go func() {
+		defer queue.running.Add(-1)
+		defer qm.KeyConcurrency.release(req.KeyID)
+		qm.processRequest(req, queue)
+	}()
+}
+
+// reserveBytes counts n more body bytes as waiting in the queue, unless
+// they would take it over MaxQueuedBytes
+func (q *PriorityQueue) reserveBytes(n int64) bool {
+	for {
+		queued := q.queuedBytes.Load()
+		if q.MaxQueuedBytes > 0 && queued+n > q.MaxQueuedBytes {
+			return false
+		}
+		if q.queuedBytes.CompareAndSwap(queued, queued+n) {
+			return true
+		}
+	}
+}
+
+// enqueue accepts a request onto its queue, or starts it straight away when
+// it bypasses the queues, counting it as in flight until it is done. It
+// fails with ErrQueueFull when the queue has no room or is at its hard
+// watermark, and ErrStopping once the manager is draining.
+func (qm *QueueManager) enqueue(queue *PriorityQueue, req *workRequest) error {
+	// Holding mu orders every accepted request before Drain starts waiting
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	if qm.stopping.Load() {
+		return ErrStopping
+	}
+
+	if req.Bypass {
+		qm.start(req, queue)
+	} else {
+		if queue.full() {
+			return ErrQueueFull
+		}
+		if !queue.reserveBytes(req.BodySize) {
+			return ErrQueueBytes
+		}
+		select {
+		case queue.Requests <- req:
 		default:
-			// Queue is empty, try the next one
-			continue
+			queue.queuedBytes.Add(-req.BodySize)
+			return ErrQueueFull
 		}
 	}
+
+	qm.inflight.Add(1)
+	go func() {
+		<-req.Done
+		qm.inflight.Done()
+	}()
+	return nil
+}
+
+// Drain stops the manager accepting requests and waits until every request
+// it accepted has finished, or ctx is done. The scheduler has to keep
+// running meanwhile so that queued requests are still dispatched.
+func (qm *QueueManager) Drain(ctx context.Context) error {
+	qm.mu.Lock()
+	qm.stopping.Store(true)
+	qm.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		qm.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tracef logs a scheduling decision when tracing is on
+func (qm *QueueManager) tracef(format string, args ...interface{}) {
+	if qm.Trace {
+		fmt.Printf("TRACE scheduler: "+format+"\n", args...)
+	}
+}
+
+// traceID identifies a request in trace logs by the ID it is access logged under
+func traceID(req *workRequest) string {
+	if id := req.requestID(); id != "" {
+		return id
+	}
+	return "-"
+}
+
+// requestID returns the ID a request is access logged under, or the one its
+// client sent when there is no access log
+func (req *workRequest) requestID() string {
+	return requestID(req.Request)
+}
+
+// preemptions returns how many of a request's attempts were lost to higher
+// priority work rather than to a stalled upstream or a schema violation
+func (req *workRequest) preemptions() int {
+	n := req.RetryCount - req.IdleRetries
+	if req.SchemaRetried {
+		n--
+	}
+	return n
+}
+
+// requestID returns the ID r is access logged under, or the one its client
+// sent when there is no access log
+func requestID(r *http.Request) string {
+	if entry := accessEntryFromContext(r.Context()); entry != nil {
+		return entry.RequestID
+	}
+	return r.Header.Get(RequestIDHeader)
+}
+
+// dequeue takes the next request off a queue, taking off any whose client
+// has disconnected or stopped waiting too, so they don't take up upstream
+// capacity. Those are added to gone, for the caller to abandon once it has
+// unlocked the queues. It returns nil once the queue is empty.
+func (qm *QueueManager) dequeue(queue *PriorityQueue, gone *[]*workRequest) *workRequest {
+	for {
+		select {
+		case req := <-queue.Requests:
+			queue.queuedBytes.Add(-req.BodySize)
+			if req.Priority == 0 {
+				req.Priority = queue.Priority
+			}
+			if req.Request.Context().Err() == nil {
+				return req
+			}
+			qm.tracef("skip request %s on priority %d queue: client gone (%v)",
+				traceID(req), queue.Priority, req.Request.Context().Err())
+			qm.dropClientGone(req, queue)
+			*gone = append(*gone, req)
+		default:
+			return nil
+		}
+	}
+}
+
+// dropClientGone counts a request taken off queue because its client is
+// gone, before it is abandoned
+func (qm *QueueManager) dropClientGone(req *workRequest, queue *PriorityQueue) {
+	queue.clientGone.Add(1)
+
+	queuedAt := req.StartTime
+	if !req.RequeuedAt.IsZero() {
+		queuedAt = req.RequeuedAt
+	}
+
+	req.QueueWait += time.Since(queuedAt)
 }
 
 // ShouldPreempt checks if a higher priority preemptive queue has requests
 func (qm *QueueManager) ShouldPreempt(currentPriority int) bool {
+	return qm.preemptor(currentPriority) != nil
+}
+
+// preemptor returns the higher priority preemptive queue with requests
+// waiting that a request at currentPriority should yield to, nil for none
+func (qm *QueueManager) preemptor(currentPriority int) *PriorityQueue {
 	qm.mu.RLock()
 	defer qm.mu.RUnlock()
 	
-	if qm.stopping {
-		return false
+	if qm.stopping.Load() {
+		return nil
+	}
+
+	// Compare priorities as currently scheduled
+	current := currentPriority
+	for _, q := range qm.Queues {
+		if q.Priority == currentPriority {
+			current = qm.rank(q)
+		}
 	}
 	
 	// Check all higher priority queues that are preemptive
 	for _, q := range qm.Queues {
-		if q.Priority < currentPriority && q.Preemptive && len(q.Requests) > 0 {
-			return true
+		// Requests held back by their key's concurrency cap can't use the room
+		// preemption would make, so only those still in Requests count
+		if qm.rank(q) < current && q.Preemptive && len(q.Requests) > 0 {
+			return q
+		}
+	}
+	return nil
+}
+
+// isRetryable reports whether a request can be replayed from scratch
+func (qm *QueueManager) isRetryable(r *http.Request) bool {
+	classifier := qm.RetryClassifier
+	if classifier == nil {
+		classifier = NewRetryClassifier(nil)
+	}
+	return classifier.IsRetryable(r.Method, r.URL.Path)
+}
+
+// requeue puts a request back on its queue (or a higher one, see
+// RequeueBoost) for another attempt, failing it with a 503 if the queue has
+// no room. The retry is the same request to the client, so it keeps its
+// arrival time, priority and attribution rather than starting afresh. It
+// goes to the backend the request was routed to; no fallback is tried.
+func (qm *QueueManager) requeue(req *workRequest, queue *PriorityQueue) bool {
+	// Create a new request object since the old one is being used
+	newReq := &workRequest{
+		// Keep the client's context so its deadline and cancellation still apply
+		Request:         req.Request.Clone(req.Request.Context()),
+		ResponseWriter:  req.ResponseWriter,
+		Done:            req.Done,
+		StartTime:       req.StartTime,
+		Model:           req.Model,
+		InputTokens:     req.InputTokens,
+		Tools:           req.Tools,
+		ReasoningEffort: req.ReasoningEffort,
+		RetryCount:      req.RetryCount,
+		Preempted:       req.Preempted,
+		Boosted:         req.Boosted,
+		Demoted:         req.Demoted,
+		Tags:            req.Tags,
+		User:            req.User,
+		SessionID:       req.SessionID,
+		KeyID:           req.KeyID,
+		Priority:        req.Priority,
+		IdleRetries:     req.IdleRetries,
+		Retries:         req.Retries,
+		RequeuedAt:      time.Now(),
+		QueueWait:       req.QueueWait,
+		UpstreamHeaders: req.UpstreamHeaders,
+		Backend:         req.Backend,
+		Speculative:     req.Speculative,
+		Passthrough:     req.Passthrough,
+		BodySize:        req.BodySize,
+		Schema:          req.Schema,
+		SchemaRetried:   req.SchemaRetried,
+	}
+	// The attempt before drained the body, so the retry reads it afresh
+	if newReq.Request.GetBody != nil {
+		if body, err := newReq.Request.GetBody(); err == nil {
+			newReq.Request.Body = body
+		}
+	}
+	queue = qm.requeueTarget(req, queue)
+	
+	// Send to its queue for retry; it was accepted already, so it is let in
+	// over the queue's byte limit
+	queue.queuedBytes.Add(newReq.BodySize)
+	select {
+	case queue.Requests <- newReq:
+		qm.tracef("requeue request %s on priority %d queue for attempt %d",
+			traceID(req), queue.Priority, req.RetryCount+1)
+		return true
+	default:
+		// Queue is full, this shouldn't happen but handle it
+		queue.queuedBytes.Add(-newReq.BodySize)
+		fmt.Printf("ERROR: Could not requeue request, queue is full\n")
+		
+		// Write error response
+		req.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+		req.ResponseWriter.Write([]byte(`{"error":"Service overloaded, please try again later"}`))
+		qm.recordRejection(req.Request, req.Priority, req.Model, http.StatusServiceUnavailable, RejectQueueFull)
+		close(req.Done)
+		return false
+	}
+}
+
+// setBody makes body the request body, kept so a retry can send it again
+// through GetBody
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// requeueTarget returns the queue req, requeued from queue, goes to:
+// RequeueBoost queues above the one it arrived on, stopping short of
+// preemptive ones. The boost doesn't compound over repeated requeues, and
+// never moves a request below queue, e.g. once it has escalated.
+func (qm *QueueManager) requeueTarget(req *workRequest, queue *PriorityQueue) *PriorityQueue {
+	if qm.RequeueBoost <= 0 {
+		return queue
+	}
+	
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	for _, q := range qm.Queues {
+		if q.Priority != req.Priority {
+			continue
+		}
+		if target := qm.higherQueue(q, qm.RequeueBoost); qm.rank(target) < qm.rank(queue) {
+			return target
+		}
+	}
+	return queue
+}
+
+// higherQueue returns the queue scheduled steps places ahead of queue,
+// stopping short of preemptive queues. Callers hold mu.
+func (qm *QueueManager) higherQueue(queue *PriorityQueue, steps int) *PriorityQueue {
+	// Queues are sorted highest priority first
+	for i, q := range qm.Queues {
+		if q != queue {
+			continue
+		}
+		target := queue
+		for j := i - 1; j >= 0 && j >= i-steps && !qm.Queues[j].Preemptive; j-- {
+			target = qm.Queues[j]
 		}
+		return target
 	}
-	return false
+	return queue
+}
+
+// abandon gives up on a request whose client has gone away or whose
+// deadline has passed, answering the latter with a 504
+func (qm *QueueManager) abandon(req *workRequest) {
+	o := qm.outcome(req, OutcomeClientGone)
+	err := req.Request.Context().Err()
+	qm.Journal.failed(req, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeDeadlineExceeded(req.ResponseWriter)
+		o.Status = http.StatusGatewayTimeout
+	}
+	qm.LogSampler.logf(logError, "Abandoned request for model %s (Path: %s): %v\n", req.Model, req.Request.URL.Path, err)
+	qm.finish(o, metrics.RequestMetrics{
+		QueueWaitTime: req.QueueWait,
+		RetryCount:    req.RetryCount,
+		Preempted:     req.Preempted,
+		Tags:          req.Tags,
+		User:          req.User,
+	})
+	close(req.Done)
 }
 
 // processRequest handles a single work request and ensures retry on preemption
 func (qm *QueueManager) processRequest(req *workRequest, queue *PriorityQueue) {
-	// Create a new context for this request that can be cancelled for preemption
-	ctx, cancel := context.WithCancel(context.Background())
+	if req.Priority == 0 {
+		req.Priority = queue.Priority
+	}
+	if req.Retries == nil {
+		req.Retries = openai.NewRetryBudget(qm.RetryBudget)
+	}
+
+	// Don't spend an upstream call on a client that has stopped waiting
+	if req.Request.Context().Err() != nil {
+		qm.abandon(req)
+		return
+	}
+
+	// Create a new context for this request that can be cancelled for
+	// preemption, and ends with the client's own
+	ctx, cancel := context.WithCancel(req.Request.Context())
 	req.PreemptCtx = ctx
 	req.PreemptCancel = cancel
 	
+	// Track how long requests wait to be picked up; the queue's average
+	// doesn't count retries, the request's own total does
+	if !req.Bypass {
+		queuedAt := req.StartTime
+		if !req.RequeuedAt.IsZero() {
+			queuedAt = req.RequeuedAt
+		}
+		wait := time.Since(queuedAt)
+		req.QueueWait += wait
+		if req.RetryCount == 0 {
+			queue.waits.record(wait, qm.waitWindow())
+		}
+	}
+	
+	// Requests for paths the proxy doesn't handle are relayed as they are
+	if req.Forward != nil {
+		qm.forward(ctx, req, queue)
+		cancel()
+		return
+	}
+	
+	// Stop monitoring once this attempt is finished, whatever the outcome
+	attemptDone := make(chan struct{})
+	defer close(attemptDone)
+	
+	// Requests with side effects must run to completion rather than be replayed
+	retryable := !req.Passthrough && !req.Bypass && qm.isRetryable(req.Request)
+	
 	// Start a goroutine to monitor for preemption
+	interval := qm.preemptCheckInterval()
 	go func() {
+		if !retryable {
+			qm.tracef("request %s on priority %d is exempt from preemption: it can't be replayed",
+				traceID(req), queue.Priority)
+			return
+		}
+		
 		for {
 			select {
 			case <-req.Done:
 				// Request completed normally
 				return
-			case <-time.After(50 * time.Millisecond):
+			case <-attemptDone:
+				// This attempt ended without completing the request (e.g. requeued)
+				return
+			case <-time.After(interval):
 				// Check for preemption periodically
-				if qm.ShouldPreempt(queue.Priority) {
+				if by := qm.preemptor(queue.Priority); by != nil {
+					// Once the client has received headers the request can't be
+					// replayed, so let it run to completion
+					req.stateMu.Lock()
+					if req.responseStarted && req.eventStream && qm.PreemptStreams {
+						// A stream can't be replayed either, but it can be cut short
+						req.streamCut = true
+						cancel()
+						req.stateMu.Unlock()
+						qm.tracef("cut off stream %s on priority %d: %d waiting on preemptive priority %d queue",
+							traceID(req), queue.Priority, len(by.Requests), by.Priority)
+						return
+					}
+					if req.responseStarted {
+						req.stateMu.Unlock()
+						qm.tracef("not preempting request %s on priority %d: its response has started",
+							traceID(req), queue.Priority)
+						return
+					}
+					if !req.Retries.Spend() {
+						req.stateMu.Unlock()
+						qm.tracef("not preempting request %s on priority %d: its retry budget is spent",
+							traceID(req), queue.Priority)
+						return
+					}
+					
 					// Cancel the current request
+					req.preempting = true
 					cancel()
+					req.stateMu.Unlock()
+					qm.tracef("preempt request %s on priority %d: %d waiting on preemptive priority %d queue",
+						traceID(req), queue.Priority, len(by.Requests), by.Priority)
 					
 					// Only requeue if this is a lower priority queue
 					if queue.Priority > 1 {
@@ -186,32 +852,9 @@ func (qm *QueueManager) processRequest(req *workRequest, queue *PriorityQueue) {
 						req.Preempted = true
 						req.RetryCount++
 						
-						// Create a new request object since the old one is being used
-						newReq := &workRequest{
-							Request:        req.Request.Clone(context.Background()),
-							ResponseWriter: req.ResponseWriter,
-							Done:           req.Done,
-							StartTime:      req.StartTime,
-							Model:          req.Model,
-							InputTokens:    req.InputTokens,
-							Tools:          req.Tools,
-							RetryCount:     req.RetryCount,
-							Preempted:      req.Preempted,
-						}
-						
-						// Send to its queue for retry
-						select {
-						case queue.Requests <- newReq:
-							fmt.Printf("Preempted request for model %s, priority %d. Retrying (attempt %d)\n", 
+						if qm.requeue(req, queue) {
+							qm.LogSampler.logf(logPreemption, "Preempted request for model %s, priority %d. Retrying (attempt %d)\n", 
 								req.Model, queue.Priority, req.RetryCount+1)
-						default:
-							// Queue is full, this shouldn't happen but handle it
-							fmt.Printf("ERROR: Could not requeue preempted request, queue is full\n")
-							
-							// Write error response
-							req.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
-							req.ResponseWriter.Write([]byte(`{"error":"Service overloaded, please try again later"}`))
-							close(req.Done)
 						}
 					}
 					return
@@ -224,62 +867,500 @@ func (qm *QueueManager) processRequest(req *workRequest, queue *PriorityQueue) {
 	httpReq := req.Request.Clone(ctx)
 	
 	// Forward the request to OpenAI
+	forwardCtx, header := qm.Identity.propagate(ctx, req, upstreamHeaders(req))
+	if header != nil {
+		forwardCtx = openai.ContextWithHeaders(forwardCtx, header)
+	}
+	if req.SessionID != "" {
+		forwardCtx = contextWithSession(forwardCtx, req.SessionID)
+	}
+	if req.KeyID != "" {
+		forwardCtx = contextWithClientKey(forwardCtx, req.KeyID)
+	}
+	if req.Backend != "" {
+		forwardCtx = contextWithBackend(forwardCtx, req.Backend)
+	}
+	if req.Speculative != "" {
+		forwardCtx = contextWithSpeculative(forwardCtx, req.Speculative)
+	}
+	forwardCtx = openai.ContextWithRetryBudget(forwardCtx, req.Retries)
+	// Writes that mustn't be applied twice aren't retried upstream after a
+	// failure it may have acted on, journaled or not
+	if !qm.isRetryable(req.Request) {
+		forwardCtx = openai.ContextWithoutAmbiguousRetries(forwardCtx)
+	}
+	forwardCtx, cancelUpstream := qm.withUpstreamTimeout(forwardCtx, req, queue)
+	defer cancelUpstream()
+	var served *atomic.Pointer[string]
+	if qm.BackendUsage != nil {
+		forwardCtx, served = contextWithServedBy(forwardCtx)
+	}
+	// Keep a copy of the request body to archive with the response; binary
+	// bodies are left to stream upstream untouched
+	debug := qm.Debug.match(req)
+	var requestBody []byte
+	if (qm.Archiver != nil || len(debug) > 0) && httpReq.Body != nil && isJSONContent(httpReq.Header.Get("Content-Type")) {
+		requestBody, _ = io.ReadAll(httpReq.Body)
+		httpReq.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+	
 	startTime := time.Now()
-	resp, err := qm.OpenAIClient.ForwardRequest(ctx, httpReq.Method, httpReq.URL.Path, httpReq.Body)
+	req.DispatchedAt = startTime
+	// Time since the request arrived that was neither queueing nor this upstream call,
+	// e.g. attempts lost to preemption
+	schedulingDelay := startTime.Sub(req.StartTime) - req.QueueWait
+	forwardCtx, span := startUpstreamSpan(forwardCtx, req, qm.Identity.tenant(req))
+	// List endpoints page and filter with the query, e.g. a thread's messages
+	upstreamPath := httpReq.URL.Path
+	if httpReq.URL.RawQuery != "" {
+		upstreamPath += "?" + httpReq.URL.RawQuery
+	}
+	resp, err := qm.OpenAIClient.ForwardRequest(forwardCtx, httpReq.Method, upstreamPath, httpReq.Body)
 	processingTime := time.Since(startTime)
+	endUpstreamSpan(span, resp, err)
 	
 	// Check if the request was cancelled due to preemption
 	select {
 	case <-ctx.Done():
-		// Request was preempted, we'll retry
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if req.Request.Context().Err() != nil && req.claim() {
+			// Not preempted, the client stopped waiting
+			qm.abandon(req)
+		}
+		// Otherwise the request was preempted, we'll retry
 		return
 	default:
 		// Request completed, process the response
 		if err != nil {
-			req.ResponseWriter.WriteHeader(http.StatusBadGateway)
+			status := http.StatusBadGateway
+			if errors.Is(context.Cause(forwardCtx), errUpstreamTimeout) {
+				// The upstream didn't answer within its budget
+				status = http.StatusGatewayTimeout
+				err = errUpstreamTimeout
+			}
+			qm.Journal.failed(req, err)
+			req.ResponseWriter.WriteHeader(status)
 			req.ResponseWriter.Write([]byte(fmt.Sprintf(`{"error":"Error forwarding request: %v"}`, err)))
+			o := qm.outcome(req, OutcomeFailed)
+			o.Status = status
+			qm.finish(o, metrics.RequestMetrics{
+				ProcessingTime:  processingTime,
+				QueueWaitTime:   req.QueueWait,
+				SchedulingDelay: schedulingDelay,
+				RetryCount:      req.RetryCount,
+				Preempted:       req.Preempted,
+				Tags:            req.Tags,
+				User:            req.User,
+				RetryBudget:     req.Retries.Limit(),
+				RetriesUsed:     req.Retries.Used(),
+			})
 			close(req.Done)
 			return
 		}
 		
-		// Copy headers from OpenAI response
-		for k, v := range resp.Header {
-			for _, vv := range v {
-				req.ResponseWriter.Header().Add(k, vv)
+		// Give clients OpenAI-format errors whichever backend produced them
+		normalizeErrorResponse(resp, qm.ExposeUpstreamErrors)
+		
+		// Note the upstream's own ID so the request can be traced on its side
+		upstreamID, upstreamTime := upstreamCorrelation(resp.Header)
+		if entry := accessEntryFromContext(req.Request.Context()); entry != nil {
+			entry.QueueWaitMs = req.QueueWait.Milliseconds()
+			entry.UpstreamRequestID = upstreamID
+			entry.UpstreamProcessingMs = upstreamTime.Milliseconds()
+		}
+		
+		// Guard against upstreams that stop sending mid-response
+		body := io.ReadCloser(resp.Body)
+		if qm.StreamIdleTimeout > 0 {
+			body = newIdleTimeoutReader(resp.Body, qm.StreamIdleTimeout)
+		}
+		tap := newUsageTap(body, isEventStream(resp.Header))
+		// Binary responses (speech audio, file contents) carry no usage report
+		if tap.stream || isJSONContent(resp.Header.Get("Content-Type")) {
+			body = tap
+		}
+		
+		// Wait for the first chunk before committing headers so a response
+		// that stalls immediately can still be retried
+		first := make([]byte, 32*1024)
+		n, readErr := body.Read(first)
+		if n == 0 && errors.Is(readErr, ErrStreamIdle) && retryable && req.IdleRetries < qm.StreamIdleRetries && req.Retries.Spend() {
+			body.Close()
+			req.IdleRetries++
+			req.RetryCount++
+			if qm.requeue(req, queue) {
+				qm.LogSampler.logf(logPreemption, "Upstream stalled for model %s, priority %d. Retrying (attempt %d)\n",
+					req.Model, queue.Priority, req.RetryCount+1)
 			}
+			return
 		}
 		
+		// Commit to this attempt; from here on it is exempt from preemption,
+		// though a stream may still be cut off
+		req.eventStream = isEventStream(resp.Header)
+		if !req.claim() {
+			// Preempted while waiting for the first chunk, the monitor has requeued it
+			body.Close()
+			return
+		}
+		if ctx.Err() != nil {
+			// The client stopped waiting for the first chunk
+			body.Close()
+			qm.abandon(req)
+			return
+		}
+		
+		// Archive the response as it streams to the client
+		if qm.Archiver != nil || len(debug) > 0 {
+			record := archive.Record{
+				Time:              req.StartTime,
+				KeyID:             req.KeyID,
+				Model:             req.Model,
+				Method:            req.Request.Method,
+				Path:              req.Request.URL.Path,
+				Status:            resp.StatusCode,
+				Streamed:          isEventStream(resp.Header),
+				Request:           string(requestBody),
+				UpstreamRequestID: upstreamID,
+			}
+			var captures []*archive.Capture
+			if qm.Archiver != nil {
+				captures = append(captures, qm.Archiver.Start(record))
+			}
+			// Debug captures also keep the headers, without credentials
+			record.RequestID = req.requestID()
+			record.RequestHeaders = redactHeaders(req.Request.Header)
+			record.ResponseHeaders = redactHeaders(resp.Header)
+			for _, archiver := range debug {
+				captures = append(captures, archiver.Start(record))
+			}
+			for _, capture := range captures {
+				capture.Chunk(first[:n])
+			}
+			body = newArchiveTee(body, captures...)
+		}
+		
+		// Scan generated text on its way to the client
+		w := req.ResponseWriter
+		var scan *scanWriter
+		if qm.Scanner.scans(req) {
+			scan = qm.Scanner.newScanWriter(ctx, w, req, req.eventStream)
+			w = scan
+		}
+		// and check a complete response against its schema before that
+		var check *schemaWriter
+		if qm.Structured != nil && req.Schema != nil && !req.eventStream {
+			check = newSchemaWriter(w, req)
+			w = check
+		}
+
+		// Copy headers from OpenAI response
+		copyUpstreamHeaders(w, resp.Header)
+		if qm.RetryBudget > 0 {
+			writeRetryHeaders(w, req.Retries)
+		}
+		announceTrailers(w, resp.Trailer)
+		
 		// Set status code
-		req.ResponseWriter.WriteHeader(resp.StatusCode)
+		w.WriteHeader(resp.StatusCode)
+		
+		// Copy body, sending the first chunk straight away in case the next is slow to come
+		if n > 0 {
+			w.Write(first[:n])
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+		if readErr == nil {
+			_, err = copyResponse(w, body)
+		} else if readErr != io.EOF {
+			err = readErr
+		}
+		body.Close()
+		if check != nil && err == nil {
+			retry := func() bool {
+				return qm.Structured.Retry && retryable && !req.SchemaRetried && req.Retries.Spend()
+			}
+			if err = check.finish(qm.Structured, qm.LogSampler, retry); err != nil {
+				// Nothing reached the scanner
+				scan = nil
+			}
+		}
+		if scan != nil {
+			if scanErr := scan.finish(); err == nil {
+				err = scanErr
+			}
+		}
+		copyTrailers(w, resp.Trailer)
 		
-		// Copy body
-		_, err = io.Copy(req.ResponseWriter, resp.Body)
-		resp.Body.Close()
+		// A stream that ended before it was cut off wasn't cut short
+		req.stateMu.Lock()
+		cut := req.streamCut && err != nil && !errors.Is(err, errOutputBlocked)
+		req.stateMu.Unlock()
+		expired := err != nil && errors.Is(context.Cause(forwardCtx), errUpstreamTimeout)
+		blocked := errors.Is(err, errOutputBlocked)
+		violated := errors.Is(err, errSchemaViolation) || errors.Is(err, errSchemaRetry)
+		truncated := cut || expired || errors.Is(err, ErrStreamIdle) || (blocked && req.eventStream)
 		
-		if err != nil {
-			fmt.Printf("Error copying response body: %v\n", err)
+		// Prefer the upstream's own token counts over our estimate; a
+		// truncated stream is charged for the text it sent
+		inputTokens, outputTokens := req.InputTokens, int64(0)
+		if in, out, ok := tap.Usage(); ok {
+			inputTokens, outputTokens = in, out
+		} else if truncated {
+			outputTokens = partialTokens(req.Model, tap)
 		}
 		
-		// Record metrics
-		metricsCollector := metrics.GetCollector()
-		if metricsCollector != nil {
-			metricsCollector.Collect(metrics.RequestMetrics{
-				Model:          req.Model,
-				InputTokens:    req.InputTokens,
-				ProcessingTime: processingTime,
-				RetryCount:     req.RetryCount,
-				Tools:          req.Tools,
-				EndpointPath:   req.Request.URL.Path,
-				Priority:       queue.Priority,
-				Preempted:      req.Preempted,
-				StatusCode:     resp.StatusCode,
+		if cut {
+			req.Preempted = true
+			_, contentLen := tap.Generated()
+			qm.LogSampler.logf(logPreemption, "Cut off stream for model %s, priority %d, after %d characters for higher priority work\n",
+				req.Model, queue.Priority, contentLen)
+			writePreemptedEvent(w, contentLen, outputTokens)
+		} else if errors.Is(err, ErrStreamIdle) {
+			qm.LogSampler.logf(logError, "Upstream stream for model %s idle for more than %v, terminating\n",
+				req.Model, qm.StreamIdleTimeout)
+			writeStreamError(w, resp.Header, ErrStreamIdle.Error(), "stream_idle_timeout")
+		} else if expired {
+			qm.LogSampler.logf(logError, "Upstream response for model %s, priority %d, ran past its upstream timeout, terminating\n",
+				req.Model, queue.Priority)
+			writeStreamError(w, resp.Header, errUpstreamTimeout.Error(), "upstream_timeout")
+		} else if err != nil && !blocked && !violated { // The scanner and schema check logged what they found
+			qm.LogSampler.logf(logError, "Error copying response body: %v\n", err)
+		}
+		
+		if qm.Ledger != nil {
+			qm.Ledger.Record(usage.Record{
+				Time:         time.Now(),
+				KeyID:        req.KeyID,
+				Model:        req.Model,
+				InputTokens:  inputTokens,
+				OutputTokens: outputTokens,
+				Preemptions:  req.preemptions(),
 			})
 		}
 		
-		fmt.Printf("Completed request for model: %s (Path: %s, Priority: %d, Preemptions: %d, Time: %v)\n", 
-			req.Model, req.Request.URL.Path, queue.Priority, req.RetryCount, processingTime)
+		if qm.Quotas != nil {
+			qm.Quotas.Consume(req.KeyID, inputTokens+outputTokens)
+		}
+		
+		// Charge the tokens to the upstream that served them, against its provider's quotas
+		backend := servedBy(served)
+		requestsLeft, tokensLeft := int64(-1), int64(-1)
+		if backend != "" {
+			requestsLeft, tokensLeft = qm.BackendUsage.Record(backend, inputTokens, outputTokens)
+		}
+		
+		// Send a request whose response violated its schema again, having
+		// charged the tokens the response took
+		if errors.Is(err, errSchemaRetry) {
+			req.SchemaRetried = true
+			req.RetryCount++
+			if qm.requeue(req, queue) {
+				qm.LogSampler.logf(logPreemption, "Response for model %s violated its schema, priority %d. Retrying (attempt %d)\n",
+					req.Model, queue.Priority, req.RetryCount+1)
+			}
+			return
+		}
+		
+		// Record the outcome, and with it metrics
+		o := qm.outcome(req, OutcomeCompleted)
+		if truncated {
+			o.Outcome = OutcomeTruncated
+		}
+		o.Status = resp.StatusCode
+		if blocked {
+			o.Blocked = scan.blocked.Check
+			if !req.eventStream {
+				o.Status = http.StatusUnprocessableEntity
+			}
+		}
+		schemaViolations := 0
+		if req.SchemaRetried {
+			schemaViolations++
+		}
+		if violated {
+			o.Status = http.StatusBadGateway
+			schemaViolations++
+		}
+		if backend != "" {
+			o.Backend = backend
+		}
+		o.InputTokens = inputTokens
+		o.OutputTokens = outputTokens
+		qm.finish(o, metrics.RequestMetrics{
+			ProcessingTime:         processingTime,
+			QueueWaitTime:          req.QueueWait,
+			SchedulingDelay:        schedulingDelay,
+			RetryCount:             req.RetryCount,
+			Tools:                  req.Tools,
+			ToolCalls:              tap.ToolCalls(),
+			ReasoningEffort:        req.ReasoningEffort,
+			Preempted:              req.Preempted,
+			Boosted:                req.Boosted,
+			Demoted:                req.Demoted,
+			Tags:                   req.Tags,
+			User:                   req.User,
+			UpstreamRequestID:      upstreamID,
+			UpstreamProcessingTime: upstreamTime,
+			RetryBudget:            req.Retries.Limit(),
+			RetriesUsed:            req.Retries.Used(),
+			BackendRequestsLeft:    requestsLeft,
+			BackendTokensLeft:      tokensLeft,
+			SchemaChecked:          check != nil && check.checked,
+			SchemaViolations:       schemaViolations,
+		})
+		
+		tags := ""
+		if len(req.Tags) > 0 {
+			tags = ", Tags: " + formatTags(req.Tags)
+		}
+		if upstreamID != "" {
+			tags += ", Upstream ID: " + upstreamID
+		}
+		// Successes may be sampled, failures and preempted requests never are
+		kind := logSuccess
+		switch {
+		case err != nil || truncated || resp.StatusCode >= 400:
+			kind = logError
+		case req.RetryCount > 0 || req.Preempted:
+			kind = logPreemption
+		}
+		qm.LogSampler.logf(kind, "Completed request for model: %s (Path: %s, Priority: %d, Preemptions: %d, Time: %v, Queue wait: %v%s)\n", 
+			req.Model, req.Request.URL.Path, queue.Priority, req.RetryCount, processingTime, req.QueueWait, tags)
 		
 		// Signal that the request is done
 		close(req.Done)
 	}
-}
\ No newline at end of file
+}
+// ErrNoQueue is returned when no queue is configured for a priority
+var ErrNoQueue = errors.New("no queue configured for this priority")
+
+// ErrQueueFull is returned when a queue has no room for another request
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrQueueBytes is returned when a queue's waiting request bodies leave no
+// room for another request's
+var ErrQueueBytes = errors.New("queue is over its buffered bytes limit")
+
+// ErrStopping is returned for requests submitted once the manager is draining
+var ErrStopping = errors.New("proxy is shutting down")
+
+// QueueStatus describes the current state of a single queue
+type QueueStatus struct {
+	Port           int   `json:"port"`
+	SharedPorts    []int `json:"shared_ports,omitempty"` // Other endpoints' ports feeding the queue
+	Priority       int   `json:"priority"`
+	Preemptive     bool  `json:"preemptive"`
+	Depth          int   `json:"depth"`
+	Capacity       int   `json:"capacity"`
+	AvgWaitMs      int64 `json:"avg_wait_ms"`                // Average wait of recently picked up requests
+	ClientGone     int64 `json:"client_gone"`                // Requests dropped because their client left while queued
+	Escalated      int64 `json:"escalated"`                  // Requests moved to a higher queue for waiting too long
+	TimedOut       int64 `json:"timed_out"`                  // Requests answered with a 504 for waiting past their queue timeout
+	Running        int64 `json:"running"`                    // Requests currently being processed
+	QueuedBytes    int64 `json:"queued_bytes"`               // Body bytes of the requests waiting
+	MaxQueuedBytes int64 `json:"max_queued_bytes,omitempty"` // Body bytes allowed to wait at once (0 = unlimited)
+	MaxConcurrent  int   `json:"max_concurrent,omitempty"`   // Requests allowed to run at once, as scheduled (0 = unlimited)
+	SoftWatermark  int   `json:"soft_watermark,omitempty"`   // Depth past which clients are advised to slow down
+	HardWatermark  int   `json:"hard_watermark,omitempty"`   // Depth at which new requests are rejected
+	// Longest a request arriving on the queue may wait in queues, and
+	// each of its attempts may take upstream
+	QueueTimeoutMs    int64 `json:"queue_timeout_ms,omitempty"`
+	UpstreamTimeoutMs int64 `json:"upstream_timeout_ms,omitempty"`
+	// ScheduledPriority is the priority the queue is dispatched at while a
+	// schedule window changes it
+	ScheduledPriority int `json:"scheduled_priority,omitempty"`
+}
+
+// Status returns a snapshot of every queue, highest priority first
+func (qm *QueueManager) Status() []QueueStatus {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+
+	status := make([]QueueStatus, 0, len(qm.Queues))
+	for _, q := range qm.Queues {
+		status = append(status, QueueStatus{
+			Port:           q.Port,
+			SharedPorts:    q.SharedPorts,
+			Priority:       q.Priority,
+			Preemptive:     q.Preemptive,
+			Depth:          q.waiting(),
+			Capacity:       cap(q.Requests),
+			AvgWaitMs:      qm.AverageWait(q).Milliseconds(),
+			ClientGone:     q.clientGone.Load(),
+			Escalated:      q.escalated.Load(),
+			TimedOut:       q.timedOut.Load(),
+			Running:        q.running.Load(),
+			QueuedBytes:    q.queuedBytes.Load(),
+			MaxQueuedBytes: q.MaxQueuedBytes,
+			MaxConcurrent:  qm.concurrencyLimit(q),
+			SoftWatermark:  q.SoftWatermark,
+			HardWatermark:  q.HardWatermark,
+			// Reported in milliseconds like the queue's waits
+			QueueTimeoutMs:    q.QueueTimeout.Milliseconds(),
+			UpstreamTimeoutMs: q.UpstreamTimeout.Milliseconds(),
+		})
+		if rank := qm.rank(q); rank != q.Priority {
+			status[len(status)-1].ScheduledPriority = rank
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Priority < status[j].Priority
+	})
+	return status
+}
+
+// Submit queues an HTTP request at the given priority and blocks until its
+// response has been written to w. It goes straight to the scheduler, with
+// none of RequestHandler's admission checks, e.g. for the simulator;
+// transports serving clients (e.g. gRPC) use ContextWithPriority with the
+// handler instead.
+func (qm *QueueManager) Submit(priority int, r *http.Request, w http.ResponseWriter) error {
+	queue := qm.FindQueue(priority)
+	if queue == nil {
+		return ErrNoQueue
+	}
+
+	req := &workRequest{
+		Request:        r,
+		ResponseWriter: w,
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+	}
+
+	// Extract metrics data, restoring the body for the upstream call
+	if r.Body != nil && isJSONContent(r.Header.Get("Content-Type")) {
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+
+		req.Model, req.InputTokens, req.Tools, _ = openai.ExtractRequestMetadata(bytes.NewReader(bodyBytes))
+		req.User = openai.ExtractUser(bodyBytes)
+		req.ReasoningEffort = openai.ExtractReasoningEffort(bodyBytes)
+		req.SessionID = sessionID(r, bodyBytes)
+		req.KeyID = clientKeyID(r)
+		req.BodySize = int64(len(bodyBytes))
+		setBody(r, bodyBytes)
+	}
+
+	if err := qm.enqueue(queue, req); err != nil {
+		status, reason := http.StatusTooManyRequests, RejectQueueFull
+		switch err {
+		case ErrStopping:
+			status, reason = http.StatusServiceUnavailable, RejectShuttingDown
+		case ErrQueueBytes:
+			reason = RejectQueueBytes
+		}
+		qm.recordRejection(r, priority, req.Model, status, reason)
+		return err
+	}
+
+	<-req.Done
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"sync"
 	"testing"
@@ -18,7 +19,7 @@ import (
 
 func TestNewQueueManager(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	endpoints := []config.Endpoint{
 		{Port: 8080, Priority: 1, Preemptive: true},
 		{Port: 8081, Priority: 2, Preemptive: false},
@@ -68,9 +69,47 @@ func TestNewQueueManager(t *testing.T) {
 	}
 }
 
+func TestSharedPriorityQueue(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	endpoints := []config.Endpoint{
+		{Port: 8080, Priority: 1},
+		{Port: 8081, Priority: 2},
+		{Port: 8082, Priority: 1, Preemptive: true, MaxConcurrent: 3},
+	}
+	client := &MockOpenAIClient{ResponseBody: `{"id":"test-response"}`, ResponseStatus: http.StatusOK}
+	qm := NewQueueManager(endpoints, client)
+
+	// Endpoints with the same priority feed one queue with the settings either sets
+	if len(qm.Queues) != 2 {
+		t.Fatalf("Expected 2 queues, got %d", len(qm.Queues))
+	}
+	queue := qm.FindQueueByPort(8082)
+	if queue == nil || queue != qm.FindQueueByPort(8080) {
+		t.Fatal("Expected ports 8080 and 8082 to share a queue")
+	}
+	if !queue.Preemptive || queue.MaxConcurrent != 3 {
+		t.Errorf("Expected the shared queue to be preemptive with max_concurrent 3, got %+v", queue)
+	}
+	if status := qm.Status()[0]; status.Port != 8080 || fmt.Sprint(status.SharedPorts) != "[8082]" {
+		t.Errorf("Expected the queue reported under port 8080 shared with 8082, got %+v", status)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+	req.Host = "localhost:8082"
+	recorder := httptest.NewRecorder()
+	NewRequestHandler(qm).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK || client.CallCount != 1 {
+		t.Errorf("Expected the request on the shared port served, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
 func TestQueueManagerPreemption(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a controlled test environment
 	highPriorityQueue := make(chan *workRequest, 1)
@@ -129,7 +168,7 @@ func TestQueueManagerPreemption(t *testing.T) {
 
 func TestShouldPreempt(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	endpoints := []config.Endpoint{
 		{Port: 8080, Priority: 1, Preemptive: true},
 		{Port: 8081, Priority: 2, Preemptive: false},
@@ -177,7 +216,7 @@ func TestShouldPreempt(t *testing.T) {
 
 func TestProcessRequestPreemption(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a test request
 	requestURL := "http://example.com/v1/chat/completions"
@@ -241,7 +280,7 @@ func TestProcessRequestPreemption(t *testing.T) {
 
 func TestProcessRequestWithError(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a test request
 	requestURL := "http://example.com/v1/chat/completions"
@@ -301,7 +340,7 @@ func TestProcessRequestWithError(t *testing.T) {
 
 func TestProcessNextRequest(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create channels for test queues
 	highQueue := make(chan *workRequest, 1)
@@ -365,7 +404,7 @@ func TestProcessNextRequest(t *testing.T) {
 
 func TestStartScheduler(t *testing.T) {
 	// Initialize metrics collector
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	mockClient := &MockOpenAIClient{
 		ResponseBody:   `{"id":"test-response"}`,
@@ -422,7 +461,267 @@ func TestStartScheduler(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 	
 	// Check that stopping flag was set
-	if !qm.stopping {
+	if !qm.stopping.Load() {
 		t.Error("Expected stopping to be true after context cancellation")
 	}
-}
\ No newline at end of file
+}
+
+func TestDrain(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+		RequestDelay:   50 * time.Millisecond,
+	})
+	queue := qm.Queues[0]
+	newRequest := func() *workRequest {
+		return &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/test", nil),
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+			Model:          "gpt-4",
+		}
+	}
+
+	// Nothing is dispatched until the scheduler runs, so Drain gives up
+	queued := newRequest()
+	if err := qm.enqueue(queue, queued); err != nil {
+		t.Fatalf("Expected request accepted, got %v", err)
+	}
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+	if err := qm.Drain(timeout); err != context.DeadlineExceeded {
+		t.Errorf("Expected drain to time out, got %v", err)
+	}
+
+	// Requests arriving while draining are refused
+	if err := qm.enqueue(queue, newRequest()); err != ErrStopping {
+		t.Errorf("Expected ErrStopping while draining, got %v", err)
+	}
+
+	// Once the scheduler runs, Drain returns after the accepted request finished
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	if err := qm.Drain(context.Background()); err != nil {
+		t.Fatalf("Expected drain to finish, got %v", err)
+	}
+	select {
+	case <-queued.Done:
+	default:
+		t.Error("Expected the queued request finished before Drain returned")
+	}
+}
+
+func TestQueuedBytesLimit(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, MaxQueuedMB: 1}}, &MockOpenAIClient{})
+	queue := qm.Queues[0]
+	newRequest := func(size int64) *workRequest {
+		return &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/test", nil),
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+			BodySize:       size,
+		}
+	}
+
+	if err := qm.enqueue(queue, newRequest(600<<10)); err != nil {
+		t.Fatalf("Expected request accepted, got %v", err)
+	}
+	if err := qm.enqueue(queue, newRequest(600<<10)); err != ErrQueueBytes {
+		t.Errorf("Expected ErrQueueBytes over the limit, got %v", err)
+	}
+	if got := queue.queuedBytes.Load(); got != 600<<10 {
+		t.Errorf("Expected only the accepted body counted, got %d bytes", got)
+	}
+
+	// Picking the request up frees its bytes for the next
+	if qm.dequeue(queue, nil) == nil {
+		t.Fatal("Expected the queued request")
+	}
+	if err := qm.enqueue(queue, newRequest(600<<10)); err != nil {
+		t.Errorf("Expected request accepted once the queue emptied, got %v", err)
+	}
+}
+
+func TestRequestTimings(t *testing.T) {
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		// Ignore requests left over from other tests
+		if m.Model == "timing-test" {
+			recorded <- m
+		}
+		return nil
+	}
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+		RequestDelay:   50 * time.Millisecond,
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"timing-test"}`))
+		req.Host = "localhost:8080"
+		NewRequestHandler(qm).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	// Leave the request waiting in its queue before the scheduler picks it up
+	time.Sleep(100 * time.Millisecond)
+	go qm.StartScheduler(ctx)
+	<-done
+
+	m := <-recorded
+	if m.QueueWaitTime < 80*time.Millisecond {
+		t.Errorf("Expected a queue wait of about 100ms, got %v", m.QueueWaitTime)
+	}
+	if m.ProcessingTime < 50*time.Millisecond || m.ProcessingTime >= m.QueueWaitTime {
+		t.Errorf("Expected processing time to cover only the upstream call, got %v", m.ProcessingTime)
+	}
+	if m.SchedulingDelay < 0 || m.SchedulingDelay > 50*time.Millisecond {
+		t.Errorf("Expected a small scheduling delay without preemption, got %v", m.SchedulingDelay)
+	}
+	if m.TotalTime < m.QueueWaitTime+m.SchedulingDelay+m.ProcessingTime {
+		t.Errorf("Expected total time %v to cover wait %v, delay %v and processing %v",
+			m.TotalTime, m.QueueWaitTime, m.SchedulingDelay, m.ProcessingTime)
+	}
+}
+
+func TestSchedulerIntervals(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{})
+	if qm.schedulerTick() != 10*time.Millisecond || qm.preemptCheckInterval() != 50*time.Millisecond {
+		t.Errorf("Expected 10ms tick and 50ms preemption check by default, got %v and %v",
+			qm.schedulerTick(), qm.preemptCheckInterval())
+	}
+
+	qm.SchedulerTick = time.Millisecond
+	qm.PreemptCheckInterval = 250 * time.Millisecond
+	if qm.schedulerTick() != time.Millisecond || qm.preemptCheckInterval() != 250*time.Millisecond {
+		t.Errorf("Expected configured intervals, got %v and %v", qm.schedulerTick(), qm.preemptCheckInterval())
+	}
+}
+
+func TestDispatchSkipsClientGone(t *testing.T) {
+	// Initialize metrics collector and capture what it records
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	var mu sync.Mutex
+	var recorded []metrics.RequestMetrics
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		mu.Lock()
+		defer mu.Unlock()
+		recorded = append(recorded, m)
+		return nil
+	}
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	queue := qm.FindQueue(1)
+
+	newWorkRequest := func(ctx context.Context, model string) *workRequest {
+		httpReq, _ := http.NewRequestWithContext(ctx, "POST", "http://localhost:8080/v1/chat/completions", strings.NewReader(`{}`))
+		return &workRequest{
+			Request:        httpReq,
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+			Model:          model,
+		}
+	}
+
+	// The first client times out while waiting, the second is still there
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	dropped := newWorkRequest(gone, "gpt-gone")
+	live := newWorkRequest(context.Background(), "gpt-live")
+	queue.Requests <- dropped
+	queue.Requests <- live
+
+	// A single dispatch skips straight to the live request
+	qm.processNextRequest()
+	for _, req := range []*workRequest{dropped, live} {
+		select {
+		case <-req.Done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected request for %s to finish", req.Model)
+		}
+	}
+
+	if client.CallCount != 1 {
+		t.Errorf("Expected only the live request to go upstream, got %d calls", client.CallCount)
+	}
+	if status := qm.Status(); status[0].ClientGone != 1 {
+		t.Errorf("Expected one client_gone drop, got %d", status[0].ClientGone)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(recorded) != 2 || !recorded[0].ClientGone || recorded[0].Model != "gpt-gone" || recorded[1].ClientGone {
+		t.Errorf("Expected a client_gone record followed by the completed request, got %+v", recorded)
+	}
+}
+
+func TestSchedulerTrace(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	client := &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: 200,
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Trace = true
+	queue := qm.FindQueue(1)
+
+	newWorkRequest := func(ctx context.Context, id string) *workRequest {
+		httpReq, _ := http.NewRequestWithContext(ctx, "POST", "http://localhost:8080/v1/chat/completions", strings.NewReader(`{}`))
+		httpReq.Header.Set(RequestIDHeader, id)
+		return &workRequest{
+			Request:        httpReq,
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+			Model:          "gpt-4",
+		}
+	}
+	gone, cancel := context.WithCancel(context.Background())
+	cancel()
+	queue.Requests <- newWorkRequest(gone, "req-gone")
+	live := newWorkRequest(context.Background(), "req-live")
+	queue.Requests <- live
+
+	// Capture stdout
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	qm.processNextRequest()
+	<-live.Done
+	w.Close()
+	os.Stdout = stdout
+
+	out, _ := io.ReadAll(r)
+	for _, want := range []string{
+		"TRACE scheduler: skip request req-gone on priority 1 queue: client gone (context canceled)",
+		"TRACE scheduler: dispatch request req-live (model gpt-4) from priority 1 queue, 0 left queued",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("Expected trace %q, got:\n%s", want, out)
+		}
+	}
+}
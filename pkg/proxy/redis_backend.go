@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend is a QueueBackend that keeps one Redis list per priority.
+// BLPOP across the lists in priority order gives every replica the same
+// view of which job should run next.
+type RedisBackend struct {
+	client       *redis.Client
+	prefix       string
+	resultTTL    time.Duration
+	pollInterval time.Duration
+}
+
+// NewRedisBackend connects to Redis using a redis:// URL
+func NewRedisBackend(redisURL, prefix string, resultTTL time.Duration) (*RedisBackend, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	return &RedisBackend{
+		client:       redis.NewClient(opts),
+		prefix:       prefix,
+		resultTTL:    resultTTL,
+		pollInterval: time.Second,
+	}, nil
+}
+
+// queueKey is the list holding jobs for a priority
+func (b *RedisBackend) queueKey(priority int) string {
+	return fmt.Sprintf("%s:queue:%d", b.prefix, priority)
+}
+
+// resultKey is the list a job's result is published to
+func (b *RedisBackend) resultKey(jobID string) string {
+	return fmt.Sprintf("%s:result:%s", b.prefix, jobID)
+}
+
+// Push implements QueueBackend
+func (b *RedisBackend) Push(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.client.RPush(ctx, b.queueKey(job.Priority), data).Err()
+}
+
+// Pop implements QueueBackend
+func (b *RedisBackend) Pop(ctx context.Context, priorities []int) (*Job, error) {
+	keys := make([]string, 0, len(priorities))
+	for _, p := range priorities {
+		keys = append(keys, b.queueKey(p))
+	}
+
+	res, err := b.client.BLPop(ctx, b.pollInterval, keys...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(res[1]), &job); err != nil {
+		return nil, fmt.Errorf("invalid job in %s: %w", res[0], err)
+	}
+	return &job, nil
+}
+
+// Complete implements QueueBackend
+func (b *RedisBackend) Complete(ctx context.Context, job *Job, result *JobResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	// Expire unclaimed results so abandoned submitters don't leak keys
+	pipe := b.client.TxPipeline()
+	pipe.RPush(ctx, b.resultKey(job.ID), data)
+	pipe.Expire(ctx, b.resultKey(job.ID), b.resultTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Wait implements QueueBackend
+func (b *RedisBackend) Wait(ctx context.Context, jobID string) (*JobResult, error) {
+	for {
+		res, err := b.client.BLPop(ctx, b.pollInterval, b.resultKey(jobID)).Result()
+		if errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var result JobResult
+		if err := json.Unmarshal([]byte(res[1]), &result); err != nil {
+			return nil, fmt.Errorf("invalid result for job %s: %w", jobID, err)
+		}
+		return &result, nil
+	}
+}
+
+// Depth implements QueueBackend
+func (b *RedisBackend) Depth(ctx context.Context, priority int) (int64, error) {
+	return b.client.LLen(ctx, b.queueKey(priority)).Result()
+}
+
+// leaderKey holds the ID of the replica leading the election
+func (b *RedisBackend) leaderKey() string {
+	return b.prefix + ":leader"
+}
+
+// acquireScript renews the lease when the replica already holds it and takes it when no one does
+var acquireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+return 0
+`)
+
+// releaseScript removes the lease only when the replica still holds it
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Acquire implements Lease
+func (b *RedisBackend) Acquire(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	held, err := acquireScript.Run(ctx, b.client, []string{b.leaderKey()}, id, ttl.Milliseconds()).Int()
+	return held == 1, err
+}
+
+// Release implements Lease
+func (b *RedisBackend) Release(ctx context.Context, id string) error {
+	return releaseScript.Run(ctx, b.client, []string{b.leaderKey()}, id).Err()
+}
+
+// Close implements QueueBackend
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
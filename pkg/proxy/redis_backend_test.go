@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// newTestRedisBackend starts an in-memory Redis and connects a backend to it
+func newTestRedisBackend(t *testing.T) *RedisBackend {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	backend, err := NewRedisBackend("redis://"+server.Addr(), "test", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create Redis backend: %v", err)
+	}
+	backend.pollInterval = 100 * time.Millisecond
+	t.Cleanup(func() { backend.Close() })
+
+	return backend
+}
+
+func TestRedisBackendPriorityOrder(t *testing.T) {
+	backend := newTestRedisBackend(t)
+	ctx := context.Background()
+
+	backend.Push(ctx, &Job{ID: "low-1", Priority: 2})
+	backend.Push(ctx, &Job{ID: "high-1", Priority: 1})
+	backend.Push(ctx, &Job{ID: "low-2", Priority: 2})
+
+	depth, err := backend.Depth(ctx, 2)
+	if err != nil || depth != 2 {
+		t.Errorf("Expected depth 2 for priority 2, got %d (err: %v)", depth, err)
+	}
+
+	// Higher priority jobs come out first, then FIFO within a priority
+	for _, expected := range []string{"high-1", "low-1", "low-2"} {
+		job, err := backend.Pop(ctx, []int{1, 2})
+		if err != nil {
+			t.Fatalf("Failed to pop job: %v", err)
+		}
+		if job == nil || job.ID != expected {
+			t.Fatalf("Expected job %s, got %+v", expected, job)
+		}
+	}
+
+	// An empty queue returns nil after the poll interval
+	job, err := backend.Pop(ctx, []int{1, 2})
+	if err != nil || job != nil {
+		t.Errorf("Expected no job from empty queues, got %+v (err: %v)", job, err)
+	}
+}
+
+func TestRedisBackendResults(t *testing.T) {
+	backend := newTestRedisBackend(t)
+	ctx := context.Background()
+
+	job := &Job{ID: "job-1", Priority: 1}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		backend.Complete(ctx, job, &JobResult{
+			Status: 201,
+			Header: http.Header{"X-Test": {"yes"}},
+			Body:   []byte(`{"ok":true}`),
+		})
+	}()
+
+	result, err := backend.Wait(ctx, "job-1")
+	if err != nil {
+		t.Fatalf("Failed to wait for result: %v", err)
+	}
+
+	if result.Status != 201 || result.Header.Get("X-Test") != "yes" || string(result.Body) != `{"ok":true}` {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// Waiting stops when the caller gives up
+	waitCtx, cancel := context.WithTimeout(ctx, 150*time.Millisecond)
+	defer cancel()
+	if _, err := backend.Wait(waitCtx, "never-completed"); err == nil {
+		t.Error("Expected error when waiting past the context deadline")
+	}
+}
+
+func TestDistributedRoundTrip(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	backend := newTestRedisBackend(t)
+	endpoints := []config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2, Preemptive: false},
+	}
+
+	// The submitting replica only accepts connections
+	submitter := NewQueueManager(endpoints, &MockOpenAIClient{})
+	submitter.Backend = backend
+
+	// The worker replica runs jobs against the upstream
+	worker := NewQueueManager(endpoints, &MockOpenAIClient{
+		ResponseBody:    `{"id":"from-worker"}`,
+		ResponseStatus:  200,
+		ResponseHeaders: map[string]string{"Content-Type": "application/json"},
+	})
+	worker.Backend = backend
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go worker.StartScheduler(ctx)
+	go worker.StartDistributedWorker(ctx, 2)
+
+	handler := NewRequestHandler(submitter)
+	req := httptest.NewRequest("POST", "/v1/chat/completions",
+		bytes.NewBufferString(`{"model":"gpt-4","messages":[{"role":"user","content":"Hello"}]}`))
+	req.Host = "localhost:8081"
+	recorder := httptest.NewRecorder()
+
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	if recorder.Body.String() != `{"id":"from-worker"}` {
+		t.Errorf("Expected response from the worker replica, got %s", recorder.Body.String())
+	}
+
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected upstream headers to be relayed, got %v", recorder.Header())
+	}
+}
+
+func TestRedisBackendLease(t *testing.T) {
+	testLease(t, newTestRedisBackend(t))
+}
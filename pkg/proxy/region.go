@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults used when a RegionalClient's settings are left zero
+const (
+	defaultRegionFailureThreshold = 3
+	defaultRegionFailbackAfter    = time.Minute
+	defaultRegionTrialAfter       = 30 * time.Second
+	defaultRegionSwitchMargin     = 0.2
+	defaultRegionProbePath        = "/v1/models"
+)
+
+// regionLatencyWeight is how much each new latency sample moves a region's average
+const regionLatencyWeight = 0.2
+
+// slowStartMinShare is the share of its requests a region is sent as soon as
+// its slow start begins, so its health is proven from the start
+const slowStartMinShare = 0.1
+
+// Region is one deployment of an upstream, e.g. an Azure OpenAI resource in one region
+type Region struct {
+	Name   string
+	URL    string // For status reports only
+	Client OpenAIClient
+}
+
+// RegionStatus describes how a region is doing
+type RegionStatus struct {
+	Name      string  `json:"name"`
+	URL       string  `json:"url,omitempty"`
+	Active    bool    `json:"active"` // Requests are currently sent here
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latency_ms"` // Moving average, 0 until measured
+	Failures  int     `json:"failures"`   // Consecutive failures
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	// SlowStart is the share of its requests a recovered region is sent
+	// while it warms up, omitted once it takes all of them
+	SlowStart float64 `json:"slow_start,omitempty"`
+}
+
+// regionState is what a RegionalClient knows about one region
+type regionState struct {
+	Region
+	latency      time.Duration
+	failures     int
+	healthy      bool
+	healthySince time.Time
+	failedAt     time.Time // When it last failed
+	trying       bool      // A request is trying it while it is unhealthy
+	requests     int64
+	errors       int64
+	recovered    bool      // Was unhealthy since it last took traffic, so it warms up first
+	rampStart    time.Time // When its latest slow start began
+	rampCredit   float64   // Requests it is owed during slow start, sent once this reaches 1
+}
+
+// RegionalClient sends requests to whichever region of an upstream is
+// healthy and fastest. It sticks with the region it is using until that
+// region fails or another has been healthy for FailbackAfter and is faster
+// by SwitchMargin, so traffic doesn't flap between regions during an
+// incident or on small latency differences. A region that recovers from an
+// incident is given traffic gradually over SlowStart, so a server still
+// warming up isn't overwhelmed and taken out again. Without probes, an
+// unhealthy region is tried with a single request every TrialAfter to find
+// out whether it has recovered.
+type RegionalClient struct {
+	// FailureThreshold is how many failures in a row take a region out (default 3)
+	FailureThreshold int
+	// FailbackAfter is how long a region must have been healthy before traffic moves to it (default 1m)
+	FailbackAfter time.Duration
+	// SwitchMargin is how much lower, as a fraction, another region's latency
+	// must be for traffic to move to it (default 0.2)
+	SwitchMargin float64
+	// ProbePath is requested to measure each region's latency (default /v1/models)
+	ProbePath string
+	// SlowStart is how long a recovered region takes to ramp up to all the
+	// requests it is picked for, the rest going to another healthy region
+	// meanwhile (0 sends them all at once)
+	SlowStart time.Duration
+	// TrialAfter is how long an unhealthy region waits after its last failure
+	// before a request tries it again, when it isn't probed (default 30s)
+	TrialAfter time.Duration
+
+	mu      sync.Mutex
+	regions []*regionState
+	active  int
+	probing bool // Latency comes from probes rather than requests
+	now     func() time.Time
+}
+
+// NewRegionalClient creates a client over regions, preferring them in the given order until latencies are known
+func NewRegionalClient(regions ...Region) *RegionalClient {
+	c := &RegionalClient{now: time.Now}
+	for _, region := range regions {
+		c.regions = append(c.regions, &regionState{Region: region, healthy: true})
+	}
+	return c
+}
+
+// ForwardRequest implements OpenAIClient
+func (c *RegionalClient) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	region := c.pick()
+
+	start := time.Now()
+	resp, err := region.Client.ForwardRequest(ctx, method, path, body)
+	c.report(region, time.Since(start), resp, err, false)
+	return resp, err
+}
+
+// Probe measures every region's latency and health with a request to
+// ProbePath every interval, until ctx is cancelled. While probing, latency
+// is only taken from probes, so slow prompts don't count against a region.
+// Once probing stops, latency is taken from requests again.
+func (c *RegionalClient) Probe(ctx context.Context, interval time.Duration) {
+	c.mu.Lock()
+	c.probing = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.probing = false
+		c.mu.Unlock()
+	}()
+
+	path := c.ProbePath
+	if path == "" {
+		path = defaultRegionProbePath
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, region := range c.regions {
+			probeCtx, cancel := context.WithTimeout(ctx, interval)
+			start := time.Now()
+			resp, err := region.Client.ForwardRequest(probeCtx, "GET", path, nil)
+			if resp != nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			c.report(region, time.Since(start), resp, err, true)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status reports every region, in configured order
+func (c *RegionalClient) Status() []RegionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := make([]RegionStatus, 0, len(c.regions))
+	for i, region := range c.regions {
+		status = append(status, RegionStatus{
+			Name:      region.Name,
+			URL:       region.URL,
+			Active:    i == c.active,
+			Healthy:   region.healthy,
+			LatencyMs: float64(region.latency) / float64(time.Millisecond),
+			Failures:  region.failures,
+			Requests:  region.requests,
+			Errors:    region.errors,
+		})
+		if share := c.share(region); share < 1 {
+			status[len(status)-1].SlowStart = share
+		}
+	}
+	return status
+}
+
+// pick returns the region to send the next request to, moving traffic when
+// the active region is down or clearly slower than a settled alternative
+func (c *RegionalClient) pick() *regionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if region := c.trial(); region != nil {
+		return region
+	}
+	c.choose()
+	return c.warm()
+}
+
+// trial returns an unhealthy region due another try, nil if none is. Without
+// probes only requests can show a region has recovered, so once TrialAfter
+// has passed since a region last failed, a single request is sent to it and
+// its outcome decides whether the region is healthy again. Callers hold mu.
+func (c *RegionalClient) trial() *regionState {
+	if c.probing {
+		return nil
+	}
+	for _, region := range c.regions {
+		if !region.healthy && !region.trying && c.now().Sub(region.failedAt) >= c.trialAfter() {
+			region.trying = true
+			fmt.Printf("Trying unhealthy region %s again\n", region.Name)
+			return region
+		}
+	}
+	return nil
+}
+
+// choose makes the best region active when the active one is down or
+// clearly slower than a settled alternative. Callers hold mu.
+func (c *RegionalClient) choose() {
+	current := c.regions[c.active]
+	best := c.best(-1)
+	if best < 0 || best == c.active {
+		// With every region down, keep trying the one in use
+		return
+	}
+
+	candidate := c.regions[best]
+	switch {
+	case !current.healthy:
+		fmt.Printf("Region %s is unhealthy, failing over to %s\n", current.Name, candidate.Name)
+	case c.now().Sub(candidate.healthySince) >= c.failbackAfter() && current.latency > 0 && candidate.latency > 0 &&
+		float64(candidate.latency) < float64(current.latency)*(1-c.switchMargin()):
+		fmt.Printf("Region %s is faster than %s (%v vs %v), switching\n",
+			candidate.Name, current.Name, candidate.latency.Round(time.Millisecond), current.latency.Round(time.Millisecond))
+	default:
+		return
+	}
+	c.active = best
+}
+
+// warm returns the region a request goes to. It is the active region, unless
+// that region is in its slow start, which starts when it is first active after
+// recovering. Only a share of the requests then goes to it, rising evenly over
+// SlowStart, and the rest go to the best other healthy region. Callers hold mu.
+func (c *RegionalClient) warm() *regionState {
+	active := c.regions[c.active]
+	if c.SlowStart <= 0 {
+		return active
+	}
+	if active.recovered && active.healthy {
+		active.recovered = false
+		active.rampStart = c.now()
+		active.rampCredit = 0
+		fmt.Printf("Region %s recovered, ramping its traffic up over %v\n", active.Name, c.SlowStart)
+	}
+
+	share := c.share(active)
+	other := c.best(c.active)
+	if share >= 1 || other < 0 {
+		return active
+	}
+	active.rampCredit += share
+	if active.rampCredit >= 1 {
+		active.rampCredit--
+		return active
+	}
+	return c.regions[other]
+}
+
+// share returns the share of its requests a region is sent, below 1 during
+// its slow start. Callers hold mu.
+func (c *RegionalClient) share(region *regionState) float64 {
+	if c.SlowStart <= 0 || region.rampStart.IsZero() {
+		return 1
+	}
+	elapsed := c.now().Sub(region.rampStart)
+	if elapsed >= c.SlowStart {
+		return 1
+	}
+	return max(float64(elapsed)/float64(c.SlowStart), slowStartMinShare)
+}
+
+// best returns the healthy region with the lowest latency, regions not yet
+// measured coming last in configured order, leaving out region skip; -1 if
+// none is healthy. Callers hold mu.
+func (c *RegionalClient) best(skip int) int {
+	best := -1
+	for i, region := range c.regions {
+		if !region.healthy || i == skip {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := c.regions[best]
+		if region.latency > 0 && (b.latency == 0 || region.latency < b.latency) {
+			best = i
+		}
+	}
+	return best
+}
+
+// report records the outcome of a request or probe to a region
+func (c *RegionalClient) report(region *regionState, latency time.Duration, resp *http.Response, err error, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !probe {
+		region.trying = false
+	}
+	// Preempted and abandoned requests say nothing about the region
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+
+	if !probe {
+		region.requests++
+	}
+	if failed {
+		if !probe {
+			region.errors++
+		}
+		region.failures++
+		region.failedAt = c.now()
+		if region.healthy && region.failures >= c.failureThreshold() {
+			region.healthy = false
+			fmt.Printf("Region %s marked unhealthy after %d failures\n", region.Name, region.failures)
+		}
+		return
+	}
+
+	region.failures = 0
+	if !region.healthy {
+		region.healthy = true
+		region.healthySince = c.now()
+		region.recovered = true
+		fmt.Printf("Region %s is healthy again\n", region.Name)
+	}
+	if probe == c.probing {
+		if region.latency == 0 {
+			region.latency = latency
+		} else {
+			region.latency += time.Duration(regionLatencyWeight * float64(latency-region.latency))
+		}
+	}
+}
+
+// failureThreshold returns how many failures in a row take a region out
+func (c *RegionalClient) failureThreshold() int {
+	if c.FailureThreshold > 0 {
+		return c.FailureThreshold
+	}
+	return defaultRegionFailureThreshold
+}
+
+// failbackAfter returns how long a region must be healthy before traffic moves to it
+func (c *RegionalClient) failbackAfter() time.Duration {
+	if c.FailbackAfter > 0 {
+		return c.FailbackAfter
+	}
+	return defaultRegionFailbackAfter
+}
+
+// trialAfter returns how long an unhealthy region waits before a request tries it again
+func (c *RegionalClient) trialAfter() time.Duration {
+	if c.TrialAfter > 0 {
+		return c.TrialAfter
+	}
+	return defaultRegionTrialAfter
+}
+
+// switchMargin returns how much faster another region must be to move to it
+func (c *RegionalClient) switchMargin() float64 {
+	if c.SwitchMargin > 0 {
+		return c.SwitchMargin
+	}
+	return defaultRegionSwitchMargin
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRegion is an upstream region whose status code can be changed
+type fakeRegion struct {
+	status atomic.Int32
+	calls  atomic.Int32
+}
+
+func newFakeRegion() *fakeRegion {
+	r := &fakeRegion{}
+	r.status.Store(http.StatusOK)
+	return r
+}
+
+func (r *fakeRegion) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	r.calls.Add(1)
+	status := int(r.status.Load())
+	if status == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+}
+
+func TestRegionalClientFailover(t *testing.T) {
+	east, west, north := newFakeRegion(), newFakeRegion(), newFakeRegion()
+	client := NewRegionalClient(
+		Region{Name: "eastus", Client: east},
+		Region{Name: "westus", Client: west},
+		Region{Name: "northeurope", Client: north},
+	)
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	client.probing = true
+
+	// Until latencies are known, the first region is used
+	send := func() {
+		resp, _ := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+	send()
+	if east.calls.Load() != 1 {
+		t.Fatalf("Expected the first region to be used, got %d calls", east.calls.Load())
+	}
+
+	// An incident in the region moves traffic once it has failed enough times
+	east.status.Store(http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		send()
+	}
+	send()
+	if east.calls.Load() != 4 || west.calls.Load() != 1 {
+		t.Fatalf("Expected failover to westus after 3 failures, got %d east and %d west calls", east.calls.Load(), west.calls.Load())
+	}
+
+	// The region recovers and is faster, but traffic sticks until it has stayed healthy
+	ok := &http.Response{StatusCode: http.StatusOK}
+	client.report(client.regions[1], 100*time.Millisecond, ok, nil, true)
+	client.report(client.regions[0], 40*time.Millisecond, ok, nil, true)
+	send()
+	if west.calls.Load() != 2 {
+		t.Errorf("Expected traffic to stick with westus right after eastus recovered, got %d west calls", west.calls.Load())
+	}
+
+	now = now.Add(2 * time.Minute)
+	east.status.Store(http.StatusOK)
+	send()
+	if east.calls.Load() != 5 {
+		t.Errorf("Expected traffic back on eastus once it settled, got %d east calls", east.calls.Load())
+	}
+
+	// Small latency differences don't move traffic
+	client.report(client.regions[2], 38*time.Millisecond, ok, nil, true)
+	send()
+	if east.calls.Load() != 6 || north.calls.Load() != 0 {
+		t.Errorf("Expected traffic to stay on eastus, got %d east and %d north calls", east.calls.Load(), north.calls.Load())
+	}
+
+	status := client.Status()
+	if !status[0].Active || !status[0].Healthy || status[0].LatencyMs != 40 || status[0].Errors != 3 || status[1].Requests != 2 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
+
+func TestRegionalClientProbe(t *testing.T) {
+	east, west := newFakeRegion(), newFakeRegion()
+	east.status.Store(0)
+	client := NewRegionalClient(Region{Name: "eastus", Client: east}, Region{Name: "westus", Client: west})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Probe(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		status := client.Status()
+		if !status[0].Healthy && status[1].LatencyMs > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := client.Status()
+	if status[0].Healthy || !status[1].Healthy || status[1].LatencyMs == 0 {
+		t.Fatalf("Expected probes to take eastus out and measure westus, got %+v", status)
+	}
+	if status[0].Requests != 0 || status[0].Errors != 0 {
+		t.Errorf("Expected probes not to count as requests, got %+v", status[0])
+	}
+
+	// Requests now skip the unreachable region without having to fail first
+	resp, err := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the request to be served by westus, got %v", err)
+	}
+}
+
+func TestRegionalClientTrial(t *testing.T) {
+	east, west := newFakeRegion(), newFakeRegion()
+	client := NewRegionalClient(Region{Name: "eastus", Client: east}, Region{Name: "westus", Client: west})
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	client.TrialAfter = 30 * time.Second
+
+	send := func() {
+		resp, _ := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+
+	// Without probes, a region taken out is left alone until its trial is due
+	east.status.Store(http.StatusServiceUnavailable)
+	for i := 0; i < 3; i++ {
+		send()
+	}
+	now = now.Add(10 * time.Second)
+	send()
+	if east.calls.Load() != 3 || west.calls.Load() != 1 {
+		t.Fatalf("Expected eastus left alone before its trial, got %d east and %d west calls", east.calls.Load(), west.calls.Load())
+	}
+
+	// then tried with a single request, which keeps it out while it fails
+	now = now.Add(30 * time.Second)
+	send()
+	send()
+	if east.calls.Load() != 4 || west.calls.Load() != 2 || client.Status()[0].Healthy {
+		t.Fatalf("Expected a single failed trial of eastus, got %d east and %d west calls", east.calls.Load(), west.calls.Load())
+	}
+
+	// and brings it back once it succeeds
+	east.status.Store(http.StatusOK)
+	now = now.Add(30 * time.Second)
+	send()
+	if east.calls.Load() != 5 || !client.Status()[0].Healthy {
+		t.Errorf("Expected a successful trial to bring eastus back, got %+v", client.Status()[0])
+	}
+}
+
+func TestRegionalClientSlowStart(t *testing.T) {
+	east, west := newFakeRegion(), newFakeRegion()
+	client := NewRegionalClient(Region{Name: "eastus", Client: east}, Region{Name: "westus", Client: west})
+	client.SlowStart = 10 * time.Second
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	client.probing = true
+	send := func(n int) {
+		for range n {
+			resp, _ := client.ForwardRequest(context.Background(), "POST", "/v1/chat/completions", nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+	}
+
+	// eastus fails over to westus, then recovers and is faster
+	failed := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	ok := &http.Response{StatusCode: http.StatusOK}
+	for range 3 {
+		client.report(client.regions[0], time.Second, failed, nil, true)
+	}
+	send(1)
+	client.report(client.regions[0], 40*time.Millisecond, ok, nil, true)
+	client.report(client.regions[1], 100*time.Millisecond, ok, nil, true)
+	now = now.Add(2 * time.Minute)
+
+	// Traffic moves back, but only a tenth of it to begin with
+	east.calls.Store(0)
+	west.calls.Store(0)
+	send(10)
+	if east.calls.Load() > 1 || west.calls.Load() < 9 {
+		t.Errorf("Expected most requests kept on westus as slow start begins, got %d east and %d west", east.calls.Load(), west.calls.Load())
+	}
+	if status := client.Status(); !status[0].Active || status[0].SlowStart != slowStartMinShare {
+		t.Errorf("Expected eastus active in slow start, got %+v", status[0])
+	}
+
+	// Halfway through, half of it
+	now = now.Add(5 * time.Second)
+	east.calls.Store(0)
+	send(10)
+	if got := east.calls.Load(); got < 4 || got > 6 {
+		t.Errorf("Expected about half the requests on eastus halfway through slow start, got %d", got)
+	}
+
+	// And all of it once slow start is over
+	now = now.Add(5 * time.Second)
+	east.calls.Store(0)
+	send(10)
+	if got := east.calls.Load(); got != 10 {
+		t.Errorf("Expected every request on eastus after slow start, got %d", got)
+	}
+	if status := client.Status(); status[0].SlowStart != 0 {
+		t.Errorf("Expected slow start over, got %+v", status[0])
+	}
+}
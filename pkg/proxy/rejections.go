@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// Reasons the proxy itself turns a request away, reported as the Rejected
+// metric so local rejections can be told apart from upstream throttling
+const (
+	RejectRateLimited  = "rate_limited"        // Over the key's rate limit
+	RejectQuota        = "quota_exceeded"      // The key's token quota is spent, or can't cover the request
+	RejectQueueFull    = "queue_full"          // No room on the request's queue
+	RejectQueueBytes   = "queue_bytes"         // The request's queue holds its limit of body bytes
+	RejectQueueTimeout = "queue_timeout"       // The request waited past its endpoint's queue timeout
+	RejectShuttingDown = "shutting_down"       // The proxy is draining for shutdown
+	RejectMaintenance  = "maintenance"         // Maintenance mode is on
+	RejectBackend      = "backend_unavailable" // The shared queue backend couldn't take the request
+	RejectMigrated     = "migrated"            // The request was exported to another instance while queued
+)
+
+// recordRejection reports a request the proxy answered itself with a 429 or
+// 503, tagged with its queue's priority, its path and client key
+func (qm *QueueManager) recordRejection(r *http.Request, priority int, model string, status int, reason string) {
+	now := time.Now()
+	qm.finish(RequestOutcome{
+		RequestID:  requestID(r),
+		KeyID:      clientKeyID(r),
+		Model:      model,
+		Path:       r.URL.Path,
+		Priority:   priority,
+		Outcome:    OutcomeRejected,
+		Reason:     reason,
+		Status:     status,
+		QueuedAt:   now,
+		FinishedAt: now,
+	}, metrics.RequestMetrics{})
+}
+
+// rejected records a request the handler turned away. Rejections before the
+// request's queue is known are counted under the queue of its port.
+func (h *RequestHandler) rejected(r *http.Request, queue *PriorityQueue, model string, status int, reason string) {
+	if queue == nil {
+		if port, err := listenerPort(r); err == nil {
+			queue = h.QueueManager.FindQueueByPort(port)
+		}
+	}
+	priority := 0
+	if queue != nil {
+		priority = queue.Priority
+	}
+	h.QueueManager.recordRejection(r, priority, model, status, reason)
+}
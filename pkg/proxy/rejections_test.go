@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+func TestRejectionMetrics(t *testing.T) {
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	var mu sync.Mutex
+	var recorded []metrics.RequestMetrics
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// Ignore requests left over from other tests
+		if m.KeyID == KeyID("rejection-test") {
+			recorded = append(recorded, m)
+		}
+		return nil
+	}
+
+	send := func(handler *RequestHandler) int {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Authorization", "Bearer rejection-test")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// Without a scheduler the queue fills up, then the key runs into its rate limit
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{})
+	qm.Queues[0].Requests = make(chan *workRequest)
+	handler := NewRequestHandler(qm)
+	handler.Limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), time.Minute, 1, 0, nil)
+	send(handler)
+	send(handler)
+
+	// An upstream 429 is recorded as the upstream's, not a rejection
+	upstream := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{
+		ResponseBody:   `{"error":{"message":"Rate limit reached"}}`,
+		ResponseStatus: http.StatusTooManyRequests,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go upstream.StartScheduler(ctx)
+	if code := send(NewRequestHandler(upstream)); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the upstream's 429 passed on, got %d", code)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(recorded) != 3 {
+		t.Fatalf("Expected 3 requests recorded, got %+v", recorded)
+	}
+	for i, reason := range []string{RejectQueueFull, RejectRateLimited, ""} {
+		m := recorded[i]
+		if m.Rejected != reason || m.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("Request %d: expected 429 rejected as %q, got %d rejected as %q", i, reason, m.StatusCode, m.Rejected)
+		}
+		if m.Priority != 1 || m.EndpointPath != "/v1/chat/completions" {
+			t.Errorf("Request %d: expected endpoint recorded, got %+v", i, m)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+// Reloader re-reads the config while the proxy runs, e.g. when a mounted
+// Kubernetes ConfigMap or Secret changes, and applies the settings the
+// running proxy can change in place: the upstream API key, endpoints'
+// max_concurrent and client auth, and the default rate limits. Changes to
+// anything else are reported as needing a restart. Readiness reports not
+// ready while a reload is applied.
+type Reloader struct {
+	Keys        *openai.KeyRing     // Rotated to a changed openai_api_key when set
+	Queues      *QueueManager       // Given changed max_concurrent limits when set
+	Limiter     *ratelimit.Limiter  // Given changed rate limits when set
+	Schedule    *Schedule           // Given changed rate limits instead of Limiter when set
+	Auth        map[int]*AuthSwitch // Given changed client auth, keyed by endpoint port
+	Maintenance *Maintenance        // Readiness gate held while reloading when set
+	load        func() (*config.Config, error)
+	mu          sync.Mutex
+	started     *config.Config // Config the proxy started with
+	current     *config.Config // Config last applied
+	status      ReloadStatus
+}
+
+// ReloadStatus reports the outcome of config reloads
+type ReloadStatus struct {
+	Reloads       int       `json:"reloads"`                  // Reloads applied since the proxy started
+	LastReload    time.Time `json:"last_reload,omitempty"`    // When the config was last applied
+	LastError     string    `json:"last_error,omitempty"`     // Why the latest reload failed, empty if it didn't
+	RestartNeeded []string  `json:"restart_needed,omitempty"` // Changed settings that only take effect on restart
+}
+
+// NewReloader creates a reloader for the proxy running with cfg, reading
+// the config again with load
+func NewReloader(cfg *config.Config, load func() (*config.Config, error)) *Reloader {
+	return &Reloader{load: load, started: cfg, current: cfg}
+}
+
+// Reload reads the config and applies what changed. A config that can't be
+// loaded or applied leaves the running one in place.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Maintenance != nil {
+		defer r.Maintenance.holdReadiness()()
+	}
+
+	cfg, err := r.load()
+	if err == nil {
+		err = r.apply(cfg)
+	}
+	if err != nil {
+		r.status.LastError = err.Error()
+		return err
+	}
+
+	r.current = cfg
+	r.status.Reloads++
+	r.status.LastReload = time.Now()
+	r.status.LastError = ""
+	r.status.RestartNeeded = restartNeeded(r.started, cfg)
+	log.Printf("Reloaded config")
+	if len(r.status.RestartNeeded) > 0 {
+		log.Printf("Config changes to %s take effect on restart", strings.Join(r.status.RestartNeeded, ", "))
+	}
+	return nil
+}
+
+// apply puts the settings that can change in place into effect, checking
+// they are all valid before changing any
+func (r *Reloader) apply(cfg *config.Config) error {
+	old := make(map[int]config.Endpoint, len(r.current.Endpoints))
+	for _, ep := range r.current.Endpoints {
+		old[ep.Port] = ep
+	}
+
+	auth := make(map[int]Authorizer)
+	for _, ep := range cfg.Endpoints {
+		prev, ok := old[ep.Port]
+		if !ok || reflect.DeepEqual(prev.Auth, ep.Auth) {
+			continue
+		}
+		a, err := NewAuthorizer(ep.Auth)
+		if err != nil {
+			return fmt.Errorf("invalid auth for port %d: %w", ep.Port, err)
+		}
+		// Turning auth on or off changes the port's handlers, which needs a restart
+		if a != nil && r.Auth[ep.Port] != nil {
+			auth[ep.Port] = a
+		}
+	}
+
+	fromFile := cfg.OpenAIAPIKeyFile != "" || cfg.Secrets.OpenAIAPIKey != ""
+	if r.Keys != nil && !fromFile && cfg.OpenAIAPIKey != "" && cfg.OpenAIAPIKey != r.current.OpenAIAPIKey {
+		r.Keys.Rotate(cfg.OpenAIAPIKey, time.Duration(cfg.KeyRotationGrace)*time.Second)
+		log.Printf("Rotated upstream API key from reloaded config")
+	}
+	for _, ep := range cfg.Endpoints {
+		prev, ok := old[ep.Port]
+		if !ok {
+			continue
+		}
+		if r.Queues != nil && ep.MaxConcurrent != prev.MaxConcurrent && r.Queues.SetMaxConcurrent(ep.Port, ep.MaxConcurrent) {
+			log.Printf("Changed max_concurrent for port %d to %d", ep.Port, ep.MaxConcurrent)
+		}
+		if a, ok := auth[ep.Port]; ok {
+			r.Auth[ep.Port].Set(a)
+			log.Printf("Changed client auth for port %d", ep.Port)
+		}
+	}
+	perKey, org := cfg.RateLimits.RequestsPerKey, cfg.RateLimits.OrgRequests
+	if perKey != r.current.RateLimits.RequestsPerKey || org != r.current.RateLimits.OrgRequests {
+		switch {
+		case r.Schedule != nil:
+			r.Schedule.SetLimits(perKey, org)
+		case r.Limiter != nil:
+			r.Limiter.SetLimits(perKey, org)
+		}
+	}
+	return nil
+}
+
+// Status reports the outcome of reloads so far
+func (r *Reloader) Status() ReloadStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := r.status
+	status.RestartNeeded = slices.Clone(status.RestartNeeded)
+	return status
+}
+
+// Watch reloads the config whenever the files at paths change, checking
+// every interval until ctx is cancelled. Directories are watched for
+// changes to any file in them, following symlinks, so a ConfigMap's
+// atomic update is noticed once, when its ..data link is swapped.
+func (r *Reloader) Watch(ctx context.Context, paths []string, interval time.Duration) {
+	last := fingerprint(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sum := fingerprint(paths)
+		if sum == last {
+			continue
+		}
+		last = sum
+
+		if err := r.Reload(); err != nil {
+			log.Printf("Error reloading config, keeping the running one: %v", err)
+		}
+	}
+}
+
+// fingerprint hashes the names and contents of the files at paths. Files
+// that can't be read count by their error, so one appearing is a change.
+func fingerprint(paths []string) [sha256.Size]byte {
+	h := sha256.New()
+	var add func(path string)
+	add = func(path string) {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00%v\x00", path, err)
+			return
+		}
+		if !info.IsDir() {
+			fmt.Fprintf(h, "%s\x00", path)
+			if f, err := os.Open(path); err == nil {
+				io.Copy(h, f)
+				f.Close()
+			}
+			h.Write([]byte{0})
+			return
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00%v\x00", path, err)
+			return
+		}
+		for _, entry := range entries {
+			// Kubernetes keeps each version of a mounted volume in ..-prefixed
+			// directories, the files themselves link into the current one
+			if !strings.HasPrefix(entry.Name(), "..") {
+				add(filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	for _, path := range paths {
+		add(path)
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// restartNeeded lists the settings that differ between the config the proxy
+// started with and cfg, other than those applied in place
+func restartNeeded(started, cfg *config.Config) []string {
+	a, b := *started, *cfg
+	for _, c := range []*config.Config{&a, &b} {
+		c.OpenAIAPIKey = ""
+		c.RateLimits.RequestsPerKey, c.RateLimits.OrgRequests = 0, 0
+		endpoints := slices.Clone(c.Endpoints)
+		for i := range endpoints {
+			endpoints[i].MaxConcurrent = 0
+			endpoints[i].Auth = config.AuthConfig{Mode: endpoints[i].Auth.Mode}
+		}
+		c.Endpoints = endpoints
+	}
+
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
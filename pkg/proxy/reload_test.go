@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{
+	  "openai_api_key": "upstream-1",
+	  "endpoints": [{"port": 8080, "priority": 1, "auth": {"mode": "keys", "keys": ["client-1"]}}],
+	  "rate_limits": {"requests_per_key": 10}
+	}`)
+
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := NewQueueManager(cfg.Endpoints, &MockOpenAIClient{})
+	auth, _ := NewAuthorizer(cfg.Endpoints[0].Auth)
+	reloader := NewReloader(cfg, func() (*config.Config, error) { return config.LoadConfig(path) })
+	reloader.Keys = openai.NewKeyRing(cfg.OpenAIAPIKey, 0)
+	reloader.Queues = qm
+	reloader.Limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(), time.Minute, 10, 0, nil)
+	reloader.Auth = map[int]*AuthSwitch{8080: NewAuthSwitch(auth)}
+	reloader.Maintenance = NewMaintenance("", 0)
+
+	// Settings that can change in place do, the others wait for a restart
+	write(`{
+	  "openai_api_key": "upstream-2",
+	  "influx_bucket": "other",
+	  "endpoints": [{"port": 8080, "priority": 1, "max_concurrent": 2, "auth": {"mode": "keys", "keys": ["client-2"]}}],
+	  "rate_limits": {"requests_per_key": 5}
+	}`)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if reloader.Keys.Current() != "upstream-2" {
+		t.Errorf("Expected the upstream key rotated, got %s", reloader.Keys.Current())
+	}
+	if limit := qm.Status()[0].MaxConcurrent; limit != 2 {
+		t.Errorf("Expected max_concurrent 2, got %d", limit)
+	}
+	if perKey, _ := reloader.Limiter.Limits(); perKey != 5 {
+		t.Errorf("Expected 5 requests per key, got %d", perKey)
+	}
+	for key, ok := range map[string]bool{"client-1": false, "client-2": true} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if _, err := reloader.Auth[8080].Authorize(req); (err == nil) != ok {
+			t.Errorf("Key %s: expected accepted %v, got error %v", key, ok, err)
+		}
+	}
+	status := reloader.Status()
+	if status.Reloads != 1 || !slices.Equal(status.RestartNeeded, []string{"influx_bucket"}) {
+		t.Errorf("Expected influx_bucket to need a restart, got %+v", status)
+	}
+
+	// A config with mistakes leaves the running one in place
+	write(`{"endpoints": [{"port": 8080, "priority": 1, "max_concurent": 4}]}`)
+	if err := reloader.Reload(); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	status = reloader.Status()
+	if status.Reloads != 1 || !strings.Contains(status.LastError, "max_concurent") || qm.Status()[0].MaxConcurrent != 2 {
+		t.Errorf("Expected the failed reload recorded and nothing changed, got %+v", status)
+	}
+
+	// Readiness is held while a reload is applied
+	release := reloader.Maintenance.holdReadiness()
+	recorder := httptest.NewRecorder()
+	reloader.Maintenance.serveReady(recorder, httptest.NewRequest("GET", "/proxy/ready", nil))
+	release()
+	if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), "reloading") {
+		t.Errorf("Expected not ready while reloading, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// A mounted ConfigMap: files link into the current ..data version
+	dir := t.TempDir()
+	version := func(name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "config.json"), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filepath.Join(dir, "..data"))
+		if err := os.Symlink(name, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	version("..v1", `{"endpoints": []}`)
+	if err := os.Symlink(filepath.Join("..data", "config.json"), filepath.Join(dir, "config.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	before := fingerprint([]string{dir})
+	if fingerprint([]string{dir}) != before {
+		t.Error("Expected an unchanged directory to keep its fingerprint")
+	}
+	version("..v2", `{"endpoints": [{"port": 8080, "priority": 1}]}`)
+	if fingerprint([]string{dir}) == before {
+		t.Error("Expected swapping ..data to change the fingerprint")
+	}
+}
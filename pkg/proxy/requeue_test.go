@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 	
+	"github.com/mule-ai/proxy/pkg/config"
 	"github.com/mule-ai/proxy/pkg/metrics"
 )
 
@@ -19,7 +20,7 @@ import (
 // because the queue is full
 func TestQueuePreemption(t *testing.T) {
 	// Initialize metrics
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a slow mock client to simulate a long-running request
 	mockClient := &MockOpenAIClient{
@@ -163,7 +164,7 @@ func TestQueuePreemption(t *testing.T) {
 // a preempted request cannot be requeued because the queue is full
 func TestQueueFullOnRequeue(t *testing.T) {
 	// Initialize metrics
-	metrics.NewMetricsCollector("http://localhost:8086", "test-token", "test-org", "test-bucket")
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
 	
 	// Create a mock client that always takes a long time to respond to help with preemption
 	mockClient := &MockOpenAIClient{
@@ -292,4 +293,67 @@ func TestQueueFullOnRequeue(t *testing.T) {
 	if bodyString != `{"error":"Service overloaded, please try again later"}` {
 		t.Errorf("Unexpected response body: %s", bodyString)
 	}
-}
\ No newline at end of file
+}
+
+// TestRequeueInheritsPriority tests that a requeued request keeps what it
+// arrived with, and moves up with RequeueBoost
+func TestRequeueInheritsPriority(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, Preemptive: true},
+		{Port: 8081, Priority: 2},
+		{Port: 8082, Priority: 3},
+		{Port: 8083, Priority: 4},
+	}, &MockOpenAIClient{})
+	low := qm.FindQueue(4)
+
+	start := time.Now().Add(-time.Minute)
+	httpReq, _ := http.NewRequest("POST", "/v1/chat/completions", nil)
+	req := &workRequest{
+		Request:        httpReq,
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+		StartTime:      start,
+		Priority:       4,
+		Boosted:        true,
+		Demoted:        true,
+		Tags:           map[string]string{"team": "search"},
+		User:           "user-1",
+		SessionID:      "session-1",
+		KeyID:          "key-1",
+		RetryCount:     1,
+	}
+
+	if !qm.requeue(req, low) {
+		t.Fatal("Expected the request to be requeued")
+	}
+	retry := <-low.Requests
+	if !retry.StartTime.Equal(start) || retry.Priority != 4 || !retry.Boosted || !retry.Demoted || retry.Tags["team"] != "search" ||
+		retry.User != "user-1" || retry.SessionID != "session-1" || retry.KeyID != "key-1" || retry.RetryCount != 1 {
+		t.Errorf("Expected the retry to keep the original request's details, got %+v", retry)
+	}
+
+	// Boosting moves the retry up, but not into the preemptive queue
+	qm.RequeueBoost = 1
+	qm.requeue(retry, low)
+	if len(qm.FindQueue(3).Requests) != 1 {
+		t.Fatal("Expected the retry to move up one queue")
+	}
+	retry = <-qm.FindQueue(3).Requests
+	if retry.Priority != 4 {
+		t.Errorf("Expected the retry to keep its original priority, got %d", retry.Priority)
+	}
+
+	// The boost is counted from the queue the request arrived on, so it
+	// doesn't compound when the request is requeued again
+	qm.requeue(retry, qm.FindQueue(3))
+	if len(qm.FindQueue(3).Requests) != 1 {
+		t.Fatal("Expected the retry to stay one queue up")
+	}
+	retry = <-qm.FindQueue(3).Requests
+
+	qm.RequeueBoost = 5
+	qm.requeue(retry, qm.FindQueue(3))
+	if len(qm.FindQueue(2).Requests) != 1 || len(qm.FindQueue(1).Requests) != 0 {
+		t.Error("Expected the retry to stop below the preemptive queue")
+	}
+}
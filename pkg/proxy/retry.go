@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"path"
+	"strings"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// defaultRetryRules lists the requests known to be free of side effects.
+// Anything not matched here (file uploads, fine-tune creation, batches,
+// assistant/thread mutations, responses, ...) is never replayed. A response
+// can run in the background, call server-side tools or be stored upstream,
+// so a replay may repeat what its abandoned attempt already did.
+var defaultRetryRules = []config.RetryRule{
+	{Method: "GET", Path: "/**", Retryable: true},
+	{Method: "POST", Path: "/v1/chat/completions", Retryable: true},
+	{Method: "POST", Path: "/v1/completions", Retryable: true},
+	{Method: "POST", Path: "/v1/embeddings", Retryable: true},
+	{Method: "POST", Path: "/v1/moderations", Retryable: true},
+}
+
+// RetryClassifier decides whether a request can be safely replayed after preemption
+type RetryClassifier struct {
+	rules []config.RetryRule
+}
+
+// NewRetryClassifier creates a classifier where the given rules take precedence over the defaults
+func NewRetryClassifier(rules []config.RetryRule) *RetryClassifier {
+	all := make([]config.RetryRule, 0, len(rules)+len(defaultRetryRules))
+	all = append(all, rules...)
+	all = append(all, defaultRetryRules...)
+
+	return &RetryClassifier{rules: all}
+}
+
+// IsRetryable reports whether a request may be replayed; the first matching rule wins
+func (c *RetryClassifier) IsRetryable(method, reqPath string) bool {
+	for _, rule := range c.rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		if matchPath(rule.Path, reqPath) {
+			return rule.Retryable
+		}
+	}
+	return false
+}
+
+// matchPath matches a request path against a path.Match pattern. A pattern
+// ending in "/**" matches the prefix itself and everything below it.
+func matchPath(pattern, reqPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if prefix == "" || reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/") {
+			return true
+		}
+		// Allow wildcards inside the prefix, e.g. "/v1/threads/*/runs/**"
+		parts := strings.Split(reqPath, "/")
+		for i := len(parts); i > 0; i-- {
+			if ok, _ := path.Match(prefix, strings.Join(parts[:i], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	ok, _ := path.Match(pattern, reqPath)
+	return ok
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestRetryClassifierDefaults(t *testing.T) {
+	classifier := NewRetryClassifier(nil)
+
+	tests := []struct {
+		method    string
+		path      string
+		retryable bool
+	}{
+		{"GET", "/v1/models", true},
+		{"GET", "/v1/files/file-123", true},
+		{"POST", "/v1/chat/completions", true},
+		{"POST", "/v1/completions", true},
+		{"POST", "/v1/embeddings", true},
+		{"POST", "/v1/responses", false},
+		{"POST", "/v1/files", false},
+		{"POST", "/v1/fine_tuning/jobs", false},
+		{"DELETE", "/v1/files/file-123", false},
+	}
+
+	for _, tt := range tests {
+		if got := classifier.IsRetryable(tt.method, tt.path); got != tt.retryable {
+			t.Errorf("IsRetryable(%s, %s) = %v, expected %v", tt.method, tt.path, got, tt.retryable)
+		}
+	}
+}
+
+func TestRetryClassifierOverrides(t *testing.T) {
+	classifier := NewRetryClassifier([]config.RetryRule{
+		{Method: "POST", Path: "/v1/chat/completions", Retryable: false},
+		{Path: "/v1/threads/*/runs/**", Retryable: true},
+	})
+
+	if classifier.IsRetryable("POST", "/v1/chat/completions") {
+		t.Error("Expected configured rule to make chat completions non-retryable")
+	}
+
+	if !classifier.IsRetryable("POST", "/v1/threads/thread-1/runs/run-1/cancel") {
+		t.Error("Expected wildcard rule to match nested thread run paths")
+	}
+
+	// Defaults still apply to anything the overrides don't match
+	if !classifier.IsRetryable("POST", "/v1/embeddings") {
+		t.Error("Expected default rule to apply to embeddings")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/v1/models", "/v1/models", true},
+		{"/v1/models", "/v1/models/gpt-4", false},
+		{"/v1/files/*", "/v1/files/file-123", true},
+		{"/v1/files/**", "/v1/files", true},
+		{"/v1/files/**", "/v1/files/file-123/content", true},
+		{"/v1/files/**", "/v1/filesystem", false},
+		{"/**", "/anything/at/all", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.path); got != tt.match {
+			t.Errorf("matchPath(%s, %s) = %v, expected %v", tt.pattern, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestUnsafeRequestIsNotPreempted(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	mockClient := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(200 * time.Millisecond):
+				return &http.Response{
+					StatusCode: 200,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(bytes.NewBufferString(`{"id":"file-123"}`)),
+				}, nil
+			}
+		},
+	}
+
+	highPriorityQueue := &PriorityQueue{
+		Port:       8080,
+		Priority:   1,
+		Preemptive: true,
+		Requests:   make(chan *workRequest, 10),
+	}
+
+	lowPriorityQueue := &PriorityQueue{
+		Port:     8081,
+		Priority: 2,
+		Requests: make(chan *workRequest, 10),
+	}
+
+	qm := &QueueManager{
+		Queues:          []*PriorityQueue{highPriorityQueue, lowPriorityQueue},
+		OpenAIClient:    mockClient,
+		RetryClassifier: NewRetryClassifier(nil),
+	}
+
+	// File uploads can't be replayed, so they must never be preempted
+	uploadReq, _ := http.NewRequest("POST", "http://example.com/v1/files", bytes.NewBufferString("file-data"))
+	recorder := httptest.NewRecorder()
+	workReq := &workRequest{
+		Request:        uploadReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+	}
+
+	highPriorityQueue.Requests <- &workRequest{
+		Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+	}
+
+	go qm.processRequest(workReq, lowPriorityQueue)
+
+	select {
+	case <-workReq.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Upload request did not complete")
+	}
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, recorder.Code)
+	}
+
+	if len(lowPriorityQueue.Requests) != 0 {
+		t.Error("Upload request should not have been requeued")
+	}
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// RetryBudgetHeader is the retries a request was allowed, when retry_budget is set
+const RetryBudgetHeader = "X-Proxy-Retry-Budget"
+
+// RetriesHeader is the retries a request made, over requeues and upstream retries
+const RetriesHeader = "X-Proxy-Retries"
+
+// writeRetryHeaders tells the client how much of its request's retry budget was spent
+func writeRetryHeaders(w http.ResponseWriter, budget *openai.RetryBudget) {
+	w.Header().Set(RetryBudgetHeader, strconv.Itoa(budget.Limit()))
+	w.Header().Set(RetriesHeader, strconv.Itoa(budget.Used()))
+}
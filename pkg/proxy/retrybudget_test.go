@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestRetryBudgetStopsPreemption(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	var calls atomic.Int32
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			calls.Add(1)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": {"application/json"}},
+					Body:       io.NopCloser(bytes.NewBufferString(`{"id":"test-response"}`)),
+				}, nil
+			}
+		},
+	}
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8081, Priority: 1, Preemptive: true},
+		{Port: 8080, Priority: 2},
+	}, client)
+	qm.RetryBudget = 1
+	qm.PreemptCheckInterval = 5 * time.Millisecond
+	high, low := qm.FindQueueByPort(8081), qm.FindQueueByPort(8080)
+
+	// Work keeps waiting on the preemptive queue throughout
+	high.Requests <- &workRequest{Request: httptest.NewRequest("POST", "/v1/chat/completions", nil), Done: make(chan struct{})}
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+	r.Header.Set("Content-Type", "application/json")
+	req := &workRequest{Request: r, ResponseWriter: recorder, Done: make(chan struct{}), StartTime: time.Now(), Model: "gpt-4"}
+
+	// The first attempt is preempted, spending the budget
+	qm.processRequest(req, low)
+	var retry *workRequest
+	select {
+	case retry = <-low.Requests:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request preempted and requeued")
+	}
+
+	// The second runs to completion despite the waiting work
+	qm.processRequest(retry, low)
+	select {
+	case <-req.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the retry to complete")
+	}
+
+	if recorder.Code != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("Expected a 200 after two upstream calls, got %d after %d", recorder.Code, calls.Load())
+	}
+	if recorder.Header().Get(RetryBudgetHeader) != "1" || recorder.Header().Get(RetriesHeader) != "1" {
+		t.Errorf("Expected the budget of 1 reported spent, got %v", recorder.Header())
+	}
+}
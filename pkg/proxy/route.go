@@ -0,0 +1,333 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// backendKey is the context key for the upstream a route picked
+type backendKey struct{}
+
+// contextWithBackend returns a context carrying the upstream a request should go to
+func contextWithBackend(ctx context.Context, backend string) context.Context {
+	return context.WithValue(ctx, backendKey{}, backend)
+}
+
+// backendFromContext returns the upstream carried by a context, if any
+func backendFromContext(ctx context.Context) string {
+	backend, _ := ctx.Value(backendKey{}).(string)
+	return backend
+}
+
+// BackendClient sends requests to the upstream their route picked, and
+// everything else to Default
+type BackendClient struct {
+	Default  OpenAIClient
+	Backends map[string]OpenAIClient // Keyed by upstream base URL
+	// Speculator races requests against a second upstream when their route asks for it
+	Speculator *Speculator
+}
+
+// ForwardRequest implements OpenAIClient
+func (c *BackendClient) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	backend := backendFromContext(ctx)
+	if speculative := speculativeFromContext(ctx); speculative != "" && c.Speculator != nil && c.Speculator.allow() {
+		return c.race(ctx, method, path, body, [2]string{backend, speculative})
+	}
+	return c.client(backend).ForwardRequest(ctx, method, path, body)
+}
+
+// client returns the client for an upstream, falling back to Default
+func (c *BackendClient) client(backend string) OpenAIClient {
+	if client, ok := c.Backends[backend]; ok {
+		return client
+	}
+	return c.Default
+}
+
+// label names an upstream in logs and statistics
+func (c *BackendClient) label(backend string) string {
+	if _, ok := c.Backends[backend]; !ok {
+		return "default"
+	}
+	return backend
+}
+
+// requestFeatures are the characteristics of a request routes match on
+type requestFeatures struct {
+	Model         string
+	ContextTokens int64 // Prompt tokens, counted with the model's tokenizer
+	MaxTokens     int64 // Requested output limit, 0 if unset
+	HasTools      bool
+	HasImages     bool
+	Stream        bool
+}
+
+// upstreamTarget is where a route sends a request
+type upstreamTarget struct {
+	Backend     string // Upstream base URL, empty for the default
+	Speculative string // Second upstream raced against Backend, empty for none
+}
+
+// routeBody is the part of a request body routes look at
+type routeBody struct {
+	estimateBody
+	Tools     []json.RawMessage `json:"tools"`
+	Functions []json.RawMessage `json:"functions"`
+	Stream    bool              `json:"stream"`
+}
+
+// RouteRequest is what a router decides a request's route from
+type RouteRequest struct {
+	Request  *http.Request // Headers and path, with the client's principal in its context
+	Body     []byte        // JSON body, empty for bodiless requests
+	Model    string        // Requested model
+	KeyID    string        // Client key ID (see KeyID)
+	Priority int           // Priority of the queue the request is headed for
+}
+
+// Route is where a router sends a request; zero fields leave it as it is
+type Route struct {
+	Name    string // Shown in logs
+	Model   string // Model used instead of the requested one
+	Backend string // Upstream base URL, empty for the default
+	// Speculative is a second upstream raced against Backend. A race is only
+	// decided once a whole response is in, so routers leave it empty for
+	// streaming requests.
+	Speculative string
+	// Priority is the queue the request waits in instead of the one it is
+	// headed for; boosted requests keep theirs
+	Priority int
+}
+
+// Router picks the model, backend and queue for a request. RuleRouter
+// follows the config's routes; programs embedding the proxy can set their
+// own, e.g. to pick models with a classifier. Routers are called
+// concurrently, for every request with a JSON body outside the stateful APIs.
+type Router interface {
+	// Route returns the route for a request, nil to leave it to model discovery
+	Route(req *RouteRequest) (*Route, error)
+}
+
+// RuleRouter picks the model and backend for a request from its characteristics
+type RuleRouter struct {
+	rules []config.RouteRule
+	// countTokens is set when a rule looks at context length, which needs the prompt tokenized
+	countTokens bool
+}
+
+// NewRuleRouter creates a router whose rules are tried in order, the first match winning
+func NewRuleRouter(rules []config.RouteRule) *RuleRouter {
+	rt := &RuleRouter{rules: rules}
+	for _, rule := range rules {
+		if rule.Match.MinContextTokens > 0 || rule.Match.MaxContextTokens > 0 {
+			rt.countTokens = true
+		}
+	}
+	return rt
+}
+
+// Route implements Router, following the first rule a POST request matches
+func (rt *RuleRouter) Route(req *RouteRequest) (*Route, error) {
+	if req.Request.Method != "POST" || len(req.Body) == 0 {
+		return nil, nil
+	}
+	features, err := rt.features(req.Body)
+	if err != nil {
+		// Not a JSON request; nothing to route on
+		return nil, nil
+	}
+	rule, ok := rt.match(features)
+	if !ok {
+		return nil, nil
+	}
+
+	route := &Route{Name: rule.Name, Model: rule.Model, Backend: rule.Backend, Priority: rule.Priority}
+	if !features.Stream {
+		route.Speculative = rule.Speculative
+	}
+	fmt.Printf("Routed request by rule %q (model: %s, backend: %s, context tokens: %d)\n",
+		rule.Name, cmp.Or(rule.Model, req.Model), rule.Backend, features.ContextTokens)
+	return route, nil
+}
+
+// features extracts what routes match on from a JSON request body
+func (rt *RuleRouter) features(body []byte) (requestFeatures, error) {
+	var parsed routeBody
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return requestFeatures{}, err
+	}
+
+	f := requestFeatures{
+		Model:     parsed.Model,
+		MaxTokens: parsed.maxOutput(),
+		HasTools:  len(parsed.Tools) > 0 || len(parsed.Functions) > 0,
+		Stream:    parsed.Stream,
+	}
+	for _, msg := range parsed.Messages {
+		if hasImages(msg.Content) {
+			f.HasImages = true
+			break
+		}
+	}
+	if !f.HasImages && len(parsed.Input) > 0 {
+		f.HasImages = inputHasImages(parsed.Input)
+	}
+
+	if rt.countTokens {
+		tokens, err := countInputTokens(&parsed.estimateBody)
+		if err != nil {
+			return requestFeatures{}, err
+		}
+		f.ContextTokens = tokens
+	}
+	return f, nil
+}
+
+// match returns the first rule a request's features satisfy
+func (rt *RuleRouter) match(f requestFeatures) (config.RouteRule, bool) {
+	for _, rule := range rt.rules {
+		if routeMatches(rule.Match, f) {
+			return rule, true
+		}
+	}
+	return config.RouteRule{}, false
+}
+
+// names reports whether a rule lists a model among the ones it matches
+func (rt *RuleRouter) names(model string) bool {
+	for _, rule := range rt.rules {
+		if matchModel(rule.Match.Models, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMatches reports whether features satisfy every condition a route sets
+func routeMatches(m config.RouteMatch, f requestFeatures) bool {
+	if len(m.Models) > 0 && !matchModel(m.Models, f.Model) {
+		return false
+	}
+	if m.MinContextTokens > 0 && f.ContextTokens < m.MinContextTokens {
+		return false
+	}
+	if m.MaxContextTokens > 0 && f.ContextTokens > m.MaxContextTokens {
+		return false
+	}
+	if m.MinMaxTokens > 0 && f.MaxTokens < m.MinMaxTokens {
+		return false
+	}
+	if m.Tools != nil && *m.Tools != f.HasTools {
+		return false
+	}
+	if m.Images != nil && *m.Images != f.HasImages {
+		return false
+	}
+	return true
+}
+
+// matchModel reports whether a model is listed, a trailing "*" matching any suffix
+func matchModel(patterns []string, model string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(model, prefix) {
+				return true
+			}
+		} else if pattern == model {
+			return true
+		}
+	}
+	return false
+}
+
+// hasImages reports whether message content includes an image part
+func hasImages(content json.RawMessage) bool {
+	var parts []struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(content, &parts) != nil {
+		return false
+	}
+	for _, part := range parts {
+		switch part.Type {
+		case "image_url", "image", "input_image":
+			return true
+		}
+	}
+	return false
+}
+
+// inputHasImages reports whether a responses API input has an image in any
+// of its items
+func inputHasImages(input json.RawMessage) bool {
+	var items []struct {
+		Content json.RawMessage `json:"content"`
+	}
+	if json.Unmarshal(input, &items) != nil {
+		return false
+	}
+	for _, item := range items {
+		if hasImages(item.Content) {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the body, model and upstream a request should be sent with,
+// and the queue it should wait in when its route names another, as its
+// router decides
+func (h *RequestHandler) route(r *http.Request, body []byte, model string, priority int) ([]byte, string, upstreamTarget, *PriorityQueue) {
+	// Assistants, threads and fine-tuning jobs only exist on the upstream that created them
+	if isStateful(r.URL.Path) {
+		return body, model, upstreamTarget{}, nil
+	}
+	if h.Router == nil {
+		return body, model, h.discovered(model), nil
+	}
+
+	route, err := h.Router.Route(&RouteRequest{Request: r, Body: body, Model: model, KeyID: clientKeyID(r), Priority: priority})
+	if err != nil {
+		// Serve the request unrouted rather than fail it
+		fmt.Printf("Router error: %v\n", err)
+		return body, model, h.discovered(model), nil
+	}
+	if route == nil {
+		return body, model, h.discovered(model), nil
+	}
+
+	if route.Model != "" && route.Model != model {
+		rewritten, err := openai.ReplaceModel(body, route.Model)
+		if err != nil {
+			return body, model, upstreamTarget{}, nil
+		}
+		body, model = rewritten, route.Model
+	}
+
+	var queue *PriorityQueue
+	if route.Priority > 0 && route.Priority != priority {
+		if queue = h.QueueManager.FindQueue(route.Priority); queue == nil {
+			fmt.Printf("Route %q names priority %d, which has no queue\n", route.Name, route.Priority)
+		}
+	}
+	return body, model, upstreamTarget{Backend: route.Backend, Speculative: route.Speculative}, queue
+}
+
+// discovered returns the upstream model discovery found a model on, or the
+// default when discovery is off or no backend lists it
+func (h *RequestHandler) discovered(model string) upstreamTarget {
+	if h.Discovery == nil || model == "" {
+		return upstreamTarget{}
+	}
+	backend, _ := h.Discovery.Backend(model)
+	return upstreamTarget{Backend: backend}
+}
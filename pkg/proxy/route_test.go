@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestRouterMatch(t *testing.T) {
+	yes, no := true, false
+	router := NewRuleRouter([]config.RouteRule{
+		{Name: "long-context", Match: config.RouteMatch{Models: []string{"gpt-4o*"}, MinContextTokens: 1000}, Model: "gpt-4.1"},
+		{Name: "vision", Match: config.RouteMatch{Images: &yes}, Backend: "http://vision"},
+		{Name: "long-output", Match: config.RouteMatch{MinMaxTokens: 8000, Tools: &no}, Model: "o3-mini"},
+	})
+
+	longPrompt := strings.Repeat("hello ", 2000)
+	tests := []struct {
+		name  string
+		body  string
+		route string
+	}{
+		{"long prompt", `{"model":"gpt-4o-mini","messages":[{"role":"user","content":"` + longPrompt + `"}]}`, "long-context"},
+		{"long prompt, other model", `{"model":"claude-3","messages":[{"role":"user","content":"` + longPrompt + `"}]}`, ""},
+		{"short prompt", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`, ""},
+		{"image", `{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:"}}]}]}`, "vision"},
+		{"long output", `{"model":"gpt-4o","max_completion_tokens":16000,"messages":[]}`, "long-output"},
+		{"long output with tools", `{"model":"gpt-4o","max_tokens":16000,"tools":[{"type":"function"}],"messages":[]}`, ""},
+	}
+
+	for _, tt := range tests {
+		features, err := router.features([]byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: failed to extract features: %v", tt.name, err)
+		}
+		rule, ok := router.match(features)
+		if ok != (tt.route != "") || rule.Name != tt.route {
+			t.Errorf("%s: expected route %q, got %q (features %+v)", tt.name, tt.route, rule.Name, features)
+		}
+	}
+}
+
+func TestRouteToBackend(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	type call struct {
+		backend string
+		model   string
+	}
+	calls := make(chan call, 1)
+	forwarder := func(name string) *MockOpenAIClient {
+		return &MockOpenAIClient{
+			CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+				model, _, _, _ := openai.ExtractRequestMetadata(body)
+				calls <- call{name, model}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+				}, nil
+			},
+		}
+	}
+
+	client := &BackendClient{
+		Default:  forwarder("default"),
+		Backends: map[string]OpenAIClient{"http://vision": forwarder("vision")},
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	yes := true
+	handler := NewRequestHandler(qm)
+	handler.Router = NewRuleRouter([]config.RouteRule{
+		{Name: "vision", Match: config.RouteMatch{Images: &yes}, Model: "llava", Backend: "http://vision"},
+	})
+
+	send := func(body string) call {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		req.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", recorder.Code)
+		}
+		return <-calls
+	}
+
+	if c := send(`{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"data:"}}]}]}`); c != (call{"vision", "llava"}) {
+		t.Errorf("Expected image request to be routed to llava on the vision backend, got %+v", c)
+	}
+
+	if c := send(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hello"}]}`); c != (call{"default", "gpt-4o"}) {
+		t.Errorf("Expected text request to go to the default backend unchanged, got %+v", c)
+	}
+}
+
+// tierRouter is a custom router sending requests by a header
+type tierRouter struct{}
+
+func (tierRouter) Route(req *RouteRequest) (*Route, error) {
+	switch req.Request.Header.Get("X-Tier") {
+	case "batch":
+		return &Route{Name: "batch", Model: "gpt-4o-mini", Backend: "http://vision", Priority: 2}, nil
+	case "broken":
+		return nil, errors.New("classifier unavailable")
+	}
+	return nil, nil
+}
+
+func TestCustomRouter(t *testing.T) {
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	backends := make(chan string, 1)
+	forwarder := func(name string) *MockOpenAIClient {
+		return &MockOpenAIClient{
+			CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+				backends <- name
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     make(http.Header),
+					Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+				}, nil
+			},
+		}
+	}
+	client := &BackendClient{
+		Default:  forwarder("default"),
+		Backends: map[string]OpenAIClient{"http://vision": forwarder("vision")},
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}, {Port: 8081, Priority: 2}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.Router = tierRouter{}
+
+	send := func(tier string) (string, metrics.RequestMetrics) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		req.Host = "localhost:8080"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Tier", tier)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code 200, got %d", recorder.Code)
+		}
+		return <-backends, <-recorded
+	}
+
+	if backend, m := send("batch"); backend != "vision" || m.Model != "gpt-4o-mini" || m.Priority != 2 {
+		t.Errorf("Expected the route's model, backend and queue applied, got %s on %s at priority %d", m.Model, backend, m.Priority)
+	}
+
+	// A router failing leaves the request as it came
+	if backend, m := send("broken"); backend != "default" || m.Model != "gpt-4o" || m.Priority != 1 {
+		t.Errorf("Expected an unrouted request, got %s on %s at priority %d", m.Model, backend, m.Priority)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ScalingSignal measures queue pressure for autoscalers such as the
+// Kubernetes HPA or KEDA, which scale replicas better on it than on CPU:
+// the proxy mostly waits on upstream calls, so its CPU stays low however
+// far behind it falls.
+type ScalingSignal struct {
+	// BacklogSeconds is how long the requests waiting in any one queue will
+	// take to be picked up at that queue's recent rate, the longest of all queues
+	BacklogSeconds float64 `json:"backlog_seconds"`
+	// Saturation is requests running and waiting per concurrency slot in
+	// queues with a max_concurrent, above 1 once requests wait for a slot
+	Saturation float64 `json:"saturation"`
+	Queued     int     `json:"queued"`   // Requests waiting in this replica's queues
+	Running    int64   `json:"running"`  // Requests being processed
+	Capacity   int     `json:"capacity"` // Concurrency slots across queues with a max_concurrent
+	// SharedQueued is the number of jobs waiting in the distributed backend
+	// for any replica to pull, when there is one
+	SharedQueued int64         `json:"shared_queued,omitempty"`
+	Queues       []QueueSignal `json:"queues"`
+}
+
+// QueueSignal measures the pressure on one queue
+type QueueSignal struct {
+	Port           int     `json:"port"`
+	Priority       int     `json:"priority"`
+	Queued         int     `json:"queued"`
+	Running        int64   `json:"running"`
+	PickupRate     float64 `json:"pickup_rate"`          // Requests picked up a second, over the wait window
+	BacklogSeconds float64 `json:"backlog_seconds"`      // Time to pick up the requests waiting at PickupRate
+	Saturation     float64 `json:"saturation,omitempty"` // Requests running and waiting per slot, 0 without max_concurrent
+	SharedQueued   int64   `json:"shared_queued,omitempty"`
+}
+
+// Scaling measures the pressure on every queue, highest priority first
+func (qm *QueueManager) Scaling(ctx context.Context) ScalingSignal {
+	window := qm.waitWindow()
+	signal := ScalingSignal{Queues: []QueueSignal{}}
+	var load int64
+
+	for _, status := range qm.Status() {
+		q := qm.FindQueueByPort(status.Port)
+		if q == nil {
+			continue
+		}
+		queue := QueueSignal{
+			Port:       status.Port,
+			Priority:   status.Priority,
+			Queued:     status.Depth,
+			Running:    status.Running,
+			PickupRate: q.waits.rate(window),
+		}
+
+		if qm.Backend != nil {
+			depth, err := qm.Backend.Depth(ctx, status.Priority)
+			if err == nil {
+				queue.SharedQueued = depth
+				signal.SharedQueued += depth
+			}
+		}
+
+		queue.BacklogSeconds = qm.backlog(q, queue.Queued).Seconds()
+
+		if status.MaxConcurrent > 0 {
+			queue.Saturation = float64(queue.Running+int64(queue.Queued)) / float64(status.MaxConcurrent)
+			signal.Capacity += status.MaxConcurrent
+			load += queue.Running + int64(queue.Queued)
+		}
+
+		signal.Queued += queue.Queued
+		signal.Running += queue.Running
+		signal.BacklogSeconds = max(signal.BacklogSeconds, queue.BacklogSeconds)
+		signal.Queues = append(signal.Queues, queue)
+	}
+
+	if signal.Capacity > 0 {
+		signal.Saturation = float64(load) / float64(signal.Capacity)
+	}
+	return signal
+}
+
+// backlog estimates how long the requests waiting on q will take to be
+// picked up at its rate over the wait window
+func (qm *QueueManager) backlog(q *PriorityQueue, waiting int) time.Duration {
+	var backlog time.Duration
+	if waiting > 0 {
+		var ok bool
+		if backlog, ok = qm.pickupTime(q, waiting); !ok {
+			// Nothing was picked up in the whole window
+			backlog = qm.waitWindow()
+		}
+	}
+	// Requests that already waited longer than the estimate show it is too low
+	return max(backlog, qm.AverageWait(q))
+}
+
+// pickupTime estimates how long waiting requests will take to be picked up
+// from q at its rate over the wait window, false when it picked up none in
+// the window to measure the rate by
+func (qm *QueueManager) pickupTime(q *PriorityQueue, waiting int) (time.Duration, bool) {
+	rate := q.waits.rate(qm.waitWindow())
+	if rate <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(waiting) / rate * float64(time.Second)), true
+}
+
+// scaling reports queue pressure for autoscalers
+func (h *AdminHandler) scaling(w http.ResponseWriter, r *http.Request) {
+	if h.QueueManager == nil {
+		writeError(w, http.StatusNotFound, "No queues to measure")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	writeJSON(w, http.StatusOK, h.QueueManager.Scaling(ctx))
+}
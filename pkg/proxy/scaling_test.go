@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func TestScalingSignal(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8080, Priority: 1, MaxConcurrent: 2},
+		{Port: 8081, Priority: 2},
+	}, &MockOpenAIClient{})
+	qm.WaitWindow = 10 * time.Second
+	backend := newTestRedisBackend(t)
+	qm.Backend = backend
+
+	// The first queue picked up a request a second and is full with two waiting
+	first := qm.FindQueueByPort(8080)
+	for range 10 {
+		first.waits.record(500*time.Millisecond, qm.WaitWindow)
+	}
+	first.running.Store(2)
+	first.Requests <- &workRequest{}
+	first.Requests <- &workRequest{}
+
+	// The second hasn't picked anything up lately
+	second := qm.FindQueueByPort(8081)
+	second.Requests <- &workRequest{}
+
+	if err := backend.Push(context.Background(), &Job{ID: "job-1", Priority: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewAdminHandler(qm, nil).ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/scaling", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", recorder.Code)
+	}
+	var signal ScalingSignal
+	if err := json.NewDecoder(recorder.Body).Decode(&signal); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(signal.Queues) != 2 {
+		t.Fatalf("Expected both queues, got %+v", signal)
+	}
+	if q := signal.Queues[0]; q.PickupRate != 1 || q.BacklogSeconds != 2 || q.Saturation != 2 {
+		t.Errorf("Expected 2s of backlog at twice the first queue's capacity, got %+v", q)
+	}
+	if q := signal.Queues[1]; q.BacklogSeconds != 10 || q.Saturation != 0 || q.SharedQueued != 1 {
+		t.Errorf("Expected the second queue's backlog to be the whole window, got %+v", q)
+	}
+	if signal.BacklogSeconds != 10 || signal.Saturation != 2 || signal.Queued != 3 ||
+		signal.Running != 2 || signal.Capacity != 2 || signal.SharedQueued != 1 {
+		t.Errorf("Unexpected totals: %+v", signal)
+	}
+}
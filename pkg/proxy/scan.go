@@ -0,0 +1,712 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+// Output scan rule actions
+const (
+	ScanBlock  = "block"  // Refuse the response, or cut off the stream
+	ScanRedact = "redact" // Replace what matched and pass the rest
+)
+
+// OutputRedactedHeader lists the rules that redacted a complete response
+const OutputRedactedHeader = "X-Proxy-Output-Redacted"
+
+// Defaults for output scanning
+const (
+	defaultScanWindow      = 1000
+	defaultScanTimeout     = 2 * time.Second
+	defaultRedaction       = "[REDACTED]"
+	defaultScanMaxResponse = 8 << 20
+)
+
+// errOutputBlocked ends the copy of a response output scanning blocked
+var errOutputBlocked = errors.New("output blocked by scanning")
+
+// scanPaths are the endpoints whose responses are generated text
+var scanPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/responses":        true,
+}
+
+// textFields are the keys whose string values are generated text, in the
+// responses and stream events of the chat, completions and responses APIs
+var textFields = map[string]bool{
+	"content": true,
+	"text":    true,
+	"delta":   true,
+	"refusal": true,
+}
+
+// ScanFinding is why output was blocked
+type ScanFinding struct {
+	Check    string `json:"check"`              // The rule's name, or "classifier"
+	Category string `json:"category,omitempty"` // What the classifier flagged
+}
+
+// ScanRule is a pattern generated text is checked against
+type ScanRule struct {
+	Name        string
+	Pattern     *regexp.Regexp
+	Action      string
+	Replacement string
+}
+
+// OutputScanner checks generated text before it reaches the client.
+// Complete responses are scanned whole before they are answered, up to
+// MaxResponse bytes; larger ones pass unscanned, or are blocked with
+// FailClosed. Streams pass through event by event: rules are checked on
+// each event as it goes, against the recent text too so matches spanning
+// events are caught. With redact rules, events are held back until a
+// window of text has followed them, so a match spanning events is redacted
+// in all of them. The classifier checks each window of text in the
+// background and the stream is cut off at the next event once it flags
+// one. Before the final [DONE] event is passed on, the text not yet
+// classified is checked, so a stream only completes once all of it has been.
+type OutputScanner struct {
+	Rules       []ScanRule
+	Classifier  *OutputClassifier
+	Window      int           // Bytes of streamed text each classifier check covers and redaction holds back (default 1000)
+	Timeout     time.Duration // Per classifier check (default 2s)
+	FailClosed  bool          // Block output that couldn't be checked
+	MaxResponse int           // Bytes of a complete response held for scanning (default 8 MiB)
+}
+
+// NewOutputScanner compiles rules
+func NewOutputScanner(rules []config.OutputScanRule) (*OutputScanner, error) {
+	s := &OutputScanner{}
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Pattern, err)
+		}
+		s.Rules = append(s.Rules, ScanRule{
+			Name:        cmp.Or(rule.Name, rule.Pattern),
+			Pattern:     pattern,
+			Action:      cmp.Or(rule.Action, ScanBlock),
+			Replacement: cmp.Or(rule.Replacement, defaultRedaction),
+		})
+	}
+	return s, nil
+}
+
+// window returns how much streamed text a classifier check covers
+func (s *OutputScanner) window() int {
+	if s.Window > 0 {
+		return s.Window
+	}
+	return defaultScanWindow
+}
+
+// redacts reports whether any rule redacts
+func (s *OutputScanner) redacts() bool {
+	return slices.ContainsFunc(s.Rules, func(rule ScanRule) bool { return rule.Action == ScanRedact })
+}
+
+// blocks returns the first block rule text matches, if any
+func (s *OutputScanner) blocks(text string) *ScanFinding {
+	for _, rule := range s.Rules {
+		if rule.Action == ScanBlock && rule.Pattern.MatchString(text) {
+			return &ScanFinding{Check: rule.Name}
+		}
+	}
+	return nil
+}
+
+// redact replaces what the redact rules match in text, returning the rules
+// that did
+func (s *OutputScanner) redact(text string) (string, []string) {
+	var fired []string
+	for _, rule := range s.Rules {
+		if rule.Action == ScanRedact && rule.Pattern.MatchString(text) {
+			text = rule.Pattern.ReplaceAllLiteralString(text, rule.Replacement)
+			fired = append(fired, rule.Name)
+		}
+	}
+	return text, fired
+}
+
+// classify runs text past the classifier, if there is one. A check that
+// fails blocks the text only with FailClosed.
+func (s *OutputScanner) classify(ctx context.Context, text string) *ScanFinding {
+	if s.Classifier == nil || strings.TrimSpace(text) == "" {
+		return nil
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	finding, err := s.Classifier.Classify(ctx, text)
+	if err != nil {
+		log.Printf("Output classifier check failed: %v", err)
+		if s.FailClosed {
+			return &ScanFinding{Check: "classifier", Category: "unavailable"}
+		}
+		return nil
+	}
+	return finding
+}
+
+// scans reports whether a request's response is scanned
+func (s *OutputScanner) scans(req *workRequest) bool {
+	return s != nil && req.Request.Method == "POST" && scanPaths[req.Request.URL.Path]
+}
+
+// OutputClassifier flags text through a classifier that speaks the OpenAI
+// moderations API
+type OutputClassifier struct {
+	URL    string
+	APIKey string
+	Client *http.Client // nil uses http.DefaultClient
+}
+
+// Classify returns a finding naming the flagged categories, nil when the
+// classifier didn't flag text
+func (c *OutputClassifier) Classify(ctx context.Context, text string) (*ScanFinding, error) {
+	body, err := json.Marshal(map[string]string{"input": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	resp, err := cmp.Or(c.Client, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("classifier answered %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid classifier response: %w", err)
+	}
+	for _, r := range result.Results {
+		if !r.Flagged {
+			continue
+		}
+		var categories []string
+		for category, flagged := range r.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		slices.Sort(categories)
+		return &ScanFinding{Check: "classifier", Category: strings.Join(categories, ",")}, nil
+	}
+	return nil, nil
+}
+
+// scanWriter scans a response on its way to the client. A complete
+// response is held until finish has scanned it; a stream is passed on
+// event by event.
+type scanWriter struct {
+	http.ResponseWriter
+	scanner  *OutputScanner
+	ctx      context.Context
+	req      *workRequest
+	stream   bool
+	status   int  // 0 until the header is written
+	pass     bool // Error responses, and complete ones too large to hold, aren't scanned
+	buf      bytes.Buffer
+	window   string          // Recently streamed text, for rule matches spanning events
+	held     []*heldEvent    // Stream events held back for redaction, oldest first
+	heldLen  int             // Bytes of text in held
+	pending  strings.Builder // Streamed text the classifier hasn't checked yet
+	checking atomic.Bool
+	checks   sync.WaitGroup
+	flagged  atomic.Pointer[ScanFinding] // Set by a background classifier check
+	blocked  *ScanFinding
+	redacted []string // Rules that redacted a complete response
+}
+
+// newScanWriter scans what req's response writes to w
+func (s *OutputScanner) newScanWriter(ctx context.Context, w http.ResponseWriter, req *workRequest, stream bool) *scanWriter {
+	return &scanWriter{ResponseWriter: w, scanner: s, ctx: ctx, req: req, stream: stream}
+}
+
+// WriteHeader implements http.ResponseWriter. A complete response's status
+// is only sent once it has been scanned.
+func (w *scanWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.pass = status < 200 || status >= 300
+	if w.pass || w.stream {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// Redaction changes the length
+	w.Header().Del("Content-Length")
+}
+
+// Write implements http.ResponseWriter
+func (w *scanWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pass {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.blocked != nil {
+		return 0, errOutputBlocked
+	}
+
+	if !w.stream && w.buf.Len()+len(p) > w.scanner.maxResponse() {
+		return w.overflow(p)
+	}
+	w.buf.Write(p)
+	for w.stream {
+		i := bytes.Index(w.buf.Bytes(), []byte("\n\n"))
+		if i < 0 {
+			break
+		}
+		event := string(w.buf.Next(i + 2))
+		if err := w.event(event); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// overflow gives up holding a complete response too large to scan: with
+// FailClosed it is blocked, otherwise what was held and p are passed on
+// unscanned, as is the rest of it
+func (w *scanWriter) overflow(p []byte) (int, error) {
+	if w.scanner.FailClosed {
+		finding := &ScanFinding{Check: "size", Category: "too_large"}
+		w.block(finding)
+		writeJSON(w.ResponseWriter, http.StatusUnprocessableEntity, map[string]any{"error": blockedError(finding)})
+		return 0, errOutputBlocked
+	}
+	log.Printf("Passing output of request %s unscanned: larger than %d bytes", w.req.requestID(), w.scanner.maxResponse())
+	w.pass = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf.Reset()
+	return w.ResponseWriter.Write(p)
+}
+
+// maxResponse returns how large a complete response may be to be scanned
+func (s *OutputScanner) maxResponse() int {
+	if s.MaxResponse > 0 {
+		return s.MaxResponse
+	}
+	return defaultScanMaxResponse
+}
+
+// Flush implements http.Flusher
+func (w *scanWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && (w.pass || w.stream) {
+		flusher.Flush()
+	}
+}
+
+// event scans one stream event and passes it on, once enough text has
+// followed it when redacting
+func (w *scanWriter) event(event string) error {
+	if finding := w.flagged.Load(); finding != nil {
+		return w.block(finding)
+	}
+
+	data, _ := eventData(event)
+	if data == "[DONE]" {
+		// The stream only completes once all of its text has been checked
+		if err := w.release(0); err != nil {
+			return err
+		}
+		if finding := w.settle(); finding != nil {
+			return w.block(finding)
+		}
+		_, err := io.WriteString(w.ResponseWriter, event)
+		return err
+	}
+
+	if finding := w.hold(event, data); finding != nil {
+		return w.block(finding)
+	}
+	keep := 0
+	if w.scanner.redacts() {
+		keep = w.scanner.window()
+	}
+	return w.release(keep)
+}
+
+// eventData returns the data of a stream event
+func eventData(event string) (string, bool) {
+	for line := range strings.SplitSeq(event, "\n") {
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			return strings.TrimSpace(data), true
+		}
+	}
+	return "", false
+}
+
+// heldEvent is a stream event held back until enough text has followed it
+// for matches spanning it and later events to be redacted
+type heldEvent struct {
+	event   string
+	data    string
+	doc     any        // The data decoded, nil when it isn't JSON
+	slots   []textSlot // Where its texts are in doc
+	texts   []string   // Its texts, redacted as matches are found
+	changed bool
+}
+
+// hold adds a stream event to those held back, checking its text against
+// the block rules along with the recent text, and redacts what the redact
+// rules match in the held text
+func (w *scanWriter) hold(event, data string) *ScanFinding {
+	h := &heldEvent{event: event, data: data}
+	h.doc, h.slots = jsonTexts(data)
+	for _, slot := range h.slots {
+		text := slot.text()
+		if finding := w.scanner.blocks(w.window + text); finding != nil {
+			return finding
+		}
+		w.window += text
+		if window := w.scanner.window(); len(w.window) > window {
+			w.window = w.window[len(w.window)-window:]
+		}
+		h.texts = append(h.texts, text)
+	}
+	w.held = append(w.held, h)
+	if len(h.texts) > 0 {
+		w.redactHeld()
+	}
+	return nil
+}
+
+// redactHeld replaces what the redact rules match in the text of the held
+// events taken together, so a match spanning events is replaced in the
+// event it starts in and removed from those it runs on into
+func (w *scanWriter) redactHeld() {
+	var fired []string
+	for _, rule := range w.scanner.Rules {
+		if rule.Action != ScanRedact {
+			continue
+		}
+		var joined strings.Builder
+		for _, h := range w.held {
+			for _, text := range h.texts {
+				joined.WriteString(text)
+			}
+		}
+		matches := rule.Pattern.FindAllStringIndex(joined.String(), -1)
+		if len(matches) == 0 {
+			continue
+		}
+		fired = append(fired, rule.Name)
+
+		start := 0
+		for _, h := range w.held {
+			for i, text := range h.texts {
+				end := start + len(text)
+				if redacted := redactSpan(text, start, matches, rule.Replacement); redacted != text {
+					h.texts[i], h.changed = redacted, true
+				}
+				start = end
+			}
+		}
+	}
+	w.heldLen = 0
+	for _, h := range w.held {
+		for _, text := range h.texts {
+			w.heldLen += len(text)
+		}
+	}
+	if len(fired) > 0 {
+		log.Printf("Redacted output of request %s matching %s", w.req.requestID(), strings.Join(fired, ", "))
+	}
+}
+
+// redactSpan returns text, found at offset start of the text matches were
+// found in, with replacement in place of each match starting in it and
+// without what matches starting earlier cover
+func redactSpan(text string, start int, matches [][]int, replacement string) string {
+	end := start + len(text)
+	var b strings.Builder
+	pos := start
+	for _, m := range matches {
+		if m[0] == m[1] || m[1] <= pos || m[0] >= end {
+			continue
+		}
+		if m[0] > pos {
+			b.WriteString(text[pos-start : m[0]-start])
+		}
+		if m[0] >= start {
+			b.WriteString(replacement)
+		}
+		pos = min(m[1], end)
+	}
+	b.WriteString(text[pos-start:])
+	return b.String()
+}
+
+// release passes on the held events that have at least keep bytes of text
+// after them, oldest first
+func (w *scanWriter) release(keep int) error {
+	for len(w.held) > 0 {
+		h := w.held[0]
+		n := 0
+		for _, text := range h.texts {
+			n += len(text)
+		}
+		if w.heldLen-n < keep {
+			return nil
+		}
+		w.held[0] = nil
+		w.held = w.held[1:]
+		w.heldLen -= n
+
+		event := h.event
+		if h.changed {
+			for i, slot := range h.slots {
+				slot.set(h.texts[i])
+			}
+			if rewritten, err := json.Marshal(h.doc); err == nil {
+				event = strings.Replace(event, h.data, string(rewritten), 1)
+			}
+		}
+		for _, text := range h.texts {
+			w.observe(text)
+		}
+		if _, err := io.WriteString(w.ResponseWriter, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observe notes streamed text on its way to the client, handing each full
+// window of it to the classifier in the background. Text arriving while a
+// check runs goes into the next one.
+func (w *scanWriter) observe(text string) {
+	if w.scanner.Classifier == nil {
+		return
+	}
+	w.pending.WriteString(text)
+	if w.pending.Len() < w.scanner.window() || !w.checking.CompareAndSwap(false, true) {
+		return
+	}
+	pending := w.pending.String()
+	w.pending.Reset()
+	w.checks.Add(1)
+	go func() {
+		defer w.checks.Done()
+		defer w.checking.Store(false)
+		if finding := w.scanner.classify(w.ctx, pending); finding != nil {
+			w.flagged.CompareAndSwap(nil, finding)
+		}
+	}()
+}
+
+// settle waits for background checks, then checks the text they haven't
+// covered
+func (w *scanWriter) settle() *ScanFinding {
+	w.checks.Wait()
+	if finding := w.flagged.Load(); finding != nil {
+		return finding
+	}
+	pending := w.pending.String()
+	w.pending.Reset()
+	return w.scanner.classify(w.ctx, pending)
+}
+
+// block stops the response for finding, ending a stream with an error
+// event the client can parse
+func (w *scanWriter) block(finding *ScanFinding) error {
+	w.blocked = finding
+	log.Printf("Blocked output of request %s for model %s: %s %s",
+		w.req.requestID(), w.req.Model, finding.Check, finding.Category)
+	if w.stream {
+		data, _ := json.Marshal(map[string]any{"error": blockedError(finding)})
+		io.WriteString(w.ResponseWriter, "data: "+string(data)+"\n\n")
+		if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	return errOutputBlocked
+}
+
+// blockedError is the structured error blocked output is answered with
+func blockedError(finding *ScanFinding) map[string]any {
+	return map[string]any{
+		"message":  "The response was blocked by output scanning",
+		"type":     "output_blocked",
+		"param":    nil,
+		"code":     finding.Check,
+		"category": finding.Category,
+	}
+}
+
+// finish scans a complete response and answers it, or checks the text of a
+// stream that ended without [DONE]. It returns errOutputBlocked when the
+// output was blocked, now or while it was written.
+func (w *scanWriter) finish() error {
+	if w.blocked != nil {
+		return errOutputBlocked
+	}
+	if w.pass {
+		return nil
+	}
+	if w.stream {
+		if rest := w.buf.String(); rest != "" {
+			w.buf.Reset()
+			if err := w.event(rest); err != nil {
+				return err
+			}
+		}
+		if err := w.release(0); err != nil {
+			return err
+		}
+		if finding := w.settle(); finding != nil {
+			return w.block(finding)
+		}
+		return nil
+	}
+
+	body := w.buf.Bytes()
+	var blocked *ScanFinding
+	var texts []string
+	scanned, changed := rewriteJSONText(string(body), func(text string) string {
+		if blocked == nil {
+			blocked = w.scanner.blocks(text)
+		}
+		text, fired := w.scanner.redact(text)
+		for _, rule := range fired {
+			if !slices.Contains(w.redacted, rule) {
+				w.redacted = append(w.redacted, rule)
+			}
+		}
+		texts = append(texts, text)
+		return text
+	})
+	if blocked == nil {
+		blocked = w.scanner.classify(w.ctx, strings.Join(texts, "\n"))
+	}
+	if blocked != nil {
+		w.block(blocked)
+		writeJSON(w.ResponseWriter, http.StatusUnprocessableEntity, map[string]any{"error": blockedError(blocked)})
+		return errOutputBlocked
+	}
+
+	if changed {
+		body = []byte(scanned)
+		w.Header().Set(OutputRedactedHeader, strings.Join(w.redacted, ", "))
+		log.Printf("Redacted output of request %s matching %s", w.req.requestID(), strings.Join(w.redacted, ", "))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(body)
+	return err
+}
+
+// textSlot is where a generated text is in a decoded JSON document
+type textSlot struct {
+	object map[string]any
+	key    string
+}
+
+// text returns the text in the slot
+func (s textSlot) text() string {
+	return s.object[s.key].(string)
+}
+
+// set replaces the text in the slot
+func (s textSlot) set(text string) {
+	s.object[s.key] = text
+}
+
+// jsonTexts decodes a JSON document, returning it with where its generated
+// texts are; nil for data that isn't JSON
+func jsonTexts(data string) (any, []textSlot) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if dec.Decode(&doc) != nil {
+		return nil, nil
+	}
+
+	var slots []textSlot
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, child := range v {
+				if _, ok := child.(string); ok && textFields[key] {
+					slots = append(slots, textSlot{v, key})
+					continue
+				}
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+	return doc, slots
+}
+
+// rewriteJSONText passes each generated text in a JSON document through
+// fn, returning the document with what fn changed and whether it changed
+// anything. Documents that aren't JSON are returned as they are.
+func rewriteJSONText(data string, fn func(string) string) (string, bool) {
+	doc, slots := jsonTexts(data)
+	changed := false
+	for _, slot := range slots {
+		text := slot.text()
+		if scanned := fn(text); scanned != text {
+			slot.set(scanned)
+			changed = true
+		}
+	}
+	if !changed {
+		return data, false
+	}
+
+	rewritten, err := json.Marshal(doc)
+	if err != nil {
+		return data, false
+	}
+	return string(rewritten), true
+}
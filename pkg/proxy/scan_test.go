@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestOutputScanner(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The upstream answers with whatever the test sets next
+	var status int
+	var contentType, respBody string
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": {contentType}, "Content-Length": {"1"}},
+			Body:       io.NopCloser(strings.NewReader(respBody)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	scanner, err := NewOutputScanner([]config.OutputScanRule{
+		{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`, Action: ScanRedact},
+		{Name: "codename", Pattern: `Project Nightjar`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm.Scanner = scanner
+	outcomes := &outcomeRecorder{}
+	qm.Outcomes = []OutcomeSink{outcomes}
+	last := func() RequestOutcome {
+		outcomes.mu.Lock()
+		defer outcomes.mu.Unlock()
+		return outcomes.outcomes[len(outcomes.outcomes)-1]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+		r.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+	chunk := func(text string) string {
+		return `data: {"choices":[{"delta":{"content":"` + text + `"}}]}` + "\n\n"
+	}
+
+	// Complete responses are redacted before they are answered
+	status, contentType = http.StatusOK, "application/json"
+	respBody = `{"choices":[{"message":{"role":"assistant","content":"Your SSN is 123-45-6789."}}]}`
+	recorder := send()
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "Your SSN is [REDACTED].") {
+		t.Errorf("Expected the SSN redacted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get(OutputRedactedHeader) != "ssn" || recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("Expected the redaction reported without the upstream's length, got %v", recorder.Header())
+	}
+
+	// or refused with a structured error
+	respBody = `{"choices":[{"message":{"role":"assistant","content":"Project Nightjar ships in May."}}]}`
+	recorder = send()
+	var blocked struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &blocked)
+	if recorder.Code != http.StatusUnprocessableEntity || blocked.Error.Type != "output_blocked" || blocked.Error.Code != "codename" {
+		t.Errorf("Expected the response blocked, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if last := last(); last.Blocked != "codename" || last.Status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the outcome to record the block, got %+v", last)
+	}
+
+	// Streams are redacted across events, the match replaced in the event it
+	// starts in and removed from the next
+	contentType = "text/event-stream"
+	respBody = chunk("SSN 123-45") + chunk("-6789, thanks") + "data: [DONE]\n\n"
+	recorder = send()
+	body := recorder.Body.String()
+	if body != chunk("SSN [REDACTED]")+chunk(", thanks")+"data: [DONE]\n\n" {
+		t.Errorf("Expected the SSN spanning events redacted, got %s", body)
+	}
+
+	// and cut off at a block rule's match spanning events, held text and all
+	respBody = chunk("SSN 123-45-6789, ") + chunk("codename Project ") + chunk("Nightjar") + chunk(" more") + "data: [DONE]\n\n"
+	recorder = send()
+	body = recorder.Body.String()
+	if strings.Contains(body, "Nightjar") || strings.Contains(body, "[DONE]") || !strings.Contains(body, `"type":"output_blocked"`) {
+		t.Errorf("Expected the stream cut off with an error event, got %s", body)
+	}
+	if last := last(); last.Outcome != OutcomeTruncated || last.Blocked != "codename" {
+		t.Errorf("Expected a truncated, blocked outcome, got %+v", last)
+	}
+
+	// Complete responses too large to hold pass unscanned, or are blocked failing closed
+	status, contentType = http.StatusOK, "application/json"
+	respBody = `{"choices":[{"message":{"role":"assistant","content":"Project Nightjar ships in May."}}]}`
+	scanner.MaxResponse = 16
+	if recorder := send(); recorder.Code != http.StatusOK || recorder.Body.String() != respBody {
+		t.Errorf("Expected the large response passed on unscanned, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	scanner.FailClosed = true
+	if recorder := send(); recorder.Code != http.StatusUnprocessableEntity || !strings.Contains(recorder.Body.String(), `"code":"size"`) {
+		t.Errorf("Expected the large response blocked, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	scanner.MaxResponse, scanner.FailClosed = 0, false
+
+	// Error responses pass untouched
+	status, contentType = http.StatusBadRequest, "application/json"
+	respBody = `{"error":{"message":"Project Nightjar is not a model","type":"invalid_request_error"}}`
+	if recorder := send(); recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "Nightjar") {
+		t.Errorf("Expected the error passed on, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestOutputClassifier(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The classifier flags text mentioning a heist
+	classifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		flagged := strings.Contains(req.Input, "heist")
+		json.NewEncoder(w).Encode(map[string]any{"results": []any{map[string]any{
+			"flagged":    flagged,
+			"categories": map[string]bool{"illicit": flagged, "hate": false},
+		}}})
+	}))
+	defer classifier.Close()
+
+	var respBody string
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(respBody)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Scanner = &OutputScanner{Classifier: &OutputClassifier{URL: classifier.URL}, Window: 16}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+
+	send := func() string {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[],"stream":true}`))
+		r.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder.Body.String()
+	}
+
+	// Clean streams complete
+	respBody = `data: {"choices":[{"delta":{"content":"A recipe for bread"}}]}` + "\n\n" + "data: [DONE]\n\n"
+	if body := send(); !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("Expected a clean stream completed, got %s", body)
+	}
+
+	// A stream is only completed once all of its text has been classified
+	respBody = `data: {"choices":[{"delta":{"content":"Plan the heist"}}]}` + "\n\n" + "data: [DONE]\n\n"
+	body := send()
+	if strings.Contains(body, "[DONE]") || !strings.Contains(body, `"code":"classifier"`) || !strings.Contains(body, `"category":"illicit"`) {
+		t.Errorf("Expected the flagged stream cut off before it completed, got %s", body)
+	}
+
+	// An unreachable classifier passes output unless the scanner fails closed
+	qm.Scanner.Classifier.URL = "http://127.0.0.1:1"
+	if body := send(); !strings.Contains(body, "[DONE]") {
+		t.Errorf("Expected output passed when the classifier is down, got %s", body)
+	}
+	qm.Scanner.FailClosed = true
+	if body := send(); strings.Contains(body, "[DONE]") || !strings.Contains(body, `"category":"unavailable"`) {
+		t.Errorf("Expected output blocked when the classifier is down and failing closed, got %s", body)
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/cron"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+// QueueOverride changes a queue's settings while a schedule window is active
+type QueueOverride struct {
+	Priority      int // Priority the queue is dispatched at (0 keeps the configured one)
+	MaxConcurrent int // Requests run at once (0 keeps the configured limit, -1 lifts it)
+}
+
+// scheduleWindow is a schedule window with its expression parsed
+type scheduleWindow struct {
+	config.ScheduleWindow
+	when *cron.Expr
+	loc  *time.Location
+}
+
+// Schedule changes queue and rate limit settings while schedule windows are
+// active, putting them back when the windows end. Where several active
+// windows change the same setting, the one listed last wins.
+type Schedule struct {
+	queues  *QueueManager
+	limiter *ratelimit.Limiter
+	windows []scheduleWindow
+	mu      sync.Mutex // Held while applying, so changes land in order
+	perKey  int64      // Configured rate limits, used outside windows
+	org     int64
+	active  []string
+	now     func() time.Time
+}
+
+// NewSchedule validates schedule windows against the queues and limiter they
+// change. limiter may be nil when no window changes rate limits.
+func NewSchedule(windows []config.ScheduleWindow, qm *QueueManager, limiter *ratelimit.Limiter) (*Schedule, error) {
+	s := &Schedule{queues: qm, limiter: limiter, now: time.Now}
+	if limiter != nil {
+		s.perKey, s.org = limiter.Limits()
+	}
+
+	for i, w := range windows {
+		if w.Name == "" {
+			w.Name = fmt.Sprintf("schedule %d", i)
+		}
+		when, err := cron.Parse(w.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", w.Name, err)
+		}
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid timezone: %w", w.Name, err)
+		}
+		for _, q := range w.Queues {
+			if qm.FindQueueByPort(q.Port) == nil {
+				return nil, fmt.Errorf("%s: no queue on port %d", w.Name, q.Port)
+			}
+			if q.Priority < 0 || q.MaxConcurrent < -1 {
+				return nil, fmt.Errorf("%s: invalid settings for port %d", w.Name, q.Port)
+			}
+		}
+		if w.RateLimits != nil && limiter == nil {
+			return nil, fmt.Errorf("%s: rate limits are not enabled", w.Name)
+		}
+		s.windows = append(s.windows, scheduleWindow{ScheduleWindow: w, when: when, loc: loc})
+	}
+	return s, nil
+}
+
+// Apply puts the settings of the windows active at now into effect
+func (s *Schedule) Apply(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	overrides := make(map[int]QueueOverride)
+	perKey, org := s.perKey, s.org
+	var active []string
+
+	for _, w := range s.windows {
+		if !w.when.Matches(now.In(w.loc)) {
+			continue
+		}
+		active = append(active, w.Name)
+
+		for _, q := range w.Queues {
+			// Overrides are keyed by the port a queue is known by, not a port sharing it
+			port := q.Port
+			if queue := s.queues.FindQueueByPort(port); queue != nil {
+				port = queue.Port
+			}
+			o := overrides[port]
+			if q.Priority != 0 {
+				o.Priority = q.Priority
+			}
+			if q.MaxConcurrent != 0 {
+				o.MaxConcurrent = q.MaxConcurrent
+			}
+			overrides[port] = o
+		}
+		if limits := w.RateLimits; limits != nil {
+			if limits.RequestsPerKey != 0 {
+				perKey = max(limits.RequestsPerKey, 0)
+			}
+			if limits.OrgRequests != 0 {
+				org = max(limits.OrgRequests, 0)
+			}
+		}
+	}
+
+	s.queues.SetQueueOverrides(overrides)
+	if s.limiter != nil {
+		s.limiter.SetLimits(perKey, org)
+	}
+
+	for _, name := range active {
+		if !slices.Contains(s.active, name) {
+			fmt.Printf("Schedule window %s started\n", name)
+		}
+	}
+	for _, name := range s.active {
+		if !slices.Contains(active, name) {
+			fmt.Printf("Schedule window %s ended\n", name)
+		}
+	}
+	s.active = active
+}
+
+// SetLimits changes the rate limits in effect outside windows, e.g. when the
+// config is reloaded, applying them straight away if no window overrides them
+func (s *Schedule) SetLimits(perKey, org int64) {
+	s.mu.Lock()
+	s.perKey, s.org = perKey, org
+	s.mu.Unlock()
+	s.Apply(s.now())
+}
+
+// Active returns the names of the windows in effect
+func (s *Schedule) Active() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.active)
+}
+
+// Run applies the schedule now and at the start of every minute until ctx is cancelled
+func (s *Schedule) Run(ctx context.Context) {
+	for {
+		now := s.now()
+		s.Apply(now)
+
+		timer := time.NewTimer(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
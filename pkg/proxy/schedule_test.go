@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/ratelimit"
+)
+
+func TestSchedule(t *testing.T) {
+	// Upstream calls hold until the test is done, so requests stay running
+	release := make(chan struct{})
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return &http.Response{StatusCode: 200, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+		},
+	}
+	qm := NewQueueManager([]config.Endpoint{
+		{Port: 8081, Priority: 1},
+		{Port: 8082, Priority: 2, MaxConcurrent: 1},
+		{Port: 8083, Priority: 3},
+	}, client)
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), time.Minute, 10, 0, nil)
+
+	schedule, err := NewSchedule([]config.ScheduleWindow{{
+		Name: "overnight-batch",
+		Cron: "* 0-5 * * *",
+		Queues: []config.QueueSchedule{
+			{Port: 8083, Priority: 1},
+			{Port: 8082, MaxConcurrent: -1},
+		},
+		RateLimits: &config.RateLimitSchedule{RequestsPerKey: -1, OrgRequests: 100},
+	}}, qm, limiter)
+	if err != nil {
+		t.Fatalf("Failed to create schedule: %v", err)
+	}
+
+	var requests []*workRequest
+	enqueue := func(port int) {
+		req := &workRequest{
+			Request:        httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{}`)),
+			ResponseWriter: httptest.NewRecorder(),
+			Done:           make(chan struct{}),
+			StartTime:      time.Now(),
+		}
+		requests = append(requests, req)
+		qm.FindQueueByPort(port).Requests <- req
+	}
+	depths := func() (int, int) {
+		return len(qm.FindQueueByPort(8082).Requests), len(qm.FindQueueByPort(8083).Requests)
+	}
+
+	// Outside the window, the priority 2 queue runs one request at a time and
+	// lower priority work goes ahead of its second
+	schedule.Apply(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC))
+	enqueue(8082)
+	enqueue(8082)
+	enqueue(8083)
+	qm.processNextRequest()
+	qm.processNextRequest()
+	if p2, p3 := depths(); p2 != 1 || p3 != 0 {
+		t.Fatalf("Expected the second priority 2 request held back by its limit, got depths %d and %d", p2, p3)
+	}
+
+	// During the window, batch work goes first and the limit is lifted
+	schedule.Apply(time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC))
+	enqueue(8083)
+	qm.processNextRequest()
+	if p2, p3 := depths(); p2 != 1 || p3 != 0 {
+		t.Errorf("Expected batch work dispatched first during the window, got depths %d and %d", p2, p3)
+	}
+	qm.processNextRequest()
+	if p2, _ := depths(); p2 != 0 {
+		t.Errorf("Expected the priority 2 limit lifted during the window, got depth %d", p2)
+	}
+
+	if perKey, org := limiter.Limits(); perKey != 0 || org != 100 {
+		t.Errorf("Expected window rate limits, got %d per key and %d org", perKey, org)
+	}
+	if active := schedule.Active(); len(active) != 1 || active[0] != "overnight-batch" {
+		t.Errorf("Expected the window to be active, got %v", active)
+	}
+	status := qm.Status()
+	if status[1].MaxConcurrent != 0 || status[2].ScheduledPriority != 1 {
+		t.Errorf("Expected queue status to show scheduled settings, got %+v", status)
+	}
+
+	// Everything goes back once the window ends
+	schedule.Apply(time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC))
+	if perKey, org := limiter.Limits(); perKey != 10 || org != 0 {
+		t.Errorf("Expected configured rate limits restored, got %d per key and %d org", perKey, org)
+	}
+	status = qm.Status()
+	if len(schedule.Active()) != 0 || status[1].MaxConcurrent != 1 || status[2].ScheduledPriority != 0 {
+		t.Errorf("Expected configured queue settings restored, got %+v", status)
+	}
+
+	close(release)
+	for _, req := range requests {
+		select {
+		case <-req.Done:
+		case <-time.After(time.Second):
+			t.Fatal("Expected every request to finish")
+		}
+	}
+}
+
+func TestNewScheduleErrors(t *testing.T) {
+	qm := NewQueueManager([]config.Endpoint{{Port: 8081, Priority: 1}}, &MockOpenAIClient{})
+
+	tests := map[string]config.ScheduleWindow{
+		"bad cron":     {Cron: "* * *"},
+		"bad timezone": {Cron: "* * * * *", Timezone: "Mars/Olympus"},
+		"no queue":     {Cron: "* * * * *", Queues: []config.QueueSchedule{{Port: 9999, Priority: 1}}},
+		"no limiter":   {Cron: "* * * * *", RateLimits: &config.RateLimitSchedule{OrgRequests: 10}},
+	}
+	for name, window := range tests {
+		if _, err := NewSchedule([]config.ScheduleWindow{window}, qm, nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// Defaults for the slowest requests kept
+const (
+	defaultSlowKeep   = 50
+	defaultSlowWindow = time.Hour
+)
+
+// SlowRequest reports a request that took longer than its threshold, with
+// where its time went
+type SlowRequest struct {
+	RequestID   string     `json:"request_id,omitempty"`
+	KeyID       string     `json:"key_id,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	Path        string     `json:"path"`
+	Priority    int        `json:"priority"`
+	Backend     string     `json:"backend,omitempty"`
+	Outcome     string     `json:"outcome"`
+	Status      int        `json:"status,omitempty"`
+	Retries     int        `json:"retries"`
+	Preemptions int        `json:"preemptions"`
+	FinishedAt  time.Time  `json:"finished_at"`
+	TotalMs     int64      `json:"total_ms"`
+	ThresholdMs int64      `json:"threshold_ms"`
+	Phases      SlowPhases `json:"phases"`
+}
+
+// SlowPhases splits a request's time between its phases, in milliseconds
+type SlowPhases struct {
+	QueueMs      int64 `json:"queue_ms"`      // Waiting in queues, over all attempts
+	SchedulingMs int64 `json:"scheduling_ms"` // Neither queued nor on the final upstream call, e.g. preempted attempts
+	UpstreamMs   int64 `json:"upstream_ms"`   // Until the upstream started responding
+	ResponseMs   int64 `json:"response_ms"`   // Relaying the response, e.g. streaming it
+	// UpstreamReportedMs is the time the upstream reports spending (openai-processing-ms)
+	UpstreamReportedMs int64 `json:"upstream_reported_ms,omitempty"`
+}
+
+// SlowRequests flags requests that take longer than a threshold from
+// arrival to their last byte, logging a report of each and keeping the
+// slowest of the last Window for the admin API. Thresholds can be set per
+// model, since long generations are slow by nature.
+type SlowRequests struct {
+	Threshold time.Duration            // Applies to models without their own (0 = none)
+	Models    map[string]time.Duration // Thresholds by model name or "prefix*" pattern
+	Keep      int                      // Slowest requests kept (default 50)
+	Window    time.Duration            // How long they are kept for (default an hour)
+
+	mu      sync.Mutex
+	slowest []SlowRequest // Slowest first
+	now     func() time.Time
+}
+
+// NewSlowRequests flags requests slower than threshold, or their model's
+// threshold in models
+func NewSlowRequests(threshold time.Duration, models map[string]time.Duration) *SlowRequests {
+	return &SlowRequests{Threshold: threshold, Models: models, now: time.Now}
+}
+
+// threshold returns a model's threshold, preferring an exact match over
+// the longest matching prefix; 0 is none
+func (s *SlowRequests) threshold(model string) time.Duration {
+	if threshold, ok := s.Models[model]; ok {
+		return threshold
+	}
+	threshold, best := s.Threshold, -1
+	for pattern, t := range s.Models {
+		prefix, ok := strings.CutSuffix(pattern, "*")
+		if ok && strings.HasPrefix(model, prefix) && len(prefix) > best {
+			threshold, best = t, len(prefix)
+		}
+	}
+	return threshold
+}
+
+// observe reports a finished request if it was slow, returning whether it was
+func (s *SlowRequests) observe(o RequestOutcome, detail metrics.RequestMetrics) bool {
+	if s == nil {
+		return false
+	}
+	threshold := s.threshold(o.Model)
+	if threshold <= 0 || detail.TotalTime <= threshold {
+		return false
+	}
+
+	report := SlowRequest{
+		RequestID:   o.RequestID,
+		KeyID:       o.KeyID,
+		Model:       o.Model,
+		Path:        o.Path,
+		Priority:    o.Priority,
+		Backend:     o.Backend,
+		Outcome:     o.Outcome,
+		Status:      o.Status,
+		Retries:     o.Retries,
+		Preemptions: o.Preemptions,
+		FinishedAt:  o.FinishedAt,
+		TotalMs:     detail.TotalTime.Milliseconds(),
+		ThresholdMs: threshold.Milliseconds(),
+		Phases: SlowPhases{
+			QueueMs:            detail.QueueWaitTime.Milliseconds(),
+			SchedulingMs:       detail.SchedulingDelay.Milliseconds(),
+			UpstreamMs:         detail.ProcessingTime.Milliseconds(),
+			UpstreamReportedMs: detail.UpstreamProcessingTime.Milliseconds(),
+		},
+	}
+	// Whatever isn't accounted for went on relaying the response
+	rest := detail.TotalTime - detail.QueueWaitTime - detail.SchedulingDelay - detail.ProcessingTime
+	report.Phases.ResponseMs = max(rest, 0).Milliseconds()
+
+	if line, err := json.Marshal(report); err == nil {
+		fmt.Printf("Slow request: %s\n", line)
+	}
+	s.record(report)
+	return true
+}
+
+// record keeps a report if it is among the slowest of the window
+func (s *SlowRequests) record(report SlowRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+
+	i := sort.Search(len(s.slowest), func(i int) bool { return s.slowest[i].TotalMs < report.TotalMs })
+	s.slowest = append(s.slowest, SlowRequest{})
+	copy(s.slowest[i+1:], s.slowest[i:])
+	s.slowest[i] = report
+
+	keep := s.Keep
+	if keep <= 0 {
+		keep = defaultSlowKeep
+	}
+	if len(s.slowest) > keep {
+		s.slowest = s.slowest[:keep]
+	}
+}
+
+// expire drops reports older than the window; callers hold mu
+func (s *SlowRequests) expire() {
+	window := s.Window
+	if window <= 0 {
+		window = defaultSlowWindow
+	}
+	cutoff := s.now().Add(-window)
+	kept := s.slowest[:0]
+	for _, report := range s.slowest {
+		if report.FinishedAt.After(cutoff) {
+			kept = append(kept, report)
+		}
+	}
+	s.slowest = kept
+}
+
+// Slowest returns up to limit of the slowest requests of the window, slowest first
+func (s *SlowRequests) Slowest(limit int) []SlowRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	return append([]SlowRequest{}, s.slowest[:min(limit, len(s.slowest))]...)
+}
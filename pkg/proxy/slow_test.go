@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/internal/mockopenai"
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestSlowRequests(t *testing.T) {
+	slow := NewSlowRequests(time.Second, map[string]time.Duration{"o3*": time.Minute, "o3-mini": 5 * time.Second})
+	slow.Keep = 2
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	slow.now = func() time.Time { return now }
+
+	observe := func(model string, total time.Duration) bool {
+		return slow.observe(RequestOutcome{Model: model, Path: "/v1/chat/completions", FinishedAt: now}, metrics.RequestMetrics{
+			TotalTime:       total,
+			QueueWaitTime:   total / 4,
+			SchedulingDelay: total / 4,
+			ProcessingTime:  total / 4,
+		})
+	}
+
+	if observe("gpt-4o", 500*time.Millisecond) {
+		t.Error("Expected a request under the threshold not flagged")
+	}
+	if !observe("gpt-4o", 2*time.Second) {
+		t.Error("Expected a request over the threshold flagged")
+	}
+	// Models keep their own thresholds, an exact name over a pattern
+	if observe("o3", 30*time.Second) {
+		t.Error("Expected the o3* threshold applied")
+	}
+	if !observe("o3-mini", 6*time.Second) {
+		t.Error("Expected o3-mini's own threshold applied")
+	}
+	if !observe("gpt-4o", 3*time.Second) {
+		t.Error("Expected a request over the threshold flagged")
+	}
+
+	// Only the slowest are kept, slowest first
+	slowest := slow.Slowest(10)
+	if len(slowest) != 2 || slowest[0].TotalMs != 6000 || slowest[1].TotalMs != 3000 {
+		t.Fatalf("Expected the 2 slowest kept, got %+v", slowest)
+	}
+	if p := slowest[1].Phases; p.QueueMs != 750 || p.SchedulingMs != 750 || p.UpstreamMs != 750 || p.ResponseMs != 750 {
+		t.Errorf("Expected the time split between the phases, got %+v", p)
+	}
+	if slowest[0].ThresholdMs != 5000 {
+		t.Errorf("Expected the request's threshold reported, got %d", slowest[0].ThresholdMs)
+	}
+
+	// They age out of the window
+	now = now.Add(2 * time.Hour)
+	if slowest := slow.Slowest(10); len(slowest) != 0 {
+		t.Errorf("Expected requests older than the window dropped, got %+v", slowest)
+	}
+}
+
+func TestSlowRequestReport(t *testing.T) {
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	client := &MockOpenAIClient{Script: []mockopenai.Response{{Delay: 60 * time.Millisecond, Body: `{"id":"slow"}`}}}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Slow = NewSlowRequests(50*time.Millisecond, nil)
+	admin := NewAdminHandler(qm, nil)
+
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/slow", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 while reports aren't enabled, got %d", recorder.Code)
+	}
+
+	req := &workRequest{
+		Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+		Model:          "gpt-4o",
+	}
+	qm.processRequest(req, qm.Queues[0])
+	<-req.Done
+	if m := <-recorded; !m.Slow {
+		t.Error("Expected the request flagged slow in metrics")
+	}
+
+	admin.Slow = qm.Slow
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest("GET", "/admin/slow?limit=5", nil))
+	var listed struct {
+		Requests []SlowRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil || len(listed.Requests) != 1 {
+		t.Fatalf("Expected the slow request listed, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if r := listed.Requests[0]; r.Model != "gpt-4o" || r.Phases.UpstreamMs < 60 {
+		t.Errorf("Expected the upstream wait reported, got %+v", r)
+	}
+}
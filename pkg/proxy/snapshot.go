@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// snapshotMagic starts every snapshot, naming its format version
+const snapshotMagic = "MQS2"
+
+// Snapshot keys are derived from the passphrase with PBKDF2-SHA256 under a
+// random salt per snapshot, so a stolen snapshot can't be attacked with
+// tables precomputed for every passphrase
+const (
+	snapshotSaltBytes  = 16
+	snapshotIterations = 600000
+)
+
+// maxSnapshotBytes bounds the snapshots /admin/queue/import reads
+const maxSnapshotBytes = 1 << 30
+
+// errBadSnapshot is returned for a snapshot that isn't one, or was sealed
+// with another key
+var errBadSnapshot = errors.New("not a queue snapshot, or sealed with another key")
+
+// Snapshot is the backlog of queued requests moved from one proxy instance
+// to another, bodies included
+type Snapshot struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Jobs       []*Job    `json:"jobs"`
+}
+
+// ImportResult counts what became of a snapshot's requests on import
+type ImportResult struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // No queue for their priority or room on it, or already retried here
+}
+
+// QueueSnapshots moves a backlog between proxy instances, e.g. when a node
+// is replaced. Export takes the requests waiting in this instance's queues
+// off them and returns them sealed with AES-GCM under a key derived from a
+// passphrase the instances share. Only requests whose answer can still
+// reach their client are moved: ones that can be replayed and carry an
+// Idempotency-Key. Their clients are told with a 503 to retry with the
+// same key, and Import runs them under the journal here, which answers
+// those retries once they are done.
+type QueueSnapshots struct {
+	QueueManager *QueueManager
+	passphrase   string
+}
+
+// NewQueueSnapshots creates snapshots of qm's queues sealed with passphrase
+func NewQueueSnapshots(qm *QueueManager, passphrase string) (*QueueSnapshots, error) {
+	if passphrase == "" {
+		return nil, errors.New("queue snapshots need a key")
+	}
+	return &QueueSnapshots{QueueManager: qm, passphrase: passphrase}, nil
+}
+
+// aead returns the cipher of snapshots sealed under salt
+func (s *QueueSnapshots) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, s.passphrase, salt, snapshotIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Export takes the requests waiting at priority (0 for every queue) that
+// can be moved off their queues and returns them as a sealed snapshot
+func (s *QueueSnapshots) Export(priority int) ([]byte, int, error) {
+	salt := make([]byte, snapshotSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, 0, err
+	}
+	aead, err := s.aead(salt)
+	if err != nil {
+		return nil, 0, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, 0, err
+	}
+
+	jobs := s.QueueManager.exportQueued(priority)
+	plain, err := json.Marshal(Snapshot{ExportedAt: time.Now(), Jobs: jobs})
+	if err != nil {
+		return nil, 0, err
+	}
+	header := append([]byte(snapshotMagic), salt...)
+	sealed := append(bytes.Clone(header), nonce...)
+	return aead.Seal(sealed, nonce, plain, header), len(jobs), nil
+}
+
+// Import queues the requests of a sealed snapshot. It needs the journal,
+// through which their clients collect their answers.
+func (s *QueueSnapshots) Import(sealed []byte) (ImportResult, error) {
+	salted := len(snapshotMagic) + snapshotSaltBytes
+	if len(sealed) < salted || string(sealed[:len(snapshotMagic)]) != snapshotMagic {
+		return ImportResult{}, errBadSnapshot
+	}
+	aead, err := s.aead(sealed[len(snapshotMagic):salted])
+	if err != nil {
+		return ImportResult{}, err
+	}
+	header := salted + aead.NonceSize()
+	if len(sealed) < header {
+		return ImportResult{}, errBadSnapshot
+	}
+	plain, err := aead.Open(nil, sealed[salted:header], sealed[header:], sealed[:salted])
+	if err != nil {
+		return ImportResult{}, errBadSnapshot
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(plain, &snapshot); err != nil {
+		return ImportResult{}, fmt.Errorf("decoding queue snapshot: %w", err)
+	}
+	if s.QueueManager.Journal == nil {
+		return ImportResult{}, errors.New("importing requests needs the journal enabled, for their clients to collect their answers")
+	}
+	return s.QueueManager.importJobs(snapshot.Jobs), nil
+}
+
+// exportQueued takes the requests waiting at priority (0 for all) that can
+// be moved off their queues as jobs, answering each client with a 503 that
+// asks it to retry. Requests left queued keep their order, unless others
+// filled their queue while it was being emptied.
+func (qm *QueueManager) exportQueued(priority int) []*Job {
+	var jobs []*Job
+	var moved []*workRequest
+	var from []*PriorityQueue
+	// Requests left queued that found their queue full
+	var overflow []*workRequest
+	var overflowTo []*PriorityQueue
+
+	// The write lock keeps the scheduler off the queues while they are emptied
+	qm.mu.Lock()
+	for _, q := range qm.Queues {
+		if priority != 0 && q.Priority != priority {
+			continue
+		}
+		take := func(req *workRequest, body []byte) {
+			arrival := req.Priority
+			if arrival == 0 {
+				arrival = q.Priority
+			}
+			q.queuedBytes.Add(-req.BodySize)
+			jobs = append(jobs, newJob(req, arrival, body))
+			moved = append(moved, req)
+			from = append(from, q)
+		}
+
+		bodies := make(map[*workRequest][]byte)
+		for _, req := range q.removeHeld(func(req *workRequest) bool {
+			body, ok := qm.queuedBody(req)
+			bodies[req] = body
+			return ok
+		}) {
+			take(req, bodies[req])
+		}
+		for n := len(q.Requests); n > 0; n-- {
+			var req *workRequest
+			select {
+			case req = <-q.Requests:
+			default:
+			}
+			if req == nil {
+				break
+			}
+
+			if body, ok := qm.queuedBody(req); ok {
+				take(req, body)
+				continue
+			}
+
+			select {
+			case q.Requests <- req:
+			default:
+				// Requests are enqueued and requeued without the lock, so
+				// others may have taken its place
+				overflow = append(overflow, req)
+				overflowTo = append(overflowTo, q)
+			}
+		}
+	}
+	qm.mu.Unlock()
+
+	// The scheduler makes room for them once it has the lock back
+	for i, req := range overflow {
+		overflowTo[i].Requests <- req
+	}
+
+	now := time.Now()
+	for i, req := range moved {
+		qm.tracef("export request %s from priority %d queue", traceID(req), from[i].Priority)
+		req.ResponseWriter.Header().Set("Retry-After", "1")
+		writeError(req.ResponseWriter, http.StatusServiceUnavailable,
+			"Request moved to another proxy instance; retry it with the same Idempotency-Key for its answer")
+
+		o := qm.outcome(req, OutcomeRejected)
+		o.Reason = RejectMigrated
+		o.Status = http.StatusServiceUnavailable
+		qm.finish(o, metrics.RequestMetrics{
+			QueueWaitTime: queuedFor(req, now),
+			RetryCount:    req.RetryCount,
+			Preempted:     req.Preempted,
+			Tags:          req.Tags,
+			User:          req.User,
+		})
+		close(req.Done)
+	}
+	return jobs
+}
+
+// queuedBody returns the body of a queued request, false for requests that
+// can't be moved: ones whose body streams from the client, or served
+// in-process, ones that aren't safe to replay, and ones without an
+// Idempotency-Key, whose client couldn't collect the answer
+func (qm *QueueManager) queuedBody(req *workRequest) ([]byte, bool) {
+	if req.Passthrough || req.Forward != nil || req.Request.Header.Get(IdempotencyKeyHeader) == "" {
+		return nil, false
+	}
+	classifier := qm.RetryClassifier
+	if classifier == nil {
+		classifier = NewRetryClassifier(nil)
+	}
+	if !classifier.IsRetryable(req.Request.Method, req.Request.URL.Path) {
+		return nil, false
+	}
+	if req.Request.Body == nil || req.Request.Body == http.NoBody {
+		return nil, true
+	}
+	body, err := io.ReadAll(req.Request.Body)
+	// Whatever happens to the request, its body stays readable
+	setBody(req.Request, body)
+	return body, err == nil
+}
+
+// importJobs queues jobs from another instance on the queues of their
+// priorities, journaled so their clients' retries are answered once they
+// have run
+func (qm *QueueManager) importJobs(jobs []*Job) ImportResult {
+	var result ImportResult
+	for _, job := range jobs {
+		queue := qm.FindQueue(job.Priority)
+		if queue == nil {
+			result.Skipped++
+			continue
+		}
+		jw := &journalWriter{ResponseWriter: newResponseBuffer()}
+		req, cancel, err := job.request(jw)
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		// A client that has already retried here has its answer coming
+		req.journal = qm.Journal.adopt(req.Request, req.KeyID)
+		if req.journal == nil {
+			cancel()
+			result.Skipped++
+			continue
+		}
+		if err := qm.enqueue(queue, req); err != nil {
+			qm.Journal.forget(req.journal)
+			cancel()
+			qm.LogSampler.logf(logError, "Could not import request for model %s on priority %d: %v\n",
+				job.Model, job.Priority, err)
+			result.Skipped++
+			continue
+		}
+		go func() {
+			<-req.Done
+			qm.Journal.settle(req.journal, req, jw)
+			cancel()
+		}()
+		result.Imported++
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestQueueSnapshotExportImport(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	endpoints := []config.Endpoint{{Port: 8080, Priority: 1}, {Port: 8081, Priority: 2}}
+
+	// The old node has a backlog on both queues
+	old := NewQueueManager(endpoints, &MockOpenAIClient{})
+	sink := &outcomeRecorder{}
+	old.Outcomes = []OutcomeSink{sink}
+	oldSnapshots, err := NewQueueSnapshots(old, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldAdmin := NewAdminHandler(old, nil)
+	oldAdmin.Snapshots = oldSnapshots
+
+	newRequest := func(body string) (*workRequest, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("POST", "/v1/chat/completions?x=1", bytes.NewBufferString(body))
+		r.Header.Set(RequestIDHeader, "req-"+body)
+		r.Header.Set(IdempotencyKeyHeader, "idem-"+body)
+		recorder := httptest.NewRecorder()
+		return &workRequest{
+			Request:        r,
+			ResponseWriter: recorder,
+			Done:           make(chan struct{}),
+			StartTime:      time.Now().Add(-time.Minute),
+			Model:          "gpt-4",
+			KeyID:          "key-1",
+			BodySize:       int64(len(body)),
+		}, recorder
+	}
+	interactive, interactiveRecorder := newRequest(`{"n":1}`)
+	batch, _ := newRequest(`{"n":2}`)
+	streaming, _ := newRequest(`{"n":3}`)
+	streaming.Passthrough = true
+	// Without an Idempotency-Key the client couldn't collect the answer
+	unkeyed, _ := newRequest(`{"n":4}`)
+	unkeyed.Request.Header.Del(IdempotencyKeyHeader)
+	for req, q := range map[*workRequest]*PriorityQueue{interactive: old.FindQueue(1), batch: old.FindQueue(2), streaming: old.FindQueue(2), unkeyed: old.FindQueue(2)} {
+		if err := old.enqueue(q, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	oldAdmin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/queue/export", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("X-Proxy-Exported") != "2" {
+		t.Fatalf("Expected 2 requests exported, got %d (%s)", recorder.Code, recorder.Header().Get("X-Proxy-Exported"))
+	}
+	snapshot := recorder.Body.Bytes()
+	if bytes.Contains(snapshot, []byte(`"n":1`)) {
+		t.Error("Expected request bodies encrypted")
+	}
+
+	// Moved requests are answered on the old node, the others stay
+	select {
+	case <-interactive.Done:
+	default:
+		t.Fatal("Expected the exported request answered")
+	}
+	if interactiveRecorder.Code != http.StatusServiceUnavailable || interactiveRecorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected a 503 asking for a retry for the exported request, got %d", interactiveRecorder.Code)
+	}
+	if len(old.FindQueue(1).Requests) != 0 || len(old.FindQueue(2).Requests) != 2 {
+		t.Error("Expected the passthrough and unkeyed requests left queued")
+	}
+	if len(sink.outcomes) != 2 || sink.outcomes[0].Reason != RejectMigrated {
+		t.Errorf("Expected migrated outcomes, got %+v", sink.outcomes)
+	}
+
+	// A node with another key can't read the snapshot
+	stranger, _ := NewQueueSnapshots(NewQueueManager(endpoints, &MockOpenAIClient{}), "another passphrase entirely")
+	if _, err := stranger.Import(snapshot); err != errBadSnapshot {
+		t.Errorf("Expected a snapshot sealed with another key refused, got %v", err)
+	}
+
+	// The new node queues them where they were, with what they carried
+	var bodies []string
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		b, _ := io.ReadAll(body)
+		bodies = append(bodies, path+" "+string(b))
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+	}
+	replacement := NewQueueManager(endpoints, client)
+	newSnapshots, _ := NewQueueSnapshots(replacement, "correct horse battery staple")
+	newAdmin := NewAdminHandler(replacement, nil)
+	newAdmin.Snapshots = newSnapshots
+
+	// Their answers reach their clients through the journal
+	recorder = httptest.NewRecorder()
+	newAdmin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/queue/import", bytes.NewReader(snapshot)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an import without the journal refused, got %d", recorder.Code)
+	}
+	replacement.Journal = NewJournal(nil)
+
+	recorder = httptest.NewRecorder()
+	newAdmin.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/queue/import", bytes.NewReader(snapshot)))
+	var result ImportResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil || result.Imported != 2 || result.Skipped != 0 {
+		t.Fatalf("Expected 2 requests imported, got %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	imported := <-replacement.FindQueue(1).Requests
+	if imported.KeyID != "key-1" || imported.Model != "gpt-4" || imported.requestID() != "req-"+`{"n":1}` {
+		t.Errorf("Expected the request's attribution kept, got %+v", imported)
+	}
+	if time.Since(imported.StartTime) < time.Minute {
+		t.Errorf("Expected the request's arrival time kept, got %v", imported.StartTime)
+	}
+	replacement.processRequest(imported, replacement.FindQueue(1))
+	<-imported.Done
+	if len(bodies) != 1 || bodies[0] != `/v1/chat/completions?x=1 {"n":1}` {
+		t.Errorf("Expected the request sent upstream as it arrived, got %q", bodies)
+	}
+	// The journal settles the request just after it is done
+	for i := 0; i < 100 && len(replacement.Journal.Entries(JournalCompleted)) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	retry := httptest.NewRequest("POST", "/v1/chat/completions?x=1", bytes.NewBufferString(`{"n":1}`))
+	retry.Header.Set(IdempotencyKeyHeader, "idem-"+`{"n":1}`)
+	recorder = httptest.NewRecorder()
+	if _, ok := replacement.Journal.begin(recorder, retry, "key-1"); ok || recorder.Header().Get(JournalHeader) != "replayed" {
+		t.Errorf("Expected the client's retry answered from the journal, got %d %v", recorder.Code, recorder.Header())
+	}
+	if len(replacement.FindQueue(2).Requests) != 1 {
+		t.Error("Expected the batch request queued at its priority")
+	}
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// speculativeKey is the context key for the upstream a request is raced against
+type speculativeKey struct{}
+
+// contextWithSpeculative returns a context carrying a second upstream to race a request against
+func contextWithSpeculative(ctx context.Context, backend string) context.Context {
+	return context.WithValue(ctx, speculativeKey{}, backend)
+}
+
+// speculativeFromContext returns the second upstream carried by a context, if any
+func speculativeFromContext(ctx context.Context) string {
+	backend, _ := ctx.Value(speculativeKey{}).(string)
+	return backend
+}
+
+// SpeculativeStats compares the backends requests were raced between
+type SpeculativeStats struct {
+	Races           int64   `json:"races"`              // Requests this backend took part in
+	Wins            int64   `json:"wins"`               // Races it answered first
+	Failures        int64   `json:"failures"`           // Races it answered with an error
+	Cancelled       int64   `json:"cancelled"`          // Races it was cancelled in after losing
+	AvgWinLatencyMs float64 `json:"avg_win_latency_ms"` // Average time to a winning response
+	winLatency      time.Duration
+}
+
+// Speculator decides which requests may be dual-dispatched and records how
+// the backends compare. Every race costs one extra upstream request, which
+// is limited to Budget per minute.
+type Speculator struct {
+	Budget      int // Extra upstream requests allowed per minute (0 = unlimited)
+	mu          sync.Mutex
+	windowStart time.Time
+	used        int
+	stats       map[string]*SpeculativeStats
+}
+
+// NewSpeculator creates a speculator allowing budget races per minute
+func NewSpeculator(budget int) *Speculator {
+	return &Speculator{
+		Budget: budget,
+		stats:  make(map[string]*SpeculativeStats),
+	}
+}
+
+// allow spends one race from this minute's budget, if any is left
+func (s *Speculator) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) >= time.Minute {
+		s.windowStart, s.used = now, 0
+	}
+	if s.Budget > 0 && s.used >= s.Budget {
+		return false
+	}
+	s.used++
+	return true
+}
+
+// record adds the outcome of one backend's attempt in a race
+func (s *Speculator) record(backend string, latency time.Duration, won bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.stats[backend]
+	if !ok {
+		stats = &SpeculativeStats{}
+		s.stats[backend] = stats
+	}
+
+	stats.Races++
+	switch {
+	case won:
+		stats.Wins++
+		stats.winLatency += latency
+		stats.AvgWinLatencyMs = float64(stats.winLatency.Milliseconds()) / float64(stats.Wins)
+	case errors.Is(err, context.Canceled):
+		stats.Cancelled++
+	case err != nil:
+		stats.Failures++
+	}
+}
+
+// Stats returns a snapshot of the race statistics, keyed by backend
+func (s *Speculator) Stats() map[string]SpeculativeStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]SpeculativeStats, len(s.stats))
+	for backend, st := range s.stats {
+		stats[backend] = *st
+	}
+	return stats
+}
+
+// raceResult is one backend's answer in a race
+type raceResult struct {
+	backend string
+	resp    *http.Response
+	err     error
+	latency time.Duration
+}
+
+// ok reports whether the backend answered successfully
+func (r raceResult) ok() bool {
+	return r.err == nil && r.resp.StatusCode < 400
+}
+
+// race sends a request to two backends at once and returns the first
+// successful response, cancelling the other. Responses are read in full
+// before they count, so only non-streaming requests should be raced.
+func (c *BackendClient) race(ctx context.Context, method, path string, body io.Reader, backends [2]string) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	start := time.Now()
+	results := make(chan raceResult, len(backends))
+	cancels := make([]context.CancelFunc, len(backends))
+	for i, backend := range backends {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels[i] = cancel
+
+		go func(backend string) {
+			var reader io.Reader
+			if payload != nil {
+				reader = bytes.NewReader(payload)
+			}
+
+			resp, err := c.client(backend).ForwardRequest(attemptCtx, method, path, reader)
+			if err == nil {
+				// Buffer the response so cancelling the loser can't cut it short
+				var data []byte
+				data, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+				resp.Body = io.NopCloser(bytes.NewReader(data))
+			}
+			results <- raceResult{backend: backend, resp: resp, err: err, latency: time.Since(start)}
+		}(backend)
+	}
+	cancelAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+
+	first := <-results
+	if first.ok() {
+		cancelAll()
+		c.Speculator.record(c.label(first.backend), first.latency, true, nil)
+
+		// Record the loser once it notices it was cancelled
+		go func() {
+			loser := <-results
+			c.Speculator.record(c.label(loser.backend), loser.latency, false, loser.err)
+			fmt.Printf("Speculative dispatch for %s: %s won in %v, %s stopped after %v\n",
+				path, c.label(first.backend), first.latency, c.label(loser.backend), loser.latency)
+		}()
+		return first.resp, nil
+	}
+
+	// The first answer was a failure, so the race is down to the other backend
+	second := <-results
+	cancelAll()
+	c.Speculator.record(c.label(first.backend), first.latency, false, raceError(first))
+	c.Speculator.record(c.label(second.backend), second.latency, second.ok(), raceError(second))
+	fmt.Printf("Speculative dispatch for %s: %s failed after %v, %s answered in %v\n",
+		path, c.label(first.backend), first.latency, c.label(second.backend), second.latency)
+
+	// Prefer a response the client can see over a transport error
+	if second.ok() || first.err != nil {
+		return second.resp, second.err
+	}
+	return first.resp, first.err
+}
+
+// raceError returns why a race result failed, nil if it succeeded
+func raceError(r raceResult) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", r.resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// delayedClient answers after a delay unless its context is cancelled first
+type delayedClient struct {
+	delay  time.Duration
+	status int
+	body   string
+	calls  chan struct{}
+}
+
+func (c *delayedClient) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	c.calls <- struct{}{}
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return &http.Response{
+		StatusCode: c.status,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(c.body)),
+	}, nil
+}
+
+func TestSpeculativeDispatch(t *testing.T) {
+	slow := &delayedClient{delay: 300 * time.Millisecond, status: http.StatusOK, body: "slow", calls: make(chan struct{}, 10)}
+	fast := &delayedClient{delay: 10 * time.Millisecond, status: http.StatusOK, body: "fast", calls: make(chan struct{}, 10)}
+	failing := &delayedClient{status: http.StatusServiceUnavailable, body: "down", calls: make(chan struct{}, 10)}
+
+	client := &BackendClient{
+		Default:    slow,
+		Backends:   map[string]OpenAIClient{"http://fast": fast, "http://failing": failing},
+		Speculator: NewSpeculator(2),
+	}
+
+	forward := func(speculative string) string {
+		ctx := contextWithSpeculative(context.Background(), speculative)
+		resp, err := client.ForwardRequest(ctx, "POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4"}`))
+		if err != nil {
+			t.Fatalf("Failed to forward request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	// The faster backend wins and the slower one is cancelled
+	start := time.Now()
+	if body := forward("http://fast"); body != "fast" {
+		t.Errorf("Expected the fast response, got %q", body)
+	}
+	if time.Since(start) > 150*time.Millisecond {
+		t.Error("Expected the fast backend to answer without waiting for the slow one")
+	}
+
+	// A failure is ignored while the other backend can still succeed
+	if body := forward("http://failing"); body != "slow" {
+		t.Errorf("Expected the successful response, got %q", body)
+	}
+
+	// The budget is spent, so the next request only goes to its own backend
+	forward("http://fast")
+	if len(fast.calls) != 1 {
+		t.Errorf("Expected the speculative backend to be skipped once the budget is spent, got %d calls", len(fast.calls))
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	stats := client.Speculator.Stats()
+	if s := stats["http://fast"]; s.Races != 1 || s.Wins != 1 || s.AvgWinLatencyMs <= 0 {
+		t.Errorf("Unexpected stats for the fast backend: %+v", s)
+	}
+	if s := stats["http://failing"]; s.Races != 1 || s.Failures != 1 {
+		t.Errorf("Unexpected stats for the failing backend: %+v", s)
+	}
+	if s := stats["default"]; s.Races != 2 || s.Wins != 1 || s.Cancelled != 1 {
+		t.Errorf("Unexpected stats for the default backend: %+v", s)
+	}
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// statefulPrefixes are the API paths whose objects live on the upstream that
+// created them: assistants, threads with their messages and runs, and
+// fine-tuning jobs
+var statefulPrefixes = []string{"/v1/assistants", "/v1/threads", "/v1/fine_tuning"}
+
+// maxPins bounds how many thread and assistant IDs are remembered
+const maxPins = 100000
+
+// maxPinScan bounds how much of a response is searched for the IDs it creates
+const maxPinScan = 64 << 10
+
+// statefulIDPattern finds thread, assistant and fine-tuning job IDs in a
+// response, e.g. a created thread's "id" or a run's "thread_id"
+var statefulIDPattern = regexp.MustCompile(`"(?:id|thread_id|assistant_id)"\s*:\s*"((?:thread|asst)_[A-Za-z0-9]+|ftjob-[A-Za-z0-9]+)"`)
+
+// statefulIDPrefixes start the IDs of objects that live on one upstream
+var statefulIDPrefixes = []string{"thread_", "asst_", "ftjob-"}
+
+// isStateful reports whether a path belongs to an API whose objects live on one upstream
+func isStateful(reqPath string) bool {
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	for _, prefix := range statefulPrefixes {
+		if reqPath == prefix || strings.HasPrefix(reqPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// statefulIDs returns the thread, assistant and fine-tuning job IDs a path
+// names, e.g. thread_abc for /v1/threads/thread_abc/runs
+func statefulIDs(reqPath string) []string {
+	reqPath, _, _ = strings.Cut(reqPath, "?")
+	var ids []string
+	for _, segment := range strings.Split(reqPath, "/") {
+		for _, prefix := range statefulIDPrefixes {
+			if strings.HasPrefix(segment, prefix) {
+				ids = append(ids, segment)
+			}
+		}
+	}
+	return ids
+}
+
+// pinBody passes a response through, handing the IDs of the objects found
+// in its start to pin once it is closed
+type pinBody struct {
+	io.ReadCloser
+	buf []byte
+	pin func(ids []string)
+}
+
+// Read implements io.Reader
+func (b *pinBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxPinScan - len(b.buf); room > 0 {
+		b.buf = append(b.buf, p[:min(n, room)]...)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *pinBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.pin != nil {
+		var ids []string
+		for _, match := range statefulIDPattern.FindAllSubmatch(b.buf, -1) {
+			ids = append(ids, string(match[1]))
+		}
+		if len(ids) > 0 {
+			b.pin(ids)
+		}
+		b.pin = nil
+	}
+	return err
+}
+
+// pins remembers which replica each thread, assistant and job lives on,
+// forgetting the oldest once maxPins are held. Callers hold the client's mu.
+type pins struct {
+	replica map[string]int
+	order   []string
+}
+
+// lookup returns the replica the first pinned ID lives on
+func (p *pins) lookup(ids []string) (int, bool) {
+	for _, id := range ids {
+		if i, ok := p.replica[id]; ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// add pins IDs to a replica, leaving those already pinned where they are
+func (p *pins) add(ids []string, replica int) {
+	if p.replica == nil {
+		p.replica = make(map[string]int)
+	}
+	for _, id := range ids {
+		if _, ok := p.replica[id]; !ok {
+			p.replica[id] = replica
+			p.order = append(p.order, id)
+		}
+	}
+	for len(p.order) > maxPins {
+		delete(p.replica, p.order[0])
+		p.order = p.order[1:]
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestStatefulPaths(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/v1/assistants":                    true,
+		"/v1/threads/thread_1/runs?limit=5": true,
+		"/v1/threadsafe":                    false,
+		"/v1/chat/completions":              false,
+	} {
+		if got := isStateful(path); got != want {
+			t.Errorf("%s: expected stateful %v, got %v", path, want, got)
+		}
+	}
+
+	ids := statefulIDs("/v1/threads/thread_1/runs/run_2?assistant=asst_3")
+	if !reflect.DeepEqual(ids, []string{"thread_1"}) {
+		t.Errorf("Expected the thread ID from the path, got %v", ids)
+	}
+}
+
+func TestAssistantsPassthrough(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("OpenAI-Beta") != "assistants=v2" {
+			t.Errorf("Expected the client's OpenAI-Beta upstream, got %q", r.Header.Get("OpenAI-Beta"))
+		}
+		if r.URL.RawQuery != "order=asc&limit=20" {
+			t.Errorf("Expected the client's query upstream, got %q", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	}))
+	defer server.Close()
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, openai.NewClient(server.URL, "test-key"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	req := httptest.NewRequest("GET", "/v1/threads/thread_1/messages?order=asc&limit=20", nil)
+	req.Host = "localhost:8080"
+	req.Header.Set("OpenAI-Beta", "assistants=v2")
+	recorder := httptest.NewRecorder()
+	NewRequestHandler(qm).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+}
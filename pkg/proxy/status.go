@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// StatusSnapshot is the proxy's state at a point in time, served by
+// /admin/status for scripts and chat bots. Sections for features that
+// aren't enabled are left out.
+type StatusSnapshot struct {
+	Time          time.Time             `json:"time"`
+	UptimeSeconds int64                 `json:"uptime_seconds"`
+	Build         BuildInfo             `json:"build"`
+	Queues        []QueueStatus         `json:"queues"`
+	Queued        int                   `json:"queued"`   // Requests waiting, over all queues
+	Inflight      int64                 `json:"inflight"` // Requests running, over all queues
+	Backends      []BackendInfo         `json:"backends,omitempty"`
+	BackendUsage  []BackendUsageStatus  `json:"backend_usage,omitempty"` // Tokens and requests per upstream against provider quotas
+	Regions       []RegionStatus        `json:"regions,omitempty"`
+	Limits        StatusLimits          `json:"limits"`
+	Schedules     []string              `json:"schedules,omitempty"` // Schedule windows in effect
+	Maintenance   *MaintenanceStatus    `json:"maintenance,omitempty"`
+	Cache         *CacheStats           `json:"cache,omitempty"`
+	UpstreamKey   *openai.KeyRingStatus `json:"upstream_key,omitempty"`
+}
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"` // VCS commit the binary was built from
+	BuiltAt   string `json:"built_at,omitempty"` // Build date set at link time, otherwise the time of the commit
+	Modified  bool   `json:"modified,omitempty"` // Built from a working tree with uncommitted changes
+}
+
+// BackendInfo describes an upstream requests can be sent to
+type BackendInfo struct {
+	URL  string `json:"url"`
+	Role string `json:"role"` // "default", "replica", "region", "route" or "discovery"
+}
+
+// StatusLimits summarizes the limits requests are held to; zero is unlimited
+type StatusLimits struct {
+	RateWindowSeconds int    `json:"rate_window_seconds,omitempty"`
+	RequestsPerKey    int64  `json:"requests_per_key"`
+	OrgRequests       int64  `json:"org_requests"`
+	QuotaPeriod       string `json:"quota_period,omitempty"`
+	QuotaTokens       int64  `json:"quota_tokens"`
+	KeyConcurrency    int    `json:"key_concurrency"` // Requests each key may have in flight
+}
+
+// Build details set at link time, e.g. with
+//
+//	go build -ldflags "-X github.com/mule-ai/proxy/pkg/proxy.Version=v1.8.0 -X github.com/mule-ai/proxy/pkg/proxy.Commit=$(git rev-parse HEAD)"
+//
+// They take precedence over what the Go toolchain records, which lacks a
+// version for binaries built from a checkout and any VCS details for ones
+// built outside it, e.g. in a container.
+var (
+	Version   string
+	Commit    string
+	BuildDate string
+)
+
+// ReadBuildInfo identifies the running binary, from the details set at link
+// time and those the Go toolchain embedded
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: "unknown"}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return linkedBuildInfo(info)
+	}
+
+	info.Version = build.Main.Version
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.BuiltAt = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return linkedBuildInfo(info)
+}
+
+// linkedBuildInfo overrides info with the details set at link time
+func linkedBuildInfo(info BuildInfo) BuildInfo {
+	if Version != "" {
+		info.Version = Version
+	}
+	if Commit != "" {
+		info.Revision = Commit
+	}
+	if BuildDate != "" {
+		info.BuiltAt = BuildDate
+	}
+	return info
+}
+
+// version reports which build of the proxy is running
+func (h *AdminHandler) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.build)
+}
+
+// status reports a snapshot of the proxy's state
+func (h *AdminHandler) status(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	snapshot := StatusSnapshot{
+		Time:          now.UTC(),
+		UptimeSeconds: int64(now.Sub(h.started).Seconds()),
+		Build:         h.build,
+		Queues:        []QueueStatus{},
+		Backends:      h.Backends,
+		Limits:        h.Limits,
+	}
+
+	if h.QueueManager != nil {
+		snapshot.Queues = h.QueueManager.Status()
+		for _, q := range snapshot.Queues {
+			snapshot.Queued += q.Depth
+			snapshot.Inflight += q.Running
+		}
+	}
+	if h.Limiter != nil {
+		// Schedule windows may have changed the configured rate limits
+		snapshot.Limits.RequestsPerKey, snapshot.Limits.OrgRequests = h.Limiter.Limits()
+	}
+	if h.Schedule != nil {
+		snapshot.Schedules = h.Schedule.Active()
+	}
+	if h.Maintenance != nil {
+		maintenance := h.Maintenance.Status()
+		snapshot.Maintenance = &maintenance
+	}
+	if h.Cache != nil {
+		stats := h.Cache.Stats()
+		snapshot.Cache = &stats
+	}
+	if h.Regions != nil {
+		snapshot.Regions = h.Regions.Status()
+	}
+	if h.BackendUsage != nil {
+		snapshot.BackendUsage = h.BackendUsage.Status()
+	}
+	if h.Keys != nil {
+		keys := h.Keys.Status()
+		snapshot.UpstreamKey = &keys
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamIdle is returned when an upstream response stalls for longer than the idle timeout
+var ErrStreamIdle = errors.New("upstream stream idle timeout")
+
+// idleTimeoutReader wraps an upstream body and aborts it when no data arrives within the timeout
+type idleTimeoutReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	idle    atomic.Bool
+}
+
+// newIdleTimeoutReader starts the idle timer and returns the wrapped body
+func newIdleTimeoutReader(body io.ReadCloser, timeout time.Duration) *idleTimeoutReader {
+	r := &idleTimeoutReader{
+		body:    body,
+		timeout: timeout,
+	}
+
+	// Closing the body unblocks any Read that is waiting on the upstream
+	r.timer = time.AfterFunc(timeout, func() {
+		r.idle.Store(true)
+		body.Close()
+	})
+
+	return r
+}
+
+// Read reads from the upstream body and resets the idle timer on progress
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if r.idle.Load() {
+		return n, ErrStreamIdle
+	}
+
+	r.timer.Reset(r.timeout)
+	return n, err
+}
+
+// Close stops the idle timer and closes the upstream body
+func (r *idleTimeoutReader) Close() error {
+	r.timer.Stop()
+	return r.body.Close()
+}
+
+// copyResponse copies the upstream body to the client, flushing after every
+// chunk so streamed responses reach the client as they arrive
+func copyResponse(w http.ResponseWriter, body io.Reader) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+
+	var written int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// announceTrailers declares the trailers an upstream response will send, so
+// the client's response announces them too. It must be called before the
+// header is written.
+func announceTrailers(w http.ResponseWriter, trailer http.Header) {
+	for k := range trailer {
+		w.Header().Add("Trailer", k)
+	}
+}
+
+// copyTrailers sends an upstream response's trailers to the client. They
+// are only known once the upstream body has been read to the end.
+func copyTrailers(w http.ResponseWriter, trailer http.Header) {
+	for k, v := range trailer {
+		for _, vv := range v {
+			w.Header().Add(http.TrailerPrefix+k, vv)
+		}
+	}
+}
+
+// isEventStream reports whether a response is a server-sent event stream
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
+
+// writeStreamError terminates a partially written stream with an error the client can parse
+func writeStreamError(w http.ResponseWriter, header http.Header, message, errType string) {
+	if !isEventStream(header) {
+		// Nothing well-defined can be appended to a plain body, the client sees a truncated response
+		return
+	}
+
+	w.Write([]byte(`data: {"error":{"message":"` + message + `","type":"` + errType + `"}}` + "\n\n"))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// preemptedEvent is the last event of a stream cut off for higher priority
+// work. Its error makes OpenAI clients raise rather than treat the response
+// as complete; the marker and counts let other clients tell truncation apart.
+type preemptedEvent struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+	ProxyPreempted       bool  `json:"proxy_preempted"`
+	PartialContentLength int64 `json:"partial_content_length"` // Characters of content sent before the cut
+	PartialOutputTokens  int64 `json:"partial_output_tokens"`
+}
+
+// writePreemptedEvent ends a stream cut off for higher priority work
+func writePreemptedEvent(w http.ResponseWriter, contentLength, outputTokens int64) {
+	event := preemptedEvent{ProxyPreempted: true, PartialContentLength: contentLength, PartialOutputTokens: outputTokens}
+	event.Error.Message = "Response cut off for higher priority work"
+	event.Error.Type = "proxy_preempted"
+	data, _ := json.Marshal(event)
+
+	w.Write([]byte("data: " + string(data) + "\n\n"))
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
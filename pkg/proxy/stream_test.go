@@ -0,0 +1,446 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// stallingBody returns a body that emits the given chunk and then blocks until closed
+func stallingBody(chunk string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		if chunk != "" {
+			pw.Write([]byte(chunk))
+		}
+	}()
+	return pr
+}
+
+func TestIdleTimeoutReader(t *testing.T) {
+	reader := newIdleTimeoutReader(stallingBody("data: hello\n\n"), 50*time.Millisecond)
+	defer reader.Close()
+
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected first read to succeed, got %v", err)
+	}
+
+	if string(buf[:n]) != "data: hello\n\n" {
+		t.Errorf("Unexpected first chunk: %q", string(buf[:n]))
+	}
+
+	// The upstream never sends anything else
+	start := time.Now()
+	_, err = reader.Read(buf)
+	if err != ErrStreamIdle {
+		t.Errorf("Expected ErrStreamIdle, got %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("Idle timeout took too long to fire: %v", time.Since(start))
+	}
+}
+
+func TestStreamIdleTimeoutTerminatesStream(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	mockClient := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			header := make(http.Header)
+			header.Set("Content-Type", "text/event-stream")
+			return &http.Response{
+				StatusCode: 200,
+				Header:     header,
+				Body:       stallingBody("data: {\"id\":\"chunk-1\"}\n\n"),
+			}, nil
+		},
+	}
+
+	qm := &QueueManager{
+		Queues:            []*PriorityQueue{},
+		OpenAIClient:      mockClient,
+		StreamIdleTimeout: 50 * time.Millisecond,
+		mu:                sync.RWMutex{},
+	}
+
+	queue := &PriorityQueue{
+		Port:     8080,
+		Priority: 1,
+		Requests: make(chan *workRequest, 1),
+	}
+
+	testReq, _ := http.NewRequest("POST", "http://example.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4","stream":true}`))
+	recorder := httptest.NewRecorder()
+	workReq := &workRequest{
+		Request:        testReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		Model:          "gpt-4",
+	}
+
+	go qm.processRequest(workReq, queue)
+
+	select {
+	case <-workReq.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Stalled stream was not terminated")
+	}
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, "chunk-1") {
+		t.Errorf("Expected first chunk to be delivered, got: %s", body)
+	}
+
+	if !strings.Contains(body, "stream_idle_timeout") {
+		t.Errorf("Expected stream to end with an idle timeout error event, got: %s", body)
+	}
+}
+
+func TestStreamIdleTimeoutRetriesBeforeFirstChunk(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	var sent []string
+	mockClient := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			data, _ := io.ReadAll(body)
+			sent = append(sent, string(data))
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       stallingBody(""),
+			}, nil
+		},
+	}
+
+	qm := &QueueManager{
+		Queues:            []*PriorityQueue{},
+		OpenAIClient:      mockClient,
+		StreamIdleTimeout: 20 * time.Millisecond,
+		StreamIdleRetries: 1,
+		mu:                sync.RWMutex{},
+	}
+
+	queue := &PriorityQueue{
+		Port:     8080,
+		Priority: 1,
+		Requests: make(chan *workRequest, 1),
+	}
+
+	testReq, _ := http.NewRequest("POST", "http://example.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+	workReq := &workRequest{
+		Request:        testReq,
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+		Model:          "gpt-4",
+	}
+
+	qm.processRequest(workReq, queue)
+
+	// The stalled attempt should have been put back on the queue
+	var retried *workRequest
+	select {
+	case retried = <-queue.Requests:
+		if retried.IdleRetries != 1 || retried.RetryCount != 1 {
+			t.Errorf("Expected one idle retry to be recorded, got IdleRetries=%d RetryCount=%d",
+				retried.IdleRetries, retried.RetryCount)
+		}
+	default:
+		t.Fatal("Expected stalled request to be requeued")
+	}
+
+	// with the body the first attempt drained
+	qm.processRequest(retried, queue)
+	if len(sent) != 2 || sent[1] != `{"model":"gpt-4"}` {
+		t.Errorf("Expected the retry to send the original body, got %q", sent)
+	}
+}
+
+func TestStartedStreamIsNotPreempted(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	firstChunkSent := make(chan struct{})
+	mockClient := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.Write([]byte("data: chunk-1\n\n"))
+				close(firstChunkSent)
+				time.Sleep(150 * time.Millisecond)
+				pw.Write([]byte("data: chunk-2\n\n"))
+				pw.Close()
+			}()
+
+			header := make(http.Header)
+			header.Set("Content-Type", "text/event-stream")
+			return &http.Response{StatusCode: 200, Header: header, Body: pr}, nil
+		},
+	}
+
+	highPriorityQueue := &PriorityQueue{
+		Port:       8080,
+		Priority:   1,
+		Preemptive: true,
+		Requests:   make(chan *workRequest, 10),
+	}
+
+	lowPriorityQueue := &PriorityQueue{
+		Port:     8081,
+		Priority: 2,
+		Requests: make(chan *workRequest, 10),
+	}
+
+	qm := &QueueManager{
+		Queues:       []*PriorityQueue{highPriorityQueue, lowPriorityQueue},
+		OpenAIClient: mockClient,
+	}
+
+	testReq, _ := http.NewRequest("POST", "http://example.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4","stream":true}`))
+	recorder := httptest.NewRecorder()
+	workReq := &workRequest{
+		Request:        testReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		Model:          "gpt-4",
+	}
+
+	go qm.processRequest(workReq, lowPriorityQueue)
+
+	// Once the stream has started, a high priority arrival must not interrupt it
+	<-firstChunkSent
+	time.Sleep(10 * time.Millisecond)
+	highPriorityQueue.Requests <- &workRequest{
+		Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+	}
+
+	select {
+	case <-workReq.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Started stream did not complete")
+	}
+
+	if len(lowPriorityQueue.Requests) != 0 {
+		t.Error("Started stream should not have been requeued")
+	}
+
+	if !strings.Contains(recorder.Body.String(), "chunk-2") {
+		t.Errorf("Expected the full stream to be delivered, got: %s", recorder.Body.String())
+	}
+}
+
+func TestPreemptStreamsCutsOffStartedStream(t *testing.T) {
+	// Initialize metrics collector and capture what it records
+	collector := metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+	collectFn := collector.CollectFn
+	defer func() { collector.CollectFn = collectFn }()
+
+	recorded := make(chan metrics.RequestMetrics, 1)
+	collector.CollectFn = func(m metrics.RequestMetrics) error {
+		recorded <- m
+		return nil
+	}
+
+	// The upstream streams two chunks, then keeps generating until cancelled
+	streaming := make(chan struct{})
+	mockClient := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				pw.Write([]byte(`data: {"choices":[{"delta":{"content":"Hello"}}]}` + "\n\n"))
+				pw.Write([]byte(`data: {"choices":[{"delta":{"content":" wörld"}}]}` + "\n\n"))
+				close(streaming)
+				<-ctx.Done()
+				pw.CloseWithError(ctx.Err())
+			}()
+
+			header := make(http.Header)
+			header.Set("Content-Type", "text/event-stream")
+			return &http.Response{StatusCode: 200, Header: header, Body: pr}, nil
+		},
+	}
+
+	highPriorityQueue := &PriorityQueue{Port: 8080, Priority: 1, Preemptive: true, Requests: make(chan *workRequest, 10)}
+	lowPriorityQueue := &PriorityQueue{Port: 8081, Priority: 2, Requests: make(chan *workRequest, 10)}
+	qm := &QueueManager{
+		Queues:               []*PriorityQueue{highPriorityQueue, lowPriorityQueue},
+		OpenAIClient:         mockClient,
+		PreemptStreams:       true,
+		PreemptCheckInterval: 10 * time.Millisecond,
+	}
+
+	testReq, _ := http.NewRequest("POST", "http://example.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4","stream":true}`))
+	recorder := httptest.NewRecorder()
+	workReq := &workRequest{
+		Request:        testReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		Model:          "gpt-4",
+	}
+
+	go qm.processRequest(workReq, lowPriorityQueue)
+
+	<-streaming
+	highPriorityQueue.Requests <- &workRequest{
+		Request:        httptest.NewRequest("POST", "/v1/chat/completions", nil),
+		ResponseWriter: httptest.NewRecorder(),
+		Done:           make(chan struct{}),
+	}
+
+	select {
+	case <-workReq.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Started stream was not cut off")
+	}
+
+	if len(lowPriorityQueue.Requests) != 0 {
+		t.Error("A cut off stream can't be replayed and should not have been requeued")
+	}
+
+	body := recorder.Body.String()
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	last := events[len(events)-1]
+	if !strings.Contains(body, "wörld") || !strings.Contains(last, `"proxy_preempted":true`) ||
+		!strings.Contains(last, `"partial_content_length":11`) || !strings.Contains(last, `"type":"proxy_preempted"`) {
+		t.Errorf("Expected the stream to end with a preemption marker, got: %s", body)
+	}
+
+	m := <-recorded
+	if !m.Truncated || !m.Preempted || m.OutputTokens == 0 {
+		t.Errorf("Expected metrics for a truncated stream with partial tokens, got %+v", m)
+	}
+	if !strings.Contains(last, fmt.Sprintf(`"partial_output_tokens":%d`, m.OutputTokens)) {
+		t.Errorf("Expected the marker to report %d partial tokens, got: %s", m.OutputTokens, last)
+	}
+}
+
+func TestStreamFramingAndTrailers(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "upstream-only")
+		w.Write([]byte("data: one\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: two\n\n"))
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+
+	// Serve the proxy on a real listener so framing and flushing are observable
+	proxyServer := httptest.NewUnstartedServer(nil)
+	port := proxyServer.Listener.Addr().(*net.TCPAddr).Port
+	qm := NewQueueManager([]config.Endpoint{{Port: port, Priority: 1}}, openai.NewClient(upstream.URL, "test-key"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	proxyServer.Config.Handler = NewRequestHandler(qm)
+	proxyServer.Start()
+	defer proxyServer.Close()
+	defer close(release) // Let the upstream finish before the servers shut down
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(proxyServer.URL+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4","stream":true}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected a chunked response, got %v", resp.TransferEncoding)
+	}
+	if resp.Header.Get("X-Hop") != "" {
+		t.Errorf("Expected headers named in Connection to be dropped, got X-Hop %q", resp.Header.Get("X-Hop"))
+	}
+	if _, ok := resp.Trailer["X-Checksum"]; !ok {
+		t.Errorf("Expected the X-Checksum trailer to be announced, got %v", resp.Trailer)
+	}
+
+	// The first chunk must arrive while the upstream is still holding the second
+	first := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		first <- string(buf[:n])
+	}()
+	select {
+	case chunk := <-first:
+		if chunk != "data: one\n\n" {
+			t.Errorf("Expected the first chunk on its own, got %q", chunk)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the first chunk to be flushed")
+	}
+
+	release <- struct{}{}
+	rest, _ := io.ReadAll(resp.Body)
+	if string(rest) != "data: two\n\n" {
+		t.Errorf("Expected the second chunk, got %q", rest)
+	}
+	if resp.Trailer.Get("X-Checksum") != "abc123" {
+		t.Errorf("Expected trailer X-Checksum abc123, got %q", resp.Trailer.Get("X-Checksum"))
+	}
+}
+
+func TestBrokenUpstreamStreamEndsResponse(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The upstream's connection drops partway through its second chunk
+	first := `data: {"choices":[{"delta":{"content":"Hello"}}]}` + "\n\n"
+	broken := MockResponse{
+		Chunks:     []string{`{"choices":[{"delta":{"content":"Hello"}}]}`, `{"choices":[{"delta":{"content":" world"}}]}`},
+		ChunkDelay: 5 * time.Millisecond,
+		FailAfter:  len(first) + 10,
+	}
+	mockClient := &MockOpenAIClient{Script: []MockResponse{broken}}
+
+	queue := &PriorityQueue{Port: 8080, Priority: 1, Requests: make(chan *workRequest, 10)}
+	qm := &QueueManager{Queues: []*PriorityQueue{queue}, OpenAIClient: mockClient}
+
+	testReq, _ := http.NewRequest("POST", "http://example.com/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4","stream":true}`))
+	recorder := httptest.NewRecorder()
+	workReq := &workRequest{
+		Request:        testReq,
+		ResponseWriter: recorder,
+		Done:           make(chan struct{}),
+		Model:          "gpt-4",
+	}
+	go qm.processRequest(workReq, queue)
+
+	select {
+	case <-workReq.Done:
+	case <-time.After(time.Second):
+		t.Fatal("Request did not finish after the upstream stream broke")
+	}
+
+	// Whatever was sent before the break reaches the client; it isn't replayed
+	want := first + `data: {"ch`
+	if recorder.Body.String() != want {
+		t.Errorf("Expected %q, got %q", want, recorder.Body.String())
+	}
+	if len(queue.Requests) != 0 || mockClient.CallCount != 1 {
+		t.Errorf("Expected one upstream call and no requeue, got %d calls", mockClient.CallCount)
+	}
+}
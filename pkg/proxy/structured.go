@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"sync"
+
+	"github.com/mule-ai/proxy/pkg/jsonschema"
+)
+
+// Errors ending the copy of a response that violated its schema
+var (
+	errSchemaViolation = errors.New("response violates its schema")
+	errSchemaRetry     = errors.New("response violates its schema, retrying")
+)
+
+// StructuredOutputs checks the responses of chat requests asking for
+// json_schema output against the schema they gave, so clients get either
+// output that fits it or a well-defined error. Only complete responses are
+// checked: a stream has reached the client by the time it could be. A
+// response that violates its schema is retried once when Retry is set and
+// the request can be replayed, then answered with a schema_validation_error.
+// Refusals and tool calls aren't checked, nor are requests whose schema
+// can't be compiled, which the upstream will reject anyway.
+type StructuredOutputs struct {
+	Retry bool // Retry a response that violates its schema once
+
+	mu    sync.Mutex
+	stats map[string]*SchemaStats // By model
+}
+
+// SchemaStats counts a model's checked responses
+type SchemaStats struct {
+	Checked     int64   `json:"checked"`  // Responses checked, over all attempts
+	Invalid     int64   `json:"invalid"`  // Responses that violated their schema
+	Retried     int64   `json:"retried"`  // Requests retried for a violation
+	Rejected    int64   `json:"rejected"` // Requests answered with a schema_validation_error
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// responseSchema is the schema a request's response is checked against
+type responseSchema struct {
+	Name   string
+	Schema *jsonschema.Schema
+}
+
+// schema returns the schema a chat request asks its response to follow,
+// nil when it asks for none or the response isn't checked
+func (s *StructuredOutputs) schema(r *http.Request, body []byte) *responseSchema {
+	if s == nil || r.Method != "POST" || r.URL.Path != "/v1/chat/completions" || len(body) == 0 {
+		return nil
+	}
+	var request struct {
+		Stream         bool `json:"stream"`
+		ResponseFormat struct {
+			Type       string `json:"type"`
+			JSONSchema struct {
+				Name   string          `json:"name"`
+				Schema json.RawMessage `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	// A stream has reached its client before it could be checked
+	if json.Unmarshal(body, &request) != nil || request.Stream || request.ResponseFormat.Type != "json_schema" {
+		return nil
+	}
+	format := request.ResponseFormat.JSONSchema
+	if len(format.Schema) == 0 {
+		return nil
+	}
+	schema, err := jsonschema.Compile(format.Schema)
+	if err != nil {
+		fmt.Printf("Not checking responses against schema %q: %v\n", format.Name, err)
+		return nil
+	}
+	return &responseSchema{Name: format.Name, Schema: schema}
+}
+
+// record counts a checked response of model
+func (s *StructuredOutputs) record(model string, valid, retried bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[string]*SchemaStats)
+	}
+	stats := s.stats[model]
+	if stats == nil {
+		stats = &SchemaStats{}
+		s.stats[model] = stats
+	}
+	stats.Checked++
+	switch {
+	case valid:
+	case retried:
+		stats.Invalid++
+		stats.Retried++
+	default:
+		stats.Invalid++
+		stats.Rejected++
+	}
+}
+
+// Stats returns the counts of each model's checked responses
+func (s *StructuredOutputs) Stats() map[string]SchemaStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make(map[string]SchemaStats, len(s.stats))
+	for model, counts := range s.stats {
+		st := *counts
+		st.FailureRate = float64(st.Invalid) / float64(st.Checked)
+		stats[model] = st
+	}
+	return stats
+}
+
+// schemaWriter holds a complete response until finish has checked it
+// against its schema. Headers are kept apart until then too, so a retry
+// leaves no trace of the response it replaces.
+type schemaWriter struct {
+	next    http.ResponseWriter // Where a response that fits is written, e.g. the output scanner
+	client  http.ResponseWriter // Where a violation is reported
+	req     *workRequest
+	header  http.Header
+	status  int
+	buf     bytes.Buffer
+	passed  bool
+	checked bool // The response was checked, being a success
+}
+
+// newSchemaWriter checks what req's response writes to next
+func newSchemaWriter(next http.ResponseWriter, req *workRequest) *schemaWriter {
+	return &schemaWriter{next: next, client: req.ResponseWriter, req: req, header: make(http.Header)}
+}
+
+// Header implements http.ResponseWriter
+func (w *schemaWriter) Header() http.Header {
+	if w.passed {
+		// Trailers are set once the response has been written
+		return w.next.Header()
+	}
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *schemaWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *schemaWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.buf.Write(p)
+}
+
+// violation returns how a response's generated content violates its
+// schema, nil when it fits or isn't checked
+func (w *schemaWriter) violation() error {
+	w.checked = w.status >= 200 && w.status < 300
+	if !w.checked {
+		return nil
+	}
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content *string `json:"content"`
+				Refusal string  `json:"refusal"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(w.buf.Bytes(), &completion) != nil {
+		return nil
+	}
+	for _, choice := range completion.Choices {
+		if choice.Message.Content == nil || choice.Message.Refusal != "" {
+			continue
+		}
+		if err := w.req.Schema.Schema.Validate([]byte(*choice.Message.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish writes a response that fits its schema on to next. One that
+// doesn't is left unwritten for the request to be sent again if retry
+// allows it, and otherwise answered with a schema_validation_error; either
+// way finish returns an error wrapping errSchemaRetry or errSchemaViolation.
+func (w *schemaWriter) finish(structured *StructuredOutputs, sampler *LogSampler, retry func() bool) error {
+	if violation := w.violation(); violation != nil {
+		retrying := retry()
+		structured.record(w.req.Model, false, retrying)
+		sampler.logf(logError, "Response to request %s for model %s violates schema %q: %v\n",
+			w.req.requestID(), w.req.Model, w.req.Schema.Name, violation)
+		if retrying {
+			return fmt.Errorf("%w: %v", errSchemaRetry, violation)
+		}
+		writeJSON(w.client, http.StatusBadGateway, map[string]any{"error": schemaError(w.req.Schema.Name, violation)})
+		return fmt.Errorf("%w: %v", errSchemaViolation, violation)
+	}
+	if w.checked {
+		structured.record(w.req.Model, true, false)
+	}
+
+	maps.Copy(w.next.Header(), w.header)
+	w.passed = true
+	w.next.WriteHeader(w.status)
+	_, err := w.next.Write(w.buf.Bytes())
+	return err
+}
+
+// schemaError is the structured error a response violating its schema is
+// answered with
+func schemaError(name string, violation error) map[string]any {
+	path := ""
+	var ve *jsonschema.ValidationError
+	if errors.As(violation, &ve) {
+		path = ve.Path
+	}
+	return map[string]any{
+		"message": fmt.Sprintf("The model's response does not match schema %q: %v", name, violation),
+		"type":    "schema_validation_error",
+		"param":   nil,
+		"code":    "response_schema_mismatch",
+		"path":    path,
+	}
+}
+
+// structuredStatus reports how often each model's responses violated their schema
+func (h *AdminHandler) structuredStatus(w http.ResponseWriter, r *http.Request) {
+	if h.QueueManager.Structured == nil {
+		writeError(w, http.StatusNotFound, "Structured output validation is not enabled")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.QueueManager.Structured.Stats())
+}
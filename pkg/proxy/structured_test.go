@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestStructuredOutputs(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The upstream answers with the contents the test queues, recording the
+	// bodies it was sent
+	var contents, bodies []string
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		data, _ := io.ReadAll(body)
+		bodies = append(bodies, string(data))
+		content, _ := json.Marshal(contents[0])
+		contents = contents[1:]
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}, "X-Attempt": {"1"}},
+			Body:       io.NopCloser(strings.NewReader(`{"choices":[{"message":{"role":"assistant","content":` + string(content) + `}}]}`)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	qm.Structured = &StructuredOutputs{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+
+	request := `{"model":"gpt-4o","messages":[],"response_format":{"type":"json_schema","json_schema":{"name":"person",` +
+		`"schema":{"type":"object","properties":{"age":{"type":"integer"}},"required":["age"]}}}}`
+	send := func(body string, replies ...string) *httptest.ResponseRecorder {
+		contents, bodies = replies, nil
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		r.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// Responses that fit their schema pass untouched
+	recorder := send(request, `{"age":36}`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `{\"age\":36}`) {
+		t.Errorf("Expected the response passed on, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Those that don't are answered with a structured error
+	recorder = send(request, `{"age":"unknown"}`)
+	var violation struct {
+		Error struct {
+			Type string `json:"type"`
+			Path string `json:"path"`
+		} `json:"error"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &violation)
+	if recorder.Code != http.StatusBadGateway || violation.Error.Type != "schema_validation_error" || violation.Error.Path != "/age" {
+		t.Errorf("Expected a schema validation error, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("X-Attempt") != "" {
+		t.Errorf("Expected none of the upstream's headers on the error, got %v", recorder.Header())
+	}
+
+	// or, retrying, sent again once with the same body
+	qm.Structured.Retry = true
+	recorder = send(request, `not json`, `{"age":7}`)
+	if recorder.Code != http.StatusOK || len(bodies) != 2 || bodies[1] != request {
+		t.Errorf("Expected the request retried with its body, got %d after %q", recorder.Code, bodies)
+	}
+	if got := recorder.Header().Values("X-Attempt"); len(got) != 1 {
+		t.Errorf("Expected only the retry's headers, got %v", got)
+	}
+	if recorder := send(request, `{}`, `{}`); recorder.Code != http.StatusBadGateway || len(bodies) != 2 {
+		t.Errorf("Expected a single retry before the error, got %d after %d attempts", recorder.Code, len(bodies))
+	}
+
+	// Requests without a schema aren't checked
+	if recorder := send(`{"model":"gpt-4o","messages":[]}`, `not json`); recorder.Code != http.StatusOK {
+		t.Errorf("Expected a request without a schema left alone, got %d", recorder.Code)
+	}
+	// nor are streams, which reach their client before they could be
+	stream := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	if schema := qm.Structured.schema(stream, []byte(`{"stream":true,`+request[1:])); schema != nil {
+		t.Errorf("Expected no schema compiled for a stream, got %q", schema.Name)
+	}
+
+	stats := qm.Structured.Stats()["gpt-4o"]
+	if stats.Checked != 6 || stats.Invalid != 4 || stats.Retried != 2 || stats.Rejected != 2 || stats.FailureRate != 4.0/6 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TagsHeader carries comma-separated key=value tags used as metrics dimensions
+const TagsHeader = "X-Proxy-Tags"
+
+// parseTags reads allowlisted tags from a request. Unknown keys and
+// malformed pairs are dropped so clients can't create arbitrary series.
+func parseTags(r *http.Request, allowed map[string]bool) map[string]string {
+	header := r.Header.Get(TagsHeader)
+	if header == "" || len(allowed) == 0 {
+		return nil
+	}
+
+	var tags map[string]string
+	for _, pair := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if !ok || value == "" || !allowed[key] {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = value
+	}
+	return tags
+}
+
+// formatTags renders tags as sorted key=value pairs for logging
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	allowed := map[string]bool{"team": true, "job": true}
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"team=search,job=eval", "job=eval,team=search"},
+		{" team = search , job=eval ", "job=eval,team=search"},
+		{"team=search,owner=alice", "team=search"},
+		{"team,job=", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		req.Header.Set(TagsHeader, tt.header)
+
+		tags := parseTags(req, allowed)
+		if got := formatTags(tags); got != tt.expected {
+			t.Errorf("parseTags(%q): expected %q, got %q", tt.header, tt.expected, got)
+		}
+	}
+
+	// Without an allowlist no tags are recorded
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	req.Header.Set(TagsHeader, "team=search")
+	if tags := parseTags(req, nil); tags != nil {
+		t.Errorf("Expected no tags without an allowlist, got %v", tags)
+	}
+}
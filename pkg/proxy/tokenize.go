@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mule-ai/proxy/pkg/tokenizer"
+)
+
+// TokenizeRequest asks for the tokens of a text under a model's encoding
+type TokenizeRequest struct {
+	Model     string `json:"model"`
+	Text      string `json:"text"`
+	ReturnIDs bool   `json:"return_ids"` // Include the token IDs in the response
+}
+
+// TokenizeResponse reports the tokens of a text
+type TokenizeResponse struct {
+	Model    string `json:"model"`
+	Encoding string `json:"encoding"`
+	Tokens   int    `json:"tokens"`
+	TokenIDs []int  `json:"token_ids,omitempty"`
+}
+
+// serveTokenize counts the tokens of a text with the local tokenizer
+func serveTokenize(w http.ResponseWriter, r *http.Request) {
+	var req TokenizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Model == "" {
+		writeError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+
+	tokens, err := tokenizer.Encode(req.Model, req.Text)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := TokenizeResponse{
+		Model:    req.Model,
+		Encoding: tokenizer.EncodingForModel(req.Model),
+		Tokens:   len(tokens),
+	}
+	if req.ReturnIDs {
+		resp.TokenIDs = tokens
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
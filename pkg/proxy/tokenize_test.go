@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenizeEndpoint(t *testing.T) {
+	client := &MockOpenAIClient{ResponseStatus: 200}
+	handler := NewRequestHandler(NewQueueManager(nil, client))
+
+	req := httptest.NewRequest("POST", "/proxy/tokenize",
+		bytes.NewBufferString(`{"model":"gpt-4","text":"hello world","return_ids":true}`))
+	req.Host = "localhost:8080"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", recorder.Code)
+	}
+
+	var resp TokenizeResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.Tokens != 2 || len(resp.TokenIDs) != 2 || resp.Encoding != "cl100k_base" {
+		t.Errorf("Unexpected tokenize response: %+v", resp)
+	}
+
+	if client.CallCount != 0 {
+		t.Errorf("Expected no upstream calls, got %d", client.CallCount)
+	}
+
+	// Token IDs are only returned on request
+	req = httptest.NewRequest("POST", "/proxy/tokenize", bytes.NewBufferString(`{"model":"gpt-4","text":"hello world"}`))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	var countOnly TokenizeResponse
+	json.Unmarshal(recorder.Body.Bytes(), &countOnly)
+	if countOnly.Tokens != 2 || countOnly.TokenIDs != nil {
+		t.Errorf("Expected a count without token IDs, got %+v", countOnly)
+	}
+
+	// A model is required to choose the encoding
+	req = httptest.NewRequest("POST", "/proxy/tokenize", bytes.NewBufferString(`{"text":"hello"}`))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/json"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+// functionCall is the function a tool call invokes, or a legacy
+// function_call; streamed calls send their arguments in fragments
+type functionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// toolCallDelta is a fragment of a tool call in a streamed chunk, with
+// later fragments of the same call sharing its index
+type toolCallDelta struct {
+	Index    int          `json:"index"`
+	Function functionCall `json:"function"`
+}
+
+// outputItem is an item of a responses API output, of which function calls
+// are the ones looked at
+type outputItem struct {
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// toolCall returns the call an output item makes, if it is a function call
+func (item outputItem) toolCall() (metrics.ToolCall, bool) {
+	if item.Type != "function_call" {
+		return metrics.ToolCall{}, false
+	}
+	return metrics.ToolCall{Name: item.Name, ArgumentBytes: len(item.Arguments)}, true
+}
+
+// addToolCallDelta adds a streamed fragment to the call it belongs to, the
+// call numbered index of choice (-1 for a legacy function_call)
+func (t *usageTap) addToolCallDelta(choice, index int, fn functionCall) {
+	key := [2]int{choice, index}
+	i, ok := t.toolIndex[key]
+	if !ok {
+		if t.toolIndex == nil {
+			t.toolIndex = make(map[[2]int]int)
+		}
+		i = len(t.toolCalls)
+		t.toolIndex[key] = i
+		t.toolCalls = append(t.toolCalls, metrics.ToolCall{})
+	}
+	if fn.Name != "" {
+		t.toolCalls[i].Name = fn.Name
+	}
+	t.toolCalls[i].ArgumentBytes += len(fn.Arguments)
+}
+
+// ToolCalls returns the tools and functions the response invoked, once the
+// body has been read to the end. Calls in streams are put together from
+// their fragments.
+func (t *usageTap) ToolCalls() []metrics.ToolCall {
+	if t.stream || t.overflow {
+		return t.toolCalls
+	}
+
+	var doc struct {
+		Choices []struct {
+			Message struct {
+				ToolCalls []struct {
+					Function functionCall `json:"function"`
+				} `json:"tool_calls"`
+				FunctionCall *functionCall `json:"function_call"`
+			} `json:"message"`
+		} `json:"choices"`
+		Output []outputItem `json:"output"`
+	}
+	if json.Unmarshal(t.buf, &doc) != nil {
+		return nil
+	}
+	var calls []metrics.ToolCall
+	for _, choice := range doc.Choices {
+		for _, call := range choice.Message.ToolCalls {
+			calls = append(calls, metrics.ToolCall{Name: call.Function.Name, ArgumentBytes: len(call.Function.Arguments)})
+		}
+		if fn := choice.Message.FunctionCall; fn != nil {
+			calls = append(calls, metrics.ToolCall{Name: fn.Name, ArgumentBytes: len(fn.Arguments)})
+		}
+	}
+	for _, item := range doc.Output {
+		if call, ok := item.toolCall(); ok {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestUsageTapToolCalls(t *testing.T) {
+	body := `{"choices":[{"message":{"tool_calls":[` +
+		`{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},` +
+		`{"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]}}]}`
+	want := []metrics.ToolCall{{Name: "get_weather", ArgumentBytes: 16}, {Name: "get_time", ArgumentBytes: 2}}
+	if got := readTap(body, false).ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v from JSON body, got %v", want, got)
+	}
+
+	// Streamed arguments arrive in fragments that add up to the whole call
+	stream := "data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\"\"}}]}}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":\\\"Paris\\\"}\"}}]}}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+		"data: [DONE]\n\n"
+	if got := readTap(stream, true).ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v from stream, got %v", want, got)
+	}
+
+	// Legacy function calls are counted too
+	stream = "data: {\"choices\":[{\"delta\":{\"function_call\":{\"name\":\"lookup\",\"arguments\":\"{\\\"q\\\":\"}}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"function_call\":{\"arguments\":\"1}\"}}}]}\n\n"
+	want = []metrics.ToolCall{{Name: "lookup", ArgumentBytes: 7}}
+	if got := readTap(stream, true).ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v from a function_call stream, got %v", want, got)
+	}
+
+	// So are the responses API's function call output items
+	body = `{"object":"response","output":[{"type":"reasoning"},{"type":"function_call","name":"get_weather","arguments":"{\"city\":\"Paris\"}"}]}`
+	want = []metrics.ToolCall{{Name: "get_weather", ArgumentBytes: 16}}
+	if got := readTap(body, false).ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v from a response, got %v", want, got)
+	}
+	stream = "event: response.function_call_arguments.delta\ndata: {\"type\":\"response.function_call_arguments.delta\",\"delta\":\"{\\\"city\\\"\"}\n\n" +
+		"event: response.output_item.done\ndata: {\"type\":\"response.output_item.done\",\"item\":{\"type\":\"function_call\",\"name\":\"get_weather\",\"arguments\":\"{\\\"city\\\":\\\"Paris\\\"}\"}}\n\n"
+	if got := readTap(stream, true).ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v from a response stream, got %v", want, got)
+	}
+
+	if got := readTap(`{"choices":[{"message":{"content":"Hi"}}]}`, false).ToolCalls(); got != nil {
+		t.Errorf("Expected no tool calls from a text response, got %v", got)
+	}
+}
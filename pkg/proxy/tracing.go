@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the proxy's spans in the traces it exports
+const tracerName = "github.com/mule-ai/proxy"
+
+// TracingHandler records every request it serves as a server span,
+// continuing any trace the client started. Spans go to the global tracer
+// provider, so they are dropped unless tracing is set up.
+type TracingHandler struct {
+	next http.Handler
+}
+
+// NewTracingHandler traces the requests next serves
+func NewTracingHandler(next http.Handler) *TracingHandler {
+	return &TracingHandler{next: next}
+}
+
+// ServeHTTP implements http.Handler
+func (h *TracingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := otel.Tracer(tracerName).Start(ctx, r.Method+" "+r.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		))
+	defer span.End()
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	h.next.ServeHTTP(recorder, r.WithContext(ctx))
+
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// annotateSpan adds what the proxy learns about a request to its server span
+func annotateSpan(r *http.Request, model string, priority int) {
+	span := trace.SpanFromContext(r.Context())
+	if !span.IsRecording() {
+		return
+	}
+	if model != "" {
+		span.SetAttributes(attribute.String("gen_ai.request.model", model))
+	}
+	span.SetAttributes(attribute.Int("proxy.priority", priority))
+}
+
+// startUpstreamSpan starts a client span for one attempt at sending a
+// request upstream, the parent of the trace context sent with it. The
+// request's tenant is left out when it has none.
+func startUpstreamSpan(ctx context.Context, req *workRequest, tenant string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.Int("proxy.attempt", req.RetryCount+1),
+		attribute.Int("proxy.priority", req.Priority),
+		attribute.Int64("proxy.queue_wait_ms", req.QueueWait.Milliseconds()),
+		attribute.String("proxy.key_id", req.KeyID),
+	}
+	if tenant != "" {
+		attrs = append(attrs, attribute.String("proxy.tenant", tenant))
+	}
+	return otel.Tracer(tracerName).Start(ctx, "upstream "+req.Request.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+// endUpstreamSpan ends an upstream attempt's span once its response starts
+func endUpstreamSpan(span trace.Span, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp != nil:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
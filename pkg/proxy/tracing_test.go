@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+)
+
+func TestTracingHandler(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	}()
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, &MockOpenAIClient{
+		ResponseBody:   `{"id":"test-response"}`,
+		ResponseStatus: http.StatusOK,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	// The client's trace is continued
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"gpt-4"}`))
+	req.Host = "localhost:8080"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	recorder := httptest.NewRecorder()
+	NewTracingHandler(NewRequestHandler(qm)).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", recorder.Code)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a server and an upstream span, got %d", len(spans))
+	}
+	upstream, server := spans[0], spans[1]
+	if server.SpanContext.TraceID().String() != traceID {
+		t.Errorf("Expected the client's trace continued, got trace %s", server.SpanContext.TraceID())
+	}
+	if upstream.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("Expected the upstream span to be a child of the server span")
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range server.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["gen_ai.request.model"].AsString() != "gpt-4" || attrs["http.response.status_code"].AsInt64() != http.StatusOK {
+		t.Errorf("Expected model and status on the server span, got %v", server.Attributes)
+	}
+}
+
+func TestIdentityPropagation(t *testing.T) {
+	p := &IdentityPropagation{
+		Baggage:   true,
+		TenantTag: "team",
+		Headers:   map[string]string{IdentityTenant: "X-Tenant-Id", IdentityKeyID: "X-Key-Id"},
+	}
+	req := &workRequest{KeyID: "sub:acme", Priority: 2, Tags: map[string]string{"team": "search"}}
+	shared := http.Header{"Openai-Beta": {"assistants=v2"}}
+
+	ctx, header := p.propagate(context.Background(), req, shared)
+	if header.Get("X-Tenant-Id") != "search" || header.Get("X-Key-Id") != "sub:acme" || header.Get("Openai-Beta") != "assistants=v2" {
+		t.Errorf("Expected the identity headers added, got %v", header)
+	}
+	if len(shared) != 1 {
+		t.Errorf("Expected the shared headers left alone, got %v", shared)
+	}
+	bag := baggage.FromContext(ctx)
+	if bag.Member("tenant").Value() != "search" || bag.Member("key_id").Value() != "sub:acme" || bag.Member("priority").Value() != "2" {
+		t.Errorf("Expected the identifiers in the baggage, got %s", bag)
+	}
+
+	// Without the tag the JWT subject is the tenant, and keys have none
+	if tenant := p.tenant(&workRequest{KeyID: "sub:acme"}); tenant != "acme" {
+		t.Errorf("Expected the JWT subject as tenant, got %q", tenant)
+	}
+	if tenant := p.tenant(&workRequest{KeyID: KeyID("sk-test")}); tenant != "" {
+		t.Errorf("Expected no tenant for a plain key, got %q", tenant)
+	}
+}
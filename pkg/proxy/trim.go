@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/accesslog"
+	"github.com/mule-ai/proxy/pkg/openai"
+	"github.com/mule-ai/proxy/pkg/tokenizer"
+)
+
+// Context trimming strategies
+const (
+	TrimDropOldest = "drop_oldest" // Drop the oldest messages
+	TrimSummarize  = "summarize"   // Replace the oldest messages with a summary of them
+)
+
+// TrimmedHeader reports how many messages were trimmed from a conversation
+const TrimmedHeader = "X-Proxy-Context-Trimmed"
+
+// Defaults for context trimming
+const (
+	defaultSummaryTokens  = 500
+	defaultSummaryTimeout = 30 * time.Second
+)
+
+// Summaries of trimmed messages
+const (
+	summaryPrompt = "Summarize the conversation below in a few paragraphs. Keep the facts, decisions, names and open questions a reply to later messages would need."
+	summaryPrefix = "Summary of the earlier conversation: "
+)
+
+// ContextTrimmer shortens chat conversations that don't fit their model's
+// context window, so they are answered rather than failing upstream after
+// waiting their turn. The oldest messages go first. Leading system and
+// developer messages and the latest message are always kept, and an
+// assistant message is dropped together with the tool results answering its
+// calls. Conversations that can't be made to fit are left for admission to
+// reject.
+type ContextTrimmer struct {
+	Strategy      string        // TrimDropOldest or TrimSummarize
+	SummaryModel  string        // Model summarizing trimmed messages (default the request's model)
+	SummaryTokens int64         // Most tokens a summary takes (default 500)
+	Timeout       time.Duration // Longest a summary may take before the messages are dropped instead (default 30s)
+}
+
+// chatMessage is what trimming needs of a chat message
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+	Name    string          `json:"name"`
+}
+
+// messageTokens counts a message's tokens the way countInputTokens does
+func messageTokens(model string, msg chatMessage) (int64, error) {
+	total := int64(tokensPerMessage)
+	if msg.Name != "" {
+		total += tokensPerName
+	}
+	for _, text := range textOf(msg.Content) {
+		n, err := tokenizer.Count(model, text)
+		if err != nil {
+			return 0, err
+		}
+		total += int64(n)
+	}
+	return total, nil
+}
+
+// trimContext returns the body of a chat request bound for queue and
+// backend that doesn't fit its model's context window with its oldest
+// messages trimmed, reporting how many in TrimmedHeader
+func (h *RequestHandler) trimContext(w http.ResponseWriter, r *http.Request, queue *PriorityQueue, backend string, body []byte, model string) []byte {
+	t := h.Trim
+	if t == nil || model == "" || r.Method != "POST" || r.URL.Path != "/v1/chat/completions" {
+		return body
+	}
+	price, _ := h.Pricing.Lookup(model)
+	if price.ContextWindow == 0 {
+		return body
+	}
+
+	var request map[string]json.RawMessage
+	var est estimateBody
+	var raw []json.RawMessage
+	if json.Unmarshal(body, &request) != nil || json.Unmarshal(body, &est) != nil || json.Unmarshal(request["messages"], &raw) != nil {
+		return body
+	}
+	messages := make([]chatMessage, len(raw))
+	tokens := make([]int64, len(raw))
+	total := int64(tokensPerReply)
+	for i := range raw {
+		if json.Unmarshal(raw[i], &messages[i]) != nil {
+			return body
+		}
+		n, err := messageTokens(model, messages[i])
+		if err != nil {
+			return body
+		}
+		tokens[i], total = n, total+n
+	}
+	budget := price.ContextWindow - est.maxOutput()
+	if total <= budget {
+		return body
+	}
+
+	// Leading instructions stay, and so does the message being answered
+	first := 0
+	for first < len(messages) && (messages[first].Role == "system" || messages[first].Role == "developer") {
+		first++
+	}
+	if t.Strategy == TrimSummarize {
+		// Leave room for the summary
+		prefix, _ := tokenizer.Count(model, summaryPrefix)
+		total += cmp.Or(t.SummaryTokens, defaultSummaryTokens) + int64(prefix) + tokensPerMessage
+	}
+	end := first
+	for total > budget && end < len(messages)-1 {
+		total -= tokens[end]
+		end++
+		// Tool results can't outlive the call they answer
+		for end < len(messages)-1 && messages[end].Role == "tool" {
+			total -= tokens[end]
+			end++
+		}
+	}
+	if total > budget || end == first || messages[end].Role == "tool" {
+		return body
+	}
+
+	kept := append(raw[:first:first], raw[end:]...)
+	if t.Strategy == TrimSummarize {
+		summary, err := h.summarize(r, queue, backend, model, messages[first:end])
+		if err != nil {
+			fmt.Printf("Summarizing %d trimmed messages for model %s failed, dropping them: %v\n", end-first, model, err)
+		} else {
+			encoded, _ := json.Marshal(map[string]string{"role": "system", "content": summaryPrefix + summary})
+			kept = append(raw[:first:first], append([]json.RawMessage{encoded}, raw[end:]...)...)
+		}
+	}
+
+	request["messages"], _ = json.Marshal(kept)
+	rewritten, err := json.Marshal(request)
+	if err != nil {
+		return body
+	}
+	fmt.Printf("Trimmed %d of %d messages from a request for model %s to fit its %d token context window\n",
+		end-first, len(messages), model, price.ContextWindow)
+	w.Header().Set(TrimmedHeader, strconv.Itoa(end-first))
+	return rewritten
+}
+
+// summarize has the upstream summarize messages. The summary is queued
+// like the request it is for: it waits its turn on queue, runs on the
+// backend the request was routed to, and is recorded and charged to the
+// client's key as a request of its own.
+func (h *RequestHandler) summarize(r *http.Request, queue *PriorityQueue, backend, model string, messages []chatMessage) (string, error) {
+	t := h.Trim
+	var transcript strings.Builder
+	for _, msg := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, strings.Join(textOf(msg.Content), "\n"))
+	}
+	body, err := json.Marshal(map[string]any{
+		"model": cmp.Or(t.SummaryModel, model),
+		"messages": []map[string]string{
+			{"role": "system", "content": summaryPrompt},
+			{"role": "user", "content": transcript.String()},
+		},
+		"max_tokens": cmp.Or(t.SummaryTokens, defaultSummaryTokens),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cmp.Or(t.Timeout, defaultSummaryTimeout))
+	defer cancel()
+	// The summary isn't the client's request, so it isn't access logged as it
+	ctx = context.WithValue(ctx, accessKey{}, (*accesslog.Entry)(nil))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if id := requestID(r); id != "" {
+		httpReq.Header.Set(RequestIDHeader, id+"-summary")
+	}
+
+	buf := newResponseBuffer()
+	req := &workRequest{
+		Request:        httpReq,
+		ResponseWriter: buf,
+		Done:           make(chan struct{}),
+		StartTime:      time.Now(),
+		Tags:           parseTags(r, h.TagKeys),
+		KeyID:          clientKeyID(r),
+		Backend:        backend,
+		BodySize:       int64(len(body)),
+	}
+	req.Model, req.InputTokens, _, _ = openai.ExtractRequestMetadata(bytes.NewReader(body))
+	if err := h.QueueManager.enqueue(queue, req); err != nil {
+		return "", err
+	}
+	<-req.Done
+	if buf.status != http.StatusOK {
+		return "", fmt.Errorf("upstream answered %d", buf.status)
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(buf.body.Bytes(), &completion); err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message.Content == "" {
+		return "", errors.New("empty summary")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
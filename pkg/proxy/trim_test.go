@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/pricing"
+)
+
+func TestContextTrimmer(t *testing.T) {
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	// The upstream summarizes when asked to and records the other requests
+	var sent struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	var summaries int
+	client := &MockOpenAIClient{}
+	client.CustomForwarder = func(_ context.Context, method, path string, body io.Reader) (*http.Response, error) {
+		data, _ := io.ReadAll(body)
+		respBody := `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`
+		if strings.Contains(string(data), summaryPrompt) {
+			summaries++
+			respBody = `{"choices":[{"message":{"role":"assistant","content":"The user asked about lorem."}}]}`
+		} else {
+			json.Unmarshal(data, &sent)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(respBody)),
+		}, nil
+	}
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+	handler := NewRequestHandler(qm)
+	handler.Pricing = pricing.NewTable(map[string]config.ModelPrice{"gpt-4o": {ContextWindow: 150}})
+	handler.Trim = &ContextTrimmer{Strategy: TrimDropOldest, SummaryTokens: 20}
+
+	long := strings.Repeat("lorem ", 80)
+	send := func(messages string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","max_tokens":10,"messages":[`+messages+`]}`))
+		r.Host = "localhost:8080"
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder
+	}
+	roles := func() string {
+		var roles []string
+		for _, msg := range sent.Messages {
+			roles = append(roles, msg.Role)
+		}
+		return strings.Join(roles, ",")
+	}
+	conversation := `{"role":"system","content":"Be brief."},{"role":"user","content":"` + long + `"},` +
+		`{"role":"assistant","content":"` + long + `"},{"role":"user","content":"And now?"}`
+
+	// The oldest messages are dropped until the conversation fits, keeping the instructions
+	recorder := send(conversation)
+	if recorder.Code != http.StatusOK || recorder.Header().Get(TrimmedHeader) != "1" || roles() != "system,assistant,user" {
+		t.Errorf("Expected the oldest message dropped, got %d %q %s", recorder.Code, recorder.Header().Get(TrimmedHeader), roles())
+	}
+
+	// Tool results go with the call they answer
+	recorder = send(`{"role":"user","content":"hi"},` +
+		`{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{}"}}]},` +
+		`{"role":"tool","tool_call_id":"call_1","content":"` + long + `"},` +
+		`{"role":"assistant","content":"` + long + `"},{"role":"user","content":"And now?"}`)
+	if recorder.Header().Get(TrimmedHeader) != "3" || roles() != "assistant,user" {
+		t.Errorf("Expected the tool call dropped with its result, got %q %s", recorder.Header().Get(TrimmedHeader), roles())
+	}
+
+	// Conversations that fit are left alone
+	if recorder := send(`{"role":"user","content":"hi"}`); recorder.Header().Get(TrimmedHeader) != "" || roles() != "user" {
+		t.Errorf("Expected a short conversation left alone, got %q %s", recorder.Header().Get(TrimmedHeader), roles())
+	}
+
+	// and those that can't be made to fit are rejected as before
+	recorder = send(`{"role":"user","content":"` + long + long + `"}`)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "context_length_exceeded") {
+		t.Errorf("Expected an unfittable conversation rejected, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Summarizing replaces the dropped messages with a summary of them
+	handler.Trim.Strategy = TrimSummarize
+	sink := &outcomeRecorder{}
+	qm.Outcomes = []OutcomeSink{sink}
+	recorder = send(conversation)
+	if summaries != 1 || roles() != "system,system,assistant,user" || sent.Messages[1].Content != summaryPrefix+"The user asked about lorem." {
+		t.Errorf("Expected the dropped message summarized, got %d summaries and %+v", summaries, sent.Messages)
+	}
+	// as a request of its own, queued and recorded like the one it is for
+	if len(sink.outcomes) != 2 || sink.outcomes[0].Model != "gpt-4o" || sink.outcomes[0].InputTokens == 0 {
+		t.Errorf("Expected the summary recorded before the request, got %+v", sink.outcomes)
+	}
+}
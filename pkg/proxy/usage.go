@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/tokenizer"
+)
+
+// maxUsageBuffer bounds how much of a response is held to find its usage report
+const maxUsageBuffer = 1 << 20
+
+// tokenUsage is the usage report OpenAI includes in responses
+type tokenUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	InputTokens      int64 `json:"input_tokens"`  // As named by the responses API
+	OutputTokens     int64 `json:"output_tokens"` // As named by the responses API
+}
+
+// usageTap watches a response body stream past and picks out its usage
+// report: the top-level "usage" of a JSON body, or of the last SSE event
+// that carries one (sent when stream_options.include_usage is set, or with
+// the responses API's response.completed). For streams it also collects the
+// generated text, to account for responses that are cut off before their
+// usage report.
+type usageTap struct {
+	io.ReadCloser
+	stream     bool
+	buf        []byte
+	overflow   bool
+	usage      *tokenUsage
+	content    strings.Builder // Generated text, up to maxUsageBuffer
+	contentLen int64           // Characters of generated text
+	toolCalls  []metrics.ToolCall
+	toolIndex  map[[2]int]int // Position in toolCalls of each streamed choice's calls
+}
+
+// newUsageTap wraps a response body
+func newUsageTap(body io.ReadCloser, stream bool) *usageTap {
+	return &usageTap{ReadCloser: body, stream: stream}
+}
+
+// Read implements io.Reader
+func (t *usageTap) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.observe(p[:n])
+	}
+	return n, err
+}
+
+// observe buffers response bytes, parsing complete SSE lines as they arrive
+func (t *usageTap) observe(p []byte) {
+	if t.overflow {
+		if !t.stream {
+			return
+		}
+		// Skip the rest of an oversized line
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			return
+		}
+		p = p[i+1:]
+		t.overflow = false
+	}
+
+	t.buf = append(t.buf, p...)
+	if t.stream {
+		for {
+			i := bytes.IndexByte(t.buf, '\n')
+			if i < 0 {
+				break
+			}
+			t.parseEvent(t.buf[:i])
+			t.buf = t.buf[i+1:]
+		}
+	}
+
+	if len(t.buf) > maxUsageBuffer {
+		t.buf = nil
+		t.overflow = true
+	}
+}
+
+// parseEvent records the usage and generated text carried by an SSE data line
+func (t *usageTap) parseEvent(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.Contains(data, []byte(`"choices"`)) {
+		t.parseChoices(data)
+	}
+	if bytes.Contains(data, []byte(`"response.`)) {
+		t.parseResponseEvent(data)
+	}
+	if bytes.Contains(data, []byte(`"thread.message.delta"`)) {
+		t.parseMessageDelta(data)
+	}
+	if bytes.Contains(data, []byte(`"usage"`)) {
+		t.parse(data)
+	}
+}
+
+// parseChoices records the text and tool calls a chat or completion chunk adds
+func (t *usageTap) parseChoices(data []byte) {
+	var chunk struct {
+		Choices []struct {
+			Index int `json:"index"`
+			Delta struct {
+				Content      string          `json:"content"`
+				ToolCalls    []toolCallDelta `json:"tool_calls"`
+				FunctionCall *functionCall   `json:"function_call"`
+			} `json:"delta"`
+			Text string `json:"text"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(data, &chunk) != nil {
+		return
+	}
+	for _, choice := range chunk.Choices {
+		for _, call := range choice.Delta.ToolCalls {
+			t.addToolCallDelta(choice.Index, call.Index, call.Function)
+		}
+		if choice.Delta.FunctionCall != nil {
+			t.addToolCallDelta(choice.Index, -1, *choice.Delta.FunctionCall)
+		}
+		t.addContent(choice.Delta.Content)
+		t.addContent(choice.Text)
+	}
+}
+
+// parseResponseEvent records the text and function calls a responses API
+// stream event adds
+func (t *usageTap) parseResponseEvent(data []byte) {
+	var event struct {
+		Type  string     `json:"type"`
+		Delta string     `json:"delta"`
+		Item  outputItem `json:"item"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return
+	}
+	switch event.Type {
+	case "response.output_text.delta", "response.refusal.delta":
+		t.addContent(event.Delta)
+	case "response.output_item.done":
+		if call, ok := event.Item.toolCall(); ok {
+			t.toolCalls = append(t.toolCalls, call)
+		}
+	}
+}
+
+// parseMessageDelta records the text an assistants run stream adds to a message
+func (t *usageTap) parseMessageDelta(data []byte) {
+	var event struct {
+		Delta struct {
+			Content []struct {
+				Text struct {
+					Value string `json:"value"`
+				} `json:"text"`
+			} `json:"content"`
+		} `json:"delta"`
+	}
+	if json.Unmarshal(data, &event) != nil {
+		return
+	}
+	for _, part := range event.Delta.Content {
+		t.addContent(part.Text.Value)
+	}
+}
+
+// addContent records generated text
+func (t *usageTap) addContent(text string) {
+	t.contentLen += int64(utf8.RuneCountInString(text))
+	if t.content.Len()+len(text) <= maxUsageBuffer {
+		t.content.WriteString(text)
+	}
+}
+
+// parse records the top-level usage of a JSON document, or of the response
+// a responses API event carries, if it has one
+func (t *usageTap) parse(data []byte) {
+	var doc struct {
+		Usage    *tokenUsage `json:"usage"`
+		Response struct {
+			Usage *tokenUsage `json:"usage"`
+		} `json:"response"`
+	}
+	if json.Unmarshal(data, &doc) != nil {
+		return
+	}
+	if doc.Usage != nil {
+		t.usage = doc.Usage
+	} else if doc.Response.Usage != nil {
+		t.usage = doc.Response.Usage
+	}
+}
+
+// Usage returns the input and output tokens the upstream reported, once
+// the body has been read to the end
+func (t *usageTap) Usage() (int64, int64, bool) {
+	if !t.stream && !t.overflow && t.usage == nil {
+		t.parse(t.buf)
+	}
+	if t.usage == nil {
+		return 0, 0, false
+	}
+	if t.usage.PromptTokens == 0 && t.usage.CompletionTokens == 0 {
+		return t.usage.InputTokens, t.usage.OutputTokens, true
+	}
+	return t.usage.PromptTokens, t.usage.CompletionTokens, true
+}
+
+// Generated returns the text a stream has generated so far and its length
+// in characters; the text is cut short for very long responses
+func (t *usageTap) Generated() (string, int64) {
+	return t.content.String(), t.contentLen
+}
+
+// partialTokens counts the output tokens of a stream that was cut off before its usage report
+func partialTokens(model string, tap *usageTap) int64 {
+	text, _ := tap.Generated()
+	n, err := tokenizer.Count(model, text)
+	if err != nil {
+		return 0
+	}
+	return int64(n)
+}
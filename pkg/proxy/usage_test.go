@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// readTap reads a body through a usage tap in small chunks
+func readTap(body string, stream bool) *usageTap {
+	tap := newUsageTap(io.NopCloser(strings.NewReader(body)), stream)
+	buf := make([]byte, 7)
+	for {
+		if _, err := tap.Read(buf); err != nil {
+			return tap
+		}
+	}
+}
+
+func TestUsageTap(t *testing.T) {
+	tap := readTap(`{"id":"chatcmpl-1","usage":{"prompt_tokens":12,"completion_tokens":34}}`, false)
+	if in, out, ok := tap.Usage(); !ok || in != 12 || out != 34 {
+		t.Errorf("Expected usage 12/34 from JSON body, got %d/%d (found: %v)", in, out, ok)
+	}
+
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}],\"usage\":null}\n\n" +
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":7}}\n\n" +
+		"data: [DONE]\n\n"
+	tap = readTap(stream, true)
+	if in, out, ok := tap.Usage(); !ok || in != 5 || out != 7 {
+		t.Errorf("Expected usage 5/7 from stream, got %d/%d (found: %v)", in, out, ok)
+	}
+
+	tap = readTap("data: {\"choices\":[]}\n\ndata: [DONE]\n\n", true)
+	if _, _, ok := tap.Usage(); ok {
+		t.Error("Expected no usage from a stream without a usage event")
+	}
+}
+
+func TestUsageTapResponses(t *testing.T) {
+	tap := readTap(`{"id":"resp_1","object":"response","usage":{"input_tokens":12,"output_tokens":34,"total_tokens":46}}`, false)
+	if in, out, ok := tap.Usage(); !ok || in != 12 || out != 34 {
+		t.Errorf("Expected usage 12/34 from a response, got %d/%d (found: %v)", in, out, ok)
+	}
+
+	stream := "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"usage\":null}}\n\n" +
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hel\"}\n\n" +
+		"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"lo\"}\n\n" +
+		"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"usage\":{\"input_tokens\":5,\"output_tokens\":7}}}\n\n"
+	tap = readTap(stream, true)
+	if in, out, ok := tap.Usage(); !ok || in != 5 || out != 7 {
+		t.Errorf("Expected usage 5/7 from a response stream, got %d/%d (found: %v)", in, out, ok)
+	}
+	if text, n := tap.Generated(); text != "Hello" || n != 5 {
+		t.Errorf("Expected the streamed text collected, got %q (%d)", text, n)
+	}
+}
+
+func TestUsageTapRunStream(t *testing.T) {
+	stream := "event: thread.message.delta\ndata: {\"id\":\"msg_1\",\"object\":\"thread.message.delta\",\"delta\":{\"content\":[{\"index\":0,\"type\":\"text\",\"text\":{\"value\":\"Hi\"}}]}}\n\n" +
+		"event: thread.run.completed\ndata: {\"id\":\"run_1\",\"object\":\"thread.run\",\"thread_id\":\"thread_1\",\"usage\":{\"prompt_tokens\":9,\"completion_tokens\":1}}\n\n" +
+		"event: done\ndata: [DONE]\n\n"
+	tap := readTap(stream, true)
+	if in, out, ok := tap.Usage(); !ok || in != 9 || out != 1 {
+		t.Errorf("Expected usage 9/1 from a run stream, got %d/%d (found: %v)", in, out, ok)
+	}
+	if text, _ := tap.Generated(); text != "Hi" {
+		t.Errorf("Expected the message delta collected, got %q", text)
+	}
+}
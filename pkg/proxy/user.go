@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+// userFieldPaths are the endpoints whose request bodies accept a `user` field
+var userFieldPaths = map[string]bool{
+	"/v1/chat/completions":   true,
+	"/v1/completions":        true,
+	"/v1/embeddings":         true,
+	"/v1/images/generations": true,
+	"/v1/responses":          true,
+}
+
+// attributeUser returns the end user a request is made on behalf of. When
+// the client didn't send one and injection is enabled, the client's key ID
+// is written into the body so upstream abuse reports map back to a key.
+func (h *RequestHandler) attributeUser(r *http.Request, body []byte) ([]byte, string) {
+	if user := openai.ExtractUser(body); user != "" {
+		return body, user
+	}
+
+	if !h.InjectUser || r.Method != "POST" || !userFieldPaths[r.URL.Path] {
+		return body, ""
+	}
+
+	user := clientKeyID(r)
+	injected, err := openai.InjectUser(body, user)
+	if err != nil {
+		// Not a JSON object; leave it for upstream to reject
+		return body, ""
+	}
+	return injected, user
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/openai"
+)
+
+func TestHandlerInjectUser(t *testing.T) {
+	// Initialize metrics collector
+	metrics.NewMetricsCollector("", "test-token", "test-org", "test-bucket")
+
+	upstream := make(chan []byte, 1)
+	client := &MockOpenAIClient{
+		CustomForwarder: func(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+			data, _ := io.ReadAll(body)
+			upstream <- data
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"id":"test-response"}`)),
+				Header:     make(http.Header),
+			}, nil
+		},
+	}
+
+	qm := NewQueueManager([]config.Endpoint{{Port: 8080, Priority: 1, Preemptive: true}}, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go qm.StartScheduler(ctx)
+
+	handler := NewRequestHandler(qm)
+	handler.InjectUser = true
+
+	send := func(body string) string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		req.Host = "localhost:8080"
+		req.Header.Set("Authorization", "Bearer client-key")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return openai.ExtractUser(<-upstream)
+	}
+
+	// A missing user is filled in from the client key
+	if user := send(`{"model":"gpt-4"}`); user != KeyID("client-key") {
+		t.Errorf("Expected injected user %s, got %q", KeyID("client-key"), user)
+	}
+
+	// A user sent by the client is left alone
+	if user := send(`{"model":"gpt-4","user":"end-user-1"}`); user != "end-user-1" {
+		t.Errorf("Expected client user end-user-1, got %q", user)
+	}
+}
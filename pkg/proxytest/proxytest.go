@@ -0,0 +1,92 @@
+// Package proxytest runs the proxy in-process against a scripted fake
+// upstream, so clients of the proxy can be integration tested without an
+// upstream API key, InfluxDB or configured ports.
+//
+// A test creates a proxy, scripts the upstream and sends requests to the
+// proxy's URL:
+//
+//	p := proxytest.New(t)
+//	p.Upstream.Script(
+//		proxytest.Error(http.StatusServiceUnavailable, "overloaded"),
+//		proxytest.ChatStream(12, 3, "Hello", " there"),
+//	)
+//	resp, err := http.Post(p.URL(1)+"/v1/chat/completions", "application/json", body)
+package proxytest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/metrics"
+	"github.com/mule-ai/proxy/pkg/proxy"
+)
+
+// Proxy is a proxy serving each of its queues on a loopback listener
+type Proxy struct {
+	Upstream *Upstream
+	Queues   *proxy.QueueManager
+	// Handler can be configured further, e.g. with a rate limiter, before
+	// requests are sent
+	Handler *proxy.RequestHandler
+	servers map[int]*httptest.Server // Keyed by queue priority
+	cancel  context.CancelFunc
+}
+
+// New starts a proxy with a queue for each endpoint, defaulting to a single
+// queue with priority 1. Endpoint ports are ignored: each queue listens on
+// a port the system picks, found with URL. The proxy is closed when the
+// test ends.
+func New(t testing.TB, endpoints ...config.Endpoint) *Proxy {
+	t.Helper()
+	if len(endpoints) == 0 {
+		endpoints = []config.Endpoint{{Priority: 1}}
+	}
+
+	// Without an InfluxDB URL the collector discards metrics, so nothing is
+	// written anywhere
+	metrics.NewMetricsCollector("", "", "proxytest", "proxytest")
+
+	p := &Proxy{Upstream: NewUpstream(), servers: make(map[int]*httptest.Server, len(endpoints))}
+	endpoints = append([]config.Endpoint(nil), endpoints...)
+	for i := range endpoints {
+		server := httptest.NewUnstartedServer(nil)
+		endpoints[i].Port = server.Listener.Addr().(*net.TCPAddr).Port
+		p.servers[endpoints[i].Priority] = server
+	}
+
+	p.Queues = proxy.NewQueueManager(endpoints, p.Upstream)
+	p.Handler = proxy.NewRequestHandler(p.Queues)
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	go p.Queues.StartScheduler(ctx)
+
+	for _, server := range p.servers {
+		server.Config.Handler = p.Handler
+		server.Start()
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// URL returns the base URL of the queue with a priority, e.g.
+// "http://127.0.0.1:40123"; it panics if there is no such queue
+func (p *Proxy) URL(priority int) string {
+	server, ok := p.servers[priority]
+	if !ok {
+		panic(fmt.Sprintf("proxytest: no queue with priority %d", priority))
+	}
+	return server.URL
+}
+
+// Close stops the proxy, closing its listeners and scheduler
+func (p *Proxy) Close() {
+	for _, server := range p.servers {
+		server.Close()
+	}
+	p.cancel()
+}
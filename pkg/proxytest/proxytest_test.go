@@ -0,0 +1,86 @@
+package proxytest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func post(t *testing.T, url string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(url+"/v1/chat/completions", "application/json",
+		strings.NewReader(`{"model":"gpt-4","messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestProxyScriptedResponses(t *testing.T) {
+	p := New(t)
+	p.Upstream.Script(
+		Error(http.StatusServiceUnavailable, "overloaded"),
+		ChatCompletion("Hello", 5, 1),
+	)
+
+	if resp := post(t, p.URL(1)); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the scripted error, got %d", resp.StatusCode)
+	}
+	resp := post(t, p.URL(1))
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"content":"Hello"`) {
+		t.Errorf("Expected the scripted completion, got %d %s", resp.StatusCode, body)
+	}
+
+	// The fallback answers once the script runs out
+	if resp := post(t, p.URL(1)); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the fallback response, got %d", resp.StatusCode)
+	}
+
+	requests := p.Upstream.Requests()
+	if len(requests) != 3 || requests[0].Path != "/v1/chat/completions" || !strings.Contains(string(requests[0].Body), `"gpt-4"`) {
+		t.Errorf("Expected the upstream to record the requests, got %+v", requests)
+	}
+}
+
+func TestProxyStreaming(t *testing.T) {
+	p := New(t, config.Endpoint{Priority: 1}, config.Endpoint{Priority: 2})
+	p.Upstream.Script(ChatStream(5, 2, "Hel", "lo"))
+
+	resp := post(t, p.URL(2))
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", resp.Header.Get("Content-Type"))
+	}
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	if len(events) != 4 || events[3] != "[DONE]" || !strings.Contains(events[0], `"Hel"`) {
+		t.Errorf("Expected three chunks and [DONE], got %q", events)
+	}
+}
+
+func TestProxyBrokenStream(t *testing.T) {
+	p := New(t)
+	broken := Stream(`{"choices":[{"delta":{"content":"Hel"}}]}`)
+	broken.BodyErr = errors.New("connection reset")
+	p.Upstream.Script(broken)
+
+	resp := post(t, p.URL(1))
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"Hel"`) || strings.Contains(string(body), "[DONE]") {
+		t.Errorf("Expected the stream to end without [DONE], got %s", body)
+	}
+	if p.Upstream.Pending() != 0 {
+		t.Errorf("Expected the script used up, %d responses left", p.Upstream.Pending())
+	}
+}
@@ -0,0 +1,112 @@
+package proxytest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/mule-ai/proxy/internal/mockopenai"
+)
+
+// Response is one scripted upstream response: a body or a stream of
+// server-sent events, optionally delayed, paced or broken midway
+type Response = mockopenai.Response
+
+// JSON returns a 200 response with a JSON body
+func JSON(body string) Response {
+	return mockopenai.JSON(body)
+}
+
+// Error returns an OpenAI-format error response
+func Error(status int, message string) Response {
+	return mockopenai.Error(status, message)
+}
+
+// Stream returns a streaming response sending chunks as server-sent events
+func Stream(chunks ...string) Response {
+	return mockopenai.Stream(chunks...)
+}
+
+// ChatCompletion returns a chat completion answering with content and
+// reporting token usage
+func ChatCompletion(content string, promptTokens, completionTokens int) Response {
+	return mockopenai.ChatCompletion(content, promptTokens, completionTokens)
+}
+
+// ChatStream returns a streaming chat completion sending each delta as a
+// chunk, with a final chunk reporting token usage
+func ChatStream(promptTokens, completionTokens int, deltas ...string) Response {
+	return mockopenai.ChatStream(promptTokens, completionTokens, deltas...)
+}
+
+// Request is a request the proxy sent upstream
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Upstream is a fake upstream answering with scripted responses. Responses
+// added with Script are used once each, in order; once they run out, every
+// request gets the fallback response.
+type Upstream struct {
+	mu       sync.Mutex
+	script   []Response
+	fallback Response
+	requests []Request
+}
+
+// NewUpstream creates an upstream answering every request with an empty chat completion
+func NewUpstream() *Upstream {
+	return &Upstream{fallback: ChatCompletion("", 0, 0)}
+}
+
+// Script queues responses for the next requests, in order
+func (u *Upstream) Script(responses ...Response) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.script = append(u.script, responses...)
+}
+
+// SetFallback sets the response used once scripted responses run out
+func (u *Upstream) SetFallback(resp Response) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fallback = resp
+}
+
+// Requests returns the requests received so far
+func (u *Upstream) Requests() []Request {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]Request(nil), u.requests...)
+}
+
+// Pending returns how many scripted responses haven't been used yet
+func (u *Upstream) Pending() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.script)
+}
+
+// ForwardRequest implements proxy.OpenAIClient
+func (u *Upstream) ForwardRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, err
+		}
+	}
+
+	u.mu.Lock()
+	u.requests = append(u.requests, Request{Method: method, Path: path, Body: data})
+	resp := u.fallback
+	if len(u.script) > 0 {
+		resp, u.script = u.script[0], u.script[1:]
+	}
+	u.mu.Unlock()
+
+	return mockopenai.Respond(ctx, resp)
+}
@@ -0,0 +1,233 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Period is how often quotas reset
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Weekly  Period = "weekly" // Weeks start on Monday
+	Monthly Period = "monthly"
+)
+
+// ParsePeriod validates a period name
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case Daily, Weekly, Monthly:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown quota period %q", s)
+}
+
+// usage is a key's consumption in the current period
+type usage struct {
+	used   int64
+	carry  int64 // Unused budget rolled over from earlier periods
+	budget int64 // Budget granted by the key's authorizer, 0 for the configured one
+}
+
+// Manager enforces per-key token budgets that reset at the start of every
+// period in a configured timezone. With rollover, unused budget carries into
+// the next period, up to maxRollover.
+type Manager struct {
+	period      Period
+	loc         *time.Location
+	budget      int64
+	keyBudgets  map[string]int64
+	rollover    bool
+	maxRollover int64
+	mu          sync.Mutex
+	keys        map[string]*usage
+	periodStart time.Time
+	now         func() time.Time
+}
+
+// NewManager creates a quota manager. A budget of 0 means unlimited;
+// keyBudgets overrides budget for individual key IDs. maxRollover caps the
+// carried budget, defaulting to one period's budget when 0.
+func NewManager(period Period, loc *time.Location, budget int64, keyBudgets map[string]int64, rollover bool, maxRollover int64) *Manager {
+	m := &Manager{
+		period:      period,
+		loc:         loc,
+		budget:      budget,
+		keyBudgets:  keyBudgets,
+		rollover:    rollover,
+		maxRollover: maxRollover,
+		keys:        make(map[string]*usage),
+		now:         time.Now,
+	}
+	m.periodStart = m.start(m.now())
+
+	// Track keys with their own budget from the start so they roll over even when idle
+	for keyID := range keyBudgets {
+		m.keys[keyID] = &usage{}
+	}
+	return m
+}
+
+// Decision is the outcome of a quota check
+type Decision struct {
+	Allowed    bool
+	Limit      int64         // The key's budget this period including rollover (0 if unlimited)
+	Remaining  int64         // Tokens left this period
+	RetryAfter time.Duration // Time until the next reset when not allowed
+}
+
+// start returns the beginning of the period containing t
+func (m *Manager) start(t time.Time) time.Time {
+	t = t.In(m.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, m.loc)
+	switch m.period {
+	case Weekly:
+		offset := (int(day.Weekday()) + 6) % 7 // Days since Monday
+		return day.AddDate(0, 0, -offset)
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, m.loc)
+	default:
+		return day
+	}
+}
+
+// next returns the beginning of the period after the one starting at start
+func (m *Manager) next(start time.Time) time.Time {
+	switch m.period {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Monthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// budgetFor returns the per-period budget for a key ID; callers hold mu
+func (m *Manager) budgetFor(keyID string) int64 {
+	if u := m.keys[keyID]; u != nil && u.budget > 0 {
+		return u.budget
+	}
+	if budget, ok := m.keyBudgets[keyID]; ok {
+		return budget
+	}
+	return m.budget
+}
+
+// rolloverCap returns the most budget a key may carry between periods
+func (m *Manager) rolloverCap(budget int64) int64 {
+	if m.maxRollover > 0 {
+		return m.maxRollover
+	}
+	return budget
+}
+
+// resetLocked starts every period that has begun since the last reset
+func (m *Manager) resetLocked(now time.Time) {
+	for !now.Before(m.next(m.periodStart)) {
+		for keyID, u := range m.keys {
+			if m.rollover {
+				budget := m.budgetFor(keyID)
+				u.carry = min(max(budget+u.carry-u.used, 0), m.rolloverCap(budget))
+			} else {
+				u.carry = 0
+			}
+			u.used = 0
+		}
+		m.periodStart = m.next(m.periodStart)
+	}
+}
+
+// Allow reports whether a key has budget left this period
+func (m *Manager) Allow(keyID string) Decision {
+	return m.AllowBudget(keyID, 0)
+}
+
+// AllowBudget is Allow with budget in place of the key's configured budget,
+// e.g. one its authorizer grants it; 0 keeps the configured budget. The
+// key keeps the budget for its usage and rollover until it is given another.
+func (m *Manager) AllowBudget(keyID string, budget int64) Decision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.resetLocked(now)
+	if budget > 0 {
+		u := m.keys[keyID]
+		if u == nil {
+			u = &usage{}
+			m.keys[keyID] = u
+		}
+		u.budget = budget
+	}
+
+	budget = m.budgetFor(keyID)
+	if budget <= 0 {
+		return Decision{Allowed: true}
+	}
+
+	u := m.keys[keyID]
+	if u == nil {
+		u = &usage{}
+	}
+
+	limit := budget + u.carry
+	decision := Decision{
+		Allowed:   u.used < limit,
+		Limit:     limit,
+		Remaining: max(limit-u.used, 0),
+	}
+	if !decision.Allowed {
+		decision.RetryAfter = m.next(m.periodStart).Sub(now)
+	}
+	return decision
+}
+
+// Consume charges tokens to a key's budget for the current period
+func (m *Manager) Consume(keyID string, tokens int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resetLocked(m.now())
+	if m.budgetFor(keyID) <= 0 {
+		return
+	}
+
+	u := m.keys[keyID]
+	if u == nil {
+		u = &usage{}
+		m.keys[keyID] = u
+	}
+	u.used += tokens
+}
+
+// NextReset returns when the current period ends
+func (m *Manager) NextReset() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resetLocked(m.now())
+	return m.next(m.periodStart)
+}
+
+// Run resets quotas at each period boundary until ctx is cancelled. Quotas
+// also reset as they are checked, so Run only needs to run on one replica.
+func (m *Manager) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Until(m.NextReset()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		m.mu.Lock()
+		m.resetLocked(m.now())
+		start := m.periodStart
+		m.mu.Unlock()
+		fmt.Printf("Quota period started at %s\n", start.Format(time.RFC3339))
+	}
+}
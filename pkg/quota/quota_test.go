@@ -0,0 +1,134 @@
+package quota
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestManager creates a manager whose clock is controlled by the returned pointer
+func newTestManager(period Period, loc *time.Location, start time.Time, rollover bool) (*Manager, *time.Time) {
+	now := start
+	m := NewManager(period, loc, 100, map[string]int64{"vip": 1000}, rollover, 0)
+	m.now = func() time.Time { return now }
+	m.periodStart = m.start(now)
+	return m, &now
+}
+
+func TestPeriodBoundaries(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Timezone data unavailable: %v", err)
+	}
+
+	// Wednesday 2026-10-14 23:30 in New York is already Thursday in UTC
+	now := time.Date(2026, 10, 14, 23, 30, 0, 0, ny)
+
+	tests := []struct {
+		period Period
+		start  time.Time
+		next   time.Time
+	}{
+		{Daily, time.Date(2026, 10, 14, 0, 0, 0, 0, ny), time.Date(2026, 10, 15, 0, 0, 0, 0, ny)},
+		{Weekly, time.Date(2026, 10, 12, 0, 0, 0, 0, ny), time.Date(2026, 10, 19, 0, 0, 0, 0, ny)},
+		{Monthly, time.Date(2026, 10, 1, 0, 0, 0, 0, ny), time.Date(2026, 11, 1, 0, 0, 0, 0, ny)},
+	}
+
+	for _, tt := range tests {
+		m, _ := newTestManager(tt.period, ny, now, false)
+		if !m.periodStart.Equal(tt.start) {
+			t.Errorf("%s: expected period to start %v, got %v", tt.period, tt.start, m.periodStart)
+		}
+		if next := m.NextReset(); !next.Equal(tt.next) {
+			t.Errorf("%s: expected next reset %v, got %v", tt.period, tt.next, next)
+		}
+	}
+}
+
+func TestAllowAndReset(t *testing.T) {
+	m, now := newTestManager(Daily, time.UTC, time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), false)
+
+	m.Consume("key-a", 60)
+	if d := m.Allow("key-a"); !d.Allowed || d.Remaining != 40 {
+		t.Errorf("Expected 40 tokens remaining, got %+v", d)
+	}
+
+	m.Consume("key-a", 50)
+	d := m.Allow("key-a")
+	if d.Allowed || d.RetryAfter != 12*time.Hour {
+		t.Errorf("Expected key to be over quota until midnight, got %+v", d)
+	}
+
+	// Other keys have their own budgets
+	if d := m.Allow("vip"); !d.Allowed || d.Limit != 1000 {
+		t.Errorf("Expected vip budget of 1000, got %+v", d)
+	}
+
+	// The budget resets at the start of the next day
+	*now = time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)
+	if d := m.Allow("key-a"); !d.Allowed || d.Remaining != 100 {
+		t.Errorf("Expected a fresh budget after reset, got %+v", d)
+	}
+}
+
+func TestRollover(t *testing.T) {
+	m, now := newTestManager(Daily, time.UTC, time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), true)
+
+	m.Consume("key-a", 30)
+	*now = time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC)
+	if d := m.Allow("key-a"); d.Limit != 170 {
+		t.Errorf("Expected 70 unused tokens to roll over, got limit %d", d.Limit)
+	}
+
+	// Carried budget is capped at one period's budget by default
+	*now = time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC)
+	if d := m.Allow("key-a"); d.Limit != 200 {
+		t.Errorf("Expected rollover capped at 100, got limit %d", d.Limit)
+	}
+
+	// Keys with their own budget roll over even if they were never used
+	if d := m.Allow("vip"); d.Limit != 2000 {
+		t.Errorf("Expected idle vip budget to roll over, got limit %d", d.Limit)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	m := NewManager(Daily, time.UTC, 100, nil, false, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Expected Run to return after cancellation")
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	if p, err := ParsePeriod("weekly"); err != nil || p != Weekly {
+		t.Errorf("Expected weekly, got %v (err: %v)", p, err)
+	}
+	if _, err := ParsePeriod("hourly"); err == nil {
+		t.Error("Expected error for an unknown period")
+	}
+}
+
+func TestAllowBudget(t *testing.T) {
+	m, _ := newTestManager(Daily, time.UTC, time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), false)
+
+	m.Consume("key-a", 150)
+	if d := m.AllowBudget("key-a", 500); !d.Allowed || d.Limit != 500 || d.Remaining != 350 {
+		t.Errorf("Expected the granted budget of 500 applied, got %+v", d)
+	}
+
+	// The key keeps its granted budget when checked without one
+	if d := m.Allow("key-a"); !d.Allowed || d.Limit != 500 {
+		t.Errorf("Expected the granted budget kept, got %+v", d)
+	}
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Store holds rate limit counters, either in-process or shared between replicas
+type Store interface {
+	// Incr adds n to the counter for key and returns the new total. The
+	// counter expires after ttl so old windows don't accumulate.
+	Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+}
+
+// Limiter enforces fixed-window request limits per client key and across the whole organization
+type Limiter struct {
+	// Burst lets a key go over its limit by up to this many requests in a
+	// window, out of what it left unused in the previous one, so short
+	// interactive bursts aren't throttled while sustained traffic still
+	// converges on the limit. Set it before the limiter is used.
+	Burst     int64
+	store     Store
+	window    time.Duration
+	mu        sync.RWMutex
+	perKey    int64
+	org       int64
+	keyLimits map[string]int64
+	now       func() time.Time
+}
+
+// NewLimiter creates a limiter. A limit of 0 means unlimited; keyLimits
+// overrides perKey for individual key IDs.
+func NewLimiter(store Store, window time.Duration, perKey, org int64, keyLimits map[string]int64) *Limiter {
+	return &Limiter{
+		store:     store,
+		window:    window,
+		perKey:    perKey,
+		org:       org,
+		keyLimits: keyLimits,
+		now:       time.Now,
+	}
+}
+
+// Decision is the outcome of a rate limit check
+type Decision struct {
+	Allowed    bool
+	Limit      int64         // The limit that applied (0 if unlimited)
+	Remaining  int64         // Requests left in the current window
+	RetryAfter time.Duration // Time until the window resets when not allowed
+}
+
+// SetLimits changes the default per-key limit and the org limit, e.g. for a
+// scheduled window. Per-key overrides keep applying.
+func (l *Limiter) SetLimits(perKey, org int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perKey, l.org = perKey, org
+}
+
+// Limits returns the default per-key limit and the org limit in effect
+func (l *Limiter) Limits() (perKey, org int64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.perKey, l.org
+}
+
+// limitFor returns the per-key limit for a key ID
+func (l *Limiter) limitFor(keyID string) int64 {
+	if limit, ok := l.keyLimits[keyID]; ok {
+		return limit
+	}
+	perKey, _ := l.Limits()
+	return perKey
+}
+
+// Allow counts a request for keyID and reports whether it fits within both the key and org limits
+func (l *Limiter) Allow(ctx context.Context, keyID string) (Decision, error) {
+	return l.AllowLimit(ctx, keyID, 0)
+}
+
+// AllowLimit is Allow with limit in place of the key's configured limit,
+// e.g. one its authorizer grants it; 0 keeps the configured limit
+func (l *Limiter) AllowLimit(ctx context.Context, keyID string, limit int64) (Decision, error) {
+	if limit <= 0 {
+		limit = l.limitFor(keyID)
+	}
+	now := l.now()
+	windowStart := now.Truncate(l.window)
+	retryAfter := windowStart.Add(l.window).Sub(now)
+	suffix := fmt.Sprintf("%d", windowStart.Unix())
+
+	decision := Decision{Allowed: true}
+
+	if limit > 0 {
+		allowance, ttl := limit, l.window
+		if l.Burst > 0 {
+			// Keep counters for the next window to see what was left unused
+			ttl = 2 * l.window
+			previous := fmt.Sprintf("%d", windowStart.Add(-l.window).Unix())
+			used, err := l.store.Incr(ctx, "key:"+keyID+":"+previous, 0, ttl)
+			if err != nil {
+				return decision, err
+			}
+			allowance += min(l.Burst, max(limit-used, 0))
+		}
+
+		count, err := l.store.Incr(ctx, "key:"+keyID+":"+suffix, 1, ttl)
+		if err != nil {
+			return decision, err
+		}
+		decision.Limit = limit
+		decision.Remaining = max(allowance-count, 0)
+		if count > allowance {
+			decision.Allowed = false
+			decision.RetryAfter = retryAfter
+			return decision, nil
+		}
+	}
+
+	if _, org := l.Limits(); org > 0 {
+		count, err := l.store.Incr(ctx, "org:"+suffix, 1, l.window)
+		if err != nil {
+			return decision, err
+		}
+		if count > org {
+			decision.Allowed = false
+			decision.Limit = org
+			decision.Remaining = 0
+			decision.RetryAfter = retryAfter
+		}
+	}
+
+	return decision, nil
+}
@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestLimiterPerKey(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), time.Minute, 2, 0, map[string]int64{"key-vip": 3})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.Allow(ctx, "key-a")
+		if err != nil || !decision.Allowed {
+			t.Fatalf("Expected request %d to be allowed, got %+v (err: %v)", i, decision, err)
+		}
+	}
+
+	decision, _ := limiter.Allow(ctx, "key-a")
+	if decision.Allowed {
+		t.Error("Expected third request to be rejected")
+	}
+
+	if decision.RetryAfter <= 0 || decision.RetryAfter > time.Minute {
+		t.Errorf("Expected RetryAfter within the window, got %v", decision.RetryAfter)
+	}
+
+	// Other keys have their own budget, and overrides apply
+	for i := 0; i < 3; i++ {
+		if decision, _ := limiter.Allow(ctx, "key-vip"); !decision.Allowed {
+			t.Errorf("Expected request %d for key-vip to be allowed", i)
+		}
+	}
+}
+
+func TestLimiterOrg(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), time.Minute, 0, 3, nil)
+	ctx := context.Background()
+
+	for _, key := range []string{"key-a", "key-b", "key-c"} {
+		if decision, _ := limiter.Allow(ctx, key); !decision.Allowed {
+			t.Errorf("Expected request for %s to be allowed", key)
+		}
+	}
+
+	decision, _ := limiter.Allow(ctx, "key-d")
+	if decision.Allowed {
+		t.Error("Expected org limit to reject the fourth request")
+	}
+
+	if decision.Limit != 3 {
+		t.Errorf("Expected org limit to be reported, got %d", decision.Limit)
+	}
+}
+
+func TestLimiterWindowReset(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), time.Minute, 1, 0, nil)
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	limiter.Allow(ctx, "key-a")
+	if decision, _ := limiter.Allow(ctx, "key-a"); decision.Allowed {
+		t.Error("Expected second request in the window to be rejected")
+	}
+
+	// The next window starts with a fresh budget
+	now = now.Add(time.Minute)
+	if decision, _ := limiter.Allow(ctx, "key-a"); !decision.Allowed {
+		t.Error("Expected request in the next window to be allowed")
+	}
+}
+
+func TestRedisStoreSharedAcrossReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	// Two replicas pointing at the same Redis share one budget
+	var limiters []*Limiter
+	for i := 0; i < 2; i++ {
+		store, err := NewRedisStore("redis://"+server.Addr(), "test")
+		if err != nil {
+			t.Fatalf("Failed to create Redis store: %v", err)
+		}
+		defer store.Close()
+		limiters = append(limiters, NewLimiter(store, time.Minute, 3, 0, nil))
+	}
+
+	ctx := context.Background()
+	allowed := 0
+	for i := 0; i < 6; i++ {
+		decision, err := limiters[i%2].Allow(ctx, "key-a")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if decision.Allowed {
+			allowed++
+		}
+	}
+
+	if allowed != 3 {
+		t.Errorf("Expected 3 requests allowed across replicas, got %d", allowed)
+	}
+
+	// Counters expire with the window
+	for _, key := range server.Keys() {
+		if server.TTL(key) <= 0 {
+			t.Errorf("Expected key %s to have a TTL", key)
+		}
+	}
+}
+
+func TestLimiterSetLimits(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), time.Minute, 1, 0, map[string]int64{"key-vip": 5})
+	ctx := context.Background()
+
+	limiter.Allow(ctx, "key-a")
+	if decision, _ := limiter.Allow(ctx, "key-a"); decision.Allowed {
+		t.Fatal("Expected the second request to be rejected")
+	}
+
+	// Raising the limit applies to the current window, and overrides keep applying
+	limiter.SetLimits(3, 1)
+	if decision, _ := limiter.Allow(ctx, "key-a"); !decision.Allowed || decision.Limit != 3 {
+		t.Errorf("Expected the raised limit to apply, got %+v", decision)
+	}
+	if decision, _ := limiter.Allow(ctx, "key-vip"); decision.Allowed {
+		t.Errorf("Expected the new org limit to apply, got %+v", decision)
+	}
+	if perKey, org := limiter.Limits(); perKey != 3 || org != 1 {
+		t.Errorf("Expected limits 3 and 1, got %d and %d", perKey, org)
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), time.Minute, 3, 0, nil)
+	limiter.Burst = 2
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	allowed := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if decision, _ := limiter.Allow(ctx, "key-a"); decision.Allowed {
+				count++
+			}
+		}
+		return count
+	}
+
+	// An idle key can burst past its limit
+	if n := allowed(6); n != 5 {
+		t.Errorf("Expected 5 requests allowed in a burst, got %d", n)
+	}
+
+	// Having used its allowance, the next window is held to the limit
+	now = now.Add(time.Minute)
+	if n := allowed(4); n != 3 {
+		t.Errorf("Expected 3 requests allowed after a burst, got %d", n)
+	}
+
+	// Unused allowance builds up the burst again, up to its cap
+	now = now.Add(time.Minute)
+	allowed(1)
+	now = now.Add(time.Minute)
+	if n := allowed(6); n != 5 {
+		t.Errorf("Expected 5 requests allowed after a quiet window, got %d", n)
+	}
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in-process; limits are per replica
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[string]*memoryCounter
+}
+
+// memoryCounter is a single counter with its expiry
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+// NewMemoryStore creates an in-process counter store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*memoryCounter),
+	}
+}
+
+// Incr implements Store
+func (s *MemoryStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	counter, ok := s.counters[key]
+	if !ok || now.After(counter.expires) {
+		// Drop expired counters while we hold the lock
+		for k, c := range s.counters {
+			if now.After(c.expires) {
+				delete(s.counters, k)
+			}
+		}
+		counter = &memoryCounter{expires: now.Add(ttl)}
+		s.counters[key] = counter
+	}
+
+	counter.value += n
+	return counter.value, nil
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps counters in Redis so every replica enforces the same limits
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to Redis using a redis:// URL
+func NewRedisStore(redisURL, prefix string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+
+	return &RedisStore{
+		client: redis.NewClient(opts),
+		prefix: prefix,
+	}, nil
+}
+
+// Incr implements Store
+func (s *RedisStore) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	fullKey := s.prefix + ":ratelimit:" + key
+
+	pipe := s.client.TxPipeline()
+	incr := pipe.IncrBy(ctx, fullKey, n)
+	// NX keeps the window anchored to the first increment
+	pipe.ExpireNX(ctx, fullKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// Close releases the Redis connection
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
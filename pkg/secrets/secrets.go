@@ -0,0 +1,150 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider fetches secrets from a secrets manager
+type Provider interface {
+	// Fetch returns the current value of the secret a reference points at
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// splitRef splits a reference into the secret's path and the field wanted
+// from it, empty when the whole secret is wanted
+func splitRef(ref string) (string, string) {
+	path, field, _ := strings.Cut(ref, "#")
+	return path, field
+}
+
+// field picks a field from a secret holding several
+func field(values map[string]interface{}, ref, name string) (string, error) {
+	v, ok := values[name]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %q", ref, name)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s field %q is not a string", ref, name)
+	}
+	return s, nil
+}
+
+// jsonField picks a field from a secret stored as a JSON object
+func jsonField(secret, ref, name string) (string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &values); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object: %w", ref, err)
+	}
+	return field(values, ref, name)
+}
+
+// Store caches secrets from a provider and refreshes them, so rotated
+// secrets are picked up without a restart
+type Store struct {
+	provider Provider
+	refresh  time.Duration
+	timeout  time.Duration // Limit for each fetch
+
+	mu       sync.RWMutex
+	values   map[string]string
+	watchers map[string][]func(string)
+}
+
+// NewStore creates a store that checks for rotated secrets every refresh
+func NewStore(provider Provider, refresh time.Duration) *Store {
+	return &Store{
+		provider: provider,
+		refresh:  refresh,
+		timeout:  30 * time.Second,
+		values:   make(map[string]string),
+		watchers: make(map[string][]func(string)),
+	}
+}
+
+// Load fetches a secret and keeps it refreshed from then on. It fails if
+// the secret can't be fetched, so missing credentials stop startup.
+func (s *Store) Load(ctx context.Context, ref string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	value, err := s.provider.Fetch(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("error fetching secret %s: %w", ref, err)
+	}
+
+	s.mu.Lock()
+	s.values[ref] = value
+	s.mu.Unlock()
+	return value, nil
+}
+
+// Get returns the cached value of a loaded secret
+func (s *Store) Get(ref string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[ref]
+}
+
+// OnChange calls fn with the new value whenever a secret is rotated
+func (s *Store) OnChange(ref string, fn func(string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers[ref] = append(s.watchers[ref], fn)
+}
+
+// Refresh refetches every loaded secret and notifies watchers of the ones
+// that changed. Secrets that can't be fetched keep their cached value, so
+// a secrets manager outage doesn't take the proxy down with it.
+func (s *Store) Refresh(ctx context.Context) {
+	s.mu.RLock()
+	refs := make([]string, 0, len(s.values))
+	for ref := range s.values {
+		refs = append(refs, ref)
+	}
+	s.mu.RUnlock()
+
+	for _, ref := range refs {
+		fetchCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		value, err := s.provider.Fetch(fetchCtx, ref)
+		cancel()
+		if err != nil {
+			log.Printf("Error refreshing secret %s, keeping cached value: %v", ref, err)
+			continue
+		}
+
+		s.mu.Lock()
+		changed := s.values[ref] != value
+		s.values[ref] = value
+		watchers := s.watchers[ref]
+		s.mu.Unlock()
+
+		if changed {
+			log.Printf("Secret %s was rotated", ref)
+			for _, fn := range watchers {
+				fn(value)
+			}
+		}
+	}
+}
+
+// Run refreshes secrets until ctx is cancelled
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Refresh(ctx)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/proxy":
+			w.Write([]byte(`{"data":{"data":{"openai_api_key":"sk-v2","value":"default"},"metadata":{"version":3}}}`))
+		case "/v1/kv/proxy":
+			w.Write([]byte(`{"data":{"influx_token":"influx-v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewVaultProvider(server.URL, "root", "", "")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "secret/data/proxy#openai_api_key", want: "sk-v2"},
+		{ref: "secret/data/proxy", want: "default"},
+		{ref: "kv/proxy#influx_token", want: "influx-v1"},
+		{ref: "secret/data/proxy#missing", wantErr: true},
+		{ref: "secret/data/other#value", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := provider.Fetch(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	// Tokens from a file are re-read, so renewals are picked up
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("stale\n"), 0o600)
+	provider, _ = NewVaultProvider(server.URL, "", tokenFile, "")
+	if _, err := provider.Fetch(context.Background(), "kv/proxy#influx_token"); err == nil {
+		t.Error("Expected error with stale token")
+	}
+	os.WriteFile(tokenFile, []byte("root\n"), 0o600)
+	if got, err := provider.Fetch(context.Background(), "kv/proxy#influx_token"); err != nil || got != "influx-v1" {
+		t.Errorf("Expected influx-v1 after token renewal, got %q (%v)", got, err)
+	}
+}
+
+func TestAWSProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "session")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261016/us-east-1/secretsmanager/aws4_request, ") ||
+			!strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, ") {
+			t.Errorf("Unexpected Authorization header: %s", auth)
+		}
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || r.Header.Get("X-Amz-Security-Token") != "session" {
+			t.Errorf("Unexpected headers: %v", r.Header)
+		}
+
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		switch body.SecretId {
+		case "proxy/openai":
+			w.Write([]byte(`{"Name":"proxy/openai","SecretString":"sk-aws"}`))
+		case "proxy/all":
+			w.Write([]byte(`{"Name":"proxy/all","SecretString":"{\"influx_token\":\"influx-aws\"}"}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException"}`))
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewAWSProvider("us-east-1", server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	provider.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+
+	if got, err := provider.Fetch(context.Background(), "proxy/openai"); err != nil || got != "sk-aws" {
+		t.Errorf("Expected sk-aws, got %q (%v)", got, err)
+	}
+	if got, err := provider.Fetch(context.Background(), "proxy/all#influx_token"); err != nil || got != "influx-aws" {
+		t.Errorf("Expected influx-aws, got %q (%v)", got, err)
+	}
+	if _, err := provider.Fetch(context.Background(), "proxy/missing"); err == nil {
+		t.Error("Expected error for missing secret")
+	}
+}
+
+// fakeProvider serves secrets from a map
+type fakeProvider struct {
+	values map[string]string
+	err    error
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	return p.values[ref], nil
+}
+
+func TestStoreRefresh(t *testing.T) {
+	provider := &fakeProvider{values: map[string]string{"key": "v1"}}
+	store := NewStore(provider, time.Minute)
+
+	if got, err := store.Load(context.Background(), "key"); err != nil || got != "v1" {
+		t.Fatalf("Expected v1, got %q (%v)", got, err)
+	}
+
+	var rotated []string
+	store.OnChange("key", func(v string) { rotated = append(rotated, v) })
+
+	store.Refresh(context.Background())
+	if len(rotated) != 0 {
+		t.Errorf("Expected no rotation for unchanged secret, got %v", rotated)
+	}
+
+	provider.values["key"] = "v2"
+	store.Refresh(context.Background())
+	if store.Get("key") != "v2" || len(rotated) != 1 || rotated[0] != "v2" {
+		t.Errorf("Expected rotation to v2, got %q and %v", store.Get("key"), rotated)
+	}
+
+	// An outage keeps the cached value
+	provider.err = errors.New("unavailable")
+	store.Refresh(context.Background())
+	if store.Get("key") != "v2" {
+		t.Errorf("Expected cached v2 during outage, got %q", store.Get("key"))
+	}
+
+	if _, err := store.Load(context.Background(), "other"); err == nil {
+		t.Error("Expected Load to fail while the provider is unavailable")
+	}
+}
@@ -0,0 +1,110 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from HashiCorp Vault's KV secrets engine.
+// References are the API path of the secret, e.g. "secret/data/proxy" for
+// KV version 2, with "#field" picking the field (default "value").
+type VaultProvider struct {
+	Address    string
+	Token      string
+	TokenFile  string // Read on every fetch, so tokens renewed by Vault Agent are picked up
+	Namespace  string
+	HTTPClient *http.Client
+}
+
+// NewVaultProvider creates a Vault provider, defaulting the address and
+// token to the standard VAULT_ADDR and VAULT_TOKEN variables
+func NewVaultProvider(address, token, tokenFile, namespace string) (*VaultProvider, error) {
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" && tokenFile == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" {
+		return nil, errors.New("vault address is not set")
+	}
+	if token == "" && tokenFile == "" {
+		return nil, errors.New("vault token is not set")
+	}
+
+	return &VaultProvider{
+		Address:    strings.TrimSuffix(address, "/"),
+		Token:      token,
+		TokenFile:  tokenFile,
+		Namespace:  namespace,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// token returns the token to authenticate with
+func (p *VaultProvider) token() (string, error) {
+	if p.TokenFile == "" {
+		return p.Token, nil
+	}
+	data, err := os.ReadFile(p.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("error reading vault token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Fetch implements Provider
+func (p *VaultProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	path, name := splitRef(ref)
+	if name == "" {
+		name = "value"
+	}
+
+	token, err := p.token()
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Address+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("vault returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error decoding vault response: %w", err)
+	}
+
+	// KV version 2 nests the secret's fields under data.data, next to its metadata
+	values := secret.Data
+	if inner, ok := values["data"].(map[string]interface{}); ok {
+		if _, ok := values["metadata"]; ok {
+			values = inner
+		}
+	}
+	return field(values, ref, name)
+}
@@ -0,0 +1,74 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// defaultEncoding is used for models tiktoken doesn't know about
+const defaultEncoding = "cl100k_base"
+
+var (
+	encodings = make(map[string]*tiktoken.Tiktoken)
+	mu        sync.Mutex
+)
+
+func init() {
+	// Use the embedded BPE ranks so tokenizing never reaches the network
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// EncodingForModel returns the name of the encoding a model uses
+func EncodingForModel(model string) string {
+	if name, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return name
+	}
+
+	// Dated snapshots like gpt-4o-2024-05-13 match their family's prefix
+	best, encodingName := "", defaultEncoding
+	for prefix, name := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, encodingName = prefix, name
+		}
+	}
+	return encodingName
+}
+
+// encoding returns a cached encoder by name
+func encoding(name string) (*tiktoken.Tiktoken, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if enc, ok := encodings[name]; ok {
+		return enc, nil
+	}
+
+	enc, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading encoding %s: %w", name, err)
+	}
+	encodings[name] = enc
+	return enc, nil
+}
+
+// Encode returns the token IDs of text for a model, treating special tokens as plain text
+func Encode(model, text string) ([]int, error) {
+	enc, err := encoding(EncodingForModel(model))
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeOrdinary(text), nil
+}
+
+// Count returns the number of tokens in text for a model
+func Count(model, text string) (int, error) {
+	tokens, err := Encode(model, text)
+	if err != nil {
+		return 0, err
+	}
+	return len(tokens), nil
+}
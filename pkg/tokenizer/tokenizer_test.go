@@ -0,0 +1,37 @@
+package tokenizer
+
+import "testing"
+
+func TestEncodingForModel(t *testing.T) {
+	tests := map[string]string{
+		"gpt-4":                  "cl100k_base",
+		"gpt-3.5-turbo":          "cl100k_base",
+		"gpt-4o":                 "o200k_base",
+		"gpt-4o-2024-05-13":      "o200k_base",
+		"some-local-llama":       "cl100k_base",
+		"text-embedding-3-small": "cl100k_base",
+	}
+
+	for model, expected := range tests {
+		if got := EncodingForModel(model); got != expected {
+			t.Errorf("EncodingForModel(%q): expected %s, got %s", model, expected, got)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tokens, err := Encode("gpt-4", "hello world")
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// cl100k_base: "hello" = 15339, " world" = 1917
+	if len(tokens) != 2 || tokens[0] != 15339 || tokens[1] != 1917 {
+		t.Errorf("Expected [15339 1917], got %v", tokens)
+	}
+
+	count, err := Count("gpt-4", "")
+	if err != nil || count != 0 {
+		t.Errorf("Expected 0 tokens for empty text, got %d (err: %v)", count, err)
+	}
+}
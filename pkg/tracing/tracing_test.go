@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mule-ai/proxy/pkg/config"
+)
+
+func TestSetup(t *testing.T) {
+	// Without an endpoint tracing stays off
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	if err != nil || shutdown(context.Background()) != nil {
+		t.Fatalf("Expected tracing left off without an endpoint, got %v", err)
+	}
+
+	for name, cfg := range map[string]config.TracingConfig{
+		"unknown protocol": {Endpoint: "localhost:4317", Protocol: "thrift", SampleRatio: 1},
+		"sample ratio":     {Endpoint: "localhost:4317", Protocol: ProtocolGRPC, SampleRatio: 1.5},
+	} {
+		if _, err := Setup(context.Background(), cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	// Exporters connect lazily, so no collector needs to be listening
+	for _, cfg := range []config.TracingConfig{
+		{Endpoint: "localhost:4317", Protocol: ProtocolGRPC, Insecure: true, SampleRatio: 1, ServiceName: "proxy-test"},
+		{Endpoint: "http://localhost:4318/v1/traces", Protocol: ProtocolHTTP, SampleRatio: 0.1, ServiceName: "proxy-test",
+			Headers: map[string]string{"Authorization": "Bearer token"}, ResourceAttributes: map[string]string{"deployment.environment": "test"}},
+	} {
+		shutdown, err := Setup(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", cfg.Protocol, err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		shutdown(ctx)
+	}
+}
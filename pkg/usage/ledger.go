@@ -0,0 +1,191 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/pricing"
+)
+
+// monthFormat is the layout of billing periods, e.g. "2026-10"
+const monthFormat = "2006-01"
+
+// Record is the usage of one completed request
+type Record struct {
+	Time         time.Time
+	KeyID        string
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	Preemptions  int // Attempts aborted by preemption before the one that completed
+}
+
+// Entry is the aggregated usage of one key and model in a billing month
+type Entry struct {
+	Month             string  `json:"month"`
+	KeyID             string  `json:"key_id"`
+	Model             string  `json:"model"`
+	Requests          int64   `json:"requests"`
+	Attempts          int64   `json:"attempts"`
+	PreemptedAttempts int64   `json:"preempted_attempts"`
+	InputTokens       int64   `json:"input_tokens"`
+	BilledInputTokens int64   `json:"billed_input_tokens"`
+	OutputTokens      int64   `json:"output_tokens"`
+	Cost              float64 `json:"cost"`
+}
+
+// entryKey identifies an Entry
+type entryKey struct {
+	month, keyID, model string
+}
+
+// Ledger aggregates request usage per key, model and month for billing.
+//
+// Accounting rules: a request counts once however many times it was
+// preempted and retried, and its output tokens come from the attempt that
+// completed. Upstreams may bill the prompt of an aborted attempt, so with
+// BillPreemptedAttempts set each preempted attempt adds the request's input
+// tokens to BilledInputTokens; otherwise only the completed attempt is billed.
+type Ledger struct {
+	Pricing               *pricing.Table
+	BillPreemptedAttempts bool
+	mu                    sync.Mutex
+	entries               map[entryKey]*Entry
+}
+
+// NewLedger creates an empty ledger
+func NewLedger(table *pricing.Table, billPreemptedAttempts bool) *Ledger {
+	return &Ledger{
+		Pricing:               table,
+		BillPreemptedAttempts: billPreemptedAttempts,
+		entries:               make(map[entryKey]*Entry),
+	}
+}
+
+// Record adds a completed request to its month's totals. Cost is priced
+// when the request is recorded so later price changes don't rewrite history.
+func (l *Ledger) Record(r Record) {
+	billedInput := r.InputTokens
+	if l.BillPreemptedAttempts {
+		billedInput += int64(r.Preemptions) * r.InputTokens
+	}
+	cost, _ := l.Pricing.Cost(r.Model, billedInput, r.OutputTokens)
+
+	key := entryKey{r.Time.UTC().Format(monthFormat), r.KeyID, r.Model}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &Entry{Month: key.month, KeyID: key.keyID, Model: key.model}
+		l.entries[key] = e
+	}
+	e.Requests++
+	e.Attempts += int64(r.Preemptions) + 1
+	e.PreemptedAttempts += int64(r.Preemptions)
+	e.InputTokens += r.InputTokens
+	e.BilledInputTokens += billedInput
+	e.OutputTokens += r.OutputTokens
+	e.Cost += cost
+}
+
+// Report returns a month's entries sorted by key and model. An empty month returns every month.
+func (l *Ledger) Report(month string) []Entry {
+	l.mu.Lock()
+	entries := make([]Entry, 0, len(l.entries))
+	for key, e := range l.entries {
+		if month == "" || key.month == month {
+			entries = append(entries, *e)
+		}
+	}
+	l.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Month != b.Month {
+			return a.Month < b.Month
+		}
+		if a.KeyID != b.KeyID {
+			return a.KeyID < b.KeyID
+		}
+		return a.Model < b.Model
+	})
+	return entries
+}
+
+// Save writes every entry to a JSON file
+func (l *Ledger) Save(path string) error {
+	data, err := json.Marshal(l.Report(""))
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves a truncated ledger
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load restores entries saved by Save. A missing file leaves the ledger empty.
+func (l *Ledger) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("invalid usage file %s: %w", path, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range entries {
+		e := entries[i]
+		l.entries[entryKey{e.Month, e.KeyID, e.Model}] = &e
+	}
+	return nil
+}
+
+// WriteCSV writes entries as CSV with a header row
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"month", "key_id", "model", "requests", "attempts", "preempted_attempts",
+		"input_tokens", "billed_input_tokens", "output_tokens", "cost"})
+
+	for _, e := range entries {
+		cw.Write([]string{
+			e.Month,
+			e.KeyID,
+			e.Model,
+			strconv.FormatInt(e.Requests, 10),
+			strconv.FormatInt(e.Attempts, 10),
+			strconv.FormatInt(e.PreemptedAttempts, 10),
+			strconv.FormatInt(e.InputTokens, 10),
+			strconv.FormatInt(e.BilledInputTokens, 10),
+			strconv.FormatInt(e.OutputTokens, 10),
+			strconv.FormatFloat(e.Cost, 'f', 6, 64),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes entries as a JSON array
+func WriteJSON(w io.Writer, entries []Entry) error {
+	return json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,104 @@
+package usage
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mule-ai/proxy/pkg/config"
+	"github.com/mule-ai/proxy/pkg/pricing"
+)
+
+func testPricing() *pricing.Table {
+	return pricing.NewTable(map[string]config.ModelPrice{"gpt-4": {Input: 30, Output: 60}})
+}
+
+func TestLedgerRecord(t *testing.T) {
+	october := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	november := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	ledger := NewLedger(testPricing(), false)
+	ledger.Record(Record{Time: october, KeyID: "key-a", Model: "gpt-4", InputTokens: 100, OutputTokens: 50})
+	ledger.Record(Record{Time: october, KeyID: "key-a", Model: "gpt-4", InputTokens: 100, OutputTokens: 50, Preemptions: 2})
+	ledger.Record(Record{Time: october, KeyID: "key-b", Model: "gpt-4", InputTokens: 10})
+	ledger.Record(Record{Time: november, KeyID: "key-a", Model: "gpt-4", InputTokens: 10})
+
+	entries := ledger.Report("2026-10")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries for October, got %d", len(entries))
+	}
+
+	a := entries[0]
+	if a.KeyID != "key-a" || a.Requests != 2 || a.Attempts != 4 || a.PreemptedAttempts != 2 {
+		t.Errorf("Unexpected request accounting: %+v", a)
+	}
+
+	// Preempted attempts aren't billed by default
+	if a.InputTokens != 200 || a.BilledInputTokens != 200 || a.OutputTokens != 100 {
+		t.Errorf("Unexpected token accounting: %+v", a)
+	}
+
+	expected := (200*30.0 + 100*60.0) / 1e6
+	if math.Abs(a.Cost-expected) > 1e-12 {
+		t.Errorf("Expected cost %v, got %v", expected, a.Cost)
+	}
+
+	if len(ledger.Report("")) != 3 {
+		t.Errorf("Expected 3 entries across all months, got %d", len(ledger.Report("")))
+	}
+}
+
+func TestLedgerBillPreemptedAttempts(t *testing.T) {
+	ledger := NewLedger(testPricing(), true)
+	ledger.Record(Record{Time: time.Now(), KeyID: "key-a", Model: "gpt-4", InputTokens: 100, Preemptions: 2})
+
+	entries := ledger.Report("")
+	if entries[0].InputTokens != 100 || entries[0].BilledInputTokens != 300 {
+		t.Errorf("Expected each preempted attempt's prompt to be billed, got %+v", entries[0])
+	}
+}
+
+func TestLedgerSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+
+	ledger := NewLedger(testPricing(), false)
+	ledger.Record(Record{Time: time.Now(), KeyID: "key-a", Model: "gpt-4", InputTokens: 100})
+	if err := ledger.Save(path); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	restored := NewLedger(testPricing(), false)
+	if err := restored.Load(path); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	restored.Record(Record{Time: time.Now(), KeyID: "key-a", Model: "gpt-4", InputTokens: 100})
+
+	entries := restored.Report("")
+	if len(entries) != 1 || entries[0].Requests != 2 || entries[0].InputTokens != 200 {
+		t.Errorf("Expected saved usage to be added to, got %+v", entries)
+	}
+
+	// A missing file is an empty ledger, not an error
+	if err := NewLedger(nil, false).Load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected no error for a missing file, got %v", err)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []Entry{{Month: "2026-10", KeyID: "key-a", Model: "gpt-4", Requests: 2, Cost: 0.012}})
+	if err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "month,key_id,model") {
+		t.Fatalf("Expected a header and one row, got %q", buf.String())
+	}
+	if lines[1] != "2026-10,key-a,gpt-4,2,0,0,0,0,0,0.012000" {
+		t.Errorf("Unexpected CSV row: %s", lines[1])
+	}
+}